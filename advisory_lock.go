@@ -0,0 +1,82 @@
+package dban
+
+import (
+	"context"
+	"hash/fnv"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+const (
+	advisoryLockQuery    = "SELECT pg_advisory_xact_lock($1)"
+	advisoryTryLockQuery = "SELECT pg_try_advisory_xact_lock($1)"
+)
+
+// Locker runs functions under Postgres advisory locks taken on the connections of the
+// db, so that e.g. only one of the replicas of a service runs a streamer at a time
+type Locker struct {
+	db *pgdb.DB
+}
+
+// NewLocker creates a locker taking advisory locks on the connections of db
+func NewLocker(db *pgdb.DB) *Locker {
+	return &Locker{db: db}
+}
+
+// WithLock waits for the advisory lock of the name and runs fn holding it. The lock is
+// taken within a transaction and released once fn returns, even if the process dies
+// in the middle, since the transaction ends with the connection
+func (l *Locker) WithLock(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	return l.withLock(ctx, name, false, fn)
+}
+
+// TryWithLock does the same thing as WithLock, but fails with ErrLockBusy right away if
+// the lock of the name is held by somebody else
+func (l *Locker) TryWithLock(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	return l.withLock(ctx, name, true, fn)
+}
+
+func (l *Locker) withLock(ctx context.Context, name string, try bool, fn func(ctx context.Context) error) error {
+	fields := logan.F{"lock": name}
+
+	// the transaction is bound to the db in place, so it gets a db of its own
+	db := l.db.Clone()
+	err := db.Transaction(func() error {
+		if try {
+			var acquired bool
+			if err := db.GetRawContext(ctx, &acquired, advisoryTryLockQuery, advisoryLockID(name)); err != nil {
+				return errors.Wrap(classifyLockErr(ctx, err), "failed to try advisory lock")
+			}
+			if !acquired {
+				return ErrLockBusy
+			}
+		} else if err := db.ExecRawContext(ctx, advisoryLockQuery, advisoryLockID(name)); err != nil {
+			return errors.Wrap(classifyLockErr(ctx, err), "failed to take advisory lock")
+		}
+
+		return fn(ctx)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to run with advisory lock", fields)
+	}
+	return nil
+}
+
+// advisoryLockID hashes the name of a lock into the bigint key advisory locks take. It
+// is computed here rather than with hashtext, whose results may differ between Postgres
+// versions, so that replicas talking to different servers agree on it
+func advisoryLockID(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// classifyLockErr classifies err of a lock statement run under ctx
+func classifyLockErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return &kindError{kind: ctxErr, err: err}
+	}
+	return classifyPostgres(err)
+}
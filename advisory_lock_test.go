@@ -0,0 +1,60 @@
+package dban
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdvisoryLockID(t *testing.T) {
+	assert.Equal(t, advisoryLockID("streamer"), advisoryLockID("streamer"))
+	assert.NotEqual(t, advisoryLockID("streamer"), advisoryLockID("streamer-2"))
+}
+
+func TestLockerPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	locker := NewLocker(db)
+	ctx := context.Background()
+
+	t.Run("runs fn holding the lock", func(t *testing.T) {
+		var ran bool
+		err := locker.WithLock(ctx, "locker-runs", func(ctx context.Context) error {
+			ran = true
+			return nil
+		})
+		require.NoError(t, err)
+		assert.True(t, ran)
+	})
+
+	t.Run("try fails while the lock is held", func(t *testing.T) {
+		err := locker.WithLock(ctx, "locker-busy", func(ctx context.Context) error {
+			return locker.TryWithLock(ctx, "locker-busy", func(context.Context) error {
+				t.Fatal("fn must not run without the lock")
+				return nil
+			})
+		})
+		assert.True(t, Is(err, ErrLockBusy))
+
+		// the lock is released along with the transaction
+		err = locker.TryWithLock(ctx, "locker-busy", func(context.Context) error { return nil })
+		assert.NoError(t, err)
+	})
+
+	t.Run("returns the error of fn", func(t *testing.T) {
+		errFn := errors.New("fn failed")
+		err := locker.WithLock(ctx, "locker-error", func(context.Context) error { return errFn })
+		assert.True(t, Is(err, errFn))
+	})
+
+	t.Run("gives up waiting once ctx is canceled", func(t *testing.T) {
+		err := locker.WithLock(ctx, "locker-canceled", func(context.Context) error {
+			canceled, cancel := context.WithCancel(ctx)
+			cancel()
+			return locker.WithLock(canceled, "locker-canceled", func(context.Context) error { return nil })
+		})
+		assert.True(t, Is(err, context.Canceled))
+	})
+}
@@ -0,0 +1,187 @@
+package dban
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// CronSchedule is a parsed cron spec (see ParseCron)
+type CronSchedule struct {
+	second, minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// anyDay is set when either of the day fields is unrestricted, so that the days
+	// match both of them rather than any of them
+	anyDay bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronSecond     = cronField{name: "second", min: 0, max: 59}
+	cronMinute     = cronField{name: "minute", min: 0, max: 59}
+	cronHour       = cronField{name: "hour", min: 0, max: 23}
+	cronDayOfMonth = cronField{name: "day of month", min: 1, max: 31}
+	cronMonth      = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// day of week 7 is Sunday as well as 0
+	cronDayOfWeek = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// cronSearchYears bounds the search of the next activation of a spec that never matches,
+// e.g. February 30
+const cronSearchYears = 5
+
+// ParseCron parses a standard cron spec of 5 fields (minute, hour, day of month, month and
+// day of week) or of 6 fields with seconds preceding them. Fields are lists of values,
+// ranges and steps, e.g. "*/5 9-17 * * mon-fri". Months and days of week could be named
+func ParseCron(spec string) (*CronSchedule, error) {
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, errors.From(errors.New("cron spec must have 5 or 6 fields"), logan.F{"spec": spec})
+	}
+
+	var (
+		schedule CronSchedule
+		err      error
+	)
+	for i, parsed := range []struct {
+		field cronField
+		bits  *uint64
+	}{
+		{cronSecond, &schedule.second},
+		{cronMinute, &schedule.minute},
+		{cronHour, &schedule.hour},
+		{cronDayOfMonth, &schedule.dayOfMonth},
+		{cronMonth, &schedule.month},
+		{cronDayOfWeek, &schedule.dayOfWeek},
+	} {
+		if *parsed.bits, err = parsed.field.parse(fields[i]); err != nil {
+			return nil, errors.Wrap(err, "failed to parse cron spec", logan.F{"spec": spec})
+		}
+	}
+	if schedule.dayOfWeek&(1<<7) != 0 {
+		schedule.dayOfWeek |= 1
+	}
+	schedule.anyDay = isCronWildcard(fields[3]) || isCronWildcard(fields[5])
+
+	return &schedule, nil
+}
+
+func isCronWildcard(field string) bool {
+	return field == "*" || field == "?"
+}
+
+// parse returns the values of the field set as bits
+func (f cronField) parse(field string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, errors.From(errors.New("invalid step"), logan.F{"field": f.name, "value": part})
+			}
+			rangePart = part[:i]
+		}
+
+		from, to := f.min, f.max
+		switch {
+		case isCronWildcard(rangePart):
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if from, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if to, err = f.value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if from > to {
+				return 0, errors.From(errors.New("range is reversed"), logan.F{"field": f.name, "value": part})
+			}
+		default:
+			var err error
+			if from, err = f.value(rangePart); err != nil {
+				return 0, err
+			}
+			if step > 1 {
+				// "5/15" means every 15 starting from 5
+				to = f.max
+			} else {
+				to = from
+			}
+		}
+
+		for value := from; value <= to; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+func (f cronField) value(raw string) (int, error) {
+	if value, ok := f.names[strings.ToLower(raw)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < f.min || value > f.max {
+		return 0, errors.From(errors.New("value is out of range"), logan.F{
+			"field": f.name,
+			"value": raw,
+			"min":   f.min,
+			"max":   f.max,
+		})
+	}
+	return value, nil
+}
+
+// Next returns the first activation after t in the location of t, or zero time if there
+// is none within a few years
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Second).Add(time.Second)
+	limit := t.Year() + cronSearchYears
+
+	for t.Year() <= limit {
+		year, month, day := t.Date()
+		switch {
+		case c.month&(1<<uint(month)) == 0:
+			t = time.Date(year, month+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(year, month, day+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(year, month, day, t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = time.Date(year, month, day, t.Hour(), t.Minute()+1, 0, 0, t.Location())
+		case c.second&(1<<uint(t.Second())) == 0:
+			t = t.Add(time.Second)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches follows cron: if both day fields are restricted, a day matching either of them matches
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	dayOfMonth := c.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := c.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if c.anyDay {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
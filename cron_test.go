@@ -0,0 +1,45 @@
+package dban_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+)
+
+func TestParseCron(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04:05", value)
+		require.NoError(t, err)
+		return parsed
+	}
+	// 2023-01-02 is Monday
+	from := at("2023-01-02 10:02:30")
+
+	for spec, expected := range map[string]string{
+		"*/5 * * * *":          "2023-01-02 10:05:00",
+		"30 2 * * *":           "2023-01-03 02:30:00",
+		"*/5 9-17 * * mon-fri": "2023-01-02 10:05:00",
+		"0 9 * * sat,sun":      "2023-01-07 09:00:00",
+		"0 0 1 jan *":          "2024-01-01 00:00:00",
+		"15 * * * * *":         "2023-01-02 10:03:15",
+		"0 0 13 * 5":           "2023-01-06 00:00:00",
+		"0 0 * * 7":            "2023-01-08 00:00:00",
+		"2/20 10 * * *":        "2023-01-02 10:22:00",
+	} {
+		schedule, err := dban.ParseCron(spec)
+		require.NoError(t, err, spec)
+		assert.Equal(t, at(expected), schedule.Next(from), spec)
+	}
+
+	schedule, err := dban.ParseCron("0 0 30 feb *")
+	require.NoError(t, err)
+	assert.True(t, schedule.Next(from).IsZero())
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * * * mon-sun-", "*/0 * * * *", "5-1 * * * *", "* * * foo *"} {
+		_, err := dban.ParseCron(spec)
+		assert.Error(t, err, spec)
+	}
+}
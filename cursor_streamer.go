@@ -0,0 +1,63 @@
+package dban
+
+import (
+	"context"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+)
+
+// CursorStreamable is an interface that an object must implement in order to be streamed
+// through with keyset pagination, which unlike OFFSET does not slow down on later pages.
+// Entities must be selected in ascending order of the cursor, starting after params.Cursor,
+// or from the first entity if it is 0 (see pgdb.CursorPageParams.ApplyTo)
+type CursorStreamable[T any] interface {
+	SelectWithCursorParams(params pgdb.CursorPageParams) ([]T, error)
+}
+
+// CursorStreamer is a KeysetStreamer by a numeric cursor, e.g. an ID
+type CursorStreamer[T any] interface {
+	KeysetStreamer[T, uint64]
+}
+
+// CursorStreamerInitParams are parameters specified when initializing a new cursor streamer
+type CursorStreamerInitParams[T any] struct {
+	Stream        CursorStreamable[T]
+	KeyValueQ     KeyValueQ
+	KeyValueKey   string
+	ExtractCursor func(t T) uint64
+	BatchSize     *uint64
+	Log           *logan.Entry
+	Ctx           *context.Context
+	EventSink     EventSink
+}
+
+// NewCursorStreamer creates a new instance of CursorStreamer using CursorStreamerInitParams,
+// storing the cursor as a decimal number (see NewKeysetStreamer). ExtractCursor must return
+// cursors above 0, as 0 selects from the first entity
+func NewCursorStreamer[T any](initParams CursorStreamerInitParams[T]) CursorStreamer[T] {
+	return NewKeysetStreamer(KeysetStreamerInitParams[T, uint64]{
+		Stream:        cursorParamsStreamable[T]{stream: initParams.Stream},
+		KeyValueQ:     initParams.KeyValueQ,
+		KeyValueKey:   initParams.KeyValueKey,
+		ExtractCursor: initParams.ExtractCursor,
+		Codec:         Uint64Codec(),
+		BatchSize:     initParams.BatchSize,
+		Log:           initParams.Log,
+		Ctx:           initParams.Ctx,
+		EventSink:     initParams.EventSink,
+	})
+}
+
+// cursorParamsStreamable selects entities of a CursorStreamable for a keyset streamer
+type cursorParamsStreamable[T any] struct {
+	stream CursorStreamable[T]
+}
+
+func (s cursorParamsStreamable[T]) SelectAfter(after *uint64, limit uint64) ([]T, error) {
+	params := pgdb.CursorPageParams{Order: pgdb.OrderTypeAsc, Limit: limit}
+	if after != nil {
+		params.Cursor = *after
+	}
+	return s.stream.SelectWithCursorParams(params)
+}
@@ -0,0 +1,106 @@
+package dban_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// idStreamable selects IDs in ascending order following the cursor
+type idStreamable struct {
+	ids      []uint64
+	recorded []pgdb.CursorPageParams
+}
+
+func (s *idStreamable) SelectWithCursorParams(params pgdb.CursorPageParams) ([]uint64, error) {
+	s.recorded = append(s.recorded, params)
+	selected := []uint64{}
+	for _, id := range s.ids {
+		if id > params.Cursor && uint64(len(selected)) < params.Limit {
+			selected = append(selected, id)
+		}
+	}
+	return selected, nil
+}
+
+func newTestCursorStreamer(stream dban.CursorStreamable[uint64], kvQ dban.KeyValueQ) dban.CursorStreamer[uint64] {
+	batchSize := uint64(2)
+	return dban.NewCursorStreamer(dban.CursorStreamerInitParams[uint64]{
+		Stream:        stream,
+		KeyValueQ:     kvQ,
+		KeyValueKey:   cursorKey,
+		ExtractCursor: func(id uint64) uint64 { return id },
+		BatchSize:     &batchSize,
+	})
+}
+
+func TestCursorStreamer(t *testing.T) {
+	stream := &idStreamable{ids: []uint64{3, 10, 42}}
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	streamer := newTestCursorStreamer(stream, kvQ)
+
+	for _, expected := range [][]uint64{{3, 10}, {42}, {3, 10}} {
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, expected, list)
+	}
+
+	cursor, err := streamer.GetCursor()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), cursor)
+	cursors := make([]uint64, len(stream.recorded))
+	for i, params := range stream.recorded {
+		assert.Equal(t, pgdb.OrderTypeAsc, params.Order)
+		cursors[i] = params.Cursor
+	}
+	assert.Equal(t, []uint64{0, 10, 42, 0}, cursors, "end of the list must wrap to the start")
+
+	t.Run("resumed", func(t *testing.T) {
+		var processed []uint64
+		require.NoError(t, newTestCursorStreamer(stream, kvQ).FormListAndProcess(func(_ context.Context, id uint64) error {
+			processed = append(processed, id)
+			return nil
+		}))
+		assert.Equal(t, []uint64{42}, processed, "a new streamer must continue after the stored cursor")
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		list, err := newTestCursorStreamer(&idStreamable{}, dbantest.NewMemoryKeyValueQ()).FormList()
+		require.NoError(t, err)
+		assert.Empty(t, list)
+	})
+
+	t.Run("corrupt cursor", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "abc"}))
+		_, err := newTestCursorStreamer(stream, kvQ).FormList()
+		assert.True(t, dban.Is(err, dban.ErrCorruptCursor))
+	})
+
+	t.Run("process failed", func(t *testing.T) {
+		failure := errors.New("boom")
+		err := newTestCursorStreamer(stream, dbantest.NewMemoryKeyValueQ()).FormListAndProcess(func(_ context.Context, id uint64) error {
+			if id == 10 {
+				return failure
+			}
+			return nil
+		})
+		assert.True(t, dban.Is(err, failure))
+		assert.Equal(t, "10", errors.GetFields(err)["cursor"])
+	})
+
+	t.Run("no ExtractCursor", func(t *testing.T) {
+		_, err := dban.NewCursorStreamer(dban.CursorStreamerInitParams[uint64]{
+			Stream:      stream,
+			KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey: cursorKey,
+		}).FormList()
+		assert.Error(t, err)
+	})
+}
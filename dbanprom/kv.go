@@ -0,0 +1,273 @@
+// Package dbanprom instruments dban.KeyValueQ with Prometheus metrics
+package dbanprom
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zspkg/dban"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// Outcomes of operations the operations counter is labeled with
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+// Methods of the querier the metrics are labeled with
+const (
+	MethodGet            = "get"
+	MethodGetMany        = "get_many"
+	MethodSelectByPrefix = "select_by_prefix"
+	MethodListKeys       = "list_keys"
+	MethodCount          = "count"
+	MethodSelect         = "select"
+	MethodSelectPage     = "select_page"
+	MethodUpsert         = "upsert"
+	MethodUpsertMany     = "upsert_many"
+	MethodLockingGet     = "locking_get"
+	MethodDelete         = "delete"
+	MethodDeleteMany     = "delete_many"
+	MethodUpdate         = "update"
+)
+
+// metrics are the collectors shared by an instrumented querier and its clones
+type metrics struct {
+	operations *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	inFlight   prometheus.Gauge
+}
+
+func newMetrics(reg prometheus.Registerer, labels prometheus.Labels) *metrics {
+	m := &metrics{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   "dban",
+			Subsystem:   "kv",
+			Name:        "operations_total",
+			Help:        "Number of key value operations by method and outcome.",
+			ConstLabels: labels,
+		}, []string{"method", "outcome"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   "dban",
+			Subsystem:   "kv",
+			Name:        "operation_duration_seconds",
+			Help:        "Latency of key value operations by method.",
+			ConstLabels: labels,
+			Buckets:     prometheus.DefBuckets,
+		}, []string{"method"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   "dban",
+			Subsystem:   "kv",
+			Name:        "operations_in_flight",
+			Help:        "Number of key value operations in progress.",
+			ConstLabels: labels,
+		}),
+	}
+
+	m.operations = register(reg, m.operations)
+	m.duration = register(reg, m.duration)
+	m.inFlight = register(reg, m.inFlight)
+	return m
+}
+
+// register registers the collector, or returns the one registered already, so that
+// queriers instrumented with the same labels share their collectors
+func register[C prometheus.Collector](reg prometheus.Registerer, collector C) C {
+	err := reg.Register(collector)
+	if err == nil {
+		return collector
+	}
+
+	if registered, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		if existing, ok := registered.ExistingCollector.(C); ok {
+			return existing
+		}
+	}
+	panic(errors.Wrap(err, "failed to register key value metrics"))
+}
+
+type instrumentedKeyValueQ struct {
+	inner   dban.KeyValueQ
+	metrics *metrics
+}
+
+// NewInstrumentedKeyValueQ creates a querier recording metrics of the operations of inner:
+// dban_kv_operations_total by method and outcome, dban_kv_operation_duration_seconds by
+// method and dban_kv_operations_in_flight, all of them with the constant labels. The
+// collectors are registered with reg (prometheus.DefaultRegisterer if nil) once; queriers
+// instrumented again with the same labels share them. Registration conflicting with other
+// collectors panics, as prometheus.MustRegister does
+func NewInstrumentedKeyValueQ(inner dban.KeyValueQ, reg prometheus.Registerer, labels prometheus.Labels) dban.KeyValueQ {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	return &instrumentedKeyValueQ{
+		inner:   inner,
+		metrics: newMetrics(reg, labels),
+	}
+}
+
+func (q *instrumentedKeyValueQ) New() dban.KeyValueQ {
+	return &instrumentedKeyValueQ{
+		inner:   q.inner.New(),
+		metrics: q.metrics,
+	}
+}
+
+// instrument calls op recording it as an operation of the method
+func instrument[T any](q *instrumentedKeyValueQ, method string, op func() (T, error)) (value T, err error) {
+	q.metrics.inFlight.Inc()
+	start := time.Now()
+	defer func() {
+		q.metrics.inFlight.Dec()
+		q.metrics.duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+
+		outcome := OutcomeSuccess
+		if err != nil {
+			outcome = OutcomeError
+		}
+		q.metrics.operations.WithLabelValues(method, outcome).Inc()
+	}()
+
+	return op()
+}
+
+// instrumentErr is instrument of an operation returning an error only
+func instrumentErr(q *instrumentedKeyValueQ, method string, op func() error) error {
+	_, err := instrument(q, method, func() (struct{}, error) {
+		return struct{}{}, op()
+	})
+	return err
+}
+
+func (q *instrumentedKeyValueQ) Get(key string) (*dban.KeyValue, error) {
+	return instrument(q, MethodGet, func() (*dban.KeyValue, error) {
+		return q.inner.Get(key)
+	})
+}
+
+func (q *instrumentedKeyValueQ) GetMany(keys []string) (map[string]dban.KeyValue, error) {
+	return instrument(q, MethodGetMany, func() (map[string]dban.KeyValue, error) {
+		return q.inner.GetMany(keys)
+	})
+}
+
+func (q *instrumentedKeyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	return instrument(q, MethodSelectByPrefix, func() ([]dban.KeyValue, error) {
+		return q.inner.SelectByPrefix(prefix, params)
+	})
+}
+
+func (q *instrumentedKeyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	return instrument(q, MethodListKeys, func() ([]string, error) {
+		return q.inner.ListKeys(prefix, params)
+	})
+}
+
+func (q *instrumentedKeyValueQ) Count() (uint64, error) {
+	return instrument(q, MethodCount, q.inner.Count)
+}
+
+func (q *instrumentedKeyValueQ) FilterByKeys(keys ...string) dban.KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByKeys(keys...)
+	return &filtered
+}
+
+func (q *instrumentedKeyValueQ) FilterByPrefix(prefix string) dban.KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByPrefix(prefix)
+	return &filtered
+}
+
+func (q *instrumentedKeyValueQ) FilterByValueLike(pattern string) dban.KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByValueLike(pattern)
+	return &filtered
+}
+
+func (q *instrumentedKeyValueQ) Select() ([]dban.KeyValue, error) {
+	return instrument(q, MethodSelect, q.inner.Select)
+}
+
+func (q *instrumentedKeyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	return instrument(q, MethodSelectPage, func() ([]dban.KeyValue, error) {
+		return q.inner.SelectPage(params)
+	})
+}
+
+func (q *instrumentedKeyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	return q.SelectPage(dban.KeyValueStreamParams(params))
+}
+
+func (q *instrumentedKeyValueQ) MustGet(key string) *dban.KeyValue {
+	value, err := dban.GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+func (q *instrumentedKeyValueQ) Upsert(kv dban.KeyValue) error {
+	return instrumentErr(q, MethodUpsert, func() error {
+		return q.inner.Upsert(kv)
+	})
+}
+
+func (q *instrumentedKeyValueQ) UpsertMany(kvs []dban.KeyValue) error {
+	return instrumentErr(q, MethodUpsertMany, func() error {
+		return q.inner.UpsertMany(kvs)
+	})
+}
+
+func (q *instrumentedKeyValueQ) LockingGet(key string) (*dban.KeyValue, error) {
+	return instrument(q, MethodLockingGet, func() (*dban.KeyValue, error) {
+		return q.inner.LockingGet(key)
+	})
+}
+
+func (q *instrumentedKeyValueQ) MustLockingGet(key string) *dban.KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
+
+func (q *instrumentedKeyValueQ) Delete(key string) error {
+	return instrumentErr(q, MethodDelete, func() error {
+		return q.inner.Delete(key)
+	})
+}
+
+func (q *instrumentedKeyValueQ) DeleteMany(keys []string) (int64, error) {
+	return instrument(q, MethodDeleteMany, func() (int64, error) {
+		return q.inner.DeleteMany(keys)
+	})
+}
+
+func (q *instrumentedKeyValueQ) Update(key string, fn func(current *string) (string, error)) (dban.KeyValue, error) {
+	return instrument(q, MethodUpdate, func() (dban.KeyValue, error) {
+		return q.inner.Update(key, fn)
+	})
+}
+
+// Transaction runs fn in a transaction of inner with a querier recording metrics the same
+// way, if inner supports transactions
+func (q *instrumentedKeyValueQ) Transaction(fn func(q dban.KeyValueQ) error) error {
+	txQ, ok := q.inner.(dban.TransactionalKeyValueQ)
+	if !ok {
+		return fn(q)
+	}
+	return txQ.Transaction(func(inner dban.KeyValueQ) error {
+		return fn(&instrumentedKeyValueQ{inner: inner, metrics: q.metrics})
+	})
+}
+
+func (q *instrumentedKeyValueQ) WithinTx() bool {
+	txQ, ok := q.inner.(dban.TransactionalKeyValueQ)
+	return ok && txQ.WithinTx()
+}
@@ -0,0 +1,55 @@
+package dbanprom
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+// failingKeyValueQ fails every delete
+type failingKeyValueQ struct {
+	dban.KeyValueQ
+}
+
+func (q failingKeyValueQ) Delete(string) error {
+	return errors.New("storage is unavailable")
+}
+
+func TestInstrumentedKeyValueQ(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	labels := prometheus.Labels{"service": "indexer"}
+	kvQ := NewInstrumentedKeyValueQ(failingKeyValueQ{dbantest.NewMemoryKeyValueQ()}, reg, labels)
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "page", Value: "1"}))
+	require.NoError(t, kvQ.New().Upsert(dban.KeyValue{Key: "page", Value: "2"}))
+	assert.Equal(t, "2", kvQ.MustGet("page").Value)
+	assert.Error(t, kvQ.Delete("page"))
+
+	// instrumenting again with the same registerer reuses the collectors
+	again := NewInstrumentedKeyValueQ(dbantest.NewMemoryKeyValueQ(), reg, labels)
+	_, err := again.LockingGet("page")
+	require.NoError(t, err)
+
+	expected := `
+# HELP dban_kv_operations_total Number of key value operations by method and outcome.
+# TYPE dban_kv_operations_total counter
+dban_kv_operations_total{method="delete",outcome="error",service="indexer"} 1
+dban_kv_operations_total{method="get",outcome="success",service="indexer"} 1
+dban_kv_operations_total{method="locking_get",outcome="success",service="indexer"} 1
+dban_kv_operations_total{method="upsert",outcome="success",service="indexer"} 2
+# HELP dban_kv_operations_in_flight Number of key value operations in progress.
+# TYPE dban_kv_operations_in_flight gauge
+dban_kv_operations_in_flight{service="indexer"} 0
+`
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"dban_kv_operations_total", "dban_kv_operations_in_flight"))
+	assert.Equal(t, 4, testutil.CollectAndCount(kvQ.(*instrumentedKeyValueQ).metrics.duration),
+		"latency is observed per method")
+}
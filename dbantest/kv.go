@@ -0,0 +1,368 @@
+package dbantest
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zspkg/dban"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+type memoryKeyValueQ struct {
+	mu       *sync.Mutex
+	values   map[string]string
+	versions map[string]int64
+	filters  dban.KeyValueFilters
+}
+
+// NewMemoryKeyValueQ creates a key value querier keeping values in memory. Queriers
+// created with New share the values
+func NewMemoryKeyValueQ() dban.KeyValueQ {
+	return &memoryKeyValueQ{
+		mu:       &sync.Mutex{},
+		values:   make(map[string]string),
+		versions: make(map[string]int64),
+	}
+}
+
+func (q *memoryKeyValueQ) New() dban.KeyValueQ {
+	return &memoryKeyValueQ{mu: q.mu, values: q.values, versions: q.versions}
+}
+
+func (q *memoryKeyValueQ) Get(key string) (*dban.KeyValue, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	value, ok := q.values[key]
+	if !ok {
+		return nil, nil
+	}
+	return &dban.KeyValue{Key: key, Value: value}, nil
+}
+
+func (q *memoryKeyValueQ) GetMany(keys []string) (map[string]dban.KeyValue, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	values := make(map[string]dban.KeyValue, len(keys))
+	for _, key := range keys {
+		if value, ok := q.values[key]; ok {
+			values[key] = dban.KeyValue{Key: key, Value: value}
+		}
+	}
+	return values, nil
+}
+
+func (q *memoryKeyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var values []dban.KeyValue
+	for key, value := range q.values {
+		if strings.HasPrefix(key, prefix) {
+			values = append(values, dban.KeyValue{Key: key, Value: value})
+		}
+	}
+	return dban.PageKeyValues(values, params), nil
+}
+
+func (q *memoryKeyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var keys []string
+	for key := range q.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return dban.PageKeys(keys, params), nil
+}
+
+func (q *memoryKeyValueQ) Count() (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return uint64(len(q.values)), nil
+}
+
+func (q *memoryKeyValueQ) FilterByKeys(keys ...string) dban.KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByKeys(keys...)
+	return &filtered
+}
+
+func (q *memoryKeyValueQ) FilterByPrefix(prefix string) dban.KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByKeyPrefix(prefix)
+	return &filtered
+}
+
+func (q *memoryKeyValueQ) FilterByValueLike(pattern string) dban.KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByValueLike(pattern)
+	return &filtered
+}
+
+func (q *memoryKeyValueQ) Select() ([]dban.KeyValue, error) {
+	return dban.SelectFiltered(q, q.filters, nil)
+}
+
+func (q *memoryKeyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	return dban.SelectFiltered(q, q.filters, &params)
+}
+
+func (q *memoryKeyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	return q.SelectPage(dban.KeyValueStreamParams(params))
+}
+
+func (q *memoryKeyValueQ) MustGet(key string) *dban.KeyValue {
+	value, err := dban.GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+func (q *memoryKeyValueQ) Upsert(kv dban.KeyValue) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.set(kv.Key, kv.Value)
+	return nil
+}
+
+func (q *memoryKeyValueQ) UpsertMany(kvs []dban.KeyValue) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, kv := range kvs {
+		q.set(kv.Key, kv.Value)
+	}
+	return nil
+}
+
+func (q *memoryKeyValueQ) Delete(key string) error {
+	_, err := q.DeleteMany([]string{key})
+	return err
+}
+
+func (q *memoryKeyValueQ) DeleteMany(keys []string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var deleted int64
+	for _, key := range keys {
+		if _, ok := q.values[key]; ok {
+			q.unset(key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Update calls fn holding the lock of the values, so fn must not use the querier
+func (q *memoryKeyValueQ) Update(key string, fn func(current *string) (string, error)) (dban.KeyValue, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var current *string
+	if value, ok := q.values[key]; ok {
+		current = &value
+	}
+	next, err := fn(current)
+	if dban.Is(err, dban.ErrSkipUpdate) {
+		if current == nil {
+			return dban.KeyValue{}, nil
+		}
+		return dban.KeyValue{Key: key, Value: *current}, nil
+	}
+	if err != nil {
+		return dban.KeyValue{}, err
+	}
+
+	q.set(key, next)
+	return dban.KeyValue{Key: key, Value: next}, nil
+}
+
+func (q *memoryKeyValueQ) LockingGet(key string) (*dban.KeyValue, error) {
+	return q.Get(key)
+}
+
+// LockingGetMany implements dban.ManyLocker. Values in memory are not locked
+func (q *memoryKeyValueQ) LockingGetMany(keys []string) (map[string]dban.KeyValue, error) {
+	return q.GetMany(keys)
+}
+
+func (q *memoryKeyValueQ) MustLockingGet(key string) *dban.KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
+
+// AdvanceCursor implements dban.CursorAdvancer
+func (q *memoryKeyValueQ) AdvanceCursor(key string, delta uint64) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var previous uint64
+	if value, ok := q.values[key]; ok {
+		var err error
+		if previous, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return 0, &dban.CorruptCursorError{Value: value, Err: err}
+		}
+	}
+
+	q.set(key, strconv.FormatUint(previous+delta, 10))
+	return previous, nil
+}
+
+// Increment implements dban.Incrementer
+func (q *memoryKeyValueQ) Increment(key string, delta int64) error {
+	_, err := q.IncrementAndGet(key, delta)
+	return err
+}
+
+// IncrementAndGet implements dban.AtomicCounter
+func (q *memoryKeyValueQ) IncrementAndGet(key string, delta int64) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var value int64
+	if raw, ok := q.values[key]; ok {
+		var err error
+		if value, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			return 0, errors.Wrap(dban.ErrValueNotNumeric, err.Error(), logan.F{"key": key})
+		}
+	}
+
+	value += delta
+	q.set(key, strconv.FormatInt(value, 10))
+	return value, nil
+}
+
+// DecrementAndGet implements dban.AtomicCounter
+func (q *memoryKeyValueQ) DecrementAndGet(key string, delta int64) (int64, error) {
+	return q.IncrementAndGet(key, -delta)
+}
+
+// UpdateIfEquals implements dban.CompareAndSwapper
+func (q *memoryKeyValueQ) UpdateIfEquals(key, expected, newValue string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if value, ok := q.values[key]; !ok || value != expected {
+		return false, nil
+	}
+	q.set(key, newValue)
+	return true, nil
+}
+
+// InsertIfAbsent implements dban.CompareAndSwapper
+func (q *memoryKeyValueQ) InsertIfAbsent(kv dban.KeyValue) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.values[kv.Key]; ok {
+		return false, nil
+	}
+	q.set(kv.Key, kv.Value)
+	return true, nil
+}
+
+// GetOrSet implements dban.GetOrSetter
+func (q *memoryKeyValueQ) GetOrSet(key, defaultValue string) (dban.KeyValue, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if value, ok := q.values[key]; ok {
+		return dban.KeyValue{Key: key, Value: value}, false, nil
+	}
+	q.set(key, defaultValue)
+	return dban.KeyValue{Key: key, Value: defaultValue}, true, nil
+}
+
+// DeleteByPrefix implements dban.PrefixDeleter
+func (q *memoryKeyValueQ) DeleteByPrefix(prefix string) (int64, error) {
+	if prefix == "" {
+		return 0, dban.ErrEmptyPrefix
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var deleted int64
+	for key := range q.values {
+		if strings.HasPrefix(key, prefix) {
+			q.unset(key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// CountByPrefix implements dban.PrefixDeleter
+func (q *memoryKeyValueQ) CountByPrefix(prefix string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var count int64
+	for key := range q.values {
+		if strings.HasPrefix(key, prefix) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetVersioned implements dban.Versioner
+func (q *memoryKeyValueQ) GetVersioned(key string) (*dban.VersionedKeyValue, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	value, ok := q.values[key]
+	if !ok {
+		return nil, nil
+	}
+	return &dban.VersionedKeyValue{KeyValue: dban.KeyValue{Key: key, Value: value}, Version: q.versions[key]}, nil
+}
+
+// UpsertWithVersion implements dban.Versioner
+func (q *memoryKeyValueQ) UpsertWithVersion(kv dban.KeyValue, expectedVersion int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.versions[kv.Key] != expectedVersion {
+		return errors.From(dban.ErrVersionConflict, logan.F{"key": kv.Key, "expected_version": expectedVersion})
+	}
+	q.set(kv.Key, kv.Value)
+	return nil
+}
+
+// UpsertChanged implements dban.ChangeDetector
+func (q *memoryKeyValueQ) UpsertChanged(kv dban.KeyValue) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if value, ok := q.values[kv.Key]; ok && value == kv.Value {
+		return false, nil
+	}
+	q.set(kv.Key, kv.Value)
+	return true, nil
+}
+
+// set writes the value by the key, incrementing its version the way the version trigger does
+func (q *memoryKeyValueQ) set(key, value string) {
+	q.values[key] = value
+	q.versions[key]++
+}
+
+func (q *memoryKeyValueQ) unset(key string) {
+	delete(q.values, key)
+	delete(q.versions, key)
+}
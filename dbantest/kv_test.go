@@ -0,0 +1,51 @@
+package dbantest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+func TestMemoryKeyValueQFilters(t *testing.T) {
+	kvQ := NewMemoryKeyValueQ()
+	kvs := make([]dban.KeyValue, 2500)
+	for i := range kvs {
+		kvs[i] = dban.KeyValue{Key: fmt.Sprintf("key:%04d", i), Value: fmt.Sprint(i)}
+	}
+	require.NoError(t, kvQ.UpsertMany(kvs))
+
+	values, err := kvQ.FilterByValueLike("%99").Select()
+	require.NoError(t, err)
+	require.Len(t, values, 25, "all the pages are filtered")
+	assert.Equal(t, kvs[99], values[0])
+
+	filtered := kvQ.FilterByKeys("key:0001", "key:0010", "missing").FilterByValueLike("1%")
+	values, err = filtered.SelectPage(pgdb.OffsetPageParams{Order: pgdb.OrderTypeDesc})
+	require.NoError(t, err)
+	assert.Equal(t, []dban.KeyValue{kvs[10], kvs[1]}, values)
+
+	values, err = filtered.New().SelectPage(pgdb.OffsetPageParams{Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []dban.KeyValue{kvs[2499]}, values, "New clears the filters")
+}
+
+func TestMemoryKeyValueQUpdate(t *testing.T) {
+	kvQ := NewMemoryKeyValueQ()
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "counter", Value: "1"}))
+
+	updated, err := kvQ.Update("counter", func(current *string) (string, error) {
+		require.NotNil(t, current)
+		return *current + "1", nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, dban.KeyValue{Key: "counter", Value: "11"}, updated)
+
+	updated, err = kvQ.Update("missing", func(*string) (string, error) { return "", dban.ErrSkipUpdate })
+	require.NoError(t, err)
+	assert.Zero(t, updated, "a skipped update of a missing key must return zero")
+	assert.Nil(t, kvQ.MustLockingGet("missing"))
+}
@@ -0,0 +1,88 @@
+// Package dbantest provides helpers for testing code built on top of dban
+package dbantest
+
+import (
+	"sync"
+
+	"github.com/zspkg/dban"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+// defaultLimit is the limit pgdb applies when OffsetPageParams.Limit is zero
+const defaultLimit uint64 = 15
+
+// SliceStreamableOption is an optional parameter of a slice streamable
+type SliceStreamableOption func(*sliceStreamableConfig)
+
+type sliceStreamableConfig struct {
+	pageErrors map[uint64]error
+	recorded   *[]pgdb.OffsetPageParams
+}
+
+// WithPageError makes the streamable fail with err when the page is requested
+func WithPageError(page uint64, err error) SliceStreamableOption {
+	return func(c *sliceStreamableConfig) {
+		c.pageErrors[page] = err
+	}
+}
+
+// WithPageParamsRecorder makes the streamable append every requested page params to params
+func WithPageParamsRecorder(params *[]pgdb.OffsetPageParams) SliceStreamableOption {
+	return func(c *sliceStreamableConfig) {
+		c.recorded = params
+	}
+}
+
+type sliceStreamable[T any] struct {
+	mu     sync.Mutex
+	items  []T
+	config sliceStreamableConfig
+}
+
+// NewSliceStreamable creates a streamable paginating through items in their order the
+// same way pgdb does: a zero limit means 15, pages past the end are empty and the
+// order of page params is ignored
+func NewSliceStreamable[T any](items []T, opts ...SliceStreamableOption) dban.Streamable[T] {
+	config := sliceStreamableConfig{pageErrors: make(map[uint64]error)}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	return &sliceStreamable[T]{
+		items:  items,
+		config: config,
+	}
+}
+
+func (s *sliceStreamable[T]) SelectWithPageParams(pageParams pgdb.OffsetPageParams) ([]T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.config.recorded != nil {
+		*s.config.recorded = append(*s.config.recorded, pageParams)
+	}
+	if err, ok := s.config.pageErrors[pageParams.PageNumber]; ok {
+		return nil, err
+	}
+
+	limit := pageParams.Limit
+	if limit == 0 {
+		limit = defaultLimit
+	}
+
+	total := uint64(len(s.items))
+	// compared by division, so that limit*page cannot overflow
+	if pageParams.PageNumber >= (total+limit-1)/limit {
+		return []T{}, nil
+	}
+
+	from := limit * pageParams.PageNumber
+	to := from + limit
+	if to > total {
+		to = total
+	}
+
+	page := make([]T, to-from)
+	copy(page, s.items[from:to])
+	return page, nil
+}
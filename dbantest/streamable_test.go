@@ -0,0 +1,73 @@
+package dbantest
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+func TestSliceStreamable(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	cases := []struct {
+		params   pgdb.OffsetPageParams
+		expected []int
+	}{
+		{params: pgdb.OffsetPageParams{Limit: 2, PageNumber: 0}, expected: []int{1, 2}},
+		{params: pgdb.OffsetPageParams{Limit: 2, PageNumber: 2}, expected: []int{5}},
+		{params: pgdb.OffsetPageParams{Limit: 2, PageNumber: 3}, expected: []int{}},
+		{params: pgdb.OffsetPageParams{Limit: 5, PageNumber: 1}, expected: []int{}},
+		{params: pgdb.OffsetPageParams{Limit: 0, PageNumber: 0}, expected: items},
+		{params: pgdb.OffsetPageParams{Limit: 2, PageNumber: ^uint64(0)}, expected: []int{}},
+	}
+
+	stream := NewSliceStreamable(items)
+	for _, tc := range cases {
+		page, err := stream.SelectWithPageParams(tc.params)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, page, "%+v", tc.params)
+	}
+}
+
+func TestSliceStreamableOptions(t *testing.T) {
+	var (
+		recorded []pgdb.OffsetPageParams
+		failure  = errors.New("page is broken")
+	)
+	stream := NewSliceStreamable([]string{"a", "b", "c"}, WithPageError(1, failure), WithPageParamsRecorder(&recorded))
+
+	_, err := stream.SelectWithPageParams(pgdb.OffsetPageParams{Limit: 2, PageNumber: 0})
+	require.NoError(t, err)
+	_, err = stream.SelectWithPageParams(pgdb.OffsetPageParams{Limit: 2, PageNumber: 1})
+	assert.Equal(t, failure, err)
+
+	assert.Equal(t, []pgdb.OffsetPageParams{{Limit: 2, PageNumber: 0}, {Limit: 2, PageNumber: 1}}, recorded)
+}
+
+func TestSliceStreamableMatchesSlicing(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		items := make([]int, rnd.Intn(50))
+		for j := range items {
+			items[j] = j
+		}
+		limit := uint64(rnd.Intn(10) + 1)
+		stream := NewSliceStreamable(items)
+
+		var streamed []int
+		for page := uint64(0); ; page++ {
+			batch, err := stream.SelectWithPageParams(pgdb.OffsetPageParams{Limit: limit, PageNumber: page})
+			require.NoError(t, err)
+			if len(batch) == 0 {
+				break
+			}
+			require.True(t, uint64(len(batch)) <= limit)
+			assert.Equal(t, items[page*limit:page*limit+uint64(len(batch))], batch)
+			streamed = append(streamed, batch...)
+		}
+		assert.Equal(t, len(items), len(streamed), "size %d, limit %d", len(items), limit)
+	}
+}
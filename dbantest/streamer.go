@@ -0,0 +1,113 @@
+package dbantest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/zspkg/dban"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+// RecordingStreamer is a streamer recording the pages it selects from its Stream and the
+// entities it hands to the processing functions, so that tests could assert on them
+type RecordingStreamer[T any] struct {
+	dban.Streamer[T]
+	recorder *recorder[T]
+}
+
+type recorder[T any] struct {
+	mu       sync.Mutex
+	pages    []uint64
+	entities []T
+}
+
+// NewRecordingStreamer creates a streamer with params, recording the pages selected from
+// params.Stream and the entities passed to the processing functions, including the ones
+// they fail on
+func NewRecordingStreamer[T any](params dban.StreamerInitParams[T]) *RecordingStreamer[T] {
+	r := &recorder[T]{}
+	if params.Stream != nil {
+		params.Stream = recordingStreamable[T]{stream: params.Stream, recorder: r}
+	}
+	return &RecordingStreamer[T]{Streamer: dban.NewStreamer(params), recorder: r}
+}
+
+// Pages returns the numbers of the pages selected so far in the order they were selected
+func (s *RecordingStreamer[T]) Pages() []uint64 {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	return append([]uint64(nil), s.recorder.pages...)
+}
+
+// Entities returns the entities handed to the processing functions so far in the order they
+// were handed
+func (s *RecordingStreamer[T]) Entities() []T {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	return append([]T(nil), s.recorder.entities...)
+}
+
+func (s *RecordingStreamer[T]) FormListAndProcess(fn func(ctx context.Context, t T) error) error {
+	return s.Streamer.FormListAndProcess(s.recorded(fn))
+}
+
+func (s *RecordingStreamer[T]) FormListAndProcessReport(fn func(ctx context.Context, t T) error) (dban.BatchReport[T], error) {
+	return s.Streamer.FormListAndProcessReport(s.recorded(fn))
+}
+
+func (s *RecordingStreamer[T]) FormAndProcessRows(fn func(ctx context.Context, t T) error) error {
+	return s.Streamer.FormAndProcessRows(s.recorded(fn))
+}
+
+func (s *RecordingStreamer[T]) ProcessAll(fn func(ctx context.Context, t T) error) (dban.ProcessReport, error) {
+	return s.Streamer.ProcessAll(s.recorded(fn))
+}
+
+func (s *RecordingStreamer[T]) Run(ctx context.Context, fn func(ctx context.Context, t T) error, cfg dban.RunConfig) error {
+	return s.Streamer.Run(ctx, s.recorded(fn), cfg)
+}
+
+func (s *RecordingStreamer[T]) RunCron(ctx context.Context, spec string, fn func(ctx context.Context, t T) error) error {
+	return s.Streamer.RunCron(ctx, spec, s.recorded(fn))
+}
+
+func (s *RecordingStreamer[T]) FormListAndProcessTx(fn func(ctx context.Context, q dban.KeyValueQ, t T) error) error {
+	return s.Streamer.FormListAndProcessTx(func(ctx context.Context, q dban.KeyValueQ, t T) error {
+		s.recorder.entity(t)
+		return fn(ctx, q, t)
+	})
+}
+
+func (s *RecordingStreamer[T]) FormListAndProcessBatch(fn func(ctx context.Context, batch []T) error) error {
+	return s.Streamer.FormListAndProcessBatch(func(ctx context.Context, batch []T) error {
+		for _, t := range batch {
+			s.recorder.entity(t)
+		}
+		return fn(ctx, batch)
+	})
+}
+
+func (s *RecordingStreamer[T]) recorded(fn func(ctx context.Context, t T) error) func(ctx context.Context, t T) error {
+	return func(ctx context.Context, t T) error {
+		s.recorder.entity(t)
+		return fn(ctx, t)
+	}
+}
+
+func (r *recorder[T]) entity(t T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entities = append(r.entities, t)
+}
+
+type recordingStreamable[T any] struct {
+	stream   dban.Streamable[T]
+	recorder *recorder[T]
+}
+
+func (s recordingStreamable[T]) SelectWithPageParams(pageParams pgdb.OffsetPageParams) ([]T, error) {
+	s.recorder.mu.Lock()
+	s.recorder.pages = append(s.recorder.pages, pageParams.PageNumber)
+	s.recorder.mu.Unlock()
+	return s.stream.SelectWithPageParams(pageParams)
+}
@@ -0,0 +1,39 @@
+package dbantest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+)
+
+func TestRecordingStreamer(t *testing.T) {
+	batchSize := uint64(2)
+	kvQ := NewMemoryKeyValueQ()
+	streamer := NewRecordingStreamer(dban.StreamerInitParams[int]{
+		Stream:      NewSliceStreamable([]int{1, 2, 3}),
+		KeyValueQ:   kvQ,
+		KeyValueKey: "recording",
+		BatchSize:   &batchSize,
+	})
+
+	failure := errors.New("boom")
+	err := streamer.FormListAndProcess(func(_ context.Context, i int) error {
+		if i == 2 {
+			return failure
+		}
+		return nil
+	})
+	assert.True(t, dban.Is(err, failure))
+
+	report, err := streamer.ProcessAll(func(context.Context, int) error { return nil })
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), report.Entities)
+
+	assert.Equal(t, []uint64{0, 1}, streamer.Pages())
+	assert.Equal(t, []int{1, 2, 3}, streamer.Entities(), "the entities failed on must be recorded as well")
+	assert.Equal(t, "1", kvQ.MustGet("recording").Value)
+}
@@ -0,0 +1,129 @@
+package dban
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+const deadLettersTable = "dead_letters"
+
+// DeadLetter is an entity a streamer failed to process, stored by FeatureDeadLetters.
+// Payload is the entity marshalled to JSON
+type DeadLetter struct {
+	ID        int64     `db:"id" json:"id"`
+	StreamKey string    `db:"stream_key" json:"stream_key"`
+	Payload   string    `db:"payload" json:"payload"`
+	Error     string    `db:"error" json:"error"`
+	Attempts  int64     `db:"attempts" json:"attempts"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// DeadLetterQ is an interface for querying the dead letters of streamers. Requires
+// FeatureDeadLetters. It is Streamable itself, so that the dead letters could be processed
+// again by a streamer and deleted once they succeed. Deleting them shifts the following
+// ones to the earlier pages, so some of them are taken only by the next pass of the streamer
+type DeadLetterQ interface {
+	// New creates a new instance of an interface with all filters cleared
+	New() DeadLetterQ
+	// FilterByStreamKey returns a copy of the querier selecting the dead letters of the
+	// stream only
+	FilterByStreamKey(streamKey string) DeadLetterQ
+	// Insert stores the dead letter and returns its ID. ID and CreatedAt of letter are
+	// ignored
+	Insert(letter DeadLetter) (int64, error)
+	// SelectWithPageParams returns a page of the dead letters in the order they were
+	// stored in
+	SelectWithPageParams(pageParams pgdb.OffsetPageParams) ([]DeadLetter, error)
+	// Delete deletes the dead letter by its ID. Deleting a missing one is not an error
+	Delete(id int64) error
+}
+
+var (
+	deadLetterInsertQuery = mustBuild(
+		squirrel.Insert(deadLettersTable).Columns("stream_key", "payload", "error", "attempts").
+			Values("", "", "", 0).Suffix("RETURNING id"),
+	)
+	deadLetterDeleteQuery = mustBuild(squirrel.Delete(deadLettersTable).Where(squirrel.Eq{"id": 0}))
+)
+
+type deadLetterQ struct {
+	db  *pgdb.DB
+	sql squirrel.SelectBuilder
+}
+
+// NewDeadLetterQ creates a new instance of a dead letter querier
+func NewDeadLetterQ(db *pgdb.DB) DeadLetterQ {
+	return &deadLetterQ{
+		db: db,
+		sql: squirrel.Select("id", "stream_key", "payload", "error", "attempts", "created_at").
+			From(deadLettersTable),
+	}
+}
+
+func (q *deadLetterQ) New() DeadLetterQ {
+	return NewDeadLetterQ(q.db.Clone())
+}
+
+func (q *deadLetterQ) FilterByStreamKey(streamKey string) DeadLetterQ {
+	filtered := *q
+	filtered.sql = q.sql.Where(squirrel.Eq{"stream_key": streamKey})
+	return &filtered
+}
+
+func (q *deadLetterQ) Insert(letter DeadLetter) (int64, error) {
+	var id int64
+	err := q.db.GetRaw(&id, deadLetterInsertQuery, letter.StreamKey, letter.Payload, letter.Error, letter.Attempts)
+	if err != nil {
+		return 0, errors.Wrap(classifyPostgres(err), "failed to insert dead letter", logan.F{
+			"stream_key": letter.StreamKey,
+		})
+	}
+	return id, nil
+}
+
+func (q *deadLetterQ) SelectWithPageParams(pageParams pgdb.OffsetPageParams) ([]DeadLetter, error) {
+	var letters []DeadLetter
+	query := q.sql.OrderBy("id").Limit(pageParams.Limit).Offset(pageParams.Limit * pageParams.PageNumber)
+	if err := q.db.Select(&letters, query); err != nil {
+		return nil, errors.Wrap(classifyPostgres(err), "failed to select dead letters", logan.F{
+			"limit":       pageParams.Limit,
+			"page_number": pageParams.PageNumber,
+		})
+	}
+	return letters, nil
+}
+
+func (q *deadLetterQ) Delete(id int64) error {
+	if err := q.db.ExecRaw(deadLetterDeleteQuery, id); err != nil {
+		return errors.Wrap(classifyPostgres(err), "failed to delete dead letter", logan.F{"id": id})
+	}
+	return nil
+}
+
+// StoreDeadLetters returns a DeadLetterFunc inserting the entities into q with streamKey,
+// the KeyValueKey of the streamer, and attempts, its EntityMaxAttempts. An entity that
+// could not be marshalled to JSON is stored as a JSON string of its %+v representation,
+// so that it is recorded anyway
+func StoreDeadLetters[T any](q DeadLetterQ, streamKey string, attempts uint) DeadLetterFunc[T] {
+	return func(_ context.Context, t T, err error) error {
+		payload, marshalErr := json.Marshal(t)
+		if marshalErr != nil {
+			// a string is marshalled no matter what it holds
+			payload, _ = json.Marshal(fmt.Sprintf("%+v", t))
+		}
+		_, err = q.Insert(DeadLetter{
+			StreamKey: streamKey,
+			Payload:   string(payload),
+			Error:     err.Error(),
+			Attempts:  int64(attempts),
+		})
+		return err
+	}
+}
@@ -0,0 +1,98 @@
+package dban
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+func TestDeadLetterQ(t *testing.T) {
+	db, mock := newMockDB(t)
+	deadQ := NewDeadLetterQ(db)
+	createdAt := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	const insertSQL = "INSERT INTO dead_letters (stream_key,payload,error,attempts) VALUES ($1,$2,$3,$4) RETURNING id"
+	mock.ExpectQuery(insertSQL).WithArgs("numbers", "7", "boom", int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(insertSQL).WithArgs("numbers", `"{Name:x Callback:\u003cnil\u003e}"`, "boom", int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery("SELECT id, stream_key, payload, error, attempts, created_at FROM dead_letters WHERE stream_key = $1 ORDER BY id LIMIT 2 OFFSET 2").
+		WithArgs("numbers").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "stream_key", "payload", "error", "attempts", "created_at"}).
+			AddRow(3, "numbers", "7", "boom", 3, createdAt))
+	mock.ExpectExec("DELETE FROM dead_letters WHERE id = $1").WithArgs(int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	failure := errors.New("boom")
+	require.NoError(t, StoreDeadLetters[int](deadQ, "numbers", 3)(context.Background(), 7, failure))
+	type unmarshallable struct {
+		Name     string
+		Callback func()
+	}
+	err := StoreDeadLetters[unmarshallable](deadQ, "numbers", 1)(context.Background(), unmarshallable{Name: "x"}, failure)
+	require.NoError(t, err, "an entity failed to marshal must be stored as a string")
+
+	letters, err := deadQ.FilterByStreamKey("numbers").SelectWithPageParams(pgdb.OffsetPageParams{Limit: 2, PageNumber: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []DeadLetter{{ID: 3, StreamKey: "numbers", Payload: "7", Error: "boom", Attempts: 3, CreatedAt: createdAt}}, letters)
+	require.NoError(t, deadQ.Delete(3))
+}
+
+func TestDeadLettersPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db, WithFeatures(FeatureDeadLetters))
+	kvQ := NewKeyValueQ(db)
+	deadQ := NewDeadLetterQ(db)
+	batchSize := uint64(4)
+
+	// odd numbers fail the first pass
+	streamer := NewStreamer(StreamerInitParams[uint64]{
+		Stream:            rangeStreamable{size: 10},
+		KeyValueQ:         kvQ,
+		KeyValueKey:       "numbers",
+		BatchSize:         &batchSize,
+		EntityMaxAttempts: 2,
+		OnDeadLetter:      StoreDeadLetters[uint64](deadQ, "numbers", 2),
+	})
+	_, err := streamer.ProcessAll(func(_ context.Context, i uint64) error {
+		if i%2 == 1 {
+			return errors.New("odd number")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	redrive := NewStreamer(StreamerInitParams[DeadLetter]{
+		Stream:      deadQ.FilterByStreamKey("numbers"),
+		KeyValueQ:   kvQ,
+		KeyValueKey: "numbers-redrive",
+		BatchSize:   &batchSize,
+	})
+	var redriven []uint64
+	// deleting the letters shifts the rest to the earlier pages, which the next pass takes
+	for pass := 0; pass < 3; pass++ {
+		_, err = redrive.ProcessAll(func(_ context.Context, letter DeadLetter) error {
+			assert.Equal(t, "odd number", letter.Error)
+			assert.Equal(t, int64(2), letter.Attempts)
+			var i uint64
+			if err := json.Unmarshal([]byte(letter.Payload), &i); err != nil {
+				return err
+			}
+			redriven = append(redriven, i)
+			return deadQ.Delete(letter.ID)
+		})
+		require.NoError(t, err)
+	}
+	assert.ElementsMatch(t, []uint64{1, 3, 5, 7, 9}, redriven)
+
+	letters, err := deadQ.SelectWithPageParams(pgdb.OffsetPageParams{Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, letters, "re-driven letters must be deleted")
+}
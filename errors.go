@@ -0,0 +1,400 @@
+package dban
+
+import (
+	"database/sql"
+	stderrors "errors"
+	"fmt"
+	"strings"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+var (
+	// ErrNoValue is returned when a value is required, but there is none by the key
+	ErrNoValue = errors.New("no value by the key")
+	// ErrNoSuchKey is returned by GetStrict and MustGet when there is no value by the key.
+	// It is the same error as ErrNoValue
+	ErrNoSuchKey = ErrNoValue
+	// ErrRowLocked is returned when a row could not be locked because another transaction
+	// holds the lock for too long
+	ErrRowLocked = errors.New("row is locked by another transaction")
+	// ErrDuplicateKey is returned when a write violates uniqueness of a key
+	ErrDuplicateKey = errors.New("duplicate key")
+	// ErrCorruptCursor is returned when a stored streamer cursor cannot be parsed
+	ErrCorruptCursor = errors.New("cursor is corrupt")
+	// ErrCursorOutOfRange is returned by AdvanceCursor when the cursor or the delta exceeds
+	// math.MaxInt64, the largest value Postgres could add them as
+	ErrCursorOutOfRange = errors.New("cursor is out of range")
+	// ErrReadOnly is returned when a write is attempted on a read-only storage
+	ErrReadOnly = errors.New("storage is read-only")
+	// ErrValueTooLarge is returned when a key or a value does not fit into the storage
+	ErrValueTooLarge = errors.New("value is too large")
+	// ErrBatchSizeChanged is returned when the batch size of a streamer differs from the one
+	// its cursor was counted with (see BatchSizeFail)
+	ErrBatchSizeChanged = errors.New("batch size of the streamer changed")
+	// ErrInvalidKey is returned when a key is empty or consists of whitespace only
+	ErrInvalidKey = errors.New("key is invalid")
+	// ErrEmptyKey is returned when a key is empty or consists of whitespace only. It
+	// matches ErrInvalidKey as well
+	ErrEmptyKey error = &kindError{kind: ErrInvalidKey, err: errors.New("key is empty")}
+	// ErrKeyTooLong is returned when a key is longer than the maximum key length (see
+	// WithMaxKeyLength). It matches ErrInvalidKey as well
+	ErrKeyTooLong error = &kindError{kind: ErrInvalidKey, err: errors.New("key is too long")}
+	// ErrInconsistentStream is returned when a streamer finds an empty page right after
+	// resetting its cursor to the first one
+	ErrInconsistentStream = errors.New("stream is inconsistent")
+	// ErrNoTransaction is returned when a locking read is made outside a transaction, or by
+	// Update of a querier that does not support transactions
+	ErrNoTransaction = errors.New("no transaction to hold the lock")
+	// ErrLockTimeout is returned when a lock was not acquired within the lock timeout
+	// (see WithLockTimeout). It matches ErrRowLocked as well
+	ErrLockTimeout error = &kindError{kind: ErrRowLocked, err: errors.New("lock timeout exceeded")}
+	// ErrMigrationLockTimeout is returned when the migration lock was not acquired within
+	// the timeout (see WithMigrationLockTimeout). It matches ErrLockTimeout as well
+	ErrMigrationLockTimeout error = &kindError{kind: ErrLockTimeout, err: errors.New("migration lock timeout exceeded")}
+	// ErrStatementTimeout is returned when a statement was canceled, e.g. because it ran
+	// longer than the statement timeout (see WithStatementTimeout)
+	ErrStatementTimeout = errors.New("statement timeout exceeded")
+	// ErrCursorNotPersisted is returned by a streamer whose cursor could not be written for
+	// too long (see CursorWriteBuffer)
+	ErrCursorNotPersisted = errors.New("cursor is not persisted")
+	// ErrValueNotNumeric is returned when a numeric operation finds a value that is not
+	// an integer
+	ErrValueNotNumeric = errors.New("value is not numeric")
+	// ErrValueNotBinary is returned when a binary value is read by a key holding a text one
+	ErrValueNotBinary = errors.New("value is not binary")
+	// ErrInvalidJSON is returned when a value expected to be a JSON document is not one
+	ErrInvalidJSON = errors.New("value is not valid JSON")
+	// ErrEmptyPrefix is returned when a bulk operation is given an empty prefix, which
+	// would match every key
+	ErrEmptyPrefix = errors.New("prefix is empty")
+	// ErrLockBusy is returned by Locker.TryWithLock when the advisory lock is held by
+	// somebody else
+	ErrLockBusy = errors.New("advisory lock is busy")
+	// ErrDecryptFailed is returned when an encrypted value cannot be decrypted with any
+	// of the keys (see NewEncryptedKeyValueQ)
+	ErrDecryptFailed = errors.New("failed to decrypt value")
+	// ErrVersionConflict is returned when a value is written with a version other than
+	// the stored one, i.e. it was changed since it was read (see Versioner)
+	ErrVersionConflict = errors.New("version of the value conflicts")
+	// ErrStopStreaming is returned by a processing function to stop the streamer cleanly,
+	// e.g. once the downstream is in maintenance: the page of the entity is processed again
+	// by the next call, and the processing methods return nil (see Streamer)
+	ErrStopStreaming = errors.New("streaming is stopped")
+	// ErrDirectionMismatch is returned when a streamer finds a cursor written by a streamer
+	// taking pages in the other direction (see StreamDirection)
+	ErrDirectionMismatch = errors.New("direction of the cursor does not match the streamer")
+	// ErrPartitionsChanged is returned when the number of partitions of a partitioned
+	// streamer differs from the one the stream was split into before (see
+	// NewPartitionedStreamer)
+	ErrPartitionsChanged = errors.New("number of partitions of the streamer changed")
+	// ErrStreamerPaused is returned by the processing methods of a streamer paused with
+	// Streamer.Pause
+	ErrStreamerPaused = errors.New("streamer is paused")
+	// ErrCircuitOpen is matched by CircuitOpenError, returned by the processing methods of a
+	// streamer whose circuit breaker is open (see CircuitBreakerConfig)
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+	// ErrKeyCollision is matched by KeyCollisionError, returned when a streamer finds its
+	// cursor key taken by a streamer of other entities (see StreamerFingerprint)
+	ErrKeyCollision = errors.New("cursor key is taken by another streamer")
+	// ErrInterrupted is returned by a streamer once its Ctx is done in the middle of a list,
+	// after the progress is persisted. It wraps the error of the context, so it matches
+	// context.Canceled as well
+	ErrInterrupted = errors.New("streaming is interrupted")
+	// ErrSkipUpdate is returned by the function passed to Update to leave the value as it is
+	ErrSkipUpdate = errors.New("update is skipped")
+	// ErrNotCountable is returned by SkipToEnd when the Stream of the streamer does not
+	// implement Countable
+	ErrNotCountable = errors.New("stream is not countable")
+	// ErrUnorderedQuery is returned when a query to page through has no ORDER BY, as offset
+	// pagination of an unordered query skips or repeats rows (see NewSQLStreamable)
+	ErrUnorderedQuery = errors.New("query has no ORDER BY")
+)
+
+// Postgres error codes (SQLSTATE) the package classifies
+const (
+	sqlStateSerializationFailure = "40001"
+	sqlStateDeadlockDetected     = "40P01"
+	sqlStateLockNotAvailable     = "55P03"
+	sqlStateUniqueViolation      = "23505"
+	sqlStateReadOnlyTransaction  = "25006"
+	sqlStateStringTruncation     = "22001"
+	sqlStateQueryCanceled        = "57014"
+	// sqlStateConnectionClass is a class of connection exceptions, e.g. 08006
+	sqlStateConnectionClass = "08"
+)
+
+// RetryableError marks an error after which the failed operation could be safely retried
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// Cause makes errors.Cause look through RetryableError
+func (e *RetryableError) Cause() error {
+	return e.Err
+}
+
+// CorruptCursorError is returned when a stored streamer cursor cannot be parsed. It
+// matches ErrCorruptCursor
+type CorruptCursorError struct {
+	// Value is the raw value of the cursor
+	Value string
+	Err   error
+}
+
+func (e *CorruptCursorError) Error() string {
+	return fmt.Sprintf("cursor %q is corrupt: %s", e.Value, e.Err)
+}
+
+func (e *CorruptCursorError) Is(target error) bool {
+	return target == ErrCorruptCursor
+}
+
+func (e *CorruptCursorError) Unwrap() error {
+	return e.Err
+}
+
+// KeyError is an error of a single key of a batch operation
+type KeyError struct {
+	Key string
+	// Index is the position of the key in the batch
+	Index int
+	Err   error
+}
+
+func (e KeyError) Error() string {
+	return fmt.Sprintf("key %q (#%d): %s", e.Key, e.Index, e.Err)
+}
+
+func (e KeyError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError is returned by batch operations that could tell which of the keys failed.
+// It matches every error any of the keys failed with. Batch operations return it as is,
+// so that errors.As could extract it
+type BatchError struct {
+	Errors []KeyError
+}
+
+func (e *BatchError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d of keys failed: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+func (e *BatchError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if Is(err.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Keys returns the keys that failed in the order of the batch
+func (e *BatchError) Keys() []string {
+	keys := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		keys[i] = err.Key
+	}
+	return keys
+}
+
+// StreamStage is a stage of forming and processing a list a streamer failed at
+type StreamStage string
+
+const (
+	// StageSelect is selecting entities of a page
+	StageSelect StreamStage = "select"
+	// StageCursorRead is reading the cursor, including everything preceding it
+	StageCursorRead StreamStage = "cursor-read"
+	// StageCursorWrite is moving the cursor
+	StageCursorWrite StreamStage = "cursor-write"
+	// StageProcess is processing an entity
+	StageProcess StreamStage = "process"
+	// StagePrepare is the Preparation before the first page of a pass
+	StagePrepare StreamStage = "prepare"
+)
+
+// PanicError is returned when a processing function of a streamer panics
+type PanicError struct {
+	// Value is the value the function panicked with
+	Value interface{}
+	// Stack is the stack trace of the panic
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("processing function panicked: %v", e.Value)
+}
+
+// StreamError is returned by a streamer failing to form or process a list. It is
+// returned as is, so that errors.As could extract it
+type StreamError struct {
+	KeyValueKey string
+	// Page is the page the streamer failed on. It is 0 at StageCursorRead
+	Page uint64
+	// EntityIndex is the position of the failed entity in the page at StageProcess, -1 otherwise
+	EntityIndex int
+	Stage       StreamStage
+	Err         error
+}
+
+func (e *StreamError) Error() string {
+	if e.EntityIndex >= 0 {
+		return fmt.Sprintf("stream %q failed at %s of entity #%d of page %d: %s",
+			e.KeyValueKey, e.Stage, e.EntityIndex, e.Page, e.Err)
+	}
+	return fmt.Sprintf("stream %q failed at %s of page %d: %s", e.KeyValueKey, e.Stage, e.Page, e.Err)
+}
+
+// Fields returns the fields of the error for logging
+func (e *StreamError) Fields() logan.F {
+	fields := logan.F{
+		"key":   e.KeyValueKey,
+		"page":  e.Page,
+		"stage": e.Stage,
+	}
+	if e.EntityIndex >= 0 {
+		fields["entity_index"] = e.EntityIndex
+	}
+	return fields
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// Cause makes errors.Cause look through StreamError and logan log its Fields
+func (e *StreamError) Cause() error {
+	return errors.From(e.Err, e.Fields())
+}
+
+// kindError classifies err as one of the package errors without changing its message
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+// Is matches the kind and whatever the kind itself matches
+func (e *kindError) Is(target error) bool {
+	return target == e.kind || stderrors.Is(e.kind, target)
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+// Cause makes errors.Cause look through kindError
+func (e *kindError) Cause() error {
+	return e.err
+}
+
+// Is reports whether any error in the chain of err matches target. Unlike errors.Is
+// it also follows Cause, so it sees through errors wrapped by logan
+func Is(err, target error) bool {
+	return walk(err, func(err error) bool {
+		return err == target || stderrors.Is(err, target)
+	})
+}
+
+// IsNotFound reports whether err means that there is no value by a key
+func IsNotFound(err error) bool {
+	return Is(err, ErrNoValue) || Is(err, sql.ErrNoRows)
+}
+
+// IsRetryable reports whether the operation failed with err could be retried later, e.g.
+// by the caller of a whole batch: err is transient (see IsTransient), marked with
+// RetryableError, or a row lock was not acquired in time or a statement timed out
+func IsRetryable(err error) bool {
+	if IsTransient(err) {
+		return true
+	}
+	return walk(err, func(err error) bool {
+		if _, ok := err.(*RetryableError); ok {
+			return true
+		}
+		if stderrors.Is(err, ErrRowLocked) || stderrors.Is(err, ErrStatementTimeout) {
+			return true
+		}
+		return isRetryableSQLState(sqlState(err))
+	})
+}
+
+func isRetryableSQLState(state string) bool {
+	switch state {
+	case sqlStateSerializationFailure, sqlStateDeadlockDetected, sqlStateLockNotAvailable, sqlStateQueryCanceled:
+		return true
+	}
+	return strings.HasPrefix(state, sqlStateConnectionClass)
+}
+
+// sqlState returns SQLSTATE of the Postgres error reported by either pq or pgx
+func sqlState(err error) string {
+	if sqlErr, ok := err.(interface{ SQLState() string }); ok {
+		return sqlErr.SQLState()
+	}
+	return ""
+}
+
+// classifyPostgres maps a Postgres error onto the errors of the package
+func classifyPostgres(err error) error {
+	var state string
+	walk(err, func(err error) bool {
+		state = sqlState(err)
+		return state != ""
+	})
+
+	switch {
+	case state == sqlStateLockNotAvailable:
+		return &kindError{kind: ErrLockTimeout, err: err}
+	case state == sqlStateQueryCanceled:
+		return &kindError{kind: ErrStatementTimeout, err: err}
+	case state == sqlStateUniqueViolation:
+		return &kindError{kind: ErrDuplicateKey, err: err}
+	case state == sqlStateReadOnlyTransaction:
+		return &kindError{kind: ErrReadOnly, err: err}
+	case state == sqlStateStringTruncation:
+		return &kindError{kind: ErrValueTooLarge, err: err}
+	case isRetryableSQLState(state):
+		return &RetryableError{Err: err}
+	default:
+		return err
+	}
+}
+
+// walk calls fn for err and every error it wraps, following both Unwrap and Cause,
+// until fn returns true
+func walk(err error, fn func(error) bool) bool {
+	for err != nil {
+		if fn(err) {
+			return true
+		}
+
+		switch wrapper := err.(type) {
+		case interface{ Unwrap() error }:
+			err = wrapper.Unwrap()
+		case interface{ Cause() error }:
+			err = wrapper.Cause()
+		default:
+			return false
+		}
+	}
+	return false
+}
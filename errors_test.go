@@ -0,0 +1,76 @@
+package dban
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+func TestErrorClassification(t *testing.T) {
+	wrappers := map[string]func(error) error{
+		"bare":  func(err error) error { return err },
+		"logan": func(err error) error { return errors.Wrap(err, "failed", logan.F{"key": "cursor"}) },
+		"logan twice": func(err error) error {
+			return errors.Wrap(errors.Wrap(err, "inner"), "outer", logan.F{"key": "cursor"})
+		},
+		"fmt":  func(err error) error { return fmt.Errorf("failed: %w", err) },
+		"pkg":  func(err error) error { return pkgerrors.WithMessage(err, "failed") },
+		"from": func(err error) error { return errors.From(err, logan.F{"key": "cursor"}) },
+		"mixed": func(err error) error {
+			return errors.Wrap(fmt.Errorf("middle: %w", errors.Wrap(err, "inner")), "outer")
+		},
+	}
+
+	cases := []struct {
+		name      string
+		err       error
+		retryable bool
+		notFound  bool
+		kind      error
+	}{
+		{name: "no value", err: ErrNoValue, notFound: true, kind: ErrNoValue},
+		{name: "no rows", err: sql.ErrNoRows, notFound: true},
+		{name: "retryable", err: &RetryableError{Err: errors.New("try again")}, retryable: true},
+		{name: "bad conn", err: driver.ErrBadConn, retryable: true},
+		{name: "serialization", err: classifyPostgres(&pq.Error{Code: "40001"}), retryable: true},
+		{name: "deadlock", err: classifyPostgres(&pq.Error{Code: "40P01"}), retryable: true},
+		{name: "connection", err: classifyPostgres(&pq.Error{Code: "08006"}), retryable: true},
+		{name: "raw serialization", err: &pq.Error{Code: "40001"}, retryable: true},
+		{name: "lock not available", err: classifyPostgres(&pq.Error{Code: "55P03"}), retryable: true, kind: ErrRowLocked},
+		{name: "lock timeout", err: classifyPostgres(&pq.Error{Code: "55P03"}), retryable: true, kind: ErrLockTimeout},
+		{name: "statement timeout", err: classifyPostgres(&pq.Error{Code: "57014"}), retryable: true, kind: ErrStatementTimeout},
+		{name: "bare lock timeout", err: ErrLockTimeout, retryable: true, kind: ErrRowLocked},
+		{name: "unique violation", err: classifyPostgres(&pq.Error{Code: "23505"}), kind: ErrDuplicateKey},
+		{name: "read only", err: classifyPostgres(&pq.Error{Code: "25006"}), kind: ErrReadOnly},
+		{name: "too large", err: classifyPostgres(&pq.Error{Code: "22001"}), kind: ErrValueTooLarge},
+		{name: "syntax error", err: classifyPostgres(&pq.Error{Code: "42601"})},
+		{name: "corrupt cursor", err: &CorruptCursorError{Value: "1O", Err: errors.New("bad")}, kind: ErrCorruptCursor},
+		{name: "plain", err: errors.New("plain")},
+	}
+
+	for _, tc := range cases {
+		for wrapperName, wrap := range wrappers {
+			t.Run(tc.name+"/"+wrapperName, func(t *testing.T) {
+				err := wrap(tc.err)
+				assert.Equal(t, tc.retryable, IsRetryable(err))
+				assert.Equal(t, tc.notFound, IsNotFound(err))
+				if tc.kind != nil {
+					assert.True(t, Is(err, tc.kind))
+				}
+				assert.Contains(t, err.Error(), tc.err.Error(), "message must be preserved")
+			})
+		}
+	}
+
+	assert.False(t, IsRetryable(nil))
+	assert.False(t, IsNotFound(nil))
+	assert.Equal(t, &pq.Error{Code: "23505"}, errors.Cause(errors.Wrap(classifyPostgres(&pq.Error{Code: "23505"}), "failed")),
+		"classification must not hide the cause")
+}
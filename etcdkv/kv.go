@@ -0,0 +1,212 @@
+// Package etcdkv implements dban.KeyValueQ on top of etcd
+package etcdkv
+
+import (
+	"context"
+	"strings"
+
+	"github.com/zspkg/dban"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+type keyValueQ struct {
+	client    *clientv3.Client
+	keyPrefix string
+	filters   dban.KeyValueFilters
+}
+
+// NewKeyValueQ creates a new instance of a key value querier storing values in etcd
+// under keys prefixed with keyPrefix.
+//
+// LockingGet is advisory: it reads the key like Get does, as a lease-based lock could
+// not be released at the end of a transaction the querier knows nothing about
+func NewKeyValueQ(client *clientv3.Client, keyPrefix string) dban.KeyValueQ {
+	return &keyValueQ{
+		client:    client,
+		keyPrefix: keyPrefix,
+	}
+}
+
+func (q *keyValueQ) New() dban.KeyValueQ {
+	return NewKeyValueQ(q.client, q.keyPrefix)
+}
+
+func (q *keyValueQ) Get(key string) (*dban.KeyValue, error) {
+	resp, err := q.client.Get(context.Background(), q.keyPrefix+key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get value from etcd", logan.F{"key": key})
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+
+	return &dban.KeyValue{Key: key, Value: string(resp.Kvs[0].Value)}, nil
+}
+
+// GetMany gets the keys in a single etcd transaction
+func (q *keyValueQ) GetMany(keys []string) (map[string]dban.KeyValue, error) {
+	values := make(map[string]dban.KeyValue, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	ops := make([]clientv3.Op, len(keys))
+	for i, key := range keys {
+		ops[i] = clientv3.OpGet(q.keyPrefix + key)
+	}
+	resp, err := q.client.Txn(context.Background()).Then(ops...).Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get values from etcd", logan.F{"keys": keys})
+	}
+
+	for i, op := range resp.Responses {
+		if kvs := op.GetResponseRange().Kvs; len(kvs) != 0 {
+			values[keys[i]] = dban.KeyValue{Key: keys[i], Value: string(kvs[0].Value)}
+		}
+	}
+	return values, nil
+}
+
+func (q *keyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	resp, err := q.client.Get(context.Background(), q.keyPrefix+prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get values from etcd", logan.F{"prefix": prefix})
+	}
+
+	values := make([]dban.KeyValue, len(resp.Kvs))
+	for i, kv := range resp.Kvs {
+		values[i] = dban.KeyValue{Key: strings.TrimPrefix(string(kv.Key), q.keyPrefix), Value: string(kv.Value)}
+	}
+	return dban.PageKeyValues(values, params), nil
+}
+
+// ListKeys gets the keys only, leaving the values in etcd
+func (q *keyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	resp, err := q.client.Get(context.Background(), q.keyPrefix+prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get keys from etcd", logan.F{"prefix": prefix})
+	}
+
+	keys := make([]string, len(resp.Kvs))
+	for i, kv := range resp.Kvs {
+		keys[i] = strings.TrimPrefix(string(kv.Key), q.keyPrefix)
+	}
+	return dban.PageKeys(keys, params), nil
+}
+
+func (q *keyValueQ) Count() (uint64, error) {
+	resp, err := q.client.Get(context.Background(), q.keyPrefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count keys in etcd")
+	}
+	return uint64(resp.Count), nil
+}
+
+func (q *keyValueQ) FilterByKeys(keys ...string) dban.KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByKeys(keys...)
+	return &filtered
+}
+
+func (q *keyValueQ) FilterByPrefix(prefix string) dban.KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByKeyPrefix(prefix)
+	return &filtered
+}
+
+func (q *keyValueQ) FilterByValueLike(pattern string) dban.KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByValueLike(pattern)
+	return &filtered
+}
+
+func (q *keyValueQ) Select() ([]dban.KeyValue, error) {
+	return dban.SelectFiltered(q, q.filters, nil)
+}
+
+func (q *keyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	return dban.SelectFiltered(q, q.filters, &params)
+}
+
+func (q *keyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	return q.SelectPage(dban.KeyValueStreamParams(params))
+}
+
+func (q *keyValueQ) MustGet(key string) *dban.KeyValue {
+	value, err := dban.GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+func (q *keyValueQ) Upsert(kv dban.KeyValue) error {
+	if _, err := q.client.Put(context.Background(), q.keyPrefix+kv.Key, kv.Value); err != nil {
+		return errors.Wrap(err, "failed to put value to etcd", logan.F{"key": kv.Key})
+	}
+	return nil
+}
+
+// UpsertMany puts the values in a single etcd transaction
+func (q *keyValueQ) UpsertMany(kvs []dban.KeyValue) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+
+	ops := make([]clientv3.Op, len(kvs))
+	for i, kv := range kvs {
+		ops[i] = clientv3.OpPut(q.keyPrefix+kv.Key, kv.Value)
+	}
+	if _, err := q.client.Txn(context.Background()).Then(ops...).Commit(); err != nil {
+		return errors.Wrap(err, "failed to put values to etcd", logan.F{"count": len(kvs)})
+	}
+	return nil
+}
+
+func (q *keyValueQ) Delete(key string) error {
+	_, err := q.DeleteMany([]string{key})
+	return err
+}
+
+// DeleteMany deletes the keys in a single etcd transaction
+func (q *keyValueQ) DeleteMany(keys []string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	ops := make([]clientv3.Op, len(keys))
+	for i, key := range keys {
+		ops[i] = clientv3.OpDelete(q.keyPrefix + key)
+	}
+	resp, err := q.client.Txn(context.Background()).Then(ops...).Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete values from etcd", logan.F{"keys": keys})
+	}
+
+	var deleted int64
+	for _, op := range resp.Responses {
+		deleted += op.GetResponseDeleteRange().Deleted
+	}
+	return deleted, nil
+}
+
+// Update fails with dban.ErrNoTransaction, as LockingGet does not lock the key
+func (q *keyValueQ) Update(key string, fn func(current *string) (string, error)) (dban.KeyValue, error) {
+	return dban.KeyValue{}, errors.From(dban.ErrNoTransaction, logan.F{"key": key})
+}
+
+// LockingGet is the same as Get, see NewKeyValueQ
+func (q *keyValueQ) LockingGet(key string) (*dban.KeyValue, error) {
+	return q.Get(key)
+}
+
+func (q *keyValueQ) MustLockingGet(key string) *dban.KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
@@ -0,0 +1,86 @@
+package etcdkv
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+func freeURL(t *testing.T) url.URL {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return url.URL{Scheme: "http", Host: listener.Addr().String()}
+}
+
+func newTestClient(t *testing.T) *clientv3.Client {
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	peer, client := freeURL(t), freeURL(t)
+	cfg.LPUrls, cfg.APUrls = []url.URL{peer}, []url.URL{peer}
+	cfg.LCUrls, cfg.ACUrls = []url.URL{client}, []url.URL{client}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	server, err := embed.StartEtcd(cfg)
+	require.NoError(t, err)
+	t.Cleanup(server.Close)
+
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd did not start in time")
+	}
+
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{client.String()}, DialTimeout: 5 * time.Second})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = cli.Close() })
+	return cli
+}
+
+func TestKeyValueQ(t *testing.T) {
+	client := newTestClient(t)
+	kvQ := NewKeyValueQ(client, "dban/")
+
+	kv, err := kvQ.Get("missing")
+	require.NoError(t, err)
+	assert.Nil(t, kv)
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "foo", Value: "bar"}))
+	require.NoError(t, kvQ.New().Upsert(dban.KeyValue{Key: "foo", Value: "baz"}))
+	assert.Equal(t, &dban.KeyValue{Key: "foo", Value: "baz"}, kvQ.MustGet("foo"))
+	assert.Equal(t, &dban.KeyValue{Key: "foo", Value: "baz"}, kvQ.MustLockingGet("foo"))
+
+	resp, err := client.Get(context.Background(), "dban/foo")
+	require.NoError(t, err)
+	require.Len(t, resp.Kvs, 1)
+	assert.Equal(t, "baz", string(resp.Kvs[0].Value))
+}
+
+func TestStreamerCursor(t *testing.T) {
+	kvQ := NewKeyValueQ(newTestClient(t), "dban/")
+
+	batchSize := uint64(2)
+	streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3}),
+		KeyValueQ:   kvQ,
+		KeyValueKey: "cursor",
+		BatchSize:   &batchSize,
+	})
+
+	for _, expected := range [][]int{{1, 2}, {3}, {1, 2}} {
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, expected, list)
+	}
+	assert.Equal(t, &dban.KeyValue{Key: "cursor", Value: "1"}, kvQ.MustGet("cursor"))
+}
@@ -0,0 +1,141 @@
+package dban
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultEventBuffer is a size of the buffer of AsyncEventSink created by WithEventSink
+// and StreamerInitParams.EventSink for sinks that are not asynchronous yet
+const defaultEventBuffer = 1024
+
+// Event is one of the events emitted by the key value querier and the streamer:
+// KVWritten, KVDeleted, BatchStarted, BatchCompleted, CursorReset or EndOfStream
+type Event interface {
+	event()
+}
+
+// KVWritten is emitted when a value is written by the key
+type KVWritten struct {
+	Key string
+}
+
+// KVDeleted is emitted when a value is deleted by the key
+type KVDeleted struct {
+	Key string
+}
+
+// BatchStarted is emitted when the streamer starts processing a page
+type BatchStarted struct {
+	Key  string
+	Page uint64
+}
+
+// BatchCompleted is emitted when the streamer is done with a page. Failed is the number
+// of entities that failed to be processed, which stops processing of the page unless
+// they are skipped (see EntityErrorPolicy). DeadLettered is the number of entities handed
+// to OnDeadLetter instead, while Filtered is the number of entities Filter did not accept.
+// CursorPersistDeferred is set if the cursor past the page is not written yet (see
+// CursorWriteBuffer)
+type BatchCompleted struct {
+	Key                   string
+	Page                  uint64
+	Processed             int
+	Failed                int
+	DeadLettered          int
+	Filtered              int
+	Duration              time.Duration
+	CursorPersistDeferred bool
+}
+
+// CursorReset is emitted when the streamer moves its cursor back to the first page
+type CursorReset struct {
+	Key string
+}
+
+// EndOfStream is emitted when the streamer reaches an end of the stream
+type EndOfStream struct {
+	Key string
+}
+
+func (KVWritten) event()      {}
+func (KVDeleted) event()      {}
+func (BatchStarted) event()   {}
+func (BatchCompleted) event() {}
+func (CursorReset) event()    {}
+func (EndOfStream) event()    {}
+
+// EventSink receives events, e.g. to export them to an audit pipeline
+type EventSink interface {
+	Emit(event Event)
+}
+
+// AsyncEventSink passes events to a sink from a separate goroutine through a bounded
+// buffer. Events emitted while the buffer is full are dropped and counted, so that a
+// slow sink cannot stall processing
+type AsyncEventSink struct {
+	sink    EventSink
+	events  chan Event
+	dropped uint64
+	done    chan struct{}
+	close   sync.Once
+}
+
+// NewAsyncEventSink creates an AsyncEventSink buffering up to buffer events for sink
+func NewAsyncEventSink(sink EventSink, buffer int) *AsyncEventSink {
+	s := &AsyncEventSink{
+		sink:   sink,
+		events: make(chan Event, buffer),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+		for event := range s.events {
+			s.sink.Emit(event)
+		}
+	}()
+
+	return s
+}
+
+// Emit queues the event or drops it if the buffer is full. It must not be called after Close
+func (s *AsyncEventSink) Emit(event Event) {
+	select {
+	case s.events <- event:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Dropped returns the number of events dropped because the buffer was full
+func (s *AsyncEventSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops accepting events and waits until the buffered ones are passed to the sink
+func (s *AsyncEventSink) Close() {
+	s.close.Do(func() {
+		close(s.events)
+	})
+	<-s.done
+}
+
+// asyncEventSink makes sink asynchronous unless it is already or is nil
+func asyncEventSink(sink EventSink) EventSink {
+	switch sink.(type) {
+	case nil, *AsyncEventSink:
+		return sink
+	default:
+		return NewAsyncEventSink(sink, defaultEventBuffer)
+	}
+}
+
+// WithEventSink makes the querier emit events to sink. A sink other than AsyncEventSink
+// is wrapped into one with a buffer of 1024 events
+func WithEventSink(sink EventSink) KeyValueQOption {
+	return func(q *keyValueQ) {
+		q.events = asyncEventSink(sink)
+	}
+}
@@ -0,0 +1,86 @@
+package dban_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+type collectingSink struct {
+	mu      sync.Mutex
+	events  []dban.Event
+	release chan struct{}
+}
+
+func (s *collectingSink) Emit(event dban.Event) {
+	if s.release != nil {
+		<-s.release
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+}
+
+func TestStreamerEvents(t *testing.T) {
+	collected := &collectingSink{}
+	sink := dban.NewAsyncEventSink(collected, 16)
+	batchSize := uint64(2)
+	streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3}),
+		KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+		KeyValueKey: cursorKey,
+		BatchSize:   &batchSize,
+		EventSink:   sink,
+	})
+
+	failure := errors.New("failed")
+	for _, fail := range []bool{false, false, true} {
+		_ = streamer.FormListAndProcess(func(_ context.Context, item int) error {
+			if fail && item == 2 {
+				return failure
+			}
+			return nil
+		})
+	}
+	sink.Close()
+
+	require.Len(t, collected.events, 8)
+	assert.Equal(t, dban.BatchStarted{Key: cursorKey, Page: 0}, collected.events[0])
+	assert.Equal(t, dban.BatchStarted{Key: cursorKey, Page: 1}, collected.events[2])
+	assert.Equal(t, dban.EndOfStream{Key: cursorKey}, collected.events[4])
+	assert.Equal(t, dban.CursorReset{Key: cursorKey}, collected.events[5])
+	assert.Equal(t, dban.BatchStarted{Key: cursorKey, Page: 0}, collected.events[6])
+
+	for i, expected := range map[int]dban.BatchCompleted{
+		1: {Key: cursorKey, Page: 0, Processed: 2},
+		3: {Key: cursorKey, Page: 1, Processed: 1},
+		7: {Key: cursorKey, Page: 0, Processed: 1, Failed: 1},
+	} {
+		completed, ok := collected.events[i].(dban.BatchCompleted)
+		require.True(t, ok, "%d: %T", i, collected.events[i])
+		completed.Duration = 0
+		assert.Equal(t, expected, completed, i)
+	}
+}
+
+func TestAsyncEventSinkDrops(t *testing.T) {
+	collected := &collectingSink{release: make(chan struct{})}
+	sink := dban.NewAsyncEventSink(collected, 2)
+
+	const emitted = 10
+	for i := 0; i < emitted; i++ {
+		sink.Emit(dban.KVWritten{Key: "cursor"})
+	}
+	// one event is held by the blocked sink and two more fit into the buffer
+	assert.GreaterOrEqual(t, sink.Dropped(), uint64(emitted-3))
+
+	close(collected.release)
+	sink.Close()
+	assert.Equal(t, emitted, len(collected.events)+int(sink.Dropped()))
+}
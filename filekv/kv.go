@@ -0,0 +1,309 @@
+// Package filekv implements dban.KeyValueQ over a JSON file for local development
+package filekv
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/zspkg/dban"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// rename is replaced in tests to simulate a crash in the middle of a write
+var rename = os.Rename
+
+type store struct {
+	path   string
+	mu     sync.Mutex
+	values map[string]string
+}
+
+type keyValueQ struct {
+	store   *store
+	filters dban.KeyValueFilters
+}
+
+// NewKeyValueQ creates a new instance of a key value querier keeping values in a JSON
+// object stored at path. The file is created on the first write if it does not exist.
+//
+// Values are served from memory and the file is rewritten atomically on every write,
+// so it always holds either the previous or the new state. LockingGet is guarded by an
+// in-process mutex only: sharing the file between several processes is not supported
+func NewKeyValueQ(path string) (dban.KeyValueQ, error) {
+	values, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &keyValueQ{
+		store: &store{path: path, values: values},
+	}, nil
+}
+
+func load(path string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return values, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read key value file", logan.F{"path": path})
+	}
+
+	if err = json.Unmarshal(raw, &values); err != nil {
+		fields := logan.F{"path": path}
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			fields["offset"] = syntaxErr.Offset
+		}
+		return nil, errors.Wrap(err, "key value file is corrupt", fields)
+	}
+
+	return values, nil
+}
+
+func (q *keyValueQ) New() dban.KeyValueQ {
+	return &keyValueQ{store: q.store}
+}
+
+func (q *keyValueQ) Get(key string) (*dban.KeyValue, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	value, ok := q.store.values[key]
+	if !ok {
+		return nil, nil
+	}
+	return &dban.KeyValue{Key: key, Value: value}, nil
+}
+
+func (q *keyValueQ) GetMany(keys []string) (map[string]dban.KeyValue, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	values := make(map[string]dban.KeyValue, len(keys))
+	for _, key := range keys {
+		if value, ok := q.store.values[key]; ok {
+			values[key] = dban.KeyValue{Key: key, Value: value}
+		}
+	}
+	return values, nil
+}
+
+func (q *keyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	var values []dban.KeyValue
+	for key, value := range q.store.values {
+		if strings.HasPrefix(key, prefix) {
+			values = append(values, dban.KeyValue{Key: key, Value: value})
+		}
+	}
+	return dban.PageKeyValues(values, params), nil
+}
+
+func (q *keyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	var keys []string
+	for key := range q.store.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return dban.PageKeys(keys, params), nil
+}
+
+func (q *keyValueQ) Count() (uint64, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	return uint64(len(q.store.values)), nil
+}
+
+func (q *keyValueQ) FilterByKeys(keys ...string) dban.KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByKeys(keys...)
+	return &filtered
+}
+
+func (q *keyValueQ) FilterByPrefix(prefix string) dban.KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByKeyPrefix(prefix)
+	return &filtered
+}
+
+func (q *keyValueQ) FilterByValueLike(pattern string) dban.KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByValueLike(pattern)
+	return &filtered
+}
+
+func (q *keyValueQ) Select() ([]dban.KeyValue, error) {
+	return dban.SelectFiltered(q, q.filters, nil)
+}
+
+func (q *keyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	return dban.SelectFiltered(q, q.filters, &params)
+}
+
+func (q *keyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	return q.SelectPage(dban.KeyValueStreamParams(params))
+}
+
+func (q *keyValueQ) MustGet(key string) *dban.KeyValue {
+	value, err := dban.GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+func (q *keyValueQ) Upsert(kv dban.KeyValue) error {
+	if err := q.UpsertMany([]dban.KeyValue{kv}); err != nil {
+		return errors.Wrap(err, "failed to upsert value", logan.F{"key": kv.Key})
+	}
+	return nil
+}
+
+// UpsertMany rewrites the file once for the whole batch
+func (q *keyValueQ) UpsertMany(kvs []dban.KeyValue) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	values := make(map[string]string, len(q.store.values)+len(kvs))
+	for key, value := range q.store.values {
+		values[key] = value
+	}
+	for _, kv := range kvs {
+		values[kv.Key] = kv.Value
+	}
+
+	if err := q.store.persist(values); err != nil {
+		return errors.Wrap(err, "failed to persist values", logan.F{"count": len(kvs)})
+	}
+
+	q.store.values = values
+	return nil
+}
+
+func (q *keyValueQ) Delete(key string) error {
+	_, err := q.DeleteMany([]string{key})
+	return err
+}
+
+func (q *keyValueQ) DeleteMany(keys []string) (int64, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	values := make(map[string]string, len(q.store.values))
+	for key, value := range q.store.values {
+		values[key] = value
+	}
+	var deleted int64
+	for _, key := range keys {
+		if _, ok := values[key]; ok {
+			delete(values, key)
+			deleted++
+		}
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+
+	if err := q.store.persist(values); err != nil {
+		return 0, errors.Wrap(err, "failed to persist deletion", logan.F{"keys": keys})
+	}
+
+	q.store.values = values
+	return deleted, nil
+}
+
+// Update calls fn holding the mutex of the store, so fn must not use the querier
+func (q *keyValueQ) Update(key string, fn func(current *string) (string, error)) (dban.KeyValue, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	var current *string
+	if value, ok := q.store.values[key]; ok {
+		current = &value
+	}
+	next, err := fn(current)
+	if dban.Is(err, dban.ErrSkipUpdate) {
+		if current == nil {
+			return dban.KeyValue{}, nil
+		}
+		return dban.KeyValue{Key: key, Value: *current}, nil
+	}
+	if err != nil {
+		return dban.KeyValue{}, err
+	}
+
+	values := make(map[string]string, len(q.store.values)+1)
+	for key, value := range q.store.values {
+		values[key] = value
+	}
+	values[key] = next
+	if err = q.store.persist(values); err != nil {
+		return dban.KeyValue{}, errors.Wrap(err, "failed to persist value", logan.F{"key": key})
+	}
+
+	q.store.values = values
+	return dban.KeyValue{Key: key, Value: next}, nil
+}
+
+// LockingGet is the same as Get, as there are no transactions to hold a lock until
+func (q *keyValueQ) LockingGet(key string) (*dban.KeyValue, error) {
+	return q.Get(key)
+}
+
+func (q *keyValueQ) MustLockingGet(key string) *dban.KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
+
+// persist writes values into a temporary file next to the store and renames it over
+// the store, so that a crash never leaves a partially written file behind
+func (s *store) persist(values map[string]string) error {
+	raw, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal values")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(raw); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "failed to write temporary file")
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "failed to sync temporary file")
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to close temporary file")
+	}
+
+	if err = rename(tmp.Name(), s.path); err != nil {
+		return errors.Wrap(err, "failed to replace key value file")
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package filekv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+func TestKeyValueQ(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.json")
+
+	kvQ, err := NewKeyValueQ(path)
+	require.NoError(t, err)
+
+	kv, err := kvQ.Get("missing")
+	require.NoError(t, err)
+	assert.Nil(t, kv)
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "foo", Value: "bar"}))
+	require.NoError(t, kvQ.New().Upsert(dban.KeyValue{Key: "foo", Value: "baz"}))
+	assert.Equal(t, &dban.KeyValue{Key: "foo", Value: "baz"}, kvQ.MustLockingGet("foo"))
+
+	reopened, err := NewKeyValueQ(path)
+	require.NoError(t, err)
+	assert.Equal(t, &dban.KeyValue{Key: "foo", Value: "baz"}, reopened.MustGet("foo"))
+}
+
+func TestKeyValueQDelete(t *testing.T) {
+	kvQ, err := NewKeyValueQ(filepath.Join(t.TempDir(), "kv.json"))
+	require.NoError(t, err)
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "foo", Value: "bar"}))
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "baz", Value: "qux"}))
+	require.NoError(t, kvQ.Delete("foo"))
+	require.NoError(t, kvQ.Delete("foo"), "deleting a missing key is not an error")
+	_, err = dban.GetStrict(kvQ, "foo")
+	assert.True(t, dban.Is(err, dban.ErrNoSuchKey))
+
+	deleted, err := kvQ.DeleteMany([]string{"baz", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	_, err = dban.GetStrict(kvQ, "baz")
+	assert.True(t, dban.Is(err, dban.ErrNoSuchKey))
+
+	deleted, err = kvQ.DeleteMany(nil)
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+}
+
+func TestKeyValueQUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.json")
+	kvQ, err := NewKeyValueQ(path)
+	require.NoError(t, err)
+
+	updated, err := kvQ.Update("doc", func(current *string) (string, error) {
+		assert.Nil(t, current, "a missing value must be passed as nil")
+		return "1", nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, dban.KeyValue{Key: "doc", Value: "1"}, updated)
+
+	updated, err = kvQ.Update("doc", func(*string) (string, error) { return "", dban.ErrSkipUpdate })
+	require.NoError(t, err)
+	assert.Equal(t, dban.KeyValue{Key: "doc", Value: "1"}, updated, "a skipped update must return the current value")
+
+	reopened, err := NewKeyValueQ(path)
+	require.NoError(t, err)
+	assert.Equal(t, "1", reopened.MustGet("doc").Value, "the update must be persisted")
+}
+
+func TestKeyValueQCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"foo": "bar",}`), 0o644))
+
+	_, err := NewKeyValueQ(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "corrupt")
+	assert.Equal(t, int64(15), errors.GetFields(err)["offset"])
+}
+
+func TestKeyValueQCrashSafety(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kv.json")
+
+	kvQ, err := NewKeyValueQ(path)
+	require.NoError(t, err)
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "cursor", Value: "1"}))
+	before, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	rename = func(string, string) error { return os.ErrPermission }
+	t.Cleanup(func() { rename = os.Rename })
+
+	require.Error(t, kvQ.Upsert(dban.KeyValue{Key: "cursor", Value: "2"}))
+	assert.Equal(t, &dban.KeyValue{Key: "cursor", Value: "1"}, kvQ.MustGet("cursor"), "failed write must not be visible")
+
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary file must be cleaned up")
+}
+
+func TestStreamerCursor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.json")
+	kvQ, err := NewKeyValueQ(path)
+	require.NoError(t, err)
+
+	batchSize := uint64(2)
+	streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3}),
+		KeyValueQ:   kvQ,
+		KeyValueKey: "cursor",
+		BatchSize:   &batchSize,
+	})
+
+	for _, expected := range [][]int{{1, 2}, {3}, {1, 2}} {
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, expected, list)
+	}
+
+	reopened, err := NewKeyValueQ(path)
+	require.NoError(t, err)
+	assert.Equal(t, &dban.KeyValue{Key: "cursor", Value: "1"}, reopened.MustGet("cursor"))
+}
@@ -0,0 +1,84 @@
+package golangmigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/golang-migrate/migrate/v4/source"
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/zspkg/dban"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// NewKVMigratorFromDir creates a migrator applying migrations of the golang-migrate layout
+// (<version>_<title>.up.sql and <version>_<title>.down.sql) read from dir. Migration of
+// version N titled T is tracked by sql-migrate as "N_T.sql", so versions keep their order.
+// Every file is executed as a single (possibly multi-statement) query
+func NewKVMigratorFromDir(db *sql.DB, log *logan.Entry, dir string, opts ...dban.KVMigratorOption) (dban.KeyValueMigrator, error) {
+	migrations, err := readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return dban.NewKVMigratorFromSource(db, log, migrations, opts...), nil
+}
+
+// readDir reads migrations of the golang-migrate layout from dir
+func readDir(dir string) (*migrate.MemoryMigrationSource, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read migrations directory", logan.F{"dir": dir})
+	}
+
+	byVersion := make(map[uint]*migrate.Migration)
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		// golang-migrate ignores files that are not migrations as well
+		parsed, err := source.Parse(file.Name())
+		if err != nil {
+			continue
+		}
+		fields := logan.F{"dir": dir, "file": file.Name()}
+		content, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read migration", fields)
+		}
+
+		id := fmt.Sprintf("%d_%s.sql", parsed.Version, parsed.Identifier)
+		migration, ok := byVersion[parsed.Version]
+		if !ok {
+			migration = &migrate.Migration{Id: id}
+			byVersion[parsed.Version] = migration
+		}
+		if migration.Id != id {
+			return nil, errors.From(errors.New("version is used by several migrations"), fields)
+		}
+
+		statements := &migration.Up
+		if parsed.Direction == source.Down {
+			statements = &migration.Down
+		}
+		if len(*statements) != 0 {
+			return nil, errors.From(errors.New("migration is duplicated"), fields)
+		}
+		*statements = []string{string(content)}
+	}
+
+	migrations := make([]*migrate.Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if len(migration.Up) == 0 {
+			return nil, errors.From(errors.New("migration has no up file"), logan.F{
+				"dir":          dir,
+				"migration_id": migration.Id,
+			})
+		}
+		migrations = append(migrations, migration)
+	}
+
+	return &migrate.MemoryMigrationSource{Migrations: migrations}, nil
+}
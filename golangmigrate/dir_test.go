@@ -0,0 +1,70 @@
+package golangmigrate
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeMigrationFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	return dir
+}
+
+func TestReadDir(t *testing.T) {
+	src, err := readDir(writeMigrationFiles(t, map[string]string{
+		"2_second.up.sql":   "create table second (id integer);",
+		"2_second.down.sql": "drop table second;",
+		"10_tenth.up.sql":   "create table tenth (id integer);",
+		"1_first.up.sql":    "create table first (id integer);",
+		"README.md":         "not a migration",
+	}))
+	require.NoError(t, err)
+
+	migrations, err := src.FindMigrations()
+	require.NoError(t, err)
+	var ids []string
+	for _, migration := range migrations {
+		ids = append(ids, migration.Id)
+	}
+	assert.Equal(t, []string{"1_first.sql", "2_second.sql", "10_tenth.sql"}, ids)
+	assert.Equal(t, []string{"drop table second;"}, migrations[1].Down)
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	db.SetMaxOpenConns(1)
+
+	applied, err := migrate.Exec(db, "sqlite3", src, migrate.Up)
+	require.NoError(t, err)
+	assert.Equal(t, 3, applied)
+	_, err = db.Exec("insert into tenth (id) values (1)")
+	assert.NoError(t, err)
+}
+
+func TestNewKVMigratorFromDir(t *testing.T) {
+	migrator, err := NewKVMigratorFromDir(nil, nil, writeMigrationFiles(t, map[string]string{
+		"1_first.up.sql": "create table first (id integer);",
+	}))
+	require.NoError(t, err)
+	assert.NotNil(t, migrator)
+
+	for name, files := range map[string]map[string]string{
+		"no up file":       {"1_first.down.sql": "drop table first;"},
+		"version conflict": {"1_first.up.sql": "", "1_other.up.sql": ""},
+	} {
+		_, err = NewKVMigratorFromDir(nil, nil, writeMigrationFiles(t, files))
+		assert.Error(t, err, name)
+	}
+	_, err = NewKVMigratorFromDir(nil, nil, filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
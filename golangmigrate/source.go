@@ -0,0 +1,169 @@
+// Package golangmigrate lets services using golang-migrate apply the key value migrations with
+// it, as well as apply migrations of the golang-migrate layout with dban.KeyValueMigrator
+package golangmigrate
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4/source"
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/zspkg/dban"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// featureVersionStep separates golang-migrate versions of the features: migration n of the
+// i-th feature of dban.Features (counting from 1) gets version i*featureVersionStep+n
+const featureVersionStep = 1000
+
+// migrationVersion matches the numeric version prefix of the embedded migrations
+var migrationVersion = regexp.MustCompile(`^(\d+)_[\w-]+\.sql$`)
+
+// migrationSource is a golang-migrate source driver serving migrations from memory
+type migrationSource struct {
+	migrations *source.Migrations
+	up, down   map[uint]string
+}
+
+// Source exposes the embedded migrations and the ones of the features as a golang-migrate
+// source. Versions are derived from the numeric prefixes of the files: base migration
+// 001_key_value.sql gets version 1, while migration n of the i-th feature (in the order of
+// dban.Features: FeatureTimestamps, FeatureTTL, FeatureHistory, FeatureBinary, FeatureJSON,
+// FeatureKeyCheck, FeatureVersion, FeatureDeadLetters, FeatureSoftDelete) gets version
+// i*1000+n, e.g. ttl_001_expires_at.sql gets 2001.
+// golang-migrate applies versions above the current one only, so features could be enabled
+// later only if they follow the ones already applied in that order
+func Source(features ...dban.Feature) (source.Driver, error) {
+	selected := make(map[dban.Feature]migrate.MigrationSource, len(features))
+	for _, feature := range features {
+		migrations, err := dban.FeatureMigrations(feature)
+		if err != nil {
+			return nil, err
+		}
+		selected[feature] = migrations
+	}
+
+	src := &migrationSource{
+		migrations: source.NewMigrations(),
+		up:         make(map[uint]string),
+		down:       make(map[uint]string),
+	}
+	if err := src.add(dban.Migrations(), 0, ""); err != nil {
+		return nil, err
+	}
+	for i, feature := range dban.Features() {
+		if migrations, ok := selected[feature]; ok {
+			base := uint(i+1) * featureVersionStep
+			if err := src.add(migrations, base, string(feature)+"_"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return src, nil
+}
+
+// add adds migrations of the sql-migrate source, versioned by their numeric prefix
+// following idPrefix plus base
+func (s *migrationSource) add(src migrate.MigrationSource, base uint, idPrefix string) error {
+	migrations, err := src.FindMigrations()
+	if err != nil {
+		return errors.Wrap(err, "failed to find migrations")
+	}
+
+	for _, migration := range migrations {
+		fields := logan.F{"migration_id": migration.Id}
+		match := migrationVersion.FindStringSubmatch(strings.TrimPrefix(migration.Id, idPrefix))
+		if match == nil {
+			return errors.From(errors.New("migration has no version"), fields)
+		}
+		n, err := strconv.ParseUint(match[1], 10, 32)
+		if err != nil || n >= featureVersionStep {
+			return errors.From(errors.New("migration version is out of range"), fields)
+		}
+
+		version := base + uint(n)
+		s.up[version] = joinStatements(migration.Up)
+		s.migrations.Append(&source.Migration{
+			Version: version, Identifier: migration.Id, Direction: source.Up,
+		})
+		if len(migration.Down) != 0 {
+			s.down[version] = joinStatements(migration.Down)
+			s.migrations.Append(&source.Migration{
+				Version: version, Identifier: migration.Id, Direction: source.Down,
+			})
+		}
+	}
+
+	return nil
+}
+
+// joinStatements joins statements of a migration into a single multi-statement query
+func joinStatements(statements []string) string {
+	var query strings.Builder
+	for _, statement := range statements {
+		statement = strings.TrimSpace(statement)
+		query.WriteString(statement)
+		if !strings.HasSuffix(statement, ";") {
+			query.WriteString(";")
+		}
+		query.WriteString("\n")
+	}
+	return query.String()
+}
+
+func (s *migrationSource) Open(string) (source.Driver, error) {
+	return s, nil
+}
+
+func (s *migrationSource) Close() error {
+	return nil
+}
+
+func (s *migrationSource) First() (uint, error) {
+	version, ok := s.migrations.First()
+	if !ok {
+		return 0, notExist("first")
+	}
+	return version, nil
+}
+
+func (s *migrationSource) Prev(version uint) (uint, error) {
+	prev, ok := s.migrations.Prev(version)
+	if !ok {
+		return 0, notExist(fmt.Sprintf("prev for version %d", version))
+	}
+	return prev, nil
+}
+
+func (s *migrationSource) Next(version uint) (uint, error) {
+	next, ok := s.migrations.Next(version)
+	if !ok {
+		return 0, notExist(fmt.Sprintf("next for version %d", version))
+	}
+	return next, nil
+}
+
+func (s *migrationSource) ReadUp(version uint) (io.ReadCloser, string, error) {
+	if migration, ok := s.migrations.Up(version); ok {
+		return io.NopCloser(strings.NewReader(s.up[version])), migration.Identifier, nil
+	}
+	return nil, "", notExist(fmt.Sprintf("read up for version %d", version))
+}
+
+func (s *migrationSource) ReadDown(version uint) (io.ReadCloser, string, error) {
+	if migration, ok := s.migrations.Down(version); ok {
+		return io.NopCloser(strings.NewReader(s.down[version])), migration.Identifier, nil
+	}
+	return nil, "", notExist(fmt.Sprintf("read down for version %d", version))
+}
+
+// notExist is the error of a missing migration, os.ErrNotExist is expected by golang-migrate
+func notExist(op string) error {
+	return &os.PathError{Op: op, Path: "dban", Err: os.ErrNotExist}
+}
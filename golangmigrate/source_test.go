@@ -0,0 +1,72 @@
+package golangmigrate
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	gomigrate "github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+const testDatabaseURLEnv = "DBAN_TEST_DATABASE_URL"
+
+func TestSource(t *testing.T) {
+	src, err := Source(dban.FeatureTTL, dban.FeatureTimestamps)
+	require.NoError(t, err)
+
+	var versions []uint
+	version, err := src.First()
+	for ; err == nil; version, err = src.Next(version) {
+		versions = append(versions, version)
+	}
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Equal(t, []uint{1, 2, 1001, 1002, 2001}, versions)
+
+	up, id, err := src.ReadUp(2001)
+	require.NoError(t, err)
+	assert.Equal(t, "ttl_001_expires_at.sql", id)
+	query, err := io.ReadAll(up)
+	require.NoError(t, err)
+	assert.Contains(t, string(query), "create index key_value_expires_at_idx")
+
+	_, err = src.Prev(1)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, _, err = src.ReadDown(3)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = Source(dban.Feature("unknown"))
+	assert.Error(t, err)
+}
+
+func TestSourcePostgres(t *testing.T) {
+	url := os.Getenv(testDatabaseURLEnv)
+	if url == "" {
+		t.Skipf("%s is not set", testDatabaseURLEnv)
+	}
+	db, err := pgdb.Open(pgdb.Opts{URL: url, MaxOpenConnections: 8, MaxIdleConnections: 8})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.RawDB().Close() })
+
+	src, err := Source(dban.FeatureTimestamps)
+	require.NoError(t, err)
+	migrator, err := gomigrate.NewWithSourceInstance("dban", src, url)
+	require.NoError(t, err)
+	t.Cleanup(func() { _, _ = migrator.Close() })
+
+	require.NoError(t, migrator.Up())
+	t.Cleanup(func() { require.NoError(t, migrator.Down()) })
+
+	kvQ := dban.NewKeyValueQ(db)
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "migrated", Value: "by golang-migrate"}))
+	assert.Equal(t, "by golang-migrate", kvQ.MustGet("migrated").Value)
+
+	version, dirty, err := migrator.Version()
+	require.NoError(t, err)
+	assert.False(t, dirty)
+	assert.Equal(t, uint(1002), version)
+}
@@ -0,0 +1,136 @@
+package dban
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+// defaultMaxStaleness is a time a streamer may go without progress before it is reported degraded
+const defaultMaxStaleness = 5 * time.Minute
+
+// HealthStatus is a verdict of a health check
+type HealthStatus string
+
+const (
+	// HealthHealthy means everything works
+	HealthHealthy HealthStatus = "healthy"
+	// HealthDegraded means the database works, but some streamers make no progress
+	HealthDegraded HealthStatus = "degraded"
+	// HealthUnhealthy means the database or the key value table is unreachable
+	HealthUnhealthy HealthStatus = "unhealthy"
+)
+
+// HealthReporter is a component whose staleness is checked by HealthChecker. Streamers
+// created with NewStreamer implement it
+type HealthReporter interface {
+	// Name returns a name of the component to report
+	Name() string
+	// LastProgress returns the time the component last made progress, or zero time if
+	// it has not made any yet
+	LastProgress() time.Time
+}
+
+// ComponentHealth is a verdict on a single component with a reason of it unless it is healthy
+type ComponentHealth struct {
+	Name   string       `json:"name"`
+	Status HealthStatus `json:"status"`
+	Reason string       `json:"reason,omitempty"`
+}
+
+// HealthReport is an overall verdict, which is the worst of the verdicts on the components
+type HealthReport struct {
+	Status     HealthStatus      `json:"status"`
+	Components []ComponentHealth `json:"components"`
+}
+
+// HealthChecker tells whether the database and the streamers work, e.g. for Kubernetes probes
+type HealthChecker struct {
+	db           *pgdb.DB
+	queries      *kvQueries
+	reporters    []HealthReporter
+	maxStaleness time.Duration
+	started      time.Time
+	now          func() time.Time
+}
+
+// NewHealthChecker creates a health checker of the database and the streamers. Streamers that
+// have not made progress yet are considered stale once the max staleness passes since the creation
+func NewHealthChecker(db *pgdb.DB, streamers ...HealthReporter) *HealthChecker {
+	return &HealthChecker{
+		db:           db,
+		queries:      defaultQueries,
+		reporters:    streamers,
+		maxStaleness: defaultMaxStaleness,
+		started:      time.Now(),
+		now:          time.Now,
+	}
+}
+
+// WithMaxStaleness sets a time a streamer may go without progress before it is reported
+// degraded, 5 minutes by default
+func (c *HealthChecker) WithMaxStaleness(d time.Duration) *HealthChecker {
+	c.maxStaleness = d
+	return c
+}
+
+// WithTable makes the checker check the key value table instead of the default one, the
+// one the queriers are configured with by WithTable or WithTableSchema
+func (c *HealthChecker) WithTable(table KeyValueTable) *HealthChecker {
+	c.queries = newKVQueries(table)
+	return c
+}
+
+// Check pings the database, makes sure the key value table is reachable and checks staleness
+// of the streamers. The database queries are bounded by ctx
+func (c *HealthChecker) Check(ctx context.Context) HealthReport {
+	report := HealthReport{Status: HealthHealthy}
+	add := func(component ComponentHealth) {
+		report.Components = append(report.Components, component)
+		if severity(component.Status) > severity(report.Status) {
+			report.Status = component.Status
+		}
+	}
+
+	add(c.checkQuery(ctx, "database", "SELECT 1"))
+	add(c.checkQuery(ctx, c.queries.table, "SELECT 1 FROM "+c.queries.table+" LIMIT 1"))
+
+	now := c.now()
+	for _, reporter := range c.reporters {
+		component := ComponentHealth{Name: reporter.Name(), Status: HealthHealthy}
+
+		last := reporter.LastProgress()
+		if last.IsZero() {
+			last = c.started
+		}
+		if staleness := now.Sub(last); staleness > c.maxStaleness {
+			component.Status = HealthDegraded
+			component.Reason = fmt.Sprintf("no progress for %s", staleness.Round(time.Second))
+		}
+		add(component)
+	}
+
+	return report
+}
+
+func (c *HealthChecker) checkQuery(ctx context.Context, name, query string) ComponentHealth {
+	var one int
+	err := c.db.GetRawContext(ctx, &one, query)
+	if err != nil && !IsNotFound(err) {
+		return ComponentHealth{Name: name, Status: HealthUnhealthy, Reason: err.Error()}
+	}
+	return ComponentHealth{Name: name, Status: HealthHealthy}
+}
+
+func severity(status HealthStatus) int {
+	switch status {
+	case HealthHealthy:
+		return 0
+	case HealthDegraded:
+		return 1
+	default:
+		return 2
+	}
+}
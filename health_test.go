@@ -0,0 +1,87 @@
+package dban
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeReporter struct {
+	name string
+	last time.Time
+}
+
+func (r fakeReporter) Name() string            { return r.name }
+func (r fakeReporter) LastProgress() time.Time { return r.last }
+
+func TestHealthChecker(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	newChecker := func(t *testing.T, reporters ...HealthReporter) (*HealthChecker, sqlmock.Sqlmock) {
+		db, mock := newMockDB(t)
+		checker := NewHealthChecker(db, reporters...).WithMaxStaleness(time.Minute)
+		checker.started = now.Add(-time.Hour)
+		checker.now = func() time.Time { return now }
+		return checker, mock
+	}
+	expectTable := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow(1))
+		mock.ExpectQuery("SELECT 1 FROM key_value LIMIT 1").WillReturnError(sql.ErrNoRows)
+	}
+
+	t.Run("healthy", func(t *testing.T) {
+		checker, mock := newChecker(t, fakeReporter{name: "fresh", last: now.Add(-time.Second)})
+		expectTable(mock)
+
+		report := checker.Check(context.Background())
+		assert.Equal(t, HealthHealthy, report.Status)
+		assert.Len(t, report.Components, 3)
+	})
+
+	t.Run("stale streamer", func(t *testing.T) {
+		checker, mock := newChecker(t,
+			fakeReporter{name: "fresh", last: now.Add(-time.Second)},
+			fakeReporter{name: "stale", last: now.Add(-2 * time.Minute)},
+			fakeReporter{name: "idle"},
+		)
+		expectTable(mock)
+
+		report := checker.Check(context.Background())
+		assert.Equal(t, HealthDegraded, report.Status)
+		assert.Equal(t, ComponentHealth{Name: "stale", Status: HealthDegraded, Reason: "no progress for 2m0s"}, report.Components[3])
+		assert.Equal(t, HealthDegraded, report.Components[4].Status, "streamers without progress are stale since the start")
+	})
+
+	t.Run("broken database", func(t *testing.T) {
+		checker, mock := newChecker(t, fakeReporter{name: "stale", last: now.Add(-2 * time.Minute)})
+		mock.ExpectQuery("SELECT 1").WillReturnError(sql.ErrConnDone)
+		mock.ExpectQuery("SELECT 1 FROM key_value LIMIT 1").WillReturnError(sql.ErrConnDone)
+
+		report := checker.Check(context.Background())
+		assert.Equal(t, HealthUnhealthy, report.Status)
+		assert.Equal(t, HealthUnhealthy, report.Components[0].Status)
+		assert.NotEmpty(t, report.Components[0].Reason)
+
+		raw, err := json.Marshal(report)
+		require.NoError(t, err)
+		var decoded HealthReport
+		require.NoError(t, json.Unmarshal(raw, &decoded))
+		assert.Equal(t, report, decoded)
+	})
+
+	t.Run("configured table", func(t *testing.T) {
+		checker, mock := newChecker(t)
+		checker.WithTable(KeyValueTable{Name: "cursors"})
+		mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow(1))
+		mock.ExpectQuery(`SELECT 1 FROM "cursors" LIMIT 1`).WillReturnError(sql.ErrNoRows)
+
+		report := checker.Check(context.Background())
+		assert.Equal(t, HealthHealthy, report.Status)
+		assert.Equal(t, `"cursors"`, report.Components[1].Name)
+	})
+}
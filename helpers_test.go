@@ -0,0 +1,170 @@
+package dban
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+// testDatabaseURLEnv is a variable holding a connection string of a disposable Postgres
+// database for integration tests. Integration tests are skipped when it is not set
+const testDatabaseURLEnv = "DBAN_TEST_DATABASE_URL"
+
+func openTestPostgres(t testing.TB) *pgdb.DB {
+	url := os.Getenv(testDatabaseURLEnv)
+	if url == "" {
+		t.Skipf("%s is not set", testDatabaseURLEnv)
+	}
+
+	db, err := pgdb.Open(pgdb.Opts{URL: url, MaxOpenConnections: 8, MaxIdleConnections: 8})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.RawDB().Close() })
+	return db
+}
+
+// migrateTestPostgres applies the key value migrations and reverts them once the test is over
+func migrateTestPostgres(t testing.TB, db *pgdb.DB, opts ...KVMigratorOption) KeyValueMigrator {
+	migrator := NewKVMigrator(db.RawDB(), nil, append(opts, WithForceDestructive())...)
+	require.NoError(t, migrator.MigrateUp())
+	t.Cleanup(func() { require.NoError(t, migrator.MigrateDown()) })
+	return migrator
+}
+
+// sqlQueryer implements pgdb.Queryer over any database/sql connection, so that the
+// queriers could be tested with sqlmock
+type sqlQueryer struct {
+	db *sqlx.DB
+	tx bool
+}
+
+// InTransaction lets the queriers see the queryer as bound to a transaction (see withMockTx)
+func (q sqlQueryer) InTransaction() bool {
+	return q.tx
+}
+
+// withMockTx returns a copy of the mock db that the queriers consider bound to a
+// transaction, while the queries are sent as is
+func withMockTx(db *pgdb.DB) *pgdb.DB {
+	queryer := db.Queryer.(sqlQueryer)
+	queryer.tx = true
+	return &pgdb.DB{Queryer: queryer}
+}
+
+// newMockDB returns a pgdb.DB backed by sqlmock matching queries exactly. Clone, and
+// therefore New of the queriers, is not supported
+func newMockDB(t testing.TB) (*pgdb.DB, sqlmock.Sqlmock) {
+	raw, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, mock.ExpectationsWereMet())
+		_ = raw.Close()
+	})
+
+	return &pgdb.DB{Queryer: sqlQueryer{db: sqlx.NewDb(raw, "postgres")}}, mock
+}
+
+// benchMockDB times run b.N times, each against a database of its own as sqlmock scans every
+// expectation it has ever been given on each query. prepare is not timed
+func benchMockDB(b *testing.B, expect func(sqlmock.Sqlmock), prepare func(db *pgdb.DB) (run func() error)) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		raw, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(b, err)
+		expect(mock)
+		run := prepare(&pgdb.DB{Queryer: sqlQueryer{db: sqlx.NewDb(raw, "postgres")}})
+		b.StartTimer()
+
+		if err := run(); err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		require.NoError(b, mock.ExpectationsWereMet())
+		_ = raw.Close()
+		b.StartTimer()
+	}
+}
+
+func (q sqlQueryer) build(query squirrel.Sqlizer) (string, []interface{}, error) {
+	sql, args, err := query.ToSql()
+	return sqlx.Rebind(sqlx.DOLLAR, sql), args, err
+}
+
+func (q sqlQueryer) Exec(query squirrel.Sqlizer) error {
+	return q.ExecContext(context.Background(), query)
+}
+
+func (q sqlQueryer) ExecContext(ctx context.Context, query squirrel.Sqlizer) error {
+	_, err := q.ExecWithResultContext(ctx, query)
+	return err
+}
+
+func (q sqlQueryer) ExecRaw(query string, args ...interface{}) error {
+	return q.ExecRawContext(context.Background(), query, args...)
+}
+
+func (q sqlQueryer) ExecRawContext(ctx context.Context, query string, args ...interface{}) error {
+	_, err := q.db.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, query), args...)
+	return err
+}
+
+func (q sqlQueryer) ExecWithResult(query squirrel.Sqlizer) (sql.Result, error) {
+	return q.ExecWithResultContext(context.Background(), query)
+}
+
+func (q sqlQueryer) ExecWithResultContext(ctx context.Context, query squirrel.Sqlizer) (sql.Result, error) {
+	raw, args, err := q.build(query)
+	if err != nil {
+		return nil, err
+	}
+	return q.db.ExecContext(ctx, raw, args...)
+}
+
+func (q sqlQueryer) Select(dest interface{}, query squirrel.Sqlizer) error {
+	return q.SelectContext(context.Background(), dest, query)
+}
+
+func (q sqlQueryer) SelectContext(ctx context.Context, dest interface{}, query squirrel.Sqlizer) error {
+	raw, args, err := q.build(query)
+	if err != nil {
+		return err
+	}
+	return q.db.SelectContext(ctx, dest, raw, args...)
+}
+
+func (q sqlQueryer) SelectRaw(dest interface{}, query string, args ...interface{}) error {
+	return q.SelectRawContext(context.Background(), dest, query, args...)
+}
+
+func (q sqlQueryer) SelectRawContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return q.db.SelectContext(ctx, dest, sqlx.Rebind(sqlx.DOLLAR, query), args...)
+}
+
+func (q sqlQueryer) Get(dest interface{}, query squirrel.Sqlizer) error {
+	return q.GetContext(context.Background(), dest, query)
+}
+
+func (q sqlQueryer) GetContext(ctx context.Context, dest interface{}, query squirrel.Sqlizer) error {
+	raw, args, err := q.build(query)
+	if err != nil {
+		return err
+	}
+	return q.db.GetContext(ctx, dest, raw, args...)
+}
+
+func (q sqlQueryer) GetRaw(dest interface{}, query string, args ...interface{}) error {
+	return q.GetRawContext(context.Background(), dest, query, args...)
+}
+
+func (q sqlQueryer) GetRawContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return q.db.GetContext(ctx, dest, sqlx.Rebind(sqlx.DOLLAR, query), args...)
+}
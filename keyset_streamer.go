@@ -0,0 +1,277 @@
+package dban
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// KeysetStreamable is an interface that an object must implement in order to be streamed
+// through with keyset pagination by a cursor of type C, e.g. a pair of the update time and
+// the ID. Entities must be selected in ascending order of the cursor, following after, or
+// from the first entity if after is nil
+type KeysetStreamable[T, C any] interface {
+	SelectAfter(after *C, limit uint64) ([]T, error)
+}
+
+// KeysetStreamer streams through a KeysetStreamable, keeping the cursor of the last entity
+// formed in the key value storage encoded with a codec
+type KeysetStreamer[T, C any] interface {
+	// FormList returns a batch of entities following the stored cursor and moves the cursor
+	// to the last of them, or back to the start once the end of the stream is reached. With
+	// a TransactionalKeyValueQ the cursor is read and updated in one transaction, so
+	// concurrent calls get distinct batches
+	FormList() ([]T, error)
+	// FormListAndProcess forms a list according to FormList and applies fn to its entities
+	// one by one, stopping at the first failure
+	FormListAndProcess(fn func(ctx context.Context, t T) error) error
+	// GetCursor returns the cursor of the last entity formed, the zero one at the start of
+	// the stream
+	GetCursor() (C, error)
+	// HealthReporter reports the cursor key as a name and the time a list was last formed
+	HealthReporter
+}
+
+// KeysetStreamerInitParams are parameters specified when initializing a new keyset streamer
+type KeysetStreamerInitParams[T, C any] struct {
+	Stream        KeysetStreamable[T, C]
+	KeyValueQ     KeyValueQ
+	KeyValueKey   string
+	ExtractCursor func(t T) C
+	Codec         Codec[C]
+	BatchSize     *uint64
+	Log           *logan.Entry
+	Ctx           *context.Context
+	EventSink     EventSink
+}
+
+// NewKeysetStreamer creates a new instance of KeysetStreamer using KeysetStreamerInitParams.
+// ExtractCursor returns the cursor of an entity, which must be unique, as the entities
+// following the stored cursor are selected. Codec stores cursors, JSONCodec by default; a
+// stored cursor it fails to decode matches ErrCorruptCursor. Log, BatchSize, Ctx and
+// EventSink could be omitted the same way as with NewStreamer. An invalid KeyValueKey (see
+// ValidateKey) or a missing ExtractCursor makes every method of the streamer fail
+func NewKeysetStreamer[T, C any](initParams KeysetStreamerInitParams[T, C]) KeysetStreamer[T, C] {
+	var (
+		batchSize = defaultBatchSize
+		ctx       = context.Background()
+		codec     = JSONCodec[C]()
+	)
+
+	if initParams.BatchSize != nil {
+		batchSize = *initParams.BatchSize
+	}
+	if initParams.Ctx != nil {
+		ctx = *initParams.Ctx
+	}
+	if initParams.Codec != nil {
+		codec = initParams.Codec
+	}
+
+	err := ValidateKey(initParams.KeyValueKey)
+	if err == nil && initParams.ExtractCursor == nil {
+		err = errors.New("keyset streamer requires ExtractCursor")
+	}
+
+	return &keysetStreamer[T, C]{
+		Stream:        initParams.Stream,
+		KeyValueQ:     withContext(initParams.KeyValueQ, ctx),
+		KeyValueKey:   initParams.KeyValueKey,
+		ExtractCursor: initParams.ExtractCursor,
+		Codec:         codec,
+		BatchSize:     batchSize,
+		Log:           initParams.Log,
+		Ctx:           ctx,
+		EventSink:     asyncEventSink(initParams.EventSink),
+		lastProgress:  new(int64),
+		err:           err,
+	}
+}
+
+type keysetStreamer[T, C any] struct {
+	Stream        KeysetStreamable[T, C]
+	KeyValueQ     KeyValueQ
+	KeyValueKey   string
+	ExtractCursor func(t T) C
+	Codec         Codec[C]
+	BatchSize     uint64
+	Log           *logan.Entry
+	Ctx           context.Context
+	EventSink     EventSink
+
+	// err is an error of the construction returned by every method
+	err          error
+	lastProgress *int64
+}
+
+func (s *keysetStreamer[T, C]) FormList() ([]T, error) {
+	if s.err != nil {
+		return nil, errors.Wrap(s.err, "invalid streamer")
+	}
+
+	var entities []T
+	err := transaction(s.KeyValueQ, func(q KeyValueQ) error {
+		tx := *s
+		tx.KeyValueQ = q
+		var err error
+		entities, err = tx.formList()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	atomic.StoreInt64(s.lastProgress, time.Now().UnixNano())
+	return entities, nil
+}
+
+func (s *keysetStreamer[T, C]) FormListAndProcess(fn func(ctx context.Context, t T) error) error {
+	entities, err := s.FormList()
+	if err != nil {
+		return errors.Wrap(err, "failed to form list")
+	}
+
+	for i, entity := range entities {
+		if err = s.Ctx.Err(); err != nil {
+			return err
+		}
+		if err = fn(s.Ctx, entity); err != nil {
+			fields := logan.F{"key": s.KeyValueKey, "entity_index": i}
+			if cursor, encodeErr := s.Codec.Encode(s.ExtractCursor(entity)); encodeErr == nil {
+				fields["cursor"] = cursor
+			}
+			return errors.Wrap(err, "failed to process entity", fields)
+		}
+	}
+	return nil
+}
+
+func (s *keysetStreamer[T, C]) GetCursor() (C, error) {
+	var cursor C
+	if s.err != nil {
+		return cursor, errors.Wrap(s.err, "invalid streamer")
+	}
+
+	after, err := s.readCursor(s.KeyValueQ.Get)
+	if err != nil || after == nil {
+		return cursor, err
+	}
+	return *after, nil
+}
+
+func (s *keysetStreamer[T, C]) Name() string {
+	return s.KeyValueKey
+}
+
+func (s *keysetStreamer[T, C]) LastProgress() time.Time {
+	if nanos := atomic.LoadInt64(s.lastProgress); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
+// formList selects the entities following the cursor and moves the cursor to the last of
+// them. At the end of the stream the cursor is moved back to the start at most once per
+// call: if there are no entities even after that, the stream is empty
+func (s *keysetStreamer[T, C]) formList() ([]T, error) {
+	for reset := false; ; reset = true {
+		if err := s.Ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		after, err := s.readCursor(s.KeyValueQ.LockingGet)
+		if err != nil {
+			return nil, err
+		}
+
+		entities, err := s.Stream.SelectAfter(after, s.BatchSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to select entities", logan.F{"key": s.KeyValueKey})
+		}
+
+		if len(entities) != 0 {
+			if err = s.writeCursor(s.ExtractCursor(entities[len(entities)-1])); err != nil {
+				return nil, err
+			}
+			return entities, nil
+		}
+
+		s.emit(EndOfStream{Key: s.KeyValueKey})
+		if after == nil {
+			if s.Log != nil {
+				s.Log.Warn("Entities list is empty")
+			}
+			return nil, nil
+		}
+		if reset {
+			return nil, errors.Wrap(ErrInconsistentStream, "no entities found after the cursor reset", logan.F{
+				"key": s.KeyValueKey,
+			})
+		}
+
+		// the cursor is deleted, so that the stream starts over
+		if err = s.KeyValueQ.Delete(s.KeyValueKey); err != nil {
+			return nil, errors.Wrap(err, "failed to reset cursor", logan.F{"key": s.KeyValueKey})
+		}
+		s.emit(CursorReset{Key: s.KeyValueKey})
+	}
+}
+
+// readCursor reads the cursor with get, returning nil at the start of the stream
+func (s *keysetStreamer[T, C]) readCursor(get func(key string) (*KeyValue, error)) (*C, error) {
+	stored, err := get(s.KeyValueKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get current cursor value", logan.F{"key": s.KeyValueKey})
+	}
+	if stored == nil {
+		return nil, nil
+	}
+
+	cursor, err := s.Codec.Decode(stored.Value)
+	if err != nil {
+		return nil, errors.Wrap(&CorruptCursorError{Value: stored.Value, Err: err}, "failed to parse cursor", logan.F{
+			"key":       s.KeyValueKey,
+			"kv_cursor": stored.Value,
+		})
+	}
+	return &cursor, nil
+}
+
+func (s *keysetStreamer[T, C]) writeCursor(cursor C) error {
+	value, err := s.Codec.Encode(cursor)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode cursor", logan.F{"key": s.KeyValueKey})
+	}
+	if err = s.KeyValueQ.Upsert(KeyValue{Key: s.KeyValueKey, Value: value}); err != nil {
+		return errors.Wrap(err, "failed to update cursor", logan.F{"key": s.KeyValueKey, "cursor": value})
+	}
+	return nil
+}
+
+func (s *keysetStreamer[T, C]) emit(event Event) {
+	if s.EventSink != nil {
+		s.EventSink.Emit(event)
+	}
+}
+
+type uint64Codec struct{}
+
+// Uint64Codec returns the codec storing cursors as decimal numbers, as CursorStreamer does
+func Uint64Codec() Codec[uint64] {
+	return uint64Codec{}
+}
+
+func (uint64Codec) Encode(cursor uint64) (string, error) {
+	return strconv.FormatUint(cursor, 10), nil
+}
+
+func (uint64Codec) Decode(raw string) (uint64, error) {
+	if strings.TrimSpace(raw) != raw {
+		return 0, errors.New("cursor has leading or trailing whitespace")
+	}
+	return strconv.ParseUint(raw, 10, 64)
+}
@@ -0,0 +1,107 @@
+package dban_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+type event struct {
+	ID        uint64
+	UpdatedAt time.Time
+}
+
+// eventCursor is a composite cursor of events ordered by the update time and then by ID
+type eventCursor struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	ID        uint64    `json:"id"`
+}
+
+func (c eventCursor) before(e event) bool {
+	return c.UpdatedAt.Before(e.UpdatedAt) || (c.UpdatedAt.Equal(e.UpdatedAt) && c.ID < e.ID)
+}
+
+// eventStreamable selects events following the cursor in memory
+type eventStreamable struct {
+	events []event
+}
+
+func (s *eventStreamable) SelectAfter(after *eventCursor, limit uint64) ([]event, error) {
+	sort.Slice(s.events, func(i, j int) bool {
+		return eventCursor{UpdatedAt: s.events[i].UpdatedAt, ID: s.events[i].ID}.before(s.events[j])
+	})
+	selected := []event{}
+	for _, e := range s.events {
+		if (after == nil || after.before(e)) && uint64(len(selected)) < limit {
+			selected = append(selected, e)
+		}
+	}
+	return selected, nil
+}
+
+func TestKeysetStreamer(t *testing.T) {
+	at := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)
+	stream := &eventStreamable{events: []event{
+		{ID: 7, UpdatedAt: at.Add(time.Minute)},
+		{ID: 9, UpdatedAt: at},
+		{ID: 3, UpdatedAt: at},
+	}}
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	newStreamer := func() dban.KeysetStreamer[event, eventCursor] {
+		batchSize := uint64(2)
+		return dban.NewKeysetStreamer(dban.KeysetStreamerInitParams[event, eventCursor]{
+			Stream:      stream,
+			KeyValueQ:   kvQ,
+			KeyValueKey: cursorKey,
+			ExtractCursor: func(e event) eventCursor {
+				return eventCursor{UpdatedAt: e.UpdatedAt, ID: e.ID}
+			},
+			BatchSize: &batchSize,
+		})
+	}
+
+	streamer := newStreamer()
+	list, err := streamer.FormList()
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 9}, ids(list))
+
+	cursor, err := streamer.GetCursor()
+	require.NoError(t, err)
+	assert.Equal(t, eventCursor{UpdatedAt: at, ID: 9}, cursor)
+	stored, err := kvQ.Get(cursorKey)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"updated_at":"2023-01-02T10:00:00Z","id":9}`, stored.Value)
+
+	// an event updated later is streamed once more after the restart
+	stream.events[1].UpdatedAt = at.Add(2 * time.Minute)
+	var processed []event
+	require.NoError(t, newStreamer().FormListAndProcess(func(_ context.Context, e event) error {
+		processed = append(processed, e)
+		return nil
+	}))
+	assert.Equal(t, []uint64{7, 9}, ids(processed), "the restarted streamer must resume after the stored cursor")
+
+	list, err = newStreamer().FormList()
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 7}, ids(list), "end of the list must wrap to the start")
+
+	t.Run("corrupt cursor", func(t *testing.T) {
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "{"}))
+		_, err := newStreamer().FormList()
+		assert.True(t, dban.Is(err, dban.ErrCorruptCursor))
+	})
+}
+
+func ids(events []event) []uint64 {
+	ids := make([]uint64, len(events))
+	for i, e := range events {
+		ids[i] = e.ID
+	}
+	return ids
+}
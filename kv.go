@@ -1,36 +1,87 @@
 package dban
 
 import (
+	"context"
 	"database/sql"
 	"github.com/Masterminds/squirrel"
-	"github.com/fatih/structs"
+	"github.com/lib/pq"
 	"gitlab.com/distributed_lab/kit/pgdb"
 	"gitlab.com/distributed_lab/logan/v3"
 	"gitlab.com/distributed_lab/logan/v3/errors"
+	"time"
 )
 
 // KeyValue is an object stored in the key value storage
 type KeyValue struct {
-	Key   string `db:"key" structs:"key"`
-	Value string `db:"value" structs:"value"`
+	Key   string `db:"key" structs:"key" json:"key"`
+	Value string `db:"value" structs:"value" json:"value"`
 }
 
 // KeyValueQ is an interface for querying a key value storage
+//
 //go:generate mockery --case=underscore --name=KeyValueQ
 type KeyValueQ interface {
 	// New creates a new instance of an interface with all filters cleared
 	New() KeyValueQ
-	// Get is a function to get a value from the storage based on the key
+	// Get is a function to get a value from the storage based on the key. It returns nil
+	// if there is no value by the key, GetStrict fails with ErrNoSuchKey instead
 	Get(key string) (*KeyValue, error)
-	// MustGet is a function that tries retrieving a value but panics if it fails
+	// GetMany gets the values by the keys with a single query. Missing keys are absent
+	// from the map
+	GetMany(keys []string) (map[string]KeyValue, error)
+	// SelectByPrefix returns a page of values whose keys start with prefix, ordered by the
+	// key. An empty prefix selects all of them
+	SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]KeyValue, error)
+	// ListKeys returns a page of keys starting with prefix, ordered by the key, without
+	// reading the values. An empty prefix lists all of them
+	ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error)
+	// Count returns the number of values in the storage
+	Count() (uint64, error)
+	// FilterByKeys returns a copy of the querier whose Select and SelectPage select only the
+	// values by one of the keys. Filters accumulate, other methods ignore them
+	FilterByKeys(keys ...string) KeyValueQ
+	// FilterByPrefix returns a copy of the querier whose Select, SelectPage and
+	// SelectWithPageParams select only the values whose keys start with prefix
+	FilterByPrefix(prefix string) KeyValueQ
+	// FilterByValueLike returns a copy of the querier whose Select and SelectPage select
+	// only the values matching the LIKE pattern (see KeyValueFilters.ByValueLike)
+	FilterByValueLike(pattern string) KeyValueQ
+	// Select returns all the values passing the filters, ordered by the key
+	Select() ([]KeyValue, error)
+	// SelectPage returns a page of the values passing the filters, ordered by the key
+	SelectPage(params pgdb.OffsetPageParams) ([]KeyValue, error)
+	// SelectWithPageParams does the same thing as SelectPage, but orders the values by the
+	// key ascending unless params.Order is set, so that the querier is a
+	// Streamable[KeyValue] a streamer could walk the storage with
+	SelectWithPageParams(params pgdb.OffsetPageParams) ([]KeyValue, error)
+	// MustGet is a function that tries retrieving a value but panics if it fails or there
+	// is no value by the key, in which case the panic wraps ErrNoSuchKey (see GetStrict)
 	MustGet(key string) *KeyValue
 	// Upsert updates value if there is one, insert if no
 	Upsert(KeyValue) error
+	// UpsertMany upserts the values atomically with a single statement. If a key occurs
+	// several times, the last value wins
+	UpsertMany(kvs []KeyValue) error
 	// LockingGet reads row and locks the row for reading and updating
-	// until the end of the current transaction
+	// until the end of the current transaction. The Postgres querier fails with
+	// ErrNoTransaction outside a transaction (see TransactionalKeyValueQ)
 	LockingGet(key string) (*KeyValue, error)
 	// MustLockingGet does the same thing as LockingGet, but panics on error
 	MustLockingGet(key string) *KeyValue
+	// Delete deletes the value by the key. Deleting a missing key is not an error
+	Delete(key string) error
+	// DeleteMany deletes the values by the keys and returns the number of values actually
+	// deleted, so that missing keys could be detected
+	DeleteMany(keys []string) (int64, error)
+	// Update reads the value by the key with LockingGet, passes it to fn, nil if there is
+	// none, and upserts the value fn returns, all in one transaction, so that concurrent
+	// updates of the key wait for each other instead of overwriting each other, e.g. to merge
+	// a JSON document or bump a counter. It returns the value written. Once fn returns
+	// ErrSkipUpdate, nothing is written and the current value is returned, which is zero for
+	// a missing key. Any other failure of fn or of the transaction rolls it back and is
+	// returned as is: fn is never called twice, not even by NewRetryingKeyValueQ. Queriers
+	// without transactions fail with ErrNoTransaction, unless they hold a lock of their own
+	Update(key string, fn func(current *string) (string, error)) (KeyValue, error)
 }
 
 const (
@@ -40,45 +91,109 @@ const (
 	valueColumn = "value"
 )
 
-var keyValueSelect = squirrel.Select("*").From(keyValueTable)
+var keyValueSelect = squirrel.Select(keyColumn, valueColumn).From(keyValueTable)
+
+// Queries of a fixed shape are built once per table (see kvQueries), so that frequent
+// cursor reads and writes do not build them with squirrel every time. pgdb does not expose
+// prepared statements, so the queries are sent as is, which also keeps them working
+// through poolers
+func mustBuild(query squirrel.Sqlizer) string {
+	sql, _, err := query.ToSql()
+	if err != nil {
+		panic(errors.Wrap(err, "failed to build query"))
+	}
+	return sql
+}
+
+// KeyValueQOption is an optional parameter of a key value querier
+type KeyValueQOption func(*keyValueQ)
 
 type keyValueQ struct {
-	db *pgdb.DB
+	db      *pgdb.DB
+	ctx     context.Context
+	queries *kvQueries
+
+	writeRetry     RetryConfig
+	writeRetryHook WriteRetryHook
+
+	lockingOutsideTxLog *logan.Entry
+	isolation           sql.IsolationLevel
+	lockTimeout         time.Duration
+	statementTimeout    time.Duration
+	events              EventSink
+	validators          []Validator
+	ttl                 bool
+	binary              bool
+	softDelete          bool
+	withDeleted         bool
+	maxKeyLength        int
+	actor               string
+	filters             KeyValueFilters
 }
 
 // NewKeyValueQ creates a new instance of a key value querier
-func NewKeyValueQ(db *pgdb.DB) KeyValueQ {
-	return &keyValueQ{
-		db: db,
+func NewKeyValueQ(db *pgdb.DB, opts ...KeyValueQOption) KeyValueQ {
+	q := &keyValueQ{
+		db:      db,
+		ctx:     context.Background(),
+		queries: defaultQueries,
 	}
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	return q
 }
 
 func (q *keyValueQ) Upsert(kv KeyValue) error {
-	query := squirrel.Insert(keyValueTable).
-		SetMap(structs.Map(kv)).
-		Suffix("ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value")
+	if err := q.validate(kv); err != nil {
+		return err
+	}
+
+	query := q.queries.upsert[q.resets()]
+
+	err := q.retryWrite(kv.Key, func(q *keyValueQ) error {
+		return q.db.ExecRawContext(q.ctx, query, kv.Key, kv.Value)
+	})
+	countKV(kvVarUpsert, err)
+	if err != nil {
+		return errors.Wrap(q.classify(err), "failed to upsert value", logan.F{"key": kv.Key})
+	}
 
-	return q.db.Exec(query)
+	if q.events != nil {
+		q.events.Emit(KVWritten{Key: kv.Key})
+	}
+	return nil
 }
 
 func (q *keyValueQ) New() KeyValueQ {
-	return NewKeyValueQ(q.db.Clone())
+	clone := *q
+	clone.filters = KeyValueFilters{}
+	clone.withDeleted = false
+	// there is nothing to clone a caller's transaction into (see NewKeyValueQFromTx)
+	if _, ok := q.db.Queryer.(txQueryer); !ok {
+		clone.db = q.db.Clone()
+	}
+	return &clone
 }
 
 func (q *keyValueQ) Get(key string) (*KeyValue, error) {
-	return q.get(key, false)
+	return q.get(key, rowLockNone)
 }
 
 func (q *keyValueQ) MustGet(key string) *KeyValue {
-	value, err := q.Get(key)
+	value, err := GetStrict(q, key)
 	if err != nil {
 		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
 	}
-	return value
+	return &value
 }
 
 func (q *keyValueQ) LockingGet(key string) (*KeyValue, error) {
-	return q.get(key, true)
+	if err := q.checkTx(key); err != nil {
+		return nil, err
+	}
+	return q.get(key, rowLockForUpdate)
 }
 
 func (q *keyValueQ) MustLockingGet(key string) *KeyValue {
@@ -89,17 +204,69 @@ func (q *keyValueQ) MustLockingGet(key string) *KeyValue {
 	return value
 }
 
-func (q *keyValueQ) get(key string, forUpdate bool) (*KeyValue, error) {
-	statement := keyValueSelect.Where(squirrel.Eq{keyColumn: key})
-	if forUpdate {
-		statement = statement.Suffix("FOR UPDATE")
+func (q *keyValueQ) get(key string, lock rowLock) (*KeyValue, error) {
+	if err := q.validateKey(key); err != nil {
+		return nil, err
+	}
+
+	query, operation := q.queries.get[q.hides()][lock], kvVarGet
+	if lock != rowLockNone {
+		operation = kvVarLockingGet
 	}
 
 	var value KeyValue
-	err := q.db.Get(&value, statement)
+	err := q.db.GetRawContext(q.ctx, &value, query, key)
 	if err == sql.ErrNoRows {
+		countKV(operation, nil)
 		return nil, nil
 	}
+	countKV(operation, err)
+	if err != nil {
+		return nil, errors.Wrap(q.classify(err), "failed to get value", logan.F{"key": key})
+	}
+
+	return &value, nil
+}
+
+func (q *keyValueQ) Delete(key string) error {
+	_, err := q.delete(q.queries.delete, []string{key}, key)
+	return err
+}
+
+func (q *keyValueQ) DeleteMany(keys []string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	return q.delete(q.queries.deleteMany, keys, pq.Array(keys))
+}
+
+// delete runs the delete query returning the deleted keys. Invalid keys fail the whole
+// batch with BatchError
+func (q *keyValueQ) delete(query string, keys []string, arg interface{}) (int64, error) {
+	var batchErr BatchError
+	for i, key := range keys {
+		if err := q.validateKey(key); err != nil {
+			batchErr.Errors = append(batchErr.Errors, KeyError{Key: key, Index: i, Err: err})
+		}
+	}
+	if len(batchErr.Errors) != 0 {
+		return 0, &batchErr
+	}
 
-	return &value, err
+	var deleted []string
+	err := q.retryWrite(keys[0], func(q *keyValueQ) error {
+		deleted = deleted[:0]
+		return q.db.SelectRawContext(q.ctx, &deleted, query, arg)
+	})
+	countKV(kvVarDelete, err)
+	if err != nil {
+		return 0, errors.Wrap(q.classify(err), "failed to delete values", logan.F{"keys": keys})
+	}
+
+	if q.events != nil {
+		for _, key := range deleted {
+			q.events.Emit(KVDeleted{Key: key})
+		}
+	}
+	return int64(len(deleted)), nil
 }
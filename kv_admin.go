@@ -0,0 +1,249 @@
+package dban
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// DumpFormat is a format key values are dumped in
+type DumpFormat string
+
+const (
+	// DumpFormatJSONLines dumps every key value as a JSON object on a line of its own
+	DumpFormatJSONLines DumpFormat = "jsonl"
+	// DumpFormatCSV dumps key values as CSV with the key,value header
+	DumpFormatCSV DumpFormat = "csv"
+)
+
+const (
+	// dumpPageSize is the number of key values read at once while dumping
+	dumpPageSize = 500
+	// restoreBatchSize is the number of key values written at once while restoring
+	restoreBatchSize = 500
+)
+
+var csvHeader = []string{keyColumn, valueColumn}
+
+// LineError is returned by Restore when a line of the input is malformed
+type LineError struct {
+	// Line is the number of the line, starting with 1
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// KeyValueAdmin dumps and restores the values of a key value storage, e.g. to snapshot
+// cursors before a risky migration
+type KeyValueAdmin struct {
+	q KeyValueQ
+}
+
+// NewKeyValueAdmin creates an admin of the storage of q
+func NewKeyValueAdmin(q KeyValueQ) *KeyValueAdmin {
+	return &KeyValueAdmin{q: q}
+}
+
+// Dump writes all the values to w in the format, ordered by the key. Values are read page
+// by page rather than at once, so the dump is not a snapshot unless q is within a
+// repeatable read transaction
+func (a *KeyValueAdmin) Dump(w io.Writer, format DumpFormat) error {
+	buffered := bufio.NewWriter(w)
+	write, flush, err := dumpWriter(buffered, format)
+	if err != nil {
+		return err
+	}
+
+	params := pgdb.OffsetPageParams{Limit: dumpPageSize, Order: pgdb.OrderTypeAsc}
+	for ; ; params.PageNumber++ {
+		values, err := a.q.SelectByPrefix("", params)
+		if err != nil {
+			return errors.Wrap(err, "failed to select values to dump", logan.F{"page": params.PageNumber})
+		}
+		for _, kv := range values {
+			if err = write(kv); err != nil {
+				return errors.Wrap(err, "failed to write value", logan.F{"key": kv.Key})
+			}
+		}
+		if uint64(len(values)) < params.Limit {
+			break
+		}
+	}
+
+	if err = flush(); err != nil {
+		return errors.Wrap(err, "failed to flush dump")
+	}
+	return errors.Wrap(buffered.Flush(), "failed to flush dump")
+}
+
+// dumpWriter returns the functions writing key values in the format and flushing them
+func dumpWriter(w io.Writer, format DumpFormat) (write func(KeyValue) error, flush func() error, err error) {
+	switch format {
+	case DumpFormatJSONLines:
+		encoder := json.NewEncoder(w)
+		return func(kv KeyValue) error { return encoder.Encode(kv) }, func() error { return nil }, nil
+	case DumpFormatCSV:
+		writer := csv.NewWriter(w)
+		if err = writer.Write(csvHeader); err != nil {
+			return nil, nil, errors.Wrap(err, "failed to write header")
+		}
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+		return func(kv KeyValue) error { return writer.Write([]string{kv.Key, kv.Value}) }, flush, nil
+	default:
+		return nil, nil, errors.From(errors.New("unknown dump format"), logan.F{"format": format})
+	}
+}
+
+// Restore reads the values dumped in the format from r and writes them, returning the
+// number of values written. Existing values are replaced if overwrite is set, and kept
+// otherwise, which requires q to be a CompareAndSwapper. Input is validated line by line
+// as it is written, so a malformed line fails the restore with the values before it written
+func (a *KeyValueAdmin) Restore(r io.Reader, format DumpFormat, overwrite bool) (int, error) {
+	read, err := dumpReader(r, format)
+	if err != nil {
+		return 0, err
+	}
+
+	var inserter CompareAndSwapper
+	if !overwrite {
+		var ok bool
+		if inserter, ok = a.q.(CompareAndSwapper); !ok {
+			return 0, errors.New("querier cannot restore values without overwriting them")
+		}
+	}
+
+	written := 0
+	batch := make([]KeyValue, 0, restoreBatchSize)
+	writeBatch := func() error {
+		if overwrite {
+			if err := a.q.UpsertMany(batch); err != nil {
+				return errors.Wrap(err, "failed to upsert values", logan.F{"written": written})
+			}
+			written += len(batch)
+		} else {
+			for _, kv := range batch {
+				inserted, err := inserter.InsertIfAbsent(kv)
+				if err != nil {
+					return errors.Wrap(err, "failed to insert value", logan.F{"key": kv.Key, "written": written})
+				}
+				if inserted {
+					written++
+				}
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		kv, err := read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return written, err
+		}
+
+		if batch = append(batch, kv); len(batch) == restoreBatchSize {
+			if err = writeBatch(); err != nil {
+				return written, err
+			}
+		}
+	}
+	if len(batch) > 0 {
+		if err = writeBatch(); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// dumpReader returns the function reading the next key value in the format, which
+// returns io.EOF once there are no more of them
+func dumpReader(r io.Reader, format DumpFormat) (func() (KeyValue, error), error) {
+	switch format {
+	case DumpFormatJSONLines:
+		reader := bufio.NewReader(r)
+		line := 0
+		return func() (KeyValue, error) {
+			for {
+				data, err := reader.ReadBytes('\n')
+				if len(data) == 0 && err == io.EOF {
+					return KeyValue{}, io.EOF
+				}
+				if err != nil && err != io.EOF {
+					return KeyValue{}, errors.Wrap(err, "failed to read input")
+				}
+				line++
+				if len(bytes.TrimSpace(data)) == 0 {
+					continue
+				}
+
+				var kv KeyValue
+				if err = json.Unmarshal(data, &kv); err != nil {
+					return KeyValue{}, &LineError{Line: line, Err: err}
+				}
+				if kv.Key == "" {
+					return KeyValue{}, &LineError{Line: line, Err: ErrEmptyKey}
+				}
+				return kv, nil
+			}
+		}, nil
+	case DumpFormatCSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = len(csvHeader)
+		header, err := reader.Read()
+		if err == io.EOF {
+			return func() (KeyValue, error) { return KeyValue{}, io.EOF }, nil
+		}
+		if err != nil {
+			return nil, csvLineError(err)
+		}
+		if header[0] != csvHeader[0] || header[1] != csvHeader[1] {
+			return nil, &LineError{Line: 1, Err: errors.From(errors.New("header is not key,value"), logan.F{"header": header})}
+		}
+
+		return func() (KeyValue, error) {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return KeyValue{}, io.EOF
+			}
+			if err != nil {
+				return KeyValue{}, csvLineError(err)
+			}
+
+			if record[0] == "" {
+				line, _ := reader.FieldPos(0)
+				return KeyValue{}, &LineError{Line: line, Err: ErrEmptyKey}
+			}
+			return KeyValue{Key: record[0], Value: record[1]}, nil
+		}, nil
+	default:
+		return nil, errors.From(errors.New("unknown dump format"), logan.F{"format": format})
+	}
+}
+
+// csvLineError returns a LineError of a parse error of CSV, or err as it is if it is not one
+func csvLineError(err error) error {
+	if parseErr, ok := err.(*csv.ParseError); ok {
+		return &LineError{Line: parseErr.StartLine, Err: parseErr.Err}
+	}
+	return errors.Wrap(err, "failed to read input")
+}
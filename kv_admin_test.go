@@ -0,0 +1,90 @@
+package dban_test
+
+import (
+	"bytes"
+	stderrors "errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestKeyValueAdminDumpRestore(t *testing.T) {
+	for _, format := range []dban.DumpFormat{dban.DumpFormatJSONLines, dban.DumpFormatCSV} {
+		t.Run(string(format), func(t *testing.T) {
+			source := dbantest.NewMemoryKeyValueQ()
+			kvs := make([]dban.KeyValue, 1200)
+			for i := range kvs {
+				kvs[i] = dban.KeyValue{Key: fmt.Sprintf("key:%04d", i), Value: fmt.Sprintf("value,\n\"%d\"", i)}
+			}
+			require.NoError(t, source.UpsertMany(kvs))
+
+			var dump bytes.Buffer
+			require.NoError(t, dban.NewKeyValueAdmin(source).Dump(&dump, format))
+
+			target := dbantest.NewMemoryKeyValueQ()
+			require.NoError(t, target.Upsert(dban.KeyValue{Key: "key:0000", Value: "kept"}))
+			written, err := dban.NewKeyValueAdmin(target).Restore(bytes.NewReader(dump.Bytes()), format, false)
+			require.NoError(t, err)
+			assert.Equal(t, len(kvs)-1, written, "existing values are not overwritten")
+			assert.Equal(t, "kept", target.MustGet("key:0000").Value)
+			assert.Equal(t, kvs[1199].Value, target.MustGet("key:1199").Value)
+
+			written, err = dban.NewKeyValueAdmin(target).Restore(bytes.NewReader(dump.Bytes()), format, true)
+			require.NoError(t, err)
+			assert.Equal(t, len(kvs), written)
+			assert.Equal(t, kvs[0].Value, target.MustGet("key:0000").Value)
+		})
+	}
+}
+
+func TestKeyValueAdminDumpOrder(t *testing.T) {
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	require.NoError(t, kvQ.UpsertMany([]dban.KeyValue{{Key: "b", Value: "2"}, {Key: "a", Value: "1"}}))
+
+	var dump bytes.Buffer
+	require.NoError(t, dban.NewKeyValueAdmin(kvQ).Dump(&dump, dban.DumpFormatCSV))
+	assert.Equal(t, "key,value\na,1\nb,2\n", dump.String())
+}
+
+func TestKeyValueAdminRestoreMalformed(t *testing.T) {
+	testCases := map[string]struct {
+		format dban.DumpFormat
+		input  string
+		line   int
+	}{
+		"invalid json": {
+			format: dban.DumpFormatJSONLines,
+			input:  "{\"key\":\"a\",\"value\":\"1\"}\n\n{\"key\":\n",
+			line:   3,
+		},
+		"empty key": {
+			format: dban.DumpFormatJSONLines,
+			input:  "{\"key\":\"\",\"value\":\"1\"}\n",
+			line:   1,
+		},
+		"wrong csv header": {
+			format: dban.DumpFormatCSV,
+			input:  "name,value\n",
+			line:   1,
+		},
+		"wrong number of csv fields": {
+			format: dban.DumpFormatCSV,
+			input:  "key,value\na,1\nb\n",
+			line:   3,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := dban.NewKeyValueAdmin(dbantest.NewMemoryKeyValueQ()).Restore(strings.NewReader(tc.input), tc.format, true)
+			var lineErr *dban.LineError
+			require.True(t, stderrors.As(err, &lineErr), "unexpected error: %v", err)
+			assert.Equal(t, tc.line, lineErr.Line)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package dban
+
+import (
+	"database/sql"
+	"math"
+	"strconv"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// sqlStateInvalidTextRepresentation is raised by casting a value that is not a number to
+// bigint, while sqlStateNumericOutOfRange is raised by a number out of the range of bigint
+const (
+	sqlStateInvalidTextRepresentation = "22P02"
+	sqlStateNumericOutOfRange         = "22003"
+)
+
+// CursorAdvancer is a key value querier able to advance a numeric cursor in one round trip
+type CursorAdvancer interface {
+	// AdvanceCursor adds delta to the numeric value by the key (a missing one is treated
+	// as 0) and returns the value it had before. Values that are not numbers fail with
+	// ErrCorruptCursor, while a value or a delta above math.MaxInt64, or a sum of them, fails
+	// with ErrCursorOutOfRange
+	AdvanceCursor(key string, delta uint64) (previous uint64, err error)
+}
+
+var _ CursorAdvancer = (*keyValueQ)(nil)
+
+func (q *keyValueQ) AdvanceCursor(key string, delta uint64) (uint64, error) {
+	if err := q.validateKey(key); err != nil {
+		return 0, err
+	}
+
+	fields := logan.F{"key": key, "delta": delta}
+	if delta > math.MaxInt64 {
+		return 0, errors.From(ErrCursorOutOfRange, fields)
+	}
+
+	var previous uint64
+	err := q.audited(func(q *keyValueQ) error {
+		return q.db.GetRawContext(q.ctx, &previous, q.queries.advanceCursor[q.resets()], key, strconv.FormatUint(delta, 10), delta, delta)
+	})
+	countKV(kvVarUpsert, err)
+	if err != nil {
+		switch {
+		case hasSQLState(err, sqlStateNumericOutOfRange):
+			return 0, errors.Wrap(&kindError{kind: ErrCursorOutOfRange, err: err}, "failed to advance cursor", fields)
+		case isNumericCastFailure(err):
+			return 0, errors.Wrap(&kindError{kind: ErrCorruptCursor, err: err}, "failed to advance cursor", fields)
+		}
+		if err == sql.ErrNoRows {
+			return 0, errors.From(errors.New("cursor was not returned"), fields)
+		}
+		return 0, errors.Wrap(q.classify(err), "failed to advance cursor", fields)
+	}
+
+	if q.events != nil {
+		q.events.Emit(KVWritten{Key: key})
+	}
+	return previous, nil
+}
+
+// isNumericCastFailure reports whether err is a failure to cast a value to bigint
+func isNumericCastFailure(err error) bool {
+	return hasSQLState(err, sqlStateInvalidTextRepresentation) || hasSQLState(err, sqlStateNumericOutOfRange)
+}
+
+// hasSQLState reports whether err or an error it wraps has the SQLSTATE
+func hasSQLState(err error, state string) bool {
+	return walk(err, func(err error) bool { return sqlState(err) == state })
+}
@@ -0,0 +1,125 @@
+package dban
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const advanceCursorSQL = "INSERT INTO key_value (key,value) VALUES ($1,$2) " +
+	"ON CONFLICT (key) DO UPDATE SET value = (key_value.value::bigint + $3)::text RETURNING value::bigint - $4"
+
+func TestAdvanceCursor(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db).(CursorAdvancer)
+
+	mock.ExpectQuery(advanceCursorSQL).WithArgs("cursor", "1", 1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow(41))
+	previous, err := kvQ.AdvanceCursor("cursor", 1)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(41), previous)
+
+	mock.ExpectQuery(advanceCursorSQL).WithArgs("cursor", "1", 1, 1).WillReturnError(&pq.Error{Code: "22P02"})
+	_, err = kvQ.AdvanceCursor("cursor", 1)
+	assert.True(t, Is(err, ErrCorruptCursor))
+
+	mock.ExpectQuery(advanceCursorSQL).WithArgs("cursor", "1", 1, 1).WillReturnError(&pq.Error{Code: "22003"})
+	_, err = kvQ.AdvanceCursor("cursor", 1)
+	assert.True(t, Is(err, ErrCursorOutOfRange))
+	assert.False(t, Is(err, ErrCorruptCursor), "a cursor out of range is not corrupt")
+
+	_, err = kvQ.AdvanceCursor("cursor", math.MaxInt64+1)
+	assert.True(t, Is(err, ErrCursorOutOfRange), "a delta out of range must be rejected without a query")
+
+	_, err = kvQ.AdvanceCursor("", 1)
+	assert.True(t, Is(err, ErrInvalidKey))
+}
+
+func TestAdvanceCursorPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db)
+	kvQ := NewKeyValueQ(db)
+
+	const (
+		workers  = 8
+		advances = 25
+	)
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		pages []uint64
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			advancer := kvQ.New().(CursorAdvancer)
+			for i := 0; i < advances; i++ {
+				page, err := advancer.AdvanceCursor("cursor", 1)
+				if !assert.NoError(t, err) {
+					return
+				}
+				mu.Lock()
+				pages = append(pages, page)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(pages, func(i, j int) bool { return pages[i] < pages[j] })
+	for i, page := range pages {
+		require.Equal(t, uint64(i), page, "every page must be taken exactly once")
+	}
+	assert.Equal(t, strconv.Itoa(workers*advances), kvQ.MustGet("cursor").Value)
+
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "cursor", Value: "1O"}))
+	_, err := kvQ.(CursorAdvancer).AdvanceCursor("cursor", 1)
+	assert.True(t, Is(err, ErrCorruptCursor))
+
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "cursor", Value: strconv.FormatInt(math.MaxInt64, 10)}))
+	_, err = kvQ.(CursorAdvancer).AdvanceCursor("cursor", 1)
+	assert.True(t, Is(err, ErrCursorOutOfRange))
+}
+
+func BenchmarkAdvanceCursor(b *testing.B) {
+	db := openTestPostgres(b)
+	migrateTestPostgres(b, db)
+	kvQ := NewKeyValueQ(db)
+
+	b.Run("lock and upsert", func(b *testing.B) {
+		txQ := kvQ.(TransactionalKeyValueQ)
+		for i := 0; i < b.N; i++ {
+			err := txQ.Transaction(func(q KeyValueQ) error {
+				kv, err := q.LockingGet("cursor")
+				if err != nil {
+					return err
+				}
+				var page uint64
+				if kv != nil {
+					page, _ = strconv.ParseUint(kv.Value, 10, 64)
+				}
+				return q.Upsert(KeyValue{Key: "cursor", Value: strconv.FormatUint(page+1, 10)})
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("advance", func(b *testing.B) {
+		advancer := kvQ.(CursorAdvancer)
+		for i := 0; i < b.N; i++ {
+			if _, err := advancer.AdvanceCursor("cursor", 1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
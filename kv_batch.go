@@ -0,0 +1,121 @@
+package dban
+
+import (
+	"sort"
+
+	"github.com/Masterminds/squirrel"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// ManyLocker is a key value querier able to lock several rows with a single query
+type ManyLocker interface {
+	// LockingGetMany does the same thing as GetMany, but locks the rows found the way
+	// LockingGet does, with a single statement. Rows are locked in the order of the keys
+	// whatever order they are passed in, so that concurrent calls locking overlapping keys
+	// do not deadlock. The locks isolate anything only until the end of the transaction,
+	// so the Postgres querier fails with ErrNoTransaction outside one (see
+	// TransactionalKeyValueQ). Missing keys are absent from the map and are not locked
+	LockingGetMany(keys []string) (map[string]KeyValue, error)
+}
+
+var _ ManyLocker = (*keyValueQ)(nil)
+
+func (q *keyValueQ) GetMany(keys []string) (map[string]KeyValue, error) {
+	return q.getMany(keys, false)
+}
+
+func (q *keyValueQ) LockingGetMany(keys []string) (map[string]KeyValue, error) {
+	if len(keys) != 0 {
+		if err := q.checkTx(keys[0]); err != nil {
+			return nil, err
+		}
+	}
+	return q.getMany(sortedKeys(keys), true)
+}
+
+// sortedKeys returns the distinct keys in order. The rows are locked in the order of
+// ORDER BY anyway, so the statement just does not depend on the order of the arguments
+func sortedKeys(keys []string) []string {
+	sorted := make([]string, len(keys))
+	copy(sorted, keys)
+	sort.Strings(sorted)
+
+	distinct := sorted[:0]
+	for i, key := range sorted {
+		if i == 0 || key != sorted[i-1] {
+			distinct = append(distinct, key)
+		}
+	}
+	return distinct
+}
+
+func (q *keyValueQ) getMany(keys []string, forUpdate bool) (map[string]KeyValue, error) {
+	values := make(map[string]KeyValue, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	query, operation := q.live(q.queries.selectKV.Where(squirrel.Eq{q.queries.key: keys})), kvVarGet
+	if forUpdate {
+		query, operation = query.OrderBy(q.queries.key).Suffix("FOR UPDATE"), kvVarLockingGet
+	}
+
+	var found []KeyValue
+	err := q.db.SelectContext(q.ctx, &found, query)
+	countKV(operation, err)
+	if err != nil {
+		return nil, errors.Wrap(q.classify(err), "failed to get values", logan.F{"keys": keys})
+	}
+
+	for _, kv := range found {
+		values[kv.Key] = kv
+	}
+	return values, nil
+}
+
+func (q *keyValueQ) UpsertMany(kvs []KeyValue) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+	if err := q.validateBatch(kvs); err != nil {
+		return err
+	}
+
+	kvs = lastValues(kvs)
+	query := squirrel.Insert(q.queries.table).Columns(q.queries.key, q.queries.value).Suffix(q.queries.updateValue[q.resets()])
+	for _, kv := range kvs {
+		query = query.Values(kv.Key, kv.Value)
+	}
+
+	err := q.retryWrite(kvs[0].Key, func(q *keyValueQ) error {
+		return q.db.ExecContext(q.ctx, query)
+	})
+	countKV(kvVarUpsert, err)
+	if err != nil {
+		return errors.Wrap(q.classify(err), "failed to upsert values", logan.F{"count": len(kvs)})
+	}
+
+	if q.events != nil {
+		for _, kv := range kvs {
+			q.events.Emit(KVWritten{Key: kv.Key})
+		}
+	}
+	return nil
+}
+
+// lastValues leaves the last value of every key in the order the keys first occur, as
+// Postgres cannot update the same row twice in one statement
+func lastValues(kvs []KeyValue) []KeyValue {
+	positions := make(map[string]int, len(kvs))
+	unique := make([]KeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		if i, ok := positions[kv.Key]; ok {
+			unique[i] = kv
+			continue
+		}
+		positions[kv.Key] = len(unique)
+		unique = append(unique, kv)
+	}
+	return unique
+}
@@ -0,0 +1,87 @@
+package dban
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueQGetMany(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db)
+
+	values, err := kvQ.GetMany(nil)
+	require.NoError(t, err)
+	assert.Empty(t, values)
+
+	mock.ExpectQuery("SELECT key, value FROM key_value WHERE key IN ($1,$2,$3)").
+		WithArgs("foo", "bar", "missing").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("foo", "1").AddRow("bar", "2"))
+	values, err = kvQ.GetMany([]string{"foo", "bar", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]KeyValue{
+		"foo": {Key: "foo", Value: "1"},
+		"bar": {Key: "bar", Value: "2"},
+	}, values)
+
+	_, err = kvQ.(ManyLocker).LockingGetMany([]string{"foo"})
+	assert.True(t, Is(err, ErrNoTransaction))
+
+	mock.ExpectQuery("SELECT key, value FROM key_value WHERE key IN ($1,$2) ORDER BY key FOR UPDATE").
+		WithArgs("bar", "foo").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("bar", "2"))
+	values, err = NewKeyValueQ(withMockTx(db)).(ManyLocker).LockingGetMany([]string{"foo", "bar", "foo"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]KeyValue{"bar": {Key: "bar", Value: "2"}}, values)
+}
+
+func TestKeyValueQUpsertMany(t *testing.T) {
+	db, mock := newMockDB(t)
+	events := make(chan Event, 3)
+	sink := NewAsyncEventSink(eventSinkFunc(func(event Event) { events <- event }), 3)
+	kvQ := NewKeyValueQ(db, WithEventSink(sink))
+
+	require.NoError(t, kvQ.UpsertMany(nil))
+
+	mock.ExpectExec("INSERT INTO key_value (key,value) VALUES ($1,$2),($3,$4) "+
+		"ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value").
+		WithArgs("foo", "3", "bar", "2").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	require.NoError(t, kvQ.UpsertMany([]KeyValue{
+		{Key: "foo", Value: "1"},
+		{Key: "bar", Value: "2"},
+		{Key: "foo", Value: "3"},
+	}), "the last value of a repeated key must win")
+
+	err := kvQ.UpsertMany([]KeyValue{{Key: "baz", Value: "1"}, {Key: "", Value: "2"}})
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, 1, batchErr.Errors[0].Index)
+
+	sink.Close()
+	assert.Equal(t, KVWritten{Key: "foo"}, <-events)
+	assert.Equal(t, KVWritten{Key: "bar"}, <-events)
+	assert.Empty(t, events)
+}
+
+func TestKeyValueQUpsertManyPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db)
+	kvQ := NewKeyValueQ(db)
+
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "existing", Value: "0"}))
+	require.NoError(t, kvQ.UpsertMany([]KeyValue{
+		{Key: "existing", Value: "1"},
+		{Key: "new", Value: "1"},
+		{Key: "new", Value: "2"},
+	}))
+
+	values, err := kvQ.GetMany([]string{"existing", "new"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]KeyValue{
+		"existing": {Key: "existing", Value: "1"},
+		"new":      {Key: "new", Value: "2"},
+	}, values)
+}
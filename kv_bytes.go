@@ -0,0 +1,95 @@
+package dban
+
+import (
+	"database/sql"
+
+	"github.com/Masterminds/squirrel"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// BinaryKeyValueQ is a key value querier able to store binary values. A key holds either
+// a text or a binary value: writing one kind of value drops the other one, and text reads
+// of a binary value return an empty string
+type BinaryKeyValueQ interface {
+	// UpsertBytes does the same thing as Upsert, but with a binary value. Validators of the
+	// querier are not run, as they check text values
+	UpsertBytes(key string, value []byte) error
+	// GetBytes gets the binary value by the key, or nil if there is no value. A text value
+	// fails with ErrValueNotBinary
+	GetBytes(key string) ([]byte, error)
+}
+
+var _ BinaryKeyValueQ = (*keyValueQ)(nil)
+
+const valueBytesColumn = "value_bytes"
+
+// WithBinaryValues makes the querier support BinaryKeyValueQ: text writes drop the binary
+// value of the key. Requires FeatureBinary
+func WithBinaryValues() KeyValueQOption {
+	return func(q *keyValueQ) {
+		q.binary = true
+	}
+}
+
+// resets returns the columns upserts of the querier reset
+func (q *keyValueQ) resets() resets {
+	var reset resets
+	if q.ttl {
+		reset |= resetExpiry
+	}
+	if q.binary {
+		reset |= resetBytes
+	}
+	if q.softDelete {
+		reset |= resetDeleted
+	}
+	return reset
+}
+
+func (q *keyValueQ) UpsertBytes(key string, value []byte) error {
+	fields := logan.F{"key": key, "size": len(value)}
+	if !q.binary {
+		return errors.From(errors.New("querier does not support binary values, see WithBinaryValues"), fields)
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	// validators check text values, so only the key is validated
+	if err := q.validateKey(key); err != nil {
+		return err
+	}
+
+	err := q.retryWrite(key, func(q *keyValueQ) error {
+		return q.db.ExecRawContext(q.ctx, q.queries.upsertBytes[q.resets()], key, "", value)
+	})
+	countKV(kvVarUpsert, err)
+	if err != nil {
+		return errors.Wrap(q.classify(err), "failed to upsert binary value", fields)
+	}
+
+	if q.events != nil {
+		q.events.Emit(KVWritten{Key: key})
+	}
+	return nil
+}
+
+func (q *keyValueQ) GetBytes(key string) ([]byte, error) {
+	query := q.live(squirrel.Select(valueBytesColumn).From(q.queries.table).Where(squirrel.Eq{q.queries.key: key}))
+
+	var value *[]byte
+	err := q.db.GetContext(q.ctx, &value, query)
+	if err == sql.ErrNoRows {
+		countKV(kvVarGet, nil)
+		return nil, nil
+	}
+	countKV(kvVarGet, err)
+	if err != nil {
+		return nil, errors.Wrap(q.classify(err), "failed to get binary value", logan.F{"key": key})
+	}
+	if value == nil {
+		return nil, errors.From(ErrValueNotBinary, logan.F{"key": key})
+	}
+
+	return *value, nil
+}
@@ -0,0 +1,77 @@
+package dban
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueQBytes(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db, WithBinaryValues())
+
+	const (
+		upsertBytesSQL = "INSERT INTO key_value (key,value,value_bytes) VALUES ($1,$2,$3) " +
+			"ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, value_bytes = EXCLUDED.value_bytes"
+		upsertTextSQL = "INSERT INTO key_value (key,value) VALUES ($1,$2) " +
+			"ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, value_bytes = NULL"
+		getBytesSQL = "SELECT value_bytes FROM key_value WHERE key = $1"
+	)
+	mock.ExpectExec(upsertBytesSQL).WithArgs("blob", "", []byte{0, 1, 2}).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(getBytesSQL).WithArgs("blob").WillReturnRows(
+		sqlmock.NewRows([]string{"value_bytes"}).AddRow([]byte{0, 1, 2}),
+	)
+	mock.ExpectExec(upsertTextSQL).WithArgs("text", "value").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(getBytesSQL).WithArgs("text").WillReturnRows(
+		sqlmock.NewRows([]string{"value_bytes"}).AddRow(nil),
+	)
+	mock.ExpectQuery(getBytesSQL).WithArgs("missing").WillReturnError(sql.ErrNoRows)
+
+	binQ := kvQ.(BinaryKeyValueQ)
+	require.NoError(t, binQ.UpsertBytes("blob", []byte{0, 1, 2}))
+	value, err := binQ.GetBytes("blob")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 2}, value)
+
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "text", Value: "value"}))
+	_, err = binQ.GetBytes("text")
+	assert.True(t, Is(err, ErrValueNotBinary), "unexpected error: %v", err)
+
+	value, err = binQ.GetBytes("missing")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+}
+
+func TestKeyValueQBytesNotEnabled(t *testing.T) {
+	db, _ := newMockDB(t)
+	kvQ := NewKeyValueQ(db)
+
+	assert.Error(t, kvQ.(BinaryKeyValueQ).UpsertBytes("blob", []byte{1}))
+}
+
+func TestKeyValueQBytesPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db, WithFeatures(FeatureBinary))
+	kvQ := NewKeyValueQ(db, WithBinaryValues())
+	binQ := kvQ.(BinaryKeyValueQ)
+
+	require.NoError(t, binQ.UpsertBytes("key", []byte{0xff, 0}))
+	value, err := binQ.GetBytes("key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0}, value)
+
+	// a text write replaces the binary value
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "key", Value: "text"}))
+	_, err = binQ.GetBytes("key")
+	assert.True(t, Is(err, ErrValueNotBinary), "unexpected error: %v", err)
+	assert.Equal(t, "text", kvQ.MustGet("key").Value)
+
+	require.NoError(t, binQ.UpsertBytes("key", []byte{}))
+	value, err = binQ.GetBytes("key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{}, value)
+	assert.Equal(t, "", kvQ.MustGet("key").Value)
+}
@@ -0,0 +1,268 @@
+package dban
+
+import (
+	"sync"
+	"time"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// kvCache is a cache of values shared by a cached querier and its clones
+type kvCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.RWMutex
+	entries map[string]kvCacheEntry
+}
+
+// kvCacheEntry is a cached value, nil if there was no value by the key
+type kvCacheEntry struct {
+	kv      *KeyValue
+	expires time.Time
+}
+
+func (c *kvCache) get(key string) (*KeyValue, bool) {
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+	if !ok || !c.now().Before(entry.expires) {
+		return nil, false
+	}
+	return copyKV(entry.kv), true
+}
+
+func (c *kvCache) put(key string, kv *KeyValue) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = kvCacheEntry{kv: copyKV(kv), expires: c.now().Add(c.ttl)}
+}
+
+func (c *kvCache) invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+}
+
+// copyKV copies kv, so that callers could not change cached values
+func copyKV(kv *KeyValue) *KeyValue {
+	if kv == nil {
+		return nil
+	}
+	copied := *kv
+	return &copied
+}
+
+type cachedKeyValueQ struct {
+	inner KeyValueQ
+	cache *kvCache
+	// written collects keys written within a transaction, which are invalidated once more
+	// after it ends, as values could have been cached from outside of it meanwhile
+	written *[]string
+}
+
+// NewCachedKeyValueQ creates a querier caching results of Get and GetMany of inner for ttl,
+// including missing values. Writes through the querier invalidate the values they change,
+// while writes bypassing it are seen once the ttl passes. Locking reads and reads within
+// transactions are not cached. Clones made by New share the cache
+func NewCachedKeyValueQ(inner KeyValueQ, ttl time.Duration) KeyValueQ {
+	return &cachedKeyValueQ{
+		inner: inner,
+		cache: &kvCache{
+			ttl:     ttl,
+			now:     time.Now,
+			entries: make(map[string]kvCacheEntry),
+		},
+	}
+}
+
+func (q *cachedKeyValueQ) New() KeyValueQ {
+	return &cachedKeyValueQ{
+		inner: q.inner.New(),
+		cache: q.cache,
+	}
+}
+
+// cached reports whether reads are served from the cache, which they are not within
+// transactions, as those could see their own writes
+func (q *cachedKeyValueQ) cached() bool {
+	return q.written == nil && !withinTx(q.inner)
+}
+
+func (q *cachedKeyValueQ) Get(key string) (*KeyValue, error) {
+	if !q.cached() {
+		return q.inner.Get(key)
+	}
+	if kv, ok := q.cache.get(key); ok {
+		return kv, nil
+	}
+
+	kv, err := q.inner.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	q.cache.put(key, kv)
+	return kv, nil
+}
+
+func (q *cachedKeyValueQ) GetMany(keys []string) (map[string]KeyValue, error) {
+	if !q.cached() {
+		return q.inner.GetMany(keys)
+	}
+
+	values := make(map[string]KeyValue, len(keys))
+	var missed []string
+	for _, key := range keys {
+		kv, ok := q.cache.get(key)
+		switch {
+		case !ok:
+			missed = append(missed, key)
+		case kv != nil:
+			values[key] = *kv
+		}
+	}
+	if len(missed) == 0 {
+		return values, nil
+	}
+
+	fetched, err := q.inner.GetMany(missed)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range missed {
+		kv, ok := fetched[key]
+		if !ok {
+			q.cache.put(key, nil)
+			continue
+		}
+		q.cache.put(key, &kv)
+		values[key] = kv
+	}
+	return values, nil
+}
+
+func (q *cachedKeyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.inner.SelectByPrefix(prefix, params)
+}
+
+func (q *cachedKeyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	return q.inner.ListKeys(prefix, params)
+}
+
+func (q *cachedKeyValueQ) Count() (uint64, error) {
+	return q.inner.Count()
+}
+
+func (q *cachedKeyValueQ) FilterByKeys(keys ...string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByKeys(keys...)
+	return &filtered
+}
+
+func (q *cachedKeyValueQ) FilterByPrefix(prefix string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByPrefix(prefix)
+	return &filtered
+}
+
+func (q *cachedKeyValueQ) FilterByValueLike(pattern string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByValueLike(pattern)
+	return &filtered
+}
+
+// Select bypasses the cache the way SelectByPrefix does
+func (q *cachedKeyValueQ) Select() ([]KeyValue, error) {
+	return q.inner.Select()
+}
+
+func (q *cachedKeyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.inner.SelectPage(params)
+}
+
+func (q *cachedKeyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.SelectPage(KeyValueStreamParams(params))
+}
+
+func (q *cachedKeyValueQ) MustGet(key string) *KeyValue {
+	value, err := GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+// LockingGet is not cached, as locking reads are made for correctness
+func (q *cachedKeyValueQ) LockingGet(key string) (*KeyValue, error) {
+	return q.inner.LockingGet(key)
+}
+
+func (q *cachedKeyValueQ) MustLockingGet(key string) *KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
+
+func (q *cachedKeyValueQ) Upsert(kv KeyValue) error {
+	defer q.invalidate(kv.Key)
+	return q.inner.Upsert(kv)
+}
+
+func (q *cachedKeyValueQ) UpsertMany(kvs []KeyValue) error {
+	keys := make([]string, len(kvs))
+	for i, kv := range kvs {
+		keys[i] = kv.Key
+	}
+	defer q.invalidate(keys...)
+	return q.inner.UpsertMany(kvs)
+}
+
+func (q *cachedKeyValueQ) Delete(key string) error {
+	defer q.invalidate(key)
+	return q.inner.Delete(key)
+}
+
+func (q *cachedKeyValueQ) DeleteMany(keys []string) (int64, error) {
+	defer q.invalidate(keys...)
+	return q.inner.DeleteMany(keys)
+}
+
+func (q *cachedKeyValueQ) Update(key string, fn func(current *string) (string, error)) (KeyValue, error) {
+	defer q.invalidate(key)
+	return q.inner.Update(key, fn)
+}
+
+// invalidate drops the keys from the cache, even if the write failed, as it could have
+// been applied anyway
+func (q *cachedKeyValueQ) invalidate(keys ...string) {
+	q.cache.invalidate(keys...)
+	if q.written != nil {
+		*q.written = append(*q.written, keys...)
+	}
+}
+
+func (q *cachedKeyValueQ) Transaction(fn func(q KeyValueQ) error) error {
+	if q.written != nil {
+		return transaction(q.inner, func(inner KeyValueQ) error {
+			tx := *q
+			tx.inner = inner
+			return fn(&tx)
+		})
+	}
+
+	var written []string
+	defer func() { q.cache.invalidate(written...) }()
+	return transaction(q.inner, func(inner KeyValueQ) error {
+		return fn(&cachedKeyValueQ{inner: inner, cache: q.cache, written: &written})
+	})
+}
+
+func (q *cachedKeyValueQ) WithinTx() bool {
+	return withinTx(q.inner)
+}
@@ -0,0 +1,121 @@
+package dban_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+// countingKeyValueQ counts reads reaching the storage
+type countingKeyValueQ struct {
+	dban.KeyValueQ
+	mu    sync.Mutex
+	reads int
+}
+
+func (q *countingKeyValueQ) New() dban.KeyValueQ {
+	return q
+}
+
+func (q *countingKeyValueQ) Get(key string) (*dban.KeyValue, error) {
+	q.mu.Lock()
+	q.reads++
+	q.mu.Unlock()
+	return q.KeyValueQ.Get(key)
+}
+
+func (q *countingKeyValueQ) GetMany(keys []string) (map[string]dban.KeyValue, error) {
+	q.mu.Lock()
+	q.reads++
+	q.mu.Unlock()
+	return q.KeyValueQ.GetMany(keys)
+}
+
+func (q *countingKeyValueQ) LockingGet(key string) (*dban.KeyValue, error) {
+	q.mu.Lock()
+	q.reads++
+	q.mu.Unlock()
+	return q.KeyValueQ.LockingGet(key)
+}
+
+func (q *countingKeyValueQ) Reads() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.reads
+}
+
+func TestCachedKeyValueQStaleness(t *testing.T) {
+	inner := &countingKeyValueQ{KeyValueQ: dbantest.NewMemoryKeyValueQ()}
+	kvQ := dban.NewCachedKeyValueQ(inner, 50*time.Millisecond)
+
+	require.NoError(t, inner.Upsert(dban.KeyValue{Key: "page", Value: "1"}))
+	assert.Equal(t, "1", kvQ.MustGet("page").Value)
+
+	// a write bypassing the cache is not seen until the ttl passes
+	require.NoError(t, inner.Upsert(dban.KeyValue{Key: "page", Value: "2"}))
+	assert.Equal(t, "1", kvQ.New().MustGet("page").Value, "clones share the cache")
+	assert.Equal(t, 1, inner.Reads())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, "2", kvQ.MustGet("page").Value)
+	assert.Equal(t, 2, inner.Reads())
+
+	missing, err := kvQ.Get("missing")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+	missing, err = kvQ.Get("missing")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+	assert.Equal(t, 3, inner.Reads(), "missing values are cached as well")
+}
+
+func TestCachedKeyValueQInvalidation(t *testing.T) {
+	inner := &countingKeyValueQ{KeyValueQ: dbantest.NewMemoryKeyValueQ()}
+	kvQ := dban.NewCachedKeyValueQ(inner, time.Hour)
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "a", Value: "1"}))
+	assert.Equal(t, "1", kvQ.MustGet("a").Value)
+	require.NoError(t, kvQ.New().Upsert(dban.KeyValue{Key: "a", Value: "2"}))
+	assert.Equal(t, "2", kvQ.MustGet("a").Value, "writes of clones invalidate the shared cache")
+
+	values, err := kvQ.GetMany([]string{"a", "b"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]dban.KeyValue{"a": {Key: "a", Value: "2"}}, values)
+	require.NoError(t, kvQ.UpsertMany([]dban.KeyValue{{Key: "b", Value: "3"}}))
+	values, err = kvQ.GetMany([]string{"a", "b"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]dban.KeyValue{"a": {Key: "a", Value: "2"}, "b": {Key: "b", Value: "3"}}, values)
+
+	require.NoError(t, kvQ.Delete("a"))
+	a, err := kvQ.Get("a")
+	require.NoError(t, err)
+	assert.Nil(t, a)
+
+	reads := inner.Reads()
+	assert.Equal(t, "3", kvQ.MustLockingGet("b").Value)
+	assert.Equal(t, reads+1, inner.Reads(), "locking reads bypass the cache")
+}
+
+func TestCachedKeyValueQConcurrency(t *testing.T) {
+	kvQ := dban.NewCachedKeyValueQ(dbantest.NewMemoryKeyValueQ(), time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q := kvQ.New()
+			for j := 0; j < 100; j++ {
+				assert.NoError(t, q.Upsert(dban.KeyValue{Key: "page", Value: "1"}))
+				_, err := q.Get("page")
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,77 @@
+package dban
+
+import (
+	"github.com/Masterminds/squirrel"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// CompareAndSwapper is a key value querier able to write a value conditionally in one
+// round trip, so that workers sharing a key could coordinate optimistically without a
+// transaction
+type CompareAndSwapper interface {
+	// UpdateIfEquals sets the value by the key to newValue if it is expected and reports
+	// whether it was set. A missing key is never updated (see InsertIfAbsent)
+	UpdateIfEquals(key, expected, newValue string) (bool, error)
+	// InsertIfAbsent inserts the key value unless there is a value by the key already and
+	// reports whether it was inserted
+	InsertIfAbsent(kv KeyValue) (bool, error)
+}
+
+var _ CompareAndSwapper = (*keyValueQ)(nil)
+
+func (q *keyValueQ) UpdateIfEquals(key, expected, newValue string) (bool, error) {
+	if err := q.validate(KeyValue{Key: key, Value: newValue}); err != nil {
+		return false, err
+	}
+
+	var swapped bool
+	err := q.retryWrite(key, func(q *keyValueQ) (err error) {
+		swapped, err = q.execAffecting(q.queries.updateIfEquals, newValue, key, expected)
+		return err
+	})
+	countKV(kvVarUpsert, err)
+	if err != nil {
+		return false, errors.Wrap(q.classify(err), "failed to update value", logan.F{"key": key})
+	}
+
+	if swapped && q.events != nil {
+		q.events.Emit(KVWritten{Key: key})
+	}
+	return swapped, nil
+}
+
+func (q *keyValueQ) InsertIfAbsent(kv KeyValue) (bool, error) {
+	if err := q.validate(kv); err != nil {
+		return false, err
+	}
+
+	var inserted bool
+	err := q.retryWrite(kv.Key, func(q *keyValueQ) (err error) {
+		inserted, err = q.execAffecting(q.queries.insertIfAbsent, kv.Key, kv.Value)
+		return err
+	})
+	countKV(kvVarUpsert, err)
+	if err != nil {
+		return false, errors.Wrap(q.classify(err), "failed to insert value", logan.F{"key": kv.Key})
+	}
+
+	if inserted && q.events != nil {
+		q.events.Emit(KVWritten{Key: kv.Key})
+	}
+	return inserted, nil
+}
+
+// execAffecting runs the query and reports whether it affected a row
+func (q *keyValueQ) execAffecting(query string, args ...interface{}) (bool, error) {
+	result, err := q.db.ExecWithResultContext(q.ctx, squirrel.Expr(query, args...))
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected != 0, nil
+}
@@ -0,0 +1,52 @@
+package dban
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateIfEquals(t *testing.T) {
+	db, mock := newMockDB(t)
+	events := make(chan Event, 2)
+	sink := NewAsyncEventSink(eventSinkFunc(func(event Event) { events <- event }), 2)
+	kvQ := NewKeyValueQ(db, WithEventSink(sink)).(CompareAndSwapper)
+
+	const updateSQL = "UPDATE key_value SET value = $1 WHERE key = $2 AND value = $3"
+	mock.ExpectExec(updateSQL).WithArgs("2", "owner", "1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(updateSQL).WithArgs("3", "owner", "1").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	swapped, err := kvQ.UpdateIfEquals("owner", "1", "2")
+	require.NoError(t, err)
+	assert.True(t, swapped)
+
+	swapped, err = kvQ.UpdateIfEquals("owner", "1", "3")
+	require.NoError(t, err)
+	assert.False(t, swapped)
+
+	_, err = kvQ.UpdateIfEquals(" ", "1", "2")
+	assert.True(t, Is(err, ErrInvalidKey))
+
+	sink.Close()
+	assert.Equal(t, KVWritten{Key: "owner"}, <-events)
+	assert.Empty(t, events, "a failed swap must not emit an event")
+}
+
+func TestInsertIfAbsent(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db).(CompareAndSwapper)
+
+	const insertSQL = "INSERT INTO key_value (key,value) VALUES ($1,$2) ON CONFLICT (key) DO NOTHING"
+	mock.ExpectExec(insertSQL).WithArgs("owner", "1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(insertSQL).WithArgs("owner", "2").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	inserted, err := kvQ.InsertIfAbsent(KeyValue{Key: "owner", Value: "1"})
+	require.NoError(t, err)
+	assert.True(t, inserted)
+
+	inserted, err = kvQ.InsertIfAbsent(KeyValue{Key: "owner", Value: "2"})
+	require.NoError(t, err)
+	assert.False(t, inserted)
+}
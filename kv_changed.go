@@ -0,0 +1,38 @@
+package dban
+
+import (
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// ChangeDetector is a key value querier able to tell whether a write changed anything, so
+// that change-driven workflows could skip identical writes without reading first
+type ChangeDetector interface {
+	// UpsertChanged does the same thing as Upsert, but leaves an identical value untouched
+	// and reports whether the value was inserted or changed
+	UpsertChanged(kv KeyValue) (bool, error)
+}
+
+var _ ChangeDetector = (*keyValueQ)(nil)
+
+func (q *keyValueQ) UpsertChanged(kv KeyValue) (bool, error) {
+	if err := q.validate(kv); err != nil {
+		return false, err
+	}
+
+	// DO UPDATE skipped by its WHERE clause affects no rows
+	var changed bool
+	err := q.retryWrite(kv.Key, func(q *keyValueQ) (err error) {
+		changed, err = q.execAffecting(q.queries.upsertChanged[q.resets()], kv.Key, kv.Value)
+		return err
+	})
+	countKV(kvVarUpsert, err)
+	if err != nil {
+		return false, errors.Wrap(q.classify(err), "failed to upsert value", logan.F{"key": kv.Key})
+	}
+
+	if changed && q.events != nil {
+		q.events.Emit(KVWritten{Key: kv.Key})
+	}
+	return changed, nil
+}
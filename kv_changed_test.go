@@ -0,0 +1,57 @@
+package dban
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueQUpsertChanged(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db).(ChangeDetector)
+	query := "INSERT INTO key_value (key,value) VALUES ($1,$2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value " +
+		"WHERE key_value.value IS DISTINCT FROM EXCLUDED.value"
+
+	mock.ExpectExec(query).WithArgs("foo", "1").WillReturnResult(sqlmock.NewResult(0, 1))
+	changed, err := kvQ.UpsertChanged(KeyValue{Key: "foo", Value: "1"})
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	mock.ExpectExec(query).WithArgs("foo", "1").WillReturnResult(sqlmock.NewResult(0, 0))
+	changed, err = kvQ.UpsertChanged(KeyValue{Key: "foo", Value: "1"})
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestKeyValueQUpsertChangedResets(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db, WithTTL()).(ChangeDetector)
+
+	mock.ExpectExec("INSERT INTO key_value (key,value) VALUES ($1,$2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = NULL "+
+		"WHERE key_value.value IS DISTINCT FROM EXCLUDED.value OR key_value.expires_at IS NOT NULL").
+		WithArgs("foo", "1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	changed, err := kvQ.UpsertChanged(KeyValue{Key: "foo", Value: "1"})
+	require.NoError(t, err)
+	assert.True(t, changed)
+}
+
+func TestKeyValueQUpsertChangedPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db)
+	kvQ := NewKeyValueQ(db).(ChangeDetector)
+
+	changed, err := kvQ.UpsertChanged(KeyValue{Key: "foo", Value: "1"})
+	require.NoError(t, err)
+	assert.True(t, changed, "insert is a change")
+
+	changed, err = kvQ.UpsertChanged(KeyValue{Key: "foo", Value: "1"})
+	require.NoError(t, err)
+	assert.False(t, changed, "identical value is not a change")
+
+	changed, err = kvQ.UpsertChanged(KeyValue{Key: "foo", Value: "2"})
+	require.NoError(t, err)
+	assert.True(t, changed, "different value is a change")
+}
@@ -0,0 +1,483 @@
+package dban
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// Incrementer is a key value querier able to change a numeric value in one round trip
+type Incrementer interface {
+	// Increment adds delta to the numeric value by the key (a missing one is treated as 0)
+	Increment(key string, delta int64) error
+}
+
+// AtomicCounter is a key value querier able to change a numeric value and return the
+// result in one round trip, without a transaction
+type AtomicCounter interface {
+	// IncrementAndGet adds delta to the numeric value by the key (a missing one is treated
+	// as 0) and returns the result. Values that are not integers fail with ErrValueNotNumeric
+	IncrementAndGet(key string, delta int64) (int64, error)
+	// DecrementAndGet subtracts delta the same way IncrementAndGet adds it
+	DecrementAndGet(key string, delta int64) (int64, error)
+}
+
+var (
+	_ Incrementer   = (*keyValueQ)(nil)
+	_ AtomicCounter = (*keyValueQ)(nil)
+)
+
+// Increment fails with ErrValueNotNumeric if the value is not an integer
+func (q *keyValueQ) Increment(key string, delta int64) error {
+	_, err := q.IncrementAndGet(key, delta)
+	return err
+}
+
+func (q *keyValueQ) IncrementAndGet(key string, delta int64) (int64, error) {
+	if err := q.validateKey(key); err != nil {
+		return 0, err
+	}
+
+	fields := logan.F{"key": key, "delta": delta}
+	var value int64
+	err := q.audited(func(q *keyValueQ) error {
+		return q.db.GetRawContext(q.ctx, &value, q.queries.increment[q.resets()], key, strconv.FormatInt(delta, 10), delta)
+	})
+	countKV(kvVarUpsert, err)
+	if err != nil {
+		if isNumericCastFailure(err) {
+			return 0, errors.Wrap(&kindError{kind: ErrValueNotNumeric, err: err}, "failed to increment value", fields)
+		}
+		return 0, errors.Wrap(q.classify(err), "failed to increment value", fields)
+	}
+
+	if q.events != nil {
+		q.events.Emit(KVWritten{Key: key})
+	}
+	return value, nil
+}
+
+func (q *keyValueQ) DecrementAndGet(key string, delta int64) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, errors.From(errors.New("delta is out of range"), logan.F{"key": key, "delta": delta})
+	}
+	return q.IncrementAndGet(key, -delta)
+}
+
+// BufferedCounterKV is a key value querier accumulating increments of counters in memory and
+// writing them behind. Reads include the deltas that are not flushed yet
+type BufferedCounterKV interface {
+	KeyValueQ
+	Incrementer
+	// Flush writes the accumulated deltas with one Increment per key
+	Flush(ctx context.Context) error
+	// Close stops flushing the deltas periodically and flushes what is left
+	Close(ctx context.Context) error
+}
+
+// FlushFailureHook is invoked when a flush made behind the caller, by Increment or
+// periodically, fails with err. The deltas that failed to be flushed are kept
+type FlushFailureHook func(err error)
+
+// BufferedCounterOption is an optional parameter of a buffered counter querier
+type BufferedCounterOption func(*counterBuffer)
+
+// WithFlushFailureHook sets a hook observing failures of the flushes made behind the
+// caller, e.g. to log them, as Increment does not return them
+func WithFlushFailureHook(hook FlushFailureHook) BufferedCounterOption {
+	return func(b *counterBuffer) {
+		b.onFlushFailure = hook
+	}
+}
+
+type counterBuffer struct {
+	inner          Incrementer
+	flushInterval  time.Duration
+	maxDelta       int64
+	onFlushFailure FlushFailureHook
+	now            func() time.Time
+
+	// flushMu is held by flushes and reads, so that a delta being flushed is neither
+	// missed nor counted twice by a read
+	flushMu   sync.Mutex
+	mu        sync.Mutex
+	deltas    map[string]int64
+	lastFlush time.Time
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+type bufferedCounterKV struct {
+	inner  KeyValueQ
+	buffer *counterBuffer
+}
+
+// NewBufferedCounterKV creates a querier accumulating deltas of Increment in memory. A key
+// is flushed to inner, which must implement Incrementer, once its delta reaches maxDelta in
+// absolute value, and all of them are flushed every flushInterval. Zero values disable the
+// respective trigger. Deltas that are not flushed are lost if the process crashes, that is
+// the increments made since the last successful flush (at most flushInterval worth, each key
+// below maxDelta). Deltas that failed to be flushed are kept and retried with the next flush,
+// so an unavailable storage extends the window, while Increment succeeds once the delta is
+// buffered (see WithFlushFailureHook). Call Close on shutdown.
+// Upsert overwrites the delta accumulated for the key
+func NewBufferedCounterKV(inner KeyValueQ, flushInterval time.Duration, maxDelta int64, opts ...BufferedCounterOption) (BufferedCounterKV, error) {
+	incrementer, ok := inner.(Incrementer)
+	if !ok {
+		return nil, errors.New("buffered counters require a querier implementing Incrementer")
+	}
+
+	buffer := &counterBuffer{
+		inner:         incrementer,
+		flushInterval: flushInterval,
+		maxDelta:      maxDelta,
+		now:           time.Now,
+		deltas:        make(map[string]int64),
+		stop:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(buffer)
+	}
+	buffer.lastFlush = buffer.now()
+	if flushInterval > 0 {
+		go buffer.run()
+	}
+
+	return &bufferedCounterKV{inner: inner, buffer: buffer}, nil
+}
+
+// run flushes the deltas every flushInterval until the buffer is closed. Failed flushes are
+// retried with the next tick
+func (b *counterBuffer) run() {
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.flushBehind(nil)
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// flush writes the deltas of keys (or of every key if keys is nil) to the inner querier
+func (b *counterBuffer) flush(ctx context.Context, keys []string) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	pending := make(map[string]int64)
+	if keys == nil {
+		pending, b.deltas = b.deltas, make(map[string]int64)
+		b.lastFlush = b.now()
+	}
+	for _, key := range keys {
+		pending[key] = b.deltas[key]
+		delete(b.deltas, key)
+	}
+	b.mu.Unlock()
+
+	flushed := make([]string, 0, len(pending))
+	for key := range pending {
+		flushed = append(flushed, key)
+	}
+	sort.Strings(flushed)
+
+	var err error
+	for _, key := range flushed {
+		if pending[key] == 0 {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		if err = b.inner.Increment(key, pending[key]); err != nil {
+			err = errors.Wrap(err, "failed to flush counter", logan.F{"key": key, "delta": pending[key]})
+			break
+		}
+		delete(pending, key)
+	}
+
+	if err != nil {
+		// keep what is not flushed for the next time
+		b.mu.Lock()
+		for key, delta := range pending {
+			b.deltas[key] += delta
+		}
+		b.mu.Unlock()
+	}
+	return err
+}
+
+// flushBehind flushes the deltas the way flush does, reporting a failure to the hook
+func (b *counterBuffer) flushBehind(keys []string) {
+	if err := b.flush(context.Background(), keys); err != nil && b.onFlushFailure != nil {
+		b.onFlushFailure(err)
+	}
+}
+
+// Increment buffers the delta and returns nil, even if the flush it triggers fails, as the
+// delta is kept to be flushed later and retrying the call would count it twice
+func (q *bufferedCounterKV) Increment(key string, delta int64) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	b := q.buffer
+	b.mu.Lock()
+	b.deltas[key] += delta
+	pending := b.deltas[key]
+	due := b.flushInterval > 0 && b.now().Sub(b.lastFlush) >= b.flushInterval
+	b.mu.Unlock()
+
+	switch {
+	case due:
+		b.flushBehind(nil)
+	case b.maxDelta > 0 && (pending >= b.maxDelta || -pending >= b.maxDelta):
+		b.flushBehind([]string{key})
+	}
+	return nil
+}
+
+func (q *bufferedCounterKV) Flush(ctx context.Context) error {
+	return q.buffer.flush(ctx, nil)
+}
+
+func (q *bufferedCounterKV) Close(ctx context.Context) error {
+	q.buffer.closeOnce.Do(func() {
+		close(q.buffer.stop)
+	})
+	return q.Flush(ctx)
+}
+
+func (q *bufferedCounterKV) New() KeyValueQ {
+	return &bufferedCounterKV{inner: q.inner.New(), buffer: q.buffer}
+}
+
+func (q *bufferedCounterKV) Get(key string) (*KeyValue, error) {
+	return q.read(key, q.inner.Get)
+}
+
+// ListKeys lists the stored keys only, so counters that were never flushed are not listed
+func (q *bufferedCounterKV) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	return q.inner.ListKeys(prefix, params)
+}
+
+// Count counts the stored values only, the same way ListKeys lists them
+func (q *bufferedCounterKV) Count() (uint64, error) {
+	return q.inner.Count()
+}
+
+func (q *bufferedCounterKV) FilterByKeys(keys ...string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByKeys(keys...)
+	return &filtered
+}
+
+func (q *bufferedCounterKV) FilterByPrefix(prefix string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByPrefix(prefix)
+	return &filtered
+}
+
+func (q *bufferedCounterKV) FilterByValueLike(pattern string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByValueLike(pattern)
+	return &filtered
+}
+
+// Select selects the stored values only, the same way ListKeys lists them
+func (q *bufferedCounterKV) Select() ([]KeyValue, error) {
+	return q.inner.Select()
+}
+
+func (q *bufferedCounterKV) SelectPage(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.inner.SelectPage(params)
+}
+
+func (q *bufferedCounterKV) SelectWithPageParams(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.SelectPage(KeyValueStreamParams(params))
+}
+
+func (q *bufferedCounterKV) MustGet(key string) *KeyValue {
+	value, err := GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+func (q *bufferedCounterKV) LockingGet(key string) (*KeyValue, error) {
+	return q.read(key, q.inner.LockingGet)
+}
+
+func (q *bufferedCounterKV) MustLockingGet(key string) *KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
+
+func (q *bufferedCounterKV) Upsert(kv KeyValue) error {
+	b := q.buffer
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	delta := b.deltas[kv.Key]
+	delete(b.deltas, kv.Key)
+	b.mu.Unlock()
+
+	if err := q.inner.Upsert(kv); err != nil {
+		b.mu.Lock()
+		b.deltas[kv.Key] += delta
+		b.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (q *bufferedCounterKV) UpsertMany(kvs []KeyValue) error {
+	b := q.buffer
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	deltas := make(map[string]int64, len(kvs))
+	for _, kv := range kvs {
+		if delta, ok := b.deltas[kv.Key]; ok {
+			deltas[kv.Key] = delta
+			delete(b.deltas, kv.Key)
+		}
+	}
+	b.mu.Unlock()
+
+	if err := q.inner.UpsertMany(kvs); err != nil {
+		b.mu.Lock()
+		for key, delta := range deltas {
+			b.deltas[key] += delta
+		}
+		b.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Delete drops the pending delta along with the value
+func (q *bufferedCounterKV) Delete(key string) error {
+	_, err := q.DeleteMany([]string{key})
+	return err
+}
+
+func (q *bufferedCounterKV) DeleteMany(keys []string) (int64, error) {
+	b := q.buffer
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	for _, key := range keys {
+		delete(b.deltas, key)
+	}
+	b.mu.Unlock()
+
+	return q.inner.DeleteMany(keys)
+}
+
+// Update flushes the delta accumulated for the key first, so that fn gets the counted value
+func (q *bufferedCounterKV) Update(key string, fn func(current *string) (string, error)) (KeyValue, error) {
+	if err := q.buffer.flush(context.Background(), []string{key}); err != nil {
+		return KeyValue{}, err
+	}
+	return q.inner.Update(key, fn)
+}
+
+// read gets the value with get and adds the delta that is not flushed yet to it
+func (q *bufferedCounterKV) read(key string, get func(key string) (*KeyValue, error)) (*KeyValue, error) {
+	b := q.buffer
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	kv, err := get(key)
+	if err != nil {
+		return nil, err
+	}
+	return b.withDelta(key, kv)
+}
+
+func (q *bufferedCounterKV) GetMany(keys []string) (map[string]KeyValue, error) {
+	b := q.buffer
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	values, err := q.inner.GetMany(keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		var stored *KeyValue
+		if kv, ok := values[key]; ok {
+			stored = &kv
+		}
+		kv, err := b.withDelta(key, stored)
+		if err != nil {
+			return nil, err
+		}
+		if kv != nil {
+			values[key] = *kv
+		}
+	}
+	return values, nil
+}
+
+// SelectByPrefix adds the pending deltas to the stored values only, so counters that were
+// never flushed are not listed
+func (q *bufferedCounterKV) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	b := q.buffer
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	values, err := q.inner.SelectByPrefix(prefix, params)
+	if err != nil {
+		return nil, err
+	}
+	for i := range values {
+		kv, err := b.withDelta(values[i].Key, &values[i])
+		if err != nil {
+			return nil, err
+		}
+		values[i] = *kv
+	}
+	return values, nil
+}
+
+// withDelta adds the delta that is not flushed yet to the stored value kv
+func (b *counterBuffer) withDelta(key string, kv *KeyValue) (*KeyValue, error) {
+	b.mu.Lock()
+	delta := b.deltas[key]
+	b.mu.Unlock()
+	if delta == 0 {
+		return kv, nil
+	}
+
+	var (
+		value int64
+		err   error
+	)
+	if kv != nil {
+		if value, err = strconv.ParseInt(kv.Value, 10, 64); err != nil {
+			return nil, errors.Wrap(err, "counter value is not a number", logan.F{"key": key})
+		}
+	}
+	return &KeyValue{Key: key, Value: strconv.FormatInt(value+delta, 10)}, nil
+}
@@ -0,0 +1,165 @@
+package dban
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// counterKV is an in-memory Incrementer recording the increments it receives
+type counterKV struct {
+	KeyValueQ
+	values     map[string]int64
+	increments []int64
+	fail       error
+}
+
+func (q *counterKV) New() KeyValueQ { return q }
+
+func (q *counterKV) Get(key string) (*KeyValue, error) {
+	value, ok := q.values[key]
+	if !ok {
+		return nil, nil
+	}
+	return &KeyValue{Key: key, Value: strconv.FormatInt(value, 10)}, nil
+}
+
+func (q *counterKV) Upsert(kv KeyValue) error {
+	value, err := strconv.ParseInt(kv.Value, 10, 64)
+	q.values[kv.Key] = value
+	return err
+}
+
+func (q *counterKV) Increment(key string, delta int64) error {
+	if q.fail != nil {
+		return q.fail
+	}
+	q.values[key] += delta
+	q.increments = append(q.increments, delta)
+	return nil
+}
+
+const incrementSQL = "INSERT INTO key_value (key,value) VALUES ($1,$2) " +
+	"ON CONFLICT (key) DO UPDATE SET value = (key_value.value::bigint + $3)::text RETURNING value::bigint"
+
+func TestIncrement(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db).(Incrementer)
+
+	mock.ExpectQuery(incrementSQL).
+		WithArgs("counter", "-3", -3).WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(-3))
+	require.NoError(t, kvQ.Increment("counter", -3))
+	assert.True(t, Is(kvQ.Increment(" ", 1), ErrInvalidKey))
+}
+
+func TestAtomicCounter(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db).(AtomicCounter)
+
+	mock.ExpectQuery(incrementSQL).
+		WithArgs("counter", "5", 5).WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(5))
+	value, err := kvQ.IncrementAndGet("counter", 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), value)
+
+	mock.ExpectQuery(incrementSQL).
+		WithArgs("counter", "-2", -2).WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(3))
+	value, err = kvQ.DecrementAndGet("counter", 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), value)
+
+	mock.ExpectQuery(incrementSQL).
+		WithArgs("flag", "1", 1).WillReturnError(&pq.Error{Code: sqlStateInvalidTextRepresentation})
+	_, err = kvQ.IncrementAndGet("flag", 1)
+	assert.True(t, Is(err, ErrValueNotNumeric))
+}
+
+func TestBufferedCounterKV(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	newBuffered := func(maxDelta int64, opts ...BufferedCounterOption) (*bufferedCounterKV, *counterKV) {
+		inner := &counterKV{values: map[string]int64{"counter": 10}}
+		buffered, err := NewBufferedCounterKV(inner, time.Hour, maxDelta, opts...)
+		require.NoError(t, err)
+		q := buffered.(*bufferedCounterKV)
+		q.buffer.now = func() time.Time { return now }
+		q.buffer.lastFlush = now
+		t.Cleanup(func() { _ = q.Close(context.Background()) })
+		return q, inner
+	}
+
+	t.Run("coalescing", func(t *testing.T) {
+		q, inner := newBuffered(0)
+		for i := 0; i < 100; i++ {
+			require.NoError(t, q.Increment("counter", 1))
+		}
+		assert.Empty(t, inner.increments)
+
+		now = now.Add(time.Hour)
+		require.NoError(t, q.Increment("counter", 1))
+		assert.Equal(t, []int64{101}, inner.increments, "a delta is flushed with a single increment")
+		assert.Equal(t, int64(111), inner.values["counter"])
+	})
+
+	t.Run("flush on threshold", func(t *testing.T) {
+		q, inner := newBuffered(5)
+		for i := 0; i < 4; i++ {
+			require.NoError(t, q.Increment("counter", 1))
+		}
+		require.NoError(t, q.Increment("other", -2))
+		assert.Empty(t, inner.increments)
+
+		require.NoError(t, q.Increment("counter", 1))
+		assert.Equal(t, []int64{5}, inner.increments, "only the key reaching the threshold is flushed")
+
+		require.NoError(t, q.Flush(context.Background()))
+		assert.Equal(t, []int64{5, -2}, inner.increments)
+	})
+
+	t.Run("read consistency", func(t *testing.T) {
+		q, inner := newBuffered(0)
+		require.NoError(t, q.Increment("counter", 5))
+		require.NoError(t, q.Increment("missing", 2))
+		assert.Equal(t, "15", q.MustGet("counter").Value)
+		assert.Equal(t, "2", q.MustGet("missing").Value)
+
+		inner.fail = errors.New("unavailable")
+		assert.Error(t, q.Flush(context.Background()))
+		assert.Equal(t, "15", q.MustGet("counter").Value, "deltas that failed to be flushed are kept")
+
+		inner.fail = nil
+		require.NoError(t, q.Flush(context.Background()))
+		assert.Equal(t, "15", q.MustGet("counter").Value)
+		assert.Equal(t, int64(15), inner.values["counter"])
+
+		require.NoError(t, q.Increment("counter", 3))
+		require.NoError(t, q.Upsert(KeyValue{Key: "counter", Value: "1"}))
+		assert.Equal(t, "1", q.MustGet("counter").Value, "upsert overwrites the delta")
+	})
+
+	t.Run("failed flush", func(t *testing.T) {
+		var failures []error
+		q, inner := newBuffered(5, WithFlushFailureHook(func(err error) {
+			failures = append(failures, err)
+		}))
+		inner.fail = errors.New("unavailable")
+		require.NoError(t, q.Increment("counter", 5), "the delta is buffered despite the failed flush")
+		require.Len(t, failures, 1)
+		assert.True(t, Is(failures[0], inner.fail))
+
+		inner.fail = nil
+		require.NoError(t, q.Flush(context.Background()))
+		assert.Equal(t, int64(15), inner.values["counter"], "the delta is counted once")
+	})
+
+	t.Run("not an incrementer", func(t *testing.T) {
+		_, err := NewBufferedCounterKV(&flakyKeyValueQ{}, time.Hour, 0)
+		assert.Error(t, err)
+	})
+}
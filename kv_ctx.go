@@ -0,0 +1,125 @@
+package dban
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+// KeyValueQCtx is a key value querier whose queries could be aborted with a context
+type KeyValueQCtx interface {
+	KeyValueQ
+	// WithContext returns a copy of the querier running its queries with ctx. Queries
+	// aborted by ctx fail with an error matching context.Canceled or
+	// context.DeadlineExceeded (see Is)
+	WithContext(ctx context.Context) KeyValueQ
+	// GetCtx does the same thing as Get, but with ctx
+	GetCtx(ctx context.Context, key string) (*KeyValue, error)
+	// GetManyCtx does the same thing as GetMany, but with ctx
+	GetManyCtx(ctx context.Context, keys []string) (map[string]KeyValue, error)
+	// SelectByPrefixCtx does the same thing as SelectByPrefix, but with ctx
+	SelectByPrefixCtx(ctx context.Context, prefix string, params pgdb.OffsetPageParams) ([]KeyValue, error)
+	// ListKeysCtx does the same thing as ListKeys, but with ctx
+	ListKeysCtx(ctx context.Context, prefix string, params pgdb.OffsetPageParams) ([]string, error)
+	// CountCtx does the same thing as Count, but with ctx
+	CountCtx(ctx context.Context) (uint64, error)
+	// LockingGetCtx does the same thing as LockingGet, but with ctx
+	LockingGetCtx(ctx context.Context, key string) (*KeyValue, error)
+	// UpsertCtx does the same thing as Upsert, but with ctx
+	UpsertCtx(ctx context.Context, kv KeyValue) error
+	// UpsertManyCtx does the same thing as UpsertMany, but with ctx
+	UpsertManyCtx(ctx context.Context, kvs []KeyValue) error
+	// DeleteCtx does the same thing as Delete, but with ctx
+	DeleteCtx(ctx context.Context, key string) error
+	// DeleteManyCtx does the same thing as DeleteMany, but with ctx
+	DeleteManyCtx(ctx context.Context, keys []string) (int64, error)
+}
+
+var _ KeyValueQCtx = (*keyValueQ)(nil)
+
+func (q *keyValueQ) WithContext(ctx context.Context) KeyValueQ {
+	return q.withContext(ctx)
+}
+
+func (q *keyValueQ) withContext(ctx context.Context) *keyValueQ {
+	clone := *q
+	clone.ctx = ctx
+	return &clone
+}
+
+func (q *keyValueQ) GetCtx(ctx context.Context, key string) (*KeyValue, error) {
+	return q.withContext(ctx).Get(key)
+}
+
+func (q *keyValueQ) GetManyCtx(ctx context.Context, keys []string) (map[string]KeyValue, error) {
+	return q.withContext(ctx).GetMany(keys)
+}
+
+func (q *keyValueQ) SelectByPrefixCtx(ctx context.Context, prefix string, params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.withContext(ctx).SelectByPrefix(prefix, params)
+}
+
+func (q *keyValueQ) ListKeysCtx(ctx context.Context, prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	return q.withContext(ctx).ListKeys(prefix, params)
+}
+
+func (q *keyValueQ) CountCtx(ctx context.Context) (uint64, error) {
+	return q.withContext(ctx).Count()
+}
+
+func (q *keyValueQ) LockingGetCtx(ctx context.Context, key string) (*KeyValue, error) {
+	return q.withContext(ctx).LockingGet(key)
+}
+
+func (q *keyValueQ) UpsertCtx(ctx context.Context, kv KeyValue) error {
+	return q.withContext(ctx).Upsert(kv)
+}
+
+func (q *keyValueQ) UpsertManyCtx(ctx context.Context, kvs []KeyValue) error {
+	return q.withContext(ctx).UpsertMany(kvs)
+}
+
+func (q *keyValueQ) DeleteCtx(ctx context.Context, key string) error {
+	return q.withContext(ctx).Delete(key)
+}
+
+func (q *keyValueQ) DeleteManyCtx(ctx context.Context, keys []string) (int64, error) {
+	return q.withContext(ctx).DeleteMany(keys)
+}
+
+// classify classifies err the way classifyPostgres does, unless the context of the querier
+// is done, in which case the query is most likely aborted by it. The driver reports such
+// queries as canceled statements, so the context error is made to match instead
+func (q *keyValueQ) classify(err error) error {
+	if ctxErr := q.ctx.Err(); ctxErr != nil {
+		return &kindError{kind: ctxErr, err: err}
+	}
+	return classifyPostgres(err)
+}
+
+// detachedContext keeps the values of its parent, but is never done, so that the progress of
+// a streamer could be persisted once its context is canceled
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// withContext binds q to ctx if it supports contexts
+func withContext(q KeyValueQ, ctx context.Context) KeyValueQ {
+	if ctxQ, ok := q.(KeyValueQCtx); ok && ctx != nil {
+		return ctxQ.WithContext(ctx)
+	}
+	return q
+}
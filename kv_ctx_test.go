@@ -0,0 +1,46 @@
+package dban
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueQCtx(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db).(KeyValueQCtx)
+
+	mock.ExpectQuery(getSQL).WithArgs("foo").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("foo", "bar"))
+	kv, err := kvQ.GetCtx(context.Background(), "foo")
+	require.NoError(t, err)
+	assert.Equal(t, &KeyValue{Key: "foo", Value: "bar"}, kv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = kvQ.UpsertCtx(ctx, KeyValue{Key: "foo", Value: "baz"})
+	assert.True(t, Is(err, context.Canceled), "%v", err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	_, err = kvQ.WithContext(ctx).Get("foo")
+	assert.True(t, Is(err, context.DeadlineExceeded), "%v", err)
+}
+
+func TestStreamerCtx(t *testing.T) {
+	db, _ := newMockDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	streamer := NewStreamer[uint64](StreamerInitParams[uint64]{
+		Stream:      rangeStreamable{size: 3},
+		KeyValueQ:   NewKeyValueQ(withMockTx(db)),
+		KeyValueKey: "cursor",
+		Ctx:         &ctx,
+	})
+	_, err := streamer.FormList()
+	assert.True(t, Is(err, context.Canceled), "queries of a cancelled streamer must be aborted: %v", err)
+}
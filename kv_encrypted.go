@@ -0,0 +1,271 @@
+package dban
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// encryptedPrefix marks values encrypted by an encrypted querier, so that the format
+// could change later and plaintext values could be told apart
+const encryptedPrefix = "enc:v1:"
+
+// gcmNonceSize and gcmTagSize are the sizes of the nonce and the tag of AES-GCM stored along
+// with the ciphertext
+const (
+	gcmNonceSize = 12
+	gcmTagSize   = 16
+)
+
+// EncryptedValueLen returns the length a value n bytes long is stored with by an encrypted
+// querier (see NewEncryptedKeyValueQ), e.g. to check that secrets fit the value column
+func EncryptedValueLen(n int) int {
+	return len(encryptedPrefix) + base64.StdEncoding.EncodedLen(gcmNonceSize+n+gcmTagSize)
+}
+
+type encryptedKeyValueQ struct {
+	inner KeyValueQ
+	// aeads are the ciphers of the keys, the first of them encrypts
+	aeads   []cipher.AEAD
+	filters KeyValueFilters
+}
+
+// NewEncryptedKeyValueQ creates a querier encrypting values of inner at rest with AES-GCM.
+// Values are written encrypted with key, base64-encoded and prefixed with "enc:v1:", and
+// bound to their keys, so that a value could not be moved to another key unnoticed. Reads
+// try key and then oldKeys, so that keys could be rotated, and fail with ErrDecryptFailed
+// if none of them fits. Values without the prefix are read as they are, so that the
+// querier could be put over a table with plaintext values. Keys themselves are not
+// encrypted. The keys must be 16, 24 or 32 bytes long.
+// A value of n bytes is stored with about 4n/3+45 characters (see EncryptedValueLen), so a
+// varchar(64) value column fits values of up to 14 bytes only: the table must have the text column
+// the embedded migrations create instead, or the writes are rejected by the database
+func NewEncryptedKeyValueQ(inner KeyValueQ, key []byte, oldKeys ...[]byte) (KeyValueQ, error) {
+	aeads := make([]cipher.AEAD, 0, 1+len(oldKeys))
+	for i, key := range append([][]byte{key}, oldKeys...) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create cipher", logan.F{"key_index": i})
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create AEAD", logan.F{"key_index": i})
+		}
+		aeads = append(aeads, aead)
+	}
+
+	return &encryptedKeyValueQ{inner: inner, aeads: aeads}, nil
+}
+
+func (q *encryptedKeyValueQ) New() KeyValueQ {
+	return &encryptedKeyValueQ{inner: q.inner.New(), aeads: q.aeads}
+}
+
+// encrypt returns kv with the value encrypted with the first key
+func (q *encryptedKeyValueQ) encrypt(kv KeyValue) (KeyValue, error) {
+	aead := q.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(kv.Value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return KeyValue{}, errors.Wrap(err, "failed to generate nonce", logan.F{"key": kv.Key})
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(kv.Value), []byte(kv.Key))
+	kv.Value = encryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+	return kv, nil
+}
+
+// decrypt returns kv with the value decrypted, or as it is if it is not encrypted
+func (q *encryptedKeyValueQ) decrypt(kv KeyValue) (KeyValue, error) {
+	encoded := strings.TrimPrefix(kv.Value, encryptedPrefix)
+	if len(encoded) == len(kv.Value) {
+		return kv, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return KeyValue{}, errors.Wrap(&kindError{kind: ErrDecryptFailed, err: err}, "failed to decode value", logan.F{"key": kv.Key})
+	}
+	for _, aead := range q.aeads {
+		if len(sealed) < aead.NonceSize() {
+			break
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if value, err := aead.Open(nil, nonce, ciphertext, []byte(kv.Key)); err == nil {
+			kv.Value = string(value)
+			return kv, nil
+		}
+	}
+	return KeyValue{}, errors.Wrap(ErrDecryptFailed, "none of the keys decrypts value", logan.F{"key": kv.Key})
+}
+
+func (q *encryptedKeyValueQ) decryptPtr(kv *KeyValue, err error) (*KeyValue, error) {
+	if err != nil || kv == nil {
+		return kv, err
+	}
+	decrypted, err := q.decrypt(*kv)
+	if err != nil {
+		return nil, err
+	}
+	return &decrypted, nil
+}
+
+func (q *encryptedKeyValueQ) Get(key string) (*KeyValue, error) {
+	return q.decryptPtr(q.inner.Get(key))
+}
+
+func (q *encryptedKeyValueQ) GetMany(keys []string) (map[string]KeyValue, error) {
+	values, err := q.inner.GetMany(keys)
+	if err != nil {
+		return nil, err
+	}
+	for key, kv := range values {
+		if values[key], err = q.decrypt(kv); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (q *encryptedKeyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	values, err := q.inner.SelectByPrefix(prefix, params)
+	if err != nil {
+		return nil, err
+	}
+	for i, kv := range values {
+		if values[i], err = q.decrypt(kv); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (q *encryptedKeyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	return q.inner.ListKeys(prefix, params)
+}
+
+func (q *encryptedKeyValueQ) Count() (uint64, error) {
+	return q.inner.Count()
+}
+
+func (q *encryptedKeyValueQ) FilterByKeys(keys ...string) KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByKeys(keys...)
+	return &filtered
+}
+
+func (q *encryptedKeyValueQ) FilterByPrefix(prefix string) KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByKeyPrefix(prefix)
+	return &filtered
+}
+
+func (q *encryptedKeyValueQ) FilterByValueLike(pattern string) KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByValueLike(pattern)
+	return &filtered
+}
+
+// Select filters the decrypted values in memory, as the stored ones cannot be matched
+func (q *encryptedKeyValueQ) Select() ([]KeyValue, error) {
+	return SelectFiltered(q, q.filters, nil)
+}
+
+func (q *encryptedKeyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return SelectFiltered(q, q.filters, &params)
+}
+
+func (q *encryptedKeyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.SelectPage(KeyValueStreamParams(params))
+}
+
+func (q *encryptedKeyValueQ) MustGet(key string) *KeyValue {
+	value, err := GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+func (q *encryptedKeyValueQ) Upsert(kv KeyValue) error {
+	encrypted, err := q.encrypt(kv)
+	if err != nil {
+		return err
+	}
+	return q.inner.Upsert(encrypted)
+}
+
+func (q *encryptedKeyValueQ) UpsertMany(kvs []KeyValue) error {
+	encrypted := make([]KeyValue, len(kvs))
+	for i, kv := range kvs {
+		var err error
+		if encrypted[i], err = q.encrypt(kv); err != nil {
+			return err
+		}
+	}
+	return q.inner.UpsertMany(encrypted)
+}
+
+func (q *encryptedKeyValueQ) LockingGet(key string) (*KeyValue, error) {
+	return q.decryptPtr(q.inner.LockingGet(key))
+}
+
+func (q *encryptedKeyValueQ) MustLockingGet(key string) *KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
+
+func (q *encryptedKeyValueQ) Delete(key string) error {
+	return q.inner.Delete(key)
+}
+
+func (q *encryptedKeyValueQ) DeleteMany(keys []string) (int64, error) {
+	return q.inner.DeleteMany(keys)
+}
+
+// Update passes the decrypted value to fn and encrypts the one it returns
+func (q *encryptedKeyValueQ) Update(key string, fn func(current *string) (string, error)) (KeyValue, error) {
+	var updated KeyValue
+	_, err := q.inner.Update(key, func(current *string) (string, error) {
+		var value *string
+		if current != nil {
+			decrypted, err := q.decrypt(KeyValue{Key: key, Value: *current})
+			if err != nil {
+				return "", err
+			}
+			updated, value = decrypted, &decrypted.Value
+		}
+
+		next, err := fn(value)
+		if err != nil {
+			return "", err
+		}
+		updated = KeyValue{Key: key, Value: next}
+		encrypted, err := q.encrypt(updated)
+		return encrypted.Value, err
+	})
+	if err != nil {
+		return KeyValue{}, err
+	}
+	return updated, nil
+}
+
+func (q *encryptedKeyValueQ) Transaction(fn func(q KeyValueQ) error) error {
+	return transaction(q.inner, func(inner KeyValueQ) error {
+		tx := *q
+		tx.inner = inner
+		return fn(&tx)
+	})
+}
+
+func (q *encryptedKeyValueQ) WithinTx() bool {
+	return withinTx(q.inner)
+}
@@ -0,0 +1,22 @@
+package dban
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptedKeyValueQPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db)
+	kvQ, err := NewEncryptedKeyValueQ(NewKeyValueQ(db), bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+
+	token := strings.Repeat("t", 256)
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "api_token", Value: token}))
+	assert.Equal(t, token, kvQ.MustGet("api_token").Value)
+	assert.Len(t, NewKeyValueQ(db).MustGet("api_token").Value, EncryptedValueLen(len(token)))
+}
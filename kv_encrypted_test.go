@@ -0,0 +1,81 @@
+package dban_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+func TestEncryptedKeyValueQ(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	inner := dbantest.NewMemoryKeyValueQ()
+	kvQ, err := dban.NewEncryptedKeyValueQ(inner, key)
+	require.NoError(t, err)
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "token", Value: "hunter2"}))
+	stored := inner.MustGet("token").Value
+	assert.True(t, strings.HasPrefix(stored, "enc:v1:"), "unexpected stored value %q", stored)
+	assert.NotContains(t, stored, "hunter2")
+	assert.Equal(t, &dban.KeyValue{Key: "token", Value: "hunter2"}, kvQ.New().MustGet("token"))
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "token", Value: "hunter2"}))
+	assert.NotEqual(t, stored, inner.MustGet("token").Value, "nonces must be random")
+
+	require.NoError(t, inner.Upsert(dban.KeyValue{Key: "cursor", Value: "42"}))
+	assert.Equal(t, "42", kvQ.MustGet("cursor").Value, "plaintext values are read as they are")
+
+	values, err := kvQ.SelectByPrefix("", pgdb.OffsetPageParams{Order: pgdb.OrderTypeAsc})
+	require.NoError(t, err)
+	assert.Equal(t, []dban.KeyValue{{Key: "cursor", Value: "42"}, {Key: "token", Value: "hunter2"}}, values)
+
+	// a value moved to another key does not decrypt
+	require.NoError(t, inner.Upsert(dban.KeyValue{Key: "other", Value: inner.MustGet("token").Value}))
+	_, err = kvQ.Get("other")
+	assert.True(t, dban.Is(err, dban.ErrDecryptFailed), "unexpected error: %v", err)
+
+	require.NoError(t, inner.Upsert(dban.KeyValue{Key: "garbage", Value: "enc:v1:!!!"}))
+	_, err = kvQ.GetMany([]string{"garbage"})
+	assert.True(t, dban.Is(err, dban.ErrDecryptFailed), "unexpected error: %v", err)
+
+	_, err = dban.NewEncryptedKeyValueQ(inner, []byte("short"))
+	assert.Error(t, err)
+}
+
+func TestEncryptedKeyValueQRotation(t *testing.T) {
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	inner := dbantest.NewMemoryKeyValueQ()
+
+	oldQ, err := dban.NewEncryptedKeyValueQ(inner, oldKey)
+	require.NoError(t, err)
+	require.NoError(t, oldQ.Upsert(dban.KeyValue{Key: "token", Value: "old"}))
+
+	rotatedQ, err := dban.NewEncryptedKeyValueQ(inner, newKey, oldKey)
+	require.NoError(t, err)
+	assert.Equal(t, "old", rotatedQ.MustGet("token").Value)
+	require.NoError(t, rotatedQ.Upsert(dban.KeyValue{Key: "token", Value: "new"}))
+
+	_, err = oldQ.Get("token")
+	assert.True(t, dban.Is(err, dban.ErrDecryptFailed), "values are written with the first key")
+	newQ, err := dban.NewEncryptedKeyValueQ(inner, newKey)
+	require.NoError(t, err)
+	assert.Equal(t, "new", newQ.MustGet("token").Value)
+}
+
+func TestEncryptedValueLen(t *testing.T) {
+	inner := dbantest.NewMemoryKeyValueQ()
+	kvQ, err := dban.NewEncryptedKeyValueQ(inner, bytes.Repeat([]byte{1}, 16))
+	require.NoError(t, err)
+
+	for _, n := range []int{0, 1, 14, 15, 40, 1000} {
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "token", Value: strings.Repeat("x", n)}))
+		assert.Len(t, inner.MustGet("token").Value, dban.EncryptedValueLen(n), "value of %d bytes", n)
+	}
+	assert.LessOrEqual(t, dban.EncryptedValueLen(14), 64)
+	assert.Greater(t, dban.EncryptedValueLen(15), 64, "longer values must not fit a varchar(64) column")
+}
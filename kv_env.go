@@ -0,0 +1,239 @@
+package dban
+
+import (
+	"os"
+	"strings"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// EnvOverlayOption is an optional parameter of an environment overlay querier
+type EnvOverlayOption func(*envOverlayKeyValueQ)
+
+// WithEnvOverlayLog sets a log the environment overlay querier warns to, logan.New() by default
+func WithEnvOverlayLog(log *logan.Entry) EnvOverlayOption {
+	return func(q *envOverlayKeyValueQ) {
+		q.log = log
+	}
+}
+
+type envOverlayKeyValueQ struct {
+	inner  KeyValueQ
+	prefix string
+	log    *logan.Entry
+}
+
+// NewEnvOverlayKeyValueQ creates a querier that lets operators override values of inner
+// with environment variables. Reads return the value of the variable prefix+EnvKey(key)
+// when it is set and fall back to inner otherwise. Writes always go to inner, while
+// writing a key overridden by a variable is logged as a warning
+func NewEnvOverlayKeyValueQ(inner KeyValueQ, prefix string, opts ...EnvOverlayOption) KeyValueQ {
+	q := &envOverlayKeyValueQ{
+		inner:  inner,
+		prefix: prefix,
+		log:    logan.New(),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	return q
+}
+
+// EnvKey converts a key into a part of an environment variable name: ASCII letters are
+// uppercased, digits and underscores are kept and any other character (such as dots,
+// colons or dashes) becomes an underscore. E.g. "foo.bar:baz" becomes "FOO_BAR_BAZ"
+func EnvKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+}
+
+func (q *envOverlayKeyValueQ) New() KeyValueQ {
+	return &envOverlayKeyValueQ{
+		inner:  q.inner.New(),
+		prefix: q.prefix,
+		log:    q.log,
+	}
+}
+
+func (q *envOverlayKeyValueQ) Get(key string) (*KeyValue, error) {
+	if kv := q.override(key); kv != nil {
+		return kv, nil
+	}
+	return q.inner.Get(key)
+}
+
+func (q *envOverlayKeyValueQ) GetMany(keys []string) (map[string]KeyValue, error) {
+	values, err := q.inner.GetMany(keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if kv := q.override(key); kv != nil {
+			values[key] = *kv
+		}
+	}
+	return values, nil
+}
+
+// SelectByPrefix overrides the values of the stored keys only, as there is no way to tell
+// a key from the name of a variable
+func (q *envOverlayKeyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	values, err := q.inner.SelectByPrefix(prefix, params)
+	return q.overrideAll(values), err
+}
+
+// ListKeys lists the stored keys only, as there is no way to tell a key from the name of
+// a variable
+func (q *envOverlayKeyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	return q.inner.ListKeys(prefix, params)
+}
+
+// Count counts the stored values only, the same way ListKeys lists them
+func (q *envOverlayKeyValueQ) Count() (uint64, error) {
+	return q.inner.Count()
+}
+
+func (q *envOverlayKeyValueQ) FilterByKeys(keys ...string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByKeys(keys...)
+	return &filtered
+}
+
+func (q *envOverlayKeyValueQ) FilterByPrefix(prefix string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByPrefix(prefix)
+	return &filtered
+}
+
+func (q *envOverlayKeyValueQ) FilterByValueLike(pattern string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByValueLike(pattern)
+	return &filtered
+}
+
+// Select filters the stored values, which are then overridden the way SelectByPrefix does
+func (q *envOverlayKeyValueQ) Select() ([]KeyValue, error) {
+	values, err := q.inner.Select()
+	return q.overrideAll(values), err
+}
+
+func (q *envOverlayKeyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	values, err := q.inner.SelectPage(params)
+	return q.overrideAll(values), err
+}
+
+func (q *envOverlayKeyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.SelectPage(KeyValueStreamParams(params))
+}
+
+// overrideAll replaces the values overridden by variables in place
+func (q *envOverlayKeyValueQ) overrideAll(values []KeyValue) []KeyValue {
+	for i, kv := range values {
+		if override := q.override(kv.Key); override != nil {
+			values[i] = *override
+		}
+	}
+	return values
+}
+
+func (q *envOverlayKeyValueQ) MustGet(key string) *KeyValue {
+	value, err := GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+func (q *envOverlayKeyValueQ) Upsert(kv KeyValue) error {
+	if override := q.override(kv.Key); override != nil {
+		q.log.WithFields(logan.F{
+			"key":      kv.Key,
+			"variable": q.variable(kv.Key),
+		}).Warn("Key is overridden by an environment variable, reads will keep returning its value")
+	}
+	return q.inner.Upsert(kv)
+}
+
+func (q *envOverlayKeyValueQ) UpsertMany(kvs []KeyValue) error {
+	for _, kv := range kvs {
+		if override := q.override(kv.Key); override != nil {
+			q.log.WithFields(logan.F{
+				"key":      kv.Key,
+				"variable": q.variable(kv.Key),
+			}).Warn("Key is overridden by an environment variable, reads will keep returning its value")
+		}
+	}
+	return q.inner.UpsertMany(kvs)
+}
+
+// Delete deletes the stored value only, so an overridden key keeps being read from the
+// environment
+func (q *envOverlayKeyValueQ) Delete(key string) error {
+	return q.inner.Delete(key)
+}
+
+func (q *envOverlayKeyValueQ) DeleteMany(keys []string) (int64, error) {
+	return q.inner.DeleteMany(keys)
+}
+
+// Update passes the stored value to fn rather than the one of the variable overriding it
+func (q *envOverlayKeyValueQ) Update(key string, fn func(current *string) (string, error)) (KeyValue, error) {
+	if override := q.override(key); override != nil {
+		q.log.WithFields(logan.F{
+			"key":      key,
+			"variable": q.variable(key),
+		}).Warn("Key is overridden by an environment variable, reads will keep returning its value")
+	}
+	return q.inner.Update(key, fn)
+}
+
+func (q *envOverlayKeyValueQ) LockingGet(key string) (*KeyValue, error) {
+	if kv := q.override(key); kv != nil {
+		return kv, nil
+	}
+	return q.inner.LockingGet(key)
+}
+
+func (q *envOverlayKeyValueQ) MustLockingGet(key string) *KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
+
+func (q *envOverlayKeyValueQ) variable(key string) string {
+	return q.prefix + EnvKey(key)
+}
+
+// override returns the value of the variable overriding the key or nil if it is not set
+func (q *envOverlayKeyValueQ) override(key string) *KeyValue {
+	value, ok := os.LookupEnv(q.variable(key))
+	if !ok {
+		return nil
+	}
+	return &KeyValue{Key: key, Value: value}
+}
+
+func (q *envOverlayKeyValueQ) Transaction(fn func(q KeyValueQ) error) error {
+	return transaction(q.inner, func(inner KeyValueQ) error {
+		tx := *q
+		tx.inner = inner
+		return fn(&tx)
+	})
+}
+
+func (q *envOverlayKeyValueQ) WithinTx() bool {
+	return withinTx(q.inner)
+}
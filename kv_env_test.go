@@ -0,0 +1,45 @@
+package dban_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/logan/v3"
+)
+
+func TestEnvKey(t *testing.T) {
+	assert.Equal(t, "FOO_BAR_BAZ", dban.EnvKey("foo.bar:baz"))
+	assert.Equal(t, "PROCESSOR_2_CURSOR", dban.EnvKey("processor-2_cursor"))
+	assert.Equal(t, "KEY_____", dban.EnvKey("key ключ"), "every non-ASCII rune becomes a single underscore")
+}
+
+func TestEnvOverlayKeyValueQ(t *testing.T) {
+	var logs bytes.Buffer
+	inner := dbantest.NewMemoryKeyValueQ()
+	kvQ := dban.NewEnvOverlayKeyValueQ(inner, "DBAN_KV_", dban.WithEnvOverlayLog(logan.New().Out(&logs)))
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "feature.enabled", Value: "false"}))
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "cursor", Value: "1"}))
+	assert.Empty(t, logs.String())
+
+	t.Setenv("DBAN_KV_FEATURE_ENABLED", "true")
+	assert.Equal(t, &dban.KeyValue{Key: "feature.enabled", Value: "true"}, kvQ.MustGet("feature.enabled"))
+	assert.Equal(t, &dban.KeyValue{Key: "feature.enabled", Value: "true"}, kvQ.New().MustLockingGet("feature.enabled"))
+	assert.Equal(t, &dban.KeyValue{Key: "cursor", Value: "1"}, kvQ.MustGet("cursor"), "not overridden keys fall back to inner")
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "feature.enabled", Value: "maybe"}))
+	assert.Contains(t, logs.String(), "DBAN_KV_FEATURE_ENABLED")
+	assert.Equal(t, "maybe", inner.MustGet("feature.enabled").Value, "writes go to inner")
+	assert.Equal(t, "true", kvQ.MustGet("feature.enabled").Value)
+
+	values, err := kvQ.GetMany([]string{"feature.enabled", "cursor", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]dban.KeyValue{
+		"feature.enabled": {Key: "feature.enabled", Value: "true"},
+		"cursor":          {Key: "cursor", Value: "1"},
+	}, values)
+}
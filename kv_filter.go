@@ -0,0 +1,219 @@
+package dban
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Masterminds/squirrel"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// filteredPageSize is the number of values read at once by SelectFiltered
+const filteredPageSize = 1000
+
+// KeyValueFilters are the filters a key value querier accumulates (see FilterByKeys). The
+// methods adding filters return a copy, so the filters of a querier are never changed by
+// its clones. Backends and decorators without a query to apply them to match values in
+// memory with Match or SelectFiltered
+type KeyValueFilters struct {
+	keys     [][]string
+	prefixes []string
+	likes    []*regexp.Regexp
+	// patterns are the LIKE patterns the likes are compiled from
+	patterns []string
+}
+
+// ByKeys adds the filter selecting values by one of the keys
+func (f KeyValueFilters) ByKeys(keys ...string) KeyValueFilters {
+	f.keys = append(f.keys[:len(f.keys):len(f.keys)], append([]string{}, keys...))
+	return f
+}
+
+// ByKeyPrefix adds the filter selecting values whose keys start with prefix, matched
+// literally
+func (f KeyValueFilters) ByKeyPrefix(prefix string) KeyValueFilters {
+	f.prefixes = append(f.prefixes[:len(f.prefixes):len(f.prefixes)], prefix)
+	return f
+}
+
+// ByValueLike adds the filter selecting values matching the LIKE pattern, where % matches
+// any string, _ matches any character and a backslash escapes the character following it
+func (f KeyValueFilters) ByValueLike(pattern string) KeyValueFilters {
+	f.patterns = append(f.patterns[:len(f.patterns):len(f.patterns)], pattern)
+	f.likes = append(f.likes[:len(f.likes):len(f.likes)], likeRegexp(pattern))
+	return f
+}
+
+// likeRegexp compiles a LIKE pattern into a regular expression matching the same strings
+func likeRegexp(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString(`^(?s:`)
+	for escaped, i := false, 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case escaped:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '%':
+			expr.WriteString(`.*`)
+		case c == '_':
+			expr.WriteString(`.`)
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString(`)$`)
+	return regexp.MustCompile(expr.String())
+}
+
+// Match reports whether the value passes all the filters
+func (f KeyValueFilters) Match(kv KeyValue) bool {
+	for _, keys := range f.keys {
+		found := false
+		for _, key := range keys {
+			if found = key == kv.Key; found {
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, prefix := range f.prefixes {
+		if !strings.HasPrefix(kv.Key, prefix) {
+			return false
+		}
+	}
+	for _, like := range f.likes {
+		if !like.MatchString(kv.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// ApplyTo adds the filters to the query as WHERE clauses. likeExpr is the clause matching
+// the value column against a LIKE pattern given as its argument, e.g. "value LIKE ?", so
+// that every storage could use its own escaping. Key prefixes are compared with substr, which
+// Postgres, MySQL and SQLite share, so that they need no escaping
+func (f KeyValueFilters) ApplyTo(query squirrel.SelectBuilder, keyColumn, likeExpr string) squirrel.SelectBuilder {
+	for _, keys := range f.keys {
+		query = query.Where(squirrel.Eq{keyColumn: keys})
+	}
+	for _, prefix := range f.prefixes {
+		query = query.Where("substr("+keyColumn+", 1, ?) = ?", utf8.RuneCountInString(prefix), prefix)
+	}
+	for _, pattern := range f.patterns {
+		query = query.Where(likeExpr, pattern)
+	}
+	return query
+}
+
+// SelectFiltered selects the values of q passing the filters in memory. It returns the
+// page of them if params are set, and all of them ordered by the key otherwise. Values
+// are read by the keys if a key filter is set, and page by page with SelectByPrefix, by the
+// first key prefix filter if any, if not
+func SelectFiltered(q KeyValueQ, f KeyValueFilters, params *pgdb.OffsetPageParams) ([]KeyValue, error) {
+	var values []KeyValue
+	if len(f.keys) > 0 {
+		byKey, err := q.GetMany(f.keys[0])
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get values by keys")
+		}
+		for _, kv := range byKey {
+			if f.Match(kv) {
+				values = append(values, kv)
+			}
+		}
+	} else {
+		var prefix string
+		if len(f.prefixes) > 0 {
+			prefix = f.prefixes[0]
+		}
+		page := pgdb.OffsetPageParams{Limit: filteredPageSize, Order: pgdb.OrderTypeAsc}
+		for ; ; page.PageNumber++ {
+			kvs, err := q.SelectByPrefix(prefix, page)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to select values", logan.F{"page": page.PageNumber})
+			}
+			for _, kv := range kvs {
+				if f.Match(kv) {
+					values = append(values, kv)
+				}
+			}
+			if uint64(len(kvs)) < page.Limit {
+				break
+			}
+		}
+	}
+
+	if params != nil {
+		return PageKeyValues(values, *params), nil
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Key < values[j].Key })
+	if values == nil {
+		values = []KeyValue{}
+	}
+	return values, nil
+}
+
+func (q *keyValueQ) FilterByKeys(keys ...string) KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByKeys(keys...)
+	return &filtered
+}
+
+func (q *keyValueQ) FilterByPrefix(prefix string) KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByKeyPrefix(prefix)
+	return &filtered
+}
+
+func (q *keyValueQ) FilterByValueLike(pattern string) KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByValueLike(pattern)
+	return &filtered
+}
+
+func (q *keyValueQ) Select() ([]KeyValue, error) {
+	return q.selectFiltered(func(query squirrel.SelectBuilder) squirrel.SelectBuilder {
+		return query.OrderBy(q.queries.key)
+	})
+}
+
+func (q *keyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.selectFiltered(func(query squirrel.SelectBuilder) squirrel.SelectBuilder {
+		return params.ApplyTo(query, q.queries.key)
+	})
+}
+
+func (q *keyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.SelectPage(KeyValueStreamParams(params))
+}
+
+// KeyValueStreamParams returns params ordered ascending unless the order is set, the way
+// key value queriers page the values streamed with SelectWithPageParams
+func KeyValueStreamParams(params pgdb.OffsetPageParams) pgdb.OffsetPageParams {
+	if params.Order == "" {
+		params.Order = pgdb.OrderTypeAsc
+	}
+	return params
+}
+
+// selectFiltered selects the values passing the filters with the query order applies to
+func (q *keyValueQ) selectFiltered(order func(squirrel.SelectBuilder) squirrel.SelectBuilder) ([]KeyValue, error) {
+	query := q.filters.ApplyTo(q.live(q.queries.selectKV), q.queries.key, q.queries.value+` LIKE ? ESCAPE '\'`)
+
+	values := []KeyValue{}
+	err := q.db.SelectContext(q.ctx, &values, order(query))
+	countKV(kvVarGet, err)
+	if err != nil {
+		return nil, errors.Wrap(q.classify(err), "failed to select filtered values")
+	}
+	return values, nil
+}
@@ -0,0 +1,91 @@
+package dban
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+func TestKeyValueQFilters(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db, WithTTL())
+
+	filtered := kvQ.FilterByKeys("a", "b")
+	mock.ExpectQuery(`SELECT key, value FROM key_value WHERE (expires_at IS NULL OR expires_at > now()) AND key IN ($1,$2) AND value LIKE $3 ESCAPE '\' ORDER BY key`).
+		WithArgs("a", "b", "1%").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("a", "10"))
+	values, err := filtered.FilterByValueLike("1%").Select()
+	require.NoError(t, err)
+	assert.Equal(t, []KeyValue{{Key: "a", Value: "10"}}, values)
+
+	mock.ExpectQuery(`SELECT key, value FROM key_value WHERE (expires_at IS NULL OR expires_at > now()) AND key IN ($1,$2) ORDER BY key asc LIMIT 10 OFFSET 10`).
+		WithArgs("a", "b").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}))
+	values, err = filtered.SelectPage(pgdb.OffsetPageParams{Limit: 10, PageNumber: 1, Order: pgdb.OrderTypeAsc})
+	require.NoError(t, err)
+	assert.Empty(t, values, "filters of a querier are not changed by its copies")
+}
+
+func TestKeyValueQSelectWithPageParams(t *testing.T) {
+	db, mock := newMockDB(t)
+	var stream Streamable[KeyValue] = NewKeyValueQ(db).FilterByPrefix("jobs:")
+
+	mock.ExpectQuery(`SELECT key, value FROM key_value WHERE substr(key, 1, $1) = $2 ORDER BY key asc LIMIT 2 OFFSET 2`).
+		WithArgs(5, "jobs:").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("jobs:3", "c"))
+	values, err := stream.SelectWithPageParams(pgdb.OffsetPageParams{Limit: 2, PageNumber: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []KeyValue{{Key: "jobs:3", Value: "c"}}, values, "values must be ordered by the key ascending by default")
+
+	mock.ExpectQuery(`SELECT key, value FROM key_value WHERE substr(key, 1, $1) = $2 ORDER BY key desc LIMIT 2 OFFSET 0`).
+		WithArgs(5, "jobs:").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}))
+	_, err = stream.SelectWithPageParams(pgdb.OffsetPageParams{Limit: 2, Order: pgdb.OrderTypeDesc})
+	require.NoError(t, err)
+}
+
+func TestKeyValueQNewClearsFilters(t *testing.T) {
+	raw, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer raw.Close()
+	mock.ExpectBegin()
+	tx, err := raw.Begin()
+	require.NoError(t, err)
+
+	mock.ExpectQuery("SELECT key, value FROM key_value ORDER BY key").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}))
+	_, err = NewKeyValueQFromTx(tx).FilterByKeys("a").New().Select()
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestKeyValueFiltersMatch(t *testing.T) {
+	testCases := map[string]struct {
+		filters KeyValueFilters
+		kv      KeyValue
+		match   bool
+	}{
+		"no filters":           {KeyValueFilters{}, KeyValue{Key: "a", Value: "1"}, true},
+		"key":                  {KeyValueFilters{}.ByKeys("b", "a"), KeyValue{Key: "a"}, true},
+		"other key":            {KeyValueFilters{}.ByKeys("b"), KeyValue{Key: "a"}, false},
+		"keys of all filters":  {KeyValueFilters{}.ByKeys("a", "b").ByKeys("b"), KeyValue{Key: "a"}, false},
+		"any string":           {KeyValueFilters{}.ByValueLike("a%c"), KeyValue{Value: "a\nbc"}, true},
+		"any character":        {KeyValueFilters{}.ByValueLike("a_c"), KeyValue{Value: "abc"}, true},
+		"whole value":          {KeyValueFilters{}.ByValueLike("a_"), KeyValue{Value: "abc"}, false},
+		"escaped wildcard":     {KeyValueFilters{}.ByValueLike(`a\%`), KeyValue{Value: "ab"}, false},
+		"escaped literal":      {KeyValueFilters{}.ByValueLike(`a\%`), KeyValue{Value: "a%"}, true},
+		"regexp metacharacter": {KeyValueFilters{}.ByValueLike("a.c"), KeyValue{Value: "abc"}, false},
+		"key prefix":           {KeyValueFilters{}.ByKeyPrefix("jobs:"), KeyValue{Key: "jobs:1"}, true},
+		"other key prefix":     {KeyValueFilters{}.ByKeyPrefix("jobs:"), KeyValue{Key: "job:1"}, false},
+		"literal key prefix":   {KeyValueFilters{}.ByKeyPrefix("a_"), KeyValue{Key: "ab"}, false},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.match, tc.filters.Match(tc.kv))
+		})
+	}
+}
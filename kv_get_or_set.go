@@ -0,0 +1,51 @@
+package dban
+
+import (
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// GetOrSetter is a key value querier able to initialize a value lazily without racing
+// with other instances doing the same
+type GetOrSetter interface {
+	// GetOrSet gets the value by the key, writing defaultValue first if there is none, and
+	// reports whether it was written
+	GetOrSet(key, defaultValue string) (KeyValue, bool, error)
+}
+
+var _ GetOrSetter = (*keyValueQ)(nil)
+
+// GetOrSet inserts the default value and reads the existing one if the insert conflicted,
+// all in one transaction
+func (q *keyValueQ) GetOrSet(key, defaultValue string) (KeyValue, bool, error) {
+	var (
+		value     KeyValue
+		installed bool
+	)
+	err := q.Transaction(func(tx KeyValueQ) error {
+		txQ := tx.(*keyValueQ)
+		kv := KeyValue{Key: key, Value: defaultValue}
+
+		var err error
+		if installed, err = txQ.InsertIfAbsent(kv); err != nil || installed {
+			value = kv
+			return err
+		}
+
+		existing, err := txQ.get(key, rowLockNone)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			// the conflicting value was deleted or expired since the insert
+			return errors.From(ErrNoSuchKey, logan.F{"key": key})
+		}
+		value = *existing
+		return nil
+	})
+	if err != nil {
+		return KeyValue{}, false, errors.Wrap(err, "failed to get or set value", logan.F{"key": key})
+	}
+
+	return value, installed, nil
+}
@@ -0,0 +1,33 @@
+package dban
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOrSet(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(withMockTx(db)).(GetOrSetter)
+
+	const insertSQL = "INSERT INTO key_value (key,value) VALUES ($1,$2) ON CONFLICT (key) DO NOTHING"
+	mock.ExpectExec(insertSQL).WithArgs("config", "default").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(insertSQL).WithArgs("config", "other").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(getSQL).WithArgs("config").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("config", "default"))
+
+	kv, installed, err := kvQ.GetOrSet("config", "default")
+	require.NoError(t, err)
+	assert.True(t, installed)
+	assert.Equal(t, KeyValue{Key: "config", Value: "default"}, kv)
+
+	kv, installed, err = kvQ.GetOrSet("config", "other")
+	require.NoError(t, err)
+	assert.False(t, installed, "the existing value must be kept")
+	assert.Equal(t, KeyValue{Key: "config", Value: "default"}, kv)
+
+	_, _, err = kvQ.GetOrSet(" ", "default")
+	assert.True(t, Is(err, ErrInvalidKey))
+}
@@ -0,0 +1,77 @@
+package dban
+
+import (
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+const (
+	keyValueHistoryTable = "key_value_history"
+	// historyActorSetting is a setting the history trigger reads the actor of a change from
+	historyActorSetting = "dban.actor"
+)
+
+// KeyValueChange is a change of a value recorded by FeatureHistory. OldValue is nil for
+// an inserted value and NewValue is nil for a deleted one
+type KeyValueChange struct {
+	ID        int64     `db:"id" json:"id"`
+	Key       string    `db:"key" json:"key"`
+	OldValue  *string   `db:"old_value" json:"old_value"`
+	NewValue  *string   `db:"new_value" json:"new_value"`
+	Actor     string    `db:"actor" json:"actor"`
+	ChangedAt time.Time `db:"changed_at" json:"changed_at"`
+}
+
+// HistoryReader is a key value querier able to list the changes of values
+type HistoryReader interface {
+	// GetHistory returns a page of the changes of the value by the key, ordered by the
+	// time they were made at (the latest first by default). Requires FeatureHistory
+	GetHistory(key string, params pgdb.OffsetPageParams) ([]KeyValueChange, error)
+}
+
+var _ HistoryReader = (*keyValueQ)(nil)
+
+// WithHistoryActor makes the querier label its writes with actor, so that the changes
+// FeatureHistory records could be told apart by the process that made them. Writes made
+// outside a transaction are wrapped into one to set the label, so they take two extra
+// round trips. Changes made without a label are recorded with the database user
+func WithHistoryActor(actor string) KeyValueQOption {
+	return func(q *keyValueQ) {
+		q.actor = actor
+	}
+}
+
+func (q *keyValueQ) GetHistory(key string, params pgdb.OffsetPageParams) ([]KeyValueChange, error) {
+	query := squirrel.Select("id", "key", "old_value", "new_value", "actor", "changed_at").
+		From(keyValueHistoryTable).
+		Where(squirrel.Eq{"key": key})
+
+	var changes []KeyValueChange
+	err := q.db.SelectContext(q.ctx, &changes, params.ApplyTo(query, "id"))
+	countKV(kvVarGet, err)
+	if err != nil {
+		return nil, errors.Wrap(q.classify(err), "failed to select history", logan.F{"key": key})
+	}
+	return changes, nil
+}
+
+// audited runs write labelled with the actor of the querier, if any. The label lasts until
+// the end of the transaction, so the write is run in one unless the querier is bound to a
+// transaction already
+func (q *keyValueQ) audited(write func(q *keyValueQ) error) error {
+	if q.actor == "" {
+		return write(q)
+	}
+
+	return q.Transaction(func(tx KeyValueQ) error {
+		txQ := tx.(*keyValueQ)
+		if err := txQ.db.ExecRawContext(txQ.ctx, setConfigQuery, historyActorSetting, q.actor); err != nil {
+			return errors.Wrap(err, "failed to set history actor", logan.F{"actor": q.actor})
+		}
+		return write(txQ)
+	})
+}
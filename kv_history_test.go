@@ -0,0 +1,74 @@
+package dban
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+func TestHistoryActor(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(withMockTx(db), WithHistoryActor("worker-1"))
+
+	mock.ExpectExec("SELECT set_config($1, $2, true)").WithArgs("dban.actor", "worker-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(upsertSQL).WithArgs("cursor", "1").WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "cursor", Value: "1"}))
+
+	mock.ExpectQuery("SELECT id, key, old_value, new_value, actor, changed_at FROM key_value_history " +
+		"WHERE key = $1 ORDER BY id desc LIMIT 15 OFFSET 0").WithArgs("cursor").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "key", "old_value", "new_value", "actor", "changed_at"}))
+	changes, err := kvQ.(HistoryReader).GetHistory("cursor", pgdb.OffsetPageParams{})
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestHistoryPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db, WithFeatures(FeatureHistory))
+	kvQ := NewKeyValueQ(db, WithHistoryActor("worker-1"))
+
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "cursor", Value: "1"}))
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "cursor", Value: "1"}))
+	require.NoError(t, NewKeyValueQ(db).Upsert(KeyValue{Key: "cursor", Value: "2"}))
+	require.NoError(t, kvQ.Delete("cursor"))
+
+	failure := assert.AnError
+	err := kvQ.(TransactionalKeyValueQ).Transaction(func(q KeyValueQ) error {
+		require.NoError(t, q.Upsert(KeyValue{Key: "cursor", Value: "3"}))
+		return failure
+	})
+	require.Equal(t, failure, errors.Cause(err))
+
+	changes, err := kvQ.(HistoryReader).GetHistory("cursor", pgdb.OffsetPageParams{Order: pgdb.OrderTypeAsc})
+	require.NoError(t, err)
+	require.Len(t, changes, 3, "unchanged values and rolled back changes must not be recorded")
+
+	value := func(v string) *string { return &v }
+	assert.Equal(t, []*string{nil, value("1"), value("2")}, []*string{changes[0].OldValue, changes[1].OldValue, changes[2].OldValue})
+	assert.Equal(t, []*string{value("1"), value("2"), nil}, []*string{changes[0].NewValue, changes[1].NewValue, changes[2].NewValue})
+	assert.Equal(t, "worker-1", changes[0].Actor)
+	assert.NotEqual(t, "worker-1", changes[1].Actor, "writes without an actor are recorded with the database user")
+	assert.Equal(t, "worker-1", changes[2].Actor)
+}
+
+func TestHistoryKeyCheckPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db, WithFeatures(FeatureHistory, FeatureKeyCheck))
+	kvQ := NewKeyValueQ(db)
+
+	// the longest key FeatureKeyCheck allows must be recorded as well
+	key, value := strings.Repeat("k", DefaultMaxKeyLength), strings.Repeat("v", 100)
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: key, Value: value}))
+
+	changes, err := kvQ.(HistoryReader).GetHistory(key, pgdb.OffsetPageParams{})
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, key, changes[0].Key)
+	assert.Equal(t, &value, changes[0].NewValue)
+}
@@ -0,0 +1,189 @@
+package dban
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+const (
+	defaultJanitorCursorKey        = "dban:janitor"
+	defaultJanitorBatchSize uint64 = 100
+)
+
+// JanitorOptions configure a KeyValueJanitor
+type JanitorOptions struct {
+	// Prefix limits the janitor to keys starting with it. An empty prefix makes it examine
+	// all the keys
+	Prefix string
+	// Retention is the time a key is kept for since it was last updated
+	Retention time.Duration
+	// CursorKey is the key the janitor keeps the cursor of its walk by, "dban:janitor" by
+	// default. Keys starting with it are never removed
+	CursorKey string
+	// BatchSize is the number of keys examined and removed at once, 100 by default
+	BatchSize uint64
+	// DryRun makes the janitor log the keys it would remove instead of removing them
+	DryRun bool
+	// HardDelete makes the janitor delete stale keys permanently even if the querier soft
+	// deletes them by default (see SoftDeleter)
+	HardDelete bool
+	// Log is the entry stale keys are logged with, if any
+	Log *logan.Entry
+	// Clock tells the time the retention is counted back from, the system one by default
+	Clock Clock
+}
+
+// JanitorReport tells what a run of a KeyValueJanitor did
+type JanitorReport struct {
+	// Examined is the number of keys examined
+	Examined int
+	// Stale is the number of keys not updated within the retention
+	Stale int
+	// Removed is the number of stale keys removed, which is 0 for a dry run
+	Removed int64
+	// SoftDeleted tells whether the stale keys were soft deleted rather than deleted
+	// permanently, so that they could be restored until they are purged
+	SoftDeleted bool
+}
+
+// KeyValueJanitor removes keys nobody writes anymore, such as cursors of streamers that
+// were shut down. It walks the keys with a Streamer of its own, so it requires the
+// querier to be a MetaGetter, i.e. FeatureTimestamps for the Postgres one. Stale keys are
+// soft deleted if the querier soft deletes (see WithSoftDelete), so that a retention set too
+// short does not erase progress irreversibly
+type KeyValueJanitor struct {
+	q    KeyValueQ
+	opts JanitorOptions
+}
+
+// NewKeyValueJanitor creates a janitor of the storage of q
+func NewKeyValueJanitor(q KeyValueQ, opts JanitorOptions) *KeyValueJanitor {
+	if opts.CursorKey == "" {
+		opts.CursorKey = defaultJanitorCursorKey
+	}
+	if opts.BatchSize == 0 {
+		opts.BatchSize = defaultJanitorBatchSize
+	}
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
+	return &KeyValueJanitor{q: q, opts: opts}
+}
+
+// keyStreamable streams the keys starting with the prefix
+type keyStreamable struct {
+	q      KeyValueQ
+	prefix string
+}
+
+func (s keyStreamable) SelectWithPageParams(pageParams pgdb.OffsetPageParams) ([]string, error) {
+	return s.q.ListKeys(s.prefix, pageParams)
+}
+
+// Run walks all the keys matching the prefix once, batch by batch, and removes the ones
+// not updated within the retention. Offset pages would skip keys if some were removed in
+// the middle of the walk, so the stale keys are removed once it is over. A key written
+// after it was examined is removed anyway. Cancelling ctx stops the run between batches
+func (j *KeyValueJanitor) Run(ctx context.Context) (JanitorReport, error) {
+	var report JanitorReport
+
+	q := withContext(j.q, ctx)
+	metaQ, ok := q.(MetaGetter)
+	if !ok {
+		return report, errors.New("querier cannot tell when values were updated")
+	}
+
+	// every run walks the keys from the first page
+	if err := q.Upsert(KeyValue{Key: j.opts.CursorKey, Value: "0"}); err != nil {
+		return report, errors.Wrap(err, "failed to reset janitor cursor", logan.F{"cursor_key": j.opts.CursorKey})
+	}
+	streamer := NewStreamer(StreamerInitParams[string]{
+		Stream:      keyStreamable{q: q, prefix: j.opts.Prefix},
+		KeyValueQ:   j.q,
+		KeyValueKey: j.opts.CursorKey,
+		BatchSize:   &j.opts.BatchSize,
+		Ctx:         &ctx,
+		Clock:       j.opts.Clock,
+	})
+
+	staleBefore := j.opts.Clock.Now().Add(-j.opts.Retention)
+	var stale []string
+	for batches := 0; ; batches++ {
+		if err := ctx.Err(); err != nil {
+			return report, errors.Wrap(err, "janitor run interrupted", logan.F{"examined": report.Examined})
+		}
+
+		keys, err := streamer.FormList()
+		if err != nil {
+			return report, errors.Wrap(err, "failed to form a batch of keys", logan.F{"examined": report.Examined})
+		}
+		if len(keys) == 0 {
+			break
+		}
+		// the streamer took the first page again, so all the keys were examined
+		page, err := streamer.GetCurrentPage()
+		if err != nil {
+			return report, errors.Wrap(err, "failed to get janitor cursor")
+		}
+		if page == 1 && batches > 0 {
+			break
+		}
+
+		for _, key := range keys {
+			if strings.HasPrefix(key, j.opts.CursorKey) {
+				continue
+			}
+			report.Examined++
+
+			value, err := metaQ.GetWithMeta(key)
+			if err != nil {
+				return report, errors.Wrap(err, "failed to get value with meta", logan.F{"key": key})
+			}
+			if value == nil || !value.UpdatedAt.Before(staleBefore) {
+				continue
+			}
+
+			stale = append(stale, key)
+			if j.opts.Log != nil {
+				j.opts.Log.WithFields(logan.F{
+					"key":        key,
+					"updated_at": value.UpdatedAt,
+					"dry_run":    j.opts.DryRun,
+				}).Info("Found stale key")
+			}
+		}
+	}
+	report.Stale = len(stale)
+
+	if !j.opts.DryRun {
+		remove := q.DeleteMany
+		if deleter, ok := q.(SoftDeleter); ok && deleter.SoftDeletes() && !j.opts.HardDelete {
+			remove, report.SoftDeleted = deleter.SoftDeleteMany, true
+		}
+		for len(stale) > 0 {
+			if err := ctx.Err(); err != nil {
+				return report, errors.Wrap(err, "janitor run interrupted", logan.F{"removed": report.Removed})
+			}
+
+			batch := stale
+			if uint64(len(batch)) > j.opts.BatchSize {
+				batch = batch[:j.opts.BatchSize]
+			}
+			removed, err := remove(batch)
+			if err != nil {
+				return report, errors.Wrap(err, "failed to remove stale keys", logan.F{"removed": report.Removed})
+			}
+			report.Removed += removed
+			stale = stale[len(batch):]
+		}
+	}
+
+	// the walk is over, so neither the cursor nor its batch size is needed anymore
+	_, err := q.DeleteMany([]string{j.opts.CursorKey, j.opts.CursorKey + batchSizeKeySuffix})
+	return report, errors.Wrap(err, "failed to delete janitor cursor", logan.F{"cursor_key": j.opts.CursorKey})
+}
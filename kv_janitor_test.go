@@ -0,0 +1,159 @@
+package dban_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+// metaKeyValueQ tells the update times of the values it was given, and the zero time for
+// the others
+type metaKeyValueQ struct {
+	dban.KeyValueQ
+	updated map[string]time.Time
+}
+
+func (q *metaKeyValueQ) GetWithMeta(key string) (*dban.KeyValueMeta, error) {
+	value, err := q.Get(key)
+	if value == nil || err != nil {
+		return nil, err
+	}
+	return &dban.KeyValueMeta{KeyValue: *value, UpdatedAt: q.updated[key]}, nil
+}
+
+func newJanitorTestQ(t *testing.T, now time.Time) *metaKeyValueQ {
+	q := &metaKeyValueQ{KeyValueQ: dbantest.NewMemoryKeyValueQ(), updated: make(map[string]time.Time)}
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("streamer:%02d", i)
+		require.NoError(t, q.Upsert(dban.KeyValue{Key: key, Value: "1"}))
+		// every third cursor was updated recently
+		q.updated[key] = now.Add(-48 * time.Hour)
+		if i%3 == 0 {
+			q.updated[key] = now.Add(-time.Hour)
+		}
+	}
+	require.NoError(t, q.Upsert(dban.KeyValue{Key: "other", Value: "1"}))
+	return q
+}
+
+// softDeletingQ soft deletes the values by moving them aside
+type softDeletingQ struct {
+	*metaKeyValueQ
+	deleted map[string]string
+}
+
+func (q *softDeletingQ) SoftDeletes() bool {
+	return true
+}
+
+func (q *softDeletingQ) SoftDelete(key string) error {
+	_, err := q.SoftDeleteMany([]string{key})
+	return err
+}
+
+func (q *softDeletingQ) SoftDeleteMany(keys []string) (int64, error) {
+	values, err := q.GetMany(keys)
+	if err != nil {
+		return 0, err
+	}
+	for key, value := range values {
+		q.deleted[key] = value.Value
+	}
+	return q.DeleteMany(keys)
+}
+
+func (q *softDeletingQ) Restore(key string) error {
+	value, ok := q.deleted[key]
+	if !ok {
+		return dban.ErrNoSuchKey
+	}
+	delete(q.deleted, key)
+	return q.Upsert(dban.KeyValue{Key: key, Value: value})
+}
+
+func (q *softDeletingQ) WithDeleted() dban.KeyValueQ {
+	return q
+}
+
+func (q *softDeletingQ) PurgeDeleted(time.Duration) (int64, error) {
+	purged := int64(len(q.deleted))
+	q.deleted = make(map[string]string)
+	return purged, nil
+}
+
+func TestKeyValueJanitor(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	opts := dban.JanitorOptions{
+		Prefix:    "streamer:",
+		Retention: 24 * time.Hour,
+		BatchSize: 4,
+		Clock:     &fakeClock{now: now},
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		q := newJanitorTestQ(t, now)
+		dryRun := opts
+		dryRun.DryRun = true
+
+		report, err := dban.NewKeyValueJanitor(q, dryRun).Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, dban.JanitorReport{Examined: 25, Stale: 16}, report)
+
+		count, err := q.Count()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(26), count, "nothing is removed")
+	})
+
+	t.Run("removal", func(t *testing.T) {
+		q := newJanitorTestQ(t, now)
+
+		report, err := dban.NewKeyValueJanitor(q, opts).Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, dban.JanitorReport{Examined: 25, Stale: 16, Removed: 16}, report)
+
+		keys, err := q.ListKeys("", pgdb.OffsetPageParams{Limit: 100, Order: pgdb.OrderTypeAsc})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"other", "streamer:00", "streamer:03", "streamer:06", "streamer:09",
+			"streamer:12", "streamer:15", "streamer:18", "streamer:21", "streamer:24"}, keys,
+			"fresh keys, keys not matching the prefix and the cursor are kept")
+	})
+
+	t.Run("soft delete", func(t *testing.T) {
+		q := &softDeletingQ{metaKeyValueQ: newJanitorTestQ(t, now), deleted: make(map[string]string)}
+
+		report, err := dban.NewKeyValueJanitor(q, opts).Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, dban.JanitorReport{Examined: 25, Stale: 16, Removed: 16, SoftDeleted: true}, report)
+		assert.Len(t, q.deleted, 16, "stale keys must be soft deleted once the querier soft deletes")
+		require.NoError(t, q.Restore("streamer:01"))
+
+		q = &softDeletingQ{metaKeyValueQ: newJanitorTestQ(t, now), deleted: make(map[string]string)}
+		hardDelete := opts
+		hardDelete.HardDelete = true
+		report, err = dban.NewKeyValueJanitor(q, hardDelete).Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, dban.JanitorReport{Examined: 25, Stale: 16, Removed: 16}, report)
+		assert.Empty(t, q.deleted)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		q := newJanitorTestQ(t, now)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := dban.NewKeyValueJanitor(q, opts).Run(ctx)
+		assert.True(t, dban.Is(err, context.Canceled), "unexpected error: %v", err)
+	})
+
+	t.Run("no meta", func(t *testing.T) {
+		_, err := dban.NewKeyValueJanitor(dbantest.NewMemoryKeyValueQ(), opts).Run(context.Background())
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,139 @@
+package dban
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// KeyValueJSONQ is an interface for querying a storage of JSON documents by keys. Unlike
+// KeyValueQ, documents are stored as jsonb, so that they could be validated and queried by
+// the database. Requires FeatureJSON
+type KeyValueJSONQ interface {
+	// New creates a new instance of an interface with all filters cleared
+	New() KeyValueJSONQ
+	// Upsert marshals doc to JSON and updates the document by the key if there is one,
+	// inserts it if no. Documents that could not be marshalled fail with ErrInvalidJSON
+	Upsert(key string, doc interface{}) error
+	// Get unmarshals the document by the key into dst and reports whether there is one
+	Get(key string, dst interface{}) (bool, error)
+	// Patch sets the field of the document by the path to value marshalled to JSON, the
+	// way jsonb_set does, keeping the rest of the document. A missing document fails with
+	// ErrNoSuchKey
+	Patch(key string, path []string, value interface{}) error
+	// Delete deletes the document by the key. Deleting a missing key is not an error
+	Delete(key string) error
+}
+
+const keyValueJSONTable = "key_value_json"
+
+var (
+	jsonGetQuery    = mustBuild(squirrel.Select(valueColumn).From(keyValueJSONTable).Where(squirrel.Eq{keyColumn: ""}))
+	jsonUpsertQuery = mustBuild(
+		squirrel.Insert(keyValueJSONTable).Columns(keyColumn, valueColumn).Values("", "").
+			Suffix("ON CONFLICT (" + keyColumn + ") DO UPDATE SET " + valueColumn + " = EXCLUDED." + valueColumn),
+	)
+	jsonPatchQuery = mustBuild(
+		squirrel.Update(keyValueJSONTable).
+			Set(valueColumn, squirrel.Expr("jsonb_set("+valueColumn+", ?::text[], ?::jsonb)", "", "")).
+			Where(squirrel.Eq{keyColumn: ""}),
+	)
+	jsonDeleteQuery = mustBuild(squirrel.Delete(keyValueJSONTable).Where(squirrel.Eq{keyColumn: ""}))
+)
+
+type keyValueJSONQ struct {
+	db *pgdb.DB
+}
+
+// NewKeyValueJSONQ creates a new instance of a JSON document querier
+func NewKeyValueJSONQ(db *pgdb.DB) KeyValueJSONQ {
+	return &keyValueJSONQ{db: db}
+}
+
+func (q *keyValueJSONQ) New() KeyValueJSONQ {
+	return NewKeyValueJSONQ(q.db.Clone())
+}
+
+func (q *keyValueJSONQ) Upsert(key string, doc interface{}) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	raw, err := marshalJSON(doc)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal document", logan.F{"key": key})
+	}
+
+	err = q.db.ExecRaw(jsonUpsertQuery, key, raw)
+	countKV(kvVarUpsert, err)
+	if err != nil {
+		return errors.Wrap(classifyPostgres(err), "failed to upsert document", logan.F{"key": key})
+	}
+	return nil
+}
+
+func (q *keyValueJSONQ) Get(key string, dst interface{}) (bool, error) {
+	var raw string
+	err := q.db.GetRaw(&raw, jsonGetQuery, key)
+	if err == sql.ErrNoRows {
+		countKV(kvVarGet, nil)
+		return false, nil
+	}
+	countKV(kvVarGet, err)
+	if err != nil {
+		return false, errors.Wrap(classifyPostgres(err), "failed to get document", logan.F{"key": key})
+	}
+
+	if err = json.Unmarshal([]byte(raw), dst); err != nil {
+		return false, errors.Wrap(err, "failed to unmarshal document", logan.F{"key": key})
+	}
+	return true, nil
+}
+
+func (q *keyValueJSONQ) Patch(key string, path []string, value interface{}) error {
+	fields := logan.F{"key": key, "path": path}
+	if len(path) == 0 {
+		return errors.From(errors.New("path is empty, use Upsert to replace the document"), fields)
+	}
+	raw, err := marshalJSON(value)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal value", fields)
+	}
+
+	result, err := q.db.ExecWithResult(squirrel.Expr(jsonPatchQuery, pq.Array(path), raw, key))
+	countKV(kvVarUpsert, err)
+	if err != nil {
+		return errors.Wrap(classifyPostgres(err), "failed to patch document", fields)
+	}
+	patched, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "failed to get number of patched documents", fields)
+	}
+	if patched == 0 {
+		return errors.From(ErrNoSuchKey, fields)
+	}
+	return nil
+}
+
+func (q *keyValueJSONQ) Delete(key string) error {
+	err := q.db.ExecRaw(jsonDeleteQuery, key)
+	countKV(kvVarDelete, err)
+	if err != nil {
+		return errors.Wrap(classifyPostgres(err), "failed to delete document", logan.F{"key": key})
+	}
+	return nil
+}
+
+// marshalJSON marshals v, failing with ErrInvalidJSON before the database is reached.
+// Marshalling validates json.RawMessage as well
+func marshalJSON(v interface{}) (string, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return "", &kindError{kind: ErrInvalidJSON, err: err}
+	}
+	return string(raw), nil
+}
@@ -0,0 +1,90 @@
+package dban
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testJSONDoc struct {
+	Name  string            `json:"name"`
+	Flags map[string]bool   `json:"flags,omitempty"`
+	Meta  map[string]string `json:"meta,omitempty"`
+}
+
+func TestKeyValueJSONQ(t *testing.T) {
+	db, mock := newMockDB(t)
+	jsonQ := NewKeyValueJSONQ(db)
+
+	mock.ExpectExec("INSERT INTO key_value_json (key,value) VALUES ($1,$2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value").
+		WithArgs("config", `{"name":"streamer"}`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT value FROM key_value_json WHERE key = $1").WithArgs("config").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(`{"name": "streamer", "flags": {"enabled": true}}`))
+	mock.ExpectQuery("SELECT value FROM key_value_json WHERE key = $1").WithArgs("missing").
+		WillReturnError(sql.ErrNoRows)
+
+	const patchSQL = "UPDATE key_value_json SET value = jsonb_set(value, $1::text[], $2::jsonb) WHERE key = $3"
+	mock.ExpectExec(patchSQL).WithArgs(`{"flags","enabled"}`, "false", "config").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(patchSQL).WithArgs(`{"name"}`, `"x"`, "missing").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	require.NoError(t, jsonQ.Upsert("config", testJSONDoc{Name: "streamer"}))
+
+	var doc testJSONDoc
+	found, err := jsonQ.Get("config", &doc)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, testJSONDoc{Name: "streamer", Flags: map[string]bool{"enabled": true}}, doc)
+
+	found, err = jsonQ.Get("missing", &doc)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, jsonQ.Patch("config", []string{"flags", "enabled"}, false))
+	err = jsonQ.Patch("missing", []string{"name"}, "x")
+	assert.True(t, Is(err, ErrNoSuchKey), "unexpected error: %v", err)
+}
+
+func TestKeyValueJSONQInvalidInput(t *testing.T) {
+	// no queries are expected, invalid documents must fail before reaching the database
+	db, _ := newMockDB(t)
+	jsonQ := NewKeyValueJSONQ(db)
+
+	err := jsonQ.Upsert("config", json.RawMessage(`{"name":`))
+	assert.True(t, Is(err, ErrInvalidJSON), "unexpected error: %v", err)
+	err = jsonQ.Upsert("config", make(chan int))
+	assert.True(t, Is(err, ErrInvalidJSON), "unexpected error: %v", err)
+	err = jsonQ.Patch("config", []string{"name"}, json.RawMessage("nope"))
+	assert.True(t, Is(err, ErrInvalidJSON), "unexpected error: %v", err)
+
+	assert.Error(t, jsonQ.Patch("config", nil, "x"))
+	assert.True(t, Is(jsonQ.Upsert(" ", "x"), ErrInvalidKey))
+}
+
+func TestKeyValueJSONQPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db, WithFeatures(FeatureJSON))
+	jsonQ := NewKeyValueJSONQ(db)
+
+	require.NoError(t, jsonQ.Upsert("config", testJSONDoc{Name: "streamer", Meta: map[string]string{"owner": "a"}}))
+	require.NoError(t, jsonQ.Patch("config", []string{"meta", "owner"}, "b"))
+	require.NoError(t, jsonQ.Patch("config", []string{"flags"}, map[string]bool{"enabled": true}))
+
+	var doc testJSONDoc
+	found, err := jsonQ.Get("config", &doc)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, testJSONDoc{
+		Name:  "streamer",
+		Flags: map[string]bool{"enabled": true},
+		Meta:  map[string]string{"owner": "b"},
+	}, doc)
+
+	require.NoError(t, jsonQ.Delete("config"))
+	found, err = jsonQ.Get("config", &doc)
+	require.NoError(t, err)
+	assert.False(t, found)
+}
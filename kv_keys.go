@@ -0,0 +1,79 @@
+package dban
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Masterminds/squirrel"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// suspiciousKeys selects keys of the table consisting of whitespace only (including the
+// empty one)
+func suspiciousKeys(q *kvQueries) squirrel.SelectBuilder {
+	return squirrel.Select(q.key).From(q.table).Where(q.key + ` ~ '^\s*$'`).OrderBy(q.key)
+}
+
+// DefaultMaxKeyLength is the maximum length of a key in characters, the one FeatureKeyCheck
+// makes the database enforce
+const DefaultMaxKeyLength = 256
+
+// ValidateKey checks that a key could be used in the key value storage: empty and
+// whitespace-only keys are rejected with ErrEmptyKey, as it is easy to end up with them
+// because of a missing config value, making unrelated users share the same row. Keys
+// longer than DefaultMaxKeyLength characters are rejected with ErrKeyTooLong
+func ValidateKey(key string) error {
+	return validateKey(key, DefaultMaxKeyLength)
+}
+
+// WithMaxKeyLength makes the querier reject keys longer than n characters instead of
+// DefaultMaxKeyLength, e.g. to match the constraint of a table migrated by hand
+func WithMaxKeyLength(n int) KeyValueQOption {
+	return func(q *keyValueQ) {
+		q.maxKeyLength = n
+	}
+}
+
+// validateKey checks the key the way ValidateKey does with the maximum length of the querier
+func (q *keyValueQ) validateKey(key string) error {
+	maxLength := q.maxKeyLength
+	if maxLength <= 0 {
+		maxLength = DefaultMaxKeyLength
+	}
+	return validateKey(key, maxLength)
+}
+
+func validateKey(key string, maxLength int) error {
+	if strings.TrimSpace(key) == "" {
+		return errors.From(ErrEmptyKey, logan.F{"key": key})
+	}
+	if length := utf8.RuneCountInString(key); length > maxLength {
+		// the key itself could be huge, so only its beginning is logged
+		return errors.From(ErrKeyTooLong, logan.F{"key": truncateKey(key, 64), "length": length, "max_length": maxLength})
+	}
+	return nil
+}
+
+// truncateKey returns the first n characters of the key followed by an ellipsis, if it is longer
+func truncateKey(key string, n int) string {
+	for i := range key {
+		if n == 0 {
+			return key[:i] + "…"
+		}
+		n--
+	}
+	return key
+}
+
+// FindSuspiciousCursorKeys lists keys of the key value table that ValidateKey rejects,
+// so that rows written before keys were validated could be found and cleaned up. The table
+// is the default one unless configured by WithTable or WithTableSchema
+func FindSuspiciousCursorKeys(db *pgdb.DB, opts ...KeyValueQOption) ([]string, error) {
+	var keys []string
+	if err := db.Select(&keys, suspiciousKeys(kvQueriesOf(opts))); err != nil {
+		return nil, errors.Wrap(err, "failed to select suspicious keys")
+	}
+	return keys, nil
+}
@@ -0,0 +1,76 @@
+package dban
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueQRejectsInvalidKeys(t *testing.T) {
+	// the mock fails the test on any unexpected query
+	db, _ := newMockDB(t)
+	kvQ := NewKeyValueQ(db)
+
+	for _, key := range []string{"", " ", "\t\n"} {
+		err := kvQ.Upsert(KeyValue{Key: key, Value: "1"})
+		assert.True(t, Is(err, ErrInvalidKey), "%q", key)
+	}
+	assert.NoError(t, ValidateKey(" cursor "))
+
+	err := kvQ.Upsert(KeyValue{Key: "", Value: "1"})
+	assert.True(t, Is(err, ErrEmptyKey), "unexpected error: %v", err)
+	_, err = kvQ.Get(" ")
+	assert.True(t, Is(err, ErrEmptyKey), "unexpected error: %v", err)
+	err = kvQ.Upsert(KeyValue{Key: strings.Repeat("k", 257)})
+	assert.True(t, Is(err, ErrKeyTooLong), "unexpected error: %v", err)
+}
+
+func TestKeyValueQMaxKeyLength(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db, WithMaxKeyLength(4))
+
+	mock.ExpectQuery(getSQL).WithArgs("ключ").WillReturnError(sql.ErrNoRows)
+	kv, err := kvQ.Get("ключ")
+	require.NoError(t, err, "the length is counted in characters")
+	assert.Nil(t, kv)
+
+	_, err = kvQ.Get("cursor")
+	assert.True(t, Is(err, ErrKeyTooLong), "unexpected error: %v", err)
+	_, err = NewKeyValueQ(withMockTx(db), WithMaxKeyLength(4)).LockingGet("cursor")
+	assert.True(t, Is(err, ErrInvalidKey), "unexpected error: %v", err)
+	assert.True(t, Is(ValidateKey(strings.Repeat("k", DefaultMaxKeyLength+1)), ErrKeyTooLong))
+	assert.NoError(t, ValidateKey(strings.Repeat("k", DefaultMaxKeyLength)))
+}
+
+func TestKeyCheckPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db, WithFeatures(FeatureKeyCheck))
+	// the querier accepts longer keys, so that the constraint is reached
+	kvQ := NewKeyValueQ(db, WithMaxKeyLength(1000))
+
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: strings.Repeat("k", DefaultMaxKeyLength), Value: "1"}))
+	assert.Error(t, kvQ.Upsert(KeyValue{Key: strings.Repeat("k", DefaultMaxKeyLength+1), Value: "1"}))
+	_, err := db.ExecWithResult(squirrel.Insert("key_value").Columns("key", "value").Values("", "1"))
+	assert.Error(t, err, "the constraint rejects empty keys")
+}
+
+func TestFindSuspiciousCursorKeys(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	mock.ExpectQuery(`SELECT key FROM key_value WHERE key ~ '^\s*$' ORDER BY key`).
+		WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("").AddRow("  "))
+	keys, err := FindSuspiciousCursorKeys(db)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "  "}, keys)
+
+	mock.ExpectQuery(`SELECT "k" FROM "dban"."cursors" WHERE "k" ~ '^\s*$' ORDER BY "k"`).
+		WillReturnRows(sqlmock.NewRows([]string{"k"}))
+	keys, err = FindSuspiciousCursorKeys(db, WithTable(KeyValueTable{Schema: "dban", Name: "cursors", KeyColumn: "k"}))
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
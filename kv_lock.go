@@ -0,0 +1,72 @@
+package dban
+
+import (
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// NonBlockingLocker is a key value querier able to lock a row without waiting for another
+// transaction holding it, so that contending workers could back off instead of hanging
+type NonBlockingLocker interface {
+	// LockingGetNoWait does the same thing as LockingGet, but fails with ErrRowLocked
+	// right away if the row is locked by another transaction
+	LockingGetNoWait(key string) (*KeyValue, error)
+	// LockingGetSkipLocked does the same thing as LockingGet, but returns nil right away
+	// if the row is locked by another transaction, as if there were no value by the key
+	LockingGetSkipLocked(key string) (*KeyValue, error)
+}
+
+var _ NonBlockingLocker = (*keyValueQ)(nil)
+
+// rowLock is a way a read locks the row it reads
+type rowLock int
+
+const (
+	rowLockNone rowLock = iota
+	rowLockForUpdate
+	rowLockNoWait
+	rowLockSkipLocked
+
+	rowLocks
+)
+
+// suffix returns the locking clause of a select
+func (l rowLock) suffix() string {
+	switch l {
+	case rowLockForUpdate:
+		return "FOR UPDATE"
+	case rowLockNoWait:
+		return "FOR UPDATE NOWAIT"
+	case rowLockSkipLocked:
+		return "FOR UPDATE SKIP LOCKED"
+	default:
+		return ""
+	}
+}
+
+func (q *keyValueQ) LockingGetNoWait(key string) (*KeyValue, error) {
+	if err := q.checkTx(key); err != nil {
+		return nil, err
+	}
+
+	value, err := q.get(key, rowLockNoWait)
+	if err != nil && isLockNotAvailable(err) {
+		// the row was not waited for, so it is not a timeout
+		return nil, errors.Wrap(&kindError{kind: ErrRowLocked, err: errors.Cause(err)}, "failed to get value", logan.F{"key": key})
+	}
+	return value, err
+}
+
+func (q *keyValueQ) LockingGetSkipLocked(key string) (*KeyValue, error) {
+	if err := q.checkTx(key); err != nil {
+		return nil, err
+	}
+	return q.get(key, rowLockSkipLocked)
+}
+
+// isLockNotAvailable reports whether err is a failure to lock a row
+func isLockNotAvailable(err error) bool {
+	return walk(err, func(err error) bool {
+		return sqlState(err) == sqlStateLockNotAvailable
+	})
+}
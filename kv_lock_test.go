@@ -0,0 +1,41 @@
+package dban
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNonBlockingLocker(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(withMockTx(db)).(NonBlockingLocker)
+
+	const (
+		noWaitSQL        = "SELECT key, value FROM key_value WHERE key = $1 FOR UPDATE NOWAIT"
+		skipLockedSQL    = "SELECT key, value FROM key_value WHERE key = $1 FOR UPDATE SKIP LOCKED"
+		lockNotAvailable = pq.ErrorCode("55P03")
+	)
+	mock.ExpectQuery(noWaitSQL).WithArgs("cursor").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("cursor", "1"))
+	mock.ExpectQuery(noWaitSQL).WithArgs("cursor").WillReturnError(&pq.Error{Code: lockNotAvailable})
+	mock.ExpectQuery(skipLockedSQL).WithArgs("cursor").WillReturnError(sql.ErrNoRows)
+
+	kv, err := kvQ.LockingGetNoWait("cursor")
+	require.NoError(t, err)
+	assert.Equal(t, &KeyValue{Key: "cursor", Value: "1"}, kv)
+
+	_, err = kvQ.LockingGetNoWait("cursor")
+	assert.True(t, Is(err, ErrRowLocked))
+	assert.False(t, Is(err, ErrLockTimeout), "a row that was not waited for must not look like a timeout")
+
+	kv, err = kvQ.LockingGetSkipLocked("cursor")
+	require.NoError(t, err)
+	assert.Nil(t, kv, "a locked row must be skipped")
+
+	_, err = NewKeyValueQ(db).(NonBlockingLocker).LockingGetNoWait("cursor")
+	assert.True(t, Is(err, ErrNoTransaction))
+}
@@ -0,0 +1,234 @@
+package dban
+
+import (
+	"time"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// LogLevel is a level of logan, e.g. logan.DebugLevel
+type LogLevel = logan.Level
+
+// LoggedOption is an optional parameter of a logged querier
+type LoggedOption func(*loggedKeyValueQ)
+
+// WithLoggedValues makes the logged querier log values read and written, unless they are
+// longer than maxSize bytes, in which case only their size is logged. Values are not
+// logged by default, as they could be sensitive
+func WithLoggedValues(maxSize int) LoggedOption {
+	return func(q *loggedKeyValueQ) {
+		q.logValues = true
+		q.maxValueSize = maxSize
+	}
+}
+
+type loggedKeyValueQ struct {
+	inner        KeyValueQ
+	log          *logan.Entry
+	level        LogLevel
+	logValues    bool
+	maxValueSize int
+}
+
+// NewLoggedKeyValueQ creates a querier logging every operation of inner with the method,
+// the key and the duration at the level. Failed operations are logged with the error at
+// the warning level, unless the level is more severe. MustGet and MustLockingGet log the
+// error they are about to panic with
+func NewLoggedKeyValueQ(inner KeyValueQ, log *logan.Entry, level LogLevel, opts ...LoggedOption) KeyValueQ {
+	q := &loggedKeyValueQ{
+		inner: inner,
+		log:   log,
+		level: level,
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	return q
+}
+
+func (q *loggedKeyValueQ) New() KeyValueQ {
+	clone := *q
+	clone.inner = q.inner.New()
+	return &clone
+}
+
+// logged calls op and logs it as an operation of the method with the fields. value returns
+// the value read or written by op, if any
+func logged[T any](q *loggedKeyValueQ, method string, fields logan.F, op func() (T, error), value func(T) *string) (T, error) {
+	started := time.Now()
+	result, err := op()
+
+	fields = fields.Merge(logan.F{"method": method, "duration": time.Since(started)})
+	if err != nil {
+		level := q.level
+		if level > logan.WarnLevel {
+			level = logan.WarnLevel
+		}
+		q.log.Log(uint32(level), fields, err, false, "Key value operation failed")
+		return result, err
+	}
+
+	if value != nil {
+		if v := value(result); v != nil {
+			fields = fields.Merge(q.valueFields(*v))
+		}
+	}
+	q.log.Log(uint32(q.level), fields, nil, false, "Key value operation completed")
+	return result, nil
+}
+
+// loggedErr is logged of an operation returning an error only
+func loggedErr(q *loggedKeyValueQ, method string, fields logan.F, op func() error) error {
+	_, err := logged(q, method, fields, func() (struct{}, error) {
+		return struct{}{}, op()
+	}, nil)
+	return err
+}
+
+// valueFields returns the fields describing the value, which is omitted unless values are logged
+func (q *loggedKeyValueQ) valueFields(value string) logan.F {
+	fields := logan.F{"value_size": len(value)}
+	if q.logValues && len(value) <= q.maxValueSize {
+		fields["value"] = value
+	}
+	return fields
+}
+
+// readValue returns the value of kv read, if any
+func readValue(kv *KeyValue) *string {
+	if kv == nil {
+		return nil
+	}
+	return &kv.Value
+}
+
+func (q *loggedKeyValueQ) Get(key string) (*KeyValue, error) {
+	return logged(q, "get", logan.F{"key": key}, func() (*KeyValue, error) {
+		return q.inner.Get(key)
+	}, readValue)
+}
+
+func (q *loggedKeyValueQ) GetMany(keys []string) (map[string]KeyValue, error) {
+	return logged(q, "get_many", logan.F{"keys": keys}, func() (map[string]KeyValue, error) {
+		return q.inner.GetMany(keys)
+	}, nil)
+}
+
+func (q *loggedKeyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return logged(q, "select_by_prefix", logan.F{"prefix": prefix, "page": params.PageNumber}, func() ([]KeyValue, error) {
+		return q.inner.SelectByPrefix(prefix, params)
+	}, nil)
+}
+
+func (q *loggedKeyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	return logged(q, "list_keys", logan.F{"prefix": prefix, "page": params.PageNumber}, func() ([]string, error) {
+		return q.inner.ListKeys(prefix, params)
+	}, nil)
+}
+
+func (q *loggedKeyValueQ) Count() (uint64, error) {
+	return logged(q, "count", logan.F{}, q.inner.Count, nil)
+}
+
+func (q *loggedKeyValueQ) FilterByKeys(keys ...string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByKeys(keys...)
+	return &filtered
+}
+
+func (q *loggedKeyValueQ) FilterByPrefix(prefix string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByPrefix(prefix)
+	return &filtered
+}
+
+func (q *loggedKeyValueQ) FilterByValueLike(pattern string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByValueLike(pattern)
+	return &filtered
+}
+
+func (q *loggedKeyValueQ) Select() ([]KeyValue, error) {
+	return logged(q, "select", logan.F{}, q.inner.Select, nil)
+}
+
+func (q *loggedKeyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return logged(q, "select_page", logan.F{"page": params.PageNumber}, func() ([]KeyValue, error) {
+		return q.inner.SelectPage(params)
+	}, nil)
+}
+
+func (q *loggedKeyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.SelectPage(KeyValueStreamParams(params))
+}
+
+func (q *loggedKeyValueQ) MustGet(key string) *KeyValue {
+	value, err := GetStrict(q, key)
+	if err != nil {
+		q.log.WithError(err).WithFields(logan.F{"method": "must_get", "key": key}).Error("Key value operation failed, panicking")
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+func (q *loggedKeyValueQ) Upsert(kv KeyValue) error {
+	return loggedErr(q, "upsert", logan.F{"key": kv.Key}.Merge(q.valueFields(kv.Value)), func() error {
+		return q.inner.Upsert(kv)
+	})
+}
+
+func (q *loggedKeyValueQ) UpsertMany(kvs []KeyValue) error {
+	return loggedErr(q, "upsert_many", logan.F{"count": len(kvs)}, func() error {
+		return q.inner.UpsertMany(kvs)
+	})
+}
+
+func (q *loggedKeyValueQ) LockingGet(key string) (*KeyValue, error) {
+	return logged(q, "locking_get", logan.F{"key": key}, func() (*KeyValue, error) {
+		return q.inner.LockingGet(key)
+	}, readValue)
+}
+
+func (q *loggedKeyValueQ) MustLockingGet(key string) *KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		q.log.WithError(err).WithFields(logan.F{"method": "must_locking_get", "key": key}).Error("Key value operation failed, panicking")
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
+
+func (q *loggedKeyValueQ) Delete(key string) error {
+	return loggedErr(q, "delete", logan.F{"key": key}, func() error {
+		return q.inner.Delete(key)
+	})
+}
+
+func (q *loggedKeyValueQ) DeleteMany(keys []string) (int64, error) {
+	return logged(q, "delete_many", logan.F{"keys": keys}, func() (int64, error) {
+		return q.inner.DeleteMany(keys)
+	}, nil)
+}
+
+func (q *loggedKeyValueQ) Update(key string, fn func(current *string) (string, error)) (KeyValue, error) {
+	return logged(q, "update", logan.F{"key": key}, func() (KeyValue, error) {
+		return q.inner.Update(key, fn)
+	}, func(kv KeyValue) *string {
+		return &kv.Value
+	})
+}
+
+func (q *loggedKeyValueQ) Transaction(fn func(q KeyValueQ) error) error {
+	return transaction(q.inner, func(inner KeyValueQ) error {
+		tx := *q
+		tx.inner = inner
+		return fn(&tx)
+	})
+}
+
+func (q *loggedKeyValueQ) WithinTx() bool {
+	return withinTx(q.inner)
+}
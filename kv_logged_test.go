@@ -0,0 +1,68 @@
+package dban_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/logan/v3"
+)
+
+func TestLoggedKeyValueQ(t *testing.T) {
+	var logs bytes.Buffer
+	inner := &failingKeyValueQ{KeyValueQ: dbantest.NewMemoryKeyValueQ()}
+	kvQ := dban.NewLoggedKeyValueQ(inner, logan.New().Out(&logs), logan.InfoLevel)
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "secret", Value: "hunter2"}))
+	assert.Equal(t, "hunter2", kvQ.New().MustGet("secret").Value)
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "method=upsert")
+	assert.Contains(t, lines[0], "key=secret")
+	assert.Contains(t, lines[0], "duration=")
+	assert.Contains(t, lines[1], "method=get")
+	assert.Contains(t, lines[1], "value_size=7")
+	assert.NotContains(t, logs.String(), "hunter2", "values are not logged by default")
+
+	logs.Reset()
+	inner.failing = true
+	assert.Error(t, kvQ.Upsert(dban.KeyValue{Key: "secret", Value: "x"}))
+	assert.Contains(t, logs.String(), "level=warning")
+	assert.Contains(t, logs.String(), "storage is unavailable")
+
+	logs.Reset()
+	assert.Panics(t, func() { kvQ.MustGet("missing") })
+	assert.Contains(t, logs.String(), "level=error")
+	assert.Contains(t, logs.String(), "method=must_get")
+}
+
+func TestLoggedKeyValueQValues(t *testing.T) {
+	var logs bytes.Buffer
+	kvQ := dban.NewLoggedKeyValueQ(dbantest.NewMemoryKeyValueQ(), logan.New().Out(&logs), logan.InfoLevel,
+		dban.WithLoggedValues(4))
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "page", Value: "12"}))
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "config", Value: `{"long":true}`}))
+	assert.Contains(t, logs.String(), "value=12")
+	assert.NotContains(t, logs.String(), "long", "values above the size are not logged")
+	assert.Contains(t, logs.String(), "value_size=13")
+}
+
+func TestLoggedKeyValueQComposition(t *testing.T) {
+	var logs bytes.Buffer
+	kvQ := dban.NewCachedKeyValueQ(
+		dban.NewLoggedKeyValueQ(
+			dban.NewRetryingKeyValueQ(dbantest.NewMemoryKeyValueQ(), dban.RetryConfig{}),
+			logan.New().Out(&logs), logan.DebugLevel),
+		time.Hour)
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "page", Value: "1"}))
+	assert.Equal(t, "1", kvQ.MustGet("page").Value)
+	assert.Equal(t, "1", kvQ.MustGet("page").Value)
+	assert.Equal(t, 2, strings.Count(logs.String(), "Key value operation completed"), "cached reads do not reach the logged querier")
+}
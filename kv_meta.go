@@ -0,0 +1,48 @@
+package dban
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+const (
+	createdAtColumn = "created_at"
+	updatedAtColumn = "updated_at"
+)
+
+// KeyValueMeta is a key value along with the times it was created and last updated at
+type KeyValueMeta struct {
+	KeyValue
+	CreatedAt time.Time `db:"created_at" structs:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" structs:"updated_at" json:"updated_at"`
+}
+
+// MetaGetter is a key value querier able to tell when values were written
+type MetaGetter interface {
+	// GetWithMeta gets the value by the key along with its timestamps, or nil if there is
+	// none. The Postgres querier requires FeatureTimestamps
+	GetWithMeta(key string) (*KeyValueMeta, error)
+}
+
+var _ MetaGetter = (*keyValueQ)(nil)
+
+func (q *keyValueQ) GetWithMeta(key string) (*KeyValueMeta, error) {
+	query := q.live(q.queries.selectKV.Columns(createdAtColumn, updatedAtColumn).Where(squirrel.Eq{q.queries.key: key}))
+
+	var value KeyValueMeta
+	err := q.db.GetContext(q.ctx, &value, query)
+	if err == sql.ErrNoRows {
+		countKV(kvVarGet, nil)
+		return nil, nil
+	}
+	countKV(kvVarGet, err)
+	if err != nil {
+		return nil, errors.Wrap(q.classify(err), "failed to get value with meta", logan.F{"key": key})
+	}
+
+	return &value, nil
+}
@@ -0,0 +1,57 @@
+package dban
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetWithMeta(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db).(MetaGetter)
+
+	const getWithMetaSQL = "SELECT key, value, created_at, updated_at FROM key_value WHERE key = $1"
+	created, updated := time.Unix(100, 0).UTC(), time.Unix(200, 0).UTC()
+	mock.ExpectQuery(getWithMetaSQL).WithArgs("cursor").WillReturnRows(
+		sqlmock.NewRows([]string{"key", "value", "created_at", "updated_at"}).AddRow("cursor", "3", created, updated),
+	)
+	mock.ExpectQuery(getWithMetaSQL).WithArgs("missing").WillReturnError(sql.ErrNoRows)
+
+	kv, err := kvQ.GetWithMeta("cursor")
+	require.NoError(t, err)
+	assert.Equal(t, &KeyValueMeta{
+		KeyValue:  KeyValue{Key: "cursor", Value: "3"},
+		CreatedAt: created,
+		UpdatedAt: updated,
+	}, kv)
+
+	kv, err = kvQ.GetWithMeta("missing")
+	require.NoError(t, err)
+	assert.Nil(t, kv)
+}
+
+func TestGetWithMetaPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db, WithFeatures(FeatureTimestamps))
+	kvQ := NewKeyValueQ(db)
+
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "cursor", Value: "1"}))
+	first, err := kvQ.(MetaGetter).GetWithMeta("cursor")
+	require.NoError(t, err)
+	require.NotNil(t, first)
+
+	// the trigger sets updated_at to now(), so the upserts are made apart
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "cursor", Value: "2"}))
+	second, err := kvQ.(MetaGetter).GetWithMeta("cursor")
+	require.NoError(t, err)
+
+	assert.Equal(t, "2", second.Value)
+	assert.True(t, second.CreatedAt.Equal(first.CreatedAt), "created_at must stay fixed")
+	assert.True(t, second.UpdatedAt.After(first.UpdatedAt), "updated_at must move forward")
+	assert.Equal(t, KeyValue{Key: "cursor", Value: "2"}, *kvQ.MustGet("cursor"), "plain reads must keep working")
+}
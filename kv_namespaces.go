@@ -0,0 +1,45 @@
+package dban
+
+import (
+	"github.com/Masterminds/squirrel"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// NoNamespace is a prefix keys without the delimiter (and keys starting with it) are grouped under
+const NoNamespace = ""
+
+// NamespaceInfo is a distinct key prefix with the number of keys under it
+type NamespaceInfo struct {
+	Prefix string `db:"prefix" json:"prefix"`
+	Keys   int64  `db:"keys" json:"keys"`
+}
+
+// NamespaceLister is a key value querier able to list key namespaces
+type NamespaceLister interface {
+	// ListNamespaces returns a page of distinct prefixes of keys up to the first delimiter,
+	// ordered by the prefix
+	ListNamespaces(delimiter string, page pgdb.OffsetPageParams) ([]NamespaceInfo, error)
+}
+
+var _ NamespaceLister = (*keyValueQ)(nil)
+
+func (q *keyValueQ) ListNamespaces(delimiter string, page pgdb.OffsetPageParams) ([]NamespaceInfo, error) {
+	if delimiter == "" {
+		return nil, errors.New("delimiter must not be empty")
+	}
+
+	query := squirrel.Select().
+		Column(squirrel.Expr("CASE WHEN strpos("+q.queries.key+", ?) > 0 THEN split_part("+q.queries.key+", ?, 1) ELSE ? END AS prefix",
+			delimiter, delimiter, NoNamespace)).
+		Column("count(*) AS keys").
+		From(q.queries.table).
+		GroupBy("prefix")
+
+	var namespaces []NamespaceInfo
+	if err := q.db.SelectContext(q.ctx, &namespaces, page.ApplyTo(query, "prefix")); err != nil {
+		return nil, errors.Wrap(err, "failed to select namespaces", logan.F{"delimiter": delimiter})
+	}
+	return namespaces, nil
+}
@@ -0,0 +1,44 @@
+package dban
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+func TestListNamespaces(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db).(NamespaceLister)
+
+	mock.ExpectQuery("SELECT CASE WHEN strpos(key, $1) > 0 THEN split_part(key, $2, 1) ELSE $3 END AS prefix, count(*) AS keys "+
+		"FROM key_value GROUP BY prefix ORDER BY prefix asc LIMIT 2 OFFSET 2").
+		WithArgs(":", ":", NoNamespace).
+		WillReturnRows(sqlmock.NewRows([]string{"prefix", "keys"}).AddRow("", 2).AddRow("streamer", 3))
+	namespaces, err := kvQ.ListNamespaces(":", pgdb.OffsetPageParams{Limit: 2, PageNumber: 1, Order: pgdb.OrderTypeAsc})
+	require.NoError(t, err)
+	assert.Equal(t, []NamespaceInfo{{Prefix: NoNamespace, Keys: 2}, {Prefix: "streamer", Keys: 3}}, namespaces)
+
+	_, err = kvQ.ListNamespaces("", pgdb.OffsetPageParams{})
+	assert.Error(t, err)
+}
+
+func TestListNamespacesPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db)
+	kvQ := NewKeyValueQ(db)
+
+	for _, key := range []string{"streamer:a", "streamer:b:nested", "streamer:c", "feature:x", "plain", "other", ":leading"} {
+		require.NoError(t, kvQ.Upsert(KeyValue{Key: key, Value: "1"}))
+	}
+
+	namespaces, err := kvQ.(NamespaceLister).ListNamespaces(":", pgdb.OffsetPageParams{Limit: 10, Order: pgdb.OrderTypeAsc})
+	require.NoError(t, err)
+	assert.Equal(t, []NamespaceInfo{
+		{Prefix: NoNamespace, Keys: 3},
+		{Prefix: "feature", Keys: 1},
+		{Prefix: "streamer", Keys: 3},
+	}, namespaces)
+}
@@ -0,0 +1,156 @@
+package dban
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// likeEscaper escapes the wildcards of LIKE, so that a prefix is matched literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// LikePrefix returns a LIKE pattern (with a backslash as the escape character) matching
+// strings starting with prefix
+func LikePrefix(prefix string) string {
+	return likeEscaper.Replace(prefix) + "%"
+}
+
+// PrefixDeleter is a key value querier able to delete values by a key prefix at once, e.g.
+// the keys of a retired streamer
+type PrefixDeleter interface {
+	// DeleteByPrefix deletes the values whose keys start with prefix, expired ones
+	// included, with a single statement and returns the number of deleted values. An empty
+	// prefix fails with ErrEmptyPrefix, so that the whole storage could not be wiped by mistake
+	DeleteByPrefix(prefix string) (int64, error)
+	// CountByPrefix returns the number of values DeleteByPrefix would delete, so that the
+	// blast radius could be checked first. An empty prefix counts all the values
+	CountByPrefix(prefix string) (int64, error)
+}
+
+var _ PrefixDeleter = (*keyValueQ)(nil)
+
+// byPrefix matches keys starting with prefix literally
+func (q *keyValueQ) byPrefix(prefix string) squirrel.Sqlizer {
+	return squirrel.Expr(q.queries.key+` LIKE ? ESCAPE '\'`, LikePrefix(prefix))
+}
+
+func (q *keyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	query := q.live(q.queries.selectKV)
+	if prefix != "" {
+		query = query.Where(q.byPrefix(prefix))
+	}
+
+	var values []KeyValue
+	err := q.db.SelectContext(q.ctx, &values, params.ApplyTo(query, q.queries.key))
+	countKV(kvVarGet, err)
+	if err != nil {
+		return nil, errors.Wrap(q.classify(err), "failed to select values by prefix", logan.F{"prefix": prefix})
+	}
+	return values, nil
+}
+
+// ListKeys selects the key column only, so that listing does not read the values
+func (q *keyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	query := q.live(squirrel.Select(q.queries.key).From(q.queries.table))
+	if prefix != "" {
+		query = query.Where(q.byPrefix(prefix))
+	}
+
+	keys := []string{}
+	err := q.db.SelectContext(q.ctx, &keys, params.ApplyTo(query, q.queries.key))
+	countKV(kvVarGet, err)
+	if err != nil {
+		return nil, errors.Wrap(q.classify(err), "failed to list keys", logan.F{"prefix": prefix})
+	}
+	return keys, nil
+}
+
+func (q *keyValueQ) Count() (uint64, error) {
+	var count uint64
+	err := q.db.GetContext(q.ctx, &count, q.live(squirrel.Select("count(*)").From(q.queries.table)))
+	countKV(kvVarGet, err)
+	if err != nil {
+		return 0, errors.Wrap(q.classify(err), "failed to count values")
+	}
+	return count, nil
+}
+
+func (q *keyValueQ) DeleteByPrefix(prefix string) (int64, error) {
+	if prefix == "" {
+		return 0, ErrEmptyPrefix
+	}
+
+	query := squirrel.Delete(q.queries.table).Where(q.byPrefix(prefix)).Suffix("RETURNING " + q.queries.key)
+	var deleted []string
+	err := q.retryWrite(prefix, func(q *keyValueQ) error {
+		deleted = deleted[:0]
+		return q.db.SelectContext(q.ctx, &deleted, query)
+	})
+	countKV(kvVarDelete, err)
+	if err != nil {
+		return 0, errors.Wrap(q.classify(err), "failed to delete values by prefix", logan.F{"prefix": prefix})
+	}
+
+	if q.events != nil {
+		for _, key := range deleted {
+			q.events.Emit(KVDeleted{Key: key})
+		}
+	}
+	return int64(len(deleted)), nil
+}
+
+func (q *keyValueQ) CountByPrefix(prefix string) (int64, error) {
+	query := squirrel.Select("count(*)").From(q.queries.table)
+	if prefix != "" {
+		query = query.Where(q.byPrefix(prefix))
+	}
+
+	var count int64
+	err := q.db.GetContext(q.ctx, &count, query)
+	countKV(kvVarGet, err)
+	if err != nil {
+		return 0, errors.Wrap(q.classify(err), "failed to count values by prefix", logan.F{"prefix": prefix})
+	}
+	return count, nil
+}
+
+// PageKeyValues sorts the key values by key and returns the page of them, defaulting the
+// params the way OffsetPageParams.ApplyTo does. It is meant for queriers that cannot page
+// on the storage side
+func PageKeyValues(values []KeyValue, params pgdb.OffsetPageParams) []KeyValue {
+	return page(values, params, func(kv KeyValue) string { return kv.Key })
+}
+
+// PageKeys does the same thing as PageKeyValues for keys without values
+func PageKeys(keys []string, params pgdb.OffsetPageParams) []string {
+	return page(keys, params, func(key string) string { return key })
+}
+
+func page[T any](items []T, params pgdb.OffsetPageParams, key func(T) string) []T {
+	limit := params.Limit
+	if limit == 0 {
+		limit = 15
+	}
+
+	sorted := make([]T, len(items))
+	copy(sorted, items)
+	sort.Slice(sorted, func(i, j int) bool {
+		if params.Order == pgdb.OrderTypeAsc {
+			return key(sorted[i]) < key(sorted[j])
+		}
+		return key(sorted[i]) > key(sorted[j])
+	})
+
+	offset := limit * params.PageNumber
+	if offset >= uint64(len(sorted)) {
+		return []T{}
+	}
+	if end := offset + limit; end < uint64(len(sorted)) {
+		return sorted[offset:end]
+	}
+	return sorted[offset:]
+}
@@ -0,0 +1,137 @@
+package dban
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+func TestLikePrefix(t *testing.T) {
+	assert.Equal(t, `streamer:orders%`, LikePrefix("streamer:orders"))
+	assert.Equal(t, `100\%\_off\\%`, LikePrefix(`100%_off\`))
+	assert.Equal(t, `%`, LikePrefix(""))
+}
+
+func TestKeyValueQSelectByPrefix(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db)
+
+	mock.ExpectQuery(`SELECT key, value FROM key_value WHERE key LIKE $1 ESCAPE '\' ORDER BY key asc LIMIT 2 OFFSET 2`).
+		WithArgs(`streamer\_%`).
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("streamer_c", "3"))
+	values, err := kvQ.SelectByPrefix("streamer_", pgdb.OffsetPageParams{Limit: 2, PageNumber: 1, Order: pgdb.OrderTypeAsc})
+	require.NoError(t, err)
+	assert.Equal(t, []KeyValue{{Key: "streamer_c", Value: "3"}}, values)
+
+	mock.ExpectQuery(`SELECT key, value FROM key_value ORDER BY key desc LIMIT 15 OFFSET 0`).
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}))
+	values, err = kvQ.SelectByPrefix("", pgdb.OffsetPageParams{})
+	require.NoError(t, err)
+	assert.Empty(t, values)
+}
+
+func TestKeyValueQListKeys(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db)
+
+	mock.ExpectQuery(`SELECT key FROM key_value WHERE key LIKE $1 ESCAPE '\' ORDER BY key asc LIMIT 2 OFFSET 0`).
+		WithArgs(`streamer:%`).
+		WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("streamer:a").AddRow("streamer:b"))
+	keys, err := kvQ.ListKeys("streamer:", pgdb.OffsetPageParams{Limit: 2, Order: pgdb.OrderTypeAsc})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"streamer:a", "streamer:b"}, keys)
+
+	mock.ExpectQuery(`SELECT count(*) FROM key_value`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	count, err := kvQ.Count()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), count)
+}
+
+func TestKeyValueQListKeysTable(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db, WithTable(KeyValueTable{Name: "cursors", KeyColumn: "name"}), WithTTL())
+
+	mock.ExpectQuery(`SELECT "name" FROM "cursors" WHERE (expires_at IS NULL OR expires_at > now()) ORDER BY "name" desc LIMIT 15 OFFSET 0`).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("b"))
+	keys, err := kvQ.ListKeys("", pgdb.OffsetPageParams{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, keys)
+
+	mock.ExpectQuery(`SELECT count(*) FROM "cursors" WHERE (expires_at IS NULL OR expires_at > now())`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	count, err := kvQ.Count()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+}
+
+func TestPageKeys(t *testing.T) {
+	keys := []string{"c", "a", "b"}
+	assert.Equal(t, []string{"a", "b"}, PageKeys(keys, pgdb.OffsetPageParams{Limit: 2, Order: pgdb.OrderTypeAsc}))
+	assert.Equal(t, []string{"a"}, PageKeys(keys, pgdb.OffsetPageParams{Limit: 2, PageNumber: 1}))
+	assert.Equal(t, []string{"c", "a", "b"}, keys, "keys must not be sorted in place")
+}
+
+func TestKeyValueQDeleteByPrefix(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db).(PrefixDeleter)
+
+	mock.ExpectQuery(`SELECT count(*) FROM key_value WHERE key LIKE $1 ESCAPE '\'`).
+		WithArgs(`streamer:old\_orders:%`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(`DELETE FROM key_value WHERE key LIKE $1 ESCAPE '\' RETURNING key`).
+		WithArgs(`streamer:old\_orders:%`).
+		WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("streamer:old_orders:cursor").AddRow("streamer:old_orders:size"))
+
+	count, err := kvQ.CountByPrefix("streamer:old_orders:")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	deleted, err := kvQ.DeleteByPrefix("streamer:old_orders:")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	_, err = kvQ.DeleteByPrefix("")
+	assert.True(t, Is(err, ErrEmptyPrefix), "unexpected error: %v", err)
+}
+
+func TestKeyValueQDeleteByPrefixPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db)
+	kvQ := NewKeyValueQ(db)
+
+	require.NoError(t, kvQ.UpsertMany([]KeyValue{
+		{Key: "streamer:a_b:cursor", Value: "1"},
+		{Key: "streamer:a_b:size", Value: "2"},
+		// the underscore of the prefix must not match any character
+		{Key: "streamer:axb:cursor", Value: "3"},
+		{Key: "streamer:a%b:cursor", Value: "4"},
+	}))
+
+	count, err := kvQ.(PrefixDeleter).CountByPrefix("streamer:a_b:")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	deleted, err := kvQ.(PrefixDeleter).DeleteByPrefix("streamer:a_b:")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	deleted, err = kvQ.(PrefixDeleter).DeleteByPrefix("streamer:a%")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	assert.Equal(t, "3", kvQ.MustGet("streamer:axb:cursor").Value)
+}
+
+func TestPageKeyValues(t *testing.T) {
+	values := []KeyValue{{Key: "b"}, {Key: "a"}, {Key: "c"}}
+
+	assert.Equal(t, []KeyValue{{Key: "a"}, {Key: "b"}},
+		PageKeyValues(values, pgdb.OffsetPageParams{Limit: 2, Order: pgdb.OrderTypeAsc}))
+	assert.Equal(t, []KeyValue{{Key: "c"}},
+		PageKeyValues(values, pgdb.OffsetPageParams{Limit: 2, PageNumber: 1, Order: pgdb.OrderTypeAsc}))
+	assert.Equal(t, []KeyValue{{Key: "c"}, {Key: "b"}, {Key: "a"}},
+		PageKeyValues(values, pgdb.OffsetPageParams{}), "order must default to descending")
+	assert.Empty(t, PageKeyValues(values, pgdb.OffsetPageParams{Limit: 2, PageNumber: 5}))
+	assert.Equal(t, []KeyValue{{Key: "b"}, {Key: "a"}, {Key: "c"}}, values, "values must not be reordered")
+}
@@ -0,0 +1,213 @@
+package dban
+
+import (
+	"strings"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+const (
+	// namespaceDelimiter separates the namespace of a prefixed querier from the keys within it
+	namespaceDelimiter = ":"
+	// countPageSize is the number of keys a prefixed querier lists at once to count them
+	countPageSize = 1000
+)
+
+type prefixedKeyValueQ struct {
+	inner KeyValueQ
+	// prefix is the namespace followed by the delimiter
+	prefix  string
+	filters KeyValueFilters
+}
+
+// WithPrefix creates a querier keeping its values within the namespace of prefix, so that
+// services sharing a table do not collide on generic keys. Keys are stored prefixed with
+// the prefix and ":", while the querier accepts and returns them without it. It panics if
+// prefix is empty
+func WithPrefix(inner KeyValueQ, prefix string) KeyValueQ {
+	if prefix == "" {
+		panic(errors.Wrap(ErrEmptyPrefix, "failed to create prefixed querier"))
+	}
+	return &prefixedKeyValueQ{inner: inner, prefix: prefix + namespaceDelimiter}
+}
+
+func (q *prefixedKeyValueQ) New() KeyValueQ {
+	return &prefixedKeyValueQ{inner: q.inner.New(), prefix: q.prefix}
+}
+
+// key returns the key stored in the inner querier
+func (q *prefixedKeyValueQ) key(key string) string {
+	return q.prefix + key
+}
+
+func (q *prefixedKeyValueQ) keys(keys []string) []string {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = q.key(key)
+	}
+	return prefixed
+}
+
+// strip returns kv with the key as the querier's caller knows it
+func (q *prefixedKeyValueQ) strip(kv *KeyValue) *KeyValue {
+	if kv == nil {
+		return nil
+	}
+	stripped := *kv
+	stripped.Key = strings.TrimPrefix(kv.Key, q.prefix)
+	return &stripped
+}
+
+func (q *prefixedKeyValueQ) Get(key string) (*KeyValue, error) {
+	kv, err := q.inner.Get(q.key(key))
+	return q.strip(kv), err
+}
+
+func (q *prefixedKeyValueQ) GetMany(keys []string) (map[string]KeyValue, error) {
+	values, err := q.inner.GetMany(q.keys(keys))
+	if err != nil {
+		return nil, err
+	}
+
+	stripped := make(map[string]KeyValue, len(values))
+	for _, kv := range values {
+		kv = *q.strip(&kv)
+		stripped[kv.Key] = kv
+	}
+	return stripped, nil
+}
+
+func (q *prefixedKeyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	values, err := q.inner.SelectByPrefix(q.key(prefix), params)
+	if err != nil {
+		return nil, err
+	}
+	for i := range values {
+		values[i] = *q.strip(&values[i])
+	}
+	return values, nil
+}
+
+func (q *prefixedKeyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	keys, err := q.inner.ListKeys(q.key(prefix), params)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, q.prefix)
+	}
+	return keys, nil
+}
+
+// Count pages through the keys of the namespace, as the inner querier counts the values
+// of all of them
+func (q *prefixedKeyValueQ) Count() (uint64, error) {
+	params := pgdb.OffsetPageParams{Limit: countPageSize, Order: pgdb.OrderTypeAsc}
+	var count uint64
+	for ; ; params.PageNumber++ {
+		keys, err := q.inner.ListKeys(q.prefix, params)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to count values", logan.F{"prefix": q.prefix})
+		}
+		count += uint64(len(keys))
+		if uint64(len(keys)) < params.Limit {
+			return count, nil
+		}
+	}
+}
+
+func (q *prefixedKeyValueQ) FilterByKeys(keys ...string) KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByKeys(keys...)
+	return &filtered
+}
+
+func (q *prefixedKeyValueQ) FilterByPrefix(prefix string) KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByKeyPrefix(prefix)
+	return &filtered
+}
+
+func (q *prefixedKeyValueQ) FilterByValueLike(pattern string) KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByValueLike(pattern)
+	return &filtered
+}
+
+// Select filters the values of the namespace in memory, as the keys in the inner storage
+// carry the prefix
+func (q *prefixedKeyValueQ) Select() ([]KeyValue, error) {
+	return SelectFiltered(q, q.filters, nil)
+}
+
+func (q *prefixedKeyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return SelectFiltered(q, q.filters, &params)
+}
+
+func (q *prefixedKeyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.SelectPage(KeyValueStreamParams(params))
+}
+
+func (q *prefixedKeyValueQ) MustGet(key string) *KeyValue {
+	value, err := GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+func (q *prefixedKeyValueQ) Upsert(kv KeyValue) error {
+	kv.Key = q.key(kv.Key)
+	return q.inner.Upsert(kv)
+}
+
+func (q *prefixedKeyValueQ) UpsertMany(kvs []KeyValue) error {
+	prefixed := make([]KeyValue, len(kvs))
+	for i, kv := range kvs {
+		prefixed[i] = KeyValue{Key: q.key(kv.Key), Value: kv.Value}
+	}
+	return q.inner.UpsertMany(prefixed)
+}
+
+func (q *prefixedKeyValueQ) LockingGet(key string) (*KeyValue, error) {
+	kv, err := q.inner.LockingGet(q.key(key))
+	return q.strip(kv), err
+}
+
+func (q *prefixedKeyValueQ) MustLockingGet(key string) *KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
+
+func (q *prefixedKeyValueQ) Delete(key string) error {
+	return q.inner.Delete(q.key(key))
+}
+
+func (q *prefixedKeyValueQ) DeleteMany(keys []string) (int64, error) {
+	return q.inner.DeleteMany(q.keys(keys))
+}
+
+func (q *prefixedKeyValueQ) Update(key string, fn func(current *string) (string, error)) (KeyValue, error) {
+	kv, err := q.inner.Update(q.key(key), fn)
+	if err != nil || kv.Key == "" {
+		return kv, err
+	}
+	return *q.strip(&kv), nil
+}
+
+func (q *prefixedKeyValueQ) Transaction(fn func(q KeyValueQ) error) error {
+	return transaction(q.inner, func(inner KeyValueQ) error {
+		tx := *q
+		tx.inner = inner
+		return fn(&tx)
+	})
+}
+
+func (q *prefixedKeyValueQ) WithinTx() bool {
+	return withinTx(q.inner)
+}
@@ -0,0 +1,60 @@
+package dban_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+func TestPrefixedKeyValueQ(t *testing.T) {
+	shared := dbantest.NewMemoryKeyValueQ()
+	foo := dban.WithPrefix(shared, "foo")
+	bar := dban.WithPrefix(shared, "bar")
+
+	require.NoError(t, foo.Upsert(dban.KeyValue{Key: "cursor", Value: "1"}))
+	require.NoError(t, bar.Upsert(dban.KeyValue{Key: "cursor", Value: "2"}))
+	require.NoError(t, foo.UpsertMany([]dban.KeyValue{{Key: "last_run", Value: "3"}}))
+
+	assert.Equal(t, &dban.KeyValue{Key: "cursor", Value: "1"}, foo.MustGet("cursor"))
+	assert.Equal(t, &dban.KeyValue{Key: "cursor", Value: "2"}, bar.New().MustGet("cursor"))
+	assert.Equal(t, "1", shared.MustGet("foo:cursor").Value, "keys are stored prefixed")
+
+	locked, err := foo.LockingGet("last_run")
+	require.NoError(t, err)
+	assert.Equal(t, &dban.KeyValue{Key: "last_run", Value: "3"}, locked)
+
+	values, err := foo.GetMany([]string{"cursor", "last_run", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]dban.KeyValue{
+		"cursor":   {Key: "cursor", Value: "1"},
+		"last_run": {Key: "last_run", Value: "3"},
+	}, values)
+
+	keys, err := foo.ListKeys("", pgdb.OffsetPageParams{Limit: 10, Order: pgdb.OrderTypeAsc})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cursor", "last_run"}, keys)
+	count, err := foo.Count()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+
+	deleted, err := foo.DeleteMany([]string{"cursor", "last_run"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	assert.Equal(t, "2", bar.MustGet("cursor").Value, "other namespaces are intact")
+
+	assert.Panics(t, func() { dban.WithPrefix(shared, "") })
+}
+
+func TestPrefixedKeyValueQComposition(t *testing.T) {
+	shared := dbantest.NewMemoryKeyValueQ()
+	kvQ := dban.NewCachedKeyValueQ(dban.WithPrefix(dban.NewRetryingKeyValueQ(shared, dban.RetryConfig{}), "foo"), time.Hour)
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "page", Value: "1"}))
+	assert.Equal(t, "1", kvQ.MustGet("page").Value)
+	assert.Equal(t, "1", shared.MustGet("foo:page").Value)
+}
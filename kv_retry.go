@@ -0,0 +1,58 @@
+package dban
+
+import (
+	"time"
+)
+
+// writeRetryBaseDelay is a delay before the first retry of a write or of a serializable
+// transaction, smaller than the default one of RetryConfig, as conflicts clear up quickly
+const writeRetryBaseDelay = 10 * time.Millisecond
+
+// WriteRetryHook is invoked right before the attempt-th retry of a write of the key
+// that failed with err
+type WriteRetryHook func(key string, attempt int, err error)
+
+// WithWriteRetries makes the querier retry writes failed with a transient error (see
+// IsTransient), such as a serialization failure or a deadlock, up to n times with the
+// jittered backoff of RetryConfig starting from 10ms. Writes are idempotent, so retrying
+// them is safe, but note that inside a transaction such failures abort the whole
+// transaction, so it is the transaction that has to be retried
+func WithWriteRetries(n int) KeyValueQOption {
+	return func(q *keyValueQ) {
+		q.writeRetry = RetryConfig{MaxAttempts: n + 1, BaseDelay: writeRetryBaseDelay}.withDefaults()
+	}
+}
+
+// WithWriteRetryHook sets a hook observing retries of writes, e.g. to log them. The
+// retries are counted by the key value counters anyway (see PublishExpvar)
+func WithWriteRetryHook(hook WriteRetryHook) KeyValueQOption {
+	return func(q *keyValueQ) {
+		q.writeRetryHook = hook
+	}
+}
+
+// retryWrite calls write until it succeeds, fails with an error that is not transient,
+// or the retries are exhausted. Writes within a transaction are not retried, as the
+// failure aborts the whole transaction. write is given the querier to write with (see
+// audited)
+func (q *keyValueQ) retryWrite(key string, write func(q *keyValueQ) error) error {
+	if q.WithinTx() {
+		return q.audited(write)
+	}
+	return q.writeRetry.retry(func() error {
+		return q.audited(write)
+	}, func(attempt int, err error) {
+		kvVars.Add(kvVarRetries, 1)
+		if q.writeRetryHook != nil {
+			q.writeRetryHook(key, attempt, err)
+		}
+	})
+}
+
+// isWriteConflict reports whether err is a serialization failure or a deadlock
+func isWriteConflict(err error) bool {
+	return walk(err, func(err error) bool {
+		state := sqlState(err)
+		return state == sqlStateSerializationFailure || state == sqlStateDeadlockDetected
+	})
+}
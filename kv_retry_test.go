@@ -0,0 +1,64 @@
+package dban
+
+import (
+	"expvar"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const upsertSQL = "INSERT INTO key_value (key,value) VALUES ($1,$2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value"
+
+func TestKeyValueQWriteRetries(t *testing.T) {
+	delays := noRetrySleep(t)
+
+	t.Run("conflicts", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		var attempts []int
+		kvQ := NewKeyValueQ(db, WithWriteRetries(3), WithWriteRetryHook(func(key string, attempt int, err error) {
+			assert.Equal(t, "cursor", key)
+			attempts = append(attempts, attempt)
+		}))
+
+		mock.ExpectExec(upsertSQL).WithArgs("cursor", "1").WillReturnError(&pq.Error{Code: "40001"})
+		mock.ExpectExec(upsertSQL).WithArgs("cursor", "1").WillReturnError(&pq.Error{Code: "40P01"})
+		mock.ExpectExec(upsertSQL).WithArgs("cursor", "1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		retries := func() int64 {
+			if v, ok := kvVars.Get(kvVarRetries).(*expvar.Int); ok {
+				return v.Value()
+			}
+			return 0
+		}
+		before := retries()
+		require.NoError(t, kvQ.Upsert(KeyValue{Key: "cursor", Value: "1"}))
+		assert.Equal(t, []int{1, 2}, attempts)
+		assert.Equal(t, before+2, retries(), "the retries must be counted")
+		require.Len(t, *delays, 2)
+		assert.Less(t, (*delays)[0], writeRetryBaseDelay, "writes back off from 10ms")
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		kvQ := NewKeyValueQ(db, WithWriteRetries(1))
+
+		mock.ExpectExec(upsertSQL).WillReturnError(&pq.Error{Code: "40001"})
+		mock.ExpectExec(upsertSQL).WillReturnError(&pq.Error{Code: "40001"})
+
+		err := kvQ.Upsert(KeyValue{Key: "cursor", Value: "1"})
+		assert.True(t, IsRetryable(err))
+	})
+
+	t.Run("not retryable", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		kvQ := NewKeyValueQ(db, WithWriteRetries(3))
+
+		mock.ExpectExec(upsertSQL).WillReturnError(&pq.Error{Code: "22001"})
+
+		err := kvQ.Upsert(KeyValue{Key: "cursor", Value: "1"})
+		assert.True(t, Is(err, ErrValueTooLarge))
+	})
+}
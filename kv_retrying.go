@@ -0,0 +1,279 @@
+package dban
+
+import (
+	"context"
+	"database/sql/driver"
+	stderrors "errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// RetryConfig configures retries of a retrying querier (see NewRetryingKeyValueQ) and of
+// the pages selected by a streamer (see StreamerInitParams.SelectRetry). Zero fields keep
+// the defaults
+type RetryConfig struct {
+	// MaxAttempts is the number of attempts of an operation including the first one, 3 by default
+	MaxAttempts int
+	// BaseDelay is a delay before the first retry, multiplied by Multiplier on every next
+	// one, 50ms by default
+	BaseDelay time.Duration
+	// Multiplier is the growth of the delay between retries, 2 by default. Values below 1
+	// keep the default
+	Multiplier float64
+	// MaxDelay caps the delay before a retry, 2s by default
+	MaxDelay time.Duration
+	// IsTransient tells whether an operation failed with err could be retried, IsTransient
+	// by default. Callers could extend the default one by calling it from their own
+	IsTransient func(err error) bool
+}
+
+const (
+	defaultRetryAttempts   = 3
+	defaultRetryBaseDelay  = 50 * time.Millisecond
+	defaultRetryMaxDelay   = 2 * time.Second
+	defaultRetryMultiplier = 2
+)
+
+// retrySleep waits before a retry, replaced in tests
+var retrySleep = time.Sleep
+
+func (c RetryConfig) withDefaults() RetryConfig {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = defaultRetryAttempts
+	}
+	if c.BaseDelay <= 0 {
+		c.BaseDelay = defaultRetryBaseDelay
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = defaultRetryMaxDelay
+	}
+	if c.Multiplier < 1 {
+		c.Multiplier = defaultRetryMultiplier
+	}
+	if c.IsTransient == nil {
+		c.IsTransient = IsTransient
+	}
+	return c
+}
+
+// delay returns a jittered delay before the attempt-th retry, in [d/2, d) of the backoff d
+func (c RetryConfig) delay(attempt int) time.Duration {
+	delay := c.MaxDelay
+	if backoff := float64(c.BaseDelay) * math.Pow(c.Multiplier, float64(attempt-1)); backoff < float64(c.MaxDelay) {
+		delay = time.Duration(backoff)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retry calls op until it succeeds, fails with an error that is not transient, or the
+// attempts are exhausted, calling onRetry, if set, before every retry
+func (c RetryConfig) retry(op func() error, onRetry func(attempt int, err error)) error {
+	err := op()
+	for attempt := 1; attempt < c.MaxAttempts && err != nil && c.IsTransient(err); attempt++ {
+		if onRetry != nil {
+			onRetry(attempt, err)
+		}
+		retrySleep(c.delay(attempt))
+		err = op()
+	}
+	return err
+}
+
+// IsTransient reports whether err is a failure of the connection to the database, a
+// serialization failure or a deadlock, which could go away once the operation is retried.
+// Unlike IsRetryable, it does not consider timeouts transient, and context errors never are
+func IsTransient(err error) bool {
+	if Is(err, context.Canceled) || Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return walk(err, func(err error) bool {
+		if err == driver.ErrBadConn || err == io.ErrUnexpectedEOF {
+			return true
+		}
+		var netErr net.Error
+		if stderrors.As(err, &netErr) {
+			return true
+		}
+		return isWriteConflict(err) || strings.HasPrefix(sqlState(err), sqlStateConnectionClass)
+	})
+}
+
+type retryingKeyValueQ struct {
+	inner KeyValueQ
+	cfg   RetryConfig
+}
+
+// NewRetryingKeyValueQ creates a querier retrying operations of inner failed with transient
+// errors (see RetryConfig.IsTransient) with an exponential jittered backoff. Operations are
+// idempotent, so retrying them is safe, although DeleteMany retried after a lost response
+// could count fewer deleted values. Operations within a transaction are not retried, as the
+// failure aborts it; the whole transaction is retried instead, so fn passed to Transaction
+// could be called several times
+func NewRetryingKeyValueQ(inner KeyValueQ, cfg RetryConfig) KeyValueQ {
+	return &retryingKeyValueQ{
+		inner: inner,
+		cfg:   cfg.withDefaults(),
+	}
+}
+
+func (q *retryingKeyValueQ) New() KeyValueQ {
+	return &retryingKeyValueQ{
+		inner: q.inner.New(),
+		cfg:   q.cfg,
+	}
+}
+
+// retry calls op until it succeeds, fails with an error that is not transient, or the
+// attempts are exhausted. op is called once within a transaction
+func retry[T any](q *retryingKeyValueQ, op func() (T, error)) (T, error) {
+	if withinTx(q.inner) {
+		return op()
+	}
+
+	var value T
+	err := q.cfg.retry(func() error {
+		var err error
+		value, err = op()
+		return err
+	}, nil)
+	return value, err
+}
+
+// retryErr is retry of an operation returning an error only
+func retryErr(q *retryingKeyValueQ, op func() error) error {
+	_, err := retry(q, func() (struct{}, error) {
+		return struct{}{}, op()
+	})
+	return err
+}
+
+func (q *retryingKeyValueQ) Get(key string) (*KeyValue, error) {
+	return retry(q, func() (*KeyValue, error) {
+		return q.inner.Get(key)
+	})
+}
+
+func (q *retryingKeyValueQ) GetMany(keys []string) (map[string]KeyValue, error) {
+	return retry(q, func() (map[string]KeyValue, error) {
+		return q.inner.GetMany(keys)
+	})
+}
+
+func (q *retryingKeyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return retry(q, func() ([]KeyValue, error) {
+		return q.inner.SelectByPrefix(prefix, params)
+	})
+}
+
+func (q *retryingKeyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	return retry(q, func() ([]string, error) {
+		return q.inner.ListKeys(prefix, params)
+	})
+}
+
+func (q *retryingKeyValueQ) Count() (uint64, error) {
+	return retry(q, q.inner.Count)
+}
+
+func (q *retryingKeyValueQ) FilterByKeys(keys ...string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByKeys(keys...)
+	return &filtered
+}
+
+func (q *retryingKeyValueQ) FilterByPrefix(prefix string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByPrefix(prefix)
+	return &filtered
+}
+
+func (q *retryingKeyValueQ) FilterByValueLike(pattern string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByValueLike(pattern)
+	return &filtered
+}
+
+func (q *retryingKeyValueQ) Select() ([]KeyValue, error) {
+	return retry(q, q.inner.Select)
+}
+
+func (q *retryingKeyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return retry(q, func() ([]KeyValue, error) {
+		return q.inner.SelectPage(params)
+	})
+}
+
+func (q *retryingKeyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.SelectPage(KeyValueStreamParams(params))
+}
+
+func (q *retryingKeyValueQ) MustGet(key string) *KeyValue {
+	value, err := GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+func (q *retryingKeyValueQ) Upsert(kv KeyValue) error {
+	return retryErr(q, func() error {
+		return q.inner.Upsert(kv)
+	})
+}
+
+func (q *retryingKeyValueQ) UpsertMany(kvs []KeyValue) error {
+	return retryErr(q, func() error {
+		return q.inner.UpsertMany(kvs)
+	})
+}
+
+func (q *retryingKeyValueQ) LockingGet(key string) (*KeyValue, error) {
+	return retry(q, func() (*KeyValue, error) {
+		return q.inner.LockingGet(key)
+	})
+}
+
+func (q *retryingKeyValueQ) MustLockingGet(key string) *KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
+
+func (q *retryingKeyValueQ) Delete(key string) error {
+	return retryErr(q, func() error {
+		return q.inner.Delete(key)
+	})
+}
+
+func (q *retryingKeyValueQ) DeleteMany(keys []string) (int64, error) {
+	return retry(q, func() (int64, error) {
+		return q.inner.DeleteMany(keys)
+	})
+}
+
+// Update is not retried, as retrying would call fn again (see KeyValueQ.Update)
+func (q *retryingKeyValueQ) Update(key string, fn func(current *string) (string, error)) (KeyValue, error) {
+	return q.inner.Update(key, fn)
+}
+
+func (q *retryingKeyValueQ) Transaction(fn func(q KeyValueQ) error) error {
+	return retryErr(q, func() error {
+		return transaction(q.inner, func(inner KeyValueQ) error {
+			return fn(&retryingKeyValueQ{inner: inner, cfg: q.cfg})
+		})
+	})
+}
+
+func (q *retryingKeyValueQ) WithinTx() bool {
+	return withinTx(q.inner)
+}
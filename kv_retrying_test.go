@@ -0,0 +1,155 @@
+package dban
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// flakyKeyValueQ fails upserts with the errors in order, then succeeds
+type flakyKeyValueQ struct {
+	KeyValueQ
+	errs    []error
+	upserts int
+	tx      bool
+}
+
+func (q *flakyKeyValueQ) Upsert(KeyValue) error {
+	q.upserts++
+	if len(q.errs) == 0 {
+		return nil
+	}
+	err := q.errs[0]
+	q.errs = q.errs[1:]
+	return err
+}
+
+func (q *flakyKeyValueQ) Update(key string, fn func(current *string) (string, error)) (KeyValue, error) {
+	next, err := fn(nil)
+	if err != nil {
+		return KeyValue{}, err
+	}
+	return KeyValue{Key: key, Value: next}, q.Upsert(KeyValue{Key: key, Value: next})
+}
+
+func (q *flakyKeyValueQ) Transaction(fn func(q KeyValueQ) error) error {
+	tx := *q
+	tx.tx = true
+	err := fn(&tx)
+	q.errs, q.upserts = tx.errs, tx.upserts
+	return err
+}
+
+func (q *flakyKeyValueQ) WithinTx() bool {
+	return q.tx
+}
+
+func noRetrySleep(t *testing.T) *[]time.Duration {
+	var delays []time.Duration
+	sleep := retrySleep
+	retrySleep = func(d time.Duration) { delays = append(delays, d) }
+	t.Cleanup(func() { retrySleep = sleep })
+	return &delays
+}
+
+func TestIsTransient(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{name: "bad conn", err: driver.ErrBadConn, transient: true},
+		{name: "serialization", err: &pq.Error{Code: "40001"}, transient: true},
+		{name: "deadlock", err: classifyPostgres(&pq.Error{Code: "40P01"}), transient: true},
+		{name: "connection", err: classifyPostgres(&pq.Error{Code: "08006"}), transient: true},
+		{name: "unique violation", err: classifyPostgres(&pq.Error{Code: "23505"})},
+		{name: "syntax error", err: &pq.Error{Code: "42601"}},
+		{name: "statement timeout", err: classifyPostgres(&pq.Error{Code: "57014"})},
+		{name: "canceled", err: &kindError{kind: context.Canceled, err: &pq.Error{Code: "08006"}}},
+		{name: "plain", err: errors.New("plain")},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.transient, IsTransient(errors.Wrap(tc.err, "failed to upsert value")))
+		})
+	}
+	assert.False(t, IsTransient(nil))
+}
+
+func TestRetryingKeyValueQ(t *testing.T) {
+	delays := noRetrySleep(t)
+
+	inner := &flakyKeyValueQ{errs: []error{driver.ErrBadConn, &pq.Error{Code: "40001"}}}
+	kvQ := NewRetryingKeyValueQ(inner, RetryConfig{MaxAttempts: 3, BaseDelay: 100 * time.Millisecond})
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "a", Value: "1"}))
+	assert.Equal(t, 3, inner.upserts)
+	require.Len(t, *delays, 2)
+	assert.GreaterOrEqual(t, (*delays)[0], 50*time.Millisecond)
+	assert.LessOrEqual(t, (*delays)[0], 100*time.Millisecond)
+	assert.GreaterOrEqual(t, (*delays)[1], 100*time.Millisecond, "the backoff doubles")
+
+	t.Run("attempts exhausted", func(t *testing.T) {
+		inner := &flakyKeyValueQ{errs: []error{driver.ErrBadConn, driver.ErrBadConn, driver.ErrBadConn}}
+		err := NewRetryingKeyValueQ(inner, RetryConfig{MaxAttempts: 2}).Upsert(KeyValue{Key: "a"})
+		assert.Equal(t, driver.ErrBadConn, err)
+		assert.Equal(t, 2, inner.upserts)
+	})
+
+	t.Run("not transient", func(t *testing.T) {
+		inner := &flakyKeyValueQ{errs: []error{&pq.Error{Code: "23505"}}}
+		assert.Error(t, NewRetryingKeyValueQ(inner, RetryConfig{}).Upsert(KeyValue{Key: "a"}))
+		assert.Equal(t, 1, inner.upserts)
+	})
+
+	t.Run("custom classification", func(t *testing.T) {
+		busy := errors.New("pooler is busy")
+		inner := &flakyKeyValueQ{errs: []error{busy}}
+		kvQ := NewRetryingKeyValueQ(inner, RetryConfig{IsTransient: func(err error) bool {
+			return Is(err, busy) || IsTransient(err)
+		}})
+		require.NoError(t, kvQ.Upsert(KeyValue{Key: "a"}))
+		assert.Equal(t, 2, inner.upserts)
+	})
+
+	t.Run("transaction", func(t *testing.T) {
+		inner := &flakyKeyValueQ{errs: []error{&pq.Error{Code: "40P01"}}}
+		kvQ := NewRetryingKeyValueQ(inner, RetryConfig{})
+		runs := 0
+		err := kvQ.(TransactionalKeyValueQ).Transaction(func(q KeyValueQ) error {
+			runs++
+			return q.Upsert(KeyValue{Key: "a"})
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, runs, "the whole transaction is retried")
+		assert.Equal(t, 2, inner.upserts, "statements within the transaction are not retried")
+	})
+
+	t.Run("update", func(t *testing.T) {
+		inner := &flakyKeyValueQ{errs: []error{&pq.Error{Code: "40P01"}}}
+		kvQ := NewRetryingKeyValueQ(inner, RetryConfig{})
+		calls := 0
+		_, err := kvQ.Update("a", func(*string) (string, error) {
+			calls++
+			return "1", nil
+		})
+		assert.True(t, IsTransient(err), "the failure is returned to the caller")
+		assert.Equal(t, 1, calls, "fn must not be retried")
+	})
+}
+
+func TestRetryConfigMultiplier(t *testing.T) {
+	cfg := RetryConfig{BaseDelay: 100 * time.Millisecond, Multiplier: 3, MaxDelay: time.Second}.withDefaults()
+	for attempt, backoff := range []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second} {
+		delay := cfg.delay(attempt + 1)
+		assert.GreaterOrEqual(t, delay, backoff/2)
+		assert.LessOrEqual(t, delay, backoff)
+	}
+	assert.Equal(t, float64(defaultRetryMultiplier), RetryConfig{}.withDefaults().Multiplier)
+}
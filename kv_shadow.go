@@ -0,0 +1,256 @@
+package dban
+
+import (
+	"sync/atomic"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// ShadowKeyValueQ is a key value querier keeping two storages in sync while cursors are
+// moved from one of them to another
+type ShadowKeyValueQ interface {
+	TransactionalKeyValueQ
+	// Diverged returns those of keys whose values in the primary and the shadow storages differ
+	Diverged(keys []string) ([]string, error)
+	// ShadowFailures returns the number of writes that failed on the shadow storage
+	ShadowFailures() uint64
+}
+
+// ShadowOption is an optional parameter of a shadow querier
+type ShadowOption func(*shadowKeyValueQ)
+
+// WithReadsFromShadow makes the shadow querier serve reads from the shadow storage,
+// completing the cutover. Writes keep going to the primary storage first
+func WithReadsFromShadow() ShadowOption {
+	return func(q *shadowKeyValueQ) {
+		q.readFromShadow = true
+	}
+}
+
+type shadowKeyValueQ struct {
+	primary        KeyValueQ
+	shadow         KeyValueQ
+	log            *logan.Entry
+	readFromShadow bool
+	failures       *uint64
+}
+
+// NewShadowKeyValueQ creates a querier serving reads from primary and applying every
+// successful write to shadow as well. Failures of shadow writes are logged and counted,
+// but not returned. Log could be omitted (in that case, failures wouldn't be logged)
+func NewShadowKeyValueQ(primary, shadow KeyValueQ, log *logan.Entry, opts ...ShadowOption) ShadowKeyValueQ {
+	q := &shadowKeyValueQ{
+		primary:  primary,
+		shadow:   shadow,
+		log:      log,
+		failures: new(uint64),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	return q
+}
+
+func (q *shadowKeyValueQ) New() KeyValueQ {
+	return &shadowKeyValueQ{
+		primary:        q.primary.New(),
+		shadow:         q.shadow.New(),
+		log:            q.log,
+		readFromShadow: q.readFromShadow,
+		failures:       q.failures,
+	}
+}
+
+func (q *shadowKeyValueQ) reads() KeyValueQ {
+	if q.readFromShadow {
+		return q.shadow
+	}
+	return q.primary
+}
+
+func (q *shadowKeyValueQ) Get(key string) (*KeyValue, error) {
+	return q.reads().Get(key)
+}
+
+func (q *shadowKeyValueQ) GetMany(keys []string) (map[string]KeyValue, error) {
+	return q.reads().GetMany(keys)
+}
+
+func (q *shadowKeyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.reads().SelectByPrefix(prefix, params)
+}
+
+func (q *shadowKeyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	return q.reads().ListKeys(prefix, params)
+}
+
+func (q *shadowKeyValueQ) Count() (uint64, error) {
+	return q.reads().Count()
+}
+
+func (q *shadowKeyValueQ) FilterByKeys(keys ...string) KeyValueQ {
+	filtered := *q
+	filtered.primary = q.primary.FilterByKeys(keys...)
+	filtered.shadow = q.shadow.FilterByKeys(keys...)
+	return &filtered
+}
+
+func (q *shadowKeyValueQ) FilterByPrefix(prefix string) KeyValueQ {
+	filtered := *q
+	filtered.primary = q.primary.FilterByPrefix(prefix)
+	filtered.shadow = q.shadow.FilterByPrefix(prefix)
+	return &filtered
+}
+
+func (q *shadowKeyValueQ) FilterByValueLike(pattern string) KeyValueQ {
+	filtered := *q
+	filtered.primary = q.primary.FilterByValueLike(pattern)
+	filtered.shadow = q.shadow.FilterByValueLike(pattern)
+	return &filtered
+}
+
+func (q *shadowKeyValueQ) Select() ([]KeyValue, error) {
+	return q.reads().Select()
+}
+
+func (q *shadowKeyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.reads().SelectPage(params)
+}
+
+func (q *shadowKeyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.SelectPage(KeyValueStreamParams(params))
+}
+
+func (q *shadowKeyValueQ) MustGet(key string) *KeyValue {
+	value, err := GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+func (q *shadowKeyValueQ) Upsert(kv KeyValue) error {
+	if err := q.primary.Upsert(kv); err != nil {
+		return err
+	}
+
+	if err := q.shadow.Upsert(kv); err != nil {
+		atomic.AddUint64(q.failures, 1)
+		if q.log != nil {
+			q.log.WithError(err).WithField("key", kv.Key).Warn("Failed to write value to the shadow storage")
+		}
+	}
+	return nil
+}
+
+func (q *shadowKeyValueQ) UpsertMany(kvs []KeyValue) error {
+	if err := q.primary.UpsertMany(kvs); err != nil {
+		return err
+	}
+
+	if err := q.shadow.UpsertMany(kvs); err != nil {
+		atomic.AddUint64(q.failures, 1)
+		if q.log != nil {
+			q.log.WithError(err).WithField("count", len(kvs)).Warn("Failed to write values to the shadow storage")
+		}
+	}
+	return nil
+}
+
+func (q *shadowKeyValueQ) Delete(key string) error {
+	if err := q.primary.Delete(key); err != nil {
+		return err
+	}
+
+	if err := q.shadow.Delete(key); err != nil {
+		atomic.AddUint64(q.failures, 1)
+		if q.log != nil {
+			q.log.WithError(err).WithField("key", key).Warn("Failed to delete value from the shadow storage")
+		}
+	}
+	return nil
+}
+
+func (q *shadowKeyValueQ) DeleteMany(keys []string) (int64, error) {
+	deleted, err := q.primary.DeleteMany(keys)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := q.shadow.DeleteMany(keys); err != nil {
+		atomic.AddUint64(q.failures, 1)
+		if q.log != nil {
+			q.log.WithError(err).WithField("keys", keys).Warn("Failed to delete values from the shadow storage")
+		}
+	}
+	return deleted, nil
+}
+
+// Update updates the value of the primary storage and writes the result to the shadow one
+func (q *shadowKeyValueQ) Update(key string, fn func(current *string) (string, error)) (KeyValue, error) {
+	kv, err := q.primary.Update(key, fn)
+	if err != nil || kv.Key == "" {
+		return kv, err
+	}
+
+	if err := q.shadow.Upsert(kv); err != nil {
+		atomic.AddUint64(q.failures, 1)
+		if q.log != nil {
+			q.log.WithError(err).WithField("key", key).Warn("Failed to write value to the shadow storage")
+		}
+	}
+	return kv, nil
+}
+
+func (q *shadowKeyValueQ) LockingGet(key string) (*KeyValue, error) {
+	return q.reads().LockingGet(key)
+}
+
+func (q *shadowKeyValueQ) MustLockingGet(key string) *KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
+
+func (q *shadowKeyValueQ) Diverged(keys []string) ([]string, error) {
+	var diverged []string
+	for _, key := range keys {
+		primary, err := q.primary.Get(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get value from the primary storage", logan.F{"key": key})
+		}
+		shadow, err := q.shadow.Get(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get value from the shadow storage", logan.F{"key": key})
+		}
+
+		if (primary == nil) != (shadow == nil) || (primary != nil && primary.Value != shadow.Value) {
+			diverged = append(diverged, key)
+		}
+	}
+
+	return diverged, nil
+}
+
+func (q *shadowKeyValueQ) ShadowFailures() uint64 {
+	return atomic.LoadUint64(q.failures)
+}
+
+// Transaction runs fn in a transaction of the primary querier, while shadow writes are
+// applied right away
+func (q *shadowKeyValueQ) Transaction(fn func(q KeyValueQ) error) error {
+	return transaction(q.primary, func(primary KeyValueQ) error {
+		tx := *q
+		tx.primary = primary
+		return fn(&tx)
+	})
+}
+
+func (q *shadowKeyValueQ) WithinTx() bool {
+	return withinTx(q.primary)
+}
@@ -0,0 +1,57 @@
+package dban_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+// failingKeyValueQ fails all writes while failing is set
+type failingKeyValueQ struct {
+	dban.KeyValueQ
+	failing bool
+}
+
+func (q *failingKeyValueQ) New() dban.KeyValueQ {
+	return q
+}
+
+func (q *failingKeyValueQ) Upsert(kv dban.KeyValue) error {
+	if q.failing {
+		return errors.New("storage is unavailable")
+	}
+	return q.KeyValueQ.Upsert(kv)
+}
+
+func TestShadowKeyValueQ(t *testing.T) {
+	primary := dbantest.NewMemoryKeyValueQ()
+	shadow := &failingKeyValueQ{KeyValueQ: dbantest.NewMemoryKeyValueQ()}
+	kvQ := dban.NewShadowKeyValueQ(primary, shadow, nil)
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "a", Value: "1"}))
+	assert.Equal(t, "1", shadow.MustGet("a").Value, "writes are applied to both storages")
+
+	shadow.failing = true
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "b", Value: "2"}), "shadow failures are not fatal")
+	require.NoError(t, kvQ.New().Upsert(dban.KeyValue{Key: "a", Value: "3"}))
+	assert.Equal(t, uint64(2), kvQ.ShadowFailures())
+	assert.Equal(t, "3", kvQ.MustGet("a").Value)
+
+	diverged, err := kvQ.Diverged([]string{"a", "b", "c"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, diverged)
+
+	shadow.failing = false
+	cutover := dban.NewShadowKeyValueQ(primary, shadow, nil, dban.WithReadsFromShadow())
+	assert.Nil(t, cutover.MustLockingGet("b"), "reads are served from the shadow storage")
+	require.NoError(t, cutover.Upsert(dban.KeyValue{Key: "b", Value: "2"}))
+	require.NoError(t, cutover.Upsert(dban.KeyValue{Key: "a", Value: "3"}))
+
+	diverged, err = cutover.Diverged([]string{"a", "b", "c"})
+	require.NoError(t, err)
+	assert.Empty(t, diverged)
+}
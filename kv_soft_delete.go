@@ -0,0 +1,163 @@
+package dban
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// SoftDeleter is a key value querier able to delete values reversibly, so that a cursor
+// deleted by mistake could be restored with its progress. Soft deleted values are read as
+// missing, while their rows are kept until they are purged. Writing a soft deleted key
+// revives it with the new value, as the key is free from the point of view of a reader,
+// and a write keeping the old row hidden would be lost silently. Delete and DeleteMany
+// still delete values permanently
+type SoftDeleter interface {
+	// SoftDeletes reports whether the querier soft deletes, i.e. it was created
+	// WithSoftDelete
+	SoftDeletes() bool
+	// SoftDelete soft deletes the value by the key. Soft deleting a missing key is not an error
+	SoftDelete(key string) error
+	// SoftDeleteMany soft deletes the values by the keys and returns the number of values
+	// actually soft deleted
+	SoftDeleteMany(keys []string) (int64, error)
+	// Restore restores the soft deleted value by the key. Restoring a value that was not
+	// deleted is a no-op, while a missing key fails with ErrNoSuchKey
+	Restore(key string) error
+	// WithDeleted returns a copy of the querier whose reads include soft deleted values
+	WithDeleted() KeyValueQ
+	// PurgeDeleted permanently deletes the values soft deleted more than retention ago and
+	// returns the number of them
+	PurgeDeleted(retention time.Duration) (int64, error)
+}
+
+var _ SoftDeleter = (*keyValueQ)(nil)
+
+const deletedAtColumn = "deleted_at"
+
+// WithSoftDelete makes the querier support SoftDeleter: reads treat soft deleted values
+// as missing and writes revive them, Increment and AdvanceCursor counting from 0 as they do
+// for missing values. Requires FeatureSoftDelete
+func WithSoftDelete() KeyValueQOption {
+	return func(q *keyValueQ) {
+		q.softDelete = true
+	}
+}
+
+func (q *keyValueQ) SoftDeletes() bool {
+	return q.softDelete
+}
+
+// checkSoftDelete fails the soft delete operations of a querier that does not soft delete
+func (q *keyValueQ) checkSoftDelete(fields logan.F) error {
+	if !q.softDelete {
+		return errors.From(errors.New("querier does not support soft delete, see WithSoftDelete"), fields)
+	}
+	return nil
+}
+
+func (q *keyValueQ) SoftDelete(key string) error {
+	_, err := q.SoftDeleteMany([]string{key})
+	return err
+}
+
+func (q *keyValueQ) SoftDeleteMany(keys []string) (int64, error) {
+	fields := logan.F{"keys": keys}
+	if err := q.checkSoftDelete(fields); err != nil {
+		return 0, err
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	var batchErr BatchError
+	for i, key := range keys {
+		if err := q.validateKey(key); err != nil {
+			batchErr.Errors = append(batchErr.Errors, KeyError{Key: key, Index: i, Err: err})
+		}
+	}
+	if len(batchErr.Errors) != 0 {
+		return 0, &batchErr
+	}
+
+	var deleted []string
+	err := q.retryWrite(keys[0], func(q *keyValueQ) error {
+		deleted = deleted[:0]
+		return q.db.SelectRawContext(q.ctx, &deleted, q.queries.softDelete, pq.Array(keys))
+	})
+	countKV(kvVarDelete, err)
+	if err != nil {
+		return 0, errors.Wrap(q.classify(err), "failed to soft delete values", fields)
+	}
+
+	if q.events != nil {
+		for _, key := range deleted {
+			q.events.Emit(KVDeleted{Key: key})
+		}
+	}
+	return int64(len(deleted)), nil
+}
+
+func (q *keyValueQ) Restore(key string) error {
+	fields := logan.F{"key": key}
+	if err := q.checkSoftDelete(fields); err != nil {
+		return err
+	}
+	if err := q.validateKey(key); err != nil {
+		return err
+	}
+
+	var restored bool
+	err := q.retryWrite(key, func(q *keyValueQ) (err error) {
+		restored, err = q.execAffecting(q.queries.restore, key)
+		return err
+	})
+	countKV(kvVarUpsert, err)
+	if err != nil {
+		return errors.Wrap(q.classify(err), "failed to restore value", fields)
+	}
+	if !restored {
+		return errors.From(ErrNoSuchKey, fields)
+	}
+
+	if q.events != nil {
+		q.events.Emit(KVWritten{Key: key})
+	}
+	return nil
+}
+
+func (q *keyValueQ) WithDeleted() KeyValueQ {
+	withDeleted := *q
+	withDeleted.withDeleted = true
+	return &withDeleted
+}
+
+func (q *keyValueQ) PurgeDeleted(retention time.Duration) (int64, error) {
+	fields := logan.F{"retention": retention}
+	if err := q.checkSoftDelete(fields); err != nil {
+		return 0, err
+	}
+	if retention < 0 {
+		return 0, errors.From(errors.New("retention must not be negative"), fields)
+	}
+
+	var result sql.Result
+	err := q.audited(func(q *keyValueQ) (err error) {
+		result, err = q.db.ExecWithResultContext(q.ctx, squirrel.Expr(q.queries.purgeDeleted, retention.Seconds()))
+		return err
+	})
+	countKV(kvVarDelete, err)
+	if err != nil {
+		return 0, errors.Wrap(q.classify(err), "failed to purge soft deleted values", fields)
+	}
+
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get number of purged values", fields)
+	}
+	return purged, nil
+}
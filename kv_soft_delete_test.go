@@ -0,0 +1,131 @@
+package dban
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+func TestKeyValueQSoftDelete(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db, WithSoftDelete())
+	deleter := kvQ.(SoftDeleter)
+	assert.True(t, deleter.SoftDeletes())
+
+	mock.ExpectQuery("UPDATE key_value SET deleted_at = now() WHERE key = ANY($1) AND deleted_at IS NULL RETURNING key").
+		WithArgs(pq.Array([]string{"cursor"})).
+		WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("cursor"))
+	require.NoError(t, deleter.SoftDelete("cursor"))
+
+	mock.ExpectQuery("SELECT key, value FROM key_value WHERE key = $1 AND deleted_at IS NULL").
+		WithArgs("cursor").
+		WillReturnError(sql.ErrNoRows)
+	kv, err := kvQ.Get("cursor")
+	require.NoError(t, err)
+	assert.Nil(t, kv, "soft deleted values must be read as missing")
+
+	mock.ExpectQuery("SELECT key, value FROM key_value WHERE key = $1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs("cursor").
+		WillReturnError(sql.ErrNoRows)
+	kv, err = NewKeyValueQ(withMockTx(db), WithSoftDelete()).LockingGet("cursor")
+	require.NoError(t, err)
+	assert.Nil(t, kv)
+
+	mock.ExpectQuery("SELECT key, value FROM key_value WHERE deleted_at IS NULL ORDER BY key asc LIMIT 10 OFFSET 0").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}))
+	values, err := kvQ.SelectWithPageParams(pgdb.OffsetPageParams{Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, values)
+
+	mock.ExpectQuery("SELECT key, value FROM key_value WHERE key = $1").
+		WithArgs("cursor").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("cursor", "10"))
+	kv, err = deleter.WithDeleted().Get("cursor")
+	require.NoError(t, err)
+	assert.Equal(t, &KeyValue{Key: "cursor", Value: "10"}, kv)
+
+	mock.ExpectExec("UPDATE key_value SET deleted_at = NULL WHERE key = $1").
+		WithArgs("cursor").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, deleter.Restore("cursor"))
+	mock.ExpectExec("UPDATE key_value SET deleted_at = NULL WHERE key = $1").
+		WithArgs("missing").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.True(t, Is(deleter.Restore("missing"), ErrNoSuchKey))
+
+	mock.ExpectExec("INSERT INTO key_value (key,value) VALUES ($1,$2) "+
+		"ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, deleted_at = NULL").
+		WithArgs("cursor", "11").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "cursor", Value: "11"}), "writes must revive soft deleted values")
+
+	mock.ExpectQuery("INSERT INTO key_value (key,value) VALUES ($1,$2) ON CONFLICT (key) DO UPDATE SET value = "+
+		"(CASE WHEN key_value.deleted_at IS NOT NULL THEN 0 ELSE key_value.value::bigint END + $3)::text, "+
+		"deleted_at = NULL RETURNING value::bigint").
+		WithArgs("counter", "2", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(2))
+	counted, err := kvQ.(AtomicCounter).IncrementAndGet("counter", 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), counted, "soft deleted values must be counted from 0")
+
+	mock.ExpectExec("DELETE FROM key_value WHERE deleted_at <= now() - $1 * interval '1 second'").
+		WithArgs(float64(86400)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	purged, err := deleter.PurgeDeleted(24 * time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	disabled := NewKeyValueQ(db).(SoftDeleter)
+	assert.False(t, disabled.SoftDeletes())
+	assert.Error(t, disabled.SoftDelete("cursor"), "soft delete must be enabled explicitly, as it requires FeatureSoftDelete")
+}
+
+func TestKeyValueQSoftDeletePostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db, WithFeatures(FeatureSoftDelete))
+	kvQ := NewKeyValueQ(db, WithSoftDelete())
+	deleter := kvQ.(SoftDeleter)
+
+	require.NoError(t, kvQ.UpsertMany([]KeyValue{{Key: "cursor", Value: "10"}, {Key: "other", Value: "1"}}))
+	deleted, err := deleter.SoftDeleteMany([]string{"cursor", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	values, err := kvQ.GetMany([]string{"cursor", "other"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]KeyValue{"other": {Key: "other", Value: "1"}}, values)
+	kv, err := deleter.WithDeleted().Get("cursor")
+	require.NoError(t, err)
+	assert.Equal(t, &KeyValue{Key: "cursor", Value: "10"}, kv)
+
+	require.NoError(t, deleter.Restore("cursor"))
+	assert.Equal(t, "10", kvQ.MustGet("cursor").Value, "restored values must keep their progress")
+
+	require.NoError(t, deleter.SoftDelete("cursor"))
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "cursor", Value: "1"}))
+	assert.Equal(t, "1", kvQ.MustGet("cursor").Value, "writes must revive soft deleted values")
+
+	require.NoError(t, deleter.SoftDelete("cursor"))
+	previous, err := kvQ.(CursorAdvancer).AdvanceCursor("cursor", 2)
+	require.NoError(t, err)
+	assert.Zero(t, previous, "soft deleted cursors must be advanced from 0")
+	counted, err := kvQ.(AtomicCounter).IncrementAndGet("cursor", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), counted)
+	assert.Equal(t, "3", kvQ.MustGet("cursor").Value, "advancing must revive soft deleted values")
+
+	require.NoError(t, deleter.SoftDelete("other"))
+	purged, err := deleter.PurgeDeleted(time.Hour)
+	require.NoError(t, err)
+	assert.Zero(t, purged, "values soft deleted within the retention must be kept")
+	purged, err = deleter.PurgeDeleted(0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	assert.True(t, Is(deleter.Restore("other"), ErrNoSuchKey))
+}
@@ -0,0 +1,181 @@
+package dban
+
+import (
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// SplitOption is an optional parameter of a split querier
+type SplitOption func(*splitKeyValueQ)
+
+// WithPrimaryReadsAfterWrite makes the split querier serve reads from the primary storage
+// for the period after each write, so that the writes are read back despite the lag of
+// the replica. Streamers must use it, as they cannot read a stale cursor
+func WithPrimaryReadsAfterWrite(period time.Duration) SplitOption {
+	return func(q *splitKeyValueQ) {
+		q.stickiness = period
+	}
+}
+
+type splitKeyValueQ struct {
+	primary    KeyValueQ
+	replica    KeyValueQ
+	stickiness time.Duration
+	// lastWrite is the time of the last write in nanoseconds, shared by the clones
+	lastWrite *int64
+}
+
+// NewSplitKeyValueQ creates a querier serving non-locking reads from replica and sending
+// writes and LockingGet, which is meaningless on a replica, to primary. Within a
+// transaction of the primary storage all reads are served from it
+func NewSplitKeyValueQ(primary, replica KeyValueQ, opts ...SplitOption) KeyValueQ {
+	q := &splitKeyValueQ{
+		primary:   primary,
+		replica:   replica,
+		lastWrite: new(int64),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	return q
+}
+
+func (q *splitKeyValueQ) New() KeyValueQ {
+	return &splitKeyValueQ{
+		primary:    q.primary.New(),
+		replica:    q.replica.New(),
+		stickiness: q.stickiness,
+		lastWrite:  q.lastWrite,
+	}
+}
+
+func (q *splitKeyValueQ) reads() KeyValueQ {
+	if withinTx(q.primary) {
+		return q.primary
+	}
+	if q.stickiness > 0 && time.Since(time.Unix(0, atomic.LoadInt64(q.lastWrite))) < q.stickiness {
+		return q.primary
+	}
+	return q.replica
+}
+
+// written records the time of a write, if it succeeded
+func (q *splitKeyValueQ) written(err error) error {
+	if err == nil {
+		atomic.StoreInt64(q.lastWrite, time.Now().UnixNano())
+	}
+	return err
+}
+
+func (q *splitKeyValueQ) Get(key string) (*KeyValue, error) {
+	return q.reads().Get(key)
+}
+
+func (q *splitKeyValueQ) GetMany(keys []string) (map[string]KeyValue, error) {
+	return q.reads().GetMany(keys)
+}
+
+func (q *splitKeyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.reads().SelectByPrefix(prefix, params)
+}
+
+func (q *splitKeyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	return q.reads().ListKeys(prefix, params)
+}
+
+func (q *splitKeyValueQ) Count() (uint64, error) {
+	return q.reads().Count()
+}
+
+func (q *splitKeyValueQ) FilterByKeys(keys ...string) KeyValueQ {
+	filtered := *q
+	filtered.primary = q.primary.FilterByKeys(keys...)
+	filtered.replica = q.replica.FilterByKeys(keys...)
+	return &filtered
+}
+
+func (q *splitKeyValueQ) FilterByPrefix(prefix string) KeyValueQ {
+	filtered := *q
+	filtered.primary = q.primary.FilterByPrefix(prefix)
+	filtered.replica = q.replica.FilterByPrefix(prefix)
+	return &filtered
+}
+
+func (q *splitKeyValueQ) FilterByValueLike(pattern string) KeyValueQ {
+	filtered := *q
+	filtered.primary = q.primary.FilterByValueLike(pattern)
+	filtered.replica = q.replica.FilterByValueLike(pattern)
+	return &filtered
+}
+
+func (q *splitKeyValueQ) Select() ([]KeyValue, error) {
+	return q.reads().Select()
+}
+
+func (q *splitKeyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.reads().SelectPage(params)
+}
+
+func (q *splitKeyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.SelectPage(KeyValueStreamParams(params))
+}
+
+func (q *splitKeyValueQ) MustGet(key string) *KeyValue {
+	value, err := GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+func (q *splitKeyValueQ) Upsert(kv KeyValue) error {
+	return q.written(q.primary.Upsert(kv))
+}
+
+func (q *splitKeyValueQ) UpsertMany(kvs []KeyValue) error {
+	return q.written(q.primary.UpsertMany(kvs))
+}
+
+func (q *splitKeyValueQ) Delete(key string) error {
+	return q.written(q.primary.Delete(key))
+}
+
+func (q *splitKeyValueQ) DeleteMany(keys []string) (int64, error) {
+	deleted, err := q.primary.DeleteMany(keys)
+	return deleted, q.written(err)
+}
+
+func (q *splitKeyValueQ) Update(key string, fn func(current *string) (string, error)) (KeyValue, error) {
+	kv, err := q.primary.Update(key, fn)
+	return kv, q.written(err)
+}
+
+func (q *splitKeyValueQ) LockingGet(key string) (*KeyValue, error) {
+	return q.primary.LockingGet(key)
+}
+
+func (q *splitKeyValueQ) MustLockingGet(key string) *KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
+
+// Transaction runs fn in a transaction of the primary querier
+func (q *splitKeyValueQ) Transaction(fn func(q KeyValueQ) error) error {
+	return transaction(q.primary, func(primary KeyValueQ) error {
+		tx := *q
+		tx.primary = primary
+		return fn(&tx)
+	})
+}
+
+func (q *splitKeyValueQ) WithinTx() bool {
+	return withinTx(q.primary)
+}
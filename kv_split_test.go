@@ -0,0 +1,48 @@
+package dban_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestSplitKeyValueQ(t *testing.T) {
+	primary := dbantest.NewMemoryKeyValueQ()
+	replica := dbantest.NewMemoryKeyValueQ()
+	kvQ := dban.NewSplitKeyValueQ(primary, replica)
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "a", Value: "1"}))
+	assert.Equal(t, "1", primary.MustGet("a").Value, "writes go to the primary storage")
+
+	value, err := kvQ.New().Get("a")
+	require.NoError(t, err)
+	assert.Nil(t, value, "reads are served from the replica, which has not caught up yet")
+	assert.Equal(t, "1", kvQ.MustLockingGet("a").Value, "locking reads are served from the primary storage")
+
+	require.NoError(t, replica.Upsert(dban.KeyValue{Key: "a", Value: "1"}))
+	assert.Equal(t, "1", kvQ.MustGet("a").Value)
+
+	deleted, err := kvQ.DeleteMany([]string{"a"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	count, err := kvQ.Count()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+}
+
+func TestSplitKeyValueQPrimaryReadsAfterWrite(t *testing.T) {
+	primary := dbantest.NewMemoryKeyValueQ()
+	replica := dbantest.NewMemoryKeyValueQ()
+	kvQ := dban.NewSplitKeyValueQ(primary, replica, dban.WithPrimaryReadsAfterWrite(time.Hour))
+
+	assert.Nil(t, kvQ.MustLockingGet("a"))
+	require.NoError(t, replica.Upsert(dban.KeyValue{Key: "a", Value: "stale"}))
+	assert.Equal(t, "stale", kvQ.MustGet("a").Value, "reads are served from the replica before any write")
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "a", Value: "1"}))
+	assert.Equal(t, "1", kvQ.New().MustGet("a").Value, "written values are read back from the primary storage")
+}
@@ -0,0 +1,113 @@
+package dban
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/reflectx"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// NewKeyValueQFromTx creates a key value querier running its queries in tx, a transaction
+// the caller manages, so that its writes are committed or rolled back along with the
+// caller's ones. The querier never commits nor rolls back tx: Transaction just runs the
+// function in it, and locks taken by LockingGet last until the caller ends it. New
+// returns a querier bound to tx as well. Options configuring transactions the querier
+// opens (e.g. WithIsolationLevel) have no effect
+func NewKeyValueQFromTx(tx *sql.Tx, opts ...KeyValueQOption) KeyValueQ {
+	db := &pgdb.DB{Queryer: txQueryer{tx: &sqlx.Tx{Tx: tx, Mapper: reflectx.NewMapperFunc("db", strings.ToLower)}}}
+	return NewKeyValueQ(db, opts...)
+}
+
+// txQueryer implements pgdb.Queryer over a transaction of the caller the way pgdb
+// implements it over its own connections
+type txQueryer struct {
+	tx *sqlx.Tx
+}
+
+// InTransaction makes the querier see the queryer as bound to a transaction (see WithinTx)
+func (q txQueryer) InTransaction() bool {
+	return true
+}
+
+func (q txQueryer) build(query squirrel.Sqlizer) (string, []interface{}, error) {
+	raw, args, err := query.ToSql()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to build query")
+	}
+	return raw, args, nil
+}
+
+func (q txQueryer) Exec(query squirrel.Sqlizer) error {
+	return q.ExecContext(context.Background(), query)
+}
+
+func (q txQueryer) ExecContext(ctx context.Context, query squirrel.Sqlizer) error {
+	_, err := q.ExecWithResultContext(ctx, query)
+	return err
+}
+
+func (q txQueryer) ExecRaw(query string, args ...interface{}) error {
+	return q.ExecRawContext(context.Background(), query, args...)
+}
+
+func (q txQueryer) ExecRawContext(ctx context.Context, query string, args ...interface{}) error {
+	_, err := q.tx.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, query), args...)
+	return err
+}
+
+func (q txQueryer) ExecWithResult(query squirrel.Sqlizer) (sql.Result, error) {
+	return q.ExecWithResultContext(context.Background(), query)
+}
+
+func (q txQueryer) ExecWithResultContext(ctx context.Context, query squirrel.Sqlizer) (sql.Result, error) {
+	raw, args, err := q.build(query)
+	if err != nil {
+		return nil, err
+	}
+	return q.tx.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, raw), args...)
+}
+
+func (q txQueryer) Select(dest interface{}, query squirrel.Sqlizer) error {
+	return q.SelectContext(context.Background(), dest, query)
+}
+
+func (q txQueryer) SelectContext(ctx context.Context, dest interface{}, query squirrel.Sqlizer) error {
+	raw, args, err := q.build(query)
+	if err != nil {
+		return err
+	}
+	return q.SelectRawContext(ctx, dest, raw, args...)
+}
+
+func (q txQueryer) SelectRaw(dest interface{}, query string, args ...interface{}) error {
+	return q.SelectRawContext(context.Background(), dest, query, args...)
+}
+
+func (q txQueryer) SelectRawContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return q.tx.SelectContext(ctx, dest, sqlx.Rebind(sqlx.DOLLAR, query), args...)
+}
+
+func (q txQueryer) Get(dest interface{}, query squirrel.Sqlizer) error {
+	return q.GetContext(context.Background(), dest, query)
+}
+
+func (q txQueryer) GetContext(ctx context.Context, dest interface{}, query squirrel.Sqlizer) error {
+	raw, args, err := q.build(query)
+	if err != nil {
+		return err
+	}
+	return q.GetRawContext(ctx, dest, raw, args...)
+}
+
+func (q txQueryer) GetRaw(dest interface{}, query string, args ...interface{}) error {
+	return q.GetRawContext(context.Background(), dest, query, args...)
+}
+
+func (q txQueryer) GetRawContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return q.tx.GetContext(ctx, dest, sqlx.Rebind(sqlx.DOLLAR, query), args...)
+}
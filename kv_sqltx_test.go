@@ -0,0 +1,49 @@
+package dban
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewKeyValueQFromTx(t *testing.T) {
+	raw, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer raw.Close()
+
+	mock.ExpectBegin()
+	tx, err := raw.Begin()
+	require.NoError(t, err)
+
+	kvQ := NewKeyValueQFromTx(tx)
+	assert.True(t, withinTx(kvQ))
+	assert.Same(t, kvQ.(*keyValueQ).db, kvQ.New().(*keyValueQ).db, "a querier bound to a caller's transaction is not cloned")
+
+	mock.ExpectQuery("SELECT key, value FROM key_value WHERE key = $1 FOR UPDATE").
+		WithArgs("cursor").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("cursor", "10"))
+	mock.ExpectExec("INSERT INTO key_value (key,value) VALUES ($1,$2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value").
+		WithArgs("cursor", "11").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	err = transaction(kvQ, func(q KeyValueQ) error {
+		value, err := q.LockingGet("cursor")
+		require.NoError(t, err)
+		require.Equal(t, "10", value.Value)
+		return q.Upsert(KeyValue{Key: "cursor", Value: "11"})
+	})
+	require.NoError(t, err)
+
+	mock.ExpectQuery("SELECT key, value FROM key_value WHERE key = $1").
+		WithArgs("missing").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}))
+	value, err := kvQ.Get("missing")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	// the transaction is committed by the caller only
+	mock.ExpectCommit()
+	require.NoError(t, tx.Commit())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
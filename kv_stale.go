@@ -0,0 +1,79 @@
+package dban
+
+import (
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// pausedKeySuffix is appended to the cursor key to form a key flagging the streamer as
+// paused. Cursors of paused streamers are never stale
+const pausedKeySuffix = ":paused"
+
+// staleCursors selects keys of cursors of the table starting with the prefix that were not
+// updated for olderThan. A cursor is a key with a numeric value that is not a companion key
+func staleCursors(q *kvQueries, prefix string, olderThan time.Duration) squirrel.SelectBuilder {
+	key, value := "kv."+q.key, "kv."+q.value
+	return squirrel.Select(q.key).From(q.table+" kv").
+		Where("left("+key+", char_length(?)) = ?", prefix, prefix).
+		Where(value+` ~ '^[0-9]+$'`).
+		Where("right("+key+", ?) <> ?", len(batchSizeKeySuffix), batchSizeKeySuffix).
+		Where("right("+key+", ?) <> ?", len(pausedKeySuffix), pausedKeySuffix).
+		Where("right("+key+", ?) <> ?", len(failuresKeySuffix), failuresKeySuffix).
+		Where("kv."+updatedAtColumn+" < now() - ? * interval '1 second'", olderThan.Seconds()).
+		Where("NOT EXISTS (SELECT 1 FROM "+q.table+" paused WHERE paused."+q.key+" = "+key+" || ?)", pausedKeySuffix)
+}
+
+// FindStaleCursors lists cursors with keys starting with the prefix that were not
+// written for olderThan, excluding the ones of paused streamers (flagged with a
+// "<key>:paused" key). Requires FeatureTimestamps. The table is the default one unless
+// configured by WithTable or WithTableSchema, while other options are ignored
+func FindStaleCursors(db *pgdb.DB, prefix string, olderThan time.Duration, opts ...KeyValueQOption) ([]KeyValue, error) {
+	q := kvQueriesOf(opts)
+	var stale []KeyValue
+	err := db.Select(&stale, q.selectKV.Where(squirrel.Expr(
+		q.key+" IN (?)", staleCursors(q, prefix, olderThan),
+	)).OrderBy(q.key))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select stale cursors", logan.F{
+			"prefix":     prefix,
+			"older_than": olderThan,
+		})
+	}
+	return stale, nil
+}
+
+// PurgeStaleCursors deletes the cursors FindStaleCursors finds along with their batch
+// size companions and returns the number of cursors deleted. With dryRun nothing is
+// deleted, while the number of cursors that would be is returned. The table is picked by
+// the options the way it is for FindStaleCursors
+func PurgeStaleCursors(db *pgdb.DB, prefix string, olderThan time.Duration, dryRun bool, opts ...KeyValueQOption) (int64, error) {
+	fields := logan.F{
+		"prefix":     prefix,
+		"older_than": olderThan,
+	}
+
+	if dryRun {
+		stale, err := FindStaleCursors(db, prefix, olderThan, opts...)
+		return int64(len(stale)), err
+	}
+
+	// the companions are deleted by a data-modifying CTE along with the cursors
+	q := kvQueriesOf(opts)
+	result, err := db.ExecWithResult(squirrel.Delete(q.table).
+		Prefix("WITH stale AS (?),", staleCursors(q, prefix, olderThan).Suffix("FOR UPDATE")).
+		Prefix("companions AS (DELETE FROM "+q.table+" WHERE "+q.key+" IN (SELECT "+q.key+" || ? FROM stale))", batchSizeKeySuffix).
+		Where(q.key + " IN (SELECT " + q.key + " FROM stale)"))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete stale cursors", fields)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get number of deleted cursors", fields)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,94 @@
+package dban
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const staleCursorsSQL = "SELECT key FROM key_value kv WHERE left(kv.key, char_length($1)) = $2 " +
+	"AND kv.value ~ '^[0-9]+$' AND right(kv.key, $3) <> $4 AND right(kv.key, $5) <> $6 " +
+	"AND right(kv.key, $7) <> $8 AND kv.updated_at < now() - $9 * interval '1 second' " +
+	"AND NOT EXISTS (SELECT 1 FROM key_value paused WHERE paused.key = kv.key || $10)"
+
+var staleCursorsArgs = []driver.Value{
+	"streamer-", "streamer-", 11, ":batch_size", 7, ":paused", 9, ":failures", 3600.0, ":paused",
+}
+
+func TestPurgeStaleCursors(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	mock.ExpectQuery("SELECT key, value FROM key_value WHERE key IN (" + staleCursorsSQL + ") ORDER BY key").
+		WithArgs(staleCursorsArgs...).
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("streamer-a", "3").AddRow("streamer-b", "7"))
+	purged, err := PurgeStaleCursors(db, "streamer-", time.Hour, true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), purged, "dry run only counts the cursors")
+
+	mock.ExpectExec("WITH stale AS (" + staleCursorsSQL + " FOR UPDATE), " +
+		"companions AS (DELETE FROM key_value WHERE key IN (SELECT key || $11 FROM stale)) " +
+		"DELETE FROM key_value WHERE key IN (SELECT key FROM stale)").
+		WithArgs(append(staleCursorsArgs, ":batch_size")...).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	purged, err = PurgeStaleCursors(db, "streamer-", time.Hour, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	mock.ExpectExec(`WITH stale AS (SELECT "k" FROM "cursors" kv WHERE left(kv."k", char_length($1)) = $2 ` +
+		`AND kv."v" ~ '^[0-9]+$' AND right(kv."k", $3) <> $4 AND right(kv."k", $5) <> $6 ` +
+		`AND right(kv."k", $7) <> $8 AND kv.updated_at < now() - $9 * interval '1 second' ` +
+		`AND NOT EXISTS (SELECT 1 FROM "cursors" paused WHERE paused."k" = kv."k" || $10) FOR UPDATE), ` +
+		`companions AS (DELETE FROM "cursors" WHERE "k" IN (SELECT "k" || $11 FROM stale)) ` +
+		`DELETE FROM "cursors" WHERE "k" IN (SELECT "k" FROM stale)`).
+		WithArgs(append(staleCursorsArgs, ":batch_size")...).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	purged, err = PurgeStaleCursors(db, "streamer-", time.Hour, false,
+		WithTable(KeyValueTable{Name: "cursors", KeyColumn: "k", ValueColumn: "v"}))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+}
+
+func TestStaleCursorsPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db, WithFeatures(FeatureTimestamps))
+	kvQ := NewKeyValueQ(db)
+
+	for _, kv := range []KeyValue{
+		{Key: "streamer-fresh", Value: "1"},
+		{Key: "streamer-stale", Value: "2"},
+		{Key: "streamer-stale:batch_size", Value: "15"},
+		{Key: "streamer-paused", Value: "3"},
+		{Key: "streamer-paused:paused", Value: "true"},
+		{Key: "streamer-config", Value: "not a cursor"},
+		{Key: "other-stale", Value: "4"},
+	} {
+		require.NoError(t, kvQ.Upsert(kv))
+	}
+	_, err := db.RawDB().Exec("UPDATE key_value SET updated_at = now() - interval '2 days' WHERE key <> 'streamer-fresh'")
+	require.NoError(t, err)
+	// the trigger keeps updated_at of the rows written afterwards fresh
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "streamer-fresh", Value: "2"}))
+
+	stale, err := FindStaleCursors(db, "streamer-", 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, []KeyValue{{Key: "streamer-stale", Value: "2"}}, stale)
+
+	purged, err := PurgeStaleCursors(db, "streamer-", 24*time.Hour, true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	assert.NotNil(t, kvQ.MustGet("streamer-stale"), "dry run deletes nothing")
+
+	purged, err = PurgeStaleCursors(db, "streamer-", 24*time.Hour, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	_, err = GetStrict(kvQ, "streamer-stale")
+	assert.True(t, Is(err, ErrNoSuchKey))
+	_, err = GetStrict(kvQ, "streamer-stale:batch_size")
+	assert.True(t, Is(err, ErrNoSuchKey))
+	assert.NotNil(t, kvQ.MustGet("streamer-paused"))
+	assert.NotNil(t, kvQ.MustGet("other-stale"))
+}
@@ -0,0 +1,353 @@
+package dban
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Masterminds/squirrel"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+var _ TransactionalKeyValueQ = (*stdKeyValueQ)(nil)
+
+// stdQueryer is the connection the std querier runs its queries with, *sql.DB or a
+// transaction of it
+type stdQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type stdKeyValueQ struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	queries *kvQueries
+	filters KeyValueFilters
+}
+
+// NewKeyValueQStd creates a key value querier of the default table over a plain
+// database/sql connection to Postgres, e.g. one opened with pgx/stdlib, for services that
+// do not use pgdb. It behaves the way NewKeyValueQ does: Upsert overwrites the value on
+// conflict, Get returns nil for a missing key and LockingGet locks the row FOR UPDATE,
+// failing with ErrNoTransaction outside Transaction. Options of NewKeyValueQ are not
+// supported. New returns a querier sharing the same *sql.DB, bound to the transaction
+// of q if there is one.
+//
+// The querier does not use pgdb.DB, but it still imports pgdb, as KeyValueQ pages with
+// pgdb.OffsetPageParams, so it could not live in a package free of the kit dependency
+// without changing KeyValueQ itself
+func NewKeyValueQStd(db *sql.DB) KeyValueQ {
+	return &stdKeyValueQ{db: db, queries: defaultQueries}
+}
+
+func (q *stdKeyValueQ) New() KeyValueQ {
+	return &stdKeyValueQ{db: q.db, tx: q.tx, queries: q.queries}
+}
+
+// conn returns the transaction of the querier if there is one and the database otherwise
+func (q *stdKeyValueQ) conn() stdQueryer {
+	if q.tx != nil {
+		return q.tx
+	}
+	return q.db
+}
+
+// build builds the query with the placeholders of Postgres
+func (q *stdKeyValueQ) build(query squirrel.Sqlizer) (string, []interface{}, error) {
+	raw, args, err := query.ToSql()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to build query")
+	}
+	raw, err = squirrel.Dollar.ReplacePlaceholders(raw)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to replace placeholders")
+	}
+	return raw, args, nil
+}
+
+func (q *stdKeyValueQ) exec(query squirrel.Sqlizer) (sql.Result, error) {
+	raw, args, err := q.build(query)
+	if err != nil {
+		return nil, err
+	}
+	return q.conn().ExecContext(context.Background(), raw, args...)
+}
+
+func (q *stdKeyValueQ) Transaction(fn func(q KeyValueQ) error) error {
+	if q.tx != nil {
+		return fn(q)
+	}
+
+	tx, err := q.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	if err = fn(&stdKeyValueQ{db: q.db, tx: tx, queries: q.queries}); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return errors.Wrap(err, "failed to roll back transaction", logan.F{"rollback_error": rollbackErr})
+		}
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+	return nil
+}
+
+func (q *stdKeyValueQ) WithinTx() bool {
+	return q.tx != nil
+}
+
+func (q *stdKeyValueQ) Get(key string) (*KeyValue, error) {
+	return q.get(key, rowLockNone)
+}
+
+func (q *stdKeyValueQ) LockingGet(key string) (*KeyValue, error) {
+	if q.tx == nil {
+		return nil, errors.From(ErrNoTransaction, logan.F{"key": key})
+	}
+	return q.get(key, rowLockForUpdate)
+}
+
+func (q *stdKeyValueQ) get(key string, lock rowLock) (*KeyValue, error) {
+	if err := validateKey(key, DefaultMaxKeyLength); err != nil {
+		return nil, err
+	}
+
+	raw, args, err := q.build(squirrel.Expr(q.queries.get[0][lock], key))
+	if err != nil {
+		return nil, err
+	}
+
+	var value KeyValue
+	err = q.conn().QueryRowContext(context.Background(), raw, args...).Scan(&value.Key, &value.Value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get value", logan.F{"key": key})
+	}
+	return &value, nil
+}
+
+func (q *stdKeyValueQ) MustGet(key string) *KeyValue {
+	value, err := GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+func (q *stdKeyValueQ) MustLockingGet(key string) *KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
+
+func (q *stdKeyValueQ) GetMany(keys []string) (map[string]KeyValue, error) {
+	values := make(map[string]KeyValue, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	found, err := q.selectValues(q.queries.selectKV.Where(squirrel.Eq{q.queries.key: keys}))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get values", logan.F{"keys": keys})
+	}
+	for _, kv := range found {
+		values[kv.Key] = kv
+	}
+	return values, nil
+}
+
+// selectValues runs the query selecting key values
+func (q *stdKeyValueQ) selectValues(query squirrel.SelectBuilder) ([]KeyValue, error) {
+	raw, args, err := q.build(query)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.conn().QueryContext(context.Background(), raw, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []KeyValue{}
+	for rows.Next() {
+		var value KeyValue
+		if err = rows.Scan(&value.Key, &value.Value); err != nil {
+			return nil, errors.Wrap(err, "failed to scan value")
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+func (q *stdKeyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	query := q.queries.selectKV
+	if prefix != "" {
+		query = query.Where(q.queries.key+` LIKE ? ESCAPE '\'`, LikePrefix(prefix))
+	}
+
+	values, err := q.selectValues(params.ApplyTo(query, q.queries.key))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select values by prefix", logan.F{"prefix": prefix})
+	}
+	return values, nil
+}
+
+func (q *stdKeyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	query := squirrel.Select(q.queries.key).From(q.queries.table)
+	if prefix != "" {
+		query = query.Where(q.queries.key+` LIKE ? ESCAPE '\'`, LikePrefix(prefix))
+	}
+
+	raw, args, err := q.build(params.ApplyTo(query, q.queries.key))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.conn().QueryContext(context.Background(), raw, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list keys", logan.F{"prefix": prefix})
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return nil, errors.Wrap(err, "failed to scan key", logan.F{"prefix": prefix})
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to list keys", logan.F{"prefix": prefix})
+	}
+	return keys, nil
+}
+
+func (q *stdKeyValueQ) Count() (uint64, error) {
+	raw, args, err := q.build(squirrel.Select("count(*)").From(q.queries.table))
+	if err != nil {
+		return 0, err
+	}
+
+	var count uint64
+	if err = q.conn().QueryRowContext(context.Background(), raw, args...).Scan(&count); err != nil {
+		return 0, errors.Wrap(err, "failed to count values")
+	}
+	return count, nil
+}
+
+func (q *stdKeyValueQ) FilterByKeys(keys ...string) KeyValueQ {
+	return &stdKeyValueQ{db: q.db, tx: q.tx, queries: q.queries, filters: q.filters.ByKeys(keys...)}
+}
+
+func (q *stdKeyValueQ) FilterByPrefix(prefix string) KeyValueQ {
+	return &stdKeyValueQ{db: q.db, tx: q.tx, queries: q.queries, filters: q.filters.ByKeyPrefix(prefix)}
+}
+
+func (q *stdKeyValueQ) FilterByValueLike(pattern string) KeyValueQ {
+	return &stdKeyValueQ{db: q.db, tx: q.tx, queries: q.queries, filters: q.filters.ByValueLike(pattern)}
+}
+
+func (q *stdKeyValueQ) Select() ([]KeyValue, error) {
+	values, err := q.selectValues(q.filtered().OrderBy(q.queries.key))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select filtered values")
+	}
+	return values, nil
+}
+
+func (q *stdKeyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	values, err := q.selectValues(params.ApplyTo(q.filtered(), q.queries.key))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select filtered values")
+	}
+	return values, nil
+}
+
+func (q *stdKeyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.SelectPage(KeyValueStreamParams(params))
+}
+
+// filtered returns the query selecting the values passing the filters
+func (q *stdKeyValueQ) filtered() squirrel.SelectBuilder {
+	return q.filters.ApplyTo(q.queries.selectKV, q.queries.key, q.queries.value+` LIKE ? ESCAPE '\'`)
+}
+
+func (q *stdKeyValueQ) Upsert(kv KeyValue) error {
+	if err := validateKey(kv.Key, DefaultMaxKeyLength); err != nil {
+		return err
+	}
+
+	if _, err := q.exec(squirrel.Expr(q.queries.upsert[0], kv.Key, kv.Value)); err != nil {
+		return errors.Wrap(err, "failed to upsert value", logan.F{"key": kv.Key})
+	}
+	return nil
+}
+
+func (q *stdKeyValueQ) UpsertMany(kvs []KeyValue) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+	if err := validateKeys(kvs); err != nil {
+		return err
+	}
+
+	kvs = lastValues(kvs)
+	query := squirrel.Insert(q.queries.table).Columns(q.queries.key, q.queries.value).Suffix(q.queries.updateValue[0])
+	for _, kv := range kvs {
+		query = query.Values(kv.Key, kv.Value)
+	}
+	if _, err := q.exec(query); err != nil {
+		return errors.Wrap(err, "failed to upsert values", logan.F{"count": len(kvs)})
+	}
+	return nil
+}
+
+// validateKeys returns BatchError listing the key values of the batch with invalid keys
+func validateKeys(kvs []KeyValue) error {
+	var batchErr BatchError
+	for i, kv := range kvs {
+		if err := validateKey(kv.Key, DefaultMaxKeyLength); err != nil {
+			batchErr.Errors = append(batchErr.Errors, KeyError{Key: kv.Key, Index: i, Err: err})
+		}
+	}
+	if len(batchErr.Errors) != 0 {
+		return &batchErr
+	}
+	return nil
+}
+
+func (q *stdKeyValueQ) Delete(key string) error {
+	_, err := q.DeleteMany([]string{key})
+	return err
+}
+
+func (q *stdKeyValueQ) DeleteMany(keys []string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	batch := make([]KeyValue, len(keys))
+	for i, key := range keys {
+		batch[i] = KeyValue{Key: key}
+	}
+	if err := validateKeys(batch); err != nil {
+		return 0, err
+	}
+
+	result, err := q.exec(squirrel.Delete(q.queries.table).Where(squirrel.Eq{q.queries.key: keys}))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete values", logan.F{"keys": keys})
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get number of deleted values", logan.F{"keys": keys})
+	}
+	return deleted, nil
+}
@@ -0,0 +1,127 @@
+package dban
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+func TestNewKeyValueQStd(t *testing.T) {
+	raw, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer raw.Close()
+
+	kvQ := NewKeyValueQStd(raw)
+	assert.False(t, withinTx(kvQ))
+
+	mock.ExpectQuery("SELECT key, value FROM key_value WHERE key = $1").
+		WithArgs("missing").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}))
+	value, err := kvQ.Get("missing")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	_, err = kvQ.LockingGet("cursor")
+	assert.True(t, Is(err, ErrNoTransaction), "%v", err)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT key, value FROM key_value WHERE key = $1 FOR UPDATE").
+		WithArgs("cursor").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("cursor", "10"))
+	mock.ExpectExec("INSERT INTO key_value (key,value) VALUES ($1,$2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value").
+		WithArgs("cursor", "11").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	err = transaction(kvQ, func(q KeyValueQ) error {
+		assert.True(t, withinTx(q.New()), "a querier made within a transaction must join it")
+		value, err := q.LockingGet("cursor")
+		require.NoError(t, err)
+		require.Equal(t, "10", value.Value)
+		return q.Upsert(KeyValue{Key: "cursor", Value: "11"})
+	})
+	require.NoError(t, err)
+
+	mock.ExpectExec("INSERT INTO key_value (key,value) VALUES ($1,$2),($3,$4) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value").
+		WithArgs("a", "3", "b", "2").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	require.NoError(t, kvQ.UpsertMany([]KeyValue{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}, {Key: "a", Value: "3"}}))
+
+	mock.ExpectQuery(`SELECT key, value FROM key_value WHERE substr(key, 1, $1) = $2 ORDER BY key asc LIMIT 2 OFFSET 2`).
+		WithArgs(3, "ns:").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("ns:c", "3"))
+	values, err := kvQ.FilterByPrefix("ns:").SelectWithPageParams(pgdb.OffsetPageParams{Limit: 2, PageNumber: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []KeyValue{{Key: "ns:c", Value: "3"}}, values)
+
+	mock.ExpectExec("DELETE FROM key_value WHERE key IN ($1,$2)").
+		WithArgs("a", "missing").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	deleted, err := kvQ.DeleteMany([]string{"a", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+type stdOrder struct {
+	ID int64
+}
+
+// stdOrders streams orders with a plain database/sql query
+type stdOrders struct {
+	db *sql.DB
+}
+
+func (s stdOrders) SelectWithPageParams(params pgdb.OffsetPageParams) ([]stdOrder, error) {
+	rows, err := s.db.Query("SELECT id FROM dban_std_orders ORDER BY id LIMIT $1 OFFSET $2", params.Limit, params.Limit*params.PageNumber)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []stdOrder
+	for rows.Next() {
+		var order stdOrder
+		if err = rows.Scan(&order.ID); err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, rows.Err()
+}
+
+func TestNewKeyValueQStdStreamer(t *testing.T) {
+	pg := openTestPostgres(t)
+	migrateTestPostgres(t, pg)
+	db := pg.RawDB()
+	_, err := db.Exec("CREATE TABLE dban_std_orders (id bigint PRIMARY KEY)")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, err := db.Exec("DROP TABLE dban_std_orders")
+		require.NoError(t, err)
+	})
+	_, err = db.Exec("INSERT INTO dban_std_orders (id) SELECT generate_series(1, 5)")
+	require.NoError(t, err)
+
+	batchSize := uint64(2)
+	streamer := NewStreamer(StreamerInitParams[stdOrder]{
+		Stream:      stdOrders{db: db},
+		KeyValueQ:   NewKeyValueQStd(db),
+		KeyValueKey: "std_orders",
+		BatchSize:   &batchSize,
+	})
+
+	var processed []int64
+	for i := 0; i < 3; i++ {
+		require.NoError(t, streamer.FormListAndProcess(func(_ context.Context, order stdOrder) error {
+			processed = append(processed, order.ID)
+			return nil
+		}))
+	}
+	assert.Equal(t, []int64{1, 2, 3, 4, 5}, processed)
+}
@@ -0,0 +1,60 @@
+package dban
+
+import (
+	"github.com/Masterminds/squirrel"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// KeyChecker is a key value querier able to check whether there is a value by a key
+// without reading it
+type KeyChecker interface {
+	// Exists reports whether there is a value by the key
+	Exists(key string) (bool, error)
+}
+
+var _ KeyChecker = (*keyValueQ)(nil)
+
+// GetStrict gets the value by the key and fails with ErrNoSuchKey if there is none. Unlike
+// the nil value Get returns for a missing key, the error is hard to overlook, so it is the
+// preferred way to read values that must be there
+func GetStrict(q KeyValueQ, key string) (KeyValue, error) {
+	return strict(key)(q.Get(key))
+}
+
+// Exists reports whether there is a value by the key. Queriers implementing KeyChecker
+// check it without reading the value
+func Exists(q KeyValueQ, key string) (bool, error) {
+	if checker, ok := q.(KeyChecker); ok {
+		return checker.Exists(key)
+	}
+
+	kv, err := q.Get(key)
+	return kv != nil, err
+}
+
+// strict turns a missing value read by the key into ErrNoSuchKey
+func strict(key string) func(kv *KeyValue, err error) (KeyValue, error) {
+	return func(kv *KeyValue, err error) (KeyValue, error) {
+		switch {
+		case err != nil:
+			return KeyValue{}, err
+		case kv == nil:
+			return KeyValue{}, errors.From(ErrNoSuchKey, logan.F{"key": key})
+		default:
+			return *kv, nil
+		}
+	}
+}
+
+func (q *keyValueQ) Exists(key string) (bool, error) {
+	query := q.live(squirrel.Select("1").From(q.queries.table).Where(squirrel.Eq{q.queries.key: key}))
+
+	var exists bool
+	err := q.db.GetContext(q.ctx, &exists, squirrel.Select().Column(squirrel.Expr("EXISTS (?)", query)))
+	countKV(kvVarGet, err)
+	if err != nil {
+		return false, errors.Wrap(q.classify(err), "failed to check value", logan.F{"key": key})
+	}
+	return exists, nil
+}
@@ -0,0 +1,56 @@
+package dban
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetStrict(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db)
+
+	mock.ExpectQuery(getSQL).WithArgs("foo").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("foo", "bar"))
+	kv, err := GetStrict(kvQ, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, KeyValue{Key: "foo", Value: "bar"}, kv)
+
+	mock.ExpectQuery(getSQL).WithArgs("missing").WillReturnError(sql.ErrNoRows)
+	_, err = GetStrict(kvQ, "missing")
+	assert.True(t, Is(err, ErrNoSuchKey))
+
+	mock.ExpectQuery(getSQL).WithArgs("foo").WillReturnError(sql.ErrConnDone)
+	_, err = GetStrict(kvQ, "foo")
+	assert.Error(t, err)
+	assert.False(t, Is(err, ErrNoSuchKey), "a failed read must not look like a missing value")
+
+	mock.ExpectQuery(getSQL).WithArgs("missing").WillReturnError(sql.ErrNoRows)
+	func() {
+		defer func() {
+			err, _ := recover().(error)
+			assert.True(t, Is(err, ErrNoSuchKey), "MustGet must panic with ErrNoSuchKey")
+		}()
+		kvQ.MustGet("missing")
+	}()
+}
+
+func TestExists(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db)
+
+	const existsSQL = "SELECT EXISTS (SELECT 1 FROM key_value WHERE key = $1)"
+	mock.ExpectQuery(existsSQL).WithArgs("foo").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(existsSQL).WithArgs("bar").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	exists, err := Exists(kvQ, "foo")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = Exists(kvQ, "bar")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
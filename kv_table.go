@@ -0,0 +1,256 @@
+package dban
+
+import (
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+)
+
+// KeyValueTable names the table a Postgres key value querier works with, so that it
+// could live next to a key_value table of somebody else. Zero fields keep the defaults
+type KeyValueTable struct {
+	// Schema qualifies the table. The search_path resolves it if empty
+	Schema string
+	// Name is the name of the table, key_value by default
+	Name string
+	// KeyColumn is the name of the key column, key by default
+	KeyColumn string
+	// ValueColumn is the name of the value column, value by default
+	ValueColumn string
+}
+
+// WithTable makes the querier work with the table instead of the default key_value one.
+// The table is expected to have the shape the migrations create (see KVMigrator)
+func WithTable(table KeyValueTable) KeyValueQOption {
+	return func(q *keyValueQ) {
+		q.queries = newKVQueries(table)
+	}
+}
+
+// WithTableSchema makes the querier work with the key_value table of the schema, the one
+// the migrator created WithSchema, regardless of the search_path of the connection. It is
+// a shorthand for WithTable with a KeyValueTable of the schema only
+func WithTableSchema(schema string) KeyValueQOption {
+	return WithTable(KeyValueTable{Schema: schema})
+}
+
+// kvQueriesOf returns the queries of the table the options configure, for the functions
+// working with the table without a querier
+func kvQueriesOf(opts []KeyValueQOption) *kvQueries {
+	q := &keyValueQ{queries: defaultQueries}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q.queries
+}
+
+// kvQueries are the queries of a querier built for its table
+type kvQueries struct {
+	// table is the qualified name of the table to select from and write to, while name
+	// refers to the rows being updated in ON CONFLICT clauses
+	table, name, key, value string
+
+	selectKV   squirrel.SelectBuilder
+	insert     squirrel.InsertBuilder
+	notExpired squirrel.Sqlizer
+	notDeleted squirrel.Sqlizer
+
+	// get is indexed by the rows a read hides and the row lock of it
+	get [hidesAll][rowLocks]string
+	// updateValue and upserts are indexed by the columns a write resets
+	updateValue, upsert, upsertWithTTL, upsertBytes [resetsAll]string
+	upsertChanged                                   [resetsAll]string
+	delete, deleteMany, deleteExpired               string
+	softDelete, restore, purgeDeleted               string
+	updateIfEquals, insertIfAbsent                  string
+	// advanceCursor and increment are indexed by the columns a write resets
+	advanceCursor, increment [resetsAll]string
+	// getVersioned is indexed by the rows a read hides
+	getVersioned [hidesAll]string
+	// updateIfVersion is indexed by the columns a write resets
+	updateIfVersion [resetsAll]string
+}
+
+// defaultQueries are the queries of the default table
+var defaultQueries = newKVQueries(KeyValueTable{})
+
+// newKVQueries builds the queries for the table. Configured identifiers are quoted, while
+// the defaults are left as they are, so that queries of the default table do not change
+func newKVQueries(t KeyValueTable) *kvQueries {
+	q := &kvQueries{
+		name:  identifier(t.Name, keyValueTable),
+		key:   identifier(t.KeyColumn, keyColumn),
+		value: identifier(t.ValueColumn, valueColumn),
+	}
+	q.table = q.name
+	if t.Schema != "" {
+		q.table = pq.QuoteIdentifier(t.Schema) + "." + q.name
+	}
+
+	// columns are selected under the names KeyValue is scanned by
+	q.selectKV = squirrel.Select(selected(q.key, keyColumn), selected(q.value, valueColumn)).From(q.table)
+	q.insert = squirrel.Insert(q.table).Columns(q.key, q.value).Values("", "")
+	// notExpired matches values without an expiry and the ones that did not expire yet
+	q.notExpired = squirrel.Expr("(" + expiresAtColumn + " IS NULL OR " + expiresAtColumn + " > now())")
+	q.notDeleted = squirrel.Expr(deletedAtColumn + " IS NULL")
+
+	onConflict := "ON CONFLICT (" + q.key + ") "
+	updateValue := onConflict + "DO UPDATE SET " + q.value + " = EXCLUDED." + q.value
+	// addToValue adds to the value of the row, starting from 0 if the row is hidden, the
+	// same way it does for a missing one
+	addToValue := func(reset resets) string {
+		current := q.name + "." + q.value + "::bigint"
+		if hidden := reset.hidden(q.name); hidden != "" {
+			current = "CASE WHEN " + hidden + " THEN 0 ELSE " + current + " END"
+		}
+		return onConflict + "DO UPDATE SET " + q.value + " = (" + current + " + ?)::text" + reset.set()
+	}
+
+	for hide := hides(0); hide < hidesAll; hide++ {
+		byKey := q.visible(q.selectKV.Where(squirrel.Eq{q.key: ""}), hide)
+		q.get[hide][rowLockNone] = mustBuild(byKey)
+		for lock := rowLockForUpdate; lock < rowLocks; lock++ {
+			q.get[hide][lock] = mustBuild(byKey.Suffix(lock.suffix()))
+		}
+		q.getVersioned[hide] = mustBuild(byKey.Column(versionColumn))
+	}
+
+	for reset := resets(0); reset < resetsAll; reset++ {
+		q.updateValue[reset] = updateValue + reset.set()
+		q.upsert[reset] = mustBuild(q.insert.Suffix(q.updateValue[reset]))
+		q.upsertWithTTL[reset] = mustBuild(
+			squirrel.Insert(q.table).
+				Columns(q.key, q.value, expiresAtColumn).
+				Values("", "", squirrel.Expr("now() + ? * interval '1 second'", 0)).
+				Suffix(updateValue + ", " + expiresAtColumn + " = EXCLUDED." + expiresAtColumn + (reset &^ resetExpiry).set()),
+		)
+		q.upsertBytes[reset] = mustBuild(
+			squirrel.Insert(q.table).
+				Columns(q.key, q.value, valueBytesColumn).
+				Values("", "", "").
+				Suffix(updateValue + ", " + valueBytesColumn + " = EXCLUDED." + valueBytesColumn + (reset &^ resetBytes).set()),
+		)
+		q.upsertChanged[reset] = mustBuild(q.insert.Suffix(
+			q.updateValue[reset] + " WHERE " + q.name + "." + q.value + " IS DISTINCT FROM EXCLUDED." + q.value + reset.changed(q.name),
+		))
+		q.advanceCursor[reset] = mustBuild(q.insert.Suffix(addToValue(reset)).Suffix("RETURNING " + q.value + "::bigint - ?"))
+		q.increment[reset] = mustBuild(q.insert.Suffix(addToValue(reset)).Suffix("RETURNING " + q.value + "::bigint"))
+		// the version is incremented by the trigger of FeatureVersion
+		q.updateIfVersion[reset] = "UPDATE " + q.table + " SET " + q.value + " = ?" + reset.set() +
+			" WHERE " + q.key + " = ? AND " + versionColumn + " = ?"
+	}
+
+	q.delete = mustBuild(squirrel.Delete(q.table).Where(squirrel.Eq{q.key: ""}).Suffix("RETURNING " + q.key))
+	q.deleteMany = mustBuild(squirrel.Delete(q.table).Where(q.key + " = ANY(?)").Suffix("RETURNING " + q.key))
+	q.deleteExpired = mustBuild(squirrel.Delete(q.table).Where(expiresAtColumn + " <= now()"))
+	q.softDelete = mustBuild(
+		squirrel.Update(q.table).Set(deletedAtColumn, squirrel.Expr("now()")).
+			Where(q.key + " = ANY(?)").Where(q.notDeleted).
+			Suffix("RETURNING " + q.key),
+	)
+	q.restore = mustBuild(squirrel.Update(q.table).Set(deletedAtColumn, squirrel.Expr("NULL")).Where(squirrel.Eq{q.key: ""}))
+	q.purgeDeleted = mustBuild(
+		squirrel.Delete(q.table).Where(deletedAtColumn + " <= now() - ? * interval '1 second'"),
+	)
+
+	q.updateIfEquals = mustBuild(
+		squirrel.Update(q.table).Set(q.value, "").Where(squirrel.Eq{q.key: ""}).Where(squirrel.Eq{q.value: ""}),
+	)
+	q.insertIfAbsent = mustBuild(q.insert.Suffix(onConflict + "DO NOTHING"))
+
+	return q
+}
+
+// visible adds the conditions hiding the rows to the query
+func (q *kvQueries) visible(query squirrel.SelectBuilder, hide hides) squirrel.SelectBuilder {
+	if hide&hideExpired != 0 {
+		query = query.Where(q.notExpired)
+	}
+	if hide&hideDeleted != 0 {
+		query = query.Where(q.notDeleted)
+	}
+	return query
+}
+
+// hides is a set of rows reads treat as missing, e.g. expired or soft deleted ones
+type hides uint8
+
+const (
+	hideExpired hides = 1 << iota
+	hideDeleted
+
+	hidesAll
+)
+
+// resets is a set of columns a write resets, so that a value does not keep attributes of
+// the value it replaced, e.g. its expiry or binary contents, or stays soft deleted
+type resets uint8
+
+const (
+	resetExpiry resets = 1 << iota
+	resetBytes
+	resetDeleted
+
+	resetsAll
+)
+
+// set returns the assignments resetting the columns, to be appended to DO UPDATE SET
+func (r resets) set() string {
+	var assignments string
+	if r&resetExpiry != 0 {
+		assignments += ", " + expiresAtColumn + " = NULL"
+	}
+	if r&resetBytes != 0 {
+		assignments += ", " + valueBytesColumn + " = NULL"
+	}
+	if r&resetDeleted != 0 {
+		assignments += ", " + deletedAtColumn + " = NULL"
+	}
+	return assignments
+}
+
+// changed returns the conditions under which a write changes the columns it resets even
+// if the value stays the same, to be appended to the WHERE clause of DO UPDATE of table
+func (r resets) changed(table string) string {
+	var conditions string
+	if r&resetExpiry != 0 {
+		conditions += " OR " + table + "." + expiresAtColumn + " IS NOT NULL"
+	}
+	if r&resetBytes != 0 {
+		conditions += " OR " + table + "." + valueBytesColumn + " IS NOT NULL"
+	}
+	if r&resetDeleted != 0 {
+		conditions += " OR " + table + "." + deletedAtColumn + " IS NOT NULL"
+	}
+	return conditions
+}
+
+// hidden returns the conditions under which a row of table written by a write resetting the
+// columns is hidden from reads, e.g. expired or soft deleted
+func (r resets) hidden(table string) string {
+	var conditions []string
+	if r&resetExpiry != 0 {
+		conditions = append(conditions, table+"."+expiresAtColumn+" <= now()")
+	}
+	if r&resetDeleted != 0 {
+		conditions = append(conditions, table+"."+deletedAtColumn+" IS NOT NULL")
+	}
+	return strings.Join(conditions, " OR ")
+}
+
+// identifier quotes name, or returns the default one if name is empty
+func identifier(name, defaultName string) string {
+	if name == "" {
+		return defaultName
+	}
+	return pq.QuoteIdentifier(name)
+}
+
+// selected selects the column under the alias unless the column is named so already
+func selected(column, alias string) string {
+	if column == alias {
+		return column
+	}
+	return column + " AS " + alias
+}
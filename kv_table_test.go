@@ -0,0 +1,62 @@
+package dban
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueQWithTable(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db, WithTable(KeyValueTable{
+		Schema:      "dban",
+		Name:        "cursors",
+		KeyColumn:   "name",
+		ValueColumn: "data",
+	}))
+
+	mock.ExpectQuery(`SELECT "name" AS key, "data" AS value FROM "dban"."cursors" WHERE "name" = $1`).
+		WithArgs("foo").WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("foo", "1"))
+	assert.Equal(t, &KeyValue{Key: "foo", Value: "1"}, kvQ.MustGet("foo"))
+
+	mock.ExpectExec(`INSERT INTO "dban"."cursors" ("name","data") VALUES ($1,$2) `+
+		`ON CONFLICT ("name") DO UPDATE SET "data" = EXCLUDED."data"`).
+		WithArgs("foo", "2").WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "foo", Value: "2"}))
+
+	mock.ExpectQuery(`INSERT INTO "dban"."cursors" ("name","data") VALUES ($1,$2) `+
+		`ON CONFLICT ("name") DO UPDATE SET "data" = ("cursors"."data"::bigint + $3)::text RETURNING "data"::bigint`).
+		WithArgs("foo", "1", 1).WillReturnRows(sqlmock.NewRows([]string{"data"}).AddRow(3))
+	value, err := kvQ.(AtomicCounter).IncrementAndGet("foo", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), value)
+
+	mock.ExpectExec(`UPDATE "dban"."cursors" SET "data" = $1 WHERE "name" = $2 AND "data" = $3`).
+		WithArgs("4", "foo", "3").WillReturnResult(sqlmock.NewResult(0, 1))
+	swapped, err := kvQ.(CompareAndSwapper).UpdateIfEquals("foo", "3", "4")
+	require.NoError(t, err)
+	assert.True(t, swapped)
+
+	assert.Same(t, kvQ.(*keyValueQ).queries, kvQ.New().(*keyValueQ).queries, "New must keep the table")
+}
+
+func TestKeyValueQWithTableSchema(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db, WithTableSchema("dban_test"))
+
+	mock.ExpectQuery(`SELECT key, value FROM "dban_test".key_value WHERE key = $1`).
+		WithArgs("foo").WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("foo", "1"))
+	assert.Equal(t, &KeyValue{Key: "foo", Value: "1"}, kvQ.MustGet("foo"))
+
+	mock.ExpectExec(`INSERT INTO "dban_test".key_value (key,value) VALUES ($1,$2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value`).
+		WithArgs("foo", "2").WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "foo", Value: "2"}))
+}
+
+func TestDefaultKeyValueTable(t *testing.T) {
+	assert.Equal(t, *defaultQueries, *newKVQueries(KeyValueTable{}))
+	assert.Equal(t, "SELECT key, value FROM key_value WHERE key = ?", defaultQueries.get[0][rowLockNone])
+	assert.Equal(t, "UPDATE key_value SET value = ? WHERE key = ? AND value = ?", defaultQueries.updateIfEquals)
+}
@@ -1,10 +1,164 @@
 package dban
 
 import (
+	"database/sql"
+	"io"
 	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+)
+
+const (
+	getSQL          = "SELECT key, value FROM key_value WHERE key = $1"
+	getForUpdateSQL = "SELECT key, value FROM key_value WHERE key = $1 FOR UPDATE"
 )
 
-// TODO: To be implemented
-func TestKV(t *testing.T) {
-	return
+func TestKeyValueQ(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db)
+
+	mock.ExpectExec(upsertSQL).WithArgs("foo", "bar").WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "foo", Value: "bar"}))
+
+	mock.ExpectQuery(getSQL).WithArgs("foo").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("foo", "bar"))
+	assert.Equal(t, &KeyValue{Key: "foo", Value: "bar"}, kvQ.MustGet("foo"))
+
+	_, err := kvQ.LockingGet("foo")
+	assert.True(t, Is(err, ErrNoTransaction), "locking reads outside a transaction must fail")
+
+	txQ := NewKeyValueQ(withMockTx(db))
+	mock.ExpectQuery(getForUpdateSQL).WithArgs("foo").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("foo", "bar"))
+	assert.Equal(t, &KeyValue{Key: "foo", Value: "bar"}, txQ.MustLockingGet("foo"))
+
+	mock.ExpectQuery(getSQL).WithArgs("missing").WillReturnError(sql.ErrNoRows)
+	kv, err := kvQ.Get("missing")
+	require.NoError(t, err)
+	assert.Nil(t, kv)
+
+	mock.ExpectQuery(getForUpdateSQL).WithArgs("foo").WillReturnError(sql.ErrConnDone)
+	assert.Panics(t, func() { txQ.MustLockingGet("foo") })
+
+	compatQ := NewKeyValueQ(db, WithLockingOutsideTx(logan.New().Out(io.Discard)))
+	mock.ExpectQuery(getForUpdateSQL).WithArgs("foo").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("foo", "bar"))
+	assert.Equal(t, &KeyValue{Key: "foo", Value: "bar"}, compatQ.MustLockingGet("foo"))
+}
+
+func BenchmarkGetQuery(b *testing.B) {
+	expectGet := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(getSQL).WithArgs("cursor").
+			WillReturnRows(sqlmock.NewRows([]string{keyColumn, valueColumn}).AddRow("cursor", "1"))
+	}
+
+	b.Run("squirrel", func(b *testing.B) {
+		benchMockDB(b, expectGet, func(db *pgdb.DB) func() error {
+			return func() error {
+				var value KeyValue
+				return db.Get(&value, keyValueSelect.Where(squirrel.Eq{keyColumn: "cursor"}))
+			}
+		})
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		benchMockDB(b, expectGet, func(db *pgdb.DB) func() error {
+			kvQ := NewKeyValueQ(db)
+			return func() error {
+				_, err := kvQ.Get("cursor")
+				return err
+			}
+		})
+	})
+}
+
+func TestUpsertQuery(t *testing.T) {
+	// the query Upsert used to build from the struct with reflection
+	assert.Equal(t, "INSERT INTO key_value (key,value) VALUES (?,?) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value", defaultQueries.upsert[0])
+}
+
+func BenchmarkUpsertQuery(b *testing.B) {
+	kv := KeyValue{Key: "cursor", Value: "1"}
+	expectUpsert := func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec(upsertSQL).WithArgs(kv.Key, kv.Value).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	b.Run("squirrel", func(b *testing.B) {
+		benchMockDB(b, expectUpsert, func(db *pgdb.DB) func() error {
+			return func() error {
+				return db.Exec(squirrel.Insert(keyValueTable).
+					SetMap(map[string]interface{}{keyColumn: kv.Key, valueColumn: kv.Value}).
+					Suffix("ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value"))
+			}
+		})
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		benchMockDB(b, expectUpsert, func(db *pgdb.DB) func() error {
+			kvQ := NewKeyValueQ(db)
+			return func() error { return kvQ.Upsert(kv) }
+		})
+	})
+}
+
+type eventSinkFunc func(Event)
+
+func (f eventSinkFunc) Emit(event Event) { f(event) }
+
+func TestKeyValueQEvents(t *testing.T) {
+	db, mock := newMockDB(t)
+	events := make(chan Event, 1)
+	sink := NewAsyncEventSink(eventSinkFunc(func(event Event) { events <- event }), 1)
+	kvQ := NewKeyValueQ(db, WithEventSink(sink))
+
+	mock.ExpectExec(upsertSQL).WithArgs("foo", "bar").WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "foo", Value: "bar"}))
+	mock.ExpectExec(upsertSQL).WithArgs("foo", "baz").WillReturnError(sql.ErrConnDone)
+	require.Error(t, kvQ.Upsert(KeyValue{Key: "foo", Value: "baz"}))
+	sink.Close()
+
+	assert.Equal(t, KVWritten{Key: "foo"}, <-events)
+	assert.Empty(t, events, "failed writes must not be reported")
+}
+
+func TestKeyValueQDelete(t *testing.T) {
+	const (
+		deleteSQL     = "DELETE FROM key_value WHERE key = $1 RETURNING key"
+		deleteManySQL = "DELETE FROM key_value WHERE key = ANY($1) RETURNING key"
+	)
+	db, mock := newMockDB(t)
+	events := make(chan Event, 2)
+	sink := NewAsyncEventSink(eventSinkFunc(func(event Event) { events <- event }), 2)
+	kvQ := NewKeyValueQ(db, WithEventSink(sink))
+
+	mock.ExpectQuery(deleteSQL).WithArgs("foo").WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("foo"))
+	require.NoError(t, kvQ.Delete("foo"))
+	mock.ExpectQuery(deleteSQL).WithArgs("missing").WillReturnRows(sqlmock.NewRows([]string{"key"}))
+	require.NoError(t, kvQ.Delete("missing"), "deleting a missing key is not an error")
+
+	mock.ExpectQuery(deleteManySQL).WithArgs(pq.Array([]string{"bar", "missing"})).
+		WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("bar"))
+	deleted, err := kvQ.DeleteMany([]string{"bar", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	deleted, err = kvQ.DeleteMany(nil)
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+
+	_, err = kvQ.DeleteMany([]string{"baz", " "})
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, []string{" "}, batchErr.Keys())
+	assert.True(t, Is(err, ErrInvalidKey))
+
+	sink.Close()
+	assert.Equal(t, KVDeleted{Key: "foo"}, <-events)
+	assert.Equal(t, KVDeleted{Key: "bar"}, <-events)
 }
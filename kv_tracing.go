@@ -0,0 +1,272 @@
+package dban
+
+import (
+	"context"
+	"unicode/utf8"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxSpanKeyLength is the number of bytes of a key recorded on a span, so that long or
+// user-provided keys do not bloat traces
+const maxSpanKeyLength = 128
+
+// Attributes of the spans of traced queriers and streamers
+const (
+	attrKey       = attribute.Key("dban.key")
+	attrKeysCount = attribute.Key("dban.keys.count")
+	attrPage      = attribute.Key("dban.page")
+	attrEntities  = attribute.Key("dban.entities")
+	attrIndex     = attribute.Key("dban.entity.index")
+)
+
+// spanKey returns the attribute of the key truncated to maxSpanKeyLength bytes on a rune boundary
+func spanKey(key string) attribute.KeyValue {
+	if len(key) > maxSpanKeyLength {
+		cut := maxSpanKeyLength
+		for cut > 0 && !utf8.RuneStart(key[cut]) {
+			cut--
+		}
+		key = key[:cut]
+	}
+	return attrKey.String(key)
+}
+
+// endSpan records err on the span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+type tracedKeyValueQ struct {
+	inner  KeyValueQ
+	tracer trace.Tracer
+	ctx    context.Context
+}
+
+var _ KeyValueQCtx = (*tracedKeyValueQ)(nil)
+
+// NewTracedKeyValueQ creates a querier opening a span with the tracer for every operation
+// of inner, recording the key (truncated) and the error. Spans are children of the span of
+// the context the querier is bound to with WithContext, which is passed to inner as well
+func NewTracedKeyValueQ(inner KeyValueQ, tracer trace.Tracer) KeyValueQ {
+	return &tracedKeyValueQ{
+		inner:  inner,
+		tracer: tracer,
+		ctx:    context.Background(),
+	}
+}
+
+func (q *tracedKeyValueQ) New() KeyValueQ {
+	return &tracedKeyValueQ{
+		inner:  q.inner.New(),
+		tracer: q.tracer,
+		ctx:    q.ctx,
+	}
+}
+
+// traced calls op within a span of the operation, recording attr unless it is the zero one
+func traced[T any](q *tracedKeyValueQ, operation string, attr attribute.KeyValue, op func() (T, error)) (T, error) {
+	opts := []trace.SpanStartOption{trace.WithSpanKind(trace.SpanKindClient)}
+	if attr.Valid() {
+		opts = append(opts, trace.WithAttributes(attr))
+	}
+	_, span := q.tracer.Start(q.ctx, "dban.kv."+operation, opts...)
+	value, err := op()
+	endSpan(span, err)
+	return value, err
+}
+
+// tracedErr is traced of an operation returning an error only
+func tracedErr(q *tracedKeyValueQ, operation string, attr attribute.KeyValue, op func() error) error {
+	_, err := traced(q, operation, attr, func() (struct{}, error) {
+		return struct{}{}, op()
+	})
+	return err
+}
+
+func (q *tracedKeyValueQ) Get(key string) (*KeyValue, error) {
+	return traced(q, "get", spanKey(key), func() (*KeyValue, error) {
+		return q.inner.Get(key)
+	})
+}
+
+func (q *tracedKeyValueQ) GetMany(keys []string) (map[string]KeyValue, error) {
+	return traced(q, "get_many", attrKeysCount.Int(len(keys)), func() (map[string]KeyValue, error) {
+		return q.inner.GetMany(keys)
+	})
+}
+
+func (q *tracedKeyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return traced(q, "select_by_prefix", spanKey(prefix), func() ([]KeyValue, error) {
+		return q.inner.SelectByPrefix(prefix, params)
+	})
+}
+
+func (q *tracedKeyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	return traced(q, "list_keys", spanKey(prefix), func() ([]string, error) {
+		return q.inner.ListKeys(prefix, params)
+	})
+}
+
+func (q *tracedKeyValueQ) Count() (uint64, error) {
+	return traced(q, "count", attribute.KeyValue{}, q.inner.Count)
+}
+
+func (q *tracedKeyValueQ) FilterByKeys(keys ...string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByKeys(keys...)
+	return &filtered
+}
+
+func (q *tracedKeyValueQ) FilterByPrefix(prefix string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByPrefix(prefix)
+	return &filtered
+}
+
+func (q *tracedKeyValueQ) FilterByValueLike(pattern string) KeyValueQ {
+	filtered := *q
+	filtered.inner = q.inner.FilterByValueLike(pattern)
+	return &filtered
+}
+
+func (q *tracedKeyValueQ) Select() ([]KeyValue, error) {
+	return traced(q, "select", attribute.KeyValue{}, q.inner.Select)
+}
+
+func (q *tracedKeyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return traced(q, "select_page", attribute.KeyValue{}, func() ([]KeyValue, error) {
+		return q.inner.SelectPage(params)
+	})
+}
+
+func (q *tracedKeyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.SelectPage(KeyValueStreamParams(params))
+}
+
+func (q *tracedKeyValueQ) MustGet(key string) *KeyValue {
+	value, err := GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+func (q *tracedKeyValueQ) Upsert(kv KeyValue) error {
+	return tracedErr(q, "upsert", spanKey(kv.Key), func() error {
+		return q.inner.Upsert(kv)
+	})
+}
+
+func (q *tracedKeyValueQ) UpsertMany(kvs []KeyValue) error {
+	return tracedErr(q, "upsert_many", attrKeysCount.Int(len(kvs)), func() error {
+		return q.inner.UpsertMany(kvs)
+	})
+}
+
+func (q *tracedKeyValueQ) LockingGet(key string) (*KeyValue, error) {
+	return traced(q, "locking_get", spanKey(key), func() (*KeyValue, error) {
+		return q.inner.LockingGet(key)
+	})
+}
+
+func (q *tracedKeyValueQ) MustLockingGet(key string) *KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
+
+func (q *tracedKeyValueQ) Delete(key string) error {
+	return tracedErr(q, "delete", spanKey(key), func() error {
+		return q.inner.Delete(key)
+	})
+}
+
+func (q *tracedKeyValueQ) DeleteMany(keys []string) (int64, error) {
+	return traced(q, "delete_many", attrKeysCount.Int(len(keys)), func() (int64, error) {
+		return q.inner.DeleteMany(keys)
+	})
+}
+
+func (q *tracedKeyValueQ) Update(key string, fn func(current *string) (string, error)) (KeyValue, error) {
+	return traced(q, "update", spanKey(key), func() (KeyValue, error) {
+		return q.inner.Update(key, fn)
+	})
+}
+
+// Transaction runs fn within a span of the transaction, whose operations are its children
+func (q *tracedKeyValueQ) Transaction(fn func(q KeyValueQ) error) error {
+	ctx, span := q.tracer.Start(q.ctx, "dban.kv.transaction")
+	err := transaction(withContext(q.inner, ctx), func(inner KeyValueQ) error {
+		return fn(&tracedKeyValueQ{inner: inner, tracer: q.tracer, ctx: ctx})
+	})
+	endSpan(span, err)
+	return err
+}
+
+func (q *tracedKeyValueQ) WithinTx() bool {
+	return withinTx(q.inner)
+}
+
+func (q *tracedKeyValueQ) WithContext(ctx context.Context) KeyValueQ {
+	return q.withContext(ctx)
+}
+
+func (q *tracedKeyValueQ) withContext(ctx context.Context) *tracedKeyValueQ {
+	return &tracedKeyValueQ{
+		inner:  withContext(q.inner, ctx),
+		tracer: q.tracer,
+		ctx:    ctx,
+	}
+}
+
+func (q *tracedKeyValueQ) GetCtx(ctx context.Context, key string) (*KeyValue, error) {
+	return q.withContext(ctx).Get(key)
+}
+
+func (q *tracedKeyValueQ) GetManyCtx(ctx context.Context, keys []string) (map[string]KeyValue, error) {
+	return q.withContext(ctx).GetMany(keys)
+}
+
+func (q *tracedKeyValueQ) SelectByPrefixCtx(ctx context.Context, prefix string, params pgdb.OffsetPageParams) ([]KeyValue, error) {
+	return q.withContext(ctx).SelectByPrefix(prefix, params)
+}
+
+func (q *tracedKeyValueQ) ListKeysCtx(ctx context.Context, prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	return q.withContext(ctx).ListKeys(prefix, params)
+}
+
+func (q *tracedKeyValueQ) CountCtx(ctx context.Context) (uint64, error) {
+	return q.withContext(ctx).Count()
+}
+
+func (q *tracedKeyValueQ) LockingGetCtx(ctx context.Context, key string) (*KeyValue, error) {
+	return q.withContext(ctx).LockingGet(key)
+}
+
+func (q *tracedKeyValueQ) UpsertCtx(ctx context.Context, kv KeyValue) error {
+	return q.withContext(ctx).Upsert(kv)
+}
+
+func (q *tracedKeyValueQ) UpsertManyCtx(ctx context.Context, kvs []KeyValue) error {
+	return q.withContext(ctx).UpsertMany(kvs)
+}
+
+func (q *tracedKeyValueQ) DeleteCtx(ctx context.Context, key string) error {
+	return q.withContext(ctx).Delete(key)
+}
+
+func (q *tracedKeyValueQ) DeleteManyCtx(ctx context.Context, keys []string) (int64, error) {
+	return q.withContext(ctx).DeleteMany(keys)
+}
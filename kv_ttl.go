@@ -0,0 +1,94 @@
+package dban
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// ExpiringKeyValueQ is a key value querier able to write values expiring after a while
+type ExpiringKeyValueQ interface {
+	// UpsertWithTTL does the same thing as Upsert, but the value expires after ttl, i.e.
+	// reads treat it as missing
+	UpsertWithTTL(kv KeyValue, ttl time.Duration) error
+	// DeleteExpired deletes the expired values and returns the number of them
+	DeleteExpired() (int64, error)
+}
+
+var _ ExpiringKeyValueQ = (*keyValueQ)(nil)
+
+const expiresAtColumn = "expires_at"
+
+// WithTTL makes the querier support ExpiringKeyValueQ: reads treat expired values as
+// missing and Upsert clears the expiry of the value. So do Increment and AdvanceCursor,
+// counting expired values from 0 as they do missing ones. Requires FeatureTTL
+func WithTTL() KeyValueQOption {
+	return func(q *keyValueQ) {
+		q.ttl = true
+	}
+}
+
+func (q *keyValueQ) UpsertWithTTL(kv KeyValue, ttl time.Duration) error {
+	fields := logan.F{"key": kv.Key, "ttl": ttl}
+	if !q.ttl {
+		return errors.From(errors.New("querier does not support TTL, see WithTTL"), fields)
+	}
+	if ttl <= 0 {
+		return errors.From(errors.New("ttl must be positive"), fields)
+	}
+	if err := q.validate(kv); err != nil {
+		return err
+	}
+
+	err := q.retryWrite(kv.Key, func(q *keyValueQ) error {
+		return q.db.ExecRawContext(q.ctx, q.queries.upsertWithTTL[q.resets()], kv.Key, kv.Value, ttl.Seconds())
+	})
+	countKV(kvVarUpsert, err)
+	if err != nil {
+		return errors.Wrap(q.classify(err), "failed to upsert value with ttl", fields)
+	}
+
+	if q.events != nil {
+		q.events.Emit(KVWritten{Key: kv.Key})
+	}
+	return nil
+}
+
+func (q *keyValueQ) DeleteExpired() (int64, error) {
+	var result sql.Result
+	err := q.audited(func(q *keyValueQ) (err error) {
+		result, err = q.db.ExecWithResultContext(q.ctx, squirrel.Expr(q.queries.deleteExpired))
+		return err
+	})
+	countKV(kvVarDelete, err)
+	if err != nil {
+		return 0, errors.Wrap(q.classify(err), "failed to delete expired values")
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get number of deleted values")
+	}
+	return deleted, nil
+}
+
+// live filters out expired values if the querier supports TTL, and soft deleted ones if it
+// soft deletes (see WithSoftDelete)
+func (q *keyValueQ) live(query squirrel.SelectBuilder) squirrel.SelectBuilder {
+	return q.queries.visible(query, q.hides())
+}
+
+// hides returns the rows reads of the querier treat as missing
+func (q *keyValueQ) hides() hides {
+	var hide hides
+	if q.ttl {
+		hide |= hideExpired
+	}
+	if q.softDelete && !q.withDeleted {
+		hide |= hideDeleted
+	}
+	return hide
+}
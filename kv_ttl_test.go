@@ -0,0 +1,75 @@
+package dban
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueQTTL(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db, WithTTL())
+	expiring := kvQ.(ExpiringKeyValueQ)
+
+	mock.ExpectExec("INSERT INTO key_value (key,value,expires_at) VALUES ($1,$2,now() + $3 * interval '1 second') "+
+		"ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at").
+		WithArgs("lock", "owner", float64(30)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, expiring.UpsertWithTTL(KeyValue{Key: "lock", Value: "owner"}, 30*time.Second))
+	assert.Error(t, expiring.UpsertWithTTL(KeyValue{Key: "lock", Value: "owner"}, 0))
+
+	mock.ExpectQuery("SELECT key, value FROM key_value WHERE key = $1 AND (expires_at IS NULL OR expires_at > now())").
+		WithArgs("lock").
+		WillReturnError(sql.ErrNoRows)
+	kv, err := kvQ.Get("lock")
+	require.NoError(t, err)
+	assert.Nil(t, kv, "expired values must be read as missing")
+
+	mock.ExpectExec("INSERT INTO key_value (key,value) VALUES ($1,$2) "+
+		"ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = NULL").
+		WithArgs("lock", "forever").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "lock", Value: "forever"}))
+
+	mock.ExpectExec("DELETE FROM key_value WHERE expires_at <= now()").WillReturnResult(sqlmock.NewResult(0, 3))
+	deleted, err := expiring.DeleteExpired()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), deleted)
+
+	err = NewKeyValueQ(db).(ExpiringKeyValueQ).UpsertWithTTL(KeyValue{Key: "lock", Value: "owner"}, time.Second)
+	assert.Error(t, err, "TTL must be enabled explicitly, as it requires FeatureTTL")
+}
+
+func TestKeyValueQTTLPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db, WithFeatures(FeatureTTL))
+	kvQ := NewKeyValueQ(db, WithTTL())
+	expiring := kvQ.(ExpiringKeyValueQ)
+
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "cursor", Value: "1"}))
+	require.NoError(t, expiring.UpsertWithTTL(KeyValue{Key: "marker", Value: "1"}, time.Millisecond))
+	require.NoError(t, expiring.UpsertWithTTL(KeyValue{Key: "lock", Value: "1"}, time.Hour))
+	time.Sleep(10 * time.Millisecond)
+
+	values, err := kvQ.GetMany([]string{"cursor", "marker", "lock"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]KeyValue{
+		"cursor": {Key: "cursor", Value: "1"},
+		"lock":   {Key: "lock", Value: "1"},
+	}, values)
+
+	require.NoError(t, expiring.UpsertWithTTL(KeyValue{Key: "counter", Value: "5"}, time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	counted, err := kvQ.(AtomicCounter).IncrementAndGet("counter", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), counted, "expired values must be counted from 0")
+	assert.Equal(t, "1", kvQ.MustGet("counter").Value)
+
+	deleted, err := expiring.DeleteExpired()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+}
@@ -0,0 +1,190 @@
+package dban
+
+import (
+	"database/sql"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+var _ TransactionalKeyValueQ = (*keyValueQ)(nil)
+
+// TransactionalKeyValueQ is a key value querier able to run a function in a transaction,
+// which makes locks taken by LockingGet last until the function returns
+type TransactionalKeyValueQ interface {
+	KeyValueQ
+	// Transaction runs fn with a querier bound to a transaction that is committed if fn
+	// returns nil and rolled back otherwise. Calls made within a transaction join it
+	Transaction(fn func(q KeyValueQ) error) error
+	// WithinTx reports whether the querier is bound to a transaction
+	WithinTx() bool
+}
+
+// WithLockingOutsideTx makes LockingGet called outside a transaction warn to log instead of
+// failing with ErrNoTransaction. It keeps code relying on the old behavior working, although
+// the row lock is released right after such a read
+func WithLockingOutsideTx(log *logan.Entry) KeyValueQOption {
+	return func(q *keyValueQ) {
+		q.lockingOutsideTxLog = log
+	}
+}
+
+// serializableTxAttempts is a number of attempts to run a serializable transaction
+// failing on serialization failures
+const serializableTxAttempts = 3
+
+// WithIsolationLevel sets an isolation level of transactions opened by Transaction, and
+// therefore by the streamer. Serializable transactions failed on a serialization failure
+// or a deadlock are run again, up to 3 times in total. It panics on levels Postgres
+// does not support (see ValidateIsolationLevel)
+func WithIsolationLevel(level sql.IsolationLevel) KeyValueQOption {
+	if err := ValidateIsolationLevel(level); err != nil {
+		panic(err)
+	}
+
+	return func(q *keyValueQ) {
+		q.isolation = level
+	}
+}
+
+// WithLockTimeout sets lock_timeout for transactions opened by Transaction, so that
+// LockingGet waiting for a lock longer than d fails with ErrLockTimeout
+func WithLockTimeout(d time.Duration) KeyValueQOption {
+	return func(q *keyValueQ) {
+		q.lockTimeout = d
+	}
+}
+
+// WithStatementTimeout sets statement_timeout for transactions opened by Transaction,
+// so that statements running longer than d fail with ErrStatementTimeout
+func WithStatementTimeout(d time.Duration) KeyValueQOption {
+	return func(q *keyValueQ) {
+		q.statementTimeout = d
+	}
+}
+
+// setConfigQuery sets a setting until the end of the current transaction. Unlike SET LOCAL,
+// set_config accepts the value as a parameter
+const setConfigQuery = "SELECT set_config(?, ?, true)"
+
+// setTimeouts applies the configured timeouts to the current transaction
+func (q *keyValueQ) setTimeouts() error {
+	for _, timeout := range []struct {
+		setting string
+		value   time.Duration
+	}{
+		{setting: "lock_timeout", value: q.lockTimeout},
+		{setting: "statement_timeout", value: q.statementTimeout},
+	} {
+		if timeout.value <= 0 {
+			continue
+		}
+
+		// zero disables the timeout, so shorter ones are rounded up to a millisecond
+		ms := timeout.value.Milliseconds()
+		if ms == 0 {
+			ms = 1
+		}
+		if err := q.db.ExecRawContext(q.ctx, setConfigQuery, timeout.setting, strconv.FormatInt(ms, 10)+"ms"); err != nil {
+			return errors.Wrap(err, "failed to set timeout", logan.F{"setting": timeout.setting})
+		}
+	}
+	return nil
+}
+
+// ValidateIsolationLevel checks that Postgres supports the isolation level
+func ValidateIsolationLevel(level sql.IsolationLevel) error {
+	switch level {
+	case sql.LevelDefault, sql.LevelReadUncommitted, sql.LevelReadCommitted, sql.LevelRepeatableRead, sql.LevelSerializable:
+		return nil
+	default:
+		return errors.From(errors.New("isolation level is not supported"), logan.F{"level": level.String()})
+	}
+}
+
+func (q *keyValueQ) Transaction(fn func(q KeyValueQ) error) error {
+	if q.WithinTx() {
+		return fn(q)
+	}
+
+	return q.retryTx(func() error {
+		// pgdb binds its queryer to the transaction in place, so a clone is used to keep
+		// other users of q out of it
+		tx := q.New().(*keyValueQ)
+		return tx.db.TransactionWithOptions(&sql.TxOptions{Isolation: q.isolation}, func() error {
+			if err := tx.setTimeouts(); err != nil {
+				return err
+			}
+			return fn(tx)
+		})
+	})
+}
+
+// serializableTxRetry retries serializable transactions failed on a serialization failure
+// or a deadlock only, as a transaction whose connection failed could have been committed
+var serializableTxRetry = RetryConfig{
+	MaxAttempts: serializableTxAttempts,
+	BaseDelay:   writeRetryBaseDelay,
+	IsTransient: isWriteConflict,
+}.withDefaults()
+
+// retryTx runs the transaction again if it is serializable and fails on a serialization
+// failure or a deadlock
+func (q *keyValueQ) retryTx(run func() error) error {
+	if q.isolation != sql.LevelSerializable {
+		return run()
+	}
+	return serializableTxRetry.retry(run, func(int, error) {
+		kvVars.Add(kvVarRetries, 1)
+	})
+}
+
+// WithinTx reports whether the querier is bound to a transaction. pgdb does not expose it,
+// so the connection its queryer wraps is checked; queryers of other types could report it
+// by implementing InTransaction() bool
+func (q *keyValueQ) WithinTx() bool {
+	if queryer, ok := q.db.Queryer.(interface{ InTransaction() bool }); ok {
+		return queryer.InTransaction()
+	}
+
+	queryer := reflect.ValueOf(q.db.Queryer)
+	if queryer.Kind() != reflect.Ptr || queryer.Elem().Kind() != reflect.Struct {
+		return false
+	}
+	raw := queryer.Elem().FieldByName("raw")
+	if !raw.IsValid() || raw.Kind() != reflect.Interface || raw.IsNil() {
+		return false
+	}
+	return raw.Elem().Type() == reflect.TypeOf((*sqlx.Tx)(nil))
+}
+
+// checkTx fails LockingGet called outside a transaction, as the lock it takes would be
+// released right away
+func (q *keyValueQ) checkTx(key string) error {
+	if q.WithinTx() {
+		return nil
+	}
+	if q.lockingOutsideTxLog != nil {
+		q.lockingOutsideTxLog.WithField("key", key).Warn("Locking read outside a transaction, the lock is released right away")
+		return nil
+	}
+	return errors.From(ErrNoTransaction, logan.F{"key": key})
+}
+
+// transaction runs fn in a transaction of q if it supports them, or just with q otherwise
+func transaction(q KeyValueQ, fn func(q KeyValueQ) error) error {
+	if txQ, ok := q.(TransactionalKeyValueQ); ok {
+		return txQ.Transaction(fn)
+	}
+	return fn(q)
+}
+
+// withinTx reports whether q is bound to a transaction
+func withinTx(q KeyValueQ) bool {
+	txQ, ok := q.(TransactionalKeyValueQ)
+	return ok && txQ.WithinTx()
+}
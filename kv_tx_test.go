@@ -0,0 +1,208 @@
+package dban
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+type rangeStreamable struct {
+	size uint64
+}
+
+func (s rangeStreamable) SelectWithPageParams(params pgdb.OffsetPageParams) ([]uint64, error) {
+	var items []uint64
+	for i := params.PageNumber * params.Limit; i < s.size && uint64(len(items)) < params.Limit; i++ {
+		items = append(items, i)
+	}
+	return items, nil
+}
+
+func TestKeyValueQTransaction(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db)
+	kvQ := NewKeyValueQ(db).(TransactionalKeyValueQ)
+
+	assert.False(t, kvQ.WithinTx())
+	_, err := kvQ.LockingGet("cursor")
+	assert.True(t, Is(err, ErrNoTransaction))
+
+	failure := errors.New("rolled back")
+	err = kvQ.Transaction(func(q KeyValueQ) error {
+		assert.True(t, q.(TransactionalKeyValueQ).WithinTx())
+		require.NoError(t, q.Upsert(KeyValue{Key: "cursor", Value: "1"}))
+		return failure
+	})
+	assert.Equal(t, failure, errors.Cause(err))
+	_, err = GetStrict(kvQ, "cursor")
+	assert.True(t, Is(err, ErrNoSuchKey), "writes of a failed transaction must be rolled back")
+
+	err = kvQ.Transaction(func(outer KeyValueQ) error {
+		require.NoError(t, outer.Upsert(KeyValue{Key: "cursor", Value: "1"}))
+		return outer.(TransactionalKeyValueQ).Transaction(func(inner KeyValueQ) error {
+			assert.Same(t, outer, inner, "a nested call must join the outer transaction")
+			return failure
+		})
+	})
+	assert.Equal(t, failure, errors.Cause(err))
+	_, err = GetStrict(kvQ, "cursor")
+	assert.True(t, Is(err, ErrNoSuchKey), "a failed nested call must roll the outer transaction back")
+
+	t.Run("concurrent streamers", func(t *testing.T) {
+		const (
+			workers = 4
+			pages   = 10
+		)
+		require.NoError(t, kvQ.Upsert(KeyValue{Key: "cursor", Value: "0"}))
+
+		var (
+			wg    sync.WaitGroup
+			mu    sync.Mutex
+			items []uint64
+		)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				batchSize := uint64(3)
+				streamer := NewStreamer[uint64](StreamerInitParams[uint64]{
+					Stream:      rangeStreamable{size: workers * pages * batchSize},
+					KeyValueQ:   kvQ.New(),
+					KeyValueKey: "cursor",
+					BatchSize:   &batchSize,
+				})
+				for i := 0; i < pages; i++ {
+					list, err := streamer.FormList()
+					if !assert.NoError(t, err) {
+						return
+					}
+					mu.Lock()
+					items = append(items, list...)
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		sort.Slice(items, func(i, j int) bool { return items[i] < items[j] })
+		require.Len(t, items, workers*pages*3, "pages must not be handed out twice")
+		for i, item := range items {
+			require.Equal(t, uint64(i), item, "pages must not be skipped")
+		}
+	})
+}
+
+func TestKeyValueQIsolationLevel(t *testing.T) {
+	assert.Panics(t, func() { WithIsolationLevel(sql.LevelSnapshot) })
+	assert.NoError(t, ValidateIsolationLevel(sql.LevelRepeatableRead))
+
+	noRetrySleep(t)
+
+	conflict := pkgerrors.Wrap(&pq.Error{Code: "40001"}, "failed to commit tx")
+	scripted := func(errs ...error) (func() error, *int) {
+		runs := 0
+		return func() error {
+			runs++
+			if len(errs) == 0 {
+				return nil
+			}
+			err := errs[0]
+			errs = errs[1:]
+			return err
+		}, &runs
+	}
+
+	serializable := NewKeyValueQ(nil, WithIsolationLevel(sql.LevelSerializable)).(*keyValueQ)
+	run, runs := scripted(conflict, conflict)
+	assert.NoError(t, serializable.retryTx(run))
+	assert.Equal(t, 3, *runs, "the whole transaction must be run again")
+
+	run, runs = scripted(conflict, conflict, conflict)
+	assert.Equal(t, conflict, serializable.retryTx(run))
+	assert.Equal(t, serializableTxAttempts, *runs)
+
+	run, runs = scripted(errors.New("plain"))
+	assert.Error(t, serializable.retryTx(run))
+	assert.Equal(t, 1, *runs)
+
+	repeatable := NewKeyValueQ(nil, WithIsolationLevel(sql.LevelRepeatableRead)).(*keyValueQ)
+	run, runs = scripted(conflict)
+	assert.Equal(t, conflict, repeatable.retryTx(run))
+	assert.Equal(t, 1, *runs, "only serializable transactions are retried")
+}
+
+func TestKeyValueQIsolationLevelPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db)
+
+	for level, expected := range map[sql.IsolationLevel]string{
+		sql.LevelDefault:        "read committed",
+		sql.LevelRepeatableRead: "repeatable read",
+		sql.LevelSerializable:   "serializable",
+	} {
+		kvQ := NewKeyValueQ(db, WithIsolationLevel(level)).(TransactionalKeyValueQ)
+		require.NoError(t, kvQ.Transaction(func(q KeyValueQ) error {
+			var actual string
+			require.NoError(t, q.(*keyValueQ).db.GetRaw(&actual, "SHOW transaction_isolation"))
+			assert.Equal(t, expected, actual)
+			return nil
+		}))
+	}
+}
+
+func TestKeyValueQTimeouts(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(withMockTx(db), WithLockTimeout(1500*time.Millisecond), WithStatementTimeout(time.Microsecond)).(*keyValueQ)
+
+	mock.ExpectExec("SELECT set_config($1, $2, true)").WithArgs("lock_timeout", "1500ms").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SELECT set_config($1, $2, true)").WithArgs("statement_timeout", "1ms").WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, kvQ.setTimeouts())
+
+	require.NoError(t, NewKeyValueQ(withMockTx(db)).(*keyValueQ).setTimeouts(), "no timeouts must be set by default")
+}
+
+func TestKeyValueQLockTimeoutPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db)
+	require.NoError(t, NewKeyValueQ(db).Upsert(KeyValue{Key: "cursor", Value: "0"}))
+
+	// hold the lock from another connection
+	holder, err := db.RawDB().Begin()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = holder.Rollback() })
+	_, err = holder.Exec("SELECT value FROM key_value WHERE key = 'cursor' FOR UPDATE")
+	require.NoError(t, err)
+
+	kvQ := NewKeyValueQ(db, WithLockTimeout(200*time.Millisecond)).(TransactionalKeyValueQ)
+	started := time.Now()
+	err = kvQ.Transaction(func(q KeyValueQ) error {
+		_, err := q.LockingGet("cursor")
+		return err
+	})
+	assert.Less(t, time.Since(started), 2*time.Second)
+	assert.True(t, Is(err, ErrLockTimeout), "%v", err)
+	assert.True(t, IsRetryable(err))
+}
+
+func TestKeyValueQNestedTransaction(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(withMockTx(db)).(TransactionalKeyValueQ)
+
+	// no BEGIN is expected, the nested call must run within the transaction it is made in
+	mock.ExpectExec(upsertSQL).WithArgs("cursor", "1").WillReturnResult(sqlmock.NewResult(0, 1))
+	err := kvQ.Transaction(func(q KeyValueQ) error {
+		assert.Same(t, kvQ, q)
+		return q.Upsert(KeyValue{Key: "cursor", Value: "1"})
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,196 @@
+package dban
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// GetUint64 gets the value by the key parsed as an unsigned integer, or nil if there is none
+func GetUint64(q KeyValueQ, key string) (*uint64, error) {
+	var value uint64
+	found, err := getParsed(q, key, func(raw string) (err error) {
+		value, err = strconv.ParseUint(raw, 10, 64)
+		return err
+	})
+	if !found || err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
+// GetBool gets the value by the key parsed with strconv.ParseBool, or nil if there is none
+func GetBool(q KeyValueQ, key string) (*bool, error) {
+	var value bool
+	found, err := getParsed(q, key, func(raw string) (err error) {
+		value, err = strconv.ParseBool(raw)
+		return err
+	})
+	if !found || err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
+// GetTime gets the value by the key parsed with the layout, or nil if there is none
+func GetTime(q KeyValueQ, key, layout string) (*time.Time, error) {
+	var value time.Time
+	found, err := getParsed(q, key, func(raw string) (err error) {
+		value, err = time.Parse(layout, raw)
+		return err
+	})
+	if !found || err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
+// GetJSON unmarshals the value by the key into dst and reports whether there is one
+func GetJSON(q KeyValueQ, key string, dst interface{}) (bool, error) {
+	return getParsed(q, key, func(raw string) error {
+		return json.Unmarshal([]byte(raw), dst)
+	})
+}
+
+// getParsed gets the value by the key and parses it, reporting whether there is one
+func getParsed(q KeyValueQ, key string, parse func(raw string) error) (bool, error) {
+	kv, err := q.Get(key)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get value", logan.F{"key": key})
+	}
+	if kv == nil {
+		return false, nil
+	}
+
+	if err = parse(kv.Value); err != nil {
+		return false, errors.Wrap(err, "failed to parse value", logan.F{
+			"key":   key,
+			"value": kv.Value,
+		})
+	}
+	return true, nil
+}
+
+// Codec encodes values of T into the strings they are stored as and decodes them back
+type Codec[T any] interface {
+	Encode(value T) (string, error)
+	Decode(raw string) (T, error)
+}
+
+type jsonCodec[T any] struct{}
+
+// JSONCodec returns the codec storing values of T as JSON
+func JSONCodec[T any]() Codec[T] {
+	return jsonCodec[T]{}
+}
+
+func (jsonCodec[T]) Encode(value T) (string, error) {
+	raw, err := json.Marshal(value)
+	return string(raw), err
+}
+
+func (jsonCodec[T]) Decode(raw string) (T, error) {
+	var value T
+	err := json.Unmarshal([]byte(raw), &value)
+	return value, err
+}
+
+// DecodeError is returned by TypedKV when a stored value cannot be decoded
+type DecodeError struct {
+	Key string
+	// Value is the raw stored value
+	Value string
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode value by key %q: %s", e.Key, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// TypedKV stores values of T in a key value storage, encoding them with a codec, so that
+// callers do not serialize them on their own. The table is the same as for plain values
+type TypedKV[T any] struct {
+	q     KeyValueQ
+	codec Codec[T]
+}
+
+// NewTypedKV creates a typed wrapper of q. A nil codec is JSONCodec
+func NewTypedKV[T any](q KeyValueQ, codec Codec[T]) *TypedKV[T] {
+	if codec == nil {
+		codec = JSONCodec[T]()
+	}
+	return &TypedKV[T]{q: q, codec: codec}
+}
+
+// Get gets the value by the key, or nil if there is none. A value that cannot be decoded
+// fails with DecodeError
+func (kv *TypedKV[T]) Get(key string) (*T, error) {
+	stored, err := kv.q.Get(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get value", logan.F{"key": key})
+	}
+	if stored == nil {
+		return nil, nil
+	}
+
+	value, err := kv.decode(*stored)
+	if err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
+// Set encodes the value and upserts it by the key
+func (kv *TypedKV[T]) Set(key string, value T) error {
+	raw, err := kv.codec.Encode(value)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode value", logan.F{"key": key})
+	}
+	return errors.Wrap(kv.q.Upsert(KeyValue{Key: key, Value: raw}), "failed to upsert value", logan.F{"key": key})
+}
+
+// GetOrSet gets the value by the key, writing defaultValue first if there is none. It does
+// not race with other instances doing the same only if the querier is a GetOrSetter
+func (kv *TypedKV[T]) GetOrSet(key string, defaultValue T) (T, error) {
+	raw, err := kv.codec.Encode(defaultValue)
+	if err != nil {
+		return defaultValue, errors.Wrap(err, "failed to encode default value", logan.F{"key": key})
+	}
+
+	initializer, ok := kv.q.(GetOrSetter)
+	if !ok {
+		value, err := kv.Get(key)
+		if err != nil {
+			return defaultValue, err
+		}
+		if value != nil {
+			return *value, nil
+		}
+		return defaultValue, errors.Wrap(kv.q.Upsert(KeyValue{Key: key, Value: raw}), "failed to upsert default value", logan.F{"key": key})
+	}
+
+	stored, installed, err := initializer.GetOrSet(key, raw)
+	if err != nil {
+		return defaultValue, errors.Wrap(err, "failed to get or set value", logan.F{"key": key})
+	}
+	if installed {
+		return defaultValue, nil
+	}
+	return kv.decode(stored)
+}
+
+func (kv *TypedKV[T]) decode(stored KeyValue) (T, error) {
+	value, err := kv.codec.Decode(stored.Value)
+	if err != nil {
+		return value, &DecodeError{Key: stored.Key, Value: stored.Value, Err: err}
+	}
+	return value, nil
+}
@@ -0,0 +1,124 @@
+package dban_test
+
+import (
+	stderrors "errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+func TestTypedGetters(t *testing.T) {
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	require.NoError(t, kvQ.UpsertMany([]dban.KeyValue{
+		{Key: "page", Value: "42"},
+		{Key: "enabled", Value: "true"},
+		{Key: "since", Value: "2023-01-02T03:04:05Z"},
+		{Key: "config", Value: `{"name":"orders","size":3}`},
+		{Key: "broken", Value: "nope"},
+	}))
+
+	page, err := dban.GetUint64(kvQ, "page")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), *page)
+
+	enabled, err := dban.GetBool(kvQ, "enabled")
+	require.NoError(t, err)
+	assert.True(t, *enabled)
+
+	since, err := dban.GetTime(kvQ, "since", time.RFC3339)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC), *since)
+
+	var config struct {
+		Name string `json:"name"`
+		Size int    `json:"size"`
+	}
+	found, err := dban.GetJSON(kvQ, "config", &config)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "orders", config.Name)
+	assert.Equal(t, 3, config.Size)
+
+	t.Run("missing", func(t *testing.T) {
+		page, err := dban.GetUint64(kvQ, "missing")
+		require.NoError(t, err)
+		assert.Nil(t, page)
+
+		found, err := dban.GetJSON(kvQ, "missing", &config)
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		_, err := dban.GetUint64(kvQ, "broken")
+		require.Error(t, err)
+		assert.Equal(t, "broken", errors.GetFields(err)["key"])
+		assert.Equal(t, "nope", errors.GetFields(err)["value"])
+
+		_, err = dban.GetBool(kvQ, "broken")
+		assert.Error(t, err)
+		_, err = dban.GetTime(kvQ, "broken", time.RFC3339)
+		assert.Error(t, err)
+		_, err = dban.GetJSON(kvQ, "broken", &config)
+		assert.Error(t, err)
+	})
+}
+
+type typedCursor struct {
+	Block uint64 `json:"block"`
+	Hash  string `json:"hash"`
+}
+
+func TestTypedKV(t *testing.T) {
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	cursors := dban.NewTypedKV[typedCursor](kvQ, nil)
+
+	value, err := cursors.Get("cursor")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	def := typedCursor{Block: 1, Hash: "0x1"}
+	got, err := cursors.GetOrSet("cursor", def)
+	require.NoError(t, err)
+	assert.Equal(t, def, got)
+	assert.JSONEq(t, `{"block":1,"hash":"0x1"}`, kvQ.MustGet("cursor").Value)
+
+	require.NoError(t, cursors.Set("cursor", typedCursor{Block: 2, Hash: "0x2"}))
+	got, err = cursors.GetOrSet("cursor", def)
+	require.NoError(t, err)
+	assert.Equal(t, typedCursor{Block: 2, Hash: "0x2"}, got)
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "cursor", Value: "not json"}))
+	_, err = cursors.Get("cursor")
+	var decodeErr *dban.DecodeError
+	require.True(t, stderrors.As(err, &decodeErr), "unexpected error: %v", err)
+	assert.Equal(t, "not json", decodeErr.Value)
+}
+
+// upperCodec stores strings uppercased
+type upperCodec struct{}
+
+func (upperCodec) Encode(value string) (string, error) {
+	return strings.ToUpper(value), nil
+}
+
+func (upperCodec) Decode(raw string) (string, error) {
+	return strings.ToLower(raw), nil
+}
+
+func TestTypedKVCodec(t *testing.T) {
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	names := dban.NewTypedKV[string](kvQ, upperCodec{})
+
+	require.NoError(t, names.Set("name", "foo"))
+	assert.Equal(t, "FOO", kvQ.MustGet("name").Value)
+	value, err := names.Get("name")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", *value)
+}
@@ -0,0 +1,63 @@
+package dban
+
+import (
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// UpdateLocked does what KeyValueQ.Update does with a querier already bound to a
+// transaction: the value is read with LockingGet and the value fn returns is upserted, while
+// committing or rolling back is up to the caller. It lets queriers of other packages
+// implement Update within transactions of their own
+func UpdateLocked(q KeyValueQ, key string, fn func(current *string) (string, error)) (KeyValue, error) {
+	fields := logan.F{"key": key}
+
+	current, err := q.LockingGet(key)
+	if err != nil {
+		return KeyValue{}, errors.Wrap(err, "failed to get value", fields)
+	}
+
+	var value *string
+	if current != nil {
+		value = &current.Value
+	}
+	next, err := fn(value)
+	if Is(err, ErrSkipUpdate) {
+		if current == nil {
+			return KeyValue{}, nil
+		}
+		return *current, nil
+	}
+	if err != nil {
+		return KeyValue{}, err
+	}
+
+	updated := KeyValue{Key: key, Value: next}
+	if err = q.Upsert(updated); err != nil {
+		return KeyValue{}, errors.Wrap(err, "failed to upsert value", fields)
+	}
+	return updated, nil
+}
+
+// updateInTx implements KeyValueQ.Update of a transactional querier with UpdateLocked in a
+// transaction of it. fn is called once, even if the transaction fails
+func updateInTx(q TransactionalKeyValueQ, key string, fn func(current *string) (string, error)) (KeyValue, error) {
+	var updated KeyValue
+	err := q.Transaction(func(q KeyValueQ) error {
+		var err error
+		updated, err = UpdateLocked(q, key, fn)
+		return err
+	})
+	if err != nil {
+		return KeyValue{}, err
+	}
+	return updated, nil
+}
+
+func (q *keyValueQ) Update(key string, fn func(current *string) (string, error)) (KeyValue, error) {
+	return updateInTx(q, key, fn)
+}
+
+func (q *stdKeyValueQ) Update(key string, fn func(current *string) (string, error)) (KeyValue, error) {
+	return updateInTx(q, key, fn)
+}
@@ -0,0 +1,87 @@
+package dban
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdate(t *testing.T) {
+	raw, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer raw.Close()
+	kvQ := NewKeyValueQStd(raw)
+	expectGet := func(key string, rows *sqlmock.Rows) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(getForUpdateSQL).WithArgs(key).WillReturnRows(rows)
+	}
+	kvRows := func() *sqlmock.Rows { return sqlmock.NewRows([]string{"key", "value"}) }
+
+	expectGet("counter", kvRows().AddRow("counter", "10"))
+	mock.ExpectExec(upsertSQL).WithArgs("counter", "11").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	updated, err := kvQ.Update("counter", func(current *string) (string, error) {
+		require.NotNil(t, current)
+		n, err := strconv.Atoi(*current)
+		return strconv.Itoa(n + 1), err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, KeyValue{Key: "counter", Value: "11"}, updated)
+
+	expectGet("missing", kvRows())
+	mock.ExpectExec(upsertSQL).WithArgs("missing", "1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	_, err = kvQ.Update("missing", func(current *string) (string, error) {
+		assert.Nil(t, current, "a missing value must be passed as nil")
+		return "1", nil
+	})
+	require.NoError(t, err)
+
+	expectGet("counter", kvRows().AddRow("counter", "11"))
+	mock.ExpectCommit()
+	updated, err = kvQ.Update("counter", func(*string) (string, error) { return "", ErrSkipUpdate })
+	require.NoError(t, err)
+	assert.Equal(t, KeyValue{Key: "counter", Value: "11"}, updated, "a skipped update must return the current value")
+
+	failure := errors.New("invalid document")
+	expectGet("counter", kvRows().AddRow("counter", "11"))
+	mock.ExpectRollback()
+	calls := 0
+	_, err = kvQ.Update("counter", func(*string) (string, error) {
+		calls++
+		return "", failure
+	})
+	assert.True(t, Is(err, failure))
+	assert.Equal(t, 1, calls, "fn must not be retried")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdatePostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db)
+	kvQ := NewKeyValueQ(db)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := kvQ.Update("update_counter", func(current *string) (string, error) {
+				n := 0
+				if current != nil {
+					n, _ = strconv.Atoi(*current)
+				}
+				return strconv.Itoa(n + 1), nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, "8", kvQ.MustGet("update_counter").Value, "concurrent updates must not overwrite each other")
+}
@@ -0,0 +1,64 @@
+package dban
+
+import (
+	"encoding/json"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// Validator checks a key value before it is written
+type Validator func(kv KeyValue) error
+
+// WithValidators makes the querier run validators in order before every write, failing
+// the write with the first error. Reads are not validated
+func WithValidators(validators ...Validator) KeyValueQOption {
+	return func(q *keyValueQ) {
+		q.validators = append(q.validators, validators...)
+	}
+}
+
+// MaxSize is a validator rejecting values longer than n bytes with ErrValueTooLarge
+func MaxSize(n int) Validator {
+	return func(kv KeyValue) error {
+		if len(kv.Value) > n {
+			return errors.From(ErrValueTooLarge, logan.F{"size": len(kv.Value), "max_size": n})
+		}
+		return nil
+	}
+}
+
+// ValidJSON is a validator rejecting values that are not valid JSON with ErrInvalidJSON
+func ValidJSON(kv KeyValue) error {
+	if !json.Valid([]byte(kv.Value)) {
+		return ErrInvalidJSON
+	}
+	return nil
+}
+
+// validate checks the key (see ValidateKey) and runs the validators of the querier
+func (q *keyValueQ) validate(kv KeyValue) error {
+	if err := q.validateKey(kv.Key); err != nil {
+		return err
+	}
+	for _, validator := range q.validators {
+		if err := validator(kv); err != nil {
+			return errors.Wrap(err, "invalid key value", logan.F{"key": kv.Key})
+		}
+	}
+	return nil
+}
+
+// validateBatch validates every key value of a batch and returns BatchError listing the invalid ones
+func (q *keyValueQ) validateBatch(kvs []KeyValue) error {
+	var batchErr BatchError
+	for i, kv := range kvs {
+		if err := q.validate(kv); err != nil {
+			batchErr.Errors = append(batchErr.Errors, KeyError{Key: kv.Key, Index: i, Err: err})
+		}
+	}
+	if len(batchErr.Errors) != 0 {
+		return &batchErr
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package dban
+
+import (
+	stderrors "errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+func TestKeyValueQValidators(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	var calls []string
+	recording := func(name string, err error) Validator {
+		return func(KeyValue) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+	failure := errors.New("rejected")
+	kvQ := NewKeyValueQ(db, WithValidators(recording("first", nil), recording("second", failure), recording("third", nil)))
+
+	err := kvQ.Upsert(KeyValue{Key: "cursor", Value: "1"})
+	assert.Equal(t, failure, errors.Cause(err))
+	assert.Contains(t, err.Error(), "invalid key value")
+	assert.Equal(t, []string{"first", "second"}, calls, "validators must run in order up to the first failure")
+
+	calls = nil
+	_, _ = kvQ.Get("cursor")
+	assert.Empty(t, calls, "reads must not be validated")
+
+	kvQ = NewKeyValueQ(db, WithValidators(MaxSize(8), ValidJSON))
+	mock.ExpectExec(upsertSQL).WithArgs("cursor", `{"a":1}`).WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "cursor", Value: `{"a":1}`}))
+	assert.True(t, Is(kvQ.Upsert(KeyValue{Key: "cursor", Value: `{"a":100}`}), ErrValueTooLarge))
+	assert.Error(t, kvQ.Upsert(KeyValue{Key: "cursor", Value: `{`}))
+}
+
+func TestKeyValueQValidateBatch(t *testing.T) {
+	kvQ := NewKeyValueQ(nil, WithValidators(MaxSize(2))).(*keyValueQ)
+	assert.NoError(t, kvQ.validateBatch([]KeyValue{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}}))
+
+	err := kvQ.validateBatch([]KeyValue{
+		{Key: "a", Value: "1"},
+		{Key: "", Value: "1"},
+		{Key: "b", Value: "2"},
+		{Key: "c", Value: "100"},
+	})
+
+	var batchErr *BatchError
+	require.True(t, stderrors.As(err, &batchErr))
+	assert.Equal(t, []string{"", "c"}, batchErr.Keys())
+	assert.Equal(t, []int{1, 3}, []int{batchErr.Errors[0].Index, batchErr.Errors[1].Index})
+	assert.True(t, stderrors.Is(err, ErrInvalidKey))
+	assert.True(t, Is(errors.Wrap(err, "failed"), ErrValueTooLarge))
+	assert.False(t, Is(err, ErrDuplicateKey))
+	assert.Contains(t, err.Error(), `2 of keys failed: key "" (#1)`)
+}
@@ -0,0 +1,80 @@
+package dban
+
+import (
+	"database/sql"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// versionColumn is the column of the version of a value, incremented by every write (see
+// FeatureVersion)
+const versionColumn = "version"
+
+// VersionedKeyValue is a key value with the version it was read at
+type VersionedKeyValue struct {
+	KeyValue
+	// Version is 1 for a value just inserted and grows with every write of it
+	Version int64 `db:"version" structs:"version" json:"version"`
+}
+
+// Versioner is a key value querier able to write values optimistically, so that workers
+// sharing a key could detect that another one wrote it in between without holding a row
+// lock. It requires FeatureVersion
+type Versioner interface {
+	// GetVersioned does the same thing as Get, but returns the version of the value as well
+	GetVersioned(key string) (*VersionedKeyValue, error)
+	// UpsertWithVersion writes the value only if its stored version is expectedVersion,
+	// incrementing the version, and fails with ErrVersionConflict otherwise. An
+	// expectedVersion of 0 means there must be no value by the key yet
+	UpsertWithVersion(kv KeyValue, expectedVersion int64) error
+}
+
+var _ Versioner = (*keyValueQ)(nil)
+
+func (q *keyValueQ) GetVersioned(key string) (*VersionedKeyValue, error) {
+	if err := q.validateKey(key); err != nil {
+		return nil, err
+	}
+
+	query := q.queries.getVersioned[q.hides()]
+	var value VersionedKeyValue
+	err := q.db.GetRawContext(q.ctx, &value, query, key)
+	if err == sql.ErrNoRows {
+		countKV(kvVarGet, nil)
+		return nil, nil
+	}
+	countKV(kvVarGet, err)
+	if err != nil {
+		return nil, errors.Wrap(q.classify(err), "failed to get versioned value", logan.F{"key": key})
+	}
+	return &value, nil
+}
+
+func (q *keyValueQ) UpsertWithVersion(kv KeyValue, expectedVersion int64) error {
+	if err := q.validate(kv); err != nil {
+		return err
+	}
+
+	var written bool
+	err := q.retryWrite(kv.Key, func(q *keyValueQ) (err error) {
+		if expectedVersion == 0 {
+			written, err = q.execAffecting(q.queries.insertIfAbsent, kv.Key, kv.Value)
+		} else {
+			written, err = q.execAffecting(q.queries.updateIfVersion[q.resets()], kv.Value, kv.Key, expectedVersion)
+		}
+		return err
+	})
+	countKV(kvVarUpsert, err)
+	if err != nil {
+		return errors.Wrap(q.classify(err), "failed to upsert versioned value", logan.F{"key": kv.Key})
+	}
+	if !written {
+		return errors.From(ErrVersionConflict, logan.F{"key": kv.Key, "expected_version": expectedVersion})
+	}
+
+	if q.events != nil {
+		q.events.Emit(KVWritten{Key: kv.Key})
+	}
+	return nil
+}
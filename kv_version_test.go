@@ -0,0 +1,80 @@
+package dban
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueQUpsertWithVersion(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db).(Versioner)
+
+	mock.ExpectQuery("SELECT key, value, version FROM key_value WHERE key = $1").
+		WithArgs("cursor").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value", "version"}).AddRow("cursor", "10", 3))
+	value, err := kvQ.GetVersioned("cursor")
+	require.NoError(t, err)
+	assert.Equal(t, &VersionedKeyValue{KeyValue: KeyValue{Key: "cursor", Value: "10"}, Version: 3}, value)
+
+	mock.ExpectExec("UPDATE key_value SET value = $1 WHERE key = $2 AND version = $3").
+		WithArgs("11", "cursor", int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, kvQ.UpsertWithVersion(KeyValue{Key: "cursor", Value: "11"}, 3))
+
+	mock.ExpectExec("UPDATE key_value SET value = $1 WHERE key = $2 AND version = $3").
+		WithArgs("11", "cursor", int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	err = kvQ.UpsertWithVersion(KeyValue{Key: "cursor", Value: "11"}, 3)
+	assert.True(t, Is(err, ErrVersionConflict), "unexpected error: %v", err)
+
+	mock.ExpectExec("INSERT INTO key_value (key,value) VALUES ($1,$2) ON CONFLICT (key) DO NOTHING").
+		WithArgs("new", "1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	err = kvQ.UpsertWithVersion(KeyValue{Key: "new", Value: "1"}, 0)
+	assert.True(t, Is(err, ErrVersionConflict), "unexpected error: %v", err)
+}
+
+func TestKeyValueQUpsertWithVersionResets(t *testing.T) {
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(db, WithTTL()).(Versioner)
+
+	mock.ExpectQuery("SELECT key, value, version FROM key_value WHERE key = $1 AND (expires_at IS NULL OR expires_at > now())").
+		WithArgs("cursor").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value", "version"}))
+	value, err := kvQ.GetVersioned("cursor")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	mock.ExpectExec("UPDATE key_value SET value = $1, expires_at = NULL WHERE key = $2 AND version = $3").
+		WithArgs("1", "cursor", int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, kvQ.UpsertWithVersion(KeyValue{Key: "cursor", Value: "1"}, 1))
+}
+
+func TestKeyValueQUpsertWithVersionPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db, WithFeatures(FeatureVersion))
+	kvQ := NewKeyValueQ(db)
+	versioner := kvQ.(Versioner)
+
+	require.NoError(t, versioner.UpsertWithVersion(KeyValue{Key: "cursor", Value: "1"}, 0))
+	err := versioner.UpsertWithVersion(KeyValue{Key: "cursor", Value: "1"}, 0)
+	assert.True(t, Is(err, ErrVersionConflict), "unexpected error: %v", err)
+
+	value, err := versioner.GetVersioned("cursor")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), value.Version)
+
+	// plain writes increment the version too
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "cursor", Value: "2"}))
+	err = versioner.UpsertWithVersion(KeyValue{Key: "cursor", Value: "3"}, value.Version)
+	assert.True(t, Is(err, ErrVersionConflict), "unexpected error: %v", err)
+
+	require.NoError(t, versioner.UpsertWithVersion(KeyValue{Key: "cursor", Value: "3"}, 2))
+	value, err = versioner.GetVersioned("cursor")
+	require.NoError(t, err)
+	assert.Equal(t, &VersionedKeyValue{KeyValue: KeyValue{Key: "cursor", Value: "3"}, Version: 3}, value)
+}
@@ -0,0 +1,243 @@
+package dban
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// MapFunc converts an entity of a streamer to the type it is processed as
+type MapFunc[T, U any] func(t T) (U, error)
+
+// NewMapStreamer returns a Streamer of the entities of inner converted with mapFn, e.g. rows
+// to domain structs, so that the processing functions do not convert them themselves. The
+// cursor and the rest of the features are left to inner: a conversion failure is a failure
+// of processing the entity, which stops the list with the default EntityErrorPolicy, while
+// FormList, Select and FormListAndProcessBatch fail on it as a whole. Failures tell the index
+// of the entity failed to be converted
+func NewMapStreamer[T, U any](inner Streamer[T], mapFn MapFunc[T, U]) Streamer[U] {
+	return &mapStreamer[T, U]{inner: inner, mapFn: mapFn}
+}
+
+type mapStreamer[T, U any] struct {
+	inner Streamer[T]
+	mapFn MapFunc[T, U]
+}
+
+func (s *mapStreamer[T, U]) Select(pageNumber uint64) ([]U, error) {
+	entities, err := s.inner.Select(pageNumber)
+	if err != nil {
+		return nil, err
+	}
+	return s.mapList(entities)
+}
+
+func (s *mapStreamer[T, U]) FormListAndProcess(fn func(ctx context.Context, u U) error) error {
+	return s.inner.FormListAndProcess(s.mapped(fn))
+}
+
+// FormListAndProcessReport reports the entities as they were converted for fn, keyed by their
+// index in the list. The ones failed to be converted are left zero, their Err telling why
+func (s *mapStreamer[T, U]) FormListAndProcessReport(fn func(ctx context.Context, u U) error) (BatchReport[U], error) {
+	var (
+		mu        sync.Mutex
+		converted = make(map[int]U)
+	)
+	inner, err := s.inner.FormListAndProcessReport(s.mapped(func(ctx context.Context, u U) error {
+		if i, ok := entityIndex(ctx); ok {
+			mu.Lock()
+			converted[i] = u
+			mu.Unlock()
+		}
+		return fn(ctx, u)
+	}))
+
+	report := BatchReport[U]{Page: inner.Page}
+	if inner.Results != nil {
+		report.Results = make([]EntityResult[U], len(inner.Results))
+	}
+	for i, result := range inner.Results {
+		report.Results[i] = EntityResult[U]{
+			Entity:       converted[result.Index],
+			Index:        result.Index,
+			Err:          result.Err,
+			Took:         result.Took,
+			DeadLettered: result.DeadLettered,
+		}
+	}
+	return report, err
+}
+
+func (s *mapStreamer[T, U]) FormAndProcessRows(fn func(ctx context.Context, u U) error) error {
+	return s.inner.FormAndProcessRows(s.mapped(fn))
+}
+
+func (s *mapStreamer[T, U]) FormList() ([]U, error) {
+	entities, err := s.inner.FormList()
+	mapped, mapErr := s.mapList(entities)
+	if mapErr != nil {
+		return nil, mapErr
+	}
+	// entities preceding the unprocessed pages are returned along with the error
+	return mapped, err
+}
+
+func (s *mapStreamer[T, U]) GetCurrentPage() (uint64, error) {
+	return s.inner.GetCurrentPage()
+}
+
+func (s *mapStreamer[T, U]) GetStats() StreamerStats {
+	return s.inner.GetStats()
+}
+
+func (s *mapStreamer[T, U]) ProcessAll(fn func(ctx context.Context, u U) error) (ProcessReport, error) {
+	return s.inner.ProcessAll(s.mapped(fn))
+}
+
+func (s *mapStreamer[T, U]) Run(ctx context.Context, fn func(ctx context.Context, u U) error, cfg RunConfig) error {
+	return s.inner.Run(ctx, s.mapped(fn), cfg)
+}
+
+func (s *mapStreamer[T, U]) FormListAndProcessTx(fn func(ctx context.Context, q KeyValueQ, u U) error) error {
+	return s.inner.FormListAndProcessTx(func(ctx context.Context, q KeyValueQ, t T) error {
+		u, err := s.mapFn(t)
+		if err != nil {
+			return errors.Wrap(err, "failed to map entity")
+		}
+		return fn(ctx, q, u)
+	})
+}
+
+func (s *mapStreamer[T, U]) FormListAndProcessBatch(fn func(ctx context.Context, batch []U) error) error {
+	return s.inner.FormListAndProcessBatch(func(ctx context.Context, batch []T) error {
+		mapped, err := s.mapList(batch)
+		if err != nil {
+			return err
+		}
+		return fn(ctx, mapped)
+	})
+}
+
+// Stream converts the entities streamed by inner. A conversion failure stops inner, so the
+// page of the entity is left to be streamed again, unless the entity is the last one of the
+// page, which inner considers delivered once it is received
+func (s *mapStreamer[T, U]) Stream(ctx context.Context) (<-chan U, <-chan error) {
+	ctx, cancel := context.WithCancel(ctx)
+	inner, innerErrs := s.inner.Stream(ctx)
+
+	entities := make(chan U)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(entities)
+		defer cancel()
+
+		i := 0
+		for t := range inner {
+			u, err := s.mapFn(t)
+			if err != nil {
+				cancel()
+				for range innerErrs {
+					// inner stops without sending the rest of the entities once ctx is canceled
+				}
+				errs <- errors.Wrap(err, "failed to map entity", logan.F{"entity_number": i})
+				return
+			}
+			select {
+			case entities <- u:
+			case <-ctx.Done():
+			}
+			i++
+		}
+		if err, ok := <-innerErrs; ok {
+			errs <- err
+		}
+	}()
+	return entities, errs
+}
+
+func (s *mapStreamer[T, U]) Reset() error {
+	return s.inner.Reset()
+}
+
+func (s *mapStreamer[T, U]) SetPage(page uint64) error {
+	return s.inner.SetPage(page)
+}
+
+func (s *mapStreamer[T, U]) SkipToEnd() error {
+	return s.inner.SkipToEnd()
+}
+
+func (s *mapStreamer[T, U]) Flush() error {
+	return s.inner.Flush()
+}
+
+func (s *mapStreamer[T, U]) RunCron(ctx context.Context, spec string, fn func(ctx context.Context, u U) error) error {
+	return s.inner.RunCron(ctx, spec, s.mapped(fn))
+}
+
+func (s *mapStreamer[T, U]) Pause() error {
+	return s.inner.Pause()
+}
+
+func (s *mapStreamer[T, U]) Resume() error {
+	return s.inner.Resume()
+}
+
+func (s *mapStreamer[T, U]) IsPaused() (bool, error) {
+	return s.inner.IsPaused()
+}
+
+func (s *mapStreamer[T, U]) BreakerState() (CircuitState, error) {
+	return s.inner.BreakerState()
+}
+
+func (s *mapStreamer[T, U]) ResetBreaker() error {
+	return s.inner.ResetBreaker()
+}
+
+func (s *mapStreamer[T, U]) GetLastRunInfo() (*LastRunInfo, error) {
+	return s.inner.GetLastRunInfo()
+}
+
+func (s *mapStreamer[T, U]) ResetRunCounters() error {
+	return s.inner.ResetRunCounters()
+}
+
+func (s *mapStreamer[T, U]) Name() string {
+	return s.inner.Name()
+}
+
+func (s *mapStreamer[T, U]) LastProgress() time.Time {
+	return s.inner.LastProgress()
+}
+
+// mapped makes a processing function of inner converting the entity before passing it to fn
+func (s *mapStreamer[T, U]) mapped(fn func(ctx context.Context, u U) error) func(ctx context.Context, t T) error {
+	return func(ctx context.Context, t T) error {
+		u, err := s.mapFn(t)
+		if err != nil {
+			return errors.Wrap(err, "failed to map entity")
+		}
+		return fn(ctx, u)
+	}
+}
+
+// mapList converts the entities of a list, failing on the first one that could not be
+func (s *mapStreamer[T, U]) mapList(entities []T) ([]U, error) {
+	if entities == nil {
+		return nil, nil
+	}
+
+	mapped := make([]U, len(entities))
+	for i, t := range entities {
+		var err error
+		if mapped[i], err = s.mapFn(t); err != nil {
+			return nil, errors.Wrap(err, "failed to map entity", logan.F{"entity_index": i})
+		}
+	}
+	return mapped, nil
+}
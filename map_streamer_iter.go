@@ -0,0 +1,31 @@
+//go:build go1.23
+
+package dban
+
+import (
+	"iter"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// All converts the entities iterated over by inner. A conversion failure is yielded as the
+// last error, breaking the loop over inner, so the page of the entity is left to be iterated
+// again
+func (s *mapStreamer[T, U]) All() iter.Seq2[U, error] {
+	return func(yield func(U, error) bool) {
+		i := 0
+		for t, err := range s.inner.All() {
+			var u U
+			if err == nil {
+				if u, err = s.mapFn(t); err != nil {
+					err = errors.Wrap(err, "failed to map entity", logan.F{"entity_number": i})
+				}
+			}
+			if !yield(u, err) || err != nil {
+				return
+			}
+			i++
+		}
+	}
+}
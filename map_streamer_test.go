@@ -0,0 +1,93 @@
+package dban_test
+
+import (
+	"context"
+	stderrors "errors"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+var errOdd = errors.New("odd entity")
+
+// formatEven formats even entities failing on the odd ones
+func formatEven(i int) (string, error) {
+	if i%2 != 0 {
+		return "", errOdd
+	}
+	return strconv.Itoa(i), nil
+}
+
+func TestMapStreamer(t *testing.T) {
+	t.Run("form list", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := dban.NewMapStreamer[int, string](newTestStreamer(dbantest.NewSliceStreamable([]int{2, 4, 5}), kvQ), formatEven)
+
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2", "4"}, list)
+
+		_, err = streamer.FormList()
+		assert.True(t, dban.Is(err, errOdd))
+		assert.Equal(t, 0, errors.GetFields(err)["entity_index"])
+
+		page, err := streamer.GetCurrentPage()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), page, "the cursor must be managed by the inner streamer")
+	})
+
+	t.Run("process", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := dban.NewMapStreamer[int, string](newTestStreamer(dbantest.NewSliceStreamable([]int{2, 3}), kvQ), formatEven)
+
+		var processed []string
+		err := streamer.FormListAndProcess(func(_ context.Context, s string) error {
+			processed = append(processed, s)
+			return nil
+		})
+		assert.True(t, dban.Is(err, errOdd))
+		assert.Equal(t, []string{"2"}, processed)
+
+		var streamErr *dban.StreamError
+		require.True(t, stderrors.As(err, &streamErr))
+		assert.Equal(t, 1, streamErr.EntityIndex)
+	})
+
+	t.Run("report", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		var conversions int
+		countingFormat := func(i int) (string, error) {
+			conversions++
+			return formatEven(i)
+		}
+		streamer := dban.NewMapStreamer[int, string](newTestStreamer(dbantest.NewSliceStreamable([]int{2, 3}), kvQ), countingFormat)
+
+		report, err := streamer.FormListAndProcessReport(func(context.Context, string) error { return nil })
+		assert.True(t, dban.Is(err, errOdd))
+		assert.Equal(t, 2, conversions, "the entities must be converted once")
+		require.Len(t, report.Results, 2)
+		assert.Equal(t, dban.EntityResult[string]{Entity: "2", Index: 0, Took: report.Results[0].Took}, report.Results[0])
+		assert.Equal(t, "", report.Results[1].Entity, "the entity failed to be converted must be left zero")
+		assert.Equal(t, 1, report.Results[1].Index)
+		assert.True(t, dban.Is(report.Results[1].Err, errOdd), "the conversion error must be reported")
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := dban.NewMapStreamer[int, string](newTestStreamer(dbantest.NewSliceStreamable([]int{2, 4, 7, 6}), kvQ), formatEven)
+
+		entities, errs := streamer.Stream(context.Background())
+		var received []string
+		for entity := range entities {
+			received = append(received, entity)
+		}
+		assert.Equal(t, []string{"2", "4"}, received)
+		assert.True(t, dban.Is(<-errs, errOdd))
+		assert.Equal(t, "1", kvQ.MustGet(cursorKey).Value, "the page of the entity failed must be left to stream again")
+	})
+}
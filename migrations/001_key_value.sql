@@ -0,0 +1,11 @@
+-- +migrate Up
+
+create table {{.Name}}
+(
+    key   varchar(64) unique not null,
+    value varchar(64)        not null
+);
+
+-- +migrate Down
+
+drop table {{.Name}};
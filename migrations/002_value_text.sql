@@ -0,0 +1,15 @@
+-- +migrate Up
+
+-- values such as JSON cursors and encrypted tokens do not fit into 64 characters. Other
+-- dialects do not enforce the length of varchar, nor could sqlite alter the type of a column
+{{- if eq .Dialect "postgres"}}
+alter table {{.Name}}
+    alter column value type text;
+{{- end}}
+
+-- +migrate Down
+{{- if eq .Dialect "postgres"}}
+
+alter table {{.Name}}
+    alter column value type varchar(64);
+{{- end}}
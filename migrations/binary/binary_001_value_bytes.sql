@@ -0,0 +1,9 @@
+-- +migrate Up
+
+alter table {{.Name}}
+    add column value_bytes bytea;
+
+-- +migrate Down
+
+alter table {{.Name}}
+    drop column value_bytes;
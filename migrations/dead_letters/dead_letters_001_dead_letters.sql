@@ -0,0 +1,17 @@
+-- +migrate Up
+
+create table dead_letters
+(
+    id         bigserial primary key,
+    stream_key text        not null,
+    payload    jsonb       not null,
+    error      text        not null,
+    attempts   integer     not null,
+    created_at timestamptz not null default now()
+);
+
+create index dead_letters_stream_key_id_idx on dead_letters (stream_key, id);
+
+-- +migrate Down
+
+drop table dead_letters;
@@ -0,0 +1,46 @@
+-- +migrate Up
+
+create table {{.Name}}_history
+(
+    id         bigserial primary key,
+    key        text        not null,
+    old_value  text,
+    new_value  text,
+    actor      text        not null,
+    changed_at timestamptz not null default now()
+);
+
+create index {{.Name}}_history_key_id_idx on {{.Name}}_history (key, id);
+
+-- +migrate StatementBegin
+create function {{.Name}}_record_history() returns trigger as
+$$
+declare
+    -- writers label themselves with set_config('dban.actor', ..., true)
+    writer text := coalesce(nullif(current_setting('dban.actor', true), ''), session_user);
+begin
+    if tg_op = 'INSERT' then
+        insert into {{.Name}}_history (key, old_value, new_value, actor) values (new.key, null, new.value, writer);
+    elsif tg_op = 'DELETE' then
+        insert into {{.Name}}_history (key, old_value, new_value, actor) values (old.key, old.value, null, writer);
+    elsif new.value is distinct from old.value then
+        insert into {{.Name}}_history (key, old_value, new_value, actor) values (new.key, old.value, new.value, writer);
+    end if;
+    return null;
+end;
+$$ language plpgsql;
+-- +migrate StatementEnd
+
+create trigger {{.Name}}_record_history
+    after insert or update or delete
+    on {{.Name}}
+    for each row
+execute procedure {{.Name}}_record_history();
+
+-- +migrate Down
+
+drop trigger {{.Name}}_record_history on {{.Name}};
+
+drop function {{.Name}}_record_history();
+
+drop table {{.Name}}_history;
@@ -0,0 +1,11 @@
+-- +migrate Up
+
+create table key_value_json
+(
+    key   text primary key,
+    value jsonb not null
+);
+
+-- +migrate Down
+
+drop table key_value_json;
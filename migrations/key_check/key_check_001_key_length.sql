@@ -0,0 +1,16 @@
+-- +migrate Up
+
+-- the key column is widened, so that the constraint is the limit keys are validated against
+alter table {{.Name}}
+    alter column key type varchar(256);
+
+alter table {{.Name}}
+    add constraint {{.Name}}_key_check check (char_length(key) <= 256 and key <> '');
+
+-- +migrate Down
+
+alter table {{.Name}}
+    drop constraint {{.Name}}_key_check;
+
+alter table {{.Name}}
+    alter column key type varchar(64);
@@ -0,0 +1,13 @@
+-- +migrate Up
+
+alter table {{.Name}}
+    add column deleted_at timestamptz;
+
+create index {{.Name}}_deleted_at_idx on {{.Name}} (deleted_at) where deleted_at is not null;
+
+-- +migrate Down
+
+drop index {{.Name}}_deleted_at_idx;
+
+alter table {{.Name}}
+    drop column deleted_at;
@@ -0,0 +1,11 @@
+-- +migrate Up
+
+alter table {{.Name}}
+    add column created_at timestamptz not null default now(),
+    add column updated_at timestamptz not null default now();
+
+-- +migrate Down
+
+alter table {{.Name}}
+    drop column created_at,
+    drop column updated_at;
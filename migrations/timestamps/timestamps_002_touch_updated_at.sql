@@ -0,0 +1,26 @@
+-- +migrate Up
+
+-- +migrate StatementBegin
+create function {{.Name}}_touch_updated_at() returns trigger as
+$$
+begin
+    -- an explicitly set updated_at is kept
+    if new.updated_at = old.updated_at then
+        new.updated_at = now();
+    end if;
+    return new;
+end;
+$$ language plpgsql;
+-- +migrate StatementEnd
+
+create trigger {{.Name}}_touch_updated_at
+    before update
+    on {{.Name}}
+    for each row
+execute procedure {{.Name}}_touch_updated_at();
+
+-- +migrate Down
+
+drop trigger {{.Name}}_touch_updated_at on {{.Name}};
+
+drop function {{.Name}}_touch_updated_at();
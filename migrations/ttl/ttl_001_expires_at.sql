@@ -0,0 +1,13 @@
+-- +migrate Up
+
+alter table {{.Name}}
+    add column expires_at timestamptz;
+
+create index {{.Name}}_expires_at_idx on {{.Name}} (expires_at) where expires_at is not null;
+
+-- +migrate Down
+
+drop index {{.Name}}_expires_at_idx;
+
+alter table {{.Name}}
+    drop column expires_at;
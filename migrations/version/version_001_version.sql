@@ -0,0 +1,30 @@
+-- +migrate Up
+
+-- values written before get version 1, as if they were just inserted
+alter table {{.Name}}
+    add column version bigint not null default 1;
+
+-- +migrate StatementBegin
+create function {{.Name}}_bump_version() returns trigger as
+$$
+begin
+    new.version = old.version + 1;
+    return new;
+end;
+$$ language plpgsql;
+-- +migrate StatementEnd
+
+create trigger {{.Name}}_bump_version
+    before update
+    on {{.Name}}
+    for each row
+execute procedure {{.Name}}_bump_version();
+
+-- +migrate Down
+
+drop trigger {{.Name}}_bump_version on {{.Name}};
+
+drop function {{.Name}}_bump_version();
+
+alter table {{.Name}}
+    drop column version;
@@ -0,0 +1,688 @@
+package dban
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+//go:embed migrations
+var migrationsFS embed.FS
+
+const migrationsDialect = "postgres"
+
+// kvMigrations are the embedded migrations rendered for the default key value table (see
+// WithKeyValueTable)
+var kvMigrations = tableSource{root: "migrations"}
+
+// Feature is an optional group of migrations extending the key value storage. Every
+// group is tracked in its own migrations table, so that enabling a group later applies
+// just its migrations
+type Feature string
+
+const (
+	// FeatureTimestamps adds created_at and updated_at columns to the key value table, the
+	// latter is kept up to date by a trigger
+	FeatureTimestamps Feature = "timestamps"
+	// FeatureTTL adds an expires_at column to the key value table
+	FeatureTTL Feature = "ttl"
+	// FeatureHistory adds a key_value_history table recording every change of the key value
+	// table by a trigger, in the transaction of the change
+	FeatureHistory Feature = "history"
+	// FeatureBinary adds a value_bytes column to the key value table for binary values
+	FeatureBinary Feature = "binary"
+	// FeatureJSON adds a key_value_json table of JSON documents (see KeyValueJSONQ)
+	FeatureJSON Feature = "json"
+	// FeatureKeyCheck widens the key column of the key value table to DefaultMaxKeyLength
+	// characters and adds a constraint rejecting empty and longer keys
+	FeatureKeyCheck Feature = "key_check"
+	// FeatureVersion adds a version column to the key value table, incremented by a trigger
+	// on every update, for optimistic writes (see Versioner)
+	FeatureVersion Feature = "version"
+	// FeatureDeadLetters adds a dead_letters table of the entities streamers failed to
+	// process (see DeadLetterQ)
+	FeatureDeadLetters Feature = "dead_letters"
+	// FeatureSoftDelete adds a deleted_at column to the key value table for values deleted
+	// reversibly (see SoftDeleter)
+	FeatureSoftDelete Feature = "soft_delete"
+)
+
+// featureMigrations lists migrations of every known feature in the order they must be applied
+var featureMigrations = []featureGroup{
+	{feature: FeatureTimestamps, source: featureSource(FeatureTimestamps)},
+	{feature: FeatureTTL, source: featureSource(FeatureTTL)},
+	{feature: FeatureHistory, source: featureSource(FeatureHistory)},
+	{feature: FeatureBinary, source: featureSource(FeatureBinary)},
+	{feature: FeatureJSON, source: featureSource(FeatureJSON)},
+	{feature: FeatureKeyCheck, source: featureSource(FeatureKeyCheck)},
+	{feature: FeatureVersion, source: featureSource(FeatureVersion)},
+	{feature: FeatureDeadLetters, source: featureSource(FeatureDeadLetters)},
+	{feature: FeatureSoftDelete, source: featureSource(FeatureSoftDelete)},
+}
+
+type featureGroup struct {
+	feature Feature
+	source  migrate.MigrationSource
+}
+
+func featureSource(feature Feature) migrate.MigrationSource {
+	return tableSource{root: path.Join("migrations", string(feature))}
+}
+
+// Migrations returns the embedded base migrations, e.g. to apply them with another
+// migration tool along with FeatureMigrations
+func Migrations() migrate.MigrationSource {
+	return kvMigrations
+}
+
+// Features returns every known feature in the order their migrations must be applied
+func Features() []Feature {
+	features := make([]Feature, len(featureMigrations))
+	for i, group := range featureMigrations {
+		features[i] = group.feature
+	}
+	return features
+}
+
+// FeatureMigrations returns the embedded migrations of the feature. Their ids are prefixed
+// with the feature, e.g. ttl_001_expires_at.sql
+func FeatureMigrations(feature Feature) (migrate.MigrationSource, error) {
+	for _, group := range featureMigrations {
+		if group.feature == feature {
+			return group.source, nil
+		}
+	}
+	return nil, errors.From(errors.New("unknown feature"), logan.F{"feature": feature})
+}
+
+// migrationsTable returns the name of a table that tracks migrations of the feature
+func (f Feature) migrationsTable() string {
+	return "dban_migrations_" + string(f)
+}
+
+// migrationFileName is a naming convention for migration files: a numeric
+// version prefix followed by a name, e.g. 001_key_value.sql
+var migrationFileName = regexp.MustCompile(`^(\d+)_[\w-]+\.sql$`)
+
+// BeforeMigrationHook is invoked right before a migration with the given id is executed
+type BeforeMigrationHook func(id string)
+
+// AfterMigrationHook is invoked right after a migration with the given id was executed,
+// err is the result of the execution and took is the time it took
+type AfterMigrationHook func(id string, err error, took time.Duration)
+
+// KeyValueMigrator is an interface for applying migrations of the key value storage
+type KeyValueMigrator interface {
+	// MigrateUp applies all migrations that were not applied yet. It fails with
+	// MigrationDriftError before applying any of them if some of the applied ones were
+	// changed since, unless WithoutChecksumVerification is set. With Postgres, migrations
+	// are run under the migration lock, so that replicas starting at once wait for each
+	// other (see WithMigrationLockTimeout)
+	MigrateUp() error
+	// MigrateDown rolls back all applied migrations
+	MigrateDown() error
+	// MigrateUpN does the same thing as MigrateUp, but applies up to max migrations, all of
+	// them if max is 0, and returns the number of the applied ones
+	MigrateUpN(max int) (int, error)
+	// MigrateDownN does the same thing as MigrateDown, but rolls back up to max of the last
+	// applied migrations, all of them if max is 0, and returns the number of the reverted ones
+	MigrateDownN(max int) (int, error)
+	// PlanUp returns the migrations MigrateUpN would apply with max, along with their SQL,
+	// without executing them, e.g. for a review before a release. It is empty once every
+	// migration is applied. Like EnsureMigrated, it makes no writes, not even creating the
+	// schema or the tables tracking the migrations
+	PlanUp(max int) ([]PlannedMigration, error)
+	// PlanDown does the same thing as PlanUp for the migrations MigrateDownN would revert
+	PlanDown(max int) ([]PlannedMigration, error)
+	// WithMigrationHooks returns a migrator that invokes before and after around every
+	// single migration in both directions. Any of the hooks may be nil
+	WithMigrationHooks(before BeforeMigrationHook, after AfterMigrationHook) KeyValueMigrator
+	// Status reports which migrations of the key value storage are applied
+	Status() (MigrationStatus, error)
+	// Migrations returns the applied and the pending migrations of the base set and the
+	// selected features, both in the order they are applied in, e.g. for release tooling to
+	// display the plan. The tables tracking them are created if missing
+	Migrations() (applied, pending []MigrationRecord, err error)
+	// HasPending tells whether some of the migrations of the base set and the selected
+	// features are not applied yet
+	HasPending() (bool, error)
+	// EnsureMigrated fails with PendingMigrationsError listing the migrations of the base set
+	// and the selected features that are not applied yet, e.g. for a readiness probe of a
+	// service not allowed to migrate. Unlike the rest of the methods, it makes no writes,
+	// not even creating the tables tracking the migrations
+	EnsureMigrated() error
+	// Version returns the id of the last of the applied migrations of the base set and the
+	// selected features in the order they are applied in, empty if there are none. It makes
+	// no writes as well
+	Version() (string, error)
+	// AlterToUnlogged switches the key value table to UNLOGGED, trading durability for
+	// write speed. Suitable for throwaway stores only: the table is truncated after a crash
+	AlterToUnlogged() error
+	// AlterToLogged switches the key value table back to a regular logged table
+	AlterToLogged() error
+	// Stats describes the key value table, including its persistence mode
+	Stats() (TableStats, error)
+	// MigrateUpAll applies migrations to every one of the schemas (see WithSchema). It
+	// does not stop on failures of single schemas, but collects them into SchemaMigrationError.
+	// Returns a number of applied migrations per schema
+	MigrateUpAll(ctx context.Context, schemas []string) (map[string]int, error)
+	// CheckSchema compares the key value table with the definition its migrations and the
+	// selected features produce, which are applied to a scratch schema in a transaction that
+	// is rolled back. Reports missing and extra columns, type mismatches and missing indexes
+	CheckSchema() ([]SchemaDrift, error)
+	// MustMatchSchema does the same thing as CheckSchema, but panics on error or any drift,
+	// so that services could refuse to start against a drifted table
+	MustMatchSchema()
+	// VerifyChecksums compares the SHA-256 of the applied migrations of the base set and the
+	// selected features with the ones recorded when they were applied, in the
+	// dban_migration_checksums table, and returns the migrations changed since. Migrations
+	// applied before the checksums were recorded are trusted, their checksums being recorded
+	// as they are now
+	VerifyChecksums() ([]MigrationDrift, error)
+}
+
+// MigrationStatus describes which migrations of the key value storage are applied
+type MigrationStatus struct {
+	// Base is true when all base migrations are applied
+	Base bool
+	// Features reports for every known feature whether all of its migrations are applied
+	Features map[Feature]bool
+}
+
+// KVMigratorOption is an optional parameter of a key value migrator
+type KVMigratorOption func(*kvMigrator)
+
+// WithFeatures selects feature groups to migrate along with the base migrations.
+// Down migrations revert only the selected groups
+func WithFeatures(features ...Feature) KVMigratorOption {
+	return func(m *kvMigrator) {
+		m.features = append(m.features, features...)
+	}
+}
+
+// WithDialect makes the migrator apply the base *.sql migrations from migrations using
+// the sql-migrate dialect (e.g. "mysql") instead of the embedded Postgres ones. Feature
+// groups are not available for other dialects
+func WithDialect(dialect string, migrations fs.FS) KVMigratorOption {
+	return func(m *kvMigrator) {
+		m.dialect = dialect
+		m.source = &migrate.HttpFileSystemMigrationSource{FileSystem: http.FS(migrations)}
+		m.groups = nil
+	}
+}
+
+// WithMigrationTable makes the migrator track the base migrations in the table instead of
+// gorp_migrations, the default one of sql-migrate, so that they are not mixed with the
+// migrations of an application using sql-migrate for its own schema. The table is set for
+// the migrations of the migrator only rather than with migrate.SetTable, so the application
+// is not affected. Feature groups are tracked in their own tables regardless of it
+func WithMigrationTable(table string) KVMigratorOption {
+	return func(m *kvMigrator) {
+		m.migrationTable = table
+	}
+}
+
+// WithMigrationSchema makes the migrator track applied migrations in the schema (created
+// if missing) instead of the one of the key value storage (see WithSchema). It is not
+// supported by MigrateUpAll, as the migrations of every schema would be tracked in it
+func WithMigrationSchema(schema string) KVMigratorOption {
+	return func(m *kvMigrator) {
+		m.migrationSchema = schema
+	}
+}
+
+type kvMigrator struct {
+	db      *sql.DB
+	log     *logan.Entry
+	dialect string
+	source  migrate.MigrationSource
+
+	// kvTable is the key value table the migrations create (see WithKeyValueTable)
+	kvTable KeyValueTable
+
+	migrationTable  string
+	migrationSchema string
+	// extra are merged with source into the base set (see WithExtraMigrations)
+	extra []migrate.MigrationSource
+
+	groups   []featureGroup
+	features []Feature
+
+	forceDestructive bool
+	dump             io.Writer
+	// skipChecksums disables the verification of the checksums (see WithoutChecksumVerification)
+	skipChecksums bool
+	// skipLock disables the migration lock (see WithoutMigrationLock)
+	skipLock    bool
+	lockTimeout time.Duration
+
+	schema            string
+	schemaConcurrency int
+
+	before BeforeMigrationHook
+	after  AfterMigrationHook
+}
+
+// migrationSet is a source of migrations tracked in its own table
+type migrationSet struct {
+	migrate.MigrationSet
+	source migrate.MigrationSource
+}
+
+// defaultMigrationTable is the table sql-migrate tracks migrations in unless it is set
+const defaultMigrationTable = "gorp_migrations"
+
+// table returns the name of the table tracking the migrations of the set
+func (s migrationSet) table() string {
+	if s.TableName == "" {
+		return defaultMigrationTable
+	}
+	return s.TableName
+}
+
+// NewKVMigrator creates a new instance of a migrator for the key value storage. Log
+// could be omitted (in that case, migrator wouldn't log anything)
+func NewKVMigrator(db *sql.DB, log *logan.Entry, opts ...KVMigratorOption) KeyValueMigrator {
+	return newKVMigrator(db, log, kvMigrations, opts...)
+}
+
+// NewKVMigratorWithDialect creates a new instance of a migrator applying the embedded base
+// migrations with the sql-migrate dialect, e.g. "sqlite3", or "postgres" for CockroachDB,
+// which speaks the protocol of Postgres. Dialects sql-migrate does not know are rejected, as
+// well as "mysql", as key is a reserved word there: mysqlq.NewKVMigrator applies migrations
+// of its own instead. Feature groups rely on Postgres, so they are available with "postgres"
+// only
+func NewKVMigratorWithDialect(db *sql.DB, dialect string, log *logan.Entry, opts ...KVMigratorOption) (KeyValueMigrator, error) {
+	if _, ok := migrate.MigrationDialects[dialect]; !ok {
+		return nil, errors.From(errors.New("unknown migration dialect"), logan.F{"dialect": dialect})
+	}
+	if dialect == "mysql" {
+		return nil, errors.New("embedded migrations do not support mysql, use mysqlq.NewKVMigrator")
+	}
+
+	migrator := newKVMigrator(db, log, kvMigrations, opts...)
+	migrator.dialect = dialect
+	if dialect != migrationsDialect {
+		migrator.groups = nil
+	}
+	return migrator, nil
+}
+
+// NewKVMigratorFromDir creates a new instance of a migrator that applies *.sql migrations
+// read from dir at runtime instead of the embedded ones. Files are validated up front:
+// every one of them must follow the <version>_<name>.sql convention with a unique version
+func NewKVMigratorFromDir(db *sql.DB, log *logan.Entry, dir string, opts ...KVMigratorOption) (KeyValueMigrator, error) {
+	fsys := os.DirFS(dir)
+	if err := validateMigrationFiles(fsys); err != nil {
+		return nil, errors.Wrap(err, "invalid migrations directory", logan.F{"dir": dir})
+	}
+
+	source := &migrate.HttpFileSystemMigrationSource{FileSystem: http.FS(fsys)}
+	if _, err := source.FindMigrations(); err != nil {
+		return nil, errors.Wrap(err, "failed to parse migrations", logan.F{"dir": dir})
+	}
+
+	return newKVMigrator(db, log, source, opts...), nil
+}
+
+// NewKVMigratorFromSource creates a new instance of a migrator that applies the migrations
+// of source instead of the embedded ones, e.g. the ones of another migration tool converted
+// to sql-migrate migrations
+func NewKVMigratorFromSource(db *sql.DB, log *logan.Entry, source migrate.MigrationSource, opts ...KVMigratorOption) KeyValueMigrator {
+	return newKVMigrator(db, log, source, opts...)
+}
+
+func newKVMigrator(db *sql.DB, log *logan.Entry, source migrate.MigrationSource, opts ...KVMigratorOption) *kvMigrator {
+	migrator := &kvMigrator{
+		db:      db,
+		log:     log,
+		dialect: migrationsDialect,
+		source:  source,
+		groups:  featureMigrations,
+	}
+	for _, opt := range opts {
+		opt(migrator)
+	}
+	migrator.applyKeyValueTable()
+	if len(migrator.extra) != 0 {
+		migrator.source = append(mergedSource{migrator.source}, migrator.extra...)
+	}
+
+	return migrator
+}
+
+// validateMigrationFiles checks that all *.sql files in fsys follow the naming
+// convention and that no two of them share a version
+func validateMigrationFiles(fsys fs.FS) error {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return errors.Wrap(err, "failed to list migration files")
+	}
+	if len(files) == 0 {
+		return errors.New("no migration files found")
+	}
+
+	var (
+		invalid  []string
+		versions = make(map[uint64]string, len(files))
+	)
+	for _, file := range files {
+		match := migrationFileName.FindStringSubmatch(file)
+		if match == nil {
+			invalid = append(invalid, file)
+			continue
+		}
+
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			invalid = append(invalid, file)
+			continue
+		}
+		if other, ok := versions[version]; ok {
+			invalid = append(invalid, other, file)
+			continue
+		}
+		versions[version] = file
+	}
+
+	if len(invalid) != 0 {
+		sort.Strings(invalid)
+		return errors.From(errors.Errorf(
+			"migration files must be named as <version>_<name>.sql with unique versions: %s",
+			strings.Join(invalid, ", "),
+		), logan.F{"invalid_files": invalid})
+	}
+
+	return nil
+}
+
+func (m *kvMigrator) WithMigrationHooks(before BeforeMigrationHook, after AfterMigrationHook) KeyValueMigrator {
+	clone := *m
+	clone.before = before
+	clone.after = after
+	return &clone
+}
+
+func (m *kvMigrator) MigrateUp() error {
+	_, err := m.MigrateUpN(0)
+	return err
+}
+
+func (m *kvMigrator) MigrateDown() error {
+	_, err := m.MigrateDownN(0)
+	return err
+}
+
+func (m *kvMigrator) MigrateUpN(max int) (int, error) {
+	applied, err := m.migrateN(migrate.Up, max)
+	if err != nil {
+		return applied, errors.Wrap(err, "failed to apply migrations", logan.F{"applied": applied, "max": max})
+	}
+
+	if m.log != nil {
+		m.log.WithFields(logan.F{"applied": applied, "max": max}).Info("Migrations applied")
+	}
+	return applied, nil
+}
+
+func (m *kvMigrator) MigrateDownN(max int) (int, error) {
+	reverted, err := m.migrateN(migrate.Down, max)
+	if err != nil {
+		return reverted, errors.Wrap(err, "failed to revert migrations", logan.F{"reverted": reverted, "max": max})
+	}
+
+	if m.log != nil {
+		m.log.WithFields(logan.F{"reverted": reverted, "max": max}).Info("Migrations reverted")
+	}
+	return reverted, nil
+}
+
+func (m *kvMigrator) Status() (MigrationStatus, error) {
+	base, err := m.isApplied(m.baseSet())
+	if err != nil {
+		return MigrationStatus{}, errors.Wrap(err, "failed to check base migrations")
+	}
+
+	status := MigrationStatus{
+		Base:     base,
+		Features: make(map[Feature]bool, len(m.groups)),
+	}
+	for _, group := range m.groups {
+		applied, err := m.isApplied(m.groupSet(group))
+		if err != nil {
+			return MigrationStatus{}, errors.Wrap(err, "failed to check feature migrations", logan.F{
+				"feature": group.feature,
+			})
+		}
+		status.Features[group.feature] = applied
+	}
+
+	return status, nil
+}
+
+// migrate executes migrations of the base set and the selected features. Features
+// are migrated after the base set and reverted before it
+func (m *kvMigrator) migrate(direction migrate.MigrationDirection) (int, error) {
+	return m.migrateN(direction, 0)
+}
+
+// migrateN does the same thing as migrate, but executes up to max migrations, all of them
+// if max is 0
+func (m *kvMigrator) migrateN(direction migrate.MigrationDirection, max int) (executed int, err error) {
+	if max < 0 {
+		return 0, errors.From(errors.New("max must not be negative"), logan.F{"max": max})
+	}
+	err = m.withMigrationLock(func() error {
+		executed, err = m.migrateLocked(direction, max)
+		return err
+	})
+	return executed, err
+}
+
+// migrateLocked does the same thing as migrateN holding the migration lock
+func (m *kvMigrator) migrateLocked(direction migrate.MigrationDirection, max int) (int, error) {
+	sets, err := m.selectedSets()
+	if err != nil {
+		return 0, err
+	}
+
+	if err = m.ensureSchema(); err != nil {
+		return 0, err
+	}
+	if direction == migrate.Up {
+		if err = m.checkChecksums(); err != nil {
+			return 0, err
+		}
+	}
+
+	if direction == migrate.Down {
+		if err = m.checkUnselectedReverted(); err != nil {
+			return 0, err
+		}
+		for i, j := 0, len(sets)-1; i < j; i, j = i+1, j-1 {
+			sets[i], sets[j] = sets[j], sets[i]
+		}
+	}
+
+	executed := 0
+	for _, set := range sets {
+		left := 0
+		if max > 0 {
+			if left = max - executed; left == 0 {
+				break
+			}
+		}
+		n, err := m.migrateSet(set, direction, left)
+		executed += n
+		if err != nil {
+			return executed, err
+		}
+	}
+
+	return executed, nil
+}
+
+// migrateSet executes up to max planned migrations of the set one at a time, so that hooks
+// could be invoked around each of them
+func (m *kvMigrator) migrateSet(set migrationSet, direction migrate.MigrationDirection, max int) (int, error) {
+	planned, _, err := set.PlanMigration(m.db, m.dialect, set.source, direction, max)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to plan migrations", logan.F{"table": set.TableName})
+	}
+
+	executed := 0
+	for _, migration := range planned {
+		if direction == migrate.Down {
+			if err = m.guardDestructive(migration); err != nil {
+				return executed, err
+			}
+		}
+
+		m.runBefore(migration.Id)
+
+		start := time.Now()
+		_, err = set.ExecMax(m.db, m.dialect, set.source, direction, 1)
+		m.runAfter(migration.Id, err, time.Since(start))
+
+		if err != nil {
+			return executed, errors.Wrap(err, "failed to execute migration", logan.F{
+				"migration_id": migration.Id,
+			})
+		}
+		executed++
+
+		// a checksum failed to be recorded is backfilled by the next verification
+		if err = m.trackChecksum(set, migration.Migration, direction); err != nil && m.log != nil {
+			m.log.WithError(err).WithField("migration_id", migration.Id).Warn("Failed to track migration checksum")
+		}
+	}
+
+	return executed, nil
+}
+
+// checkUnselectedReverted makes sure that reverting the base migrations won't leave
+// migrations of features that were not selected marked as applied
+func (m *kvMigrator) checkUnselectedReverted() error {
+	selected, err := m.selectedFeatures()
+	if err != nil {
+		return err
+	}
+
+	for _, group := range m.groups {
+		if selected[group.feature] {
+			continue
+		}
+
+		// read without creating the table tracking them, so that PlanDown makes no writes
+		applied, err := m.appliedIDs(m.groupSet(group))
+		if err != nil {
+			return errors.Wrap(err, "failed to get feature migration records", logan.F{
+				"feature": group.feature,
+			})
+		}
+		if len(applied) != 0 {
+			return errors.From(errors.New("feature is applied but not selected to be reverted"), logan.F{
+				"feature": group.feature,
+			})
+		}
+	}
+
+	return nil
+}
+
+// selectedSets returns the base set followed by sets of the selected features
+// in the order they must be applied
+func (m *kvMigrator) selectedSets() ([]migrationSet, error) {
+	sets, _, err := m.setFeatures()
+	return sets, err
+}
+
+func (m *kvMigrator) selectedFeatures() (map[Feature]bool, error) {
+	known := make(map[Feature]bool, len(m.groups))
+	for _, group := range m.groups {
+		known[group.feature] = true
+	}
+
+	selected := make(map[Feature]bool, len(m.features))
+	for _, feature := range m.features {
+		if !known[feature] {
+			return nil, errors.From(errors.New("unknown feature"), logan.F{"feature": feature})
+		}
+		selected[feature] = true
+	}
+
+	return selected, nil
+}
+
+func (m *kvMigrator) baseSet() migrationSet {
+	return m.set(m.migrationTable, m.source)
+}
+
+func (m *kvMigrator) groupSet(group featureGroup) migrationSet {
+	return m.set(m.groupTable(group), group.source)
+}
+
+func (m *kvMigrator) set(table string, source migrate.MigrationSource) migrationSet {
+	source = withDialect(source, m.dialect)
+	if m.schema != "" {
+		source = schemaSource{MigrationSource: source, schema: m.schema}
+	}
+
+	schema := m.schema
+	if m.migrationSchema != "" {
+		schema = m.migrationSchema
+	}
+	return migrationSet{
+		MigrationSet: migrate.MigrationSet{TableName: table, SchemaName: schema},
+		source:       source,
+	}
+}
+
+// isApplied returns true if there are no migrations of the set to apply
+func (m *kvMigrator) isApplied(set migrationSet) (bool, error) {
+	planned, _, err := set.PlanMigration(m.db, m.dialect, set.source, migrate.Up, 0)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to plan migrations", logan.F{"table": set.TableName})
+	}
+
+	return len(planned) == 0, nil
+}
+
+func (m *kvMigrator) runBefore(id string) {
+	if m.before == nil {
+		return
+	}
+
+	defer m.recoverHook(id)
+	m.before(id)
+}
+
+func (m *kvMigrator) runAfter(id string, err error, took time.Duration) {
+	if m.after == nil {
+		return
+	}
+
+	defer m.recoverHook(id)
+	m.after(id, err, took)
+}
+
+// recoverHook swallows a panic of a migration hook so that it cannot break the migration run
+func (m *kvMigrator) recoverHook(id string) {
+	if rec := recover(); rec != nil && m.log != nil {
+		m.log.WithRecover(rec).WithField("migration_id", id).Error("Migration hook panicked")
+	}
+}
@@ -0,0 +1,248 @@
+package dban
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// checksumTable is the table the checksums of the applied migrations are recorded in, in the
+// schema of the tables tracking them
+const checksumTable = "dban_migration_checksums"
+
+// ErrMigrationDrift is matched by MigrationDriftError
+var ErrMigrationDrift = errors.New("applied migrations were changed")
+
+// MigrationDriftError is returned by MigrateUp when some of the applied migrations were
+// changed since they were applied (see VerifyChecksums)
+type MigrationDriftError struct {
+	// IDs are the changed migrations in the order they are applied in
+	IDs []string
+}
+
+func (e *MigrationDriftError) Error() string {
+	return fmt.Sprintf("%d applied migration(s) were changed: %s", len(e.IDs), strings.Join(e.IDs, ", "))
+}
+
+// Is makes MigrationDriftError match ErrMigrationDrift
+func (e *MigrationDriftError) Is(target error) bool {
+	return target == ErrMigrationDrift
+}
+
+// MigrationDrift is an applied migration whose contents differ from the ones it was applied
+// with
+type MigrationDrift struct {
+	// ID is the name of the migration file
+	ID string `json:"id"`
+	// Feature is the feature group of the migration, empty for the base migrations
+	Feature Feature `json:"feature,omitempty"`
+	// Recorded is the SHA-256 of the migration recorded when it was applied
+	Recorded string `json:"recorded"`
+	// Actual is the SHA-256 of the migration as it is now
+	Actual string `json:"actual"`
+}
+
+// WithoutChecksumVerification makes MigrateUp and MigrateUpAll apply migrations without
+// verifying the checksums of the applied ones first (see VerifyChecksums)
+func WithoutChecksumVerification() KVMigratorOption {
+	return func(m *kvMigrator) {
+		m.skipChecksums = true
+	}
+}
+
+// migrationChecksum returns the SHA-256 of the statements of the migration in both
+// directions, in hex
+func migrationChecksum(migration *migrate.Migration) string {
+	hash := sha256.New()
+	for _, statements := range [][]string{migration.Up, migration.Down} {
+		for _, statement := range statements {
+			// the length prefix keeps statements moved across the boundary from matching
+			_, _ = fmt.Fprintf(hash, "%d:%s", len(statement), statement)
+		}
+		_, _ = hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (m *kvMigrator) VerifyChecksums() ([]MigrationDrift, error) {
+	sets, features, err := m.setFeatures()
+	if err != nil {
+		return nil, err
+	}
+	if err = m.ensureSchema(); err != nil {
+		return nil, err
+	}
+
+	var drifts []MigrationDrift
+	for i, set := range sets {
+		setDrifts, err := m.verifySet(set, features[i])
+		if err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, setDrifts...)
+	}
+	return drifts, nil
+}
+
+// verifySet compares the checksums of the applied migrations of the set with the recorded
+// ones. Migrations applied before the checksums were recorded are trusted: their checksums
+// are recorded as they are now
+func (m *kvMigrator) verifySet(set migrationSet, feature Feature) ([]MigrationDrift, error) {
+	applied, err := m.appliedIDs(set)
+	if err != nil || len(applied) == 0 {
+		return nil, err
+	}
+	recorded, err := m.recordedChecksums(set)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := set.source.FindMigrations()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find migrations")
+	}
+
+	var drifts []MigrationDrift
+	for _, migration := range migrations {
+		if !applied[migration.Id] {
+			continue
+		}
+		actual := migrationChecksum(migration)
+		checksum, ok := recorded[migration.Id]
+		if !ok {
+			if err = m.recordChecksum(set, migration, false); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if checksum != actual {
+			drifts = append(drifts, MigrationDrift{ID: migration.Id, Feature: feature, Recorded: checksum, Actual: actual})
+		}
+	}
+	return drifts, nil
+}
+
+// checkChecksums fails with MigrationDriftError if some of the applied migrations were
+// changed, unless the verification is disabled
+func (m *kvMigrator) checkChecksums() error {
+	if m.skipChecksums {
+		return nil
+	}
+	drifts, err := m.VerifyChecksums()
+	if err != nil {
+		return errors.Wrap(err, "failed to verify checksums")
+	}
+	if len(drifts) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(drifts))
+	for i, drift := range drifts {
+		ids[i] = drift.ID
+	}
+	return &MigrationDriftError{IDs: ids}
+}
+
+// quotedChecksumTable creates the checksum table in the schema of the set if it is missing
+// and returns its name quoted for the dialect of the migrator along with the placeholders of it
+func (m *kvMigrator) quotedChecksumTable(set migrationSet) (table string, bind func(i int) string, err error) {
+	dialect, ok := migrate.MigrationDialects[m.dialect]
+	if !ok {
+		return "", nil, errors.From(errors.New("unknown migration dialect"), logan.F{"dialect": m.dialect})
+	}
+	table = dialect.QuotedTableForQuery(set.SchemaName, checksumTable)
+	_, err = m.db.Exec("CREATE TABLE IF NOT EXISTS " + table + " (" +
+		"migration_table VARCHAR(255) NOT NULL, " +
+		"id VARCHAR(255) NOT NULL, " +
+		"checksum CHAR(64) NOT NULL, " +
+		"PRIMARY KEY (migration_table, id))")
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to create checksum table", logan.F{"schema": set.SchemaName})
+	}
+	return table, dialect.BindVar, nil
+}
+
+// recordedChecksums reads the checksums recorded for the migrations of the set by their ids
+func (m *kvMigrator) recordedChecksums(set migrationSet) (map[string]string, error) {
+	table, bind, err := m.quotedChecksumTable(set)
+	if err != nil {
+		return nil, err
+	}
+	fields := logan.F{"table": set.table()}
+
+	rows, err := m.db.Query("SELECT id, checksum FROM "+table+" WHERE migration_table = "+bind(0),
+		set.table())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select checksums", fields)
+	}
+	defer rows.Close()
+
+	recorded := make(map[string]string)
+	for rows.Next() {
+		var id, checksum string
+		if err = rows.Scan(&id, &checksum); err != nil {
+			return nil, errors.Wrap(err, "failed to scan checksum", fields)
+		}
+		recorded[id] = checksum
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to select checksums", fields)
+	}
+	return recorded, nil
+}
+
+// recordChecksum records the checksum of the migration of the set, replacing the recorded
+// one if overwrite is set and leaving it intact otherwise, e.g. when another instance
+// backfilled it meanwhile
+func (m *kvMigrator) recordChecksum(set migrationSet, migration *migrate.Migration, overwrite bool) error {
+	table, bind, err := m.quotedChecksumTable(set)
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT INTO " + table + " (migration_table, id, checksum) VALUES (" +
+		bind(0) + ", " + bind(1) + ", " + bind(2) + ")"
+	switch {
+	case m.dialect == "mysql" && overwrite:
+		query += " ON DUPLICATE KEY UPDATE checksum = VALUES(checksum)"
+	case m.dialect == "mysql":
+		query = "INSERT IGNORE" + strings.TrimPrefix(query, "INSERT")
+	case overwrite:
+		query += " ON CONFLICT (migration_table, id) DO UPDATE SET checksum = excluded.checksum"
+	default:
+		query += " ON CONFLICT (migration_table, id) DO NOTHING"
+	}
+
+	if _, err = m.db.Exec(query, set.table(), migration.Id, migrationChecksum(migration)); err != nil {
+		return errors.Wrap(err, "failed to record checksum", logan.F{"migration_id": migration.Id})
+	}
+	return nil
+}
+
+// trackChecksum records the checksum of the migration of the set once it is applied and
+// deletes it once it is reverted
+func (m *kvMigrator) trackChecksum(set migrationSet, migration *migrate.Migration, direction migrate.MigrationDirection) error {
+	if direction == migrate.Down {
+		return m.forgetChecksum(set, migration.Id)
+	}
+	return m.recordChecksum(set, migration, true)
+}
+
+// forgetChecksum deletes the checksum of the reverted migration of the set
+func (m *kvMigrator) forgetChecksum(set migrationSet, id string) error {
+	table, bind, err := m.quotedChecksumTable(set)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.db.Exec("DELETE FROM "+table+" WHERE migration_table = "+bind(0)+" AND id = "+bind(1),
+		set.table(), id)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete checksum", logan.F{"migration_id": id})
+	}
+	return nil
+}
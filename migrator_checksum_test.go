@@ -0,0 +1,66 @@
+package dban
+
+import (
+	"testing"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVMigratorVerifyChecksums(t *testing.T) {
+	db := newTestDB(t)
+	edited := func() *migrate.MemoryMigrationSource {
+		first := *testMigrations.Migrations[0]
+		first.Up = []string{"create table first (id integer, name text)"}
+		return &migrate.MemoryMigrationSource{Migrations: []*migrate.Migration{&first, testMigrations.Migrations[1]}}
+	}
+	checksums := func() int {
+		var n int
+		require.NoError(t, db.QueryRow("select count(*) from dban_migration_checksums").Scan(&n))
+		return n
+	}
+
+	migrator := newSQLiteMigrator(db, testMigrations)
+	require.NoError(t, migrator.MigrateUp())
+	assert.Equal(t, 2, checksums(), "checksums must be recorded once migrations are applied")
+	drifts, err := migrator.VerifyChecksums()
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	changed := newSQLiteMigrator(db, edited())
+	drifts, err = changed.VerifyChecksums()
+	require.NoError(t, err)
+	require.Len(t, drifts, 1)
+	assert.Equal(t, "001_first.sql", drifts[0].ID)
+	assert.Empty(t, drifts[0].Feature)
+	assert.NotEqual(t, drifts[0].Recorded, drifts[0].Actual)
+	assert.Equal(t, migrationChecksum(testMigrations.Migrations[0]), drifts[0].Recorded)
+
+	err = changed.MigrateUp()
+	assert.True(t, Is(err, ErrMigrationDrift), "%v", err)
+	assert.ErrorContains(t, err, "001_first.sql")
+	require.NoError(t, newSQLiteMigrator(db, edited(), WithoutChecksumVerification()).MigrateUp())
+
+	t.Run("backfill", func(t *testing.T) {
+		_, err := db.Exec("delete from dban_migration_checksums")
+		require.NoError(t, err)
+
+		drifts, err := changed.VerifyChecksums()
+		require.NoError(t, err)
+		assert.Empty(t, drifts, "migrations applied before checksums were recorded must be trusted")
+		assert.Equal(t, 2, checksums())
+
+		drifts, err = migrator.VerifyChecksums()
+		require.NoError(t, err)
+		require.Len(t, drifts, 1, "the backfilled checksums must be verified")
+		assert.Equal(t, "001_first.sql", drifts[0].ID)
+	})
+
+	t.Run("revert", func(t *testing.T) {
+		require.NoError(t, newSQLiteMigrator(db, edited(), WithForceDestructive()).MigrateDown())
+		assert.Zero(t, checksums(), "checksums of reverted migrations must be deleted")
+		require.NoError(t, migrator.MigrateUp(), "migrations applied again must be verified against their new contents")
+		assert.Equal(t, 2, checksums())
+	})
+}
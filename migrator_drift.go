@@ -0,0 +1,232 @@
+package dban
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+	migrate "github.com/rubenv/sql-migrate"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// schemaCheckSchema is a scratch schema the expected definition of the key value table is
+// built in. It is created within a transaction that is always rolled back
+const schemaCheckSchema = "dban_schema_check"
+
+// SchemaDriftKind is a kind of difference between the key value table and its migrations
+type SchemaDriftKind string
+
+const (
+	// DriftMissingColumn is a column created by the migrations that the table lacks
+	DriftMissingColumn SchemaDriftKind = "missing_column"
+	// DriftExtraColumn is a column of the table that the migrations do not create
+	DriftExtraColumn SchemaDriftKind = "extra_column"
+	// DriftColumnType is a column whose type or nullability differs from the migrations
+	DriftColumnType SchemaDriftKind = "column_type"
+	// DriftMissingIndex is an index created by the migrations that the table lacks
+	DriftMissingIndex SchemaDriftKind = "missing_index"
+)
+
+// SchemaDrift is a difference between the key value table and its migrations
+type SchemaDrift struct {
+	Kind SchemaDriftKind `json:"kind"`
+	// Name is a name of the column or the index
+	Name     string `json:"name"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+func (d SchemaDrift) String() string {
+	if d.Kind == DriftColumnType {
+		return fmt.Sprintf("%s %s: expected %s, got %s", d.Kind, d.Name, d.Expected, d.Actual)
+	}
+	return fmt.Sprintf("%s %s", d.Kind, d.Name)
+}
+
+// tableDefinition describes the columns (mapped to their types) and indexes of a table
+type tableDefinition struct {
+	columns map[string]string
+	indexes map[string]bool
+}
+
+func (m *kvMigrator) CheckSchema() ([]SchemaDrift, error) {
+	if m.dialect != migrationsDialect {
+		return nil, errors.From(errors.New("schema could be checked for Postgres only"), logan.F{
+			"dialect": m.dialect,
+		})
+	}
+
+	expected, err := m.expectedDefinition()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build expected definition of the table")
+	}
+
+	schema := m.schema
+	if schema == "" {
+		if err = m.db.QueryRow("SELECT current_schema()").Scan(&schema); err != nil {
+			return nil, errors.Wrap(err, "failed to get current schema")
+		}
+	}
+	actual, err := describeTable(m.db, schema, m.tableName())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to describe the table", logan.F{"schema": schema})
+	}
+
+	return diffDefinitions(expected, actual), nil
+}
+
+func (m *kvMigrator) MustMatchSchema() {
+	drifts, err := m.CheckSchema()
+	if err != nil {
+		panic(errors.Wrap(err, "failed to check schema of the key value table"))
+	}
+	if len(drifts) != 0 {
+		descriptions := make([]string, len(drifts))
+		for i, drift := range drifts {
+			descriptions[i] = drift.String()
+		}
+		panic(errors.From(errors.New("key value table does not match its migrations"), logan.F{
+			"drift": strings.Join(descriptions, "; "),
+		}))
+	}
+}
+
+// expectedDefinition applies the base migrations and the ones of the selected features to
+// a scratch schema and describes the resulting table. Nothing is committed
+func (m *kvMigrator) expectedDefinition() (tableDefinition, error) {
+	selected, err := m.selectedFeatures()
+	if err != nil {
+		return tableDefinition{}, err
+	}
+	sources := []migrate.MigrationSource{m.source}
+	for _, group := range m.groups {
+		if selected[group.feature] {
+			sources = append(sources, group.source)
+		}
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return tableDefinition{}, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, statement := range []string{
+		"CREATE SCHEMA " + pq.QuoteIdentifier(schemaCheckSchema),
+		"SET LOCAL search_path TO " + pq.QuoteIdentifier(schemaCheckSchema),
+	} {
+		if _, err = tx.Exec(statement); err != nil {
+			return tableDefinition{}, errors.Wrap(err, "failed to prepare scratch schema")
+		}
+	}
+
+	for _, source := range sources {
+		migrations, err := source.FindMigrations()
+		if err != nil {
+			return tableDefinition{}, errors.Wrap(err, "failed to find migrations")
+		}
+		for _, migration := range migrations {
+			for _, statement := range migration.Up {
+				if _, err = tx.Exec(statement); err != nil {
+					return tableDefinition{}, errors.Wrap(err, "failed to apply migration", logan.F{
+						"migration_id": migration.Id,
+					})
+				}
+			}
+		}
+	}
+
+	return describeTable(tx, schemaCheckSchema, m.tableName())
+}
+
+// describeTable reads the definition of the key value table in the schema
+func describeTable(db interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, schema, table string) (tableDefinition, error) {
+	definition := tableDefinition{
+		columns: make(map[string]string),
+		indexes: make(map[string]bool),
+	}
+
+	rows, err := db.Query(`SELECT column_name, data_type, coalesce(character_maximum_length, 0), is_nullable
+		FROM information_schema.columns WHERE table_schema = $1 AND table_name = $2`, schema, table)
+	if err != nil {
+		return tableDefinition{}, errors.Wrap(err, "failed to select columns")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			name, dataType, nullable string
+			length                   int
+		)
+		if err = rows.Scan(&name, &dataType, &length, &nullable); err != nil {
+			return tableDefinition{}, errors.Wrap(err, "failed to scan column")
+		}
+		definition.columns[name] = columnType(dataType, length, nullable == "YES")
+	}
+	if err = rows.Err(); err != nil {
+		return tableDefinition{}, errors.Wrap(err, "failed to iterate over columns")
+	}
+
+	indexes, err := db.Query("SELECT indexname FROM pg_indexes WHERE schemaname = $1 AND tablename = $2",
+		schema, table)
+	if err != nil {
+		return tableDefinition{}, errors.Wrap(err, "failed to select indexes")
+	}
+	defer indexes.Close()
+	for indexes.Next() {
+		var name string
+		if err = indexes.Scan(&name); err != nil {
+			return tableDefinition{}, errors.Wrap(err, "failed to scan index")
+		}
+		definition.indexes[name] = true
+	}
+
+	return definition, errors.Wrap(indexes.Err(), "failed to iterate over indexes")
+}
+
+// columnType formats a type of a column the way drifts report it, e.g. "character varying(64) not null"
+func columnType(dataType string, length int, nullable bool) string {
+	if length > 0 {
+		dataType = fmt.Sprintf("%s(%d)", dataType, length)
+	}
+	if !nullable {
+		dataType += " not null"
+	}
+	return dataType
+}
+
+// diffDefinitions lists drifts of actual from expected, sorted by kind and name
+func diffDefinitions(expected, actual tableDefinition) []SchemaDrift {
+	var drifts []SchemaDrift
+	for name, expectedType := range expected.columns {
+		actualType, ok := actual.columns[name]
+		switch {
+		case !ok:
+			drifts = append(drifts, SchemaDrift{Kind: DriftMissingColumn, Name: name, Expected: expectedType})
+		case actualType != expectedType:
+			drifts = append(drifts, SchemaDrift{Kind: DriftColumnType, Name: name, Expected: expectedType, Actual: actualType})
+		}
+	}
+	for name, actualType := range actual.columns {
+		if _, ok := expected.columns[name]; !ok {
+			drifts = append(drifts, SchemaDrift{Kind: DriftExtraColumn, Name: name, Actual: actualType})
+		}
+	}
+	for name := range expected.indexes {
+		if !actual.indexes[name] {
+			drifts = append(drifts, SchemaDrift{Kind: DriftMissingIndex, Name: name})
+		}
+	}
+
+	sort.Slice(drifts, func(i, j int) bool {
+		if drifts[i].Kind != drifts[j].Kind {
+			return drifts[i].Kind < drifts[j].Kind
+		}
+		return drifts[i].Name < drifts[j].Name
+	})
+	return drifts
+}
@@ -0,0 +1,69 @@
+package dban
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffDefinitions(t *testing.T) {
+	expected := tableDefinition{
+		columns: map[string]string{
+			"key":        "character varying(64) not null",
+			"value":      "character varying(64) not null",
+			"expires_at": "timestamp with time zone",
+		},
+		indexes: map[string]bool{"key_value_key_key": true, "key_value_expires_at_idx": true},
+	}
+	actual := tableDefinition{
+		columns: map[string]string{
+			"key":   "character varying(64) not null",
+			"value": "text not null",
+			"note":  "text",
+		},
+		indexes: map[string]bool{"key_value_key_key": true, "key_value_note_idx": true},
+	}
+
+	assert.Equal(t, []SchemaDrift{
+		{Kind: DriftColumnType, Name: "value", Expected: "character varying(64) not null", Actual: "text not null"},
+		{Kind: DriftExtraColumn, Name: "note", Actual: "text"},
+		{Kind: DriftMissingColumn, Name: "expires_at", Expected: "timestamp with time zone"},
+		{Kind: DriftMissingIndex, Name: "key_value_expires_at_idx"},
+	}, diffDefinitions(expected, actual))
+	assert.Empty(t, diffDefinitions(expected, expected))
+}
+
+func TestKVMigratorCheckSchema(t *testing.T) {
+	db := openTestPostgres(t)
+	migrator := migrateTestPostgres(t, db, WithFeatures(FeatureTTL))
+
+	drifts, err := migrator.CheckSchema()
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+	assert.NotPanics(t, migrator.MustMatchSchema)
+
+	_, err = db.RawDB().Exec("DROP INDEX key_value_expires_at_idx")
+	require.NoError(t, err)
+	_, err = db.RawDB().Exec("ALTER TABLE key_value ADD COLUMN note text")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, err := db.RawDB().Exec("ALTER TABLE key_value DROP COLUMN note")
+		require.NoError(t, err)
+		_, err = db.RawDB().Exec("CREATE INDEX key_value_expires_at_idx ON key_value (expires_at) WHERE expires_at IS NOT NULL")
+		require.NoError(t, err)
+	})
+
+	drifts, err = migrator.CheckSchema()
+	require.NoError(t, err)
+	assert.Equal(t, []SchemaDrift{
+		{Kind: DriftExtraColumn, Name: "note", Actual: "text"},
+		{Kind: DriftMissingIndex, Name: "key_value_expires_at_idx"},
+	}, drifts)
+	assert.Panics(t, migrator.MustMatchSchema)
+
+	// the table is checked against the selected features only
+	drifts, err = NewKVMigrator(db.RawDB(), nil).CheckSchema()
+	require.NoError(t, err)
+	assert.Contains(t, drifts, SchemaDrift{Kind: DriftExtraColumn, Name: "expires_at", Actual: "timestamp with time zone"})
+}
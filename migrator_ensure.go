@@ -0,0 +1,142 @@
+package dban
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// ErrPendingMigrations is matched by PendingMigrationsError
+var ErrPendingMigrations = errors.New("migrations are pending")
+
+// PendingMigrationsError is returned by EnsureMigrated when some of the migrations are not
+// applied yet
+type PendingMigrationsError struct {
+	// IDs are the pending migrations in the order they are applied in
+	IDs []string
+}
+
+func (e *PendingMigrationsError) Error() string {
+	return fmt.Sprintf("%d migration(s) are pending: %s", len(e.IDs), strings.Join(e.IDs, ", "))
+}
+
+// Is makes PendingMigrationsError match ErrPendingMigrations
+func (e *PendingMigrationsError) Is(target error) bool {
+	return target == ErrPendingMigrations
+}
+
+func (m *kvMigrator) EnsureMigrated() error {
+	_, pending, err := m.readMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) != 0 {
+		return &PendingMigrationsError{IDs: pending}
+	}
+	return nil
+}
+
+func (m *kvMigrator) Version() (string, error) {
+	applied, _, err := m.readMigrations()
+	if err != nil || len(applied) == 0 {
+		return "", err
+	}
+	return applied[len(applied)-1], nil
+}
+
+// readMigrations returns the ids of the applied and the pending migrations of the base set
+// and the selected features in the order they are applied in, making no writes
+func (m *kvMigrator) readMigrations() (applied, pending []string, err error) {
+	sets, err := m.selectedSets()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, set := range sets {
+		ids, err := m.appliedIDs(set)
+		if err != nil {
+			return nil, nil, err
+		}
+		migrations, err := set.source.FindMigrations()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to find migrations")
+		}
+		for _, migration := range migrations {
+			if ids[migration.Id] {
+				applied = append(applied, migration.Id)
+			} else {
+				pending = append(pending, migration.Id)
+			}
+		}
+	}
+	return applied, pending, nil
+}
+
+// appliedIDs reads the ids of the applied migrations of the set without creating the table
+// tracking them, unlike sql-migrate, so that it could be called without DDL privileges.
+// A missing table means there are none
+func (m *kvMigrator) appliedIDs(set migrationSet) (map[string]bool, error) {
+	table := set.table()
+	fields := logan.F{"table": table, "schema": set.SchemaName}
+
+	exists, err := m.tableExists(set.SchemaName, table)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check migration table", fields)
+	}
+	if !exists {
+		return map[string]bool{}, nil
+	}
+
+	dialect, ok := migrate.MigrationDialects[m.dialect]
+	if !ok {
+		return nil, errors.From(errors.New("unknown migration dialect"), logan.F{"dialect": m.dialect})
+	}
+	rows, err := m.db.Query("SELECT id FROM " + dialect.QuotedTableForQuery(set.SchemaName, table))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select applied migrations", fields)
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, errors.Wrap(err, "failed to scan applied migration", fields)
+		}
+		applied[id] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to select applied migrations", fields)
+	}
+	return applied, nil
+}
+
+// tableExists tells whether the table exists in the schema, the default one if it is empty
+func (m *kvMigrator) tableExists(schema, table string) (bool, error) {
+	var row *sql.Row
+	switch m.dialect {
+	case "postgres":
+		qualified := table
+		if schema != "" {
+			qualified = schema + "." + table
+		}
+		row = m.db.QueryRow("SELECT to_regclass($1) IS NOT NULL", qualified)
+	case "sqlite3":
+		row = m.db.QueryRow("SELECT count(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = ?", table)
+	case "mysql":
+		row = m.db.QueryRow("SELECT count(*) > 0 FROM information_schema.tables "+
+			"WHERE table_schema = COALESCE(NULLIF(?, ''), DATABASE()) AND table_name = ?", schema, table)
+	default:
+		return false, errors.From(errors.New("dialect is not supported"), logan.F{"dialect": m.dialect})
+	}
+
+	var exists bool
+	if err := row.Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
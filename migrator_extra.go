@@ -0,0 +1,70 @@
+package dban
+
+import (
+	"io/fs"
+	"net/http"
+	"sort"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// WithExtraMigrations makes the migrator apply the *.sql migrations in the root directory of
+// fsys (e.g. an embed.FS) along with the base ones as a single set, ordered by their ids, so
+// that tables of the application related to the streamers share the migration history of
+// the key value storage. An id of the extra migrations repeating one of the base ones makes
+// every method fail before executing any of them
+func WithExtraMigrations(fsys fs.FS, root string) KVMigratorOption {
+	return func(m *kvMigrator) {
+		m.extra = append(m.extra, extraSource{fsys: fsys, root: root})
+	}
+}
+
+// extraSource is a directory of extra migrations (see WithExtraMigrations)
+type extraSource struct {
+	fsys fs.FS
+	root string
+}
+
+func (s extraSource) FindMigrations() ([]*migrate.Migration, error) {
+	dir, err := fs.Sub(s.fsys, s.root)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open extra migrations", logan.F{"root": s.root})
+	}
+	migrations, err := (&migrate.HttpFileSystemMigrationSource{FileSystem: http.FS(dir)}).FindMigrations()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find extra migrations", logan.F{"root": s.root})
+	}
+	return migrations, nil
+}
+
+// mergedSource merges migrations of the sources into a single set ordered by their ids
+type mergedSource []migrate.MigrationSource
+
+func (s mergedSource) FindMigrations() ([]*migrate.Migration, error) {
+	var (
+		merged []*migrate.Migration
+		ids    = make(map[string]bool)
+	)
+	for _, source := range s {
+		migrations, err := source.FindMigrations()
+		if err != nil {
+			return nil, err
+		}
+		for _, migration := range migrations {
+			if ids[migration.Id] {
+				return nil, errors.From(errors.Errorf("migration id %s is duplicated", migration.Id), logan.F{
+					"migration_id": migration.Id,
+				})
+			}
+			ids[migration.Id] = true
+			merged = append(merged, migration)
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Less(merged[j])
+	})
+	return merged, nil
+}
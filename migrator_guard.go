@@ -0,0 +1,113 @@
+package dban
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// ErrTableNotEmpty is matched by TableNotEmptyError
+var ErrTableNotEmpty = errors.New("table is not empty")
+
+// TableNotEmptyError is returned when a down migration would drop a non-empty key value table
+// while the migrator runs in the safety mode (see WithForceDestructive)
+type TableNotEmptyError struct {
+	Table string
+	Rows  int64
+}
+
+func (e *TableNotEmptyError) Error() string {
+	return fmt.Sprintf("refusing to drop table %s with %d rows", e.Table, e.Rows)
+}
+
+// Is makes TableNotEmptyError match ErrTableNotEmpty
+func (e *TableNotEmptyError) Is(target error) bool {
+	return target == ErrTableNotEmpty
+}
+
+// WithForceDestructive disables the safety mode in which the migrator refuses to run
+// down migrations dropping a non-empty key value table
+func WithForceDestructive() KVMigratorOption {
+	return func(m *kvMigrator) {
+		m.forceDestructive = true
+	}
+}
+
+// WithDumpBeforeDrop makes the migrator write contents of the key value table to w
+// as JSON lines before a forced down migration drops it
+func WithDumpBeforeDrop(w io.Writer) KVMigratorOption {
+	return func(m *kvMigrator) {
+		m.dump = w
+	}
+}
+
+// guardDestructive checks a down migration that drops the key value table: it returns
+// TableNotEmptyError if the table has rows unless the migrator is forced, in which
+// case the rows are dumped first (if requested)
+func (m *kvMigrator) guardDestructive(migration *migrate.PlannedMigration) error {
+	if !dropsTable(migration, m.tableName()) {
+		return nil
+	}
+
+	var rows int64
+	if err := m.db.QueryRow("SELECT count(*) FROM " + m.table()).Scan(&rows); err != nil {
+		return errors.Wrap(err, "failed to count rows", logan.F{"table": m.table()})
+	}
+	if rows == 0 {
+		return nil
+	}
+
+	if !m.forceDestructive {
+		return &TableNotEmptyError{Table: m.table(), Rows: rows}
+	}
+
+	if m.dump != nil {
+		if err := dumpKeyValues(m.db, m.table(), m.dump); err != nil {
+			return errors.Wrap(err, "failed to dump table before dropping it", logan.F{"table": m.table()})
+		}
+	}
+	if m.log != nil {
+		m.log.WithFields(logan.F{
+			"migration_id": migration.Id,
+			"rows":         rows,
+		}).Warn("Dropping non-empty key value table")
+	}
+
+	return nil
+}
+
+func dropsTable(migration *migrate.PlannedMigration, table string) bool {
+	drop := dropTable(table)
+	for _, query := range migration.Queries {
+		if drop.MatchString(query) {
+			return true
+		}
+	}
+	return false
+}
+
+func dumpKeyValues(db *sql.DB, table string, w io.Writer) error {
+	rows, err := db.Query(fmt.Sprintf("SELECT %s, %s FROM %s ORDER BY %s", keyColumn, valueColumn, table, keyColumn))
+	if err != nil {
+		return errors.Wrap(err, "failed to select key values")
+	}
+	defer rows.Close()
+
+	encoder := json.NewEncoder(w)
+	for rows.Next() {
+		var kv KeyValue
+		if err = rows.Scan(&kv.Key, &kv.Value); err != nil {
+			return errors.Wrap(err, "failed to scan key value")
+		}
+		if err = encoder.Encode(kv); err != nil {
+			return errors.Wrap(err, "failed to write key value", logan.F{"key": kv.Key})
+		}
+	}
+
+	return rows.Err()
+}
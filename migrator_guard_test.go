@@ -0,0 +1,47 @@
+package dban
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+func TestKVMigratorGuardDestructive(t *testing.T) {
+	t.Run("empty table", func(t *testing.T) {
+		migrator := newTestMigrator(t, kvMigrations)
+		require.NoError(t, migrator.MigrateUp())
+		require.NoError(t, migrator.MigrateDown())
+		assert.Empty(t, tableColumns(t, migrator.db, keyValueTable))
+	})
+
+	t.Run("non-empty table", func(t *testing.T) {
+		migrator := newTestMigrator(t, kvMigrations)
+		require.NoError(t, migrator.MigrateUp())
+		_, err := migrator.db.Exec("INSERT INTO key_value (key, value) VALUES ('a', '1'), ('b', '2')")
+		require.NoError(t, err)
+
+		err = migrator.MigrateDown()
+		require.Error(t, err)
+		notEmpty, ok := errors.Cause(err).(*TableNotEmptyError)
+		require.True(t, ok, "unexpected error: %v", err)
+		assert.Equal(t, int64(2), notEmpty.Rows)
+		assert.ErrorIs(t, notEmpty, ErrTableNotEmpty)
+		assert.NotEmpty(t, tableColumns(t, migrator.db, keyValueTable))
+	})
+
+	t.Run("forced dump and drop", func(t *testing.T) {
+		var dump bytes.Buffer
+		migrator := newTestMigrator(t, kvMigrations)
+		require.NoError(t, migrator.MigrateUp())
+		_, err := migrator.db.Exec("INSERT INTO key_value (key, value) VALUES ('b', '2'), ('a', '1')")
+		require.NoError(t, err)
+
+		forced := newSQLiteMigrator(migrator.db, kvMigrations, WithForceDestructive(), WithDumpBeforeDrop(&dump))
+		require.NoError(t, forced.MigrateDown())
+		assert.Empty(t, tableColumns(t, migrator.db, keyValueTable))
+		assert.Equal(t, "{\"key\":\"a\",\"value\":\"1\"}\n{\"key\":\"b\",\"value\":\"2\"}\n", dump.String())
+	})
+}
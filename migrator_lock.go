@@ -0,0 +1,93 @@
+package dban
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// migrationLockPrefix is prepended to the name of the table tracking the base migrations
+// to form the name of the migration lock
+const migrationLockPrefix = "dban:migrate:"
+
+// WithMigrationLockTimeout makes the migrator give up waiting for the migration lock after
+// d, failing with ErrMigrationLockTimeout, so that a replica stuck in the middle of
+// migrating does not hang the startup of the rest forever. The lock is waited for
+// indefinitely by default.
+//
+// The lock is held by a connection of its own while the migrations run with another one, so
+// the pool of the db must allow at least two open connections: with SetMaxOpenConns(1) the
+// migrator fails right away instead of waiting for a connection forever, unless the lock is
+// skipped with WithoutMigrationLock
+func WithMigrationLockTimeout(d time.Duration) KVMigratorOption {
+	return func(m *kvMigrator) {
+		m.lockTimeout = d
+	}
+}
+
+// WithoutMigrationLock makes the migrator run migrations without the migration lock, e.g.
+// for a database of the "postgres" dialect not supporting advisory locks
+func WithoutMigrationLock() KVMigratorOption {
+	return func(m *kvMigrator) {
+		m.skipLock = true
+	}
+}
+
+// migrationLockName returns the name the migration lock of the migrator is derived from:
+// "dban:migrate:" followed by the name of the table tracking the base migrations, qualified
+// with its schema if there is one, e.g. "dban:migrate:gorp_migrations". The id of the
+// advisory lock is the FNV-1a hash of the name (see advisoryLockID)
+func (m *kvMigrator) migrationLockName() string {
+	set := m.baseSet()
+	table := set.table()
+	if set.SchemaName != "" {
+		table = set.SchemaName + "." + table
+	}
+	return migrationLockPrefix + table
+}
+
+// withMigrationLock runs fn holding the Postgres advisory lock of the migrations, so that
+// migrators of the same tables run one at a time. The lock is taken within a transaction
+// of its own, which holds a connection of the db apart from the ones fn migrates with, and
+// is rolled back once fn returns, releasing the lock whatever the outcome is. A pool of a
+// single connection is rejected, as fn would never get one
+func (m *kvMigrator) withMigrationLock(fn func() error) error {
+	if m.skipLock || m.dialect != migrationsDialect {
+		return fn()
+	}
+
+	name := m.migrationLockName()
+	fields := logan.F{"lock": name}
+	if m.db.Stats().MaxOpenConnections == 1 {
+		// fn would wait for the only connection of the pool, held by the lock
+		return errors.From(errors.New("migration lock requires a pool of at least two connections"), fields)
+	}
+	tx, err := m.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin migration lock transaction", fields)
+	}
+	// nothing is written within the transaction, it only holds the lock
+	defer func() { _ = tx.Rollback() }()
+
+	if m.lockTimeout > 0 {
+		// zero disables the timeout, so shorter ones are rounded up to a millisecond
+		ms := m.lockTimeout.Milliseconds()
+		if ms == 0 {
+			ms = 1
+		}
+		if _, err = tx.Exec("SELECT set_config('lock_timeout', $1, true)", strconv.FormatInt(ms, 10)+"ms"); err != nil {
+			return errors.Wrap(err, "failed to set migration lock timeout", fields)
+		}
+	}
+	if _, err = tx.Exec(advisoryLockQuery, advisoryLockID(name)); err != nil {
+		if err = classifyPostgres(err); Is(err, ErrLockTimeout) {
+			return errors.From(ErrMigrationLockTimeout, fields.Add("timeout", m.lockTimeout))
+		}
+		return errors.Wrap(err, "failed to take migration lock", fields)
+	}
+
+	return fn()
+}
@@ -0,0 +1,63 @@
+package dban
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVMigratorMigrationLockName(t *testing.T) {
+	assert.Equal(t, "dban:migrate:gorp_migrations", newKVMigrator(nil, nil, kvMigrations).migrationLockName())
+	assert.Equal(t, "dban:migrate:ops.dban_migrations", newKVMigrator(nil, nil, kvMigrations,
+		WithMigrationTable("dban_migrations"), WithMigrationSchema("ops")).migrationLockName())
+}
+
+func TestKVMigratorMigrationLockSingleConnection(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	db.SetMaxOpenConns(1)
+
+	err = newKVMigrator(db, nil, kvMigrations).withMigrationLock(func() error {
+		t.Fatal("migrations must not run without a connection to run with")
+		return nil
+	})
+	assert.ErrorContains(t, err, "at least two connections")
+
+	require.NoError(t, newKVMigrator(db, nil, kvMigrations, WithoutMigrationLock()).withMigrationLock(func() error {
+		return nil
+	}))
+}
+
+func TestKVMigratorMigrationLockPostgres(t *testing.T) {
+	db := openTestPostgres(t).RawDB()
+	migrator := NewKVMigrator(db, nil, WithMigrationTable("dban_lock_migrations"), WithForceDestructive(),
+		WithMigrationLockTimeout(100*time.Millisecond))
+	t.Cleanup(func() { require.NoError(t, migrator.MigrateDown()) })
+
+	holder, err := db.Begin()
+	require.NoError(t, err)
+	_, err = holder.Exec(advisoryLockQuery, advisoryLockID("dban:migrate:dban_lock_migrations"))
+	require.NoError(t, err)
+	err = migrator.MigrateUp()
+	assert.True(t, Is(err, ErrMigrationLockTimeout), "%v", err)
+	require.NoError(t, holder.Rollback())
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = NewKVMigrator(db, nil, WithMigrationTable("dban_lock_migrations")).MigrateUp()
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(t, err, "concurrent migrators must wait for each other")
+	}
+}
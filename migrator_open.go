@@ -0,0 +1,55 @@
+package dban
+
+import (
+	"database/sql"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// ClosableKVMigrator is a key value migrator owning its connection to the database
+type ClosableKVMigrator interface {
+	KeyValueMigrator
+	// Close closes the connection of the migrator, which the migrators returned by
+	// WithMigrationHooks share
+	Close() error
+}
+
+// NewKVMigratorFromPgdb creates a new instance of a migrator over the connection of db, the
+// one the queriers are created with, so that no other connection is opened to migrate. The
+// connection is checked with a ping, so that an unreachable database is reported right away
+func NewKVMigratorFromPgdb(db *pgdb.DB, log *logan.Entry, opts ...KVMigratorOption) (KeyValueMigrator, error) {
+	rawDB := db.RawDB()
+	if err := rawDB.Ping(); err != nil {
+		return nil, errors.Wrap(err, "failed to ping database")
+	}
+	return newKVMigrator(rawDB, log, kvMigrations, opts...), nil
+}
+
+// NewKVMigratorFromURL creates a new instance of a migrator over a connection to the
+// Postgres database at the URL, which is opened and checked with a ping right away. The
+// connection is kept until Close is called
+func NewKVMigratorFromURL(databaseURL string, log *logan.Entry, opts ...KVMigratorOption) (ClosableKVMigrator, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open database")
+	}
+	if err = db.Ping(); err != nil {
+		_ = db.Close()
+		// the URL is not logged, as it could hold the password
+		return nil, errors.Wrap(err, "failed to ping database")
+	}
+	return &closableKVMigrator{kvMigrator: newKVMigrator(db, log, kvMigrations, opts...)}, nil
+}
+
+type closableKVMigrator struct {
+	*kvMigrator
+}
+
+func (m *closableKVMigrator) Close() error {
+	if err := m.db.Close(); err != nil {
+		return errors.Wrap(err, "failed to close database")
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package dban
+
+import (
+	"fmt"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// TablePersistence is a persistence mode of a Postgres table
+type TablePersistence string
+
+const (
+	// PersistencePermanent is a regular, WAL-logged table
+	PersistencePermanent TablePersistence = "permanent"
+	// PersistenceUnlogged is a table that is not written to WAL: writes are faster,
+	// but the table is truncated after a crash and is not replicated
+	PersistenceUnlogged TablePersistence = "unlogged"
+	// PersistenceTemporary is a temporary table
+	PersistenceTemporary TablePersistence = "temporary"
+)
+
+var relPersistence = map[string]TablePersistence{
+	"p": PersistencePermanent,
+	"u": PersistenceUnlogged,
+	"t": PersistenceTemporary,
+}
+
+// TableStats describes the key value table
+type TableStats struct {
+	Persistence TablePersistence `json:"persistence"`
+	// EstimatedRows is the planner's estimate of rows count, it is -1 if the table was never analyzed
+	EstimatedRows int64 `json:"estimated_rows"`
+	// SizeBytes is a total size of the table including indexes
+	SizeBytes int64 `json:"size_bytes"`
+}
+
+func (m *kvMigrator) AlterToUnlogged() error {
+	return m.alterPersistence(PersistenceUnlogged)
+}
+
+func (m *kvMigrator) AlterToLogged() error {
+	return m.alterPersistence(PersistencePermanent)
+}
+
+func (m *kvMigrator) alterPersistence(persistence TablePersistence) error {
+	mode := "LOGGED"
+	if persistence == PersistenceUnlogged {
+		mode = "UNLOGGED"
+	}
+
+	if _, err := m.db.Exec(fmt.Sprintf("ALTER TABLE %s SET %s", m.table(), mode)); err != nil {
+		return errors.Wrap(err, "failed to alter table persistence", logan.F{
+			"table":       m.table(),
+			"persistence": persistence,
+		})
+	}
+
+	if m.log != nil {
+		m.log.WithField("persistence", persistence).Info("Key value table persistence changed")
+	}
+	return nil
+}
+
+func (m *kvMigrator) Stats() (TableStats, error) {
+	var (
+		stats       TableStats
+		persistence string
+	)
+
+	err := m.db.QueryRow(`SELECT relpersistence, reltuples::bigint, pg_total_relation_size(oid)
+		FROM pg_class WHERE oid = $1::regclass`, m.table()).
+		Scan(&persistence, &stats.EstimatedRows, &stats.SizeBytes)
+	if err != nil {
+		return TableStats{}, errors.Wrap(err, "failed to get table stats", logan.F{"table": m.table()})
+	}
+
+	stats.Persistence = relPersistence[persistence]
+	return stats, nil
+}
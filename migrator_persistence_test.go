@@ -0,0 +1,33 @@
+package dban
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVMigratorUnlogged(t *testing.T) {
+	db := openTestPostgres(t)
+	migrator := migrateTestPostgres(t, db)
+
+	stats, err := migrator.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, PersistencePermanent, stats.Persistence)
+
+	require.NoError(t, migrator.AlterToUnlogged())
+	stats, err = migrator.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, PersistenceUnlogged, stats.Persistence)
+
+	kvQ := NewKeyValueQ(db)
+	require.NoError(t, kvQ.Upsert(KeyValue{Key: "unlogged", Value: "1"}))
+	kv, err := kvQ.Get("unlogged")
+	require.NoError(t, err)
+	assert.Equal(t, &KeyValue{Key: "unlogged", Value: "1"}, kv)
+
+	require.NoError(t, migrator.AlterToLogged())
+	stats, err = migrator.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, PersistencePermanent, stats.Persistence)
+}
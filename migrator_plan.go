@@ -0,0 +1,141 @@
+package dban
+
+import (
+	"sort"
+	"strings"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// PlannedMigration is a migration that MigrateUpN or MigrateDownN would execute (see PlanUp)
+type PlannedMigration struct {
+	// ID is the name of the migration file
+	ID string
+	// Feature is the feature group of the migration, empty for the base migrations
+	Feature Feature
+	// Table is the table the migration would be recorded in or removed from
+	Table string
+	// SQL is the statements of the migration in the direction planned, rendered for the
+	// dialect and the key value table of the migrator, joined into a single script
+	SQL string
+}
+
+func (m *kvMigrator) PlanUp(max int) ([]PlannedMigration, error) {
+	return m.plan(migrate.Up, max)
+}
+
+func (m *kvMigrator) PlanDown(max int) ([]PlannedMigration, error) {
+	return m.plan(migrate.Down, max)
+}
+
+// plan plans up to max migrations of the base set and the selected features in the order
+// migrateN would execute them, all of them if max is 0. Like EnsureMigrated, it makes no
+// writes: the applied migrations are read without creating the tables tracking them
+func (m *kvMigrator) plan(direction migrate.MigrationDirection, max int) ([]PlannedMigration, error) {
+	if max < 0 {
+		return nil, errors.From(errors.New("max must not be negative"), logan.F{"max": max})
+	}
+	sets, features, err := m.setFeatures()
+	if err != nil {
+		return nil, err
+	}
+	if direction == migrate.Down {
+		if err = m.checkUnselectedReverted(); err != nil {
+			return nil, err
+		}
+		for i, j := 0, len(sets)-1; i < j; i, j = i+1, j-1 {
+			sets[i], sets[j] = sets[j], sets[i]
+			features[i], features[j] = features[j], features[i]
+		}
+	}
+
+	planned := []PlannedMigration{}
+	for i, set := range sets {
+		left := 0
+		if max > 0 {
+			if left = max - len(planned); left == 0 {
+				break
+			}
+		}
+
+		migrations, err := m.planSet(set, direction, left)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to plan migrations", logan.F{"table": set.TableName})
+		}
+		for _, migration := range migrations {
+			planned = append(planned, PlannedMigration{
+				ID:      migration.Id,
+				Feature: features[i],
+				Table:   set.table(),
+				SQL:     joinStatements(migration.Queries),
+			})
+		}
+	}
+
+	return planned, nil
+}
+
+// planSet plans up to max migrations of the set the way sql-migrate does, all of them if max
+// is 0, reading the applied ones with appliedIDs
+func (m *kvMigrator) planSet(set migrationSet, direction migrate.MigrationDirection, max int) ([]*migrate.PlannedMigration, error) {
+	applied, err := m.appliedIDs(set)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := set.source.FindMigrations()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find migrations")
+	}
+
+	known := make(map[string]bool, len(migrations))
+	for _, migration := range migrations {
+		known[migration.Id] = true
+	}
+	existing := make([]*migrate.Migration, 0, len(applied))
+	for id := range applied {
+		if !known[id] {
+			return nil, errors.From(errors.New("unknown migration in database"), logan.F{"migration_id": id})
+		}
+		existing = append(existing, &migrate.Migration{Id: id})
+	}
+	sort.Slice(existing, func(i, j int) bool {
+		return existing[i].Less(existing[j])
+	})
+
+	last := &migrate.Migration{}
+	planned := make([]*migrate.PlannedMigration, 0)
+	if len(existing) != 0 {
+		last = existing[len(existing)-1]
+		// the ones missing before the last applied one are applied first, as sql-migrate does
+		planned = append(planned, migrate.ToCatchup(migrations, existing, last)...)
+	}
+
+	toApply := migrate.ToApply(migrations, last.Id, direction)
+	if max > 0 && max < len(toApply) {
+		toApply = toApply[:max]
+	}
+	for _, migration := range toApply {
+		queries := migration.Up
+		if direction == migrate.Down {
+			queries = migration.Down
+		}
+		planned = append(planned, &migrate.PlannedMigration{Migration: migration, Queries: queries})
+	}
+	return planned, nil
+}
+
+// joinStatements joins statements of a migration into a single multi-statement query
+func joinStatements(statements []string) string {
+	var query strings.Builder
+	for _, statement := range statements {
+		statement = strings.TrimSpace(statement)
+		query.WriteString(statement)
+		if !strings.HasSuffix(statement, ";") {
+			query.WriteString(";")
+		}
+		query.WriteString("\n")
+	}
+	return query.String()
+}
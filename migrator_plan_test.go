@@ -0,0 +1,81 @@
+package dban
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVMigratorPlan(t *testing.T) {
+	t.Run("fresh database", func(t *testing.T) {
+		migrator := newSQLiteMigrator(newTestDB(t), kvMigrations, WithMigrationTable("dban_migrations"))
+		planned, err := migrator.PlanUp(0)
+		require.NoError(t, err)
+		require.NotEmpty(t, planned)
+		assert.Equal(t, "dban_migrations", planned[0].Table)
+		assert.True(t, strings.Contains(strings.ToLower(planned[0].SQL), "create table key_value"), planned[0].SQL)
+
+		_, pending, err := migrator.Migrations()
+		require.NoError(t, err)
+		assert.Len(t, pending, len(planned), "planning must not apply migrations")
+	})
+
+	db := newTestDB(t)
+	migrator := newSQLiteMigrator(db, testMigrations)
+	planned, err := migrator.PlanUp(1)
+	require.NoError(t, err)
+	assert.Equal(t, []PlannedMigration{{
+		ID:    "001_first.sql",
+		Table: "gorp_migrations",
+		SQL:   "create table first (id integer);\n",
+	}}, planned)
+	assert.Empty(t, tableColumns(t, db, "first"))
+
+	require.NoError(t, migrator.MigrateUp())
+	planned, err = migrator.PlanUp(0)
+	require.NoError(t, err)
+	assert.Equal(t, []PlannedMigration{}, planned, "an up-to-date database must have an empty plan")
+
+	planned, err = migrator.PlanDown(1)
+	require.NoError(t, err)
+	require.Len(t, planned, 1)
+	assert.Equal(t, "002_second.sql", planned[0].ID)
+	assert.Equal(t, "drop table second;\n", planned[0].SQL)
+	assert.NotEmpty(t, tableColumns(t, db, "second"))
+}
+
+func TestKVMigratorPlanReadOnly(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	// any statement but the reads expected, e.g. creating the tables tracking the migrations
+	// or the schema, fails the test
+	migrator := newKVMigrator(db, nil, kvMigrations, WithSchema("billing"))
+
+	mock.ExpectQuery("SELECT to_regclass($1) IS NOT NULL").
+		WithArgs("billing.gorp_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`SELECT id FROM billing."gorp_migrations"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("001_key_value.sql"))
+	planned, err := migrator.PlanUp(0)
+	require.NoError(t, err)
+	require.Len(t, planned, 1)
+	assert.Equal(t, "002_value_text.sql", planned[0].ID)
+
+	for _, group := range featureMigrations {
+		mock.ExpectQuery("SELECT to_regclass($1) IS NOT NULL").
+			WithArgs("billing." + group.feature.migrationsTable()).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	}
+	mock.ExpectQuery("SELECT to_regclass($1) IS NOT NULL").
+		WithArgs("billing.gorp_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	planned, err = migrator.PlanDown(0)
+	require.NoError(t, err)
+	assert.Empty(t, planned, "nothing is applied to a missing migrations table")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
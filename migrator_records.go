@@ -0,0 +1,93 @@
+package dban
+
+import (
+	"sort"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// MigrationRecord is a migration of the key value storage, applied or pending
+type MigrationRecord struct {
+	// ID is the name of the migration file
+	ID string
+	// Feature is the feature group of the migration, empty for the base migrations
+	Feature Feature
+	// AppliedAt is the time the migration was applied at, zero if it is pending
+	AppliedAt time.Time
+}
+
+// setFeatures returns the sets of the base migrations and of the selected features along
+// with the features of them, in the order they are applied
+func (m *kvMigrator) setFeatures() ([]migrationSet, []Feature, error) {
+	selected, err := m.selectedFeatures()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sets, features := []migrationSet{m.baseSet()}, []Feature{""}
+	for _, group := range m.groups {
+		if selected[group.feature] {
+			sets = append(sets, m.groupSet(group))
+			features = append(features, group.feature)
+		}
+	}
+	return sets, features, nil
+}
+
+func (m *kvMigrator) Migrations() (applied, pending []MigrationRecord, err error) {
+	sets, features, err := m.setFeatures()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = m.ensureSchema(); err != nil {
+		return nil, nil, err
+	}
+
+	for i, set := range sets {
+		records, err := set.GetMigrationRecords(m.db, m.dialect)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to get migration records", logan.F{"table": set.TableName})
+		}
+		// sorted the way sql-migrate applies them rather than by the ids as strings
+		sort.SliceStable(records, func(i, j int) bool {
+			return migrate.Migration{Id: records[i].Id}.Less(&migrate.Migration{Id: records[j].Id})
+		})
+		for _, record := range records {
+			applied = append(applied, MigrationRecord{ID: record.Id, Feature: features[i], AppliedAt: record.AppliedAt})
+		}
+
+		planned, _, err := set.PlanMigration(m.db, m.dialect, set.source, migrate.Up, 0)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to plan migrations", logan.F{"table": set.TableName})
+		}
+		for _, migration := range planned {
+			pending = append(pending, MigrationRecord{ID: migration.Id, Feature: features[i]})
+		}
+	}
+
+	return applied, pending, nil
+}
+
+func (m *kvMigrator) HasPending() (bool, error) {
+	sets, _, err := m.setFeatures()
+	if err != nil {
+		return false, err
+	}
+	if err = m.ensureSchema(); err != nil {
+		return false, err
+	}
+
+	for _, set := range sets {
+		applied, err := m.isApplied(set)
+		if err != nil {
+			return false, err
+		}
+		if !applied {
+			return true, nil
+		}
+	}
+	return false, nil
+}
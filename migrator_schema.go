@@ -0,0 +1,163 @@
+package dban
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+	migrate "github.com/rubenv/sql-migrate"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// WithSchema makes the migrator install the key value storage into the schema
+// (created if missing) and track applied migrations there
+func WithSchema(schema string) KVMigratorOption {
+	return func(m *kvMigrator) {
+		m.schema = schema
+	}
+}
+
+// WithSchemaConcurrency sets how many schemas MigrateUpAll migrates at once, 1 by default
+func WithSchemaConcurrency(n int) KVMigratorOption {
+	return func(m *kvMigrator) {
+		m.schemaConcurrency = n
+	}
+}
+
+// SchemaMigrationError is returned by MigrateUpAll when migrations of some schemas failed
+type SchemaMigrationError struct {
+	// Errors maps failed schemas to their errors
+	Errors map[string]error
+}
+
+func (e *SchemaMigrationError) Error() string {
+	schemas := make([]string, 0, len(e.Errors))
+	for schema := range e.Errors {
+		schemas = append(schemas, schema)
+	}
+	sort.Strings(schemas)
+
+	messages := make([]string, len(schemas))
+	for i, schema := range schemas {
+		messages[i] = fmt.Sprintf("%s: %s", schema, e.Errors[schema])
+	}
+	return fmt.Sprintf("failed to migrate %d schema(s): %s", len(schemas), strings.Join(messages, "; "))
+}
+
+func (m *kvMigrator) MigrateUpAll(ctx context.Context, schemas []string) (map[string]int, error) {
+	if m.migrationSchema != "" {
+		return nil, errors.New("MigrateUpAll does not support WithMigrationSchema")
+	}
+	concurrency := m.schemaConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		applied = make(map[string]int, len(schemas))
+		failed  = make(map[string]error)
+		queue   = make(chan string)
+	)
+
+	for i := 0; i < concurrency && i < len(schemas); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for schema := range queue {
+				migrator := *m
+				migrator.schema = schema
+				n, err := migrator.migrate(migrate.Up)
+
+				mu.Lock()
+				applied[schema] = n
+				if err != nil {
+					failed[schema] = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, schema := range schemas {
+		if ctx.Err() != nil {
+			mu.Lock()
+			failed[schema] = ctx.Err()
+			mu.Unlock()
+			continue
+		}
+		queue <- schema
+	}
+	close(queue)
+	wg.Wait()
+
+	if m.log != nil {
+		m.log.WithFields(logan.F{
+			"schemas": len(schemas),
+			"failed":  len(failed),
+		}).Info("Schemas migrated")
+	}
+
+	if len(failed) != 0 {
+		return applied, &SchemaMigrationError{Errors: failed}
+	}
+	return applied, nil
+}
+
+// ensureSchema creates the schemas of the migrator and of its migration tables if they do
+// not exist yet
+func (m *kvMigrator) ensureSchema() error {
+	for _, schema := range []string{m.schema, m.migrationSchema} {
+		if schema == "" {
+			continue
+		}
+		if _, err := m.db.Exec("CREATE SCHEMA IF NOT EXISTS " + pq.QuoteIdentifier(schema)); err != nil {
+			return errors.Wrap(err, "failed to create schema", logan.F{"schema": schema})
+		}
+	}
+	return nil
+}
+
+// table returns the name of the key value table qualified with the schema of the migrator
+func (m *kvMigrator) table() string {
+	if m.schema == "" {
+		return m.quotedTableName()
+	}
+	return pq.QuoteIdentifier(m.schema) + "." + m.quotedTableName()
+}
+
+// schemaSource makes migrations of the source run against the schema by setting
+// search_path for the duration of every migration transaction
+type schemaSource struct {
+	migrate.MigrationSource
+	schema string
+}
+
+func (s schemaSource) FindMigrations() ([]*migrate.Migration, error) {
+	migrations, err := s.MigrationSource.FindMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	setSearchPath := "SET LOCAL search_path TO " + pq.QuoteIdentifier(s.schema)
+	result := make([]*migrate.Migration, len(migrations))
+	for i, migration := range migrations {
+		if migration.DisableTransactionUp || migration.DisableTransactionDown {
+			return nil, errors.From(errors.New("migrations without transaction cannot be applied to a schema"), logan.F{
+				"migration_id": migration.Id,
+			})
+		}
+
+		clone := *migration
+		clone.Up = append([]string{setSearchPath}, migration.Up...)
+		clone.Down = append([]string{setSearchPath}, migration.Down...)
+		result[i] = &clone
+	}
+
+	return result, nil
+}
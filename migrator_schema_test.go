@@ -0,0 +1,129 @@
+package dban
+
+import (
+	"context"
+	"testing"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaSource(t *testing.T) {
+	migrations, err := schemaSource{MigrationSource: testMigrations, schema: `tenant"1`}.FindMigrations()
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	assert.Equal(t, []string{`SET LOCAL search_path TO "tenant""1"`, "create table first (id integer)"}, migrations[0].Up)
+	assert.Equal(t, []string{`SET LOCAL search_path TO "tenant""1"`, "drop table first"}, migrations[0].Down)
+	assert.Equal(t, []string{"create table first (id integer)"}, testMigrations.Migrations[0].Up, "source must stay intact")
+
+	_, err = schemaSource{
+		MigrationSource: &migrate.MemoryMigrationSource{Migrations: []*migrate.Migration{{
+			Id:                   "001_concurrently.sql",
+			DisableTransactionUp: true,
+		}}},
+		schema: "tenant",
+	}.FindMigrations()
+	assert.Error(t, err)
+}
+
+func TestKVMigratorMigrateUpAll(t *testing.T) {
+	db := openTestPostgres(t)
+	schemas := []string{"dban_test_tenant_a", "dban_test_tenant_b", "dban_test_tenant_broken"}
+	t.Cleanup(func() {
+		for _, schema := range schemas {
+			_, _ = db.RawDB().Exec("DROP SCHEMA IF EXISTS " + schema + " CASCADE")
+		}
+	})
+
+	_, err := db.RawDB().Exec("CREATE SCHEMA dban_test_tenant_broken; CREATE TABLE dban_test_tenant_broken.key_value (id int)")
+	require.NoError(t, err)
+
+	migrator := NewKVMigrator(db.RawDB(), nil, WithSchemaConcurrency(2))
+	applied, err := migrator.MigrateUpAll(context.Background(), schemas)
+	require.Error(t, err)
+	assert.Equal(t, map[string]int{"dban_test_tenant_a": 2, "dban_test_tenant_b": 2, "dban_test_tenant_broken": 0}, applied)
+
+	schemaErr, ok := err.(*SchemaMigrationError)
+	require.True(t, ok)
+	assert.Len(t, schemaErr.Errors, 1)
+	assert.Contains(t, schemaErr.Errors, "dban_test_tenant_broken")
+
+	status, err := NewKVMigrator(db.RawDB(), nil, WithSchema("dban_test_tenant_a")).Status()
+	require.NoError(t, err)
+	assert.True(t, status.Base)
+
+	applied, err = migrator.MigrateUpAll(context.Background(), schemas[:2])
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"dban_test_tenant_a": 0, "dban_test_tenant_b": 0}, applied)
+}
+
+func TestKVMigratorWithMigrationSchema(t *testing.T) {
+	db := openTestPostgres(t)
+	schemas := []string{"dban_test_storage", "dban_test_migrations"}
+	t.Cleanup(func() {
+		for _, schema := range schemas {
+			_, _ = db.RawDB().Exec("DROP SCHEMA IF EXISTS " + schema + " CASCADE")
+		}
+	})
+
+	migrator := NewKVMigrator(db.RawDB(), nil,
+		WithSchema("dban_test_storage"), WithMigrationSchema("dban_test_migrations"), WithMigrationTable("dban_migrations"))
+	require.NoError(t, migrator.MigrateUp())
+
+	var tracked, stored int
+	require.NoError(t, db.RawDB().QueryRow("SELECT count(*) FROM dban_test_migrations.dban_migrations").Scan(&tracked))
+	assert.Equal(t, 1, tracked)
+	require.NoError(t, db.RawDB().QueryRow("SELECT count(*) FROM dban_test_storage.key_value").Scan(&stored))
+	assert.Zero(t, stored)
+
+	_, err := migrator.MigrateUpAll(context.Background(), schemas[:1])
+	assert.ErrorContains(t, err, "WithMigrationSchema")
+	require.NoError(t, migrator.MigrateDown())
+}
+
+func TestKeyValueQWithTableSchemaPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	t.Cleanup(func() {
+		_, _ = db.RawDB().Exec("DROP SCHEMA IF EXISTS dban_test CASCADE")
+	})
+	migrator := NewKVMigrator(db.RawDB(), nil, WithSchema("dban_test"), WithForceDestructive())
+	require.NoError(t, migrator.MigrateUp())
+	t.Cleanup(func() { require.NoError(t, migrator.MigrateDown()) })
+
+	batchSize := uint64(2)
+	streamer := NewStreamer(StreamerInitParams[uint64]{
+		Stream:      rangeStreamable{size: 5},
+		KeyValueQ:   NewKeyValueQ(db, WithTableSchema("dban_test")),
+		KeyValueKey: "schema_cursor",
+		BatchSize:   &batchSize,
+	})
+	var processed []uint64
+	for i := 0; i < 2; i++ {
+		require.NoError(t, streamer.FormListAndProcess(func(_ context.Context, n uint64) error {
+			processed = append(processed, n)
+			return nil
+		}))
+	}
+	assert.Equal(t, []uint64{0, 1, 2, 3}, processed)
+
+	var cursor string
+	require.NoError(t, db.RawDB().QueryRow("SELECT value FROM dban_test.key_value WHERE key = 'schema_cursor'").Scan(&cursor))
+	assert.Equal(t, "2", cursor, "the cursor must be stored in the table of the schema")
+}
+
+func TestKVMigratorEnsureMigratedPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	t.Cleanup(func() {
+		_, _ = db.RawDB().Exec("DROP SCHEMA IF EXISTS dban_test_ensure CASCADE")
+	})
+
+	migrator := NewKVMigrator(db.RawDB(), nil, WithSchema("dban_test_ensure"), WithMigrationTable("dban_migrations"))
+	assert.ErrorIs(t, migrator.EnsureMigrated(), ErrPendingMigrations)
+
+	require.NoError(t, migrator.MigrateUp())
+	require.NoError(t, migrator.EnsureMigrated())
+	version, err := migrator.Version()
+	require.NoError(t, err)
+	assert.Equal(t, "002_value_text.sql", version)
+}
@@ -0,0 +1,201 @@
+package dban
+
+import (
+	"bytes"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/lib/pq"
+	migrate "github.com/rubenv/sql-migrate"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// tableFeatures are the features whose migrations extend the key value table itself, so
+// that they are available for a table of another name. The rest create tables of their own
+// the queriers expect under fixed names
+var tableFeatures = map[Feature]bool{
+	FeatureTimestamps: true,
+	FeatureTTL:        true,
+	FeatureHistory:    true,
+	FeatureBinary:     true,
+	FeatureKeyCheck:   true,
+	FeatureVersion:    true,
+	FeatureSoftDelete: true,
+}
+
+// tableName is the naming convention for the key value table the migrations create. Names
+// are lowercase, so that the unquoted ones of the migrations match the ones the queriers quote
+var tableName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// WithKeyValueTable makes the migrator create the key value table under the name of table,
+// the same KeyValueTable the querier is configured with (see WithTable), so that services
+// could keep several key value tables in one database:
+//
+//	dban.NewKVMigrator(db.RawDB(), log, dban.WithKeyValueTable(dban.KeyValueTable{Name: "my_service_kv"}))
+//
+// The embedded migrations are rendered for the table, the objects they create (indexes,
+// triggers, the history table) are named after it, and the ids of the migrations start with
+// its name, e.g. my_service_kv_001_key_value.sql. As sql-migrate plans migrations of a table
+// starting from the last applied one, the table is tracked in a migration table of its own,
+// dban_migrations_<name> (dban_migrations_<name>_<feature> for the features), unless
+// WithMigrationTable is set. Features creating tables of their own (FeatureJSON and
+// FeatureDeadLetters) are not available, nor are KeyColumn and ValueColumn, as the
+// migrations do not rename the columns. Schema of the table is applied as WithSchema does.
+// With the migrations of NewKVMigratorFromDir the option just names the table guarded on
+// down migrations. The default name keeps the default migrations intact
+func WithKeyValueTable(table KeyValueTable) KVMigratorOption {
+	return func(m *kvMigrator) {
+		m.kvTable = table
+		if table.Schema != "" {
+			m.schema = table.Schema
+		}
+	}
+}
+
+// applyKeyValueTable switches the embedded migrations to the ones rendered for the table
+// set by WithKeyValueTable
+func (m *kvMigrator) applyKeyValueTable() {
+	name := m.kvTable.Name
+	if name == "" || name == keyValueTable {
+		return
+	}
+	if m.source == kvMigrations {
+		m.source = tableSource{root: "migrations", table: m.kvTable}
+	}
+	if m.migrationTable == "" {
+		m.migrationTable = "dban_migrations_" + name
+	}
+
+	var groups []featureGroup
+	for _, group := range m.groups {
+		if tableFeatures[group.feature] {
+			groups = append(groups, featureGroup{
+				feature: group.feature,
+				source:  tableSource{root: path.Join("migrations", string(group.feature)), table: m.kvTable},
+			})
+		}
+	}
+	m.groups = groups
+}
+
+// tableName returns the name of the key value table the migrator creates
+func (m *kvMigrator) tableName() string {
+	if m.kvTable.Name == "" {
+		return keyValueTable
+	}
+	return m.kvTable.Name
+}
+
+// groupTable returns the name of a table that tracks migrations of the feature group
+func (m *kvMigrator) groupTable(group featureGroup) string {
+	if name := m.tableName(); name != keyValueTable {
+		return "dban_migrations_" + name + "_" + string(group.feature)
+	}
+	return group.feature.migrationsTable()
+}
+
+// tableSource renders the embedded migrations in root, templates on the name of the key
+// value table, for the table and the dialect, Postgres unless set. Migrations of the default
+// table are rendered as they are written, under their file names
+type tableSource struct {
+	root    string
+	table   KeyValueTable
+	dialect string
+}
+
+// migrationTemplate is the data the embedded migrations are rendered with
+type migrationTemplate struct {
+	// Name is the name of the key value table
+	Name string
+	// Dialect is the sql-migrate dialect the migrations are applied with
+	Dialect string
+}
+
+// withDialect makes the embedded migrations of the source render for the dialect. Other
+// sources are returned as they are
+func withDialect(source migrate.MigrationSource, dialect string) migrate.MigrationSource {
+	switch source := source.(type) {
+	case tableSource:
+		source.dialect = dialect
+		return source
+	case mergedSource:
+		rendered := make(mergedSource, len(source))
+		for i := range source {
+			rendered[i] = withDialect(source[i], dialect)
+		}
+		return rendered
+	}
+	return source
+}
+
+func (s tableSource) FindMigrations() ([]*migrate.Migration, error) {
+	name, idPrefix := keyValueTable, ""
+	dialect := s.dialect
+	if dialect == "" {
+		dialect = migrationsDialect
+	}
+	if s.table.Name != "" && s.table.Name != keyValueTable {
+		name, idPrefix = s.table.Name, s.table.Name+"_"
+	}
+	if !tableName.MatchString(name) {
+		return nil, errors.From(errors.New("key value table name must be a lowercase identifier"), logan.F{
+			"table": name,
+		})
+	}
+	if s.table.KeyColumn != "" || s.table.ValueColumn != "" {
+		return nil, errors.New("migrations do not support renaming the columns of the key value table")
+	}
+
+	entries, err := fs.ReadDir(migrationsFS, s.root)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list migrations", logan.F{"root": s.root})
+	}
+
+	var migrations []*migrate.Migration
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		fields := logan.F{"file": entry.Name()}
+
+		text, err := fs.ReadFile(migrationsFS, path.Join(s.root, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read migration", fields)
+		}
+		tmpl, err := template.New(entry.Name()).Option("missingkey=error").Parse(string(text))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse migration template", fields)
+		}
+		var rendered bytes.Buffer
+		if err = tmpl.Execute(&rendered, migrationTemplate{Name: name, Dialect: dialect}); err != nil {
+			return nil, errors.Wrap(err, "failed to render migration", fields)
+		}
+
+		migration, err := migrate.ParseMigration(idPrefix+entry.Name(), bytes.NewReader(rendered.Bytes()))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse migration", fields)
+		}
+		migrations = append(migrations, migration)
+	}
+
+	// sorted the way sql-migrate sorts migrations of a source
+	return (&migrate.MemoryMigrationSource{Migrations: migrations}).FindMigrations()
+}
+
+// dropTable matches a statement dropping the table
+func dropTable(table string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)\bdrop\s+table\s+(if\s+exists\s+)?["\x60]?` + regexp.QuoteMeta(table) + `["\x60]?(\s|;|,|$)`)
+}
+
+// quotedTableName returns the name of the key value table to query, quoted unless it is
+// the default one, like the queriers do
+func (m *kvMigrator) quotedTableName() string {
+	if m.kvTable.Name == "" {
+		return keyValueTable
+	}
+	return pq.QuoteIdentifier(m.kvTable.Name)
+}
@@ -0,0 +1,160 @@
+package dban
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"text/template"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableSourceDefault(t *testing.T) {
+	roots := []string{"migrations"}
+	for _, group := range featureMigrations {
+		roots = append(roots, path.Join("migrations", string(group.feature)))
+	}
+
+	for _, root := range roots {
+		migrations, err := tableSource{root: root}.FindMigrations()
+		require.NoError(t, err)
+		require.NotEmpty(t, migrations, root)
+
+		for _, migration := range migrations {
+			text, err := fs.ReadFile(migrationsFS, path.Join(root, migration.Id))
+			require.NoError(t, err, "ids of the default table must be the file names")
+			var rendered strings.Builder
+			require.NoError(t, template.Must(template.New(migration.Id).Parse(string(text))).
+				Execute(&rendered, map[string]string{"Name": keyValueTable, "Dialect": "postgres"}))
+			expected, err := migrate.ParseMigration(migration.Id, strings.NewReader(rendered.String()))
+			require.NoError(t, err)
+			assert.Equal(t, expected, migration, "%s must be rendered as it is written", migration.Id)
+		}
+	}
+
+	example, err := os.ReadFile("example-migration/00x_key_value.sql")
+	require.NoError(t, err)
+	expected, err := migrate.ParseMigration("001_key_value.sql", bytes.NewReader(example))
+	require.NoError(t, err)
+	migrations, err := kvMigrations.FindMigrations()
+	require.NoError(t, err)
+	assert.Equal(t, expected, migrations[0])
+}
+
+func TestTableSource(t *testing.T) {
+	migrations, err := tableSource{root: "migrations/ttl", table: KeyValueTable{Name: "orders_kv"}}.FindMigrations()
+	require.NoError(t, err)
+	require.Len(t, migrations, 1)
+	assert.Equal(t, "orders_kv_ttl_001_expires_at.sql", migrations[0].Id)
+	assert.Equal(t, []string{
+		"\nalter table orders_kv\n    add column expires_at timestamptz;\n",
+		"\ncreate index orders_kv_expires_at_idx on orders_kv (expires_at) where expires_at is not null;\n",
+	}, migrations[0].Up)
+
+	for name, table := range map[string]KeyValueTable{
+		"uppercase":      {Name: "Orders"},
+		"quoted":         {Name: `orders"; drop table key_value; --`},
+		"renamed column": {Name: "orders_kv", KeyColumn: "name"},
+	} {
+		_, err = tableSource{root: "migrations", table: table}.FindMigrations()
+		assert.Error(t, err, name)
+	}
+}
+
+func TestTableSourceDialect(t *testing.T) {
+	migrations, err := tableSource{root: "migrations"}.FindMigrations()
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	assert.Equal(t, "002_value_text.sql", migrations[1].Id)
+	assert.Equal(t, []string{"\nalter table key_value\n    alter column value type text;\n"}, migrations[1].Up)
+
+	// sqlite does not enforce the length of varchar, so the value column is left as it is
+	migrations, err = tableSource{root: "migrations", dialect: "sqlite3"}.FindMigrations()
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	assert.Empty(t, migrations[1].Up)
+	assert.Empty(t, migrations[1].Down)
+}
+
+func TestKVMigratorWithKeyValueTable(t *testing.T) {
+	db := newTestDB(t)
+	orders := newSQLiteMigrator(db, kvMigrations, WithKeyValueTable(KeyValueTable{Name: "orders_kv"}))
+	payments := newSQLiteMigrator(db, kvMigrations, WithKeyValueTable(KeyValueTable{Name: "payments_kv"}))
+
+	for _, migrator := range []*kvMigrator{orders, payments} {
+		applied, err := migrator.migrate(migrate.Up)
+		require.NoError(t, err)
+		assert.Equal(t, 2, applied)
+	}
+	assert.Equal(t, []string{"key", "value"}, tableColumns(t, db, "orders_kv"))
+	assert.Equal(t, []string{"key", "value"}, tableColumns(t, db, "payments_kv"))
+	assert.Empty(t, tableColumns(t, db, "key_value"))
+
+	applied, pending, err := orders.Migrations()
+	require.NoError(t, err)
+	require.Len(t, applied, 2)
+	assert.Equal(t, "orders_kv_001_key_value.sql", applied[0].ID)
+	assert.Equal(t, "orders_kv_002_value_text.sql", applied[1].ID)
+	assert.Empty(t, pending)
+	records, err := migrate.MigrationSet{TableName: "dban_migrations_payments_kv"}.GetMigrationRecords(db, "sqlite3")
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "payments_kv_001_key_value.sql", records[0].Id)
+
+	_, err = db.Exec("insert into orders_kv values ('cursor', '1')")
+	require.NoError(t, err)
+	_, err = orders.migrate(migrate.Down)
+	assert.True(t, Is(err, ErrTableNotEmpty), "guard must recognize the table: %v", err)
+
+	reverted, err := payments.migrate(migrate.Down)
+	require.NoError(t, err)
+	assert.Equal(t, 2, reverted)
+	assert.Empty(t, tableColumns(t, db, "payments_kv"))
+	assert.Equal(t, []string{"key", "value"}, tableColumns(t, db, "orders_kv"), "other tables must be left intact")
+}
+
+func TestKVMigratorWithKeyValueTablePostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	tables := []KeyValueTable{{Name: "dban_test_orders_kv"}, {Name: "dban_test_payments_kv"}}
+	for _, table := range tables {
+		migrateTestPostgres(t, db, WithKeyValueTable(table), WithFeatures(FeatureTTL, FeatureHistory))
+	}
+
+	batchSize := uint64(2)
+	newStreamer := func(table KeyValueTable) Streamer[uint64] {
+		return NewStreamer(StreamerInitParams[uint64]{
+			Stream:      rangeStreamable{size: 5},
+			KeyValueQ:   NewKeyValueQ(db, WithTable(table)),
+			KeyValueKey: "numbers",
+			BatchSize:   &batchSize,
+		})
+	}
+
+	orders := newStreamer(tables[0])
+	for _, expected := range [][]uint64{{0, 1}, {2, 3}} {
+		list, err := orders.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, expected, list)
+	}
+	list, err := newStreamer(tables[1]).FormList()
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{0, 1}, list, "cursors of the tables must be independent")
+	list, err = newStreamer(tables[0]).FormList()
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{4}, list)
+
+	var orderChanges, paymentChanges int
+	require.NoError(t, db.RawDB().QueryRow("SELECT count(*) FROM dban_test_orders_kv_history").Scan(&orderChanges))
+	require.NoError(t, db.RawDB().QueryRow("SELECT count(*) FROM dban_test_payments_kv_history").Scan(&paymentChanges))
+	assert.Greater(t, orderChanges, paymentChanges, "history must be recorded per table")
+
+	drifts, err := NewKVMigrator(db.RawDB(), nil,
+		WithKeyValueTable(tables[1]), WithFeatures(FeatureTTL, FeatureHistory)).CheckSchema()
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+}
@@ -0,0 +1,571 @@
+package dban
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+var testMigrations = &migrate.MemoryMigrationSource{
+	Migrations: []*migrate.Migration{
+		{
+			Id:   "001_first.sql",
+			Up:   []string{"create table first (id integer)"},
+			Down: []string{"drop table first"},
+		},
+		{
+			Id:   "002_second.sql",
+			Up:   []string{"create table second (id integer)"},
+			Down: []string{"drop table second"},
+		},
+	},
+}
+
+// testFeatureMigrations mirror featureMigrations with the DDL sqlite understands
+var testFeatureMigrations = []featureGroup{
+	{feature: FeatureTimestamps, source: &migrate.MemoryMigrationSource{
+		Migrations: []*migrate.Migration{{
+			Id:   "timestamps_001_created_at.sql",
+			Up:   []string{"alter table key_value add column created_at text"},
+			Down: []string{"alter table key_value drop column created_at"},
+		}},
+	}},
+	{feature: FeatureTTL, source: &migrate.MemoryMigrationSource{
+		Migrations: []*migrate.Migration{{
+			Id:   "ttl_001_expires_at.sql",
+			Up:   []string{"alter table key_value add column expires_at text"},
+			Down: []string{"alter table key_value drop column expires_at"},
+		}},
+	}},
+	{feature: FeatureHistory, source: &migrate.MemoryMigrationSource{
+		Migrations: []*migrate.Migration{{
+			Id:   "history_001_key_value_history.sql",
+			Up:   []string{"create table key_value_history (key text, old_value text, new_value text, actor text)"},
+			Down: []string{"drop table key_value_history"},
+		}},
+	}},
+	{feature: FeatureBinary, source: &migrate.MemoryMigrationSource{
+		Migrations: []*migrate.Migration{{
+			Id:   "binary_001_value_bytes.sql",
+			Up:   []string{"alter table key_value add column value_bytes blob"},
+			Down: []string{"alter table key_value drop column value_bytes"},
+		}},
+	}},
+	{feature: FeatureJSON, source: &migrate.MemoryMigrationSource{
+		Migrations: []*migrate.Migration{{
+			Id:   "json_001_key_value_json.sql",
+			Up:   []string{"create table key_value_json (key text primary key, value text not null)"},
+			Down: []string{"drop table key_value_json"},
+		}},
+	}},
+	{feature: FeatureKeyCheck, source: &migrate.MemoryMigrationSource{
+		Migrations: []*migrate.Migration{{
+			Id:   "key_check_001_key_length.sql",
+			Up:   []string{"create table key_value_key_check (id integer)"},
+			Down: []string{"drop table key_value_key_check"},
+		}},
+	}},
+	{feature: FeatureVersion, source: &migrate.MemoryMigrationSource{
+		Migrations: []*migrate.Migration{{
+			Id:   "version_001_version.sql",
+			Up:   []string{"alter table key_value add column version integer not null default 1"},
+			Down: []string{"alter table key_value drop column version"},
+		}},
+	}},
+	{feature: FeatureDeadLetters, source: &migrate.MemoryMigrationSource{
+		Migrations: []*migrate.Migration{{
+			Id:   "dead_letters_001_dead_letters.sql",
+			Up:   []string{"create table dead_letters (id integer primary key, stream_key text, payload text, error text, attempts integer)"},
+			Down: []string{"drop table dead_letters"},
+		}},
+	}},
+	{feature: FeatureSoftDelete, source: &migrate.MemoryMigrationSource{
+		Migrations: []*migrate.Migration{{
+			Id:   "soft_delete_001_deleted_at.sql",
+			Up:   []string{"alter table key_value add column deleted_at text"},
+			Down: []string{"alter table key_value drop column deleted_at"},
+		}},
+	}},
+}
+
+func newTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func newTestMigrator(t *testing.T, source migrate.MigrationSource) *kvMigrator {
+	return newSQLiteMigrator(newTestDB(t), source)
+}
+
+func newSQLiteMigrator(db *sql.DB, source migrate.MigrationSource, opts ...KVMigratorOption) *kvMigrator {
+	migrator := newKVMigrator(db, nil, source, opts...)
+	migrator.dialect = "sqlite3"
+	migrator.groups = testFeatureMigrations
+	return migrator
+}
+
+func tableColumns(t *testing.T, db *sql.DB, table string) []string {
+	rows, err := db.Query("select name from pragma_table_info(?)", table)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		require.NoError(t, rows.Scan(&column))
+		columns = append(columns, column)
+	}
+	require.NoError(t, rows.Err())
+	return columns
+}
+
+type hookCall struct {
+	id   string
+	err  error
+	took time.Duration
+}
+
+func TestKVMigratorHooks(t *testing.T) {
+	var before []string
+	var after []hookCall
+
+	migrator := newTestMigrator(t, testMigrations).WithMigrationHooks(
+		func(id string) { before = append(before, id) },
+		func(id string, err error, took time.Duration) {
+			after = append(after, hookCall{id: id, err: err, took: took})
+		},
+	)
+
+	require.NoError(t, migrator.MigrateUp())
+	assert.Equal(t, []string{"001_first.sql", "002_second.sql"}, before)
+	require.Len(t, after, 2)
+	for i, call := range after {
+		assert.Equal(t, before[i], call.id)
+		assert.NoError(t, call.err)
+		assert.True(t, call.took > 0)
+	}
+
+	before, after = nil, nil
+	require.NoError(t, migrator.MigrateDown())
+	assert.Equal(t, []string{"002_second.sql", "001_first.sql"}, before)
+	assert.Len(t, after, 2)
+}
+
+func TestKVMigratorHookPanics(t *testing.T) {
+	migrator := newTestMigrator(t, testMigrations).WithMigrationHooks(
+		func(string) { panic("before") },
+		func(string, error, time.Duration) { panic("after") },
+	)
+
+	require.NoError(t, migrator.MigrateUp())
+	require.NoError(t, migrator.MigrateDown())
+}
+
+func TestKVMigratorHookFailure(t *testing.T) {
+	broken := &migrate.MemoryMigrationSource{
+		Migrations: append([]*migrate.Migration{}, testMigrations.Migrations[0], &migrate.Migration{
+			Id: "002_broken.sql",
+			Up: []string{"create table"},
+		}),
+	}
+
+	var after []hookCall
+	migrator := newTestMigrator(t, broken).WithMigrationHooks(nil,
+		func(id string, err error, took time.Duration) {
+			after = append(after, hookCall{id: id, err: err, took: took})
+		},
+	)
+
+	require.Error(t, migrator.MigrateUp())
+	require.Len(t, after, 2)
+	assert.NoError(t, after[0].err)
+	assert.Equal(t, "002_broken.sql", after[1].id)
+	assert.Error(t, after[1].err)
+}
+
+func writeMigrationFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	return dir
+}
+
+func TestKVMigratorMigrateN(t *testing.T) {
+	db := newTestDB(t)
+	migrator := newSQLiteMigrator(db, testMigrations)
+
+	applied, err := migrator.MigrateUpN(1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, applied)
+	assert.NotEmpty(t, tableColumns(t, db, "first"))
+	assert.Empty(t, tableColumns(t, db, "second"))
+
+	applied, err = migrator.MigrateUpN(0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, applied, "max of 0 must apply the rest of the migrations")
+
+	reverted, err := migrator.MigrateDownN(1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reverted)
+	assert.NotEmpty(t, tableColumns(t, db, "first"))
+	assert.Empty(t, tableColumns(t, db, "second"), "only the newest migration must be reverted")
+
+	reverted, err = migrator.MigrateDownN(5)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reverted)
+	assert.Empty(t, tableColumns(t, db, "first"))
+
+	_, err = migrator.MigrateDownN(-1)
+	assert.Error(t, err)
+}
+
+func TestNewKVMigratorFromDir(t *testing.T) {
+	dir := writeMigrationFiles(t, map[string]string{
+		"001_first.sql":  "-- +migrate Up\ncreate table first (id integer);\n-- +migrate Down\ndrop table first;\n",
+		"002_second.sql": "-- +migrate Up\ncreate table second (id integer);\n-- +migrate Down\ndrop table second;\n",
+		"README.md":      "not a migration",
+	})
+
+	migrator, err := NewKVMigratorFromDir(nil, nil, dir)
+	require.NoError(t, err)
+
+	sqlite := newTestMigrator(t, nil)
+	migrator.(*kvMigrator).db, migrator.(*kvMigrator).dialect = sqlite.db, sqlite.dialect
+
+	var applied []string
+	migrator = migrator.WithMigrationHooks(func(id string) { applied = append(applied, id) }, nil)
+	require.NoError(t, migrator.MigrateUp())
+	assert.Equal(t, []string{"001_first.sql", "002_second.sql"}, applied)
+}
+
+func TestNewKVMigratorFromDirInvalid(t *testing.T) {
+	cases := map[string]struct {
+		files   map[string]string
+		invalid []string
+	}{
+		"no version": {
+			files:   map[string]string{"001_ok.sql": "", "key_value.sql": ""},
+			invalid: []string{"key_value.sql"},
+		},
+		"duplicate version": {
+			files:   map[string]string{"1_first.sql": "", "01_second.sql": "", "2_third.sql": ""},
+			invalid: []string{"01_second.sql", "1_first.sql"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewKVMigratorFromDir(nil, nil, writeMigrationFiles(t, tc.files))
+			require.Error(t, err)
+			for _, file := range tc.invalid {
+				assert.Contains(t, err.Error(), file)
+			}
+		})
+	}
+
+	_, err := NewKVMigratorFromDir(nil, nil, t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestKVMigratorFeatures(t *testing.T) {
+	db := newTestDB(t)
+
+	run := func(direction migrate.MigrationDirection, features ...Feature) (int, error) {
+		return newSQLiteMigrator(db, kvMigrations, WithFeatures(features...)).migrate(direction)
+	}
+
+	applied, err := run(migrate.Up)
+	require.NoError(t, err)
+	assert.Equal(t, 2, applied)
+	assert.Equal(t, []string{"key", "value"}, tableColumns(t, db, "key_value"))
+
+	applied, err = run(migrate.Up, FeatureTimestamps)
+	require.NoError(t, err)
+	assert.Equal(t, 1, applied)
+	assert.Equal(t, []string{"key", "value", "created_at"}, tableColumns(t, db, "key_value"))
+
+	applied, err = run(migrate.Up, FeatureTTL, FeatureTimestamps)
+	require.NoError(t, err)
+	assert.Equal(t, 1, applied)
+	assert.Equal(t, []string{"key", "value", "created_at", "expires_at"}, tableColumns(t, db, "key_value"))
+
+	status, err := newSQLiteMigrator(db, kvMigrations).Status()
+	require.NoError(t, err)
+	assert.Equal(t, MigrationStatus{
+		Base:     true,
+		Features: map[Feature]bool{FeatureTimestamps: true, FeatureTTL: true, FeatureHistory: false, FeatureBinary: false, FeatureJSON: false, FeatureKeyCheck: false, FeatureVersion: false, FeatureDeadLetters: false, FeatureSoftDelete: false},
+	}, status)
+
+	_, err = run(migrate.Down, FeatureTTL)
+	require.Error(t, err, "timestamps are applied, but not selected")
+
+	_, err = run(migrate.Up, Feature("unknown"))
+	require.Error(t, err)
+
+	reverted, err := run(migrate.Down, FeatureTimestamps, FeatureTTL)
+	require.NoError(t, err)
+	assert.Equal(t, 4, reverted)
+	assert.Empty(t, tableColumns(t, db, "key_value"))
+
+	status, err = newSQLiteMigrator(db, kvMigrations).Status()
+	require.NoError(t, err)
+	assert.False(t, status.Base)
+	assert.False(t, status.Features[FeatureTimestamps])
+}
+
+func TestFeatureMigrations(t *testing.T) {
+	features := Features()
+	require.Len(t, features, len(featureMigrations))
+	assert.Equal(t, FeatureTimestamps, features[0])
+
+	source, err := FeatureMigrations(FeatureTTL)
+	require.NoError(t, err)
+	migrations, err := source.FindMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	assert.Equal(t, "ttl_001_expires_at.sql", migrations[0].Id)
+
+	_, err = FeatureMigrations(Feature("unknown"))
+	assert.Error(t, err)
+}
+
+func TestKVMigratorWithDialect(t *testing.T) {
+	db := newTestDB(t)
+	migrations := fstest.MapFS{
+		"001_key_value.sql": {Data: []byte("-- +migrate Up\ncreate table `key_value` (`key` text);\n-- +migrate Down\ndrop table `key_value`;\n")},
+	}
+
+	migrator := NewKVMigrator(db, nil, WithDialect("sqlite3", migrations))
+	require.NoError(t, migrator.MigrateUp())
+	assert.Equal(t, []string{"key"}, tableColumns(t, db, "key_value"))
+
+	_, err := db.Exec("insert into key_value values ('cursor')")
+	require.NoError(t, err)
+	err = migrator.MigrateDown()
+	require.Error(t, err)
+	assert.ErrorIs(t, errors.Cause(err), ErrTableNotEmpty, "guard must recognize quoted table names")
+}
+
+func TestKVMigratorWithMigrationTable(t *testing.T) {
+	db := newTestDB(t)
+	// the application tracks its own migrations in gorp_migrations
+	var app migrate.MigrationSet
+	applied, err := app.Exec(db, "sqlite3", testMigrations, migrate.Up)
+	require.NoError(t, err)
+	require.Equal(t, 2, applied)
+
+	migrator := newSQLiteMigrator(db, kvMigrations, WithMigrationTable("dban_migrations"))
+	applied, err = migrator.migrate(migrate.Up)
+	require.NoError(t, err)
+	assert.Equal(t, 2, applied)
+
+	appRecords, err := app.GetMigrationRecords(db, "sqlite3")
+	require.NoError(t, err)
+	assert.Len(t, appRecords, 2, "the records of the application must be left intact")
+	dbanRecords, err := migrate.MigrationSet{TableName: "dban_migrations"}.GetMigrationRecords(db, "sqlite3")
+	require.NoError(t, err)
+	require.Len(t, dbanRecords, 2)
+	assert.Equal(t, "001_key_value.sql", dbanRecords[0].Id)
+
+	status, err := migrator.Status()
+	require.NoError(t, err)
+	assert.True(t, status.Base)
+
+	// reverting the application leaves the key value table alone and vice versa
+	reverted, err := app.Exec(db, "sqlite3", testMigrations, migrate.Down)
+	require.NoError(t, err)
+	assert.Equal(t, 2, reverted)
+	assert.Equal(t, []string{"key", "value"}, tableColumns(t, db, "key_value"))
+
+	_, err = app.Exec(db, "sqlite3", testMigrations, migrate.Up)
+	require.NoError(t, err)
+	reverted, err = migrator.migrate(migrate.Down)
+	require.NoError(t, err)
+	assert.Equal(t, 2, reverted)
+	assert.Empty(t, tableColumns(t, db, "key_value"))
+	assert.Equal(t, []string{"id"}, tableColumns(t, db, "second"))
+}
+
+func TestKVMigratorMigrations(t *testing.T) {
+	db := newTestDB(t)
+	source := &migrate.MemoryMigrationSource{Migrations: []*migrate.Migration{
+		{Id: "2_second.sql", Up: []string{"create table key_value (key text)"}, Down: []string{"drop table key_value"}},
+		{Id: "10_tenth.sql", Up: []string{"create table tenth (id integer)"}, Down: []string{"drop table tenth"}},
+	}}
+	ids := func(records []MigrationRecord) []string {
+		var ids []string
+		for _, record := range records {
+			ids = append(ids, record.ID)
+		}
+		return ids
+	}
+
+	migrator := newSQLiteMigrator(db, source, WithMigrationTable("dban_migrations"), WithFeatures(FeatureTimestamps))
+	pending, err := migrator.HasPending()
+	require.NoError(t, err)
+	assert.True(t, pending)
+
+	applied, planned, err := migrator.Migrations()
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+	assert.Equal(t, []string{"2_second.sql", "10_tenth.sql", "timestamps_001_created_at.sql"}, ids(planned))
+	assert.Equal(t, FeatureTimestamps, planned[2].Feature)
+	assert.True(t, planned[0].AppliedAt.IsZero())
+
+	_, err = newSQLiteMigrator(db, source, WithMigrationTable("dban_migrations")).migrate(migrate.Up)
+	require.NoError(t, err)
+	applied, planned, err = migrator.Migrations()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2_second.sql", "10_tenth.sql"}, ids(applied), "the records must be in the order of execution")
+	assert.False(t, applied[0].AppliedAt.IsZero())
+	assert.Equal(t, []string{"timestamps_001_created_at.sql"}, ids(planned))
+
+	_, err = migrator.migrate(migrate.Up)
+	require.NoError(t, err)
+	pending, err = migrator.HasPending()
+	require.NoError(t, err)
+	assert.False(t, pending)
+
+	// the records must be read from the configured table only
+	applied, planned, err = newSQLiteMigrator(db, source).Migrations()
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+	assert.Len(t, planned, 2)
+}
+
+func TestNewKVMigratorWithDialect(t *testing.T) {
+	db := newTestDB(t)
+
+	migrator, err := NewKVMigratorWithDialect(db, "sqlite3", nil)
+	require.NoError(t, err)
+	require.NoError(t, migrator.MigrateUp())
+	assert.Equal(t, []string{"key", "value"}, tableColumns(t, db, "key_value"))
+
+	_, err = NewKVMigratorWithDialect(db, "cockroach", nil)
+	assert.ErrorContains(t, err, "unknown migration dialect")
+	_, err = NewKVMigratorWithDialect(db, "mysql", nil)
+	assert.ErrorContains(t, err, "mysqlq.NewKVMigrator")
+
+	migrator, err = NewKVMigratorWithDialect(db, "sqlite3", nil, WithFeatures(FeatureTTL))
+	require.NoError(t, err)
+	assert.ErrorContains(t, migrator.MigrateUp(), "unknown feature", "features must be available with postgres only")
+}
+
+func TestNewKVMigratorFromURL(t *testing.T) {
+	_, err := NewKVMigratorFromURL("postgres://dban@127.0.0.1:1/dban?sslmode=disable&connect_timeout=1", nil)
+	assert.ErrorContains(t, err, "failed to ping database", "the connection must be checked at construction")
+
+	url := os.Getenv(testDatabaseURLEnv)
+	if url == "" {
+		t.Skipf("%s is not set", testDatabaseURLEnv)
+	}
+	migrator, err := NewKVMigratorFromURL(url, nil, WithMigrationTable("dban_test_url_migrations"), WithForceDestructive())
+	require.NoError(t, err)
+	require.NoError(t, migrator.MigrateUp())
+	require.NoError(t, migrator.MigrateDown())
+	require.NoError(t, migrator.Close())
+	assert.Error(t, migrator.MigrateUp(), "the connection must be closed")
+}
+
+func TestNewKVMigratorFromPgdb(t *testing.T) {
+	db := openTestPostgres(t)
+	migrator, err := NewKVMigratorFromPgdb(db, nil, WithForceDestructive())
+	require.NoError(t, err)
+	require.NoError(t, migrator.MigrateUp())
+
+	require.NoError(t, NewKeyValueQ(db).Upsert(KeyValue{Key: "migrated", Value: "true"}))
+	require.NoError(t, migrator.MigrateDown())
+}
+
+func TestKVMigratorEnsureMigrated(t *testing.T) {
+	db := newTestDB(t)
+	migrator := newSQLiteMigrator(db, testMigrations, WithMigrationTable("dban_migrations"), WithFeatures(FeatureTTL))
+	tables := func() []string {
+		rows, err := db.Query("select name from sqlite_master where type = 'table' order by name")
+		require.NoError(t, err)
+		defer rows.Close()
+		var names []string
+		for rows.Next() {
+			var name string
+			require.NoError(t, rows.Scan(&name))
+			names = append(names, name)
+		}
+		return names
+	}
+
+	err := migrator.EnsureMigrated()
+	pendingErr, ok := err.(*PendingMigrationsError)
+	require.True(t, ok, "unexpected error: %v", err)
+	assert.ErrorIs(t, err, ErrPendingMigrations)
+	assert.Equal(t, []string{"001_first.sql", "002_second.sql", "ttl_001_expires_at.sql"}, pendingErr.IDs)
+	version, err := migrator.Version()
+	require.NoError(t, err)
+	assert.Empty(t, version)
+	assert.Empty(t, tables(), "the migration tables must not be created")
+
+	_, err = newSQLiteMigrator(db, testMigrations, WithMigrationTable("dban_migrations")).migrate(migrate.Up)
+	require.NoError(t, err)
+	err = migrator.EnsureMigrated()
+	pendingErr, ok = err.(*PendingMigrationsError)
+	require.True(t, ok, "unexpected error: %v", err)
+	assert.Equal(t, []string{"ttl_001_expires_at.sql"}, pendingErr.IDs)
+	version, err = migrator.Version()
+	require.NoError(t, err)
+	assert.Equal(t, "002_second.sql", version)
+	assert.NotContains(t, tables(), FeatureTTL.migrationsTable())
+
+	_, err = db.Exec("create table key_value (key text)")
+	require.NoError(t, err)
+	_, err = migrator.migrate(migrate.Up)
+	require.NoError(t, err)
+	require.NoError(t, migrator.EnsureMigrated())
+	version, err = migrator.Version()
+	require.NoError(t, err)
+	assert.Equal(t, "ttl_001_expires_at.sql", version)
+
+	assert.ErrorIs(t, newSQLiteMigrator(db, testMigrations).EnsureMigrated(), ErrPendingMigrations,
+		"the records must be read from the configured table only")
+}
+
+func TestKVMigratorWithExtraMigrations(t *testing.T) {
+	db := newTestDB(t)
+	base := &migrate.MemoryMigrationSource{Migrations: []*migrate.Migration{
+		{Id: "001_first.sql", Up: []string{"create table first (id integer)"}, Down: []string{"drop table first"}},
+		{Id: "003_third.sql", Up: []string{"create table third (id integer)"}, Down: []string{"drop table third"}},
+	}}
+	extra := fstest.MapFS{
+		"app/002_between.sql": {Data: []byte("-- +migrate Up\ncreate table between_them (id integer);\n-- +migrate Down\ndrop table between_them;\n")},
+		"app/004_last.sql":    {Data: []byte("-- +migrate Up\ncreate table last (id integer);\n-- +migrate Down\ndrop table last;\n")},
+	}
+
+	var executed []string
+	migrator := newSQLiteMigrator(db, base, WithExtraMigrations(extra, "app")).
+		WithMigrationHooks(func(id string) { executed = append(executed, id) }, nil)
+	require.NoError(t, migrator.MigrateUp())
+	assert.Equal(t, []string{"001_first.sql", "002_between.sql", "003_third.sql", "004_last.sql"}, executed)
+	assert.Equal(t, []string{"id"}, tableColumns(t, db, "between_them"))
+
+	executed = nil
+	require.NoError(t, migrator.MigrateDown())
+	assert.Equal(t, []string{"004_last.sql", "003_third.sql", "002_between.sql", "001_first.sql"}, executed)
+	assert.Empty(t, tableColumns(t, db, "first"))
+
+	conflicting := fstest.MapFS{
+		"app/003_third.sql": {Data: []byte("-- +migrate Up\ncreate table other (id integer);\n")},
+	}
+	err := newSQLiteMigrator(db, base, WithExtraMigrations(conflicting, "app")).MigrateUp()
+	assert.ErrorContains(t, err, "migration id 003_third.sql is duplicated")
+	assert.Empty(t, tableColumns(t, db, "first"), "no migration must be executed")
+}
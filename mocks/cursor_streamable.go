@@ -0,0 +1,47 @@
+package mocks
+
+import (
+	mock "github.com/stretchr/testify/mock"
+	pgdb "gitlab.com/distributed_lab/kit/pgdb"
+)
+
+// CursorStreamable is a mock type for the dban.CursorStreamable type
+type CursorStreamable[T any] struct {
+	mock.Mock
+}
+
+// SelectWithCursorParams provides a mock function with given fields: params
+func (_m *CursorStreamable[T]) SelectWithCursorParams(params pgdb.CursorPageParams) ([]T, error) {
+	ret := _m.Called(params)
+
+	var r0 []T
+	if rf, ok := ret.Get(0).(func(pgdb.CursorPageParams) []T); ok {
+		r0 = rf(params)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]T)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(pgdb.CursorPageParams) error); ok {
+		r1 = rf(params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+type mockConstructorTestingTNewCursorStreamable interface {
+	mock.TestingT
+	Cleanup(func())
+}
+
+// NewCursorStreamable creates a new instance of CursorStreamable. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+func NewCursorStreamable[T any](t mockConstructorTestingTNewCursorStreamable) *CursorStreamable[T] {
+	mock := &CursorStreamable[T]{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,112 @@
+package mocks
+
+import (
+	context "context"
+	time "time"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// KeysetStreamer is a mock type for the dban.KeysetStreamer type
+type KeysetStreamer[T, C any] struct {
+	mock.Mock
+}
+
+// FormList provides a mock function with given fields:
+func (_m *KeysetStreamer[T, C]) FormList() ([]T, error) {
+	ret := _m.Called()
+
+	var r0 []T
+	if rf, ok := ret.Get(0).(func() []T); ok {
+		r0 = rf()
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]T)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FormListAndProcess provides a mock function with given fields: fn
+func (_m *KeysetStreamer[T, C]) FormListAndProcess(fn func(context.Context, T) error) error {
+	ret := _m.Called(fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(func(context.Context, T) error) error); ok {
+		r0 = rf(fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetCursor provides a mock function with given fields:
+func (_m *KeysetStreamer[T, C]) GetCursor() (C, error) {
+	ret := _m.Called()
+
+	var r0 C
+	if rf, ok := ret.Get(0).(func() C); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(C)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// LastProgress provides a mock function with given fields:
+func (_m *KeysetStreamer[T, C]) LastProgress() time.Time {
+	ret := _m.Called()
+
+	var r0 time.Time
+	if rf, ok := ret.Get(0).(func() time.Time); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(time.Time)
+	}
+
+	return r0
+}
+
+// Name provides a mock function with given fields:
+func (_m *KeysetStreamer[T, C]) Name() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+type mockConstructorTestingTNewKeysetStreamer interface {
+	mock.TestingT
+	Cleanup(func())
+}
+
+// NewKeysetStreamer creates a new instance of KeysetStreamer. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+func NewKeysetStreamer[T, C any](t mockConstructorTestingTNewKeysetStreamer) *KeysetStreamer[T, C] {
+	mock := &KeysetStreamer[T, C]{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
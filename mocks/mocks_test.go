@@ -0,0 +1,58 @@
+package mocks
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+// compile-time checks that the mocks stay in sync with the interfaces
+var (
+	_ dban.Streamable[int]          = &Streamable[int]{}
+	_ dban.Streamer[struct{}]       = &Streamer[struct{}]{}
+	_ dban.CursorStreamable[int]    = &CursorStreamable[int]{}
+	_ dban.CursorStreamer[struct{}] = &CursorStreamer[struct{}]{}
+)
+
+func TestStreamable(t *testing.T) {
+	stream := NewStreamable[string](t).ExpectPages(2, []string{"a", "b"}, []string{"c"})
+
+	batchSize := uint64(2)
+	streamer := dban.NewStreamer(dban.StreamerInitParams[string]{
+		Stream:      stream,
+		KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+		KeyValueKey: "cursor",
+		BatchSize:   &batchSize,
+	})
+
+	for _, expected := range [][]string{{"a", "b"}, {"c"}, {"a", "b"}} {
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, expected, list)
+	}
+}
+
+func TestStreamer(t *testing.T) {
+	streamer := NewStreamer[int](t)
+	streamer.ExpectFormListAndProcess(1, 2, 3)
+	streamer.ExpectFormListAndProcess(4)
+
+	var processed []int
+	process := func(_ context.Context, item int) error {
+		processed = append(processed, item)
+		if item == 4 {
+			return errors.New("failed to process")
+		}
+		return nil
+	}
+
+	require.NoError(t, streamer.FormListAndProcess(process))
+	assert.Error(t, streamer.FormListAndProcess(process))
+	assert.Equal(t, []int{1, 2, 3, 4}, processed)
+	streamer.AssertFormListAndProcessCalled(t, 2)
+}
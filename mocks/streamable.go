@@ -0,0 +1,56 @@
+package mocks
+
+import (
+	mock "github.com/stretchr/testify/mock"
+	pgdb "gitlab.com/distributed_lab/kit/pgdb"
+)
+
+// Streamable is a mock type for the dban.Streamable type
+type Streamable[T any] struct {
+	mock.Mock
+}
+
+// SelectWithPageParams provides a mock function with given fields: pageParams
+func (_m *Streamable[T]) SelectWithPageParams(pageParams pgdb.OffsetPageParams) ([]T, error) {
+	ret := _m.Called(pageParams)
+
+	var r0 []T
+	if rf, ok := ret.Get(0).(func(pgdb.OffsetPageParams) []T); ok {
+		r0 = rf(pageParams)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]T)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(pgdb.OffsetPageParams) error); ok {
+		r1 = rf(pageParams)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ExpectPages programs the mock to return pages for page numbers 0, 1, 2 and so on
+// requested with the limit. The page after the last one is empty
+func (_m *Streamable[T]) ExpectPages(limit uint64, pages ...[]T) *Streamable[T] {
+	for i, page := range append(pages, []T{}) {
+		_m.On("SelectWithPageParams", pgdb.OffsetPageParams{Limit: limit, PageNumber: uint64(i)}).Return(page, nil)
+	}
+	return _m
+}
+
+type mockConstructorTestingTNewStreamable interface {
+	mock.TestingT
+	Cleanup(func())
+}
+
+// NewStreamable creates a new instance of Streamable. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+func NewStreamable[T any](t mockConstructorTestingTNewStreamable) *Streamable[T] {
+	mock := &Streamable[T]{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
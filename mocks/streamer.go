@@ -0,0 +1,474 @@
+package mocks
+
+import (
+	context "context"
+	time "time"
+
+	mock "github.com/stretchr/testify/mock"
+	dban "github.com/zspkg/dban"
+)
+
+// Streamer is a mock type for the dban.Streamer type
+type Streamer[T any] struct {
+	mock.Mock
+}
+
+// BreakerState provides a mock function with given fields:
+func (_m *Streamer[T]) BreakerState() (dban.CircuitState, error) {
+	ret := _m.Called()
+
+	var r0 dban.CircuitState
+	if rf, ok := ret.Get(0).(func() dban.CircuitState); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(dban.CircuitState)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Flush provides a mock function with given fields:
+func (_m *Streamer[T]) Flush() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// FormAndProcessRows provides a mock function with given fields: fn
+func (_m *Streamer[T]) FormAndProcessRows(fn func(context.Context, T) error) error {
+	ret := _m.Called(fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(func(context.Context, T) error) error); ok {
+		r0 = rf(fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// FormList provides a mock function with given fields:
+func (_m *Streamer[T]) FormList() ([]T, error) {
+	ret := _m.Called()
+
+	var r0 []T
+	if rf, ok := ret.Get(0).(func() []T); ok {
+		r0 = rf()
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]T)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FormListAndProcess provides a mock function with given fields: fn
+func (_m *Streamer[T]) FormListAndProcess(fn func(context.Context, T) error) error {
+	ret := _m.Called(fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(func(context.Context, T) error) error); ok {
+		r0 = rf(fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// FormListAndProcessBatch provides a mock function with given fields: fn
+func (_m *Streamer[T]) FormListAndProcessBatch(fn func(context.Context, []T) error) error {
+	ret := _m.Called(fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(func(context.Context, []T) error) error); ok {
+		r0 = rf(fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// FormListAndProcessReport provides a mock function with given fields: fn
+func (_m *Streamer[T]) FormListAndProcessReport(fn func(context.Context, T) error) (dban.BatchReport[T], error) {
+	ret := _m.Called(fn)
+
+	var r0 dban.BatchReport[T]
+	if rf, ok := ret.Get(0).(func(func(context.Context, T) error) dban.BatchReport[T]); ok {
+		r0 = rf(fn)
+	} else {
+		r0 = ret.Get(0).(dban.BatchReport[T])
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(func(context.Context, T) error) error); ok {
+		r1 = rf(fn)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FormListAndProcessTx provides a mock function with given fields: fn
+func (_m *Streamer[T]) FormListAndProcessTx(fn func(context.Context, dban.KeyValueQ, T) error) error {
+	ret := _m.Called(fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(func(context.Context, dban.KeyValueQ, T) error) error); ok {
+		r0 = rf(fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetCurrentPage provides a mock function with given fields:
+func (_m *Streamer[T]) GetCurrentPage() (uint64, error) {
+	ret := _m.Called()
+
+	var r0 uint64
+	if rf, ok := ret.Get(0).(func() uint64); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(uint64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetLastRunInfo provides a mock function with given fields:
+func (_m *Streamer[T]) GetLastRunInfo() (*dban.LastRunInfo, error) {
+	ret := _m.Called()
+
+	var r0 *dban.LastRunInfo
+	if rf, ok := ret.Get(0).(func() *dban.LastRunInfo); ok {
+		r0 = rf()
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).(*dban.LastRunInfo)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetStats provides a mock function with given fields:
+func (_m *Streamer[T]) GetStats() dban.StreamerStats {
+	ret := _m.Called()
+
+	var r0 dban.StreamerStats
+	if rf, ok := ret.Get(0).(func() dban.StreamerStats); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(dban.StreamerStats)
+	}
+
+	return r0
+}
+
+// IsPaused provides a mock function with given fields:
+func (_m *Streamer[T]) IsPaused() (bool, error) {
+	ret := _m.Called()
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func() bool); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// LastProgress provides a mock function with given fields:
+func (_m *Streamer[T]) LastProgress() time.Time {
+	ret := _m.Called()
+
+	var r0 time.Time
+	if rf, ok := ret.Get(0).(func() time.Time); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(time.Time)
+	}
+
+	return r0
+}
+
+// Name provides a mock function with given fields:
+func (_m *Streamer[T]) Name() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// Pause provides a mock function with given fields:
+func (_m *Streamer[T]) Pause() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ProcessAll provides a mock function with given fields: fn
+func (_m *Streamer[T]) ProcessAll(fn func(context.Context, T) error) (dban.ProcessReport, error) {
+	ret := _m.Called(fn)
+
+	var r0 dban.ProcessReport
+	if rf, ok := ret.Get(0).(func(func(context.Context, T) error) dban.ProcessReport); ok {
+		r0 = rf(fn)
+	} else {
+		r0 = ret.Get(0).(dban.ProcessReport)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(func(context.Context, T) error) error); ok {
+		r1 = rf(fn)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Reset provides a mock function with given fields:
+func (_m *Streamer[T]) Reset() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ResetBreaker provides a mock function with given fields:
+func (_m *Streamer[T]) ResetBreaker() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ResetRunCounters provides a mock function with given fields:
+func (_m *Streamer[T]) ResetRunCounters() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Resume provides a mock function with given fields:
+func (_m *Streamer[T]) Resume() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Run provides a mock function with given fields: ctx, fn, cfg
+func (_m *Streamer[T]) Run(ctx context.Context, fn func(context.Context, T) error, cfg dban.RunConfig) error {
+	ret := _m.Called(ctx, fn, cfg)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, func(context.Context, T) error, dban.RunConfig) error); ok {
+		r0 = rf(ctx, fn, cfg)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RunCron provides a mock function with given fields: ctx, spec, fn
+func (_m *Streamer[T]) RunCron(ctx context.Context, spec string, fn func(context.Context, T) error) error {
+	ret := _m.Called(ctx, spec, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, func(context.Context, T) error) error); ok {
+		r0 = rf(ctx, spec, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Select provides a mock function with given fields: pageNumber
+func (_m *Streamer[T]) Select(pageNumber uint64) ([]T, error) {
+	ret := _m.Called(pageNumber)
+
+	var r0 []T
+	if rf, ok := ret.Get(0).(func(uint64) []T); ok {
+		r0 = rf(pageNumber)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]T)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(uint64) error); ok {
+		r1 = rf(pageNumber)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetPage provides a mock function with given fields: page
+func (_m *Streamer[T]) SetPage(page uint64) error {
+	ret := _m.Called(page)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(uint64) error); ok {
+		r0 = rf(page)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SkipToEnd provides a mock function with given fields:
+func (_m *Streamer[T]) SkipToEnd() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Stream provides a mock function with given fields: ctx
+func (_m *Streamer[T]) Stream(ctx context.Context) (<-chan T, <-chan error) {
+	ret := _m.Called(ctx)
+
+	var r0 <-chan T
+	if rf, ok := ret.Get(0).(func(context.Context) <-chan T); ok {
+		r0 = rf(ctx)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).(<-chan T)
+	}
+
+	var r1 <-chan error
+	if rf, ok := ret.Get(1).(func(context.Context) <-chan error); ok {
+		r1 = rf(ctx)
+	} else if ret.Get(1) != nil {
+		r1 = ret.Get(1).(<-chan error)
+	}
+
+	return r0, r1
+}
+
+// ExpectFormListAndProcess programs the next FormListAndProcess call to pass items to
+// the processing function one by one, stopping at the first error it returns
+func (_m *Streamer[T]) ExpectFormListAndProcess(items ...T) *mock.Call {
+	return _m.On("FormListAndProcess", mock.Anything).Return(func(fn func(context.Context, T) error) error {
+		for _, item := range items {
+			if err := fn(context.Background(), item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Once()
+}
+
+// AssertFormListAndProcessCalled asserts that FormListAndProcess was called exactly times times
+func (_m *Streamer[T]) AssertFormListAndProcessCalled(t mock.TestingT, times int) bool {
+	return _m.AssertNumberOfCalls(t, "FormListAndProcess", times)
+}
+
+type mockConstructorTestingTNewStreamer interface {
+	mock.TestingT
+	Cleanup(func())
+}
+
+// NewStreamer creates a new instance of Streamer. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+func NewStreamer[T any](t mockConstructorTestingTNewStreamer) *Streamer[T] {
+	mock := &Streamer[T]{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
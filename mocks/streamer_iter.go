@@ -0,0 +1,21 @@
+//go:build go1.23
+
+package mocks
+
+import (
+	iter "iter"
+)
+
+// All provides a mock function with given fields:
+func (_m *Streamer[T]) All() iter.Seq2[T, error] {
+	ret := _m.Called()
+
+	var r0 iter.Seq2[T, error]
+	if rf, ok := ret.Get(0).(func() iter.Seq2[T, error]); ok {
+		r0 = rf()
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).(iter.Seq2[T, error])
+	}
+
+	return r0
+}
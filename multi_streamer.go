@@ -0,0 +1,239 @@
+package dban
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// MultiStreamer processes the entities of several sources with a single loop, keeping a
+// cursor per source
+type MultiStreamer[T any] interface {
+	// FormListAndProcess forms a list from the next source in turn and processes it the way
+	// Streamer.FormListAndProcess does. Sources with no entities are skipped, so it returns
+	// nil without processing anything only once all of them are empty. The turn passes to
+	// the next source after a failure as well, so a failing source does not starve the rest
+	FormListAndProcess(fn func(ctx context.Context, t T) error) error
+	// ProcessAll processes the sources until the end of each of them is reached the way
+	// Streamer.ProcessAll does, taking a list from each source in turn
+	ProcessAll(fn func(ctx context.Context, t T) error) (ProcessReport, error)
+	// GetStats returns the counters of the streamers of the sources by the names of sources
+	GetStats() map[string]StreamerStats
+}
+
+// MultiStreamerOption is an optional parameter of a multi streamer
+type MultiStreamerOption[T any] func(*multiStreamer[T])
+
+// WithSourceParams makes the multi streamer create the streamers of the sources with params,
+// e.g. to set BatchSize, Log or EntityErrorPolicy. Stream, KeyValueQ and KeyValueKey are set
+// by the multi streamer
+func WithSourceParams[T any](params StreamerInitParams[T]) MultiStreamerOption[T] {
+	return func(s *multiStreamer[T]) {
+		s.params = params
+	}
+}
+
+// WithFailedSourcesSkipped makes the multi streamer go on with the rest of the sources once
+// one of them fails, logging the failure with Log of the source params and returning the
+// failures collected as SourceErrors once the rest are done with
+func WithFailedSourcesSkipped[T any]() MultiStreamerOption[T] {
+	return func(s *multiStreamer[T]) {
+		s.skipFailed = true
+	}
+}
+
+type multiStreamer[T any] struct {
+	params     StreamerInitParams[T]
+	skipFailed bool
+	names      []string
+	sources    map[string]*streamer[T]
+	err        error
+
+	mu sync.Mutex
+	// next is the index of the source taking the next turn in names
+	next int
+}
+
+// NewMultiStreamer creates a MultiStreamer of the sources by their names, storing the cursor
+// of each of them under keyPrefix with the ":<name>" suffix. The sources take turns in the
+// order of their names. An invalid keyPrefix or name, or no sources at all make every
+// method fail
+func NewMultiStreamer[T any](sources map[string]Streamable[T], kvQ KeyValueQ, keyPrefix string, opts ...MultiStreamerOption[T]) MultiStreamer[T] {
+	s := &multiStreamer[T]{sources: make(map[string]*streamer[T], len(sources))}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if len(sources) == 0 {
+		s.err = errors.New("multi streamer requires sources")
+		return s
+	}
+
+	for name, source := range sources {
+		s.names = append(s.names, name)
+		params := s.params
+		params.Stream = source
+		params.KeyValueQ = kvQ
+		params.KeyValueKey = keyPrefix + ":" + name
+		s.sources[name] = NewStreamer(params).(*streamer[T])
+		if err := s.sources[name].err; err != nil && s.err == nil {
+			s.err = errors.Wrap(err, "invalid source", logan.F{"source": name})
+		}
+	}
+	sort.Strings(s.names)
+	return s
+}
+
+func (s *multiStreamer[T]) FormListAndProcess(fn func(ctx context.Context, t T) error) error {
+	if s.err != nil {
+		return errors.Wrap(s.err, "invalid streamer")
+	}
+
+	var failed SourceErrors
+	for range s.names {
+		name := s.turn()
+		_, formed, _, err := s.sources[name].formListAndProcess(eachEntity(fn))
+		if stopped(err) {
+			return nil
+		}
+		if err != nil {
+			if !s.skipFailed {
+				return errors.Wrap(err, "failed to process source", logan.F{"source": name})
+			}
+			s.sourceFailed(name, err, &failed)
+			continue
+		}
+		if formed != 0 {
+			break
+		}
+	}
+	return failed.orNil()
+}
+
+func (s *multiStreamer[T]) ProcessAll(fn func(ctx context.Context, t T) error) (report ProcessReport, err error) {
+	if s.err != nil {
+		return report, errors.Wrap(s.err, "invalid streamer")
+	}
+
+	drains := make(map[string]*streamer[T], len(s.names))
+	for _, name := range s.names {
+		drains[name] = s.sources[name].drainer()
+	}
+	defer func() {
+		for _, name := range s.names {
+			if flushErr := s.sources[name].Flush(); flushErr != nil && err == nil {
+				err = errors.Wrap(flushErr, "failed to flush source", logan.F{"source": name})
+			}
+		}
+	}()
+
+	var failed SourceErrors
+	for active := s.names; len(active) != 0; {
+		pending := active[:0:0]
+		for _, name := range active {
+			more, err := drains[name].drainList(fn, &report)
+			if stopped(err) {
+				return report, nil
+			}
+			if err != nil {
+				if !s.skipFailed || Is(err, ErrInterrupted) {
+					return report, errors.Wrap(err, "failed to process source", logan.F{"source": name})
+				}
+				s.sourceFailed(name, err, &failed)
+				continue
+			}
+			if more {
+				pending = append(pending, name)
+			}
+		}
+		active = pending
+	}
+	return report, failed.orNil()
+}
+
+func (s *multiStreamer[T]) GetStats() map[string]StreamerStats {
+	stats := make(map[string]StreamerStats, len(s.sources))
+	for name, source := range s.sources {
+		stats[name] = source.GetStats()
+	}
+	return stats
+}
+
+// turn returns the name of the source taking the turn and passes it to the next one
+func (s *multiStreamer[T]) turn() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := s.names[s.next]
+	s.next = (s.next + 1) % len(s.names)
+	return name
+}
+
+func (s *multiStreamer[T]) sourceFailed(name string, err error, failed *SourceErrors) {
+	if s.params.Log != nil {
+		s.params.Log.WithError(err).WithField("source", name).Error("Source failed, skipping it")
+	}
+	failed.Errors = append(failed.Errors, &SourceError{Source: name, Err: err})
+}
+
+// SourceError is a failure of a source of a MultiStreamer or of a streamer of a
+// StreamerGroup, named by Source
+type SourceError struct {
+	Source string
+	Err    error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("source %q failed: %s", e.Source, e.Err)
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// Cause makes errors.Cause look through SourceError
+func (e *SourceError) Cause() error {
+	return e.Err
+}
+
+// SourceErrors are the failures of the sources skipped by a MultiStreamer (see
+// WithFailedSourcesSkipped) or the terminal failures of the streamers of a StreamerGroup
+type SourceErrors struct {
+	Errors []*SourceError
+}
+
+func (e *SourceErrors) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d of sources failed: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+func (e *SourceErrors) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+func (e *SourceErrors) Is(target error) bool {
+	for _, err := range e.Errors {
+		if Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// orNil returns e as an error if it holds some
+func (e *SourceErrors) orNil() error {
+	if e == nil || len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,95 @@
+package dban_test
+
+import (
+	"context"
+	stderrors "errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+func TestMultiStreamer(t *testing.T) {
+	batchSize := uint64(2)
+	params := dban.StreamerInitParams[int]{BatchSize: &batchSize}
+	sources := func() map[string]dban.Streamable[int] {
+		return map[string]dban.Streamable[int]{
+			"a": dbantest.NewSliceStreamable([]int{1, 2, 3}),
+			"b": dbantest.NewSliceStreamable([]int(nil)),
+			"c": dbantest.NewSliceStreamable([]int{31, 32, 33, 34, 35}),
+		}
+	}
+	collect := func(processed *[]int) func(context.Context, int) error {
+		return func(_ context.Context, i int) error {
+			*processed = append(*processed, i)
+			return nil
+		}
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := dban.NewMultiStreamer(sources(), kvQ, "multi-rr", dban.WithSourceParams(params))
+
+		var processed []int
+		for i := 0; i < 3; i++ {
+			require.NoError(t, streamer.FormListAndProcess(collect(&processed)))
+		}
+		assert.Equal(t, []int{1, 2, 31, 32, 3}, processed, "the empty source must be skipped")
+		assert.Equal(t, "1", kvQ.MustGet("multi-rr:c").Value, "every source must have its own cursor")
+
+		stats := streamer.GetStats()
+		assert.Equal(t, int64(3), stats["a"].Processed)
+		assert.Equal(t, int64(0), stats["b"].Processed)
+		assert.Equal(t, int64(2), stats["c"].Processed)
+	})
+
+	t.Run("process all", func(t *testing.T) {
+		streamer := dban.NewMultiStreamer(sources(), dbantest.NewMemoryKeyValueQ(), "multi-all", dban.WithSourceParams(params))
+
+		var processed []int
+		report, err := streamer.ProcessAll(collect(&processed))
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 31, 32, 3, 33, 34, 35}, processed)
+		assert.Equal(t, uint64(8), report.Entities)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		failure := errors.New("boom")
+		failing := func(_ context.Context, i int) error {
+			if i == 1 {
+				return failure
+			}
+			return nil
+		}
+
+		_, err := dban.NewMultiStreamer(sources(), dbantest.NewMemoryKeyValueQ(), "multi-failed", dban.WithSourceParams(params)).
+			ProcessAll(failing)
+		assert.True(t, dban.Is(err, failure))
+		assert.Equal(t, "a", errors.GetFields(err)["source"])
+
+		var processed []int
+		streamer := dban.NewMultiStreamer(sources(), dbantest.NewMemoryKeyValueQ(), "multi-skipped",
+			dban.WithSourceParams(params), dban.WithFailedSourcesSkipped[int]())
+		_, err = streamer.ProcessAll(func(ctx context.Context, i int) error {
+			if err := failing(ctx, i); err != nil {
+				return err
+			}
+			processed = append(processed, i)
+			return nil
+		})
+		var sourceErrs *dban.SourceErrors
+		require.True(t, stderrors.As(err, &sourceErrs))
+		require.Len(t, sourceErrs.Errors, 1)
+		assert.Equal(t, "a", sourceErrs.Errors[0].Source)
+		assert.Equal(t, []int{31, 32, 33, 34, 35}, processed, "the failing source must not starve the rest")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		err := dban.NewMultiStreamer[int](nil, dbantest.NewMemoryKeyValueQ(), "multi-invalid").
+			FormListAndProcess(collect(new([]int)))
+		assert.ErrorContains(t, err, "requires sources")
+	})
+}
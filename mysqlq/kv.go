@@ -0,0 +1,327 @@
+// Package mysqlq implements dban.KeyValueQ on top of MySQL
+package mysqlq
+
+import (
+	"database/sql"
+	"embed"
+	"io/fs"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/go-sql-driver/mysql"
+	"github.com/zspkg/dban"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+//go:embed migrations
+var migrationsFS embed.FS
+
+const (
+	keyValueTable = "key_value"
+
+	// key is a reserved word in MySQL, so columns are always quoted
+	keyColumn   = "`key`"
+	valueColumn = "`value`"
+)
+
+// MySQL error numbers mapped onto the errors of dban
+const (
+	errDuplicateEntry  = 1062
+	errLockWaitTimeout = 1205
+	errLockNowait      = 3572
+)
+
+var keyValueSelect = squirrel.Select(keyColumn, valueColumn).From(keyValueTable)
+
+// NewKVMigrator creates a new instance of a migrator applying the MySQL key value migrations
+func NewKVMigrator(db *sql.DB, log *logan.Entry, opts ...dban.KVMigratorOption) dban.KeyValueMigrator {
+	migrations, err := fs.Sub(migrationsFS, "migrations")
+	if err != nil {
+		panic(errors.Wrap(err, "failed to open embedded migrations"))
+	}
+	return dban.NewKVMigrator(db, log, append(opts, dban.WithDialect("mysql", migrations))...)
+}
+
+type keyValueQ struct {
+	db      *sql.DB
+	filters dban.KeyValueFilters
+	// prefixes are applied apart from filters, so that they are compared in binary
+	prefixes []string
+}
+
+// NewKeyValueQ creates a new instance of a key value querier over the MySQL database.
+//
+// As with Postgres, the lock taken by LockingGet lasts until the end of the current
+// transaction, so outside a transaction it is released right away
+func NewKeyValueQ(db *sql.DB) dban.KeyValueQ {
+	return &keyValueQ{
+		db: db,
+	}
+}
+
+func (q *keyValueQ) New() dban.KeyValueQ {
+	return NewKeyValueQ(q.db)
+}
+
+func (q *keyValueQ) Upsert(kv dban.KeyValue) error {
+	_, err := squirrel.Insert(keyValueTable).
+		Columns(keyColumn, valueColumn).
+		Values(kv.Key, kv.Value).
+		Suffix("ON DUPLICATE KEY UPDATE " + valueColumn + " = VALUES(" + valueColumn + ")").
+		RunWith(q.db).
+		Exec()
+	if err != nil {
+		return wrapError(err, "failed to upsert value", kv.Key)
+	}
+	return nil
+}
+
+// UpsertMany upserts the values with a single statement. MySQL applies the rows in
+// order, so the last value of a repeated key wins
+func (q *keyValueQ) UpsertMany(kvs []dban.KeyValue) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+
+	query := squirrel.Insert(keyValueTable).
+		Columns(keyColumn, valueColumn).
+		Suffix("ON DUPLICATE KEY UPDATE " + valueColumn + " = VALUES(" + valueColumn + ")")
+	for _, kv := range kvs {
+		query = query.Values(kv.Key, kv.Value)
+	}
+	if _, err := query.RunWith(q.db).Exec(); err != nil {
+		return wrapError(err, "failed to upsert values", kvs[0].Key)
+	}
+	return nil
+}
+
+func (q *keyValueQ) Delete(key string) error {
+	_, err := q.DeleteMany([]string{key})
+	return err
+}
+
+func (q *keyValueQ) DeleteMany(keys []string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	result, err := squirrel.Delete(keyValueTable).
+		Where(squirrel.Eq{keyColumn: keys}).
+		RunWith(q.db).
+		Exec()
+	if err != nil {
+		return 0, wrapError(err, "failed to delete values", strings.Join(keys, ", "))
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, wrapError(err, "failed to get number of deleted values", strings.Join(keys, ", "))
+	}
+	return deleted, nil
+}
+
+// Update fails with dban.ErrNoTransaction, as the querier does not run transactions to hold the lock of LockingGet in
+func (q *keyValueQ) Update(key string, fn func(current *string) (string, error)) (dban.KeyValue, error) {
+	return dban.KeyValue{}, errors.From(dban.ErrNoTransaction, logan.F{"key": key})
+}
+
+func (q *keyValueQ) Get(key string) (*dban.KeyValue, error) {
+	return q.get(key, false)
+}
+
+func (q *keyValueQ) GetMany(keys []string) (map[string]dban.KeyValue, error) {
+	values := make(map[string]dban.KeyValue, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	rows, err := keyValueSelect.Where(squirrel.Eq{keyColumn: keys}).RunWith(q.db).Query()
+	if err != nil {
+		return nil, wrapError(err, "failed to get values", strings.Join(keys, ", "))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var value dban.KeyValue
+		if err = rows.Scan(&value.Key, &value.Value); err != nil {
+			return nil, wrapError(err, "failed to scan value", strings.Join(keys, ", "))
+		}
+		values[value.Key] = value
+	}
+	if err = rows.Err(); err != nil {
+		return nil, wrapError(err, "failed to get values", strings.Join(keys, ", "))
+	}
+	return values, nil
+}
+
+// SelectByPrefix compares the beginning of keys in binary, so that the prefix is matched
+// regardless of the collation of the table
+func (q *keyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	query := keyValueSelect
+	if prefix != "" {
+		query = query.Where("LEFT("+keyColumn+", CHAR_LENGTH(?)) = BINARY ?", prefix, prefix)
+	}
+
+	values, err := q.selectValues(params.ApplyTo(query, keyColumn))
+	if err != nil {
+		return nil, wrapError(err, "failed to select values by prefix", prefix)
+	}
+	return values, nil
+}
+
+// selectValues runs the query selecting key values
+func (q *keyValueQ) selectValues(query squirrel.SelectBuilder) ([]dban.KeyValue, error) {
+	rows, err := query.RunWith(q.db).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []dban.KeyValue{}
+	for rows.Next() {
+		var value dban.KeyValue
+		if err = rows.Scan(&value.Key, &value.Value); err != nil {
+			return nil, errors.Wrap(err, "failed to scan value")
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// ListKeys compares the beginning of keys in binary the way SelectByPrefix does
+func (q *keyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	query := squirrel.Select(keyColumn).From(keyValueTable)
+	if prefix != "" {
+		query = query.Where("LEFT("+keyColumn+", CHAR_LENGTH(?)) = BINARY ?", prefix, prefix)
+	}
+
+	rows, err := params.ApplyTo(query, keyColumn).RunWith(q.db).Query()
+	if err != nil {
+		return nil, wrapError(err, "failed to list keys", prefix)
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return nil, wrapError(err, "failed to scan key", prefix)
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, wrapError(err, "failed to list keys", prefix)
+	}
+	return keys, nil
+}
+
+func (q *keyValueQ) Count() (uint64, error) {
+	var count uint64
+	err := squirrel.Select("count(*)").From(keyValueTable).RunWith(q.db).QueryRow().Scan(&count)
+	if err != nil {
+		return 0, wrapError(err, "failed to count values", "")
+	}
+	return count, nil
+}
+
+func (q *keyValueQ) FilterByKeys(keys ...string) dban.KeyValueQ {
+	return &keyValueQ{db: q.db, filters: q.filters.ByKeys(keys...), prefixes: q.prefixes}
+}
+
+// FilterByPrefix compares the beginning of keys in binary the way SelectByPrefix does
+func (q *keyValueQ) FilterByPrefix(prefix string) dban.KeyValueQ {
+	prefixes := append(q.prefixes[:len(q.prefixes):len(q.prefixes)], prefix)
+	return &keyValueQ{db: q.db, filters: q.filters, prefixes: prefixes}
+}
+
+// FilterByValueLike matches values in binary the way SelectByPrefix compares keys
+func (q *keyValueQ) FilterByValueLike(pattern string) dban.KeyValueQ {
+	return &keyValueQ{db: q.db, filters: q.filters.ByValueLike(pattern), prefixes: q.prefixes}
+}
+
+func (q *keyValueQ) Select() ([]dban.KeyValue, error) {
+	values, err := q.selectValues(q.filtered().OrderBy(keyColumn))
+	if err != nil {
+		return nil, wrapError(err, "failed to select filtered values", "")
+	}
+	return values, nil
+}
+
+func (q *keyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	values, err := q.selectValues(params.ApplyTo(q.filtered(), keyColumn))
+	if err != nil {
+		return nil, wrapError(err, "failed to select filtered values", "")
+	}
+	return values, nil
+}
+
+func (q *keyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	return q.SelectPage(dban.KeyValueStreamParams(params))
+}
+
+// filtered returns the query selecting the values passing the filters
+func (q *keyValueQ) filtered() squirrel.SelectBuilder {
+	query := q.filters.ApplyTo(keyValueSelect, keyColumn, valueColumn+" LIKE BINARY ?")
+	for _, prefix := range q.prefixes {
+		query = query.Where("LEFT("+keyColumn+", CHAR_LENGTH(?)) = BINARY ?", prefix, prefix)
+	}
+	return query
+}
+
+func (q *keyValueQ) MustGet(key string) *dban.KeyValue {
+	value, err := dban.GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+func (q *keyValueQ) LockingGet(key string) (*dban.KeyValue, error) {
+	return q.get(key, true)
+}
+
+func (q *keyValueQ) MustLockingGet(key string) *dban.KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
+
+func (q *keyValueQ) get(key string, forUpdate bool) (*dban.KeyValue, error) {
+	statement := keyValueSelect.Where(squirrel.Eq{keyColumn: key})
+	if forUpdate {
+		statement = statement.Suffix("FOR UPDATE")
+	}
+
+	var value dban.KeyValue
+	err := statement.RunWith(q.db).QueryRow().Scan(&value.Key, &value.Value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, wrapError(err, "failed to get value", key)
+	}
+
+	return &value, nil
+}
+
+// wrapError maps MySQL errors onto the errors of dban, keeping the original message
+func wrapError(err error, msg, key string) error {
+	fields := logan.F{"key": key}
+
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	if !ok {
+		return errors.Wrap(err, msg, fields)
+	}
+
+	switch mysqlErr.Number {
+	case errDuplicateEntry:
+		return errors.Wrap(dban.ErrDuplicateKey, msg+": "+mysqlErr.Error(), fields)
+	case errLockWaitTimeout, errLockNowait:
+		return errors.Wrap(dban.ErrRowLocked, msg+": "+mysqlErr.Error(), fields)
+	default:
+		return errors.Wrap(err, msg, fields)
+	}
+}
@@ -0,0 +1,94 @@
+package mysqlq
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+func newMockKeyValueQ(t *testing.T) (dban.KeyValueQ, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, mock.ExpectationsWereMet())
+		_ = db.Close()
+	})
+	return NewKeyValueQ(db), mock
+}
+
+func TestKeyValueQSQL(t *testing.T) {
+	kvQ, mock := newMockKeyValueQ(t)
+
+	mock.ExpectExec("INSERT INTO key_value (`key`,`value`) VALUES (?,?) ON DUPLICATE KEY UPDATE `value` = VALUES(`value`)").
+		WithArgs("foo", "bar").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "foo", Value: "bar"}))
+
+	mock.ExpectQuery("SELECT `key`, `value` FROM key_value WHERE `key` = ?").
+		WithArgs("foo").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("foo", "bar"))
+	assert.Equal(t, &dban.KeyValue{Key: "foo", Value: "bar"}, kvQ.MustGet("foo"))
+
+	mock.ExpectQuery("SELECT `key`, `value` FROM key_value WHERE `key` = ? FOR UPDATE").
+		WithArgs("missing").
+		WillReturnError(sql.ErrNoRows)
+	kv, err := kvQ.LockingGet("missing")
+	require.NoError(t, err)
+	assert.Nil(t, kv)
+}
+
+func TestKeyValueQErrors(t *testing.T) {
+	cases := map[string]struct {
+		err      error
+		expected error
+	}{
+		"lock wait timeout": {err: &mysql.MySQLError{Number: errLockWaitTimeout}, expected: dban.ErrRowLocked},
+		"lock nowait":       {err: &mysql.MySQLError{Number: errLockNowait}, expected: dban.ErrRowLocked},
+		"duplicate entry":   {err: &mysql.MySQLError{Number: errDuplicateEntry}, expected: dban.ErrDuplicateKey},
+		"other":             {err: &mysql.MySQLError{Number: 1064}, expected: nil},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			kvQ, mock := newMockKeyValueQ(t)
+			mock.ExpectQuery("SELECT `key`, `value` FROM key_value WHERE `key` = ? FOR UPDATE").
+				WillReturnError(tc.err)
+
+			_, err := kvQ.LockingGet("foo")
+			require.Error(t, err)
+			if tc.expected == nil {
+				assert.Equal(t, tc.err, errors.Cause(err))
+				return
+			}
+			assert.Equal(t, tc.expected, errors.Cause(err))
+			assert.Contains(t, err.Error(), tc.err.Error())
+		})
+	}
+}
+
+func TestKeyValueQMySQL(t *testing.T) {
+	dsn := os.Getenv("MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("MYSQL_DSN is not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	migrator := NewKVMigrator(db, nil, dban.WithForceDestructive())
+	require.NoError(t, migrator.MigrateUp())
+	t.Cleanup(func() { require.NoError(t, migrator.MigrateDown()) })
+
+	kvQ := NewKeyValueQ(db)
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "foo", Value: "bar"}))
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "foo", Value: "baz"}))
+	assert.Equal(t, &dban.KeyValue{Key: "foo", Value: "baz"}, kvQ.MustLockingGet("foo"))
+}
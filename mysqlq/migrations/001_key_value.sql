@@ -0,0 +1,11 @@
+-- +migrate Up
+
+create table key_value
+(
+    `key`   varchar(64) not null primary key,
+    `value` varchar(64) not null
+);
+
+-- +migrate Down
+
+drop table key_value;
@@ -0,0 +1,10 @@
+-- +migrate Up
+
+-- values such as JSON cursors and encrypted tokens do not fit into 64 characters
+alter table key_value
+    modify `value` text not null;
+
+-- +migrate Down
+
+alter table key_value
+    modify `value` varchar(64) not null;
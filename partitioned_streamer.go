@@ -0,0 +1,165 @@
+package dban
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// partitionsKeySuffix is appended to the cursor key to form a key storing the number of
+// partitions the stream is split into
+const partitionsKeySuffix = ":partitions"
+
+// PartitionedStreamable is an interface that an object must implement in order to be streamed
+// through in partitions: it selects a page of the entities of the partition only, e.g. with
+// WHERE id % partitions = partition. Every entity must belong to exactly one partition
+type PartitionedStreamable[T any] interface {
+	SelectWithPageParams(pageParams pgdb.OffsetPageParams, partition, partitions uint64) ([]T, error)
+}
+
+// PartitionedStreamer streams through a PartitionedStreamable with a streamer per partition,
+// each of them keeping its own cursor, so that the partitions are processed in parallel
+// without processing an entity twice
+type PartitionedStreamer[T any] interface {
+	// Partition returns the streamer of the partition, failing if the number of partitions
+	// differs from the one the stream was split into before
+	Partition(partition uint64) (Streamer[T], error)
+	// RunPartitions runs the streamers of all partitions concurrently (see Streamer.Run)
+	// until ctx is canceled. The first partition returning an error, e.g. a failure with
+	// FailFast set, stops the rest of them, and the error is returned once all of them stop
+	RunPartitions(ctx context.Context, fn func(ctx context.Context, t T) error, cfg RunConfig) error
+}
+
+type partitionedStreamer[T any] struct {
+	kvQ        KeyValueQ
+	key        string
+	partitions []Streamer[T]
+	err        error
+
+	mu      sync.Mutex
+	checked bool
+}
+
+// NewPartitionedStreamer creates a new instance of PartitionedStreamer splitting
+// initParams.PartitionedStream into that many partitions. The partitions are streamed by
+// streamers created with initParams, Stream replaced by the partition of PartitionedStream,
+// that store their cursors under KeyValueKey with the ":p<partition>" suffix, e.g. "foo:p0".
+// The number of partitions is stored under KeyValueKey with the ":partitions" suffix on first
+// use, and a different one is rejected with ErrPartitionsChanged, as the entities would move
+// between partitions with cursors counted for the old ones. Missing PartitionedStream or zero
+// partitions make every method of the streamer fail
+func NewPartitionedStreamer[T any](initParams StreamerInitParams[T], partitions uint64) PartitionedStreamer[T] {
+	s := &partitionedStreamer[T]{kvQ: initParams.KeyValueQ, key: initParams.KeyValueKey}
+	switch {
+	case initParams.PartitionedStream == nil:
+		s.err = errors.New("PartitionedStream is required")
+		return s
+	case partitions == 0:
+		s.err = errors.New("partitions must be at least 1")
+		return s
+	}
+
+	stream := initParams.PartitionedStream
+	s.partitions = make([]Streamer[T], partitions)
+	for partition := range s.partitions {
+		params := initParams
+		params.Stream = partitionStream[T]{stream: stream, partition: uint64(partition), partitions: partitions}
+		params.RowStream = nil
+		params.KeyValueKey = fmt.Sprintf("%s:p%d", initParams.KeyValueKey, partition)
+		s.partitions[partition] = NewStreamer(params)
+	}
+	return s
+}
+
+func (s *partitionedStreamer[T]) Partition(partition uint64) (Streamer[T], error) {
+	if err := s.checkPartitions(); err != nil {
+		return nil, err
+	}
+	if partition >= uint64(len(s.partitions)) {
+		return nil, errors.From(errors.New("partition is out of range"), logan.F{
+			"key":        s.key,
+			"partition":  partition,
+			"partitions": len(s.partitions),
+		})
+	}
+	return s.partitions[partition], nil
+}
+
+func (s *partitionedStreamer[T]) RunPartitions(ctx context.Context, fn func(ctx context.Context, t T) error, cfg RunConfig) error {
+	if err := s.checkPartitions(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+	)
+	for partition, streamer := range s.partitions {
+		wg.Add(1)
+		go func(partition int, streamer Streamer[T]) {
+			defer wg.Done()
+			if err := streamer.Run(ctx, fn, cfg); err != nil {
+				once.Do(func() {
+					first = errors.Wrap(err, "partition failed", logan.F{"key": s.key, "partition": partition})
+					cancel()
+				})
+			}
+		}(partition, streamer)
+	}
+	wg.Wait()
+	return first
+}
+
+// checkPartitions stores the number of partitions or compares it with the stored one. Once
+// it matches, it is not checked again
+func (s *partitionedStreamer[T]) checkPartitions() error {
+	if s.err != nil {
+		return errors.Wrap(s.err, "invalid streamer")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checked {
+		return nil
+	}
+
+	key := s.key + partitionsKeySuffix
+	fields := logan.F{"key": s.key, "partitions": len(s.partitions)}
+	storedKV, err := s.kvQ.Get(key)
+	if err != nil {
+		return errors.Wrap(err, "failed to get number of partitions", fields)
+	}
+	if storedKV == nil {
+		err = s.kvQ.Upsert(KeyValue{Key: key, Value: strconv.Itoa(len(s.partitions))})
+		if err != nil {
+			return errors.Wrap(err, "failed to store number of partitions", fields)
+		}
+		s.checked = true
+		return nil
+	}
+
+	if stored, err := strconv.ParseUint(storedKV.Value, 10, 64); err != nil || stored != uint64(len(s.partitions)) {
+		return errors.From(ErrPartitionsChanged, fields.Add("stored_partitions", storedKV.Value))
+	}
+	s.checked = true
+	return nil
+}
+
+// partitionStream is a Streamable of a single partition of a PartitionedStreamable
+type partitionStream[T any] struct {
+	stream     PartitionedStreamable[T]
+	partition  uint64
+	partitions uint64
+}
+
+func (s partitionStream[T]) SelectWithPageParams(pageParams pgdb.OffsetPageParams) ([]T, error) {
+	return s.stream.SelectWithPageParams(pageParams, s.partition, s.partitions)
+}
@@ -0,0 +1,114 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+// partitionedSlice splits a slice into partitions by the remainder of the entities
+type partitionedSlice []int
+
+func (s partitionedSlice) SelectWithPageParams(pageParams pgdb.OffsetPageParams, partition, partitions uint64) ([]int, error) {
+	var entities []int
+	for _, i := range s {
+		if uint64(i)%partitions == partition {
+			entities = append(entities, i)
+		}
+	}
+	return dbantest.NewSliceStreamable(entities).SelectWithPageParams(pageParams)
+}
+
+func TestPartitionedStreamer(t *testing.T) {
+	newStreamer := func(key string, kvQ dban.KeyValueQ, partitions uint64) dban.PartitionedStreamer[int] {
+		batchSize := uint64(2)
+		return dban.NewPartitionedStreamer(dban.StreamerInitParams[int]{
+			PartitionedStream: partitionedSlice{1, 2, 3, 4, 5, 6, 7, 8, 9},
+			KeyValueQ:         kvQ,
+			KeyValueKey:       key,
+			BatchSize:         &batchSize,
+		}, partitions)
+	}
+	cfg := dban.RunConfig{EmptyPollInterval: time.Millisecond, FailFast: true}
+
+	t.Run("partitions", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := newStreamer("partitioned", kvQ, 3)
+
+		for partition, expected := range [][]int{{3, 6}, {1, 4}, {2, 5}} {
+			p, err := streamer.Partition(uint64(partition))
+			require.NoError(t, err)
+			list, err := p.FormList()
+			require.NoError(t, err)
+			assert.Equal(t, expected, list)
+		}
+		assert.Equal(t, "1", kvQ.MustGet("partitioned:p0").Value, "every partition must have its own cursor")
+		assert.Equal(t, "3", kvQ.MustGet("partitioned:partitions").Value)
+
+		_, err := streamer.Partition(3)
+		assert.Error(t, err)
+	})
+
+	t.Run("run", func(t *testing.T) {
+		streamer := newStreamer("partitioned-run", dbantest.NewMemoryKeyValueQ(), 3)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var (
+			mu        sync.Mutex
+			processed = make(map[int]int)
+		)
+		err := streamer.RunPartitions(ctx, func(_ context.Context, i int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			processed[i]++
+			if len(processed) == 9 {
+				cancel()
+			}
+			return nil
+		}, cfg)
+		require.NoError(t, err)
+		for i := 1; i <= 9; i++ {
+			assert.Contains(t, processed, i)
+		}
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		streamer := newStreamer("partitioned-failed", dbantest.NewMemoryKeyValueQ(), 3)
+		failure := errors.New("boom")
+
+		err := streamer.RunPartitions(context.Background(), func(_ context.Context, i int) error {
+			if i == 5 {
+				return failure
+			}
+			return nil
+		}, cfg)
+		assert.True(t, dban.Is(err, failure), "the failure must stop the rest of the partitions")
+	})
+
+	t.Run("partitions changed", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		_, err := newStreamer("partitioned-changed", kvQ, 2).Partition(0)
+		require.NoError(t, err)
+
+		_, err = newStreamer("partitioned-changed", kvQ, 3).Partition(0)
+		assert.True(t, dban.Is(err, dban.ErrPartitionsChanged))
+		err = newStreamer("partitioned-changed", kvQ, 3).RunPartitions(context.Background(), func(context.Context, int) error {
+			return nil
+		}, cfg)
+		assert.True(t, dban.Is(err, dban.ErrPartitionsChanged))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := newStreamer("partitioned-invalid", dbantest.NewMemoryKeyValueQ(), 0).Partition(0)
+		assert.ErrorContains(t, err, "partitions must be at least 1")
+	})
+}
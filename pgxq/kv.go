@@ -0,0 +1,342 @@
+// Package pgxq implements dban.KeyValueQ over pgx for services using pgxpool directly
+package pgxq
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/zspkg/dban"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+const (
+	keyValueTable = "key_value"
+
+	keyColumn   = "key"
+	valueColumn = "value"
+)
+
+var (
+	statements     = squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	keyValueSelect = statements.Select(keyColumn, valueColumn).From(keyValueTable)
+)
+
+// queryer is implemented by both pgxpool.Pool and pgx.Tx
+type queryer interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+type keyValueQ struct {
+	db      queryer
+	filters dban.KeyValueFilters
+}
+
+// NewKeyValueQ creates a new instance of a key value querier over the pool. Outside
+// Transaction every query runs on its own, so LockingGet releases its lock right away
+func NewKeyValueQ(pool *pgxpool.Pool) dban.KeyValueQ {
+	return &keyValueQ{
+		db: pool,
+	}
+}
+
+// Transaction runs fn in a transaction with a querier bound to it, so that rows locked
+// by LockingGet stay locked until fn returns. The transaction is rolled back if fn fails
+func Transaction(ctx context.Context, pool *pgxpool.Pool, fn func(kvQ dban.KeyValueQ) error) error {
+	return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		return fn(&keyValueQ{db: tx})
+	})
+}
+
+// NewKVMigrator creates a migrator for the key value storage over a pgx database adapted
+// to database/sql, e.g. with stdlib.OpenDB(*pool.Config().ConnConfig)
+func NewKVMigrator(db *sql.DB, log *logan.Entry, opts ...dban.KVMigratorOption) dban.KeyValueMigrator {
+	return dban.NewKVMigrator(db, log, opts...)
+}
+
+func (q *keyValueQ) New() dban.KeyValueQ {
+	return &keyValueQ{db: q.db}
+}
+
+func (q *keyValueQ) Upsert(kv dban.KeyValue) error {
+	query, args, err := statements.Insert(keyValueTable).
+		Columns(keyColumn, valueColumn).
+		Values(kv.Key, kv.Value).
+		Suffix("ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value").
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "failed to build query", logan.F{"key": kv.Key})
+	}
+
+	if _, err = q.db.Exec(context.Background(), query, args...); err != nil {
+		return errors.Wrap(err, "failed to upsert value", logan.F{"key": kv.Key})
+	}
+	return nil
+}
+
+func (q *keyValueQ) UpsertMany(kvs []dban.KeyValue) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+
+	// Postgres cannot update the same row twice in one statement, so only the last value
+	// of a repeated key is kept
+	positions := make(map[string]int, len(kvs))
+	unique := make([]dban.KeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		if i, ok := positions[kv.Key]; ok {
+			unique[i] = kv
+			continue
+		}
+		positions[kv.Key] = len(unique)
+		unique = append(unique, kv)
+	}
+
+	statement := statements.Insert(keyValueTable).
+		Columns(keyColumn, valueColumn).
+		Suffix("ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value")
+	for _, kv := range unique {
+		statement = statement.Values(kv.Key, kv.Value)
+	}
+	query, args, err := statement.ToSql()
+	if err != nil {
+		return errors.Wrap(err, "failed to build query", logan.F{"count": len(kvs)})
+	}
+
+	if _, err = q.db.Exec(context.Background(), query, args...); err != nil {
+		return errors.Wrap(err, "failed to upsert values", logan.F{"count": len(kvs)})
+	}
+	return nil
+}
+
+func (q *keyValueQ) Delete(key string) error {
+	_, err := q.DeleteMany([]string{key})
+	return err
+}
+
+func (q *keyValueQ) DeleteMany(keys []string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	query, args, err := statements.Delete(keyValueTable).Where(squirrel.Eq{keyColumn: keys}).ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to build query", logan.F{"keys": keys})
+	}
+
+	tag, err := q.db.Exec(context.Background(), query, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete values", logan.F{"keys": keys})
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Update runs in a transaction of its own, or in a savepoint of the one passed to fn of
+// Transaction
+func (q *keyValueQ) Update(key string, fn func(current *string) (string, error)) (dban.KeyValue, error) {
+	var updated dban.KeyValue
+	err := pgx.BeginFunc(context.Background(), q.db, func(tx pgx.Tx) error {
+		var err error
+		updated, err = dban.UpdateLocked(&keyValueQ{db: tx}, key, fn)
+		return err
+	})
+	if err != nil {
+		return dban.KeyValue{}, err
+	}
+	return updated, nil
+}
+
+func (q *keyValueQ) Get(key string) (*dban.KeyValue, error) {
+	return q.get(key, false)
+}
+
+func (q *keyValueQ) GetMany(keys []string) (map[string]dban.KeyValue, error) {
+	values := make(map[string]dban.KeyValue, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	query, args, err := keyValueSelect.Where(squirrel.Eq{keyColumn: keys}).ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build query", logan.F{"keys": keys})
+	}
+
+	rows, err := q.db.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get values", logan.F{"keys": keys})
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var value dban.KeyValue
+		if err = rows.Scan(&value.Key, &value.Value); err != nil {
+			return nil, errors.Wrap(err, "failed to scan value", logan.F{"keys": keys})
+		}
+		values[value.Key] = value
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to get values", logan.F{"keys": keys})
+	}
+	return values, nil
+}
+
+func (q *keyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	statement := keyValueSelect
+	if prefix != "" {
+		statement = statement.Where(keyColumn+` LIKE ? ESCAPE '\'`, dban.LikePrefix(prefix))
+	}
+	values, err := q.selectValues(params.ApplyTo(statement, keyColumn))
+	return values, errors.Wrap(err, "failed to select values by prefix", logan.F{"prefix": prefix})
+}
+
+// selectValues runs the query selecting key values
+func (q *keyValueQ) selectValues(statement squirrel.SelectBuilder) ([]dban.KeyValue, error) {
+	query, args, err := statement.ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build query")
+	}
+
+	rows, err := q.db.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []dban.KeyValue{}
+	for rows.Next() {
+		var value dban.KeyValue
+		if err = rows.Scan(&value.Key, &value.Value); err != nil {
+			return nil, errors.Wrap(err, "failed to scan value")
+		}
+		values = append(values, value)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (q *keyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	statement := statements.Select(keyColumn).From(keyValueTable)
+	if prefix != "" {
+		statement = statement.Where(keyColumn+` LIKE ? ESCAPE '\'`, dban.LikePrefix(prefix))
+	}
+	query, args, err := params.ApplyTo(statement, keyColumn).ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build query", logan.F{"prefix": prefix})
+	}
+
+	rows, err := q.db.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list keys", logan.F{"prefix": prefix})
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return nil, errors.Wrap(err, "failed to scan key", logan.F{"prefix": prefix})
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to list keys", logan.F{"prefix": prefix})
+	}
+	return keys, nil
+}
+
+func (q *keyValueQ) Count() (uint64, error) {
+	query, args, err := statements.Select("count(*)").From(keyValueTable).ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to build query")
+	}
+
+	var count uint64
+	if err = q.db.QueryRow(context.Background(), query, args...).Scan(&count); err != nil {
+		return 0, errors.Wrap(err, "failed to count values")
+	}
+	return count, nil
+}
+
+func (q *keyValueQ) FilterByKeys(keys ...string) dban.KeyValueQ {
+	return &keyValueQ{db: q.db, filters: q.filters.ByKeys(keys...)}
+}
+
+func (q *keyValueQ) FilterByPrefix(prefix string) dban.KeyValueQ {
+	return &keyValueQ{db: q.db, filters: q.filters.ByKeyPrefix(prefix)}
+}
+
+func (q *keyValueQ) FilterByValueLike(pattern string) dban.KeyValueQ {
+	return &keyValueQ{db: q.db, filters: q.filters.ByValueLike(pattern)}
+}
+
+func (q *keyValueQ) Select() ([]dban.KeyValue, error) {
+	values, err := q.selectValues(q.filtered().OrderBy(keyColumn))
+	return values, errors.Wrap(err, "failed to select filtered values")
+}
+
+func (q *keyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	values, err := q.selectValues(params.ApplyTo(q.filtered(), keyColumn))
+	return values, errors.Wrap(err, "failed to select filtered values")
+}
+
+func (q *keyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	return q.SelectPage(dban.KeyValueStreamParams(params))
+}
+
+// filtered returns the query selecting the values passing the filters
+func (q *keyValueQ) filtered() squirrel.SelectBuilder {
+	return q.filters.ApplyTo(keyValueSelect, keyColumn, valueColumn+` LIKE ? ESCAPE '\'`)
+}
+
+func (q *keyValueQ) MustGet(key string) *dban.KeyValue {
+	value, err := dban.GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+func (q *keyValueQ) LockingGet(key string) (*dban.KeyValue, error) {
+	return q.get(key, true)
+}
+
+func (q *keyValueQ) MustLockingGet(key string) *dban.KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
+
+func (q *keyValueQ) get(key string, forUpdate bool) (*dban.KeyValue, error) {
+	statement := keyValueSelect.Where(squirrel.Eq{keyColumn: key})
+	if forUpdate {
+		statement = statement.Suffix("FOR UPDATE")
+	}
+
+	query, args, err := statement.ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build query", logan.F{"key": key})
+	}
+
+	var value dban.KeyValue
+	err = q.db.QueryRow(context.Background(), query, args...).Scan(&value.Key, &value.Value)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get value", logan.F{"key": key})
+	}
+
+	return &value, nil
+}
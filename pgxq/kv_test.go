@@ -0,0 +1,94 @@
+package pgxq
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+const testDatabaseURLEnv = "DBAN_TEST_DATABASE_URL"
+
+func newTestPool(t *testing.T) *pgxpool.Pool {
+	url := os.Getenv(testDatabaseURLEnv)
+	if url == "" {
+		t.Skipf("%s is not set", testDatabaseURLEnv)
+	}
+
+	pool, err := pgxpool.New(context.Background(), url)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	db := stdlib.OpenDB(*pool.Config().ConnConfig)
+	t.Cleanup(func() { _ = db.Close() })
+
+	migrator := NewKVMigrator(db, nil, dban.WithForceDestructive())
+	require.NoError(t, migrator.MigrateUp())
+	t.Cleanup(func() { require.NoError(t, migrator.MigrateDown()) })
+
+	return pool
+}
+
+func TestKeyValueQ(t *testing.T) {
+	kvQ := NewKeyValueQ(newTestPool(t))
+
+	kv, err := kvQ.Get("missing")
+	require.NoError(t, err)
+	assert.Nil(t, kv)
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "foo", Value: "bar"}))
+	require.NoError(t, kvQ.New().Upsert(dban.KeyValue{Key: "foo", Value: "baz"}))
+	assert.Equal(t, &dban.KeyValue{Key: "foo", Value: "baz"}, kvQ.MustGet("foo"))
+	assert.Equal(t, &dban.KeyValue{Key: "foo", Value: "baz"}, kvQ.MustLockingGet("foo"))
+}
+
+func TestTransactionLocks(t *testing.T) {
+	pool := newTestPool(t)
+	kvQ := NewKeyValueQ(pool)
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "cursor", Value: "0"}))
+
+	written := make(chan error, 1)
+	err := Transaction(context.Background(), pool, func(txQ dban.KeyValueQ) error {
+		if _, err := txQ.LockingGet("cursor"); err != nil {
+			return err
+		}
+
+		go func() { written <- kvQ.Upsert(dban.KeyValue{Key: "cursor", Value: "2"}) }()
+		select {
+		case <-written:
+			t.Error("row must stay locked until the end of the transaction")
+		case <-time.After(200 * time.Millisecond):
+		}
+
+		return txQ.Upsert(dban.KeyValue{Key: "cursor", Value: "1"})
+	})
+	require.NoError(t, err)
+	require.NoError(t, <-written)
+	assert.Equal(t, "2", kvQ.MustGet("cursor").Value)
+}
+
+func TestStreamerCursor(t *testing.T) {
+	kvQ := NewKeyValueQ(newTestPool(t))
+
+	batchSize := uint64(2)
+	streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3}),
+		KeyValueQ:   kvQ,
+		KeyValueKey: "cursor",
+		BatchSize:   &batchSize,
+	})
+
+	for _, expected := range [][]int{{1, 2}, {3}, {1, 2}} {
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, expected, list)
+	}
+	assert.Equal(t, &dban.KeyValue{Key: "cursor", Value: "1"}, kvQ.MustGet("cursor"))
+}
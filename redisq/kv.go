@@ -0,0 +1,232 @@
+// Package redisq implements dban.KeyValueQ on top of Redis, so that the streamer
+// could keep its cursor in Redis instead of Postgres
+package redisq
+
+import (
+	"context"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zspkg/dban"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// globEscaper escapes the special characters of the patterns of SCAN
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+type keyValueQ struct {
+	client    redis.UniversalClient
+	keyPrefix string
+	filters   dban.KeyValueFilters
+}
+
+// NewKeyValueQ creates a new instance of a key value querier storing values in Redis
+// under keys prefixed with keyPrefix.
+//
+// Redis has no row locks, so LockingGet degrades to a plain GET: the streamer works
+// as usual as long as a single instance drives the cursor
+func NewKeyValueQ(client redis.UniversalClient, keyPrefix string) dban.KeyValueQ {
+	return &keyValueQ{
+		client:    client,
+		keyPrefix: keyPrefix,
+	}
+}
+
+// NewRedisKeyValueQ is NewKeyValueQ for the callers that refer to it by the name of the
+// backend, e.g. along with the queriers of the other ones
+func NewRedisKeyValueQ(client redis.UniversalClient, keyPrefix string) dban.KeyValueQ {
+	return NewKeyValueQ(client, keyPrefix)
+}
+
+func (q *keyValueQ) New() dban.KeyValueQ {
+	return NewKeyValueQ(q.client, q.keyPrefix)
+}
+
+func (q *keyValueQ) Get(key string) (*dban.KeyValue, error) {
+	value, err := q.client.Get(context.Background(), q.keyPrefix+key).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get value from redis", logan.F{"key": key})
+	}
+
+	return &dban.KeyValue{Key: key, Value: value}, nil
+}
+
+func (q *keyValueQ) GetMany(keys []string) (map[string]dban.KeyValue, error) {
+	values := make(map[string]dban.KeyValue, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = q.keyPrefix + key
+	}
+	found, err := q.client.MGet(context.Background(), prefixed...).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get values from redis", logan.F{"keys": keys})
+	}
+
+	for i, value := range found {
+		if value, ok := value.(string); ok {
+			values[keys[i]] = dban.KeyValue{Key: keys[i], Value: value}
+		}
+	}
+	return values, nil
+}
+
+// SelectByPrefix scans the keys matching the prefix, so it is meant for occasional
+// listing rather than for hot paths
+func (q *keyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	keys, err := q.scanKeys(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := q.GetMany(keys)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]dban.KeyValue, 0, len(found))
+	for _, kv := range found {
+		values = append(values, kv)
+	}
+	return dban.PageKeyValues(values, params), nil
+}
+
+// ListKeys scans the keys matching the prefix the way SelectByPrefix does
+func (q *keyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	keys, err := q.scanKeys(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return dban.PageKeys(keys, params), nil
+}
+
+// Count scans the keys of the querier, as the database may keep keys of others
+func (q *keyValueQ) Count() (uint64, error) {
+	keys, err := q.scanKeys("")
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(keys)), nil
+}
+
+func (q *keyValueQ) FilterByKeys(keys ...string) dban.KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByKeys(keys...)
+	return &filtered
+}
+
+func (q *keyValueQ) FilterByPrefix(prefix string) dban.KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByKeyPrefix(prefix)
+	return &filtered
+}
+
+func (q *keyValueQ) FilterByValueLike(pattern string) dban.KeyValueQ {
+	filtered := *q
+	filtered.filters = q.filters.ByValueLike(pattern)
+	return &filtered
+}
+
+func (q *keyValueQ) Select() ([]dban.KeyValue, error) {
+	return dban.SelectFiltered(q, q.filters, nil)
+}
+
+func (q *keyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	return dban.SelectFiltered(q, q.filters, &params)
+}
+
+func (q *keyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	return q.SelectPage(dban.KeyValueStreamParams(params))
+}
+
+// scanKeys returns the keys matching the prefix, without the key prefix of the querier
+func (q *keyValueQ) scanKeys(prefix string) ([]string, error) {
+	ctx := context.Background()
+	pattern := globEscaper.Replace(q.keyPrefix+prefix) + "*"
+
+	var keys []string
+	iter := q.client.Scan(ctx, 0, pattern, 0).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, strings.TrimPrefix(iter.Val(), q.keyPrefix))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to scan keys in redis", logan.F{"prefix": prefix})
+	}
+	return keys, nil
+}
+
+func (q *keyValueQ) MustGet(key string) *dban.KeyValue {
+	value, err := dban.GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+func (q *keyValueQ) Upsert(kv dban.KeyValue) error {
+	if err := q.client.Set(context.Background(), q.keyPrefix+kv.Key, kv.Value, 0).Err(); err != nil {
+		return errors.Wrap(err, "failed to set value in redis", logan.F{"key": kv.Key})
+	}
+	return nil
+}
+
+func (q *keyValueQ) UpsertMany(kvs []dban.KeyValue) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+
+	pairs := make([]interface{}, 0, 2*len(kvs))
+	for _, kv := range kvs {
+		pairs = append(pairs, q.keyPrefix+kv.Key, kv.Value)
+	}
+	if err := q.client.MSet(context.Background(), pairs...).Err(); err != nil {
+		return errors.Wrap(err, "failed to set values in redis", logan.F{"count": len(kvs)})
+	}
+	return nil
+}
+
+func (q *keyValueQ) Delete(key string) error {
+	_, err := q.DeleteMany([]string{key})
+	return err
+}
+
+func (q *keyValueQ) DeleteMany(keys []string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = q.keyPrefix + key
+	}
+	deleted, err := q.client.Del(context.Background(), prefixed...).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete values from redis", logan.F{"keys": keys})
+	}
+	return deleted, nil
+}
+
+// Update fails with dban.ErrNoTransaction, as Redis cannot lock a key until the end of a transaction
+func (q *keyValueQ) Update(key string, fn func(current *string) (string, error)) (dban.KeyValue, error) {
+	return dban.KeyValue{}, errors.From(dban.ErrNoTransaction, logan.F{"key": key})
+}
+
+// LockingGet is the same as Get, as Redis cannot lock a key until the end of a transaction
+func (q *keyValueQ) LockingGet(key string) (*dban.KeyValue, error) {
+	return q.Get(key)
+}
+
+func (q *keyValueQ) MustLockingGet(key string) *dban.KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
@@ -0,0 +1,97 @@
+package redisq
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+func newTestKeyValueQ(t *testing.T) (dban.KeyValueQ, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewKeyValueQ(client, "dban:"), server
+}
+
+func TestNewRedisKeyValueQ(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	kvQ := NewRedisKeyValueQ(client, "dban:")
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "foo", Value: "bar"}))
+	stored, err := server.Get("dban:foo")
+	require.NoError(t, err)
+	assert.Equal(t, "bar", stored)
+}
+
+func TestKeyValueQ(t *testing.T) {
+	kvQ, server := newTestKeyValueQ(t)
+
+	kv, err := kvQ.Get("missing")
+	require.NoError(t, err)
+	assert.Nil(t, kv)
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "foo", Value: "bar"}))
+	require.NoError(t, kvQ.New().Upsert(dban.KeyValue{Key: "foo", Value: "baz"}))
+	assert.Equal(t, &dban.KeyValue{Key: "foo", Value: "baz"}, kvQ.MustGet("foo"))
+	assert.Equal(t, &dban.KeyValue{Key: "foo", Value: "baz"}, kvQ.MustLockingGet("foo"))
+
+	stored, err := server.Get("dban:foo")
+	require.NoError(t, err)
+	assert.Equal(t, "baz", stored)
+
+	values, err := kvQ.GetMany([]string{"foo", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]dban.KeyValue{"foo": {Key: "foo", Value: "baz"}}, values)
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "bar", Value: "1"}))
+	deleted, err := kvQ.DeleteMany([]string{"bar", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	assert.False(t, server.Exists("dban:bar"))
+
+	require.NoError(t, kvQ.UpsertMany([]dban.KeyValue{{Key: "cursor:*", Value: "1"}, {Key: "cursor:a", Value: "2"}}))
+	selected, err := kvQ.SelectByPrefix("cursor:*", pgdb.OffsetPageParams{})
+	require.NoError(t, err)
+	assert.Equal(t, []dban.KeyValue{{Key: "cursor:*", Value: "1"}}, selected, "the prefix must be matched literally")
+	keys, err := kvQ.ListKeys("cursor:", pgdb.OffsetPageParams{Order: pgdb.OrderTypeAsc})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cursor:*", "cursor:a"}, keys)
+	count, err := kvQ.Count()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), count)
+
+	server.Close()
+	_, err = kvQ.Get("foo")
+	assert.Error(t, err)
+	assert.Panics(t, func() { kvQ.MustGet("foo") })
+}
+
+func TestStreamerCursor(t *testing.T) {
+	kvQ, server := newTestKeyValueQ(t)
+
+	batchSize := uint64(2)
+	streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3}),
+		KeyValueQ:   kvQ,
+		KeyValueKey: "cursor",
+		BatchSize:   &batchSize,
+	})
+
+	for _, expected := range [][]int{{1, 2}, {3}, {1, 2}} {
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, expected, list)
+	}
+
+	cursor, err := server.Get("dban:cursor")
+	require.NoError(t, err)
+	assert.Equal(t, "1", cursor)
+}
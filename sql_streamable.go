@@ -0,0 +1,78 @@
+package dban
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/Masterminds/squirrel"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// orderByPattern matches ORDER BY anywhere in a query
+var orderByPattern = regexp.MustCompile(`(?i)\border\s+by\b`)
+
+// NewSQLStreamable creates a Streamable selecting the pages of base, the query limited and
+// offset according to the page params, with db.Select, so that the entities are scanned by
+// their `db` struct tags. base must have an ORDER BY making the order of rows stable, e.g.
+// ORDER BY id, as offset pagination of an unordered query skips or repeats rows, and a query
+// without one is rejected with ErrUnorderedQuery. The check only looks for ORDER BY in the
+// query, so the one of a subquery passes it. base must not be limited or offset itself
+func NewSQLStreamable[T any](db *pgdb.DB, base squirrel.SelectBuilder) (Streamable[T], error) {
+	query, _, err := base.ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build base query")
+	}
+	if !orderByPattern.MatchString(query) {
+		return nil, errors.From(ErrUnorderedQuery, logan.F{"query": query})
+	}
+	return &sqlStreamable[T]{db: db, base: base}, nil
+}
+
+// NewRawSQLStreamable creates a Streamable the way NewSQLStreamable does from a raw query
+// with args, appending LIMIT and OFFSET of the page params to it. The query could use either
+// ? or $1 placeholders, as the limit and offset are appended as literals
+func NewRawSQLStreamable[T any](db *pgdb.DB, query string, args ...interface{}) (Streamable[T], error) {
+	if !orderByPattern.MatchString(query) {
+		return nil, errors.From(ErrUnorderedQuery, logan.F{"query": query})
+	}
+	return &rawSQLStreamable[T]{db: db, query: query, args: args}, nil
+}
+
+type sqlStreamable[T any] struct {
+	db   *pgdb.DB
+	base squirrel.SelectBuilder
+}
+
+func (s *sqlStreamable[T]) SelectWithPageParams(pageParams pgdb.OffsetPageParams) ([]T, error) {
+	var entities []T
+	query := s.base.Limit(pageParams.Limit).Offset(pageParams.Limit * pageParams.PageNumber)
+	if err := s.db.Select(&entities, query); err != nil {
+		return nil, errors.Wrap(err, "failed to select page", logan.F{
+			"limit":       pageParams.Limit,
+			"page_number": pageParams.PageNumber,
+		})
+	}
+	return entities, nil
+}
+
+type rawSQLStreamable[T any] struct {
+	db    *pgdb.DB
+	query string
+	args  []interface{}
+}
+
+func (s *rawSQLStreamable[T]) SelectWithPageParams(pageParams pgdb.OffsetPageParams) ([]T, error) {
+	var entities []T
+	query := s.query +
+		" LIMIT " + strconv.FormatUint(pageParams.Limit, 10) +
+		" OFFSET " + strconv.FormatUint(pageParams.Limit*pageParams.PageNumber, 10)
+	if err := s.db.SelectRaw(&entities, query, s.args...); err != nil {
+		return nil, errors.Wrap(err, "failed to select page", logan.F{
+			"limit":       pageParams.Limit,
+			"page_number": pageParams.PageNumber,
+		})
+	}
+	return entities, nil
+}
@@ -0,0 +1,53 @@
+package dban
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+type sqlStreamableRow struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+func TestSQLStreamable(t *testing.T) {
+	t.Run("builder", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		stream, err := NewSQLStreamable[sqlStreamableRow](db, squirrel.Select("id", "name").From("users").
+			Where(squirrel.Eq{"active": true}).OrderBy("id"))
+		require.NoError(t, err)
+
+		mock.ExpectQuery(`SELECT id, name FROM users WHERE active = $1 ORDER BY id LIMIT 2 OFFSET 4`).
+			WithArgs(true).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(5, "e").AddRow(6, "f"))
+		rows, err := stream.SelectWithPageParams(pgdb.OffsetPageParams{Limit: 2, PageNumber: 2})
+		require.NoError(t, err)
+		assert.Equal(t, []sqlStreamableRow{{ID: 5, Name: "e"}, {ID: 6, Name: "f"}}, rows)
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		stream, err := NewRawSQLStreamable[sqlStreamableRow](db, `SELECT id, name FROM users WHERE active = $1 ORDER BY id`, true)
+		require.NoError(t, err)
+
+		mock.ExpectQuery(`SELECT id, name FROM users WHERE active = $1 ORDER BY id LIMIT 2 OFFSET 0`).
+			WithArgs(true).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+		rows, err := stream.SelectWithPageParams(pgdb.OffsetPageParams{Limit: 2})
+		require.NoError(t, err)
+		assert.Empty(t, rows)
+	})
+
+	t.Run("unordered", func(t *testing.T) {
+		db, _ := newMockDB(t)
+		_, err := NewSQLStreamable[sqlStreamableRow](db, squirrel.Select("id", "name").From("users"))
+		assert.True(t, Is(err, ErrUnorderedQuery), "unexpected error: %v", err)
+		_, err = NewRawSQLStreamable[sqlStreamableRow](db, `SELECT id, order_by FROM users`)
+		assert.True(t, Is(err, ErrUnorderedQuery), "unexpected error: %v", err)
+	})
+}
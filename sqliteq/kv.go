@@ -0,0 +1,292 @@
+// Package sqliteq implements dban.KeyValueQ on top of SQLite for embedded and local use
+package sqliteq
+
+import (
+	"database/sql"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/zspkg/dban"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+const (
+	keyValueTable = "key_value"
+
+	keyColumn   = "key"
+	valueColumn = "value"
+)
+
+// Schema is a statement creating the key value table in SQLite
+const Schema = `CREATE TABLE IF NOT EXISTS key_value (
+    key text PRIMARY KEY NOT NULL,
+    value text NOT NULL
+)`
+
+// CreateTable creates the key value table if it does not exist yet
+func CreateTable(db *sql.DB) error {
+	if _, err := db.Exec(Schema); err != nil {
+		return errors.Wrap(err, "failed to create key value table")
+	}
+	return nil
+}
+
+type keyValueQ struct {
+	db      *sql.DB
+	filters dban.KeyValueFilters
+}
+
+// NewKeyValueQ creates a new instance of a key value querier over the SQLite database.
+// The table is expected to be created with CreateTable beforehand.
+//
+// SQLite has no row locks: LockingGet is a plain read, while writes lock the whole
+// database until the end of the transaction. Open the database with _txlock=immediate
+// if several processes share it, so that writers are serialized instead of failing
+// with SQLITE_BUSY
+func NewKeyValueQ(db *sql.DB) dban.KeyValueQ {
+	return &keyValueQ{
+		db: db,
+	}
+}
+
+func (q *keyValueQ) New() dban.KeyValueQ {
+	return NewKeyValueQ(q.db)
+}
+
+func (q *keyValueQ) Upsert(kv dban.KeyValue) error {
+	_, err := squirrel.Insert(keyValueTable).
+		Columns(keyColumn, valueColumn).
+		Values(kv.Key, kv.Value).
+		Suffix("ON CONFLICT (key) DO UPDATE SET value = excluded.value").
+		RunWith(q.db).
+		Exec()
+	if err != nil {
+		return errors.Wrap(err, "failed to upsert value", logan.F{"key": kv.Key})
+	}
+	return nil
+}
+
+// UpsertMany upserts the values with a single statement. SQLite applies the rows in
+// order, so the last value of a repeated key wins
+func (q *keyValueQ) UpsertMany(kvs []dban.KeyValue) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+
+	query := squirrel.Insert(keyValueTable).
+		Columns(keyColumn, valueColumn).
+		Suffix("ON CONFLICT (key) DO UPDATE SET value = excluded.value")
+	for _, kv := range kvs {
+		query = query.Values(kv.Key, kv.Value)
+	}
+	if _, err := query.RunWith(q.db).Exec(); err != nil {
+		return errors.Wrap(err, "failed to upsert values", logan.F{"count": len(kvs)})
+	}
+	return nil
+}
+
+func (q *keyValueQ) Delete(key string) error {
+	_, err := q.DeleteMany([]string{key})
+	return err
+}
+
+func (q *keyValueQ) DeleteMany(keys []string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	result, err := squirrel.Delete(keyValueTable).
+		Where(squirrel.Eq{keyColumn: keys}).
+		RunWith(q.db).
+		Exec()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete values", logan.F{"keys": keys})
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get number of deleted values", logan.F{"keys": keys})
+	}
+	return deleted, nil
+}
+
+// Update fails with dban.ErrNoTransaction, as the querier does not run transactions to lock the database in
+func (q *keyValueQ) Update(key string, fn func(current *string) (string, error)) (dban.KeyValue, error) {
+	return dban.KeyValue{}, errors.From(dban.ErrNoTransaction, logan.F{"key": key})
+}
+
+func (q *keyValueQ) Get(key string) (*dban.KeyValue, error) {
+	var value dban.KeyValue
+	err := squirrel.Select(keyColumn, valueColumn).
+		From(keyValueTable).
+		Where(squirrel.Eq{keyColumn: key}).
+		RunWith(q.db).
+		QueryRow().
+		Scan(&value.Key, &value.Value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get value", logan.F{"key": key})
+	}
+
+	return &value, nil
+}
+
+func (q *keyValueQ) GetMany(keys []string) (map[string]dban.KeyValue, error) {
+	values := make(map[string]dban.KeyValue, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	rows, err := squirrel.Select(keyColumn, valueColumn).
+		From(keyValueTable).
+		Where(squirrel.Eq{keyColumn: keys}).
+		RunWith(q.db).
+		Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get values", logan.F{"keys": keys})
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var value dban.KeyValue
+		if err = rows.Scan(&value.Key, &value.Value); err != nil {
+			return nil, errors.Wrap(err, "failed to scan value", logan.F{"keys": keys})
+		}
+		values[value.Key] = value
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to get values", logan.F{"keys": keys})
+	}
+	return values, nil
+}
+
+// SelectByPrefix compares the beginning of keys instead of using LIKE, as LIKE of SQLite
+// ignores the case
+func (q *keyValueQ) SelectByPrefix(prefix string, params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	query := squirrel.Select(keyColumn, valueColumn).From(keyValueTable)
+	if prefix != "" {
+		query = query.Where("substr(key, 1, length(?)) = ?", prefix, prefix)
+	}
+
+	values, err := q.selectValues(params.ApplyTo(query, keyColumn))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select values by prefix", logan.F{"prefix": prefix})
+	}
+	return values, nil
+}
+
+// selectValues runs the query selecting key values
+func (q *keyValueQ) selectValues(query squirrel.SelectBuilder) ([]dban.KeyValue, error) {
+	rows, err := query.RunWith(q.db).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []dban.KeyValue{}
+	for rows.Next() {
+		var value dban.KeyValue
+		if err = rows.Scan(&value.Key, &value.Value); err != nil {
+			return nil, errors.Wrap(err, "failed to scan value")
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// ListKeys compares the beginning of keys the way SelectByPrefix does
+func (q *keyValueQ) ListKeys(prefix string, params pgdb.OffsetPageParams) ([]string, error) {
+	query := squirrel.Select(keyColumn).From(keyValueTable)
+	if prefix != "" {
+		query = query.Where("substr(key, 1, length(?)) = ?", prefix, prefix)
+	}
+
+	rows, err := params.ApplyTo(query, keyColumn).RunWith(q.db).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list keys", logan.F{"prefix": prefix})
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return nil, errors.Wrap(err, "failed to scan key", logan.F{"prefix": prefix})
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to list keys", logan.F{"prefix": prefix})
+	}
+	return keys, nil
+}
+
+func (q *keyValueQ) Count() (uint64, error) {
+	var count uint64
+	err := squirrel.Select("count(*)").From(keyValueTable).RunWith(q.db).QueryRow().Scan(&count)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count values")
+	}
+	return count, nil
+}
+
+func (q *keyValueQ) FilterByKeys(keys ...string) dban.KeyValueQ {
+	return &keyValueQ{db: q.db, filters: q.filters.ByKeys(keys...)}
+}
+
+func (q *keyValueQ) FilterByPrefix(prefix string) dban.KeyValueQ {
+	return &keyValueQ{db: q.db, filters: q.filters.ByKeyPrefix(prefix)}
+}
+
+// FilterByValueLike matches values with LIKE of SQLite, which ignores the case of ASCII letters
+func (q *keyValueQ) FilterByValueLike(pattern string) dban.KeyValueQ {
+	return &keyValueQ{db: q.db, filters: q.filters.ByValueLike(pattern)}
+}
+
+func (q *keyValueQ) Select() ([]dban.KeyValue, error) {
+	values, err := q.selectValues(q.filtered().OrderBy(keyColumn))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select filtered values")
+	}
+	return values, nil
+}
+
+func (q *keyValueQ) SelectPage(params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	values, err := q.selectValues(params.ApplyTo(q.filtered(), keyColumn))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select filtered values")
+	}
+	return values, nil
+}
+
+func (q *keyValueQ) SelectWithPageParams(params pgdb.OffsetPageParams) ([]dban.KeyValue, error) {
+	return q.SelectPage(dban.KeyValueStreamParams(params))
+}
+
+// filtered returns the query selecting the values passing the filters
+func (q *keyValueQ) filtered() squirrel.SelectBuilder {
+	return q.filters.ApplyTo(squirrel.Select(keyColumn, valueColumn).From(keyValueTable), keyColumn, valueColumn+` LIKE ? ESCAPE '\'`)
+}
+
+func (q *keyValueQ) MustGet(key string) *dban.KeyValue {
+	value, err := dban.GetStrict(q, key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get value by key", logan.F{"key": key}))
+	}
+	return &value
+}
+
+// LockingGet is the same as Get, as SQLite locks the whole database on write instead of rows
+func (q *keyValueQ) LockingGet(key string) (*dban.KeyValue, error) {
+	return q.Get(key)
+}
+
+func (q *keyValueQ) MustLockingGet(key string) *dban.KeyValue {
+	value, err := q.LockingGet(key)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to locking get value by key", logan.F{"key": key}))
+	}
+	return value
+}
@@ -0,0 +1,208 @@
+package sqliteq
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+func newTestKeyValueQ(t *testing.T) dban.KeyValueQ {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	// every connection to :memory: opens a database of its own
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	require.NoError(t, CreateTable(db))
+	require.NoError(t, CreateTable(db), "bootstrap must be idempotent")
+	return NewKeyValueQ(db)
+}
+
+func TestKeyValueQ(t *testing.T) {
+	kvQ := newTestKeyValueQ(t)
+
+	kv, err := kvQ.Get("missing")
+	require.NoError(t, err)
+	assert.Nil(t, kv)
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "foo", Value: "bar"}))
+	require.NoError(t, kvQ.New().Upsert(dban.KeyValue{Key: "foo", Value: "baz"}))
+	assert.Equal(t, &dban.KeyValue{Key: "foo", Value: "baz"}, kvQ.MustGet("foo"))
+	assert.Equal(t, &dban.KeyValue{Key: "foo", Value: "baz"}, kvQ.MustLockingGet("foo"))
+
+	values, err := kvQ.GetMany([]string{"foo", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]dban.KeyValue{"foo": {Key: "foo", Value: "baz"}}, values)
+}
+
+func TestKeyValueQMigrated(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	// the embedded migrations create the table the querier works with
+	migrator, err := dban.NewKVMigratorWithDialect(db, "sqlite3", nil)
+	require.NoError(t, err)
+	require.NoError(t, migrator.MigrateUp())
+
+	kvQ := NewKeyValueQ(db)
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "foo", Value: "bar"}))
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "foo", Value: "baz"}))
+	assert.Equal(t, &dban.KeyValue{Key: "foo", Value: "baz"}, kvQ.MustGet("foo"))
+
+	require.NoError(t, kvQ.Delete("foo"))
+	require.NoError(t, migrator.MigrateDown())
+	_, err = kvQ.Get("foo")
+	assert.Error(t, err)
+}
+
+func TestKeyValueQSelectByPrefix(t *testing.T) {
+	kvQ := newTestKeyValueQ(t)
+	require.NoError(t, kvQ.UpsertMany([]dban.KeyValue{
+		{Key: "streamer:orders", Value: "1"},
+		{Key: "streamer:invoices", Value: "2"},
+		{Key: "Streamer:upper", Value: "3"},
+		{Key: "other", Value: "4"},
+	}))
+
+	values, err := kvQ.SelectByPrefix("streamer:", pgdb.OffsetPageParams{Order: pgdb.OrderTypeAsc})
+	require.NoError(t, err)
+	assert.Equal(t, []dban.KeyValue{
+		{Key: "streamer:invoices", Value: "2"},
+		{Key: "streamer:orders", Value: "1"},
+	}, values)
+
+	values, err = kvQ.SelectByPrefix("", pgdb.OffsetPageParams{Limit: 1, PageNumber: 1, Order: pgdb.OrderTypeAsc})
+	require.NoError(t, err)
+	assert.Equal(t, []dban.KeyValue{{Key: "other", Value: "4"}}, values)
+
+	keys, err := kvQ.ListKeys("streamer:", pgdb.OffsetPageParams{Order: pgdb.OrderTypeAsc})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"streamer:invoices", "streamer:orders"}, keys)
+	count, err := kvQ.Count()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), count)
+}
+
+func TestKeyValueQFilters(t *testing.T) {
+	kvQ := newTestKeyValueQ(t)
+	require.NoError(t, kvQ.UpsertMany([]dban.KeyValue{
+		{Key: "a", Value: "100%"},
+		{Key: "b", Value: "1000"},
+		{Key: "c", Value: "200%"},
+	}))
+
+	values, err := kvQ.FilterByValueLike(`%\%`).Select()
+	require.NoError(t, err)
+	assert.Equal(t, []dban.KeyValue{{Key: "a", Value: "100%"}, {Key: "c", Value: "200%"}}, values)
+
+	values, err = kvQ.FilterByKeys("a", "b").FilterByValueLike("1%").SelectPage(pgdb.OffsetPageParams{Limit: 1, PageNumber: 1, Order: pgdb.OrderTypeAsc})
+	require.NoError(t, err)
+	assert.Equal(t, []dban.KeyValue{{Key: "b", Value: "1000"}}, values)
+}
+
+func TestKeyValueQUpsertMany(t *testing.T) {
+	kvQ := newTestKeyValueQ(t)
+
+	require.NoError(t, kvQ.UpsertMany(nil))
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "existing", Value: "0"}))
+	require.NoError(t, kvQ.UpsertMany([]dban.KeyValue{
+		{Key: "existing", Value: "1"},
+		{Key: "new", Value: "1"},
+		{Key: "new", Value: "2"},
+	}))
+	assert.Equal(t, "1", kvQ.MustGet("existing").Value)
+	assert.Equal(t, "2", kvQ.MustGet("new").Value)
+}
+
+func TestKeyValueQUpdate(t *testing.T) {
+	kvQ := newTestKeyValueQ(t)
+
+	_, err := kvQ.Update("doc", func(*string) (string, error) {
+		t.Fatal("fn must not be called without a transaction")
+		return "", nil
+	})
+	assert.True(t, dban.Is(err, dban.ErrNoTransaction))
+}
+
+func TestKeyValueQDelete(t *testing.T) {
+	kvQ := newTestKeyValueQ(t)
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "foo", Value: "bar"}))
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "baz", Value: "qux"}))
+	require.NoError(t, kvQ.Delete("foo"))
+	require.NoError(t, kvQ.Delete("foo"), "deleting a missing key is not an error")
+	_, err := dban.GetStrict(kvQ, "foo")
+	assert.True(t, dban.Is(err, dban.ErrNoSuchKey))
+
+	deleted, err := kvQ.DeleteMany([]string{"baz", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	_, err = dban.GetStrict(kvQ, "baz")
+	assert.True(t, dban.Is(err, dban.ErrNoSuchKey))
+
+	deleted, err = kvQ.DeleteMany(nil)
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+}
+
+func TestStreamerCursor(t *testing.T) {
+	kvQ := newTestKeyValueQ(t)
+
+	batchSize := uint64(2)
+	streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3}),
+		KeyValueQ:   kvQ,
+		KeyValueKey: "cursor",
+		BatchSize:   &batchSize,
+	})
+
+	for _, expected := range [][]int{{1, 2}, {3}, {1, 2}} {
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, expected, list)
+	}
+	assert.Equal(t, &dban.KeyValue{Key: "cursor", Value: "1"}, kvQ.MustGet("cursor"))
+}
+
+// TestStreamKeyValueTable rewrites the values of a namespace with a streamer walking the
+// key value table its cursor is stored in
+func TestStreamKeyValueTable(t *testing.T) {
+	kvQ := newTestKeyValueQ(t)
+	require.NoError(t, kvQ.UpsertMany([]dban.KeyValue{
+		{Key: "jobs:1", Value: "a"},
+		{Key: "jobs:2", Value: "b"},
+		{Key: "jobs:3", Value: "c"},
+		{Key: "other", Value: "d"},
+	}))
+
+	batchSize := uint64(2)
+	streamer := dban.NewStreamer(dban.StreamerInitParams[dban.KeyValue]{
+		Stream:      kvQ.FilterByPrefix("jobs:"),
+		KeyValueQ:   kvQ,
+		KeyValueKey: "rewrite:cursor",
+		BatchSize:   &batchSize,
+	})
+	report, err := streamer.ProcessAll(func(_ context.Context, kv dban.KeyValue) error {
+		return kvQ.Upsert(dban.KeyValue{Key: kv.Key, Value: strings.ToUpper(kv.Value)})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), report.Entities)
+
+	values, err := kvQ.FilterByPrefix("jobs:").Select()
+	require.NoError(t, err)
+	assert.Equal(t, []dban.KeyValue{
+		{Key: "jobs:1", Value: "A"},
+		{Key: "jobs:2", Value: "B"},
+		{Key: "jobs:3", Value: "C"},
+	}, values)
+	assert.Equal(t, "d", kvQ.MustGet("other").Value, "values out of the namespace must not be streamed")
+}
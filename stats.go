@@ -0,0 +1,175 @@
+package dban
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Key value counters are kept in an expvar map all the time, so that PublishExpvar could
+// expose them whenever it is called. Updating them costs an atomic addition per operation
+var (
+	kvVars = new(expvar.Map).Init()
+	varsMu sync.Mutex
+)
+
+// Names of the key value counters
+const (
+	kvVarGet        = "get"
+	kvVarLockingGet = "locking_get"
+	kvVarUpsert     = "upsert"
+	kvVarDelete     = "delete"
+	kvVarErrors     = "errors"
+	kvVarRetries    = "retries"
+)
+
+// streamerCounters are the counters of a streamer (see StreamerStats), shared by its copies
+// and updated atomically
+type streamerCounters struct {
+	batches           int64
+	processed         int64
+	failed            int64
+	resets            int64
+	page              int64
+	skippedRuns       int64
+	errors            int64
+	lastBatchAt       int64 // Unix nanoseconds
+	lastBatchDuration int64
+	filtered          int64
+	total             int64
+	position          int64
+	batchSize         int64
+}
+
+// StreamerStats are counters of a streamer since it was created
+type StreamerStats struct {
+	// Batches is the number of pages taken for processing
+	Batches int64 `json:"batches"`
+	// Processed is the number of entities processed successfully
+	Processed int64 `json:"processed"`
+	// Failed is the number of entities failed to be processed
+	Failed int64 `json:"failed"`
+	// Resets is the number of times the cursor was moved back to the first page
+	Resets int64 `json:"resets"`
+	// Page is the last page taken for processing
+	Page int64 `json:"page"`
+	// SkippedRuns is the number of cron activations skipped as the previous run was in flight
+	SkippedRuns int64 `json:"skipped_runs"`
+	// Errors is the number of lists that failed to be formed or processed
+	Errors int64 `json:"errors"`
+	// LastBatchAt is the time the last page was taken for processing, zero if none was
+	LastBatchAt time.Time `json:"last_batch_at"`
+	// LastBatchDuration is the time the last page took to be processed
+	LastBatchDuration time.Duration `json:"last_batch_duration"`
+	// Filtered is the number of entities skipped as Filter did not accept them
+	Filtered int64 `json:"filtered"`
+	// Total is the number of entities of a Countable stream as last counted, zero if it is
+	// unknown
+	Total int64 `json:"total"`
+	// Position is the number of entities of a Countable stream up to the end of the last
+	// list processed by ProcessAll or Run
+	Position int64 `json:"position"`
+	// BatchSize is the number of entities selected per page, the current one adjusted by
+	// AdaptiveBatch
+	BatchSize int64 `json:"batch_size"`
+}
+
+// Percent returns the share of the entities of a Countable stream up to Position in
+// percents, reporting false if the total is unknown. It does not exceed 100 even if the
+// stream shrank since it was counted
+func (s StreamerStats) Percent() (float64, bool) {
+	if s.Total <= 0 {
+		return 0, false
+	}
+	if s.Position >= s.Total {
+		return 100, true
+	}
+	return float64(s.Position) * 100 / float64(s.Total), true
+}
+
+// StatsReporter is a streamer whose counters PublishExpvar exposes. Streamers created with
+// NewStreamer implement it
+type StatsReporter interface {
+	Name() string
+	GetStats() StreamerStats
+}
+
+// PublishExpvar publishes the key value operation counters as the expvar map prefix+".kv"
+// and counters of the streamers (see StreamerStats) as entries of the map prefix+".streamers"
+// keyed by their names, read with GetStats whenever the map is. Calling it again with the
+// same prefix and streamer does nothing, while another streamer of the same name replaces
+// the one published before
+func PublishExpvar(prefix string, streamers ...StatsReporter) {
+	varsMu.Lock()
+	defer varsMu.Unlock()
+
+	if expvar.Get(prefix+".kv") == nil {
+		expvar.Publish(prefix+".kv", kvVars)
+	}
+	published, ok := expvar.Get(prefix + ".streamers").(*expvar.Map)
+	if !ok {
+		published = new(expvar.Map).Init()
+		expvar.Publish(prefix+".streamers", published)
+	}
+	for _, streamer := range streamers {
+		streamer := streamer
+		published.Set(streamer.Name(), expvar.Func(func() any { return streamer.GetStats() }))
+	}
+}
+
+// countKV counts a key value operation and its failure
+func countKV(operation string, err error) {
+	kvVars.Add(operation, 1)
+	if err != nil {
+		kvVars.Add(kvVarErrors, 1)
+	}
+}
+
+func (s *streamer[T]) GetStats() StreamerStats {
+	c := s.stats
+	stats := StreamerStats{
+		Batches:           atomic.LoadInt64(&c.batches),
+		Processed:         atomic.LoadInt64(&c.processed),
+		Failed:            atomic.LoadInt64(&c.failed),
+		Resets:            atomic.LoadInt64(&c.resets),
+		Page:              atomic.LoadInt64(&c.page),
+		SkippedRuns:       atomic.LoadInt64(&c.skippedRuns),
+		Errors:            atomic.LoadInt64(&c.errors),
+		LastBatchDuration: time.Duration(atomic.LoadInt64(&c.lastBatchDuration)),
+		Filtered:          atomic.LoadInt64(&c.filtered),
+		Total:             atomic.LoadInt64(&c.total),
+		Position:          atomic.LoadInt64(&c.position),
+		BatchSize:         atomic.LoadInt64(&c.batchSize),
+	}
+	if nanos := atomic.LoadInt64(&c.lastBatchAt); nanos != 0 {
+		stats.LastBatchAt = time.Unix(0, nanos)
+	}
+	return stats
+}
+
+// record updates the counters of the streamer with the event
+func (s *streamer[T]) record(event Event) {
+	switch event := event.(type) {
+	case BatchStarted:
+		atomic.AddInt64(&s.stats.batches, 1)
+		atomic.StoreInt64(&s.stats.page, int64(event.Page))
+		atomic.StoreInt64(&s.stats.lastBatchAt, s.Clock.Now().UnixNano())
+	case BatchCompleted:
+		atomic.AddInt64(&s.stats.processed, int64(event.Processed))
+		atomic.AddInt64(&s.stats.failed, int64(event.Failed))
+		atomic.AddInt64(&s.stats.filtered, int64(event.Filtered))
+		atomic.StoreInt64(&s.stats.lastBatchDuration, int64(event.Duration))
+	case CursorReset:
+		atomic.AddInt64(&s.stats.resets, 1)
+	}
+}
+
+// failed counts the failure of a list, if any, and returns it. A stop requested with
+// ErrStopStreaming is not a failure
+func (s *streamer[T]) failed(err error) error {
+	if err != nil && !stopped(err) && !Is(err, ErrInterrupted) {
+		atomic.AddInt64(&s.stats.errors, 1)
+	}
+	return err
+}
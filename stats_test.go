@@ -0,0 +1,65 @@
+package dban
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishExpvar(t *testing.T) {
+	PublishExpvar("dban_test")
+	require.NotPanics(t, func() { PublishExpvar("dban_test") })
+
+	kv := expvar.Get("dban_test.kv").(*expvar.Map)
+	counter := func(name string) int64 {
+		if v, ok := kv.Get(name).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	gets, upserts, failures := counter(kvVarGet), counter(kvVarUpsert), counter(kvVarErrors)
+
+	db, mock := newMockDB(t)
+	kvQ := NewKeyValueQ(withMockTx(db))
+	batchSize := uint64(2)
+	streamer := NewStreamer[uint64](StreamerInitParams[uint64]{
+		Stream:          rangeStreamable{size: 3},
+		KeyValueQ:       kvQ,
+		KeyValueKey:     "expvar-cursor",
+		BatchSize:       &batchSize,
+		SkipLastRunInfo: true,
+	})
+
+	PublishExpvar("dban_test", streamer)
+	require.NotPanics(t, func() { PublishExpvar("dban_test", streamer) })
+
+	mock.ExpectQuery(getSQL).WithArgs("expvar-cursor:paused").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(getForUpdateSQL).WithArgs("expvar-cursor").WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("INSERT INTO key_value (key,value) VALUES ($1,$2) ON CONFLICT (key) DO NOTHING").
+		WithArgs("expvar-cursor", "0").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(getSQL).WithArgs("expvar-cursor:batch_size").WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec(upsertSQL).WithArgs("expvar-cursor:batch_size", "2").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(upsertSQL).WithArgs("expvar-cursor", "1").WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, streamer.FormListAndProcess(func(context.Context, uint64) error { return nil }))
+
+	mock.ExpectQuery(getSQL).WithArgs("broken").WillReturnError(sql.ErrConnDone)
+	_, err := kvQ.Get("broken")
+	require.Error(t, err)
+
+	assert.Equal(t, gets+3, counter(kvVarGet))
+	assert.Equal(t, upserts+3, counter(kvVarUpsert))
+	assert.Equal(t, failures+1, counter(kvVarErrors))
+
+	published := expvar.Get("dban_test.streamers").(*expvar.Map).Get("expvar-cursor").(expvar.Func)
+	got := published.Value().(StreamerStats)
+	assert.Equal(t, streamer.GetStats(), got)
+	assert.False(t, got.LastBatchAt.IsZero())
+	got.LastBatchAt, got.LastBatchDuration = time.Time{}, 0
+	assert.Equal(t, StreamerStats{Batches: 1, Processed: 2, BatchSize: 2}, got)
+}
@@ -5,7 +5,13 @@ import (
 	"gitlab.com/distributed_lab/kit/pgdb"
 	"gitlab.com/distributed_lab/logan/v3"
 	"gitlab.com/distributed_lab/logan/v3/errors"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+	"math"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 const defaultBatchSize uint64 = 15
@@ -19,35 +25,370 @@ type Streamable[T any] interface {
 // Streamer is an interface implementing functions that allow to stream through the data
 type Streamer[T any] interface {
 	// Select returns a batch of entities of a size specified in StreamerInitParams and
-	// with a page offset specified in function arguments
+	// with a page offset specified in function arguments, retrying it with SelectRetry
 	Select(pageNumber uint64) ([]T, error)
 	// FormListAndProcess forms a list according to a FormList function and applies a function
-	// specified as an argument
+	// specified as an argument. It fails with StreamError telling the stage that failed. Once Ctx
+	// is done, the entity in flight is finished, but neither further queries are made nor
+	// further entities are processed: the progress is persisted regardless of Ctx, moving the
+	// cursor back to the page of the entity interrupted at, or storing the offset within the
+	// held page with CheckpointEveryNEntities, and the StreamError is wrapped with
+	// ErrInterrupted along with the error of Ctx, e.g. context.Canceled. An entity fn returns
+	// ErrStopStreaming for makes it return nil, leaving the page of the entity to be formed
+	// again, as ProcessAll, Run and FormListAndProcessBatch do
 	FormListAndProcess(fn func(ctx context.Context, t T) error) error
+	// FormListAndProcessReport does the same thing as FormListAndProcess, but reports the
+	// outcome of every entity processed, so that the callers could act on a partial success.
+	// The report is returned along with the error, which is nil once every entity is
+	// processed. The entities following the failure stopping the list are not processed, so
+	// they are not reported, as well as the ones skipped by Filter or DedupWindow. With
+	// MaxConcurrency the entities are reported in the order they were done with
+	FormListAndProcessReport(fn func(ctx context.Context, t T) error) (BatchReport[T], error)
+	// FormAndProcessRows does the same thing as FormListAndProcess, but selects the page from
+	// RowStream and processes its entities one at a time as they are scanned
+	FormAndProcessRows(fn func(ctx context.Context, t T) error) error
 	// FormList returns a batch of entities and turns to the next available page (or sets it to 1 if
-	// an end of a list was reached)
+	// an end of a list was reached). With a TransactionalKeyValueQ the cursor is read and updated
+	// in one transaction, so concurrent calls get distinct pages. If some of the pages reserved
+	// with PageConcurrency fail, the entities preceding them are returned with UnprocessedPagesError
 	FormList() ([]T, error)
 	// GetCurrentPage returns a page we are at while streaming through data
 	GetCurrentPage() (uint64, error)
+	// GetStats returns counters of the streamer since it was created (see PublishExpvar)
+	GetStats() StreamerStats
+	// ProcessAll forms and processes lists until the end of the stream is reached, leaving
+	// the cursor at the end instead of moving it back to the first page, so that the next
+	// call processes only the entities added since. The last page is left to the next call
+	// if it is not full, so its entities are processed again (see DedupWindow). It stops
+	// once Ctx is done, failing with ErrInterrupted, and on the first failure, returning it
+	// as StreamError
+	ProcessAll(fn func(ctx context.Context, t T) error) (ProcessReport, error)
+	// Run forms and processes lists continuously until ctx is canceled, waiting for new
+	// entities once the end of the stream is reached (see RunConfig). Ctx done in the middle
+	// of a list stops it the way ctx does, returning nil once the progress is persisted
+	Run(ctx context.Context, fn func(ctx context.Context, t T) error, cfg RunConfig) error
+	// FormListAndProcessTx does the same thing as FormListAndProcess, but reads the cursor,
+	// processes the list and moves the cursor past it in one transaction, so that the
+	// processing function could make its writes atomically with the cursor update (see
+	// KeyValueQFromContext). It requires a TransactionalKeyValueQ
+	FormListAndProcessTx(fn func(ctx context.Context, q KeyValueQ, t T) error) error
+	// FormListAndProcessBatch does the same thing as FormListAndProcess, but passes the whole
+	// list to fn at once, e.g. for a bulk insert. An empty list is not passed. EntityErrorPolicy
+	// and MaxConcurrency do not apply to it, and its failure is a StreamError of the page
+	FormListAndProcessBatch(fn func(ctx context.Context, batch []T) error) error
+	// Stream sends entities on the first channel page by page until the end of the stream is
+	// reached or ctx is canceled, sending a failure, if any, on the second one. Both channels
+	// are closed then. The cursor is moved past a page once all of its entities are received
+	Stream(ctx context.Context) (<-chan T, <-chan error)
+	// Reset moves the cursor back to the first page, so that the stream is processed again. A
+	// Descending streamer starts over from StartPage or the last page instead
+	Reset() error
+	// SetPage moves the cursor to the page, counted with the batch size of the streamer. Pages
+	// overflowing int64 are rejected. A Descending streamer takes the page next
+	SetPage(page uint64) error
+	// SkipToEnd moves the cursor to the last page of a Countable stream, so that only the
+	// entities added later are processed. It fails with ErrNotCountable otherwise
+	SkipToEnd() error
+	// Flush writes the cursor kept in memory between checkpoints, if any (see
+	// CheckpointEveryNBatches)
+	Flush() error
+	// RunCron forms and processes lists on the cron spec until ctx is canceled (see ParseCron).
+	// An invalid spec is rejected before the first activation
+	RunCron(ctx context.Context, spec string, fn func(ctx context.Context, t T) error) error
+	// Pause flags the streamer paused in the key value storage under the cursor key with the
+	// ":paused" suffix, so that every instance sharing the cursor stops processing once the
+	// list in flight is done: FormListAndProcess and the rest of the processing methods fail
+	// with ErrStreamerPaused, while Run waits for EmptyPollInterval and checks the flag again.
+	// The flag is read once per list
+	Pause() error
+	// Resume removes the flag set by Pause
+	Resume() error
+	// IsPaused tells whether the streamer is flagged paused
+	IsPaused() (bool, error)
+	// BreakerState tells the state of the circuit breaker shared by the instances of the
+	// streamer, which is always CircuitClosed without CircuitBreaker
+	BreakerState() (CircuitState, error)
+	// ResetBreaker closes the circuit breaker, clearing the count of the lists failed in a row
+	ResetBreaker() error
+	// GetLastRunInfo returns the LastRunInfo of the last list processed successfully, which
+	// is nil if there is none or it could not be parsed, so that dashboards degrade gracefully
+	GetLastRunInfo() (*LastRunInfo, error)
+	// ResetRunCounters resets the count of the processed entities of LastRunInfo
+	ResetRunCounters() error
+	// HealthReporter reports the cursor key as a name and the time a list was last formed
+	HealthReporter
+	// streamerIter adds All with Go 1.23 and later
+	streamerIter[T]
 }
 
+// CorruptCursorPolicy defines what the streamer does when its stored cursor is corrupt
+type CorruptCursorPolicy int
+
+const (
+	// CorruptCursorFail makes the streamer fail with ErrCorruptCursor until the cursor is fixed
+	CorruptCursorFail CorruptCursorPolicy = iota
+	// CorruptCursorResetToZero makes the streamer log the corrupt value and restart from the first page
+	CorruptCursorResetToZero
+	// CorruptCursorCallback makes the streamer continue from the page returned by
+	// StreamerInitParams.OnCorruptCursor
+	CorruptCursorCallback
+)
+
+// CursorMode defines how the streamer reads and advances its cursor
+type CursorMode int
+
+const (
+	// CursorLockAndUpdate makes the streamer read the cursor with LockingGet and update it
+	// once the page is selected
+	CursorLockAndUpdate CursorMode = iota
+	// CursorAdvanceFirst makes the streamer advance the cursor with a single AdvanceCursor
+	// call before selecting the page, so it requires a CursorAdvancer. A page that fails to be
+	// selected is skipped, i.e. pages are delivered at most once. Batch size changes are not
+	// detected in this mode
+	CursorAdvanceFirst
+)
+
+// CorruptCursorHandler decides which page to continue from given the raw corrupt cursor
+type CorruptCursorHandler func(raw string) (uint64, error)
+
 // StreamerInitParams are parameters specified when initializing a new streamer
 type StreamerInitParams[T any] struct {
-	Stream      Streamable[T]
-	KeyValueQ   KeyValueQ
-	KeyValueKey string
-	BatchSize   *uint64
-	Log         *logan.Entry
-	Ctx         *context.Context
+	Stream              Streamable[T]
+	RowStream           RowStreamable[T]
+	KeyValueQ           KeyValueQ
+	KeyValueKey         string
+	BatchSize           *uint64
+	Log                 *logan.Entry
+	Ctx                 *context.Context
+	CorruptCursorPolicy *CorruptCursorPolicy
+	OnCorruptCursor     CorruptCursorHandler
+	BatchSizePolicy     *BatchSizePolicy
+	EventSink           EventSink
+	CursorMode          *CursorMode
+	PageConcurrency     *uint64
+	DedupWindow         int
+	DedupID             func(T) string
+	CursorWriteBuffer   *CursorWriteBuffer
+	Preparation         Preparation
+	Clock               Clock
+	Tracer              trace.Tracer
+	EntityErrorPolicy   *EntityErrorPolicy
+	SkippableError      SkippableErrorFunc
+	MaxConcurrency      *uint64
+	StreamBufferSize    int
+	EntityRateLimit     *rate.Limit
+	EntityRateLimiter   *rate.Limiter
+	BatchInterval       *time.Duration
+	SelectRetry         *RetryConfig
+	OnDeadLetter        DeadLetterFunc[T]
+	EntityMaxAttempts   uint
+	WrapAround          *bool
+	// CheckpointEveryNBatches makes the streamer write the cursor once every that many
+	// batches (see NewStreamer)
+	CheckpointEveryNBatches *uint64
+	// CursorCacheSync makes the streamer keep the cursor in memory, reading it once and
+	// writing it once per that interval (see NewStreamer)
+	CursorCacheSync *time.Duration
+	// CheckpointEveryNEntities makes FormListAndProcess store the progress within the page
+	// once every that many entities (see NewStreamer)
+	CheckpointEveryNEntities *uint64
+	// AdvanceAfterProcessing makes FormListAndProcess move the cursor past a list only once
+	// all of its entities are processed (see NewStreamer)
+	AdvanceAfterProcessing bool
+	// Cooperative makes instances of the streamer sharing the cursor take distinct pages
+	// (see NewStreamer)
+	Cooperative bool
+	// OnBatchStart, OnBatchEnd and OnEmpty are called at the boundaries of batches (see
+	// NewStreamer)
+	OnBatchStart BatchStartHook
+	OnBatchEnd   BatchEndHook
+	OnEmpty      EmptyPageHook
+	// Filter makes the streamer process only the entities it accepts (see NewStreamer)
+	Filter func(t T) bool
+	// CountInterval is the interval the entities of a Countable Stream are counted again
+	// with (see NewStreamer)
+	CountInterval *time.Duration
+	// Direction is the order the streamer takes pages in, Ascending by default (see
+	// NewStreamer)
+	Direction *StreamDirection
+	// StartPage is the page a Descending streamer starts at instead of the last one
+	StartPage *uint64
+	// PartitionedStream is the source of the entities split into partitions, used by
+	// NewPartitionedStreamer instead of Stream
+	PartitionedStream PartitionedStreamable[T]
+	// RecoverPanics makes the streamer convert panics of the processing functions into
+	// PanicError, true by default (see NewStreamer)
+	RecoverPanics *bool
+	// AdaptiveBatch makes the streamer adjust the batch size to the time batches take,
+	// starting with BatchSize (see NewStreamer)
+	AdaptiveBatch *AdaptiveBatchConfig
+	// Sorts are passed to a Stream implementing SortableStreamable to select pages sorted by
+	// (see NewStreamer)
+	Sorts pgdb.Sorts
+	// CircuitBreaker makes the streamer stop forming lists for CoolDown once Threshold of
+	// them failed in a row (see NewStreamer)
+	CircuitBreaker *CircuitBreakerConfig
+	// Fingerprint makes the streamer store a StreamerFingerprint under the cursor key with the
+	// ":meta" suffix on the first use of the cursor and compare it on the first use by every
+	// streamer created later, so that a streamer of other entities given the same key by
+	// mistake (see DeriveStreamerKey) fails with KeyCollisionError, matching ErrKeyCollision,
+	// before touching the cursor, as NewStreamerChecked does right away. Streamers without
+	// Fingerprint neither store nor check it
+	Fingerprint bool
+	// ForceAdopt makes a streamer with Fingerprint take over a cursor recorded with another
+	// fingerprint, once, instead of failing, e.g. as the batch size is a part of the
+	// fingerprint and changing it requires ForceAdopt. BatchSizePolicy applies as usual
+	// afterwards
+	ForceAdopt bool
+	// SkipLastRunInfo makes the streamer not store LastRunInfo (see NewStreamer)
+	SkipLastRunInfo bool
 }
 
 // NewStreamer creates a new instance of Streamer using StreamerInitParams. All
-// values are necessary except for a Log, BatchSize, and Ctx which could be omitted
-// (in that case, Log wouldn't log anything, BatchSize would be set to 15 and Ctx to context.Background())
+// values are necessary except for a Log, BatchSize, Ctx, CorruptCursorPolicy and BatchSizePolicy which
+// could be omitted (in that case, Log wouldn't log anything, BatchSize would be set to 15, Ctx to
+// context.Background(), CorruptCursorPolicy to CorruptCursorFail and BatchSizePolicy to
+// BatchSizeTranslate). OnCorruptCursor is required by CorruptCursorCallback only. An invalid
+// KeyValueKey (see ValidateKey) makes every method of the streamer fail with ErrEmptyKey or
+// ErrKeyTooLong, both matching ErrInvalidKey, the same way missing Stream (unless there is a
+// RowStream) and KeyValueQ or a zero BatchSize do (see NewStreamerChecked).
+// Events are emitted to EventSink the same way WithEventSink does for the querier.
+// CursorMode is CursorLockAndUpdate by default. RowStream is required by FormAndProcessRows only.
+// PageConcurrency above 1 makes the streamer reserve that many pages at once and select them in
+// parallel (see UnprocessedPagesError), which requires a querier implementing CursorAdvancer.
+// DedupWindow makes the streamer remember the IDs (see DedupID, required along with it) of that
+// many last delivered entities and drop them if they are delivered again, e.g. because rows were
+// inserted before the cursor. Entities skipped because rows were deleted are not recovered.
+// CursorWriteBuffer is supported by CursorLockAndUpdate only. Preparation is called before the
+// first page of every pass, e.g. to refresh a materialized view (see MaterializedViewRefresh).
+// Clock is the system one by default. Queries of a querier implementing KeyValueQCtx are run
+// with Ctx, so that cancelling it aborts them, except for deferred cursor writes. Tracer makes
+// FormListAndProcess open a span per batch with a child span per entity, passing the context of
+// the latter to the processing function; queries of the batch are made within its span.
+// AdvanceAfterProcessing makes FormListAndProcess, ProcessAll and Run deliver entities at least
+// once instead of at most once: the cursor is read and kept locked (with a TransactionalKeyValueQ)
+// while the list is processed, and is moved past it only if every entity is processed, so a
+// failed list is formed again by the next call. It requires CursorLockAndUpdate, while FormList
+// and FormAndProcessRows move the cursor before returning entities anyway.
+// EntityErrorPolicy is EntityErrorFailFast by default. The list is considered processed
+// with the other policies even if some of its entities failed, so the cursor is moved past
+// it with AdvanceAfterProcessing as well, unless SkippableError refuses to skip one of them.
+// MaxConcurrency above 1 makes FormListAndProcess process that many entities of a list at
+// once, in no particular order (see processListConcurrently); the cursor is moved once all
+// of them are done with. StreamBufferSize is the buffer of the channel of Stream, which is
+// unbuffered by default. EntityRateLimit limits the number of entities processed per second
+// (one at a time), while EntityRateLimiter, taking precedence over it, could be shared by
+// streamers calling the same API; FormListAndProcessBatch is not limited by them.
+// BatchInterval is the minimum time between pages taken by the streamer and its copies.
+// Waiting for either of them is interrupted once Ctx is done. SelectRetry makes the streamer
+// retry selecting a page failed with a transient error (see RetryConfig.IsTransient), logging
+// every retry; the cursor stays locked meanwhile. Reads and writes of the cursor are not
+// retried by it, as they are made in a transaction, so its querier should be retrying one
+// (see NewRetryingKeyValueQ) for them to be. RowStream queries are not retried either.
+// EntityMaxAttempts is the number of times an entity is processed before it is considered
+// failed, 1 by default. OnDeadLetter takes such entities instead of EntityErrorPolicy, so
+// the list goes on unless OnDeadLetter fails. WrapAround set to false makes the streamer
+// leave the cursor at the empty page past the end of the stream instead of moving it back to
+// the first one, so that lists formed from then on are empty until new entities are added,
+// e.g. for one-shot backfills. CheckpointEveryNBatches above 1 makes the streamer keep the
+// cursor in memory and write it once every that many batches, as well as on Flush, which
+// Run and ProcessAll call on exit, so a crash makes the pages taken since the last write to
+// be processed again. Like CursorWriteBuffer, it suits streamers running in a single
+// instance and requires CursorLockAndUpdate. Moving the cursor back to the first page, Reset
+// and SetPage are written right away.
+// CursorCacheSync makes the streamer keep the cursor in memory as well, reading it from the
+// key value storage only once, and again after Reset and SetPage, and writing it once the
+// interval passed since the last write, or once CheckpointEveryNBatches batches are taken
+// if that comes first, as well as on Flush, saving both the read and the write of most
+// pages. GetCurrentPage returns the cursor kept in memory. A crash replays the pages taken
+// within the last interval, and the cursor moved by someone else is not noticed, so it
+// suits a single instance only: Cooperative is rejected along with it, and it has the
+// requirements of CheckpointEveryNBatches.
+// CheckpointEveryNEntities makes FormListAndProcess, ProcessAll and Run with
+// AdvanceAfterProcessing store the number of the entities of the page done with once every
+// that many of them, under the cursor key with the ":offset" suffix, and skip them when the
+// page is formed again after a failure or a crash. The offset is cleared once the cursor is
+// moved past the page. IT ASSUMES THE STREAM SELECTS ENTITIES IN A STABLE ORDER, e.g. ORDER
+// BY a unique column: otherwise the entities skipped are not the ones processed. It requires
+// AdvanceAfterProcessing and no MaxConcurrency.
+// Cooperative lets several instances stream through the same cursor, e.g. replicas of a
+// worker, each page being taken by one of them per pass: a page is claimed with AdvanceCursor,
+// which locks the cursor for that single query only, so no instance waits for another one
+// to process its page, and the cursor is moved back to the first page by the instance that
+// reached the end of the stream last (see CompareAndSwapper), while the others get an empty
+// list. It makes CursorMode CursorAdvanceFirst by default, which it requires unless
+// PageConcurrency is above 1, and a querier implementing CompareAndSwapper too. WrapAround set
+// to false is not supported with it, as instances past the end could not agree on the page to
+// stop at.
+// OnBatchStart and OnBatchEnd are called with Ctx around every list processed by
+// FormListAndProcess, FormListAndProcessBatch, FormListAndProcessTx, ProcessAll and Run, along
+// with BatchStarted and BatchCompleted events, while OnEmpty is called whenever an empty page
+// is selected, i.e. the end of the stream is reached. Unlike events, they are called
+// synchronously, so they should be quick. A hook that panics is logged and does not stop the
+// streamer. FormAndProcessRows does not call OnBatchStart and OnBatchEnd, as it does not know
+// the size of its list.
+// Filter makes the processing methods skip the entities it does not accept, counting them as
+// Filtered (see BatchCompleted), e.g. when the query of the Stream is shared with another
+// consumer. The page is consumed anyway, so a page filtered out entirely is moved past like
+// any other. FormList returns the entities as they are.
+// A Stream implementing Countable makes ProcessAll and Run count its entities when they start
+// and then once every CountInterval, a minute by default, and log every list they process
+// with Log telling how far they have got (see StreamerStats.Percent). A failure to count them
+// is logged and makes the total unknown.
+// Direction set to Descending makes the streamer take pages from StartPage, or the last page
+// of a Countable Stream if it is nil, down to the first one, moving the cursor to the page
+// below after every list, and leaves lists empty once the first page is taken instead of
+// starting over (see Reset). Empty pages are stepped over, e.g. if rows were deleted since
+// the pages were counted. The cursor is stored the same way, while the key with the
+// ":direction" suffix marks it as a descending one, so that streamers of either direction
+// fail with ErrDirectionMismatch on a cursor of the other one. It requires CursorLockAndUpdate
+// without PageConcurrency, CursorWriteBuffer, CheckpointEveryNBatches and
+// AdvanceAfterProcessing, and Stream, All and FormListAndProcessTx are not supported with it.
+// A panic of a processing function is recovered and converted into a PanicError holding the
+// value and the stack, which is handled as if the function returned it, e.g. skipped with
+// EntityErrorSkipAndLog; RecoverPanics set to false lets the panic crash the process instead.
+// AdaptiveBatch makes the streamer multiply or divide the size of the next page by up to 2
+// after every batch processed successfully, aiming at TargetBatchDuration at the pace of
+// the last batch, within MinSize and MaxSize (see StreamerStats.BatchSize). As pages of
+// different sizes do not line up, the cursor is stored as the number of the entities
+// before it, the same way a page number is with BatchSize of 1, so that resuming after a
+// restart neither skips nor repeats entities, and a cursor of a streamer with a fixed batch
+// size is translated once it is switched (see BatchSizePolicy). Pages of Select, SetPage,
+// GetCurrentPage, StreamError and the events are such offsets as well. The offset is
+// selected as the page of the current size containing it, the entities before the offset
+// being dropped, so a list could be shorter than the size after the size is changed. It
+// requires CursorLockAndUpdate without PageConcurrency, Cooperative and Descending, and
+// FormAndProcessRows is not supported with it.
+// Sorts make the streamer select pages with SelectWithPageParamsSorted of a Stream
+// implementing SortableStreamable, so that the order the cursor relies on is stated in one
+// place and stays the same across restarts, while a Stream that does not implement it makes
+// every method fail instead of paginating in an order the sorts do not tell. RowStream is
+// not sorted by them.
+// CircuitBreaker makes the processing methods count the lists failed in a row under the
+// cursor key with the ":failures" suffix, resetting the count once a list succeeds, and
+// fail with CircuitOpenError (matching ErrCircuitOpen) without forming a list for CoolDown
+// once the count reaches Threshold, while Run waits for it to pass. A single probe list is
+// let through then, which closes the breaker once it succeeds and opens it again otherwise.
+// As the state is kept in the key value storage, every instance sharing the cursor respects
+// the same breaker (see BreakerState and ResetBreaker).
+// Every list processed successfully by FormListAndProcess, FormListAndProcessBatch,
+// FormListAndProcessTx, ProcessAll and Run is recorded as LastRunInfo under the cursor key
+// with the ":last_run" suffix, with a single write per list (see GetLastRunInfo), unless
+// SkipLastRunInfo is set. A failure to write it is logged and does not fail the list
 func NewStreamer[T any](initParams StreamerInitParams[T]) Streamer[T] {
 	var (
-		batchSize = defaultBatchSize
-		ctx       = context.Background()
+		batchSize                 = defaultBatchSize
+		ctx                       = context.Background()
+		corruptCursorPolicy       = CorruptCursorFail
+		batchSizePolicy           = BatchSizeTranslate
+		cursorMode                = CursorLockAndUpdate
+		pageConcurrency           = uint64(1)
+		entityErrorPolicy         = EntityErrorFailFast
+		maxConcurrency            = uint64(1)
+		entityMaxAttempts         = uint(1)
+		direction                 = Ascending
+		recoverPanics             = true
+		clock               Clock = systemClock{}
 	)
 
 	if initParams.BatchSize != nil {
@@ -56,120 +397,928 @@ func NewStreamer[T any](initParams StreamerInitParams[T]) Streamer[T] {
 	if initParams.Ctx != nil {
 		ctx = *initParams.Ctx
 	}
+	if initParams.CorruptCursorPolicy != nil {
+		corruptCursorPolicy = *initParams.CorruptCursorPolicy
+	}
+	if initParams.BatchSizePolicy != nil {
+		batchSizePolicy = *initParams.BatchSizePolicy
+	}
+	if initParams.CursorMode != nil {
+		cursorMode = *initParams.CursorMode
+	} else if initParams.Cooperative {
+		cursorMode = CursorAdvanceFirst
+	}
+	if initParams.Clock != nil {
+		clock = initParams.Clock
+	}
+	if initParams.EntityErrorPolicy != nil {
+		entityErrorPolicy = *initParams.EntityErrorPolicy
+	}
+	if initParams.MaxConcurrency != nil && *initParams.MaxConcurrency > 1 {
+		maxConcurrency = *initParams.MaxConcurrency
+	}
+	if initParams.EntityMaxAttempts > 1 {
+		entityMaxAttempts = initParams.EntityMaxAttempts
+	}
+	if initParams.PageConcurrency != nil && *initParams.PageConcurrency > 1 {
+		pageConcurrency = *initParams.PageConcurrency
+	}
+	if initParams.Direction != nil {
+		direction = *initParams.Direction
+	}
+	if initParams.RecoverPanics != nil {
+		recoverPanics = *initParams.RecoverPanics
+	}
+
+	err := initParams.validate(batchSize)
+	adaptive := newAdaptiveBatch(initParams.AdaptiveBatch, batchSize)
+	if adaptive != nil {
+		if err == nil {
+			err = adaptive.cfg.validate()
+		}
+		if err == nil && (cursorMode != CursorLockAndUpdate || pageConcurrency > 1 || initParams.Cooperative || direction == Descending) {
+			err = errors.New("AdaptiveBatch requires CursorLockAndUpdate without PageConcurrency, Cooperative and Descending")
+		}
+		// the cursor counts entities (see pageParams)
+		batchSize = 1
+	}
+	if _, ok := initParams.KeyValueQ.(CursorAdvancer); err == nil && cursorMode == CursorAdvanceFirst && !ok {
+		err = errors.New("CursorAdvanceFirst requires a querier implementing CursorAdvancer")
+	}
+	if _, ok := initParams.KeyValueQ.(CursorAdvancer); err == nil && pageConcurrency > 1 && !ok {
+		err = errors.New("PageConcurrency requires a querier implementing CursorAdvancer")
+	}
+	if err == nil && initParams.Cooperative && cursorMode != CursorAdvanceFirst && pageConcurrency == 1 {
+		err = errors.New("Cooperative requires CursorAdvanceFirst or PageConcurrency")
+	}
+	if err == nil && initParams.Cooperative && initParams.WrapAround != nil && !*initParams.WrapAround {
+		err = errors.New("Cooperative does not support WrapAround set to false")
+	}
+	if _, ok := initParams.KeyValueQ.(CompareAndSwapper); err == nil && initParams.Cooperative && !ok {
+		err = errors.New("Cooperative requires a querier implementing CompareAndSwapper")
+	}
+	if err == nil && initParams.CursorWriteBuffer != nil && (cursorMode != CursorLockAndUpdate || pageConcurrency > 1) {
+		err = errors.New("CursorWriteBuffer requires CursorLockAndUpdate")
+	}
+	if err == nil && initParams.CheckpointEveryNBatches != nil && *initParams.CheckpointEveryNBatches > 1 &&
+		(cursorMode != CursorLockAndUpdate || pageConcurrency > 1 || initParams.CursorWriteBuffer != nil) {
+		err = errors.New("CheckpointEveryNBatches requires CursorLockAndUpdate without CursorWriteBuffer")
+	}
+	if err == nil && initParams.CursorCacheSync != nil {
+		switch {
+		case *initParams.CursorCacheSync <= 0:
+			err = errors.New("CursorCacheSync must be positive")
+		case initParams.Cooperative:
+			err = errors.New("CursorCacheSync does not support Cooperative")
+		case cursorMode != CursorLockAndUpdate || pageConcurrency > 1 || initParams.CursorWriteBuffer != nil || direction == Descending:
+			err = errors.New("CursorCacheSync requires CursorLockAndUpdate without CursorWriteBuffer and Descending")
+		}
+	}
+	if err == nil && initParams.CheckpointEveryNEntities != nil && *initParams.CheckpointEveryNEntities > 0 &&
+		(!initParams.AdvanceAfterProcessing || maxConcurrency > 1) {
+		err = errors.New("CheckpointEveryNEntities requires AdvanceAfterProcessing without MaxConcurrency")
+	}
+	if err == nil && initParams.AdvanceAfterProcessing && (cursorMode != CursorLockAndUpdate || pageConcurrency > 1) {
+		err = errors.New("AdvanceAfterProcessing requires CursorLockAndUpdate")
+	}
+	if err == nil && direction == Descending && (cursorMode != CursorLockAndUpdate || pageConcurrency > 1 ||
+		initParams.CursorWriteBuffer != nil || initParams.AdvanceAfterProcessing ||
+		(initParams.CheckpointEveryNBatches != nil && *initParams.CheckpointEveryNBatches > 1)) {
+		err = errors.New("Descending requires CursorLockAndUpdate without PageConcurrency, CursorWriteBuffer, " +
+			"CheckpointEveryNBatches and AdvanceAfterProcessing")
+	}
+	if _, ok := initParams.Stream.(Countable); err == nil && direction == Descending && initParams.StartPage == nil && !ok {
+		err = errors.New("Descending requires StartPage or a Countable Stream")
+	}
+	if _, ok := initParams.Stream.(SortableStreamable[T]); err == nil && len(initParams.Sorts) > 0 && !ok {
+		err = errors.New("Sorts requires a Stream implementing SortableStreamable")
+	}
+	if err == nil && initParams.CircuitBreaker != nil {
+		err = initParams.CircuitBreaker.validate()
+	}
+	var dedup *dedupWindow
+	if initParams.DedupWindow > 0 {
+		dedup = newDedupWindow(initParams.DedupWindow)
+		if err == nil && initParams.DedupID == nil {
+			err = errors.New("DedupWindow requires DedupID")
+		}
+	}
+
+	var fingerprint *StreamerFingerprint
+	if initParams.Fingerprint {
+		fingerprint = newFingerprint[T](batchSize, direction)
+	}
+
+	checkpoint := newCheckpoint(
+		initParams.CheckpointEveryNBatches, initParams.CursorCacheSync, clock,
+		initParams.KeyValueQ, initParams.KeyValueKey,
+	)
+
+	stats := &streamerCounters{batchSize: int64(batchSize)}
+	if adaptive != nil {
+		stats.batchSize = int64(adaptive.current())
+	}
 
 	return &streamer[T]{
-		Stream:      initParams.Stream,
-		KeyValueQ:   initParams.KeyValueQ,
-		KeyValueKey: initParams.KeyValueKey,
-		BatchSize:   batchSize,
-		Log:         initParams.Log,
-		Ctx:         ctx,
+		Source:                 initParams.Stream,
+		RowStream:              initParams.RowStream,
+		KeyValueQ:              withContext(initParams.KeyValueQ, ctx),
+		shutdownQ:              withContext(initParams.KeyValueQ, detachedContext{ctx}),
+		KeyValueKey:            initParams.KeyValueKey,
+		BatchSize:              batchSize,
+		Log:                    initParams.Log,
+		Ctx:                    ctx,
+		CorruptCursorPolicy:    corruptCursorPolicy,
+		OnCorruptCursor:        initParams.OnCorruptCursor,
+		BatchSizePolicy:        batchSizePolicy,
+		EventSink:              asyncEventSink(initParams.EventSink),
+		stats:                  stats,
+		lastProgress:           new(int64),
+		ascendingChecked:       new(int32),
+		CursorMode:             cursorMode,
+		PageConcurrency:        pageConcurrency,
+		DedupID:                initParams.DedupID,
+		Preparation:            initParams.Preparation,
+		Clock:                  clock,
+		Tracer:                 initParams.Tracer,
+		AdvanceAfterProcessing: initParams.AdvanceAfterProcessing,
+		EntityErrorPolicy:      entityErrorPolicy,
+		SkippableError:         initParams.SkippableError,
+		MaxConcurrency:         maxConcurrency,
+		StreamBufferSize:       initParams.StreamBufferSize,
+		EntityLimiter:          newEntityLimiter(initParams.EntityRateLimiter, initParams.EntityRateLimit),
+		pacer:                  newBatchPacer(initParams.BatchInterval),
+		progress:               newProgress(initParams.Stream, initParams.CountInterval),
+		SelectRetry:            selectRetry(initParams.SelectRetry),
+		OnDeadLetter:           initParams.OnDeadLetter,
+		EntityMaxAttempts:      entityMaxAttempts,
+		draining:               initParams.WrapAround != nil && !*initParams.WrapAround,
+		cooperative:            initParams.Cooperative,
+		OnBatchStart:           initParams.OnBatchStart,
+		OnBatchEnd:             initParams.OnBatchEnd,
+		OnEmpty:                initParams.OnEmpty,
+		Filter:                 initParams.Filter,
+		StartPage:              initParams.StartPage,
+		RecoverPanics:          recoverPanics,
+		descending:             direction == Descending,
+		dedup:                  dedup,
+		adaptive:               adaptive,
+		Sorts:                  initParams.Sorts,
+		sorted:                 newSortedSource(initParams.Stream, initParams.Sorts),
+		breaker:                initParams.CircuitBreaker,
+		fingerprint:            fingerprint,
+		fingerprintChecked:     new(int32),
+		forceAdopt:             initParams.ForceAdopt,
+		lastRun:                newLastRun(initParams.SkipLastRunInfo),
+		cursorBuffer:           newCursorBuffer(initParams.CursorWriteBuffer, initParams.KeyValueQ, initParams.KeyValueKey, ctx),
+		checkpoint:             checkpoint,
+		offsets:                newEntityOffsets(initParams.CheckpointEveryNEntities, withContext(initParams.KeyValueQ, ctx), initParams.KeyValueKey),
+		err:                    err,
+	}
+}
+
+// NewStreamerChecked does the same thing as NewStreamer, but returns the error the methods of
+// the streamer would fail with if the params are invalid, e.g. the required ones are missing.
+// With Fingerprint, the fingerprint is checked as well (see KeyCollisionError)
+func NewStreamerChecked[T any](initParams StreamerInitParams[T]) (Streamer[T], error) {
+	s := NewStreamer(initParams)
+	if err := s.(*streamer[T]).err; err != nil {
+		return nil, errors.Wrap(err, "invalid streamer params")
+	}
+	if err := s.(*streamer[T]).checkFingerprint(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// validate checks the params required by every streamer, naming the one that is invalid
+func (p StreamerInitParams[T]) validate(batchSize uint64) error {
+	switch {
+	case p.Stream == nil && p.RowStream == nil:
+		return errors.New("Stream or RowStream is required")
+	case p.KeyValueQ == nil:
+		return errors.New("KeyValueQ is required")
+	case batchSize == 0:
+		return errors.New("BatchSize must be at least 1")
 	}
+	if err := ValidateKey(p.KeyValueKey); err != nil {
+		return errors.Wrap(err, "invalid KeyValueKey")
+	}
+	return nil
 }
 
 // Streamer is a structure to stream through some querier
 type streamer[T any] struct {
-	Stream      Streamable[T]
-	KeyValueQ   KeyValueQ
-	KeyValueKey string
-	BatchSize   uint64
-	Log         *logan.Entry
-	Ctx         context.Context
+	Source                 Streamable[T]
+	RowStream              RowStreamable[T]
+	KeyValueQ              KeyValueQ
+	KeyValueKey            string
+	BatchSize              uint64
+	Log                    *logan.Entry
+	Ctx                    context.Context
+	CorruptCursorPolicy    CorruptCursorPolicy
+	OnCorruptCursor        CorruptCursorHandler
+	BatchSizePolicy        BatchSizePolicy
+	EventSink              EventSink
+	CursorMode             CursorMode
+	PageConcurrency        uint64
+	DedupID                func(T) string
+	Preparation            Preparation
+	Clock                  Clock
+	Tracer                 trace.Tracer
+	AdvanceAfterProcessing bool
+	EntityErrorPolicy      EntityErrorPolicy
+	SkippableError         SkippableErrorFunc
+	MaxConcurrency         uint64
+	StreamBufferSize       int
+	EntityLimiter          *rate.Limiter
+	SelectRetry            *RetryConfig
+	OnDeadLetter           DeadLetterFunc[T]
+	EntityMaxAttempts      uint
+	OnBatchStart           BatchStartHook
+	OnBatchEnd             BatchEndHook
+	OnEmpty                EmptyPageHook
+	Filter                 func(t T) bool
+	StartPage              *uint64
+	RecoverPanics          bool
+	Sorts                  pgdb.Sorts
+
+	// err is an error of the construction returned by every method
+	err   error
+	stats *streamerCounters
+	// shutdownQ is KeyValueQ run regardless of Ctx being done, so that the progress is
+	// persisted once the streamer is interrupted (see interruptStreaming)
+	shutdownQ    KeyValueQ
+	lastProgress *int64
+	// ascendingChecked is set once the cursor is found not to be one of a descending
+	// streamer, so that it is not checked again (see checkAscending)
+	ascendingChecked *int32
+	dedup            *dedupWindow
+	// adaptive is the batch size adjusted after every batch, which makes the cursor an
+	// offset (see AdaptiveBatch)
+	adaptive *adaptiveBatch
+	// sorted is Source selecting pages sorted by Sorts, if they are set
+	sorted SortableStreamable[T]
+	// breaker stops the streamer once lists fail in a row, if it is set (see CircuitBreaker)
+	breaker *CircuitBreakerConfig
+	// fingerprint is compared with the one stored along with the cursor once per streamer,
+	// if it is set (see checkFingerprint)
+	fingerprint        *StreamerFingerprint
+	fingerprintChecked *int32
+	forceAdopt         bool
+	// lastRun keeps the count of the processed entities stored in LastRunInfo, unless it is
+	// skipped
+	lastRun *lastRun
+	// results collects the outcomes of the entities (see FormListAndProcessReport)
+	results      *entityResults[T]
+	cursorBuffer *cursorBuffer
+	pacer        *batchPacer
+	progress     *progress
+	checkpoint   *checkpoint
+	offsets      *entityOffsets
+	// draining makes the streamer stop at the end of the stream instead of moving the
+	// cursor back to the first page (see ProcessAll)
+	draining bool
+	// descending makes the streamer take pages down to the first one (see Descending)
+	descending bool
+	// cooperative makes the streamer move the cursor back to the first page only if no
+	// other instance claimed a page since (see resetClaimed)
+	cooperative bool
+	// holdCursor makes the streamer leave the cursor at the page taken, so that it is moved
+	// once the page is processed (see AdvanceAfterProcessing)
+	holdCursor bool
+	// trackOffset makes the streamer store the progress within the page it holds the
+	// cursor at (see CheckpointEveryNEntities)
+	trackOffset bool
 }
 
 func (s *streamer[T]) Select(pageNumber uint64) ([]T, error) {
-	return s.Stream.SelectWithPageParams(pgdb.OffsetPageParams{
-		Limit:      s.BatchSize,
-		PageNumber: pageNumber})
+	if s.err != nil {
+		return nil, errors.Wrap(s.err, "invalid streamer")
+	}
+
+	return s.selectRetrying(pageNumber)
 }
 
 func (s *streamer[T]) FormListAndProcess(fn func(ctx context.Context, t T) error) error {
-	entities, err := s.FormList()
+	_, _, _, err := s.formListAndProcess(eachEntity(fn))
+	if stopped(err) {
+		return nil
+	}
+	return err
+}
+
+// listProcessor processes the entities of the list taken from page with the streamer s,
+// counting them in completed and collecting the errors of the skipped ones (see
+// EntityErrorPolicy). It returns the error that stopped the list, if any
+type listProcessor[T any] func(s *streamer[T], page uint64, entities []T, completed *BatchCompleted, collected *BatchErrors) error
+
+// eachEntity makes a list processor applying fn to the entities one by one or, with
+// MaxConcurrency, concurrently
+func eachEntity[T any](fn func(ctx context.Context, t T) error) listProcessor[T] {
+	return func(s *streamer[T], page uint64, entities []T, completed *BatchCompleted, collected *BatchErrors) error {
+		if s.MaxConcurrency > 1 {
+			return s.processListConcurrently(fn, page, entities, completed, collected)
+		}
+		return s.processList(fn, page, entities, completed, collected)
+	}
+}
+
+// formListAndProcess forms a list and processes it, returning the page it starts at and the
+// number of entities formed, which is 0 at the end of the stream, and processed
+func (s *streamer[T]) formListAndProcess(process listProcessor[T]) (page uint64, formed, processed int, err error) {
+	if err = s.checkPaused(); err != nil {
+		return 0, 0, 0, err
+	}
+	failures, err := s.checkBreaker()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer func() { s.recordBreaker(failures, err) }()
+	if s.Tracer != nil {
+		var span trace.Span
+		s, span = s.startBatchSpan()
+		defer func() { endSpan(span, err) }()
+	}
+	defer func() { s.failed(err) }()
+	if !s.AdvanceAfterProcessing {
+		page, formed, processed, err = s.formAndProcessList(process)
+	} else {
+		tracked := *s
+		tracked.trackOffset = true
+		page, formed, processed, err = tracked.formAndProcessHeld(func(*streamer[T]) listProcessor[T] {
+			return process
+		})
+	}
+	if stopped(err) {
+		err = s.stopStreaming(err, s.AdvanceAfterProcessing)
+	} else if s.canceled(err) {
+		// the offset within the held page is tracked by FormListAndProcess only
+		interrupted := *s
+		interrupted.trackOffset = s.AdvanceAfterProcessing
+		err = interrupted.interruptStreaming(err, s.AdvanceAfterProcessing)
+	}
+	return page, formed, processed, err
+}
+
+// formAndProcessHeld forms a list and processes it with the processor bind makes for the
+// streamer bound to the transaction. The cursor stays locked while the list is processed
+// and is moved past it in the same transaction, so a failure rolls the transaction back,
+// leaving the list to be formed again
+func (s *streamer[T]) formAndProcessHeld(bind func(tx *streamer[T]) listProcessor[T]) (page uint64, formed, processed int, err error) {
+	if err = s.pace(); err != nil {
+		return 0, 0, 0, err
+	}
+	var collected *BatchErrors
+	err = s.inTx(func(tx *streamer[T]) (err error) {
+		held := *tx
+		held.holdCursor = true
+		page, formed, processed, err = held.formAndProcessList(bind(tx))
+		if batchErr, ok := err.(*BatchErrors); ok {
+			// the entities failed are skipped, so the list is processed anyway
+			collected, err = batchErr, nil
+		}
+		if err != nil || formed == 0 {
+			return err
+		}
+		if err = tx.advanceCursor(tx.nextPage(page, formed)); err != nil {
+			return tx.fail(StageCursorWrite, page, errors.Wrap(err, "failed to update last processed entities"))
+		}
+		return nil
+	})
 	if err != nil {
-		return errors.Wrap(err, "failed to form a list of entities")
+		return page, formed, processed, s.streamError(err)
 	}
+	if formed != 0 {
+		s.pageDone()
+		if collected == nil {
+			s.recordLastRun(page, formed, processed)
+		}
+	}
+	return page, formed, processed, collected.orNil()
+}
 
-	for _, entity := range entities {
-		if err = fn(s.Ctx, entity); err != nil {
-			return errors.Wrap(err, "failed to process an entity")
+// formAndProcessList forms a list and processes it with process
+func (s *streamer[T]) formAndProcessList(process listProcessor[T]) (page uint64, formed, processed int, err error) {
+	entities, page, err := s.formListInTx()
+	if err != nil {
+		// entities preceding the unprocessed pages are processed anyway
+		streamErr := s.streamError(err)
+		if _, partial := streamErr.Err.(*UnprocessedPagesError); !partial {
+			return 0, 0, 0, streamErr
 		}
+		err = streamErr
+	}
+	if len(entities) == 0 {
+		return 0, 0, 0, err
+	}
+	if s.Tracer != nil {
+		trace.SpanFromContext(s.Ctx).SetAttributes(attrPage.Int64(int64(page)), attrEntities.Int(len(entities)))
 	}
 
+	s.batchStarted(page, len(entities))
+	started := time.Now()
+	completed := BatchCompleted{Key: s.KeyValueKey, Page: page}
+	_, completed.CursorPersistDeferred = s.cursorBuffer.current()
+	defer func() {
+		completed.Duration = time.Since(started)
+		s.batchEnded(completed, len(entities), err)
+	}()
+
+	var collected BatchErrors
+	if processErr := process(s, page, entities, &completed, &collected); processErr != nil {
+		return page, len(entities), completed.Processed, processErr
+	}
+
+	if err == nil {
+		err = collected.orNil()
+	}
+	return page, len(entities), completed.Processed, err
+}
+
+// processList processes the entities of the list one by one (see listProcessor)
+func (s *streamer[T]) processList(
+	fn func(ctx context.Context, t T) error, page uint64, entities []T, completed *BatchCompleted, collected *BatchErrors,
+) error {
+	start, err := s.resumeOffset(page)
+	if err != nil {
+		return err
+	}
+	for i := start; i < len(entities); i++ {
+		entity := entities[i]
+		if ctxErr := s.Ctx.Err(); ctxErr != nil {
+			return s.failEntity(page, i, ctxErr)
+		}
+		if s.redelivered(entity) {
+			s.entityDone(page, i+1)
+			continue
+		}
+		if s.filteredOut(entity) {
+			completed.Filtered++
+			s.entityDone(page, i+1)
+			continue
+		}
+		if waitErr := s.throttle(s.Ctx); waitErr != nil {
+			return s.failEntity(page, i, waitErr)
+		}
+		deadLettered, processErr, stopErr := s.processEntity(func() error {
+			return s.process(fn, i, entity)
+		}, page, i, entity)
+		if stopErr != nil {
+			if !stopped(stopErr) {
+				completed.Failed++
+			}
+			return stopErr
+		}
+		if processErr != nil {
+			completed.Failed++
+			if processErr = s.entityFailed(page, i, processErr, collected); processErr != nil {
+				return processErr
+			}
+		} else if deadLettered {
+			s.delivered(entity)
+			completed.DeadLettered++
+		} else {
+			s.delivered(entity)
+			completed.Processed++
+		}
+		s.entityDone(page, i+1)
+	}
 	return nil
 }
 
 func (s *streamer[T]) FormList() ([]T, error) {
-	// Get page number to begin from
-	pageNumber, err := s.GetCurrentPage()
+	entities, _, err := s.formListInTx()
+	if s.dedup != nil {
+		unseen := entities[:0]
+		for _, entity := range entities {
+			if !s.redelivered(entity) {
+				s.delivered(entity)
+				unseen = append(unseen, entity)
+			}
+		}
+		entities = unseen
+	}
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get current page number")
+		return entities, s.failed(s.streamError(err))
 	}
+	return entities, nil
+}
 
-	// Select entities from the prior found page number
-	entities, err := s.Select(pageNumber)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to select entities")
+// fail makes err a StreamError of the stage and the page
+func (s *streamer[T]) fail(stage StreamStage, page uint64, err error) *StreamError {
+	return &StreamError{KeyValueKey: s.KeyValueKey, Page: page, EntityIndex: -1, Stage: stage, Err: err}
+}
+
+// failEntity makes err a StreamError of processing the i-th entity of the list taken from
+// page (and the pages following it if the list spans several of them)
+func (s *streamer[T]) failEntity(page uint64, i int, err error) *StreamError {
+	return &StreamError{
+		KeyValueKey: s.KeyValueKey,
+		Page:        page + uint64(i)/s.BatchSize,
+		EntityIndex: int(uint64(i) % s.BatchSize),
+		Stage:       StageProcess,
+		Err:         err,
 	}
+}
 
-	// If entities list is empty, and we are on the first page, there are no entities in the database
-	if len(entities) == 0 && pageNumber == 0 {
-		if s.Log != nil {
-			s.Log.Warn("Entities list is empty")
+// interrupted returns a StreamError of the stage and the page once Ctx is done, so that no
+// more queries are made for a canceled list. The error of Ctx is not wrapped, so that
+// errors.Is matches it
+func (s *streamer[T]) interrupted(stage StreamStage, page uint64) error {
+	if err := s.Ctx.Err(); err != nil {
+		return s.fail(stage, page, err)
+	}
+	return nil
+}
+
+// streamError finds the StreamError in the chain of err, so that it could be returned as
+// is. Errors that are not one yet happened before the cursor was read
+func (s *streamer[T]) streamError(err error) *StreamError {
+	var streamErr *StreamError
+	if walk(err, func(err error) bool {
+		streamErr, _ = err.(*StreamError)
+		return streamErr != nil
+	}) {
+		return streamErr
+	}
+	return s.fail(StageCursorRead, 0, err)
+}
+
+// formListInTx forms a list within a transaction (see inTx) and returns the page it was taken from
+func (s *streamer[T]) formListInTx() (entities []T, page uint64, err error) {
+	if err = s.checkFingerprint(); err != nil {
+		return nil, 0, err
+	}
+	if !s.holdCursor {
+		// the held cursor is paced before its transaction begins (see formAndProcessHeld)
+		if err = s.pace(); err != nil {
+			return nil, 0, err
+		}
+	}
+	switch {
+	case s.PageConcurrency > 1:
+		entities, page, err = s.formListConcurrent()
+	case s.CursorMode == CursorAdvanceFirst:
+		entities, page, err = s.formListAdvancing()
+	default:
+		deferred := s.cursorBuffer.deferred()
+		err = s.inTx(func(s *streamer[T]) (err error) {
+			entities, page, err = s.formList()
+			return err
+		})
+		if err != nil && entities != nil && s.cursorBuffer.deferred() != deferred {
+			// the transaction could not be committed because of the failed cursor write
+			err = nil
 		}
-		return nil, nil
 	}
+	if err == nil {
+		s.progressed()
+	}
+	return entities, page, err
+}
 
-	// If pairs list is empty, we should begin from the 1st page
-	if len(entities) == 0 {
-		// Setting page number to 0
-		if err = s.KeyValueQ.Upsert(KeyValue{Key: s.KeyValueKey, Value: "0"}); err != nil {
-			return nil, errors.Wrap(err, "failed to upsert last page")
+// progressed records the time the streamer made progress (see LastProgress)
+func (s *streamer[T]) progressed() {
+	atomic.StoreInt64(s.lastProgress, time.Now().UnixNano())
+}
+
+func (s *streamer[T]) Name() string {
+	return s.KeyValueKey
+}
+
+func (s *streamer[T]) LastProgress() time.Time {
+	if nanos := atomic.LoadInt64(s.lastProgress); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
+func (s *streamer[T]) formList() ([]T, uint64, error) {
+	var entities []T
+	page, found, err := s.takePage(func(page uint64) (formed int, err error) {
+		entities, err = s.Select(page)
+		return len(entities), err
+	})
+	if err != nil || !found {
+		return nil, 0, err
+	}
+	return entities, page, nil
+}
+
+// takePage finds a page selectPage finds entities on, starting with the current one, and
+// moves the cursor past it. selectPage returns the number of the entities formed, or any
+// positive one if it is not known yet. It reports no page found if there are no entities
+// at all
+func (s *streamer[T]) takePage(selectPage func(page uint64) (formed int, err error)) (uint64, bool, error) {
+	if s.descending {
+		return s.takePageDescending(selectPage)
+	}
+
+	// The cursor is reset to the first page at most once per call: if the page it points
+	// to is empty even after the reset, someone else keeps moving it
+	for reset := false; ; reset = true {
+		if err := s.interrupted(StageCursorRead, 0); err != nil {
+			return 0, false, err
+		}
+		if err := s.cursorBuffer.check(); err != nil {
+			return 0, false, s.fail(StageCursorWrite, 0, err)
+		}
+
+		// Get page number to begin from, unless there is one not persisted yet
+		pageNumber, deferred := s.cursorBuffer.current()
+		if !deferred {
+			pageNumber, deferred = s.checkpoint.current()
+		}
+		var err error
+		if !deferred {
+			pageNumber, err = s.getCurrentPage()
+		}
+		if err != nil {
+			return 0, false, s.fail(StageCursorRead, 0, errors.Wrap(err, "failed to get current page number"))
 		}
+		s.checkpoint.load(pageNumber)
 
-		// Restart the function with a page number equal to 0
-		return s.FormList()
+		if err = s.prepare(pageNumber); err != nil {
+			return 0, false, err
+		}
+		if err = s.interrupted(StageSelect, pageNumber); err != nil {
+			return 0, false, err
+		}
+
+		// Select entities from the prior found page number
+		formed, err := selectPage(pageNumber)
+		found := formed != 0
+		if err != nil {
+			return 0, false, s.fail(StageSelect, pageNumber, errors.Wrap(err, "failed to select entities"))
+		}
+		if err = s.interrupted(StageCursorWrite, pageNumber); err != nil {
+			return 0, false, err
+		}
+
+		// If entities list is empty, and we are on the first page, there are no entities in the database
+		if !found && pageNumber == 0 {
+			if s.Log != nil {
+				s.Log.Warn("Entities list is empty")
+			}
+			s.endOfStream(0)
+			return 0, false, nil
+		}
+
+		// If pairs list is empty, we should begin from the 1st page
+		if !found {
+			if s.draining {
+				// the cursor is left at the empty page (see ProcessAll)
+				s.endOfStream(pageNumber)
+				return 0, false, nil
+			}
+			if reset {
+				return 0, false, s.fail(StageSelect, pageNumber, ErrInconsistentStream)
+			}
+
+			// Setting page number to 0
+			s.endOfStream(pageNumber)
+			if err = s.writeCursor(0); err != nil {
+				return 0, false, s.fail(StageCursorWrite, pageNumber, errors.Wrap(err, "failed to upsert last page"))
+			}
+			s.emit(CursorReset{Key: s.KeyValueKey})
+
+			// Select again with a page number equal to 0
+			continue
+		}
+
+		// If the list was not empty, just increment the page number
+		if s.holdCursor {
+			return pageNumber, true, nil
+		}
+		if err = s.advanceCursor(s.nextPage(pageNumber, formed)); err != nil {
+			return 0, false, s.fail(StageCursorWrite, pageNumber, errors.Wrap(err, "failed to update last processed entities"))
+		}
+
+		return pageNumber, true, nil
 	}
+}
 
-	// If the list was not empty, just increment the page number
-	if err = s.KeyValueQ.Upsert(KeyValue{
-		Key:   s.KeyValueKey,
-		Value: strconv.FormatUint(pageNumber+1, 10),
-	}); err != nil {
-		return nil, errors.Wrap(err, "failed to update last processed entities")
+// formListAdvancing forms a list taking the page with AdvanceCursor (see CursorAdvanceFirst)
+func (s *streamer[T]) formListAdvancing() ([]T, uint64, error) {
+	if s.err != nil {
+		return nil, 0, errors.Wrap(s.err, "invalid streamer")
 	}
 
-	// Return entities list
-	return entities, nil
+	for reset := false; ; reset = true {
+		if err := s.interrupted(StageCursorRead, 0); err != nil {
+			return nil, 0, err
+		}
+		pageNumber, err := s.KeyValueQ.(CursorAdvancer).AdvanceCursor(s.KeyValueKey, 1)
+		if err != nil {
+			return nil, 0, s.fail(StageCursorRead, 0, errors.Wrap(err, "failed to advance cursor"))
+		}
+		if err = s.prepare(pageNumber); err != nil {
+			return nil, 0, err
+		}
+		if err = s.interrupted(StageSelect, pageNumber); err != nil {
+			return nil, 0, err
+		}
+
+		entities, err := s.Select(pageNumber)
+		if err != nil {
+			return nil, 0, s.fail(StageSelect, pageNumber, errors.Wrap(err, "failed to select entities"))
+		}
+		if len(entities) != 0 {
+			return entities, pageNumber, nil
+		}
+
+		s.endOfStream(pageNumber)
+		if pageNumber == 0 {
+			if s.Log != nil {
+				s.Log.Warn("Entities list is empty")
+			}
+			return nil, 0, nil
+		}
+		if s.draining {
+			return nil, 0, s.rewind(pageNumber)
+		}
+		if reset {
+			return nil, 0, s.fail(StageSelect, pageNumber, ErrInconsistentStream)
+		}
+		if err = s.interrupted(StageCursorWrite, pageNumber); err != nil {
+			return nil, 0, err
+		}
+
+		moved, err := s.resetClaimed(pageNumber, pageNumber+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !moved {
+			// another instance reached the end after this one and moves the cursor back
+			return nil, 0, nil
+		}
+		s.emit(CursorReset{Key: s.KeyValueKey})
+	}
 }
 
 // GetCurrentPage returns a page we are at while streaming through data
 func (s *streamer[T]) GetCurrentPage() (uint64, error) {
-	pageKV, err := s.KeyValueQ.LockingGet(s.KeyValueKey)
-	if err != nil {
+	if page, deferred := s.cursorBuffer.current(); deferred {
+		return page, nil
+	}
+	if page, pending := s.checkpoint.current(); pending {
+		return page, nil
+	}
+
+	var page uint64
+	err := s.inTx(func(s *streamer[T]) (err error) {
+		page, err = s.getCurrentPage()
+		return err
+	})
+	return page, err
+}
+
+// inTx runs fn with a copy of the streamer whose querier is bound to a transaction (if the
+// querier supports them), so that the cursor stays locked from its read until its update
+func (s *streamer[T]) inTx(fn func(s *streamer[T]) error) error {
+	return transaction(s.KeyValueQ, func(q KeyValueQ) error {
+		tx := *s
+		tx.KeyValueQ = q
+		return fn(&tx)
+	})
+}
+
+// initCursor persists the initial cursor if the querier supports GetOrSet, so that
+// instances starting at once do not both take the first page. The cursor installed by
+// another instance is read with a lock then
+func (s *streamer[T]) initCursor() (KeyValue, error) {
+	initial := KeyValue{Key: s.KeyValueKey, Value: "0"}
+	initializer, ok := s.KeyValueQ.(GetOrSetter)
+	if !ok {
+		return initial, nil
+	}
+
+	cursor, installed, err := initializer.GetOrSet(s.KeyValueKey, initial.Value)
+	if err != nil || installed {
+		return cursor, err
+	}
+	return strict(s.KeyValueKey)(s.KeyValueQ.LockingGet(s.KeyValueKey))
+}
+
+func (s *streamer[T]) getCurrentPage() (uint64, error) {
+	if s.err != nil {
+		return 0, errors.Wrap(s.err, "invalid streamer")
+	}
+	if s.descending {
+		page, _, _, err := s.getDescendingPage()
+		return page, err
+	}
+
+	pageKV, err := strict(s.KeyValueKey)(s.KeyValueQ.LockingGet(s.KeyValueKey))
+	switch {
+	case Is(err, ErrNoSuchKey):
+		// If we did not find a cursor, initialize it with a value of 0, so it is not one of a
+		// descending streamer
+		atomic.StoreInt32(s.ascendingChecked, 1)
+		pageKV, err = s.initCursor()
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to initialize cursor", logan.F{
+				"key": s.KeyValueKey,
+			})
+		}
+	case err != nil:
 		return 0, errors.Wrap(err, "failed to get current cursor value", logan.F{
 			"key": s.KeyValueKey,
 		})
 	}
 
-	// If we did not find a cursor, initialize it with a value of 0
-	if pageKV == nil {
-		pageKV = &KeyValue{
-			Key:   s.KeyValueKey,
-			Value: "0",
+	page, err := parseCursor(pageKV.Value)
+	if err != nil {
+		if s.CorruptCursorPolicy == CorruptCursorFail {
+			return 0, errors.Wrap(err, "failed to parse cursor", logan.F{
+				"key":       s.KeyValueKey,
+				"kv_cursor": pageKV.Value,
+			})
+		}
+
+		if page, err = s.recoverCursor(pageKV.Value, err); err != nil {
+			return 0, err
 		}
 	}
 
-	page, err := strconv.ParseInt(pageKV.Value, 10, 64)
+	if err = s.checkAscending(); err != nil {
+		return 0, err
+	}
+	return s.reconcileBatchSize(page)
+}
+
+// recoverCursor replaces the corrupt cursor according to the policy of the streamer. The
+// cursor is read again with LockingGet right before the write and left intact if it was
+// changed in the meantime, so that concurrent instances do not both recover it
+func (s *streamer[T]) recoverCursor(raw string, cause error) (uint64, error) {
+	fields := logan.F{
+		"key":       s.KeyValueKey,
+		"kv_cursor": raw,
+	}
+
+	var page uint64
+	switch s.CorruptCursorPolicy {
+	case CorruptCursorResetToZero:
+		if s.Log != nil {
+			s.Log.WithError(cause).WithFields(fields).Error("Cursor is corrupt, resetting it to the first page")
+		}
+	case CorruptCursorCallback:
+		if s.OnCorruptCursor == nil {
+			return 0, errors.Wrap(cause, "cursor is corrupt and there is no callback to recover it", fields)
+		}
+
+		var err error
+		if page, err = s.OnCorruptCursor(raw); err != nil {
+			return 0, errors.Wrap(err, "failed to recover corrupt cursor", fields)
+		}
+		if page == math.MaxUint64 {
+			return 0, errors.From(errors.New("recovered cursor is out of range"), fields)
+		}
+	default:
+		return 0, errors.From(errors.New("unknown corrupt cursor policy"), fields.Add("policy", s.CorruptCursorPolicy))
+	}
+
+	current, err := s.KeyValueQ.LockingGet(s.KeyValueKey)
 	if err != nil {
-		return 0, errors.Wrap(err, "failed to parse cursor", logan.F{
-			"kv_cursor": pageKV.Value,
-		})
+		return 0, errors.Wrap(err, "failed to get current cursor value", fields)
 	}
-	if page < 0 {
-		return 0, errors.From(errors.New("cursor cannot be negative"), logan.F{
-			"cursor": page,
-		})
+	if current != nil && current.Value != raw {
+		// someone has already recovered the cursor
+		return parseCursor(current.Value)
+	}
+
+	if err = s.KeyValueQ.Upsert(KeyValue{Key: s.KeyValueKey, Value: strconv.FormatUint(page, 10)}); err != nil {
+		return 0, errors.Wrap(err, "failed to write recovered cursor", fields)
+	}
+	if page == 0 {
+		s.emit(CursorReset{Key: s.KeyValueKey})
+	}
+
+	return page, nil
+}
+
+func (s *streamer[T]) emit(event Event) {
+	s.record(event)
+	if s.EventSink != nil {
+		s.EventSink.Emit(event)
+	}
+}
+
+// parseCursor parses a page number stored as a cursor. The largest uint64 is rejected,
+// so that advancing the cursor to the next page cannot wrap around
+func parseCursor(value string) (uint64, error) {
+	if strings.TrimSpace(value) != value {
+		return 0, &CorruptCursorError{Value: value, Err: errors.New("cursor has leading or trailing whitespace")}
+	}
+
+	page, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, &CorruptCursorError{Value: value, Err: err}
+	}
+	if page == math.MaxUint64 {
+		return 0, &CorruptCursorError{Value: value, Err: errors.New("cursor is out of range")}
 	}
 
-	return uint64(page), nil
+	return page, nil
 }
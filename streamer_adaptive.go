@@ -0,0 +1,121 @@
+package dban
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// adaptiveMaxFactor is the most the batch size is multiplied or divided by after a batch
+const adaptiveMaxFactor = 2
+
+// AdaptiveBatchConfig makes the streamer adjust the size of the pages it selects after every
+// batch, so that batches take about TargetBatchDuration (see NewStreamer)
+type AdaptiveBatchConfig struct {
+	// MinSize and MaxSize bound the batch size
+	MinSize uint64
+	MaxSize uint64
+	// TargetBatchDuration is the time a batch should take to be processed
+	TargetBatchDuration time.Duration
+}
+
+func (c AdaptiveBatchConfig) validate() error {
+	switch {
+	case c.MinSize == 0:
+		return errors.New("AdaptiveBatch.MinSize must be at least 1")
+	case c.MaxSize < c.MinSize:
+		return errors.New("AdaptiveBatch.MaxSize must not be less than MinSize")
+	case c.TargetBatchDuration <= 0:
+		return errors.New("AdaptiveBatch.TargetBatchDuration must be positive")
+	}
+	return nil
+}
+
+// adaptiveBatch is the batch size adjusted by the streamer and its copies
+type adaptiveBatch struct {
+	cfg  AdaptiveBatchConfig
+	size uint64
+}
+
+// newAdaptiveBatch returns the adaptive batch starting with the initial size within the
+// bounds, if cfg is set
+func newAdaptiveBatch(cfg *AdaptiveBatchConfig, initial uint64) *adaptiveBatch {
+	if cfg == nil {
+		return nil
+	}
+	return &adaptiveBatch{cfg: *cfg, size: cfg.clamp(initial)}
+}
+
+func (c AdaptiveBatchConfig) clamp(size uint64) uint64 {
+	if size < c.MinSize {
+		return c.MinSize
+	}
+	if size > c.MaxSize {
+		return c.MaxSize
+	}
+	return size
+}
+
+func (b *adaptiveBatch) current() uint64 {
+	return atomic.LoadUint64(&b.size)
+}
+
+// adapt adjusts the size to the pace of the batch of n entities that took the duration:
+// the size that would take TargetBatchDuration at that pace is approached by at most
+// adaptiveMaxFactor at once, so that a single slow or quick batch does not swing it
+func (b *adaptiveBatch) adapt(n int, took time.Duration) uint64 {
+	size := b.current()
+	ratio := float64(adaptiveMaxFactor)
+	if took > 0 {
+		ratio = math.Min(ratio, float64(n)*float64(b.cfg.TargetBatchDuration)/float64(took)/float64(size))
+	}
+	ratio = math.Max(ratio, 1.0/adaptiveMaxFactor)
+
+	next := b.cfg.clamp(uint64(math.Max(1, math.Round(float64(size)*ratio))))
+	atomic.StoreUint64(&b.size, next)
+	return next
+}
+
+// pageParams returns the params to select the page with. With AdaptiveBatch the page is an
+// offset, which is selected as the page of the current size containing it, skipping the
+// entities preceding it
+func (s *streamer[T]) pageParams(page uint64) (params pgdb.OffsetPageParams, skip uint64) {
+	if s.adaptive == nil {
+		return pgdb.OffsetPageParams{Limit: s.BatchSize, PageNumber: page}, 0
+	}
+	size := s.adaptive.current()
+	return pgdb.OffsetPageParams{Limit: size, PageNumber: page / size}, page % size
+}
+
+// skipped returns the entities following the first skip ones
+func skipped[T any](entities []T, skip uint64) []T {
+	if skip == 0 {
+		return entities
+	}
+	if uint64(len(entities)) <= skip {
+		return nil
+	}
+	return entities[skip:]
+}
+
+// nextPage returns the page following the list of formed entities taken from page, the
+// offset past them with AdaptiveBatch
+func (s *streamer[T]) nextPage(page uint64, formed int) uint64 {
+	if s.adaptive == nil {
+		return page + 1
+	}
+	return page + uint64(formed)
+}
+
+// adaptBatch adjusts the batch size of AdaptiveBatch to the list of formed entities that
+// took the duration to be processed
+func (s *streamer[T]) adaptBatch(formed int, took time.Duration) {
+	if s.adaptive == nil || formed == 0 {
+		return
+	}
+	size := s.adaptive.adapt(formed, took)
+	atomic.StoreInt64(&s.stats.batchSize, int64(size))
+}
@@ -0,0 +1,136 @@
+package dban_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerAdaptiveBatch(t *testing.T) {
+	numbers := func(n int) []int {
+		items := make([]int, n)
+		for i := range items {
+			items[i] = i + 1
+		}
+		return items
+	}
+	newStreamer := func(key string, kvQ dban.KeyValueQ, batchSize uint64, cfg dban.AdaptiveBatchConfig) dban.Streamer[int] {
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:        dbantest.NewSliceStreamable(numbers(100)),
+			KeyValueQ:     kvQ,
+			KeyValueKey:   key,
+			BatchSize:     &batchSize,
+			AdaptiveBatch: &cfg,
+		})
+	}
+	// processList processes a list with fn taking the duration per entity
+	processList := func(t *testing.T, streamer dban.Streamer[int], perEntity time.Duration) []int {
+		var list []int
+		require.NoError(t, streamer.FormListAndProcess(func(_ context.Context, i int) error {
+			time.Sleep(perEntity)
+			list = append(list, i)
+			return nil
+		}))
+		return list
+	}
+
+	t.Run("grows", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := newStreamer("adaptive-grows", kvQ, 2, dban.AdaptiveBatchConfig{
+			MinSize:             2,
+			MaxSize:             16,
+			TargetBatchDuration: time.Hour,
+		})
+		assert.Equal(t, int64(2), streamer.GetStats().BatchSize)
+
+		// the entities before the offset are dropped from the pages of the grown size
+		for _, expected := range [][]int{numbers(2), {3, 4}, {5, 6, 7, 8}, numbers(16)[8:], numbers(32)[16:]} {
+			assert.Equal(t, expected, processList(t, streamer, 0))
+		}
+		assert.Equal(t, int64(16), streamer.GetStats().BatchSize, "the size must not exceed MaxSize")
+		assert.Equal(t, "32", kvQ.MustGet("adaptive-grows").Value, "the cursor must be the offset")
+		assert.Equal(t, "1", kvQ.MustGet("adaptive-grows:batch_size").Value)
+
+		resumed := newStreamer("adaptive-grows", kvQ, 5, dban.AdaptiveBatchConfig{
+			MinSize:             1,
+			MaxSize:             16,
+			TargetBatchDuration: time.Hour,
+		})
+		assert.Equal(t, []int{33, 34, 35}, processList(t, resumed, 0), "the offset must be resumed whatever the size")
+	})
+
+	t.Run("shrinks", func(t *testing.T) {
+		streamer := newStreamer("adaptive-shrinks", dbantest.NewMemoryKeyValueQ(), 8, dban.AdaptiveBatchConfig{
+			MinSize:             1,
+			MaxSize:             8,
+			TargetBatchDuration: 10 * time.Millisecond,
+		})
+
+		assert.Len(t, processList(t, streamer, 5*time.Millisecond), 8)
+		assert.Len(t, processList(t, streamer, 5*time.Millisecond), 4, "the size must be halved at most")
+		assert.LessOrEqual(t, len(processList(t, streamer, 5*time.Millisecond)), 2)
+		assert.LessOrEqual(t, streamer.GetStats().BatchSize, int64(2))
+	})
+
+	t.Run("translated", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		batchSize := uint64(10)
+		fixed := dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:      dbantest.NewSliceStreamable(numbers(100)),
+			KeyValueQ:   kvQ,
+			KeyValueKey: "adaptive-translated",
+			BatchSize:   &batchSize,
+		})
+		_, err := fixed.FormList()
+		require.NoError(t, err)
+
+		adaptive := newStreamer("adaptive-translated", kvQ, 4, dban.AdaptiveBatchConfig{
+			MinSize:             1,
+			MaxSize:             16,
+			TargetBatchDuration: time.Hour,
+		})
+		list, err := adaptive.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{11, 12}, list, "the page of the fixed size must be translated to the offset")
+		assert.Equal(t, "12", kvQ.MustGet("adaptive-translated").Value)
+	})
+
+	t.Run("process all", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := newStreamer("adaptive-all", kvQ, 7, dban.AdaptiveBatchConfig{
+			MinSize:             1,
+			MaxSize:             64,
+			TargetBatchDuration: time.Hour,
+		})
+		report, err := streamer.ProcessAll(func(context.Context, int) error { return nil })
+		require.NoError(t, err)
+		assert.Equal(t, uint64(100), report.Entities)
+		assert.Equal(t, "100", kvQ.MustGet("adaptive-all").Value, "the cursor must be left right past the last entity")
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		_, err := newStreamer("adaptive-invalid", dbantest.NewMemoryKeyValueQ(), 4, dban.AdaptiveBatchConfig{
+			MaxSize:             4,
+			TargetBatchDuration: time.Second,
+		}).FormList()
+		assert.ErrorContains(t, err, "MinSize must be at least 1")
+
+		descending := dban.Descending
+		batchSize := uint64(4)
+		_, err = dban.NewStreamerChecked(dban.StreamerInitParams[int]{
+			Stream:        dbantest.NewSliceStreamable(numbers(4)),
+			KeyValueQ:     dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey:   "adaptive-invalid",
+			BatchSize:     &batchSize,
+			Direction:     &descending,
+			StartPage:     &batchSize,
+			AdaptiveBatch: &dban.AdaptiveBatchConfig{MinSize: 1, MaxSize: 4, TargetBatchDuration: time.Second},
+		})
+		assert.ErrorContains(t, err, "AdaptiveBatch requires CursorLockAndUpdate")
+	})
+}
@@ -0,0 +1,53 @@
+package dban
+
+import (
+	"context"
+)
+
+func (s *streamer[T]) FormListAndProcessBatch(fn func(ctx context.Context, batch []T) error) error {
+	_, _, _, err := s.formListAndProcess(wholeList(fn))
+	if stopped(err) {
+		return nil
+	}
+	return err
+}
+
+// wholeList makes a list processor passing the entities not delivered yet and accepted by
+// Filter to fn at once
+func wholeList[T any](fn func(ctx context.Context, batch []T) error) listProcessor[T] {
+	return func(s *streamer[T], page uint64, entities []T, completed *BatchCompleted, _ *BatchErrors) error {
+		if err := s.Ctx.Err(); err != nil {
+			return s.fail(StageProcess, page, err)
+		}
+
+		batch := entities
+		if s.dedup != nil || s.Filter != nil {
+			batch = make([]T, 0, len(entities))
+			for _, entity := range entities {
+				if s.redelivered(entity) {
+					continue
+				}
+				if s.filteredOut(entity) {
+					completed.Filtered++
+					continue
+				}
+				batch = append(batch, entity)
+			}
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := s.recovered(func() error { return fn(s.Ctx, batch) }); err != nil {
+			if !stopped(err) {
+				completed.Failed += len(batch)
+			}
+			return s.fail(StageProcess, page, err)
+		}
+		for _, entity := range batch {
+			s.delivered(entity)
+		}
+		completed.Processed += len(batch)
+		return nil
+	}
+}
@@ -0,0 +1,86 @@
+package dban
+
+import (
+	"math"
+	"strconv"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// batchSizeKeySuffix is appended to the cursor key to form a key storing the batch
+// size the cursor was counted with
+const batchSizeKeySuffix = ":batch_size"
+
+// BatchSizePolicy defines what the streamer does when its batch size differs from the
+// one its stored cursor was counted with
+type BatchSizePolicy int
+
+const (
+	// BatchSizeTranslate makes the streamer translate the cursor to the page containing
+	// the same item under the new batch size. Items between the start of that page and
+	// the item are processed again rather than skipped
+	BatchSizeTranslate BatchSizePolicy = iota
+	// BatchSizeFail makes the streamer fail with ErrBatchSizeChanged
+	BatchSizeFail
+)
+
+// reconcileBatchSize compares the batch size of the streamer with the one stored along
+// with the cursor and returns the page to continue from
+func (s *streamer[T]) reconcileBatchSize(page uint64) (uint64, error) {
+	key := s.KeyValueKey + batchSizeKeySuffix
+	fields := logan.F{
+		"key":        s.KeyValueKey,
+		"page":       page,
+		"batch_size": s.BatchSize,
+	}
+
+	storedKV, err := s.KeyValueQ.Get(key)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get batch size of the cursor", fields)
+	}
+	if storedKV == nil {
+		// cursors written before the batch size was stored are assumed to match it
+		return page, s.storeBatchSize()
+	}
+
+	stored, err := strconv.ParseUint(storedKV.Value, 10, 64)
+	if err != nil || stored == 0 {
+		return 0, errors.From(&CorruptCursorError{Value: storedKV.Value, Err: errors.New("invalid batch size")}, fields)
+	}
+	if stored == s.BatchSize {
+		return page, nil
+	}
+
+	fields["stored_batch_size"] = stored
+	if s.BatchSizePolicy == BatchSizeFail {
+		return 0, errors.From(ErrBatchSizeChanged, fields)
+	}
+	if page > math.MaxUint64/stored {
+		return 0, errors.From(&CorruptCursorError{Value: strconv.FormatUint(page, 10), Err: errors.New("cursor is out of range")}, fields)
+	}
+
+	translated := page * stored / s.BatchSize
+	if err = s.KeyValueQ.Upsert(KeyValue{Key: s.KeyValueKey, Value: strconv.FormatUint(translated, 10)}); err != nil {
+		return 0, errors.Wrap(err, "failed to write translated cursor", fields)
+	}
+	if err = s.storeBatchSize(); err != nil {
+		return 0, err
+	}
+
+	if s.Log != nil {
+		s.Log.WithFields(fields.Add("translated_page", translated)).Warn("Batch size changed, cursor translated")
+	}
+	return translated, nil
+}
+
+func (s *streamer[T]) storeBatchSize() error {
+	err := s.KeyValueQ.Upsert(KeyValue{
+		Key:   s.KeyValueKey + batchSizeKeySuffix,
+		Value: strconv.FormatUint(s.BatchSize, 10),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to store batch size of the cursor", logan.F{"key": s.KeyValueKey})
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerFormListAndProcessBatch(t *testing.T) {
+	newStreamer := func(items []int, kvQ dban.KeyValueQ) dban.Streamer[int] {
+		batchSize := uint64(2)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:                 dbantest.NewSliceStreamable(items),
+			KeyValueQ:              kvQ,
+			KeyValueKey:            cursorKey,
+			BatchSize:              &batchSize,
+			AdvanceAfterProcessing: true,
+		})
+	}
+
+	t.Run("batches", func(t *testing.T) {
+		streamer := newStreamer([]int{1, 2, 3}, dbantest.NewMemoryKeyValueQ())
+		var batches [][]int
+		for i := 0; i < 2; i++ {
+			require.NoError(t, streamer.FormListAndProcessBatch(func(_ context.Context, batch []int) error {
+				batches = append(batches, batch)
+				return nil
+			}))
+		}
+		assert.Equal(t, [][]int{{1, 2}, {3}}, batches)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		err := newStreamer(nil, dbantest.NewMemoryKeyValueQ()).FormListAndProcessBatch(func(context.Context, []int) error {
+			t.Fatal("an empty batch is not expected to be processed")
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "0"}))
+		failure := errors.New("boom")
+		err := newStreamer([]int{1, 2, 3}, kvQ).FormListAndProcessBatch(func(context.Context, []int) error {
+			return failure
+		})
+
+		var streamErr *dban.StreamError
+		require.ErrorAs(t, err, &streamErr)
+		assert.Equal(t, dban.StageProcess, streamErr.Stage)
+		assert.Equal(t, -1, streamErr.EntityIndex)
+		assert.True(t, dban.Is(err, failure))
+		assert.Equal(t, "0", kvQ.MustGet(cursorKey).Value)
+	})
+}
@@ -0,0 +1,272 @@
+package dban
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+const (
+	// failuresKeySuffix is appended to the cursor key to form a key of the number of lists
+	// failed in a row (see CircuitBreaker)
+	failuresKeySuffix = ":failures"
+	// breakerKeySuffix is appended to the cursor key to form a key of the time the circuit
+	// breaker was opened at
+	breakerKeySuffix = ":breaker"
+)
+
+// CircuitBreakerConfig makes a streamer stop forming lists for a while once that many of
+// them failed in a row, e.g. while a dependency of the processing function is down (see
+// NewStreamer)
+type CircuitBreakerConfig struct {
+	// Threshold is the number of lists failed in a row that opens the breaker
+	Threshold uint64
+	// CoolDown is the time the breaker stays open before a probe list is let through
+	CoolDown time.Duration
+}
+
+func (c CircuitBreakerConfig) validate() error {
+	switch {
+	case c.Threshold == 0:
+		return errors.New("CircuitBreaker.Threshold must be at least 1")
+	case c.CoolDown <= 0:
+		return errors.New("CircuitBreaker.CoolDown must be positive")
+	}
+	return nil
+}
+
+// CircuitState is a state of the circuit breaker of a streamer
+type CircuitState string
+
+const (
+	// CircuitClosed lets every list through
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen rejects lists with CircuitOpenError until the cool-down passes
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen lets a single probe list through, which closes the breaker once it
+	// succeeds and opens it again otherwise
+	CircuitHalfOpen CircuitState = "half_open"
+)
+
+// CircuitOpenError is returned by the processing methods of a streamer whose circuit
+// breaker is open
+type CircuitOpenError struct {
+	// Key is the cursor key of the streamer
+	Key string
+	// Until is the time a probe list is let through at
+	Until time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker of streamer %s is open until %s", e.Key, e.Until.Format(time.RFC3339))
+}
+
+// Is makes CircuitOpenError match ErrCircuitOpen
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// breakerState is the state of the circuit breaker as stored in the key value storage
+type breakerState struct {
+	failures uint64
+	// openedAt is the raw value of the breaker key, empty if there is none
+	openedAt string
+	until    time.Time
+}
+
+func (s *streamer[T]) BreakerState() (CircuitState, error) {
+	if s.err != nil {
+		return "", errors.Wrap(s.err, "invalid streamer")
+	}
+	if s.breaker == nil {
+		return CircuitClosed, nil
+	}
+
+	state, err := s.readBreaker()
+	if err != nil {
+		return "", err
+	}
+	return s.circuitState(state), nil
+}
+
+func (s *streamer[T]) ResetBreaker() error {
+	if s.err != nil {
+		return errors.Wrap(s.err, "invalid streamer")
+	}
+	for _, key := range []string{s.KeyValueKey + failuresKeySuffix, s.KeyValueKey + breakerKeySuffix} {
+		if err := s.KeyValueQ.Delete(key); err != nil {
+			return errors.Wrap(err, "failed to reset circuit breaker", logan.F{"key": key})
+		}
+	}
+	if s.Log != nil {
+		s.Log.WithField("key", s.KeyValueKey).Info("Circuit breaker reset")
+	}
+	return nil
+}
+
+// circuitState tells the state of the breaker at the time of the clock
+func (s *streamer[T]) circuitState(state breakerState) CircuitState {
+	switch {
+	case state.failures < s.breaker.Threshold:
+		return CircuitClosed
+	case s.Clock.Now().Before(state.until):
+		return CircuitOpen
+	default:
+		return CircuitHalfOpen
+	}
+}
+
+// readBreaker reads the number of lists failed in a row and, once it reaches the
+// threshold, the time the breaker was opened at
+func (s *streamer[T]) readBreaker() (breakerState, error) {
+	var state breakerState
+	failures, err := s.KeyValueQ.Get(s.KeyValueKey + failuresKeySuffix)
+	if err != nil {
+		return state, errors.Wrap(err, "failed to get failures", logan.F{"key": s.KeyValueKey})
+	}
+	if failures == nil {
+		return state, nil
+	}
+	if state.failures, err = strconv.ParseUint(failures.Value, 10, 64); err != nil {
+		return state, errors.Wrap(err, "failed to parse failures", logan.F{"key": s.KeyValueKey, "value": failures.Value})
+	}
+	if state.failures < s.breaker.Threshold {
+		return state, nil
+	}
+
+	openedAt, err := s.KeyValueQ.Get(s.KeyValueKey + breakerKeySuffix)
+	if err != nil {
+		return state, errors.Wrap(err, "failed to get circuit breaker", logan.F{"key": s.KeyValueKey})
+	}
+	if openedAt == nil {
+		// the breaker opened by the last failure is let through right away
+		return state, nil
+	}
+	opened, err := time.Parse(time.RFC3339Nano, openedAt.Value)
+	if err != nil {
+		return state, errors.Wrap(err, "failed to parse circuit breaker", logan.F{"key": s.KeyValueKey, "value": openedAt.Value})
+	}
+	state.openedAt, state.until = openedAt.Value, opened.Add(s.breaker.CoolDown)
+	return state, nil
+}
+
+// checkBreaker fails with CircuitOpenError if the breaker is open. Once it is half-open,
+// the streamer claims the probe by opening it again, so that the other instances sharing
+// the cursor wait for the outcome of the probe, with a querier implementing
+// CompareAndSwapper at least. It returns the number of lists failed in a row otherwise
+func (s *streamer[T]) checkBreaker() (uint64, error) {
+	if s.breaker == nil {
+		return 0, nil
+	}
+	state, err := s.readBreaker()
+	if err != nil {
+		return 0, err
+	}
+
+	switch s.circuitState(state) {
+	case CircuitOpen:
+		return 0, &CircuitOpenError{Key: s.KeyValueKey, Until: state.until}
+	case CircuitHalfOpen:
+		now := s.Clock.Now()
+		claimed, err := s.openBreaker(state.openedAt, now)
+		if err != nil {
+			return 0, err
+		}
+		if !claimed {
+			return 0, &CircuitOpenError{Key: s.KeyValueKey, Until: now.Add(s.breaker.CoolDown)}
+		}
+		if s.Log != nil {
+			s.Log.WithField("key", s.KeyValueKey).Info("Circuit breaker is half-open, probing")
+		}
+	}
+	return state.failures, nil
+}
+
+// openBreaker stores the time the breaker is opened at, replacing openedAt, and reports
+// whether it was not replaced by another instance meanwhile
+func (s *streamer[T]) openBreaker(openedAt string, now time.Time) (bool, error) {
+	key, value := s.KeyValueKey+breakerKeySuffix, now.UTC().Format(time.RFC3339Nano)
+	fields := logan.F{"key": s.KeyValueKey}
+
+	if swapper, ok := s.KeyValueQ.(CompareAndSwapper); ok {
+		var (
+			claimed bool
+			err     error
+		)
+		if openedAt == "" {
+			claimed, err = swapper.InsertIfAbsent(KeyValue{Key: key, Value: value})
+		} else {
+			claimed, err = swapper.UpdateIfEquals(key, openedAt, value)
+		}
+		if err != nil {
+			return false, errors.Wrap(err, "failed to open circuit breaker", fields)
+		}
+		return claimed, nil
+	}
+
+	if err := s.KeyValueQ.Upsert(KeyValue{Key: key, Value: value}); err != nil {
+		return false, errors.Wrap(err, "failed to open circuit breaker", fields)
+	}
+	return true, nil
+}
+
+// recordBreaker counts the list failed with err, opening the breaker once the threshold is
+// reached, or resets the count once a list succeeds after failures. Lists stopped,
+// interrupted or not formed as the streamer is paused or its breaker is open are not
+// counted. A failure to record the outcome is logged, as the list is done with anyway
+func (s *streamer[T]) recordBreaker(failures uint64, err error) {
+	if s.breaker == nil || stopped(err) || Is(err, ErrInterrupted) || Is(err, ErrStreamerPaused) || Is(err, ErrCircuitOpen) {
+		return
+	}
+
+	var recordErr error
+	if err == nil {
+		if failures != 0 {
+			recordErr = s.ResetBreaker()
+		}
+	} else {
+		recordErr = s.countFailure(failures)
+	}
+	if recordErr != nil && s.Log != nil {
+		s.Log.WithError(recordErr).WithField("key", s.KeyValueKey).Error("Failed to record outcome for circuit breaker")
+	}
+}
+
+// countFailure increments the number of lists failed in a row and opens the breaker once
+// it reaches the threshold
+func (s *streamer[T]) countFailure(failures uint64) error {
+	key := s.KeyValueKey + failuresKeySuffix
+	if counter, ok := s.KeyValueQ.(AtomicCounter); ok {
+		n, err := counter.IncrementAndGet(key, 1)
+		if err != nil {
+			return errors.Wrap(err, "failed to count failure", logan.F{"key": s.KeyValueKey})
+		}
+		failures = uint64(n)
+	} else {
+		failures++
+		if err := s.KeyValueQ.Upsert(KeyValue{Key: key, Value: strconv.FormatUint(failures, 10)}); err != nil {
+			return errors.Wrap(err, "failed to count failure", logan.F{"key": s.KeyValueKey})
+		}
+	}
+	if failures < s.breaker.Threshold {
+		return nil
+	}
+
+	if err := s.KeyValueQ.Upsert(KeyValue{
+		Key:   s.KeyValueKey + breakerKeySuffix,
+		Value: s.Clock.Now().UTC().Format(time.RFC3339Nano),
+	}); err != nil {
+		return errors.Wrap(err, "failed to open circuit breaker", logan.F{"key": s.KeyValueKey})
+	}
+	if s.Log != nil {
+		s.Log.WithFields(logan.F{
+			"key":       s.KeyValueKey,
+			"failures":  failures,
+			"cool_down": s.breaker.CoolDown,
+		}).Warn("Circuit breaker opened")
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	logan "gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+func TestStreamerCircuitBreaker(t *testing.T) {
+	start := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)
+	newStreamer := func(kvQ dban.KeyValueQ, clock dban.Clock) dban.Streamer[int] {
+		batchSize := uint64(1)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:         dbantest.NewSliceStreamable([]int{1, 2, 3}),
+			KeyValueQ:      kvQ,
+			KeyValueKey:    "breaker",
+			BatchSize:      &batchSize,
+			Clock:          clock,
+			CircuitBreaker: &dban.CircuitBreakerConfig{Threshold: 2, CoolDown: time.Minute},
+		})
+	}
+	setNow := func(clock *fakeClock, now time.Time) {
+		clock.mu.Lock()
+		defer clock.mu.Unlock()
+		clock.now = now
+	}
+	failure := errors.New("dependency is down")
+	fail := func(context.Context, int) error { return failure }
+	succeed := func(context.Context, int) error { return nil }
+	assertState := func(t *testing.T, s dban.Streamer[int], expected dban.CircuitState) {
+		state, err := s.BreakerState()
+		require.NoError(t, err)
+		assert.Equal(t, expected, state)
+	}
+
+	t.Run("opens and closes", func(t *testing.T) {
+		kvQ, clock := dbantest.NewMemoryKeyValueQ(), &fakeClock{now: start}
+		streamer := newStreamer(kvQ, clock)
+
+		require.Error(t, streamer.FormListAndProcess(fail))
+		assertState(t, streamer, dban.CircuitClosed)
+		assert.Equal(t, "1", kvQ.MustGet("breaker:failures").Value)
+
+		require.Error(t, streamer.FormListAndProcess(fail))
+		assertState(t, streamer, dban.CircuitOpen)
+		replica := newStreamer(kvQ, clock)
+		assertState(t, replica, dban.CircuitOpen)
+		before, err := replica.GetCurrentPage()
+		require.NoError(t, err)
+
+		err = replica.FormListAndProcess(succeed)
+		require.True(t, dban.Is(err, dban.ErrCircuitOpen), "%v", err)
+		open, ok := logan.Cause(err).(*dban.CircuitOpenError)
+		require.True(t, ok)
+		assert.Equal(t, "breaker", open.Key)
+		assert.True(t, start.Add(time.Minute).Equal(open.Until))
+		page, err := replica.GetCurrentPage()
+		require.NoError(t, err)
+		assert.Equal(t, before, page, "no list must be formed while the breaker is open")
+
+		setNow(clock, start.Add(time.Minute))
+		assertState(t, streamer, dban.CircuitHalfOpen)
+		require.Error(t, streamer.FormListAndProcess(fail), "the probe fails")
+		assertState(t, streamer, dban.CircuitOpen)
+
+		setNow(clock, start.Add(2*time.Minute))
+		require.NoError(t, replica.FormListAndProcess(succeed), "the probe succeeds")
+		assertState(t, streamer, dban.CircuitClosed)
+		for _, key := range []string{"breaker:failures", "breaker:breaker"} {
+			kv, err := kvQ.Get(key)
+			require.NoError(t, err)
+			assert.Nil(t, kv, "%s must be reset", key)
+		}
+	})
+
+	t.Run("single probe", func(t *testing.T) {
+		kvQ, clock := dbantest.NewMemoryKeyValueQ(), &fakeClock{now: start}
+		streamer, replica := newStreamer(kvQ, clock), newStreamer(kvQ, clock)
+		for i := 0; i < 2; i++ {
+			require.Error(t, streamer.FormListAndProcess(fail))
+		}
+
+		setNow(clock, start.Add(time.Minute))
+		probing, release := make(chan struct{}), make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- streamer.FormListAndProcess(func(context.Context, int) error {
+				close(probing)
+				<-release
+				return nil
+			})
+		}()
+		<-probing
+		err := replica.FormListAndProcess(succeed)
+		assert.True(t, dban.Is(err, dban.ErrCircuitOpen), "only one probe must be let through: %v", err)
+		close(release)
+		require.NoError(t, <-done)
+		assertState(t, replica, dban.CircuitClosed)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		kvQ, clock := dbantest.NewMemoryKeyValueQ(), &fakeClock{now: start}
+		streamer := newStreamer(kvQ, clock)
+		for i := 0; i < 2; i++ {
+			require.Error(t, streamer.FormListAndProcess(fail))
+		}
+		assertState(t, streamer, dban.CircuitOpen)
+
+		require.NoError(t, streamer.ResetBreaker())
+		assertState(t, streamer, dban.CircuitClosed)
+		require.NoError(t, streamer.FormListAndProcess(succeed))
+	})
+
+	t.Run("run waits for cool-down", func(t *testing.T) {
+		kvQ, clock := dbantest.NewMemoryKeyValueQ(), &fakeClock{now: start}
+		streamer := newStreamer(kvQ, clock)
+		for i := 0; i < 2; i++ {
+			require.Error(t, streamer.FormListAndProcess(fail))
+		}
+
+		processed := make(chan int, 3)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- streamer.Run(ctx, func(_ context.Context, i int) error {
+				processed <- i
+				return nil
+			}, dban.RunConfig{FailFast: true})
+		}()
+
+		assert.True(t, start.Add(time.Minute).Equal(clock.advanceToNext(t)), "Run must wait for the cool-down")
+		assert.Equal(t, 3, <-processed, "the lists failed are moved past")
+		cancel()
+		require.NoError(t, <-done)
+		assertState(t, streamer, dban.CircuitClosed)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:      dbantest.NewSliceStreamable([]int{1}),
+			KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey: "breaker-disabled",
+		})
+		for i := 0; i < 3; i++ {
+			require.Error(t, streamer.FormListAndProcess(fail))
+		}
+		assertState(t, streamer, dban.CircuitClosed)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, cfg := range []dban.CircuitBreakerConfig{{CoolDown: time.Minute}, {Threshold: 1}} {
+			_, err := dban.NewStreamerChecked(dban.StreamerInitParams[int]{
+				Stream:         dbantest.NewSliceStreamable([]int{1}),
+				KeyValueQ:      dbantest.NewMemoryKeyValueQ(),
+				KeyValueKey:    "breaker-invalid",
+				CircuitBreaker: &cfg,
+			})
+			assert.Error(t, err)
+		}
+	})
+}
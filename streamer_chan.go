@@ -0,0 +1,85 @@
+package dban
+
+import (
+	"context"
+
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// Stream sends the entities page by page on the first channel until the end of the stream is
+// reached, leaving the cursor there as ProcessAll does, or ctx is canceled, then closes both
+// channels. A failure is sent on the second channel before they are closed, while a
+// cancellation is not. The cursor is read and kept locked (with a TransactionalKeyValueQ)
+// while the entities of a page are sent, and is moved past the page only once all of them
+// were received, so StreamBufferSize is the number of entities sent ahead of the consumer.
+// A consumer abandoning the channel has to cancel ctx: the page it was in the middle of is
+// not marked as processed then, and is sent again by the next call
+func (s *streamer[T]) Stream(ctx context.Context) (<-chan T, <-chan error) {
+	entities := make(chan T, s.StreamBufferSize)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(entities)
+
+		err := s.deliverAll(ctx, func(entity T) error {
+			select {
+			case entities <- entity:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			errs <- s.failed(err)
+		}
+	}()
+	return entities, errs
+}
+
+// deliverAll delivers the entities page by page until the end of the stream is reached,
+// leaving the cursor there as ProcessAll does. The cursor is moved past a page once all of
+// its entities are delivered, so an error deliver returns stops it, leaving the page to be
+// delivered again
+func (s *streamer[T]) deliverAll(ctx context.Context, deliver func(entity T) error) error {
+	if s.err != nil {
+		return errors.Wrap(s.err, "invalid streamer")
+	}
+	if s.CursorMode != CursorLockAndUpdate || s.PageConcurrency > 1 {
+		return errors.New("delivering entities one by one requires CursorLockAndUpdate")
+	}
+	if s.descending {
+		return errors.New("delivering entities one by one is not supported by descending streamers")
+	}
+
+	drain := *s
+	drain.Ctx = ctx
+	drain.KeyValueQ = withContext(s.KeyValueQ, ctx)
+	drain.draining = true
+	deliverPage := func(s *streamer[T], page uint64, entities []T, completed *BatchCompleted, _ *BatchErrors) error {
+		for i, entity := range entities {
+			if s.redelivered(entity) {
+				continue
+			}
+			if err := deliver(entity); err != nil {
+				return s.failEntity(page, i, err)
+			}
+			s.delivered(entity)
+			completed.Processed++
+		}
+		return nil
+	}
+
+	for {
+		page, formed, _, err := drain.formAndProcessHeld(func(*streamer[T]) listProcessor[T] {
+			return deliverPage
+		})
+		if err != nil || formed == 0 {
+			return err
+		}
+		if uint64(formed)%s.BatchSize != 0 {
+			// the entities added to the last page later would be skipped if the cursor was
+			// left past it
+			return drain.rewind(page)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerStream(t *testing.T) {
+	newStreamer := func(stream dban.Streamable[int], kvQ dban.KeyValueQ, bufferSize int) dban.Streamer[int] {
+		batchSize := uint64(2)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:           stream,
+			KeyValueQ:        kvQ,
+			KeyValueKey:      cursorKey,
+			BatchSize:        &batchSize,
+			StreamBufferSize: bufferSize,
+		})
+	}
+
+	t.Run("to the end", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		entities, errs := newStreamer(dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5}), kvQ, 1).Stream(context.Background())
+
+		var received []int
+		for entity := range entities {
+			received = append(received, entity)
+		}
+		assert.Equal(t, []int{1, 2, 3, 4, 5}, received)
+		assert.NoError(t, <-errs)
+		// the last page is not full, so it is sent again by the next call
+		assert.Equal(t, "2", kvQ.MustGet(cursorKey).Value)
+	})
+
+	t.Run("abandoned", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "1"}))
+		ctx, cancel := context.WithCancel(context.Background())
+		entities, errs := newStreamer(dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5}), kvQ, 0).Stream(ctx)
+
+		// the channel is unbuffered, so the next entity of the page is not sent until received
+		assert.Equal(t, 3, <-entities)
+		cancel()
+		_, ok := <-errs
+		assert.False(t, ok, "a cancellation is not expected to be sent as an error")
+		assert.Equal(t, "1", kvQ.MustGet(cursorKey).Value, "the page abandoned is not expected to be passed")
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		failure := errors.New("boom")
+		stream := dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5}, dbantest.WithPageError(1, failure))
+		entities, errs := newStreamer(stream, dbantest.NewMemoryKeyValueQ(), 1).Stream(context.Background())
+
+		var received []int
+		for entity := range entities {
+			received = append(received, entity)
+		}
+		assert.Equal(t, []int{1, 2}, received)
+		err := <-errs
+		var streamErr *dban.StreamError
+		require.ErrorAs(t, err, &streamErr)
+		assert.Equal(t, dban.StageSelect, streamErr.Stage)
+		assert.True(t, dban.Is(err, failure))
+	})
+}
@@ -0,0 +1,167 @@
+package dban
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// checkpoint keeps the cursor of a streamer in memory between the writes made once every
+// few batches (see StreamerInitParams.CheckpointEveryNBatches) or once every sync interval
+// (see StreamerInitParams.CursorCacheSync)
+type checkpoint struct {
+	every uint64
+	// sync makes the cursor be written once that much time passed since the last write and
+	// read from the storage only until it is known
+	sync  time.Duration
+	clock Clock
+	// kvQ is the querier not bound to the context of the streamer, so that the cursor
+	// could be flushed once the context is done
+	kvQ KeyValueQ
+	key string
+
+	mu sync.Mutex
+	// page is the cursor to write if pending is set
+	page    uint64
+	pending bool
+	// batches is the number of batches taken since the cursor was persisted
+	batches uint64
+	// cached is set once page is the cursor known to be persisted, so that it is not read
+	// again (see CursorCacheSync)
+	cached   bool
+	syncedAt time.Time
+}
+
+func newCheckpoint(every *uint64, sync *time.Duration, clock Clock, kvQ KeyValueQ, key string) *checkpoint {
+	c := &checkpoint{kvQ: kvQ, key: key, clock: clock}
+	if every != nil && *every > 1 {
+		c.every = *every
+	}
+	if sync != nil && *sync > 0 {
+		c.sync = *sync
+	}
+	if c.every == 0 && c.sync == 0 {
+		return nil
+	}
+	return c
+}
+
+// current returns the cursor kept in memory, if any
+func (c *checkpoint) current() (uint64, bool) {
+	if c == nil {
+		return 0, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.page, c.pending || c.cached
+}
+
+// unflushed returns the cursor that is not persisted yet, if any
+func (c *checkpoint) unflushed() (uint64, bool) {
+	if c == nil {
+		return 0, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.page, c.pending
+}
+
+// load keeps page read from the storage as the cursor, so that it is not read again with
+// CursorCacheSync
+func (c *checkpoint) load(page uint64) {
+	if c == nil || c.sync == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.cached {
+		c.page, c.cached, c.syncedAt = page, true, c.clock.Now()
+	}
+}
+
+// forget drops the cursor kept in memory, so that it is read from the storage again, e.g.
+// once it is set with SetPage
+func (c *checkpoint) forget() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending, c.cached, c.batches = false, false, 0
+}
+
+// keep keeps page as the cursor in memory and reports true, unless it is time for a
+// checkpoint. The cursor is left intact then until it is persisted
+func (c *checkpoint) keep(page uint64) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.every > 1 && c.batches+1 >= c.every {
+		return false
+	}
+	if c.sync > 0 && c.clock.Now().Sub(c.syncedAt) >= c.sync {
+		return false
+	}
+	c.page, c.pending = page, true
+	c.batches++
+	return true
+}
+
+// persisted records that the cursor was written as page
+func (c *checkpoint) persisted(page uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending, c.batches = false, 0
+	if c.sync > 0 {
+		c.page, c.cached, c.syncedAt = page, true, c.clock.Now()
+	}
+}
+
+// advanceCursor moves the cursor past a batch to page, writing it once every
+// CheckpointEveryNBatches batches or CursorCacheSync only
+func (s *streamer[T]) advanceCursor(page uint64) error {
+	if s.checkpoint.keep(page) {
+		return nil
+	}
+	return s.writeCursor(page)
+}
+
+func (s *streamer[T]) Flush() error {
+	if s.err != nil {
+		return errors.Wrap(s.err, "invalid streamer")
+	}
+	page, pending := s.checkpoint.unflushed()
+	if !pending {
+		return nil
+	}
+
+	err := s.checkpoint.kvQ.Upsert(KeyValue{Key: s.KeyValueKey, Value: strconv.FormatUint(page, 10)})
+	if err != nil {
+		return errors.Wrap(err, "failed to flush cursor", logan.F{"key": s.KeyValueKey, "page": page})
+	}
+	s.checkpoint.persisted(page)
+	return nil
+}
+
+// flushed flushes the cursor once err is returned, returning the failure of the flush
+// unless there is err already
+func (s *streamer[T]) flushed(err error) error {
+	if flushErr := s.Flush(); err == nil {
+		return flushErr
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerCheckpoint(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5, 6, 7}
+	newStreamer := func(kvQ dban.KeyValueQ) dban.Streamer[int] {
+		batchSize, every := uint64(1), uint64(3)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:                  dbantest.NewSliceStreamable(items),
+			KeyValueQ:               kvQ,
+			KeyValueKey:             cursorKey,
+			BatchSize:               &batchSize,
+			CheckpointEveryNBatches: &every,
+		})
+	}
+	stored := func(t *testing.T, kvQ dban.KeyValueQ) string {
+		kv, err := kvQ.Get(cursorKey)
+		require.NoError(t, err)
+		require.NotNil(t, kv)
+		return kv.Value
+	}
+
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	streamer := newStreamer(kvQ)
+	for i, expected := range []string{"0", "0", "3", "3"} {
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{items[i]}, list)
+		assert.Equal(t, expected, stored(t, kvQ), "the cursor must be written every 3 batches")
+	}
+	page, err := streamer.GetCurrentPage()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), page)
+
+	t.Run("crashed", func(t *testing.T) {
+		list, err := newStreamer(kvQ).FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{4}, list, "the pages taken since the checkpoint must be taken again")
+	})
+
+	t.Run("flushed", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := newStreamer(kvQ)
+		_, err := streamer.FormList()
+		require.NoError(t, err)
+		require.NoError(t, streamer.Flush())
+		assert.Equal(t, "1", stored(t, kvQ))
+		require.NoError(t, streamer.Flush(), "nothing is left to flush")
+	})
+
+	t.Run("wrapped around", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "6"}))
+		streamer := newStreamer(kvQ)
+		for range []int{7, 1} {
+			_, err := streamer.FormList()
+			require.NoError(t, err)
+		}
+		assert.Equal(t, "0", stored(t, kvQ), "the reset must be written right away")
+	})
+
+	t.Run("ProcessAll failed", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		failure := errors.New("boom")
+		_, err := newStreamer(kvQ).ProcessAll(func(_ context.Context, i int) error {
+			if i == 5 {
+				return failure
+			}
+			return nil
+		})
+		assert.True(t, dban.Is(err, failure))
+		assert.Equal(t, "5", stored(t, kvQ), "the cursor must be flushed on exit")
+	})
+}
+
+func TestStreamerCursorCacheSync(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5, 6, 7}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newStreamer := func(kvQ dban.KeyValueQ, clock *fakeClock) dban.Streamer[int] {
+		batchSize, sync := uint64(1), time.Minute
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:          dbantest.NewSliceStreamable(items),
+			KeyValueQ:       kvQ,
+			KeyValueKey:     cursorKey,
+			BatchSize:       &batchSize,
+			Clock:           clock,
+			CursorCacheSync: &sync,
+		})
+	}
+	stored := func(t *testing.T, kvQ dban.KeyValueQ) string {
+		kv, err := kvQ.Get(cursorKey)
+		require.NoError(t, err)
+		require.NotNil(t, kv)
+		return kv.Value
+	}
+	formList := func(t *testing.T, s dban.Streamer[int]) []int {
+		list, err := s.FormList()
+		require.NoError(t, err)
+		return list
+	}
+
+	kvQ, clock := dbantest.NewMemoryKeyValueQ(), &fakeClock{now: start}
+	streamer := newStreamer(kvQ, clock)
+	assert.Equal(t, []int{1}, formList(t, streamer))
+	assert.Equal(t, []int{2}, formList(t, streamer))
+	assert.Equal(t, "0", stored(t, kvQ), "the cursor must not be written within the sync interval")
+	page, err := streamer.GetCurrentPage()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), page)
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "5"}))
+	assert.Equal(t, []int{3}, formList(t, streamer), "the cursor must be read once")
+
+	clock.mu.Lock()
+	clock.now = start.Add(time.Minute)
+	clock.mu.Unlock()
+	assert.Equal(t, []int{4}, formList(t, streamer))
+	assert.Equal(t, "4", stored(t, kvQ), "the cursor must be written once the sync interval passed")
+
+	require.NoError(t, streamer.SetPage(6))
+	assert.Equal(t, []int{7}, formList(t, streamer), "the cursor must be read again once it is set")
+	require.NoError(t, streamer.Flush())
+	assert.Equal(t, "7", stored(t, kvQ), "the cursor must be written on Flush")
+
+	t.Run("Cooperative", func(t *testing.T) {
+		sync := time.Minute
+		_, err := dban.NewStreamerChecked(dban.StreamerInitParams[int]{
+			Stream:          dbantest.NewSliceStreamable(items),
+			KeyValueQ:       dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey:     cursorKey,
+			Cooperative:     true,
+			CursorCacheSync: &sync,
+		})
+		assert.Error(t, err, "the cursor kept in memory could not be shared by instances")
+	})
+}
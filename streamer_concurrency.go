@@ -0,0 +1,123 @@
+package dban
+
+import (
+	"fmt"
+	"sync"
+
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// UnprocessedPagesError is returned when some of the pages reserved by a streamer with
+// PageConcurrency failed to be selected. Entities of the pages preceding the first failed
+// one are processed, while the rest of the pages are listed, so that they could be sought
+// to again. It is the Err of the StreamError returned
+type UnprocessedPagesError struct {
+	Pages []uint64
+	Err   error
+}
+
+func (e *UnprocessedPagesError) Error() string {
+	return fmt.Sprintf("pages %v were not processed: %s", e.Pages, e.Err)
+}
+
+func (e *UnprocessedPagesError) Unwrap() error {
+	return e.Err
+}
+
+// formListConcurrent reserves PageConcurrency pages with a single AdvanceCursor call and
+// selects them in parallel. Entities are returned in page order up to the first empty page,
+// which means the end of the stream, or the first failed one (see UnprocessedPagesError)
+func (s *streamer[T]) formListConcurrent() ([]T, uint64, error) {
+	if s.err != nil {
+		return nil, 0, errors.Wrap(s.err, "invalid streamer")
+	}
+
+	n := s.PageConcurrency
+	for reset := false; ; reset = true {
+		if err := s.interrupted(StageCursorRead, 0); err != nil {
+			return nil, 0, err
+		}
+		first, err := s.KeyValueQ.(CursorAdvancer).AdvanceCursor(s.KeyValueKey, n)
+		if err != nil {
+			return nil, 0, s.fail(StageCursorRead, 0, errors.Wrap(err, "failed to advance cursor"))
+		}
+		if err = s.prepare(first); err != nil {
+			return nil, 0, err
+		}
+		if err = s.interrupted(StageSelect, first); err != nil {
+			return nil, 0, err
+		}
+
+		pages := make([][]T, n)
+		errs := make([]error, n)
+		var wg sync.WaitGroup
+		for i := range pages {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				pages[i], errs[i] = s.Select(first + uint64(i))
+			}(i)
+		}
+		wg.Wait()
+
+		var (
+			entities []T
+			ended    bool
+			empty    uint64
+		)
+		for i, page := range pages {
+			if errs[i] != nil {
+				unprocessed := make([]uint64, 0, len(pages)-i)
+				for page := first + uint64(i); page < first+n; page++ {
+					unprocessed = append(unprocessed, page)
+				}
+				return entities, first, s.fail(StageSelect, unprocessed[0], &UnprocessedPagesError{
+					Pages: unprocessed,
+					Err:   errs[i],
+				})
+			}
+			if len(page) == 0 {
+				ended, empty = true, first+uint64(i)
+				break
+			}
+			entities = append(entities, page...)
+		}
+		if !ended {
+			return entities, first, nil
+		}
+
+		// One of the pages is empty, so the end of the stream is reached within the run
+		s.endOfStream(empty)
+		if len(entities) == 0 && first == 0 {
+			if s.Log != nil {
+				s.Log.Warn("Entities list is empty")
+			}
+			return nil, 0, nil
+		}
+		if s.draining {
+			return entities, first, s.rewind(empty)
+		}
+		if len(entities) == 0 && reset {
+			return nil, 0, s.fail(StageSelect, first, ErrInconsistentStream)
+		}
+		if err = s.interrupted(StageCursorWrite, first); err != nil {
+			return nil, 0, err
+		}
+
+		moved, err := s.resetClaimed(first, first+n)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !moved {
+			// another instance reached the end after this one and moves the cursor back
+			if len(entities) == 0 {
+				return nil, 0, nil
+			}
+			return entities, first, nil
+		}
+		s.emit(CursorReset{Key: s.KeyValueKey})
+		if len(entities) != 0 {
+			return entities, first, nil
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package dban_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// reversedStreamable delays earlier pages longer, so that they complete after the later ones
+type reversedStreamable struct {
+	dban.Streamable[int]
+}
+
+func (s reversedStreamable) SelectWithPageParams(params pgdb.OffsetPageParams) ([]int, error) {
+	time.Sleep(time.Duration(10-params.PageNumber%10) * time.Millisecond)
+	return s.Streamable.SelectWithPageParams(params)
+}
+
+func newConcurrentStreamer(stream dban.Streamable[int], kvQ dban.KeyValueQ) dban.Streamer[int] {
+	batchSize, concurrency := uint64(2), uint64(3)
+	return dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:          reversedStreamable{stream},
+		KeyValueQ:       kvQ,
+		KeyValueKey:     cursorKey,
+		BatchSize:       &batchSize,
+		PageConcurrency: &concurrency,
+	})
+}
+
+func TestStreamerPageConcurrency(t *testing.T) {
+	t.Run("ordering and completeness", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := newConcurrentStreamer(dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5, 6, 7, 8}), kvQ)
+
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, list)
+		assert.Equal(t, "3", kvQ.MustGet(cursorKey).Value)
+
+		// pages 4 and 5 are past the end, so the cursor wraps around
+		list, err = streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{7, 8}, list)
+		assert.Equal(t, "0", kvQ.MustGet(cursorKey).Value)
+
+		var processed []int
+		require.NoError(t, streamer.FormListAndProcess(func(_ context.Context, i int) error {
+			processed = append(processed, i)
+			return nil
+		}))
+		assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, processed)
+	})
+
+	t.Run("end of stream", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "4"}))
+
+		list, err := newConcurrentStreamer(dbantest.NewSliceStreamable([]int{1, 2, 3}), kvQ).FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 3}, list)
+		assert.Equal(t, "0", kvQ.MustGet(cursorKey).Value, "the end of the stream is within the run")
+
+		list, err = newConcurrentStreamer(dbantest.NewSliceStreamable([]int{}), kvQ).FormList()
+		require.NoError(t, err)
+		assert.Empty(t, list)
+	})
+
+	t.Run("partial failure", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		failure := errors.New("boom")
+		stream := dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5, 6}, dbantest.WithPageError(1, failure))
+
+		var processed []int
+		err := newConcurrentStreamer(stream, kvQ).FormListAndProcess(func(_ context.Context, i int) error {
+			processed = append(processed, i)
+			return nil
+		})
+		var unprocessed *dban.UnprocessedPagesError
+		require.ErrorAs(t, err, &unprocessed)
+		assert.Equal(t, []uint64{1, 2}, unprocessed.Pages)
+		assert.Equal(t, failure, errors.Cause(unprocessed.Err))
+		assert.Equal(t, []int{1, 2}, processed, "pages preceding the failed one are processed")
+		assert.Equal(t, "3", kvQ.MustGet(cursorKey).Value)
+	})
+
+	t.Run("requires cursor advancer", func(t *testing.T) {
+		kvQ := struct{ dban.KeyValueQ }{dbantest.NewMemoryKeyValueQ()}
+		_, err := newConcurrentStreamer(dbantest.NewSliceStreamable([]int{1}), kvQ).FormList()
+		assert.Error(t, err)
+	})
+}
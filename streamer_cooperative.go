@@ -0,0 +1,30 @@
+package dban
+
+import (
+	"strconv"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// resetClaimed moves the cursor back to the first page once the end of the stream is reached
+// by the page claimed with AdvanceCursor, which left the cursor at next. A cooperative
+// streamer moves it only if the cursor is still at next and reports false otherwise: another
+// instance claimed a page after it, so that instance is the one to move the cursor, and
+// the first pages are not taken twice in a pass
+func (s *streamer[T]) resetClaimed(page, next uint64) (bool, error) {
+	if !s.cooperative {
+		if err := s.KeyValueQ.Upsert(KeyValue{Key: s.KeyValueKey, Value: "0"}); err != nil {
+			return false, s.fail(StageCursorWrite, page, errors.Wrap(err, "failed to upsert last page"))
+		}
+		return true, nil
+	}
+
+	swapped, err := s.KeyValueQ.(CompareAndSwapper).UpdateIfEquals(s.KeyValueKey, strconv.FormatUint(next, 10), "0")
+	if err != nil {
+		return false, s.fail(StageCursorWrite, page, errors.Wrap(err, "failed to reset cursor", logan.F{
+			"expected": next,
+		}))
+	}
+	return swapped, nil
+}
@@ -0,0 +1,110 @@
+package dban_test
+
+import (
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+func newCooperativeStreamer(stream dban.Streamable[int], kvQ dban.KeyValueQ) dban.Streamer[int] {
+	batchSize := uint64(2)
+	return dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      stream,
+		KeyValueQ:   kvQ,
+		KeyValueKey: cursorKey,
+		BatchSize:   &batchSize,
+		Cooperative: true,
+	})
+}
+
+// hookedStreamable calls before prior to selecting the page
+type hookedStreamable struct {
+	dban.Streamable[int]
+	page   uint64
+	before func()
+}
+
+func (s hookedStreamable) SelectWithPageParams(params pgdb.OffsetPageParams) ([]int, error) {
+	if params.PageNumber == s.page {
+		s.before()
+	}
+	return s.Streamable.SelectWithPageParams(params)
+}
+
+func TestStreamerCooperative(t *testing.T) {
+	t.Run("distinct pages", func(t *testing.T) {
+		items := make([]int, 40)
+		for i := range items {
+			items[i] = i + 1
+		}
+		kvQ := dbantest.NewMemoryKeyValueQ()
+
+		var (
+			mu     sync.Mutex
+			formed []int
+			wg     sync.WaitGroup
+		)
+		for instance := 0; instance < 3; instance++ {
+			streamer := newCooperativeStreamer(dbantest.NewSliceStreamable(items), kvQ)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for call := 0; call < 5; call++ {
+					list, err := streamer.FormList()
+					assert.NoError(t, err)
+					mu.Lock()
+					formed = append(formed, list...)
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		sort.Ints(formed)
+		assert.Equal(t, items[:30], formed, "every page must be formed by one instance")
+	})
+
+	t.Run("end of the stream reached by several instances", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		stream := dbantest.NewSliceStreamable([]int{1, 2, 3, 4})
+		second := newCooperativeStreamer(stream, kvQ)
+		var secondList []int
+		first := newCooperativeStreamer(hookedStreamable{Streamable: stream, page: 2, before: func() {
+			// the second instance claims the page following the end after the first one
+			var err error
+			secondList, err = second.FormList()
+			require.NoError(t, err)
+		}}, kvQ)
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "2"}))
+
+		list, err := first.FormList()
+		require.NoError(t, err)
+		assert.Empty(t, list, "the instance that did not move the cursor back must get an empty list")
+		assert.Equal(t, []int{1, 2}, secondList, "the first page must be taken once")
+
+		list, err = first.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{3, 4}, list)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		mode, wrapAround := dban.CursorLockAndUpdate, false
+		for name, params := range map[string]dban.StreamerInitParams[int]{
+			"cursor mode": {CursorMode: &mode},
+			"wrap around": {WrapAround: &wrapAround},
+		} {
+			params.Stream = dbantest.NewSliceStreamable([]int{1})
+			params.KeyValueQ = dbantest.NewMemoryKeyValueQ()
+			params.KeyValueKey = cursorKey
+			params.Cooperative = true
+			_, err := dban.NewStreamerChecked(params)
+			assert.Error(t, err, name)
+		}
+	})
+}
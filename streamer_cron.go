@@ -0,0 +1,85 @@
+package dban
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// Clock tells the time and waits for it, so that scheduling could be tested
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// RunCron forms and processes a list at every activation of the cron spec (see ParseCron)
+// until ctx is canceled, then waits for the run in flight. An activation coming while the
+// previous run is in flight is skipped. Failed runs are logged, since the next activation
+// retries them anyway
+func (s *streamer[T]) RunCron(ctx context.Context, spec string, fn func(ctx context.Context, t T) error) error {
+	if s.err != nil {
+		return errors.Wrap(s.err, "invalid streamer")
+	}
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+
+	var (
+		running int32
+		wg      sync.WaitGroup
+	)
+	defer wg.Wait()
+
+	for {
+		now := s.Clock.Now()
+		next := schedule.Next(now)
+		if next.IsZero() {
+			return errors.From(errors.New("cron spec has no activations"), logan.F{"spec": spec})
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.Clock.After(next.Sub(now)):
+		}
+
+		if !atomic.CompareAndSwapInt32(&running, 0, 1) {
+			atomic.AddInt64(&s.stats.skippedRuns, 1)
+			if s.Log != nil {
+				s.Log.WithFields(logan.F{"key": s.KeyValueKey, "activation": next}).
+					Warn("Skipped cron activation as the previous run is in flight")
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer atomic.StoreInt32(&running, 0)
+
+			err := s.FormListAndProcess(fn)
+			switch {
+			case err == nil || s.Log == nil:
+			case Is(err, ErrStreamerPaused):
+				s.Log.WithField("key", s.KeyValueKey).Info("Skipped cron run as the streamer is paused")
+			default:
+				s.Log.WithError(err).WithField("key", s.KeyValueKey).Error("Cron run failed")
+			}
+		}()
+	}
+}
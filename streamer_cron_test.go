@@ -0,0 +1,150 @@
+package dban_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+// fakeClock moves only when it is advanced
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// advanceToNext moves the clock to the earliest time waited for, once there is one
+func (c *fakeClock) advanceToNext(t *testing.T) time.Time {
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.waiters) != 0
+	}, time.Second, time.Millisecond)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	next := c.waiters[0].at
+	for _, waiter := range c.waiters {
+		if waiter.at.Before(next) {
+			next = waiter.at
+		}
+	}
+	c.now = next
+
+	pending := c.waiters[:0]
+	for _, waiter := range c.waiters {
+		if waiter.at.After(c.now) {
+			pending = append(pending, waiter)
+			continue
+		}
+		waiter.ch <- c.now
+	}
+	c.waiters = pending
+	return next
+}
+
+func TestStreamerRunCron(t *testing.T) {
+	newStreamer := func(key string, clock dban.Clock) dban.Streamer[int] {
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:      dbantest.NewSliceStreamable([]int{1}),
+			KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey: key,
+			Clock:       clock,
+		})
+	}
+	start := time.Date(2023, 1, 2, 10, 2, 30, 0, time.UTC)
+
+	t.Run("activations", func(t *testing.T) {
+		clock := &fakeClock{now: start}
+		streamer := newStreamer("cron-activations", clock)
+		ctx, cancel := context.WithCancel(context.Background())
+		runs := make(chan time.Time, 3)
+		done := make(chan error)
+		go func() {
+			done <- streamer.RunCron(ctx, "*/5 * * * *", func(context.Context, int) error {
+				runs <- clock.Now()
+				return nil
+			})
+		}()
+
+		for _, expected := range []time.Time{
+			time.Date(2023, 1, 2, 10, 5, 0, 0, time.UTC),
+			time.Date(2023, 1, 2, 10, 10, 0, 0, time.UTC),
+			time.Date(2023, 1, 2, 10, 15, 0, 0, time.UTC),
+		} {
+			assert.Equal(t, expected, clock.advanceToNext(t))
+			assert.Equal(t, expected, <-runs)
+		}
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("overlap", func(t *testing.T) {
+		clock := &fakeClock{now: start}
+		streamer := newStreamer("cron-overlap", clock)
+		before := streamer.GetStats()
+		ctx, cancel := context.WithCancel(context.Background())
+		started, release := make(chan struct{}), make(chan struct{})
+		done := make(chan error)
+		go func() {
+			done <- streamer.RunCron(ctx, "* * * * *", func(context.Context, int) error {
+				started <- struct{}{}
+				<-release
+				return nil
+			})
+		}()
+
+		clock.advanceToNext(t)
+		<-started
+		clock.advanceToNext(t)
+		clock.advanceToNext(t)
+		require.Eventually(t, func() bool {
+			return streamer.GetStats().SkippedRuns-before.SkippedRuns == 2
+		}, time.Second, time.Millisecond)
+
+		cancel()
+		select {
+		case <-done:
+			t.Fatal("shutdown must wait for the run in flight")
+		case <-time.After(10 * time.Millisecond):
+		}
+		close(release)
+		require.NoError(t, <-done)
+		assert.Equal(t, int64(1), streamer.GetStats().Batches-before.Batches)
+	})
+
+	t.Run("invalid spec", func(t *testing.T) {
+		clock := &fakeClock{now: start}
+		err := newStreamer("cron-invalid", clock).RunCron(context.Background(), "* * *", func(context.Context, int) error {
+			return nil
+		})
+		assert.Error(t, err)
+		assert.Empty(t, clock.waiters)
+	})
+}
@@ -0,0 +1,198 @@
+package dban
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// CursorWriteBuffer makes a streamer keep going when its cursor fails to be written: the
+// cursor is kept in memory and written in background, while the following batches are taken
+// from the in-memory cursor without reading and locking the stored one, so it suits streamers
+// running in a single instance only. Only the latest cursor is written. Once the cursor could
+// not be persisted for MaxPending batches or for Deadline, the streamer fails with
+// ErrCursorNotPersisted until it is, which bounds the work to reprocess after a crash. The
+// retries stop with the context of the streamer, making one last attempt
+type CursorWriteBuffer struct {
+	// MaxPending is the number of batches that could be taken while the cursor is not
+	// persisted, unlimited if zero
+	MaxPending int
+	// RetryInterval is the interval the write is retried with
+	RetryInterval time.Duration
+	// Deadline is how long the cursor could stay not persisted, unlimited if zero
+	Deadline time.Duration
+}
+
+type cursorBuffer struct {
+	config CursorWriteBuffer
+	kvQ    KeyValueQ
+	key    string
+	ctx    context.Context
+
+	mu sync.Mutex
+	// page is the cursor to write if pending is set
+	page    uint64
+	pending bool
+	since   time.Time
+	// batches is the number of batches taken since the cursor is not persisted
+	batches   int
+	deferrals uint64
+	lastErr   error
+	retrying  bool
+}
+
+func newCursorBuffer(config *CursorWriteBuffer, kvQ KeyValueQ, key string, ctx context.Context) *cursorBuffer {
+	if config == nil {
+		return nil
+	}
+	return &cursorBuffer{config: *config, kvQ: kvQ, key: key, ctx: ctx}
+}
+
+// current returns the cursor that is not persisted yet, if any
+func (b *cursorBuffer) current() (uint64, bool) {
+	if b == nil {
+		return 0, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.page, b.pending
+}
+
+// check fails if the cursor is not persisted for too long
+func (b *cursorBuffer) check() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.pending {
+		return nil
+	}
+	if (b.config.MaxPending > 0 && b.batches >= b.config.MaxPending) ||
+		(b.config.Deadline > 0 && time.Since(b.since) >= b.config.Deadline) {
+		return errors.Wrap(&kindError{kind: ErrCursorNotPersisted, err: b.lastErr}, "cursor is not persisted for too long", logan.F{
+			"page":    b.page,
+			"batches": b.batches,
+			"since":   b.since,
+		})
+	}
+	return nil
+}
+
+// write makes page the cursor to write and reports true if the write is deferred, i.e.
+// there is a cursor that is not persisted already
+func (b *cursorBuffer) write(page uint64) bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.pending {
+		return false
+	}
+	b.page = page
+	b.batches++
+	b.deferrals++
+	return true
+}
+
+// deferWrite keeps page to be written in background after the write failed with err
+func (b *cursorBuffer) deferWrite(page uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.pending {
+		b.pending, b.since, b.batches = true, time.Now(), 0
+	}
+	b.page = page
+	b.batches++
+	b.deferrals++
+	b.lastErr = err
+	if !b.retrying {
+		b.retrying = true
+		go b.retry()
+	}
+}
+
+// deferred returns the number of writes deferred so far
+func (b *cursorBuffer) deferred() uint64 {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.deferrals
+}
+
+func (b *cursorBuffer) retry() {
+	ticker := time.NewTicker(b.config.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if b.flush() {
+				return
+			}
+		case <-b.ctx.Done():
+			b.flush()
+			b.mu.Lock()
+			b.retrying = false
+			b.mu.Unlock()
+			return
+		}
+	}
+}
+
+// flush writes the latest cursor and reports whether nothing is left to write
+func (b *cursorBuffer) flush() bool {
+	b.mu.Lock()
+	page := b.page
+	b.mu.Unlock()
+
+	err := b.kvQ.Upsert(KeyValue{Key: b.key, Value: strconv.FormatUint(page, 10)})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		b.lastErr = err
+		return false
+	}
+	if b.page != page {
+		// the cursor moved while it was written
+		return false
+	}
+	b.pending, b.retrying = false, false
+	return true
+}
+
+// writeCursor writes the cursor, deferring the write if it fails and the streamer has a
+// CursorWriteBuffer (see cursorBuffer.deferWrite)
+func (s *streamer[T]) writeCursor(page uint64) error {
+	if s.cursorBuffer.write(page) {
+		return nil
+	}
+
+	err := s.KeyValueQ.Upsert(KeyValue{Key: s.KeyValueKey, Value: strconv.FormatUint(page, 10)})
+	if err != nil && s.cursorBuffer != nil {
+		if s.Log != nil {
+			s.Log.WithError(err).WithField("key", s.KeyValueKey).Warn("Failed to write cursor, deferring the write")
+		}
+		s.cursorBuffer.deferWrite(page, err)
+		return nil
+	}
+	if err == nil {
+		s.checkpoint.persisted(page)
+	}
+	return err
+}
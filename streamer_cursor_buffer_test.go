@@ -0,0 +1,151 @@
+package dban_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// flakyKeyValueQ fails writes while it is down and records the written values
+type flakyKeyValueQ struct {
+	dban.KeyValueQ
+
+	mu      sync.Mutex
+	down    bool
+	written []string
+}
+
+func (q *flakyKeyValueQ) setDown(down bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.down = down
+}
+
+func (q *flakyKeyValueQ) writes() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]string(nil), q.written...)
+}
+
+func (q *flakyKeyValueQ) Upsert(kv dban.KeyValue) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.down {
+		return errors.New("storage is unavailable")
+	}
+	if kv.Key == cursorKey {
+		q.written = append(q.written, kv.Value)
+	}
+	return q.KeyValueQ.Upsert(kv)
+}
+
+func TestStreamerCursorWriteBuffer(t *testing.T) {
+	newStreamer := func(ctx context.Context, kvQ dban.KeyValueQ, buffer dban.CursorWriteBuffer, sink dban.EventSink) dban.Streamer[int] {
+		batchSize := uint64(1)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:            dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5, 6}),
+			KeyValueQ:         kvQ,
+			KeyValueKey:       cursorKey,
+			BatchSize:         &batchSize,
+			Ctx:               &ctx,
+			EventSink:         sink,
+			CursorWriteBuffer: &buffer,
+		})
+	}
+	noop := func(context.Context, int) error { return nil }
+
+	t.Run("coalesced retry", func(t *testing.T) {
+		kvQ := &flakyKeyValueQ{KeyValueQ: dbantest.NewMemoryKeyValueQ()}
+		collected := &collectingSink{}
+		sink := dban.NewAsyncEventSink(collected, 16)
+		streamer := newStreamer(context.Background(), kvQ, dban.CursorWriteBuffer{
+			MaxPending:    10,
+			RetryInterval: 10 * time.Millisecond,
+		}, sink)
+
+		require.NoError(t, streamer.FormListAndProcess(noop))
+		kvQ.setDown(true)
+		var processed []int
+		for i := 0; i < 3; i++ {
+			require.NoError(t, streamer.FormListAndProcess(func(_ context.Context, item int) error {
+				processed = append(processed, item)
+				return nil
+			}))
+		}
+		assert.Equal(t, []int{2, 3, 4}, processed, "batches must be taken from the cursor kept in memory")
+		page, err := streamer.GetCurrentPage()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(4), page)
+
+		kvQ.setDown(false)
+		require.Eventually(t, func() bool {
+			return len(kvQ.writes()) == 2
+		}, time.Second, 5*time.Millisecond)
+		assert.Equal(t, []string{"1", "4"}, kvQ.writes(), "only the latest cursor must be written")
+
+		require.NoError(t, streamer.FormListAndProcess(noop))
+		sink.Close()
+		var deferred []bool
+		for _, event := range collected.events {
+			if completed, ok := event.(dban.BatchCompleted); ok {
+				deferred = append(deferred, completed.CursorPersistDeferred)
+			}
+		}
+		assert.Equal(t, []bool{false, true, true, true, false}, deferred)
+	})
+
+	t.Run("max pending", func(t *testing.T) {
+		kvQ := &flakyKeyValueQ{KeyValueQ: dbantest.NewMemoryKeyValueQ()}
+		streamer := newStreamer(context.Background(), kvQ, dban.CursorWriteBuffer{
+			MaxPending:    2,
+			RetryInterval: time.Hour,
+		}, nil)
+
+		require.NoError(t, streamer.FormListAndProcess(noop))
+		kvQ.setDown(true)
+		require.NoError(t, streamer.FormListAndProcess(noop))
+		require.NoError(t, streamer.FormListAndProcess(noop))
+		err := streamer.FormListAndProcess(noop)
+		assert.True(t, dban.Is(err, dban.ErrCursorNotPersisted))
+		var streamErr *dban.StreamError
+		require.ErrorAs(t, err, &streamErr)
+		assert.Equal(t, dban.StageCursorWrite, streamErr.Stage)
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		kvQ := &flakyKeyValueQ{KeyValueQ: dbantest.NewMemoryKeyValueQ()}
+		streamer := newStreamer(context.Background(), kvQ, dban.CursorWriteBuffer{
+			RetryInterval: time.Hour,
+			Deadline:      10 * time.Millisecond,
+		}, nil)
+
+		require.NoError(t, streamer.FormListAndProcess(noop))
+		kvQ.setDown(true)
+		require.NoError(t, streamer.FormListAndProcess(noop))
+		time.Sleep(20 * time.Millisecond)
+		assert.True(t, dban.Is(streamer.FormListAndProcess(noop), dban.ErrCursorNotPersisted))
+	})
+
+	t.Run("final flush", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		kvQ := &flakyKeyValueQ{KeyValueQ: dbantest.NewMemoryKeyValueQ()}
+		streamer := newStreamer(ctx, kvQ, dban.CursorWriteBuffer{RetryInterval: time.Hour}, nil)
+
+		require.NoError(t, streamer.FormListAndProcess(noop))
+		kvQ.setDown(true)
+		require.NoError(t, streamer.FormListAndProcess(noop))
+		kvQ.setDown(false)
+		cancel()
+		require.Eventually(t, func() bool {
+			return len(kvQ.writes()) == 2
+		}, time.Second, 5*time.Millisecond)
+		assert.Equal(t, []string{"1", "2"}, kvQ.writes())
+	})
+}
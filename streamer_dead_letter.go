@@ -0,0 +1,50 @@
+package dban
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// DeadLetterFunc takes an entity that failed to be processed EntityMaxAttempts times in a
+// row with the error of the last attempt, e.g. to write it to a quarantine table
+type DeadLetterFunc[T any] func(ctx context.Context, t T, err error) error
+
+// processEntity makes up to EntityMaxAttempts attempts to process the i-th entity of the
+// list taken from page with process, stopping once Ctx is done, and hands the entity to
+// OnDeadLetter if all of them fail. It returns the error of the last attempt unless the
+// entity was dead-lettered. A failure of OnDeadLetter is returned as stop, as it stops the
+// list regardless of EntityErrorPolicy, so that no entity is dropped silently, as well as
+// ErrStopStreaming returned by process, which is not retried
+func (s *streamer[T]) processEntity(process func() error, page uint64, i int, entity T) (deadLettered bool, err, stop error) {
+	var failure error
+	defer func(started time.Time) {
+		s.reportEntity(entity, i, started, deadLettered, failure, err, stop)
+	}(time.Now())
+
+	err = s.recovered(process)
+	if stopped(err) {
+		return false, nil, s.failEntity(page, i, err)
+	}
+	for attempt := uint(1); err != nil && attempt < s.EntityMaxAttempts && s.Ctx.Err() == nil; attempt++ {
+		if err = s.recovered(process); stopped(err) {
+			return false, nil, s.failEntity(page, i, err)
+		}
+	}
+	if err == nil || s.OnDeadLetter == nil || s.Ctx.Err() != nil {
+		return false, err, nil
+	}
+	failure = err
+
+	if deadErr := s.OnDeadLetter(s.Ctx, entity, err); deadErr != nil {
+		return false, err, s.failEntity(page, i, errors.Wrap(deadErr, "failed to dead-letter entity", logan.F{
+			"entity_error": err.Error(),
+		}))
+	}
+	if s.Log != nil {
+		s.Log.WithError(err).WithFields(s.failEntity(page, i, err).Fields()).Warn("Dead-lettered entity failed to be processed")
+	}
+	return true, nil, nil
+}
@@ -0,0 +1,96 @@
+package dban_test
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+func TestStreamerDeadLetter(t *testing.T) {
+	failure := errors.New("entity is broken")
+	newStreamer := func(concurrency uint64, onDeadLetter dban.DeadLetterFunc[int]) dban.Streamer[int] {
+		batchSize := uint64(4)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:            dbantest.NewSliceStreamable([]int{1, 2, 3, 4}),
+			KeyValueQ:         dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey:       cursorKey,
+			BatchSize:         &batchSize,
+			MaxConcurrency:    &concurrency,
+			EntityMaxAttempts: 3,
+			OnDeadLetter:      onDeadLetter,
+		})
+	}
+
+	for _, concurrency := range []uint64{1, 2} {
+		var (
+			mu           sync.Mutex
+			attempts     = map[int]int{}
+			processed    []int
+			deadLettered []int
+		)
+		// 2 fails for good, while 3 fails twice
+		process := func(_ context.Context, i int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts[i]++
+			if i == 2 || (i == 3 && attempts[i] < 3) {
+				return failure
+			}
+			processed = append(processed, i)
+			return nil
+		}
+		streamer := newStreamer(concurrency, func(_ context.Context, i int, err error) error {
+			assert.Equal(t, failure, err)
+			mu.Lock()
+			defer mu.Unlock()
+			deadLettered = append(deadLettered, i)
+			return nil
+		})
+
+		require.NoError(t, streamer.FormListAndProcess(process))
+		sort.Ints(processed)
+		assert.Equal(t, []int{1, 3, 4}, processed)
+		assert.Equal(t, []int{2}, deadLettered)
+		assert.Equal(t, map[int]int{1: 1, 2: 3, 3: 3, 4: 1}, attempts)
+	}
+
+	t.Run("dead letter failed", func(t *testing.T) {
+		quarantineErr := errors.New("quarantine is unavailable")
+		var processed []int
+		err := newStreamer(1, func(context.Context, int, error) error {
+			return quarantineErr
+		}).FormListAndProcess(func(_ context.Context, i int) error {
+			if i == 2 {
+				return failure
+			}
+			processed = append(processed, i)
+			return nil
+		})
+
+		var streamErr *dban.StreamError
+		require.ErrorAs(t, err, &streamErr)
+		assert.Equal(t, 1, streamErr.EntityIndex)
+		assert.True(t, dban.Is(err, quarantineErr))
+		assert.Equal(t, []int{1}, processed)
+	})
+
+	t.Run("no dead letter", func(t *testing.T) {
+		attempts := 0
+		err := newStreamer(1, nil).FormListAndProcess(func(_ context.Context, i int) error {
+			if i == 2 {
+				attempts++
+				return failure
+			}
+			return nil
+		})
+		assert.True(t, dban.Is(err, failure))
+		assert.Equal(t, 3, attempts)
+	})
+}
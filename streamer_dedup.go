@@ -0,0 +1,56 @@
+package dban
+
+import "sync"
+
+// dedupWindow remembers the IDs of the last entities delivered by a streamer
+type dedupWindow struct {
+	mu    sync.Mutex
+	ids   map[string]struct{}
+	order []string
+	// next is a position in order the next ID replaces
+	next int
+}
+
+func newDedupWindow(size int) *dedupWindow {
+	return &dedupWindow{
+		ids:   make(map[string]struct{}, size),
+		order: make([]string, 0, size),
+	}
+}
+
+func (w *dedupWindow) seen(id string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, ok := w.ids[id]
+	return ok
+}
+
+func (w *dedupWindow) remember(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.ids[id]; ok {
+		return
+	}
+	if len(w.order) < cap(w.order) {
+		w.order = append(w.order, id)
+	} else {
+		delete(w.ids, w.order[w.next])
+		w.order[w.next] = id
+		w.next = (w.next + 1) % len(w.order)
+	}
+	w.ids[id] = struct{}{}
+}
+
+// redelivered reports whether the entity is one of the last delivered ones (see DedupWindow)
+func (s *streamer[T]) redelivered(entity T) bool {
+	return s.dedup != nil && s.dedup.seen(s.DedupID(entity))
+}
+
+// delivered remembers the entity as delivered (see DedupWindow)
+func (s *streamer[T]) delivered(entity T) {
+	if s.dedup != nil {
+		s.dedup.remember(s.DedupID(entity))
+	}
+}
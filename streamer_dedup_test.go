@@ -0,0 +1,81 @@
+package dban_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+// mutableStreamable paginates through items that could be changed between batches
+type mutableStreamable struct {
+	items []int
+}
+
+func (s *mutableStreamable) SelectWithPageParams(params pgdb.OffsetPageParams) ([]int, error) {
+	return dbantest.NewSliceStreamable(s.items).SelectWithPageParams(params)
+}
+
+func TestStreamerDedupWindow(t *testing.T) {
+	// processPass processes two batches inserting a row before the cursor in between
+	processPass := func(t *testing.T, dedupWindow int) []int {
+		stream := &mutableStreamable{items: []int{1, 2, 3, 4, 5, 6}}
+		batchSize := uint64(2)
+		streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:      stream,
+			KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey: cursorKey,
+			BatchSize:   &batchSize,
+			DedupWindow: dedupWindow,
+			DedupID:     strconv.Itoa,
+		})
+
+		var processed []int
+		process := func(_ context.Context, i int) error {
+			processed = append(processed, i)
+			return nil
+		}
+		require.NoError(t, streamer.FormListAndProcess(process))
+		stream.items = append([]int{0}, stream.items...)
+		require.NoError(t, streamer.FormListAndProcess(process))
+		return processed
+	}
+
+	assert.Equal(t, []int{1, 2, 2, 3}, processPass(t, 0), "the inserted row shifts the delivered one to the next page")
+	assert.Equal(t, []int{1, 2, 3}, processPass(t, 2))
+
+	t.Run("window", func(t *testing.T) {
+		batchSize := uint64(2)
+		streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:      dbantest.NewSliceStreamable([]int{1, 2, 1, 3, 4, 1}),
+			KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey: cursorKey,
+			BatchSize:   &batchSize,
+			DedupWindow: 2,
+			DedupID:     strconv.Itoa,
+		})
+
+		var lists [][]int
+		for i := 0; i < 3; i++ {
+			list, err := streamer.FormList()
+			require.NoError(t, err)
+			lists = append(lists, list)
+		}
+		assert.Equal(t, [][]int{{1, 2}, {3}, {4, 1}}, lists, "only the last IDs are remembered")
+	})
+
+	t.Run("requires id", func(t *testing.T) {
+		_, err := dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:      dbantest.NewSliceStreamable([]int{1}),
+			KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey: cursorKey,
+			DedupWindow: 2,
+		}).FormList()
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,208 @@
+package dban
+
+import (
+	"sync/atomic"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// directionKeySuffix is appended to the cursor key to form a key marking the cursor of a
+// descending streamer, as the cursor itself is stored the same way in both directions
+const directionKeySuffix = ":direction"
+
+// Values of the direction marker of a descending cursor: it is set to descendingEnded once
+// the first page is processed
+const (
+	descendingMarker = "descending"
+	descendingEnded  = "descending:ended"
+)
+
+// StreamDirection defines the order the streamer takes pages in
+type StreamDirection int
+
+const (
+	// Ascending makes the streamer take pages from the first one up, starting over once the
+	// end of the stream is reached
+	Ascending StreamDirection = iota
+	// Descending makes the streamer take pages from the last one down to the first one,
+	// e.g. to process the most recent entities first, and stop there
+	Descending
+)
+
+// checkAscending makes sure the cursor is not one of a descending streamer. It is checked
+// once per streamer, as the marker is not expected to appear under a running one
+func (s *streamer[T]) checkAscending() error {
+	if atomic.LoadInt32(s.ascendingChecked) == 1 {
+		return nil
+	}
+
+	marker, err := s.KeyValueQ.Get(s.KeyValueKey + directionKeySuffix)
+	if err != nil {
+		return errors.Wrap(err, "failed to get direction of the cursor", logan.F{"key": s.KeyValueKey})
+	}
+	if marker != nil {
+		return errors.Wrap(ErrDirectionMismatch, "cursor is taken by a descending streamer", logan.F{
+			"key":    s.KeyValueKey,
+			"marker": marker.Value,
+		})
+	}
+	atomic.StoreInt32(s.ascendingChecked, 1)
+	return nil
+}
+
+// getDescendingPage returns the page a descending streamer is at. A missing cursor starts at
+// StartPage or the last page of a Countable stream, reporting it as fresh, while a cursor
+// written by an ascending streamer fails with ErrDirectionMismatch
+func (s *streamer[T]) getDescendingPage() (page uint64, ended, fresh bool, err error) {
+	fields := logan.F{"key": s.KeyValueKey}
+	marker, err := s.KeyValueQ.Get(s.KeyValueKey + directionKeySuffix)
+	if err != nil {
+		return 0, false, false, errors.Wrap(err, "failed to get direction of the cursor", fields)
+	}
+	cursor, err := s.KeyValueQ.LockingGet(s.KeyValueKey)
+	if err != nil {
+		return 0, false, false, errors.Wrap(err, "failed to get current cursor value", fields)
+	}
+
+	switch {
+	case marker == nil && cursor != nil:
+		return 0, false, false, errors.Wrap(ErrDirectionMismatch, "cursor is taken by an ascending streamer", fields)
+	case marker != nil && marker.Value == descendingEnded:
+		return 0, true, false, nil
+	case marker != nil && marker.Value != descendingMarker:
+		return 0, false, false, errors.Wrap(ErrDirectionMismatch, "unknown direction of the cursor", fields.Add("marker", marker.Value))
+	case cursor == nil:
+		page, ended, err = s.startPage()
+		return page, ended, true, err
+	}
+
+	if page, err = parseCursor(cursor.Value); err != nil {
+		return 0, false, false, errors.Wrap(err, "failed to parse cursor", fields.Add("kv_cursor", cursor.Value))
+	}
+	page, err = s.reconcileBatchSize(page)
+	return page, false, false, err
+}
+
+// startPage returns the page a descending streamer starts at. An empty Countable stream has
+// no page to start at, so it is ended
+func (s *streamer[T]) startPage() (page uint64, ended bool, err error) {
+	if s.StartPage != nil {
+		return *s.StartPage, false, nil
+	}
+
+	total, err := s.Source.(Countable).Count()
+	if err != nil {
+		return 0, false, errors.Wrap(err, "failed to count entities to start from the last page", logan.F{
+			"key": s.KeyValueKey,
+		})
+	}
+	if total == 0 {
+		return 0, true, nil
+	}
+	return (total - 1) / s.BatchSize, false, nil
+}
+
+func (s *streamer[T]) markDirection(marker string) error {
+	err := s.KeyValueQ.Upsert(KeyValue{Key: s.KeyValueKey + directionKeySuffix, Value: marker})
+	if err != nil {
+		return errors.Wrap(err, "failed to mark direction of the cursor", logan.F{"key": s.KeyValueKey})
+	}
+	return nil
+}
+
+// takePageDescending does the same thing as takePage for a descending streamer: it finds a
+// page selectPage finds entities on, starting with the current one and going down, and moves
+// the cursor to the page below it. Empty pages are stepped over, as the stream could shrink
+// since the pages were counted. Once the first page is taken, the stream is ended, so no
+// page is found from then on
+func (s *streamer[T]) takePageDescending(selectPage func(page uint64) (formed int, err error)) (uint64, bool, error) {
+	if s.err != nil {
+		return 0, false, errors.Wrap(s.err, "invalid streamer")
+	}
+	if err := s.interrupted(StageCursorRead, 0); err != nil {
+		return 0, false, err
+	}
+	pageNumber, ended, fresh, err := s.getDescendingPage()
+	if err != nil {
+		return 0, false, s.fail(StageCursorRead, 0, errors.Wrap(err, "failed to get current page number"))
+	}
+	if ended {
+		s.endOfStream(0)
+		return 0, false, nil
+	}
+
+	for {
+		if err = s.interrupted(StageSelect, pageNumber); err != nil {
+			return 0, false, err
+		}
+		formed, err := selectPage(pageNumber)
+		found := formed != 0
+		if err != nil {
+			return 0, false, s.fail(StageSelect, pageNumber, errors.Wrap(err, "failed to select entities"))
+		}
+		if found || pageNumber == 0 {
+			if err = s.interrupted(StageCursorWrite, pageNumber); err != nil {
+				return 0, false, err
+			}
+			if err = s.descend(pageNumber, fresh); err != nil {
+				return 0, false, s.fail(StageCursorWrite, pageNumber, err)
+			}
+		}
+		if found {
+			return pageNumber, true, nil
+		}
+		if pageNumber == 0 {
+			s.endOfStream(0)
+			return 0, false, nil
+		}
+		pageNumber--
+	}
+}
+
+// descend moves the cursor of a descending streamer to the page below page, or marks the
+// stream ended if it is the first one. A fresh cursor is marked as a descending one
+func (s *streamer[T]) descend(page uint64, fresh bool) error {
+	if fresh {
+		if err := s.storeBatchSize(); err != nil {
+			return err
+		}
+	}
+	if page == 0 {
+		if err := s.writeCursor(0); err != nil {
+			return errors.Wrap(err, "failed to update last processed entities")
+		}
+		return s.markDirection(descendingEnded)
+	}
+
+	if err := s.writeCursor(page - 1); err != nil {
+		return errors.Wrap(err, "failed to update last processed entities")
+	}
+	if fresh {
+		return s.markDirection(descendingMarker)
+	}
+	return nil
+}
+
+// resetDescending deletes the cursor of a descending streamer along with its marker, so that
+// it starts over from StartPage or the last page of the stream
+func (s *streamer[T]) resetDescending() error {
+	if s.err != nil {
+		return errors.Wrap(s.err, "invalid streamer")
+	}
+
+	err := s.inTx(func(s *streamer[T]) error {
+		if err := s.KeyValueQ.Delete(s.KeyValueKey); err != nil {
+			return errors.Wrap(err, "failed to delete cursor")
+		}
+		return errors.Wrap(s.KeyValueQ.Delete(s.KeyValueKey+directionKeySuffix), "failed to delete direction of the cursor")
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to reset cursor", logan.F{"key": s.KeyValueKey})
+	}
+
+	if s.Log != nil {
+		s.Log.WithField("key", s.KeyValueKey).Info("Cursor reset")
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package dban_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+func TestStreamerDescending(t *testing.T) {
+	descending := dban.Descending
+	newStreamer := func(key string, stream dban.Streamable[int], kvQ dban.KeyValueQ, startPage *uint64) dban.Streamer[int] {
+		batchSize := uint64(2)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:      stream,
+			KeyValueQ:   kvQ,
+			KeyValueKey: key,
+			BatchSize:   &batchSize,
+			Direction:   &descending,
+			StartPage:   startPage,
+		})
+	}
+	counted := func(items []int, total uint64) dban.Streamable[int] {
+		return countableStreamable{
+			Streamable: dbantest.NewSliceStreamable(items),
+			count:      func() (uint64, error) { return total, nil },
+		}
+	}
+
+	t.Run("countable", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := newStreamer("descending-countable", counted([]int{1, 2, 3, 4, 5}, 5), kvQ, nil)
+
+		for _, expected := range [][]int{{5}, {3, 4}, {1, 2}, nil, nil} {
+			list, err := streamer.FormList()
+			require.NoError(t, err)
+			assert.Equal(t, expected, list)
+		}
+		assert.Equal(t, "0", kvQ.MustGet("descending-countable").Value, "the cursor format must not change")
+	})
+
+	t.Run("start page", func(t *testing.T) {
+		var recorded []pgdb.OffsetPageParams
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		startPage := uint64(1)
+		stream := dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5}, dbantest.WithPageParamsRecorder(&recorded))
+		streamer := newStreamer("descending-start", stream, kvQ, &startPage)
+
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{3, 4}, list)
+		assert.Equal(t, "0", kvQ.MustGet("descending-start").Value)
+
+		list, err = streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, list)
+		assert.Equal(t, []uint64{1, 0}, pageNumbers(recorded), "the streamer must not wrap around")
+	})
+
+	t.Run("shrunk", func(t *testing.T) {
+		// the stream was counted with 8 entities, but half of them were deleted since
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := newStreamer("descending-shrunk", counted([]int{1, 2, 3, 4}, 8), kvQ, nil)
+
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{3, 4}, list, "empty pages must be stepped over")
+		assert.Equal(t, "0", kvQ.MustGet("descending-shrunk").Value)
+
+		list, err = streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, list)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		streamer := newStreamer("descending-empty", counted(nil, 0), dbantest.NewMemoryKeyValueQ(), nil)
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Empty(t, list)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := newStreamer("descending-reset", counted([]int{1, 2, 3}, 3), kvQ, nil)
+		for i := 0; i < 3; i++ {
+			_, err := streamer.FormList()
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, streamer.Reset())
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{3}, list, "a reset streamer must start over from the last page")
+
+		require.NoError(t, streamer.SetPage(0))
+		list, err = streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, list)
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		ascending := newTestStreamer(dbantest.NewSliceStreamable([]int{1, 2, 3}), kvQ)
+		_, err := ascending.FormList()
+		require.NoError(t, err)
+
+		_, err = newStreamer(cursorKey, counted([]int{1, 2, 3}, 3), kvQ, nil).FormList()
+		assert.True(t, dban.Is(err, dban.ErrDirectionMismatch), "a descending streamer must not take a cursor of an ascending one")
+
+		kvQ = dbantest.NewMemoryKeyValueQ()
+		_, err = newStreamer(cursorKey, counted([]int{1, 2, 3, 4, 5}, 5), kvQ, nil).FormList()
+		require.NoError(t, err)
+
+		_, err = newTestStreamer(dbantest.NewSliceStreamable([]int{1, 2, 3}), kvQ).FormList()
+		assert.True(t, dban.Is(err, dban.ErrDirectionMismatch), "an ascending streamer must not take a cursor of a descending one")
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		_, err := newStreamer("descending-invalid", dbantest.NewSliceStreamable([]int{1}), dbantest.NewMemoryKeyValueQ(), nil).FormList()
+		assert.ErrorContains(t, err, "requires StartPage or a Countable Stream")
+
+		batchSize, concurrency := uint64(2), uint64(2)
+		_, err = dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:          counted([]int{1}, 1),
+			KeyValueQ:       dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey:     "descending-invalid",
+			BatchSize:       &batchSize,
+			Direction:       &descending,
+			PageConcurrency: &concurrency,
+		}).FormList()
+		assert.ErrorContains(t, err, "Descending requires CursorLockAndUpdate")
+	})
+}
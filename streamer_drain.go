@@ -0,0 +1,83 @@
+package dban
+
+import (
+	"context"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// ProcessReport tells what ProcessAll did
+type ProcessReport struct {
+	// Pages is the number of pages processed
+	Pages uint64
+	// Entities is the number of entities processed
+	Entities uint64
+}
+
+func (s *streamer[T]) ProcessAll(fn func(ctx context.Context, t T) error) (report ProcessReport, err error) {
+	defer func() { err = s.flushed(err) }()
+
+	drain := s.drainer()
+	for {
+		more, err := drain.drainList(fn, &report)
+		if stopped(err) {
+			return report, nil
+		}
+		if err != nil || !more {
+			return report, err
+		}
+	}
+}
+
+// drainer returns a copy of the streamer stopping at the end of the stream, counting the
+// entities of a Countable stream
+func (s *streamer[T]) drainer() *streamer[T] {
+	drain := *s
+	drain.draining = true
+	drain.countTotal(true)
+	return &drain
+}
+
+// drainList forms and processes a list of the streamer made with drainer, adding it to the
+// report, and tells whether there could be more lists before the end of the stream.
+// ErrStopStreaming returned by fn is returned as is
+func (s *streamer[T]) drainList(fn func(ctx context.Context, t T) error, report *ProcessReport) (more bool, err error) {
+	if err := s.Ctx.Err(); err != nil {
+		return false, errors.Wrap(&kindError{kind: ErrInterrupted, err: err}, "processing interrupted", logan.F{
+			"pages":    report.Pages,
+			"entities": report.Entities,
+		})
+	}
+
+	page, formed, processed, err := s.formListAndProcess(eachEntity(fn))
+	report.Entities += uint64(processed)
+	if err != nil || formed == 0 {
+		return false, err
+	}
+	s.reportProgress(page, formed)
+	if s.adaptive != nil {
+		// the cursor is left right past the list, so the entities added later are not skipped
+		report.Pages++
+		return true, nil
+	}
+	// a list of concurrent streamers spans several pages
+	pages := (uint64(formed) + s.BatchSize - 1) / s.BatchSize
+	report.Pages += pages
+
+	if uint64(formed)%s.BatchSize != 0 && !s.descending {
+		// the entities added to the last page later would be skipped if the cursor was
+		// left past it
+		return false, s.rewind(page + pages - 1)
+	}
+	return true, nil
+}
+
+// rewind moves the cursor advanced past the end of the stream back to the page, so that
+// the entities added to it later are not skipped
+func (s *streamer[T]) rewind(page uint64) error {
+	if err := s.writeCursor(page); err != nil {
+		return s.fail(StageCursorWrite, page, errors.Wrap(err, "failed to move cursor back to the end"))
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerProcessAll(t *testing.T) {
+	modes := map[string]func(params *dban.StreamerInitParams[int]){
+		"lock and update": func(*dban.StreamerInitParams[int]) {},
+		"advance first": func(params *dban.StreamerInitParams[int]) {
+			mode := dban.CursorAdvanceFirst
+			params.CursorMode = &mode
+		},
+		"concurrent": func(params *dban.StreamerInitParams[int]) {
+			concurrency := uint64(2)
+			params.PageConcurrency = &concurrency
+		},
+	}
+
+	for name, configure := range modes {
+		t.Run(name, func(t *testing.T) {
+			stream := &mutableStreamable{items: []int{1, 2, 3, 4, 5}}
+			batchSize := uint64(2)
+			params := dban.StreamerInitParams[int]{
+				Stream:      stream,
+				KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+				KeyValueKey: cursorKey,
+				BatchSize:   &batchSize,
+			}
+			configure(&params)
+			streamer := dban.NewStreamer(params)
+
+			var processed []int
+			process := func(_ context.Context, i int) error {
+				processed = append(processed, i)
+				return nil
+			}
+			report, err := streamer.ProcessAll(process)
+			require.NoError(t, err)
+			assert.Equal(t, dban.ProcessReport{Pages: 3, Entities: 5}, report)
+			assert.Equal(t, []int{1, 2, 3, 4, 5}, processed)
+
+			// the cursor stays at the last page, which is not full, so only it and the
+			// pages added after it are processed
+			stream.items = append(stream.items, 6, 7, 8)
+			report, err = streamer.ProcessAll(process)
+			require.NoError(t, err)
+			assert.Equal(t, dban.ProcessReport{Pages: 2, Entities: 4}, report)
+			assert.Equal(t, []int{1, 2, 3, 4, 5, 5, 6, 7, 8}, processed)
+
+			report, err = streamer.ProcessAll(process)
+			require.NoError(t, err)
+			assert.Equal(t, dban.ProcessReport{}, report, "the last page was full")
+		})
+	}
+}
+
+func TestStreamerProcessAllFailure(t *testing.T) {
+	batchSize := uint64(2)
+	streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5}),
+		KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+		KeyValueKey: cursorKey,
+		BatchSize:   &batchSize,
+	})
+
+	report, err := streamer.ProcessAll(func(_ context.Context, i int) error {
+		if i == 4 {
+			return errors.New("failed to process")
+		}
+		return nil
+	})
+	var streamErr *dban.StreamError
+	require.True(t, errors.As(err, &streamErr), "unexpected error: %v", err)
+	assert.Equal(t, uint64(1), streamErr.Page)
+	assert.Equal(t, 1, streamErr.EntityIndex)
+	assert.Equal(t, dban.ProcessReport{Pages: 1, Entities: 3}, report)
+}
+
+func TestStreamerProcessAllCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	batchSize := uint64(1)
+	streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3}),
+		KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+		KeyValueKey: cursorKey,
+		BatchSize:   &batchSize,
+		Ctx:         &ctx,
+	})
+
+	report, err := streamer.ProcessAll(func(_ context.Context, i int) error {
+		cancel()
+		return nil
+	})
+	assert.True(t, dban.Is(err, context.Canceled), "unexpected error: %v", err)
+	assert.Equal(t, dban.ProcessReport{Pages: 1, Entities: 1}, report)
+}
+
+func TestStreamerWrapAround(t *testing.T) {
+	newStreamer := func(items []int, wrapAround *bool) dban.Streamer[int] {
+		batchSize := uint64(2)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:      dbantest.NewSliceStreamable(items),
+			KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey: cursorKey,
+			BatchSize:   &batchSize,
+			WrapAround:  wrapAround,
+		})
+	}
+	wrap, noWrap := true, false
+
+	for _, wrapAround := range []*bool{nil, &wrap, &noWrap} {
+		streamer := newStreamer(nil, wrapAround)
+		list, err := streamer.FormList()
+		require.NoError(t, err, "exhaustion at the first page")
+		assert.Empty(t, list)
+	}
+
+	t.Run("exhausted", func(t *testing.T) {
+		streamer := newStreamer([]int{1, 2, 3}, &noWrap)
+		for _, expected := range [][]int{{1, 2}, {3}, nil, nil} {
+			list, err := streamer.FormList()
+			require.NoError(t, err)
+			assert.Equal(t, expected, list)
+		}
+		page, err := streamer.GetCurrentPage()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), page, "the cursor must stay past the end")
+	})
+
+	for _, wrapAround := range []*bool{nil, &wrap} {
+		streamer := newStreamer([]int{1, 2, 3}, wrapAround)
+		for _, expected := range [][]int{{1, 2}, {3}, {1, 2}} {
+			list, err := streamer.FormList()
+			require.NoError(t, err)
+			assert.Equal(t, expected, list, "the end of the stream must wrap to the first page")
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package dban
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EntityErrorPolicy defines what the streamer does when an entity fails to be processed
+type EntityErrorPolicy int
+
+const (
+	// EntityErrorFailFast makes the streamer stop processing the list at the first failed
+	// entity and return its StreamError
+	EntityErrorFailFast EntityErrorPolicy = iota
+	// EntityErrorSkipAndLog makes the streamer log the StreamError of a failed entity and go on
+	// with the rest of the list
+	EntityErrorSkipAndLog
+	// EntityErrorCollect makes the streamer process every entity of the list and return
+	// BatchErrors if some of them failed
+	EntityErrorCollect
+)
+
+// SkippableErrorFunc decides whether the streamer could skip the failed entity according to
+// its EntityErrorPolicy. An entity it refuses to skip fails the list as with EntityErrorFailFast
+type SkippableErrorFunc func(err *StreamError) bool
+
+// BatchErrors is returned by a streamer with EntityErrorCollect once some of the entities of
+// a list failed to be processed, holding their StreamErrors in the order of the list. It
+// matches every error any of the entities failed with, and is returned as is, so that
+// errors.As could extract it
+type BatchErrors struct {
+	Errors []*StreamError
+}
+
+func (e *BatchErrors) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d of entities failed: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+func (e *BatchErrors) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+func (e *BatchErrors) Is(target error) bool {
+	for _, err := range e.Errors {
+		if Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// orNil returns e as an error if it holds some
+func (e *BatchErrors) orNil() error {
+	if e == nil || len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// entityFailed handles the failure of the i-th entity of the list taken from page according
+// to the EntityErrorPolicy. It returns the error to stop processing the list with, if the
+// entity could not be skipped, and collects the error otherwise (see EntityErrorCollect)
+func (s *streamer[T]) entityFailed(page uint64, i int, err error, collected *BatchErrors) error {
+	streamErr := s.failEntity(page, i, err)
+	if s.EntityErrorPolicy == EntityErrorFailFast || (s.SkippableError != nil && !s.SkippableError(streamErr)) {
+		return streamErr
+	}
+
+	if s.EntityErrorPolicy == EntityErrorCollect {
+		collected.Errors = append(collected.Errors, streamErr)
+		return nil
+	}
+	if s.Log != nil {
+		s.Log.WithError(err).WithFields(streamErr.Fields()).Warn("Skipped entity failed to be processed")
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerEntityErrorPolicy(t *testing.T) {
+	var (
+		failure = errors.New("boom")
+		fatal   = errors.New("fatal")
+	)
+	newStreamer := func(kvQ dban.KeyValueQ, policy dban.EntityErrorPolicy) dban.Streamer[int] {
+		batchSize := uint64(5)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:                 dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5}),
+			KeyValueQ:              kvQ,
+			KeyValueKey:            cursorKey,
+			BatchSize:              &batchSize,
+			AdvanceAfterProcessing: true,
+			EntityErrorPolicy:      &policy,
+			SkippableError: func(err *dban.StreamError) bool {
+				return !errors.Is(err, fatal)
+			},
+		})
+	}
+	// process fails the entities with the errors by their values
+	process := func(processed *[]int, failures map[int]error) func(context.Context, int) error {
+		return func(_ context.Context, i int) error {
+			if err, ok := failures[i]; ok {
+				return err
+			}
+			*processed = append(*processed, i)
+			return nil
+		}
+	}
+
+	t.Run("skip and log", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		var processed []int
+		err := newStreamer(kvQ, dban.EntityErrorSkipAndLog).FormListAndProcess(process(&processed, map[int]error{3: failure}))
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 4, 5}, processed)
+		assert.Equal(t, "1", kvQ.MustGet(cursorKey).Value)
+	})
+
+	t.Run("collect", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		var processed []int
+		err := newStreamer(kvQ, dban.EntityErrorCollect).FormListAndProcess(process(&processed, map[int]error{2: failure, 4: failure}))
+
+		var batchErr *dban.BatchErrors
+		require.ErrorAs(t, err, &batchErr)
+		require.Len(t, batchErr.Errors, 2)
+		assert.Equal(t, 1, batchErr.Errors[0].EntityIndex)
+		assert.Equal(t, 3, batchErr.Errors[1].EntityIndex)
+		assert.Len(t, batchErr.Unwrap(), 2)
+		assert.True(t, dban.Is(err, failure))
+		assert.Equal(t, []int{1, 3, 5}, processed)
+		assert.Equal(t, "1", kvQ.MustGet(cursorKey).Value, "the list is processed despite the failed entities")
+	})
+
+	t.Run("not skippable", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "0"}))
+		var processed []int
+		err := newStreamer(kvQ, dban.EntityErrorCollect).FormListAndProcess(process(&processed, map[int]error{2: failure, 4: fatal}))
+
+		var streamErr *dban.StreamError
+		require.ErrorAs(t, err, &streamErr)
+		assert.Equal(t, 3, streamErr.EntityIndex)
+		assert.True(t, dban.Is(err, fatal))
+		assert.Equal(t, []int{1, 3}, processed)
+		assert.Equal(t, "0", kvQ.MustGet(cursorKey).Value)
+	})
+}
@@ -0,0 +1,6 @@
+package dban
+
+// filteredOut reports whether Filter does not accept the entity, so it is to be skipped
+func (s *streamer[T]) filteredOut(entity T) bool {
+	return s.Filter != nil && !s.Filter(entity)
+}
@@ -0,0 +1,62 @@
+package dban_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerFilter(t *testing.T) {
+	newStreamer := func(key string, kvQ dban.KeyValueQ) dban.Streamer[int] {
+		batchSize, concurrency := uint64(2), uint64(2)
+		params := dban.StreamerInitParams[int]{
+			Stream:      dbantest.NewSliceStreamable([]int{1, 3, 4, 5, 6}),
+			KeyValueQ:   kvQ,
+			KeyValueKey: key,
+			BatchSize:   &batchSize,
+			Filter:      func(i int) bool { return i%2 == 0 },
+		}
+		if key == "filter-concurrent" {
+			params.MaxConcurrency = &concurrency
+		}
+		return dban.NewStreamer(params)
+	}
+
+	for _, key := range []string{"filter-sequential", "filter-concurrent"} {
+		t.Run(key, func(t *testing.T) {
+			kvQ := dbantest.NewMemoryKeyValueQ()
+			streamer := newStreamer(key, kvQ)
+
+			var processed []int
+			process := func(_ context.Context, i int) error {
+				processed = append(processed, i)
+				return nil
+			}
+
+			// the first page is filtered out entirely, but it is consumed anyway
+			require.NoError(t, streamer.FormListAndProcess(process))
+			assert.Empty(t, processed)
+			assert.Equal(t, "1", kvQ.MustGet(key).Value)
+
+			require.NoError(t, streamer.FormListAndProcess(process))
+			assert.Equal(t, []int{4}, processed)
+			assert.Equal(t, int64(3), streamer.GetStats().Filtered)
+		})
+	}
+
+	t.Run("batch", func(t *testing.T) {
+		streamer := newStreamer("filter-batch", dbantest.NewMemoryKeyValueQ())
+		var batches [][]int
+		for i := 0; i < 3; i++ {
+			require.NoError(t, streamer.FormListAndProcessBatch(func(_ context.Context, batch []int) error {
+				batches = append(batches, batch)
+				return nil
+			}))
+		}
+		assert.Equal(t, [][]int{{4}, {6}}, batches, "a batch filtered out entirely must not be passed")
+	})
+}
@@ -0,0 +1,144 @@
+package dban
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// metaKeySuffix is appended to the cursor key to form a key of the fingerprint of the
+// streamer owning the cursor (see Fingerprint)
+const metaKeySuffix = ":meta"
+
+// DeriveStreamerKey builds a cursor key from the prefix and the name of the type of v,
+// e.g. "billing:orders.Order" for DeriveStreamerKey("billing", orders.Order{}), so that
+// streamers of different entities do not share a key by mistake. Pointers are
+// dereferenced, so v could be a nil pointer of the type as well
+func DeriveStreamerKey(prefix string, v any) string {
+	name := "nil"
+	if t := reflect.TypeOf(v); t != nil {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		name = t.String()
+	}
+	if prefix == "" {
+		return name
+	}
+	return prefix + ":" + name
+}
+
+// StreamerFingerprint tells the streamer a cursor belongs to. It is stored as JSON under the
+// cursor key with the ":meta" suffix (see Fingerprint), which is longer than the varchar(64)
+// value column of the example migration, so the table needs the text one of the embedded
+// migrations
+type StreamerFingerprint struct {
+	// EntityType is the import path qualified name of the type of the entities
+	EntityType string `json:"entity_type"`
+	BatchSize  uint64 `json:"batch_size"`
+	// Direction is either "ascending" or "descending"
+	Direction string `json:"direction"`
+}
+
+func (f StreamerFingerprint) String() string {
+	return fmt.Sprintf("%s (batch size %d, %s)", f.EntityType, f.BatchSize, f.Direction)
+}
+
+// KeyCollisionError is returned by the streamer finding the cursor key taken by a streamer
+// with another fingerprint
+type KeyCollisionError struct {
+	// Key is the cursor key of the streamer
+	Key string
+	// Stored is the fingerprint of the streamer the cursor belongs to
+	Stored StreamerFingerprint
+	// Current is the fingerprint of the streamer that found the collision
+	Current StreamerFingerprint
+}
+
+func (e *KeyCollisionError) Error() string {
+	return fmt.Sprintf("cursor key %s is taken by a streamer of %s, while this one streams %s",
+		e.Key, e.Stored, e.Current)
+}
+
+// Is makes KeyCollisionError match ErrKeyCollision
+func (e *KeyCollisionError) Is(target error) bool {
+	return target == ErrKeyCollision
+}
+
+// newFingerprint makes the fingerprint of a streamer of T
+func newFingerprint[T any](batchSize uint64, direction StreamDirection) *StreamerFingerprint {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	entityType := t.String()
+	if t.Name() != "" && t.PkgPath() != "" {
+		entityType = t.PkgPath() + "." + t.Name()
+	}
+
+	fingerprint := StreamerFingerprint{EntityType: entityType, BatchSize: batchSize, Direction: "ascending"}
+	if direction == Descending {
+		fingerprint.Direction = "descending"
+	}
+	return &fingerprint
+}
+
+// checkFingerprint stores the fingerprint of the streamer on the first use of the cursor or
+// compares it with the stored one, failing with KeyCollisionError if they differ, unless
+// ForceAdopt makes the streamer replace it. Once it matches, it is not checked again
+func (s *streamer[T]) checkFingerprint() error {
+	if s.fingerprint == nil || atomic.LoadInt32(s.fingerprintChecked) == 1 {
+		return nil
+	}
+
+	key := s.KeyValueKey + metaKeySuffix
+	fields := logan.F{"key": s.KeyValueKey}
+	raw, err := json.Marshal(s.fingerprint)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal fingerprint", fields)
+	}
+
+	storedKV, err := s.KeyValueQ.Get(key)
+	if err != nil {
+		return errors.Wrap(err, "failed to get fingerprint of the cursor", fields)
+	}
+	if storedKV == nil {
+		// streamers initializing the cursor at once must not both claim it
+		if swapper, ok := s.KeyValueQ.(CompareAndSwapper); ok {
+			inserted, err := swapper.InsertIfAbsent(KeyValue{Key: key, Value: string(raw)})
+			if err != nil {
+				return errors.Wrap(err, "failed to store fingerprint of the cursor", fields)
+			}
+			if !inserted {
+				// another streamer stored its fingerprint meanwhile
+				return s.checkFingerprint()
+			}
+		} else if err = s.KeyValueQ.Upsert(KeyValue{Key: key, Value: string(raw)}); err != nil {
+			return errors.Wrap(err, "failed to store fingerprint of the cursor", fields)
+		}
+		atomic.StoreInt32(s.fingerprintChecked, 1)
+		return nil
+	}
+
+	var stored StreamerFingerprint
+	if err = json.Unmarshal([]byte(storedKV.Value), &stored); err != nil {
+		return errors.Wrap(err, "failed to parse fingerprint of the cursor", fields.Add("value", storedKV.Value))
+	}
+	if stored != *s.fingerprint {
+		if !s.forceAdopt {
+			return errors.From(&KeyCollisionError{Key: s.KeyValueKey, Stored: stored, Current: *s.fingerprint}, fields)
+		}
+		if err = s.KeyValueQ.Upsert(KeyValue{Key: key, Value: string(raw)}); err != nil {
+			return errors.Wrap(err, "failed to adopt cursor", fields)
+		}
+		if s.Log != nil {
+			s.Log.WithFields(fields.Merge(logan.F{
+				"stored_fingerprint": stored.String(),
+				"fingerprint":        s.fingerprint.String(),
+			})).Warn("Cursor adopted from another streamer")
+		}
+	}
+	atomic.StoreInt32(s.fingerprintChecked, 1)
+	return nil
+}
@@ -0,0 +1,121 @@
+package dban_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	logan "gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+type fingerprintOrder struct{ ID int }
+
+type fingerprintPayment struct{ ID int }
+
+func TestDeriveStreamerKey(t *testing.T) {
+	assert.Equal(t, "billing:dban_test.fingerprintOrder", dban.DeriveStreamerKey("billing", fingerprintOrder{}))
+	assert.Equal(t, "billing:dban_test.fingerprintOrder", dban.DeriveStreamerKey("billing", (*fingerprintOrder)(nil)))
+	assert.Equal(t, "billing:dban_test.fingerprintPayment", dban.DeriveStreamerKey("billing", fingerprintPayment{}))
+	assert.Equal(t, "int", dban.DeriveStreamerKey("", 0))
+}
+
+func TestStreamerFingerprint(t *testing.T) {
+	const key = "fingerprint"
+	batchSize := uint64(2)
+	orders := func(kvQ dban.KeyValueQ, forceAdopt bool) dban.StreamerInitParams[fingerprintOrder] {
+		return dban.StreamerInitParams[fingerprintOrder]{
+			Stream:      dbantest.NewSliceStreamable([]fingerprintOrder{{1}, {2}, {3}}),
+			KeyValueQ:   kvQ,
+			KeyValueKey: key,
+			BatchSize:   &batchSize,
+			Fingerprint: true,
+			ForceAdopt:  forceAdopt,
+		}
+	}
+	payments := func(kvQ dban.KeyValueQ, forceAdopt bool) dban.StreamerInitParams[fingerprintPayment] {
+		return dban.StreamerInitParams[fingerprintPayment]{
+			Stream:      dbantest.NewSliceStreamable([]fingerprintPayment{{1}, {2}, {3}}),
+			KeyValueQ:   kvQ,
+			KeyValueKey: key,
+			BatchSize:   &batchSize,
+			Fingerprint: true,
+			ForceAdopt:  forceAdopt,
+		}
+	}
+
+	t.Run("collision", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer, err := dban.NewStreamerChecked(orders(kvQ, false))
+		require.NoError(t, err)
+		require.NoError(t, streamer.FormListAndProcess(func(context.Context, fingerprintOrder) error { return nil }))
+		meta := kvQ.MustGet(key + ":meta").Value
+		assert.Contains(t, meta, "fingerprintOrder")
+
+		_, err = dban.NewStreamerChecked(payments(kvQ, false))
+		require.True(t, dban.Is(err, dban.ErrKeyCollision), "%v", err)
+		collision, ok := logan.Cause(err).(*dban.KeyCollisionError)
+		require.True(t, ok)
+		assert.Equal(t, key, collision.Key)
+		assert.Equal(t, "github.com/zspkg/dban_test.fingerprintOrder", collision.Stored.EntityType)
+		assert.Equal(t, "github.com/zspkg/dban_test.fingerprintPayment", collision.Current.EntityType)
+
+		colliding := dban.NewStreamer(payments(kvQ, false))
+		_, err = colliding.FormList()
+		assert.True(t, dban.Is(err, dban.ErrKeyCollision), "%v", err)
+		page, err := streamer.GetCurrentPage()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), page, "the cursor must not be touched by the colliding streamer")
+		assert.Equal(t, meta, kvQ.MustGet(key+":meta").Value)
+
+		same, err := dban.NewStreamerChecked(orders(kvQ, false))
+		require.NoError(t, err, "a streamer with the same fingerprint must reuse the cursor")
+		entities, err := same.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []fingerprintOrder{{3}}, entities)
+	})
+
+	t.Run("adopt", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := dban.NewStreamer(orders(kvQ, false))
+		_, err := streamer.FormList()
+		require.NoError(t, err)
+
+		adopting, err := dban.NewStreamerChecked(payments(kvQ, true))
+		require.NoError(t, err)
+		entities, err := adopting.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []fingerprintPayment{{3}}, entities, "the cursor must be taken over")
+		assert.Contains(t, kvQ.MustGet(key+":meta").Value, "fingerprintPayment")
+
+		_, err = dban.NewStreamerChecked(orders(kvQ, false))
+		assert.True(t, dban.Is(err, dban.ErrKeyCollision), "the cursor belongs to the adopting streamer: %v", err)
+	})
+
+	t.Run("batch size", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		_, err := dban.NewStreamerChecked(orders(kvQ, false))
+		require.NoError(t, err)
+
+		resized := orders(kvQ, false)
+		otherSize := uint64(3)
+		resized.BatchSize = &otherSize
+		_, err = dban.NewStreamerChecked(resized)
+		assert.True(t, dban.Is(err, dban.ErrKeyCollision), "%v", err)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		params := orders(kvQ, false)
+		params.Fingerprint = false
+		streamer, err := dban.NewStreamerChecked(params)
+		require.NoError(t, err)
+		_, err = streamer.FormList()
+		require.NoError(t, err)
+		meta, err := kvQ.Get(key + ":meta")
+		require.NoError(t, err)
+		assert.Nil(t, meta)
+	})
+}
@@ -0,0 +1,257 @@
+package dban
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+const (
+	defaultGroupRestartDelay    = time.Second
+	defaultGroupMaxRestartDelay = time.Minute
+)
+
+// GroupMember is a Run loop of a streamer bound to its processing function, which a
+// StreamerGroup runs (see StreamerRun)
+type GroupMember interface {
+	run(ctx context.Context) error
+	stats() StreamerStats
+	// reporter returns the streamer for StatusHandler
+	reporter() StatusReporter
+	// invalid returns the error of the construction of the streamer, which a restart does
+	// not fix
+	invalid() error
+}
+
+type streamerRun[T any] struct {
+	streamer Streamer[T]
+	fn       func(ctx context.Context, t T) error
+	cfg      RunConfig
+}
+
+// StreamerRun binds the streamer to fn and cfg of its Run loop, so that it could be added to
+// a StreamerGroup
+func StreamerRun[T any](s Streamer[T], fn func(ctx context.Context, t T) error, cfg RunConfig) GroupMember {
+	return streamerRun[T]{streamer: s, fn: fn, cfg: cfg}
+}
+
+func (r streamerRun[T]) run(ctx context.Context) error {
+	return r.streamer.Run(ctx, r.fn, r.cfg)
+}
+
+func (r streamerRun[T]) stats() StreamerStats {
+	return r.streamer.GetStats()
+}
+
+func (r streamerRun[T]) reporter() StatusReporter {
+	return r.streamer
+}
+
+func (r streamerRun[T]) invalid() error {
+	if s, ok := r.streamer.(*streamer[T]); ok {
+		return s.err
+	}
+	return nil
+}
+
+// StreamerGroupOption is an optional parameter of a streamer group
+type StreamerGroupOption func(*StreamerGroup)
+
+// WithGroupLog makes the streamer group log restarts of the streamers with log, adding the
+// name of the streamer to the fields
+func WithGroupLog(log *logan.Entry) StreamerGroupOption {
+	return func(g *StreamerGroup) {
+		g.log = log
+	}
+}
+
+// WithRestartBackoff sets the delay before a failed streamer is restarted, which doubles
+// with every failure in a row up to max, a second and a minute by default
+func WithRestartBackoff(base, max time.Duration) StreamerGroupOption {
+	return func(g *StreamerGroup) {
+		g.restartDelay, g.maxRestartDelay = base, max
+	}
+}
+
+// WithMaxRestarts makes a streamer failed that many times in a row after being restarted
+// stop, its last failure being returned by Run. Streamers are restarted endlessly by default
+func WithMaxRestarts(n int) StreamerGroupOption {
+	return func(g *StreamerGroup) {
+		g.maxRestarts = n
+	}
+}
+
+// WithGroupClock sets the clock the restart delays are waited for with, the system one by
+// default
+func WithGroupClock(clock Clock) StreamerGroupOption {
+	return func(g *StreamerGroup) {
+		g.clock = clock
+	}
+}
+
+// StreamerGroup runs the Run loops of several streamers at once, restarting the ones that
+// fail and stopping all of them together, e.g. for a service running a streamer per table
+type StreamerGroup struct {
+	log             *logan.Entry
+	clock           Clock
+	restartDelay    time.Duration
+	maxRestartDelay time.Duration
+	maxRestarts     int
+
+	mu      sync.Mutex
+	members map[string]GroupMember
+	err     error
+	running bool
+}
+
+// NewStreamerGroup creates an empty streamer group (see Add)
+func NewStreamerGroup(opts ...StreamerGroupOption) *StreamerGroup {
+	g := &StreamerGroup{
+		clock:           systemClock{},
+		restartDelay:    defaultGroupRestartDelay,
+		maxRestartDelay: defaultGroupMaxRestartDelay,
+		members:         make(map[string]GroupMember),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// Add adds the streamer loop under the name, which must be unique within the group, as it
+// names the streamer in the logs, the errors and Status. A duplicate name makes Run fail.
+// The streamers added once Run is called are not run by it
+func (g *StreamerGroup) Add(name string, member GroupMember) *StreamerGroup {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.members[name]; ok && g.err == nil {
+		g.err = errors.From(errors.New("streamer name is duplicated"), logan.F{"streamer": name})
+	}
+	g.members[name] = member
+	return g
+}
+
+// Run runs the loops of the streamers until ctx is canceled. A loop returning an error,
+// e.g. with RunConfig.FailFast or once the cursor fails to be flushed, is logged and
+// restarted after the backoff (see WithRestartBackoff), unless the streamer is invalid or
+// has failed more than the max restarts in a row (see WithMaxRestarts), which makes the
+// failure terminal and leaves the rest of the streamers running. A loop that ran for longer
+// than the max delay is not considered failed in a row with the previous one, while a loop
+// returning nil, e.g. once fn returns ErrStopStreaming, is not restarted. Canceling ctx
+// makes every loop finish the list in flight, and Run returns once all of them are done
+// with, with the terminal failures collected as SourceErrors if there are any
+func (g *StreamerGroup) Run(ctx context.Context) error {
+	g.mu.Lock()
+	if g.err != nil {
+		g.mu.Unlock()
+		return errors.Wrap(g.err, "invalid streamer group")
+	}
+	if g.running {
+		g.mu.Unlock()
+		return errors.New("streamer group is already running")
+	}
+	g.running = true
+	names := make([]string, 0, len(g.members))
+	for name := range g.members {
+		names = append(names, name)
+	}
+	members := make(map[string]GroupMember, len(g.members))
+	for name, member := range g.members {
+		members[name] = member
+	}
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		g.running = false
+		g.mu.Unlock()
+	}()
+	sort.Strings(names)
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed SourceErrors
+	)
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if err := g.runMember(ctx, name, members[name]); err != nil {
+				mu.Lock()
+				failed.Errors = append(failed.Errors, &SourceError{Source: name, Err: err})
+				mu.Unlock()
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	sort.Slice(failed.Errors, func(i, j int) bool {
+		return failed.Errors[i].Source < failed.Errors[j].Source
+	})
+	return failed.orNil()
+}
+
+// runMember runs the loop of the member until ctx is done or it fails terminally
+func (g *StreamerGroup) runMember(ctx context.Context, name string, member GroupMember) error {
+	if err := member.invalid(); err != nil {
+		return errors.Wrap(err, "invalid streamer")
+	}
+
+	failures := 0
+	for {
+		started := g.clock.Now()
+		err := member.run(ctx)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		if g.clock.Now().Sub(started) > g.maxRestartDelay {
+			failures = 0
+		}
+		failures++
+		if g.maxRestarts > 0 && failures > g.maxRestarts {
+			return errors.Wrap(err, "streamer failed too many times in a row", logan.F{"failures": failures})
+		}
+
+		delay := g.delay(failures)
+		if g.log != nil {
+			g.log.WithError(err).WithFields(logan.F{
+				"streamer": name,
+				"failures": failures,
+				"restart":  delay,
+			}).Error("Streamer failed, restarting")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-g.clock.After(delay):
+		}
+	}
+}
+
+// delay returns the delay before the restart following the failures in a row
+func (g *StreamerGroup) delay(failures int) time.Duration {
+	delay := g.restartDelay
+	for i := 1; i < failures && delay < g.maxRestartDelay; i++ {
+		delay *= 2
+	}
+	if delay > g.maxRestartDelay {
+		delay = g.maxRestartDelay
+	}
+	return delay
+}
+
+// Status returns the counters of the streamers by their names, e.g. for a health endpoint
+func (g *StreamerGroup) Status() map[string]StreamerStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	status := make(map[string]StreamerStats, len(g.members))
+	for name, member := range g.members {
+		status[name] = member.stats()
+	}
+	return status
+}
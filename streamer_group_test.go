@@ -0,0 +1,136 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerGroup(t *testing.T) {
+	failure := errors.New("boom")
+	newStreamer := func(key string) dban.Streamer[int] {
+		batchSize := uint64(1)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3}),
+			KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey: key,
+			BatchSize:   &batchSize,
+			// the end of the stream is polled once the clock is advanced, which it never is
+			Clock: &fakeClock{},
+		})
+	}
+	// processing records the entities and fails the first failures calls
+	type processing struct {
+		mu        sync.Mutex
+		processed []int
+		failures  int
+	}
+	process := func(p *processing) func(context.Context, int) error {
+		return func(_ context.Context, i int) error {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.processed = append(p.processed, i)
+			if len(p.processed) <= p.failures {
+				return failure
+			}
+			return nil
+		}
+	}
+	processedOf := func(p *processing) []int {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return append([]int(nil), p.processed...)
+	}
+	failFast := dban.RunConfig{FailFast: true}
+
+	t.Run("runs", func(t *testing.T) {
+		var foos, bars processing
+		group := dban.NewStreamerGroup().
+			Add("foos", dban.StreamerRun(newStreamer("group-foos"), process(&foos), dban.RunConfig{})).
+			Add("bars", dban.StreamerRun(newStreamer("group-bars"), process(&bars), dban.RunConfig{}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- group.Run(ctx) }()
+
+		require.Eventually(t, func() bool {
+			return len(processedOf(&foos)) == 3 && len(processedOf(&bars)) == 3
+		}, time.Second, time.Millisecond)
+		status := group.Status()
+		require.Len(t, status, 2)
+		assert.GreaterOrEqual(t, status["foos"].Processed, int64(3))
+		assert.GreaterOrEqual(t, status["bars"].Processed, int64(3))
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("restarted", func(t *testing.T) {
+		start := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)
+		clock := &fakeClock{now: start}
+		failing := processing{failures: 2}
+		group := dban.NewStreamerGroup(dban.WithGroupClock(clock), dban.WithRestartBackoff(time.Second, time.Minute)).
+			Add("failing", dban.StreamerRun(newStreamer("group-restarted"), process(&failing), failFast))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- group.Run(ctx) }()
+
+		// the delay doubles with every failure in a row
+		assert.Equal(t, start.Add(time.Second), clock.advanceToNext(t))
+		assert.Equal(t, start.Add(3*time.Second), clock.advanceToNext(t))
+		require.Eventually(t, func() bool { return len(processedOf(&failing)) == 3 }, time.Second, time.Millisecond)
+
+		cancel()
+		require.NoError(t, <-done)
+		assert.Equal(t, []int{1, 2, 3}, processedOf(&failing), "the failed lists must be moved past")
+	})
+
+	t.Run("terminal", func(t *testing.T) {
+		clock := &fakeClock{}
+		failing := processing{failures: 3}
+		var ok processing
+		group := dban.NewStreamerGroup(dban.WithGroupClock(clock), dban.WithMaxRestarts(1)).
+			Add("failing", dban.StreamerRun(newStreamer("group-terminal"), process(&failing), failFast)).
+			Add("invalid", dban.StreamerRun(dban.NewStreamer(dban.StreamerInitParams[int]{
+				Stream:      dbantest.NewSliceStreamable([]int{1}),
+				KeyValueKey: "group-invalid",
+			}), process(&ok), failFast)).
+			Add("ok", dban.StreamerRun(newStreamer("group-ok"), process(&ok), failFast))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- group.Run(ctx) }()
+
+		clock.advanceToNext(t)
+		require.Eventually(t, func() bool { return len(processedOf(&failing)) == 2 }, time.Second, time.Millisecond)
+		require.Eventually(t, func() bool { return len(processedOf(&ok)) == 3 }, time.Second, time.Millisecond)
+		cancel()
+
+		err := <-done
+		var failed *dban.SourceErrors
+		require.True(t, errors.As(err, &failed), "unexpected error: %v", err)
+		require.Len(t, failed.Errors, 2)
+		assert.Equal(t, "failing", failed.Errors[0].Source)
+		assert.True(t, dban.Is(failed.Errors[0], failure))
+		assert.Equal(t, "invalid", failed.Errors[1].Source)
+		assert.ErrorContains(t, failed.Errors[1], "KeyValueQ is required")
+		assert.Equal(t, []int{1, 2}, processedOf(&failing), "the streamer must not be restarted once more")
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		var p processing
+		err := dban.NewStreamerGroup().
+			Add("foos", dban.StreamerRun(newStreamer("group-duplicate"), process(&p), dban.RunConfig{})).
+			Add("foos", dban.StreamerRun(newStreamer("group-duplicate"), process(&p), dban.RunConfig{})).
+			Run(context.Background())
+		assert.ErrorContains(t, err, "streamer name is duplicated")
+	})
+}
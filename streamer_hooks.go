@@ -0,0 +1,70 @@
+package dban
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/distributed_lab/logan/v3"
+)
+
+// BatchStartHook is called before the streamer processes the size entities of the list
+// taken from page
+type BatchStartHook func(ctx context.Context, page uint64, size int)
+
+// BatchEndHook is called once the streamer is done with the list of size entities taken
+// from page, which took the time given, with the error that stopped it, if any
+type BatchEndHook func(ctx context.Context, page uint64, size int, took time.Duration, err error)
+
+// EmptyPageHook is called when the streamer selects an empty page, i.e. reaches the end of
+// the stream
+type EmptyPageHook func(ctx context.Context, page uint64)
+
+func (s *streamer[T]) batchStarted(page uint64, size int) {
+	s.emit(BatchStarted{Key: s.KeyValueKey, Page: page})
+	if s.OnBatchStart == nil {
+		return
+	}
+
+	defer s.recoverHook("OnBatchStart", page)
+	s.OnBatchStart(s.Ctx, page, size)
+}
+
+func (s *streamer[T]) batchEnded(completed BatchCompleted, size int, err error) {
+	if err == nil {
+		s.adaptBatch(size, completed.Duration)
+		// a list processed holding the cursor is recorded once the transaction advancing the
+		// cursor is committed (see formAndProcessHeld)
+		if !s.holdCursor {
+			s.recordLastRun(completed.Page, size, completed.Processed)
+		}
+	}
+	s.emit(completed)
+	if s.OnBatchEnd == nil {
+		return
+	}
+
+	defer s.recoverHook("OnBatchEnd", completed.Page)
+	s.OnBatchEnd(s.Ctx, completed.Page, size, completed.Duration, err)
+}
+
+// endOfStream reports the empty page the end of the stream is reached at
+func (s *streamer[T]) endOfStream(page uint64) {
+	s.emit(EndOfStream{Key: s.KeyValueKey})
+	if s.OnEmpty == nil {
+		return
+	}
+
+	defer s.recoverHook("OnEmpty", page)
+	s.OnEmpty(s.Ctx, page)
+}
+
+// recoverHook swallows a panic of a hook so that it cannot break the streamer
+func (s *streamer[T]) recoverHook(hook string, page uint64) {
+	if rec := recover(); rec != nil && s.Log != nil {
+		s.Log.WithRecover(rec).WithFields(logan.F{
+			"hook": hook,
+			"key":  s.KeyValueKey,
+			"page": page,
+		}).Error("Streamer hook panicked")
+	}
+}
@@ -0,0 +1,58 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerHooks(t *testing.T) {
+	var calls []string
+	batchSize := uint64(2)
+	streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3}),
+		KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+		KeyValueKey: cursorKey,
+		BatchSize:   &batchSize,
+		OnBatchStart: func(_ context.Context, page uint64, size int) {
+			calls = append(calls, fmt.Sprintf("start %d %d", page, size))
+		},
+		OnBatchEnd: func(_ context.Context, page uint64, size int, took time.Duration, err error) {
+			assert.GreaterOrEqual(t, took, time.Duration(0))
+			calls = append(calls, fmt.Sprintf("end %d %d %v", page, size, err != nil))
+		},
+		OnEmpty: func(_ context.Context, page uint64) {
+			calls = append(calls, fmt.Sprintf("empty %d", page))
+			panic("hooks must not break the streamer")
+		},
+	})
+
+	failure := errors.New("boom")
+	for _, fail := range []bool{false, true, false} {
+		err := streamer.FormListAndProcess(func(_ context.Context, i int) error {
+			if fail {
+				return failure
+			}
+			return nil
+		})
+		assert.Equal(t, fail, err != nil)
+	}
+	assert.Equal(t, []string{
+		"start 0 2", "end 0 2 false",
+		"start 1 1", "end 1 1 true",
+		"empty 2", "start 0 2", "end 0 2 false",
+	}, calls, "the end of the stream is reached on the third call, taking the first page again")
+
+	calls = nil
+	report, err := streamer.ProcessAll(func(context.Context, int) error { return nil })
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), report.Entities)
+	assert.Equal(t, []string{"start 1 1", "end 1 1 false"}, calls, "a short page ends the stream without selecting the next one")
+}
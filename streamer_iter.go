@@ -0,0 +1,39 @@
+//go:build go1.23
+
+package dban
+
+import (
+	"iter"
+
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// streamerIter is the part of Streamer built with range-over-func iterators, which
+// require Go 1.23
+type streamerIter[T any] interface {
+	// All iterates over the entities page by page until the end of the stream is reached,
+	// yielding a failure, if any, as the last error. The cursor is moved past a page once all
+	// of its entities are yielded, so breaking the loop leaves the page to be iterated again
+	All() iter.Seq2[T, error]
+}
+
+// errBreak stops delivering entities once the loop over All is broken
+var errBreak = errors.New("iteration stopped")
+
+// All delivers the entities with deliverAll, like Stream does, while the cursor is kept
+// locked (with a TransactionalKeyValueQ) during the iteration over a page. It stops between
+// lists once Ctx is done
+func (s *streamer[T]) All() iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		err := s.deliverAll(s.Ctx, func(entity T) error {
+			if !yield(entity, nil) {
+				return errBreak
+			}
+			return nil
+		})
+		if err != nil && !Is(err, errBreak) {
+			var zero T
+			yield(zero, s.failed(err))
+		}
+	}
+}
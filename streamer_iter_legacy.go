@@ -0,0 +1,7 @@
+//go:build !go1.23
+
+package dban
+
+// streamerIter is the part of Streamer built with range-over-func iterators, which is
+// empty before Go 1.23
+type streamerIter[T any] interface{}
@@ -0,0 +1,77 @@
+//go:build go1.23
+
+package dban_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerAll(t *testing.T) {
+	newStreamer := func(stream dban.Streamable[int], kvQ dban.KeyValueQ) dban.Streamer[int] {
+		batchSize := uint64(2)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:      stream,
+			KeyValueQ:   kvQ,
+			KeyValueKey: cursorKey,
+			BatchSize:   &batchSize,
+		})
+	}
+
+	t.Run("to the end", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		var received []int
+		for entity, err := range newStreamer(dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5}), kvQ).All() {
+			require.NoError(t, err)
+			received = append(received, entity)
+		}
+		assert.Equal(t, []int{1, 2, 3, 4, 5}, received)
+		assert.Equal(t, "2", kvQ.MustGet(cursorKey).Value)
+	})
+
+	t.Run("break", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "1"}))
+		streamer := newStreamer(dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5}), kvQ)
+
+		for entity, err := range streamer.All() {
+			require.NoError(t, err)
+			assert.Equal(t, 3, entity)
+			break
+		}
+		assert.Equal(t, "1", kvQ.MustGet(cursorKey).Value, "the page broken in is not expected to be passed")
+
+		var received []int
+		for entity, err := range streamer.All() {
+			require.NoError(t, err)
+			received = append(received, entity)
+		}
+		assert.Equal(t, []int{3, 4, 5}, received)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		failure := errors.New("boom")
+		stream := dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5}, dbantest.WithPageError(1, failure))
+
+		var (
+			received []int
+			failed   error
+		)
+		for entity, err := range newStreamer(stream, dbantest.NewMemoryKeyValueQ()).All() {
+			if err != nil {
+				failed = err
+				continue
+			}
+			received = append(received, entity)
+		}
+		assert.Equal(t, []int{1, 2}, received)
+		var streamErr *dban.StreamError
+		require.ErrorAs(t, failed, &streamErr)
+		assert.True(t, dban.Is(failed, failure))
+	})
+}
@@ -0,0 +1,139 @@
+package dban
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// lastRunKeySuffix is appended to the cursor key to form a key of the LastRunInfo of the
+// streamer
+const lastRunKeySuffix = ":last_run"
+
+// LastRunInfo tells when a streamer last processed a list successfully. It is stored as
+// JSON under the cursor key with the ":last_run" suffix (see GetLastRunInfo)
+type LastRunInfo struct {
+	// At is the time the list was processed at
+	At time.Time `json:"at"`
+	// Page is the page the list was taken from
+	Page uint64 `json:"page"`
+	// Entities is the number of the entities of the list
+	Entities int `json:"entities"`
+	// Processed is the number of the entities processed since the counter was reset (see
+	// ResetRunCounters)
+	Processed uint64 `json:"processed"`
+}
+
+// lastRun keeps the counter of the processed entities between the lists, so that it is
+// read from the key value storage once per streamer
+type lastRun struct {
+	mu        sync.Mutex
+	loaded    bool
+	processed uint64
+}
+
+func newLastRun(skip bool) *lastRun {
+	if skip {
+		return nil
+	}
+	return &lastRun{}
+}
+
+func (s *streamer[T]) GetLastRunInfo() (*LastRunInfo, error) {
+	if s.err != nil {
+		return nil, errors.Wrap(s.err, "invalid streamer")
+	}
+	return s.readLastRun()
+}
+
+// readLastRun reads the LastRunInfo stored, returning nil if it is missing or corrupt
+func (s *streamer[T]) readLastRun() (*LastRunInfo, error) {
+	stored, err := s.KeyValueQ.Get(s.KeyValueKey + lastRunKeySuffix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get last run info", logan.F{"key": s.KeyValueKey})
+	}
+	if stored == nil {
+		return nil, nil
+	}
+
+	var info LastRunInfo
+	if err = json.Unmarshal([]byte(stored.Value), &info); err != nil {
+		if s.Log != nil {
+			s.Log.WithError(err).WithField("key", s.KeyValueKey).Warn("Last run info is corrupt")
+		}
+		return nil, nil
+	}
+	return &info, nil
+}
+
+func (s *streamer[T]) ResetRunCounters() error {
+	if s.err != nil {
+		return errors.Wrap(s.err, "invalid streamer")
+	}
+	if s.lastRun != nil {
+		s.lastRun.mu.Lock()
+		defer s.lastRun.mu.Unlock()
+		s.lastRun.loaded, s.lastRun.processed = true, 0
+	}
+
+	info, err := s.readLastRun()
+	if err != nil || info == nil {
+		return err
+	}
+	info.Processed = 0
+	if err = s.writeLastRun(*info); err != nil {
+		return errors.Wrap(err, "failed to reset run counters", logan.F{"key": s.KeyValueKey})
+	}
+	return nil
+}
+
+func (s *streamer[T]) writeLastRun(info LastRunInfo) error {
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal last run info")
+	}
+	return s.KeyValueQ.Upsert(KeyValue{Key: s.KeyValueKey + lastRunKeySuffix, Value: string(raw)})
+}
+
+// recordLastRun stores the LastRunInfo of the list taken from page once it is processed.
+// The counter stored is read once, so that it costs a single write per list. It is written
+// outside the transaction of the cursor, and a failure to store it is logged only, as the
+// list is processed anyway
+func (s *streamer[T]) recordLastRun(page uint64, entities int, processed int) {
+	if s.lastRun == nil || entities == 0 {
+		return
+	}
+
+	s.lastRun.mu.Lock()
+	defer s.lastRun.mu.Unlock()
+	if !s.lastRun.loaded {
+		info, err := s.readLastRun()
+		if err != nil {
+			if s.Log != nil {
+				s.Log.WithError(err).WithField("key", s.KeyValueKey).Warn("Failed to read last run info")
+			}
+			return
+		}
+		if info != nil {
+			s.lastRun.processed = info.Processed
+		}
+		s.lastRun.loaded = true
+	}
+
+	info := LastRunInfo{
+		At:        s.Clock.Now(),
+		Page:      page,
+		Entities:  entities,
+		Processed: s.lastRun.processed + uint64(processed),
+	}
+	if err := s.writeLastRun(info); err != nil {
+		if s.Log != nil {
+			s.Log.WithError(err).WithField("key", s.KeyValueKey).Warn("Failed to write last run info")
+		}
+		return
+	}
+	s.lastRun.processed = info.Processed
+}
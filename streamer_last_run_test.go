@@ -0,0 +1,132 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerLastRunInfo(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newStreamer := func(kvQ dban.KeyValueQ, skip bool) dban.Streamer[int] {
+		batchSize := uint64(2)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:          dbantest.NewSliceStreamable([]int{1, 2, 3}),
+			KeyValueQ:       kvQ,
+			KeyValueKey:     cursorKey,
+			BatchSize:       &batchSize,
+			Clock:           &fakeClock{now: start},
+			SkipLastRunInfo: skip,
+		})
+	}
+	succeed := func(context.Context, int) error { return nil }
+
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	streamer := newStreamer(kvQ, false)
+	info, err := streamer.GetLastRunInfo()
+	require.NoError(t, err)
+	assert.Nil(t, info, "there is no last run before the first list")
+
+	require.NoError(t, streamer.FormListAndProcess(succeed))
+	require.NoError(t, streamer.FormListAndProcess(succeed))
+	info, err = streamer.GetLastRunInfo()
+	require.NoError(t, err)
+	assert.Equal(t, &dban.LastRunInfo{At: start, Page: 1, Entities: 1, Processed: 3}, info)
+
+	failure := errors.New("boom")
+	require.Error(t, streamer.FormListAndProcess(func(context.Context, int) error { return failure }))
+	info, err = streamer.GetLastRunInfo()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), info.Processed, "failed lists must not be recorded")
+
+	t.Run("counter is kept across streamers", func(t *testing.T) {
+		require.NoError(t, newStreamer(kvQ, false).FormListAndProcess(succeed))
+		info, err := streamer.GetLastRunInfo()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(4), info.Processed)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		require.NoError(t, streamer.ResetRunCounters())
+		info, err := streamer.GetLastRunInfo()
+		require.NoError(t, err)
+		assert.Equal(t, &dban.LastRunInfo{At: start, Page: 1, Entities: 1, Processed: 0}, info)
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey + ":last_run", Value: "{"}))
+		info, err := streamer.GetLastRunInfo()
+		require.NoError(t, err)
+		assert.Nil(t, info)
+	})
+
+	t.Run("skipped", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		require.NoError(t, newStreamer(kvQ, true).FormListAndProcess(succeed))
+		stored, err := kvQ.Get(cursorKey + ":last_run")
+		require.NoError(t, err)
+		assert.Nil(t, stored)
+	})
+}
+
+// abortingTxKeyValueQ fails writes of the last run info made within a transaction and the
+// transaction along with them, the way Postgres aborts a transaction on a failed statement
+type abortingTxKeyValueQ struct {
+	dban.KeyValueQ
+	// aborted is set once a write of the transaction fails, nil outside of one
+	aborted *bool
+}
+
+func (q *abortingTxKeyValueQ) Transaction(fn func(q dban.KeyValueQ) error) error {
+	if q.aborted != nil {
+		return fn(q)
+	}
+
+	tx := &abortingTxKeyValueQ{KeyValueQ: q.KeyValueQ, aborted: new(bool)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if *tx.aborted {
+		return errors.New("current transaction is aborted")
+	}
+	return nil
+}
+
+func (q *abortingTxKeyValueQ) WithinTx() bool {
+	return q.aborted != nil
+}
+
+func (q *abortingTxKeyValueQ) Upsert(kv dban.KeyValue) error {
+	if q.aborted != nil && strings.HasSuffix(kv.Key, ":last_run") {
+		*q.aborted = true
+		return errors.New("value too long")
+	}
+	return q.KeyValueQ.Upsert(kv)
+}
+
+func TestStreamerLastRunInfoAdvanceAfterProcessing(t *testing.T) {
+	memory := dbantest.NewMemoryKeyValueQ()
+	batchSize := uint64(2)
+	streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:                 dbantest.NewSliceStreamable([]int{1, 2, 3}),
+		KeyValueQ:              &abortingTxKeyValueQ{KeyValueQ: memory},
+		KeyValueKey:            cursorKey,
+		BatchSize:              &batchSize,
+		AdvanceAfterProcessing: true,
+	})
+
+	// the last run info is written once the transaction advancing the cursor is committed
+	require.NoError(t, streamer.FormListAndProcess(func(context.Context, int) error { return nil }))
+	assert.Equal(t, "1", memory.MustGet(cursorKey).Value)
+	info, err := streamer.GetLastRunInfo()
+	require.NoError(t, err)
+	require.NotNil(t, info)
+	assert.Equal(t, uint64(2), info.Processed)
+}
@@ -0,0 +1,96 @@
+package dban
+
+import (
+	"fmt"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// offsetKeySuffix is appended to the cursor key to form a key storing the number of
+// entities of the page processed so far (see CheckpointEveryNEntities)
+const offsetKeySuffix = ":offset"
+
+// entityOffsets stores the progress within the page processed by a streamer, so that the
+// entities processed before a crash are skipped once the page is formed again
+type entityOffsets struct {
+	every uint64
+	// kvQ is the querier outside of the transaction holding the cursor, so that the
+	// progress is persisted before the transaction is done
+	kvQ KeyValueQ
+	key string
+}
+
+func newEntityOffsets(every *uint64, kvQ KeyValueQ, key string) *entityOffsets {
+	if every == nil || *every == 0 {
+		return nil
+	}
+	return &entityOffsets{every: *every, kvQ: kvQ, key: key + offsetKeySuffix}
+}
+
+// resumeOffset returns the number of the first entities of the page processed already
+func (s *streamer[T]) resumeOffset(page uint64) (int, error) {
+	if !s.trackOffset || s.offsets == nil {
+		return 0, nil
+	}
+
+	stored, err := s.offsets.kvQ.Get(s.offsets.key)
+	if err != nil {
+		return 0, s.fail(StageCursorRead, page, errors.Wrap(err, "failed to get offset within page"))
+	}
+	if stored == nil {
+		return 0, nil
+	}
+
+	var offsetPage uint64
+	var offset int
+	if _, err = fmt.Sscanf(stored.Value, "%d:%d", &offsetPage, &offset); err != nil || offset < 0 {
+		if s.Log != nil {
+			s.Log.WithFields(logan.F{"key": s.offsets.key, "value": stored.Value}).Warn("Ignored corrupt offset within page")
+		}
+		return 0, nil
+	}
+	if offsetPage != page {
+		// the offset was left by a page done with already
+		return 0, nil
+	}
+	return offset, nil
+}
+
+// entityDone stores the number of the first entities of the page done with once every
+// CheckpointEveryNEntities entities. A failed write is logged only, since the entities would
+// be processed again anyway
+func (s *streamer[T]) entityDone(page uint64, done int) {
+	if !s.trackOffset || s.offsets == nil || uint64(done)%s.offsets.every != 0 {
+		return
+	}
+
+	err := s.offsets.kvQ.Upsert(KeyValue{Key: s.offsets.key, Value: fmt.Sprintf("%d:%d", page, done)})
+	if err != nil && s.Log != nil {
+		s.Log.WithError(err).WithFields(logan.F{"key": s.offsets.key, "page": page}).Warn("Failed to write offset within page")
+	}
+}
+
+// pageDone clears the offset within the page once the cursor is moved past it
+func (s *streamer[T]) pageDone() {
+	if !s.trackOffset || s.offsets == nil {
+		return
+	}
+
+	if err := s.offsets.kvQ.Delete(s.offsets.key); err != nil && s.Log != nil {
+		s.Log.WithError(err).WithField("key", s.offsets.key).Warn("Failed to clear offset within page")
+	}
+}
+
+// offsetReached stores the number of the first entities of the page done with right away,
+// e.g. once the streamer is interrupted, using KeyValueQ instead of the querier of the offsets
+func (s *streamer[T]) offsetReached(page uint64, done int) {
+	if !s.trackOffset || s.offsets == nil || done == 0 {
+		return
+	}
+
+	err := s.KeyValueQ.Upsert(KeyValue{Key: s.offsets.key, Value: fmt.Sprintf("%d:%d", page, done)})
+	if err != nil && s.Log != nil {
+		s.Log.WithError(err).WithFields(logan.F{"key": s.offsets.key, "page": page}).Warn("Failed to write offset within page")
+	}
+}
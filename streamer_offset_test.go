@@ -0,0 +1,71 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerCheckpointEveryNEntities(t *testing.T) {
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	newStreamer := func() dban.Streamer[int] {
+		batchSize, every := uint64(6), uint64(2)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:                   dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5, 6, 7}),
+			KeyValueQ:                kvQ,
+			KeyValueKey:              cursorKey,
+			BatchSize:                &batchSize,
+			AdvanceAfterProcessing:   true,
+			CheckpointEveryNEntities: &every,
+		})
+	}
+
+	var processed []int
+	failure := errors.New("boom")
+	err := newStreamer().FormListAndProcess(func(_ context.Context, i int) error {
+		if i == 5 {
+			return failure
+		}
+		processed = append(processed, i)
+		return nil
+	})
+	assert.True(t, dban.Is(err, failure))
+	assert.Equal(t, "0:4", kvQ.MustGet(cursorKey+":offset").Value)
+
+	// the streamer restarted skips the entities processed already
+	require.NoError(t, newStreamer().FormListAndProcess(func(_ context.Context, i int) error {
+		processed = append(processed, i)
+		return nil
+	}))
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, processed)
+	assert.Equal(t, "1", kvQ.MustGet(cursorKey).Value)
+	offset, err := kvQ.Get(cursorKey + ":offset")
+	require.NoError(t, err)
+	assert.Nil(t, offset, "the offset must be cleared once the page is done")
+
+	t.Run("stale offset", func(t *testing.T) {
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey + ":offset", Value: "0:4"}))
+		processed = nil
+		require.NoError(t, newStreamer().FormListAndProcess(func(_ context.Context, i int) error {
+			processed = append(processed, i)
+			return nil
+		}))
+		assert.Equal(t, []int{7}, processed, "the offset of another page must be ignored")
+	})
+
+	t.Run("no AdvanceAfterProcessing", func(t *testing.T) {
+		every := uint64(2)
+		_, err := dban.NewStreamerChecked(dban.StreamerInitParams[int]{
+			Stream:                   dbantest.NewSliceStreamable([]int{1}),
+			KeyValueQ:                kvQ,
+			KeyValueKey:              cursorKey,
+			CheckpointEveryNEntities: &every,
+		})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,17 @@
+package dban
+
+import "runtime/debug"
+
+// recovered calls process, converting its panic into a PanicError unless RecoverPanics is
+// off
+func (s *streamer[T]) recovered(process func() error) (err error) {
+	if !s.RecoverPanics {
+		return process()
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = &PanicError{Value: rec, Stack: debug.Stack()}
+		}
+	}()
+	return process()
+}
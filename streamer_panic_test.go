@@ -0,0 +1,93 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerPanic(t *testing.T) {
+	newStreamer := func(key string, recoverPanics *bool) dban.Streamer[int] {
+		batchSize := uint64(2)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:        dbantest.NewSliceStreamable([]int{1, 2, 3, 4}),
+			KeyValueQ:     dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey:   key,
+			BatchSize:     &batchSize,
+			RecoverPanics: recoverPanics,
+		})
+	}
+	panicAt := func(at int) func(context.Context, int) error {
+		return func(_ context.Context, i int) error {
+			if i == at {
+				panic("boom")
+			}
+			return nil
+		}
+	}
+
+	t.Run("recovered", func(t *testing.T) {
+		streamer := newStreamer("panic-recovered", nil)
+		require.NoError(t, streamer.FormListAndProcess(panicAt(4)))
+
+		err := streamer.FormListAndProcess(panicAt(4))
+		var panicErr *dban.PanicError
+		require.True(t, errors.As(err, &panicErr))
+		assert.Equal(t, "boom", panicErr.Value)
+		assert.Contains(t, string(panicErr.Stack), "TestStreamerPanic")
+		var streamErr *dban.StreamError
+		require.True(t, errors.As(err, &streamErr))
+		assert.Equal(t, uint64(1), streamErr.Page)
+		assert.Equal(t, 1, streamErr.EntityIndex)
+		assert.Equal(t, int64(1), streamer.GetStats().Failed)
+
+		list, err := streamer.FormList()
+		require.NoError(t, err, "the streamer must be usable after a panic")
+		assert.Equal(t, []int{1, 2}, list)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		streamer := newStreamer("panic-batch", nil)
+		err := streamer.FormListAndProcessBatch(func(context.Context, []int) error {
+			panic("boom")
+		})
+		var panicErr *dban.PanicError
+		assert.True(t, errors.As(err, &panicErr))
+	})
+
+	t.Run("skipped", func(t *testing.T) {
+		batchSize, policy := uint64(2), dban.EntityErrorSkipAndLog
+		streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:            dbantest.NewSliceStreamable([]int{1, 2, 3, 4}),
+			KeyValueQ:         dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey:       "panic-skipped",
+			BatchSize:         &batchSize,
+			EntityErrorPolicy: &policy,
+		})
+
+		var processed []int
+		err := streamer.FormListAndProcess(func(_ context.Context, i int) error {
+			if i == 1 {
+				panic("boom")
+			}
+			processed = append(processed, i)
+			return nil
+		})
+		require.NoError(t, err, "the panic must be handled by the error policy")
+		assert.Equal(t, []int{2}, processed)
+		assert.Equal(t, int64(1), streamer.GetStats().Failed)
+	})
+
+	t.Run("not recovered", func(t *testing.T) {
+		recoverPanics := false
+		streamer := newStreamer("panic-not-recovered", &recoverPanics)
+		assert.PanicsWithValue(t, "boom", func() {
+			_ = streamer.FormListAndProcess(panicAt(1))
+		})
+	})
+}
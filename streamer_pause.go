@@ -0,0 +1,56 @@
+package dban
+
+import (
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+func (s *streamer[T]) Pause() error {
+	if s.err != nil {
+		return errors.Wrap(s.err, "invalid streamer")
+	}
+	if err := s.KeyValueQ.Upsert(KeyValue{Key: s.KeyValueKey + pausedKeySuffix, Value: "true"}); err != nil {
+		return errors.Wrap(err, "failed to pause streamer", logan.F{"key": s.KeyValueKey})
+	}
+	if s.Log != nil {
+		s.Log.WithField("key", s.KeyValueKey).Info("Streamer paused")
+	}
+	return nil
+}
+
+func (s *streamer[T]) Resume() error {
+	if s.err != nil {
+		return errors.Wrap(s.err, "invalid streamer")
+	}
+	if err := s.KeyValueQ.Delete(s.KeyValueKey + pausedKeySuffix); err != nil {
+		return errors.Wrap(err, "failed to resume streamer", logan.F{"key": s.KeyValueKey})
+	}
+	if s.Log != nil {
+		s.Log.WithField("key", s.KeyValueKey).Info("Streamer resumed")
+	}
+	return nil
+}
+
+func (s *streamer[T]) IsPaused() (bool, error) {
+	if s.err != nil {
+		return false, errors.Wrap(s.err, "invalid streamer")
+	}
+	paused, err := s.KeyValueQ.Get(s.KeyValueKey + pausedKeySuffix)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get paused flag", logan.F{"key": s.KeyValueKey})
+	}
+	return paused != nil, nil
+}
+
+// checkPaused fails with ErrStreamerPaused if the streamer is paused. It is called once per
+// list, before the list is formed
+func (s *streamer[T]) checkPaused() error {
+	paused, err := s.IsPaused()
+	if err != nil {
+		return err
+	}
+	if paused {
+		return errors.From(ErrStreamerPaused, logan.F{"key": s.KeyValueKey})
+	}
+	return nil
+}
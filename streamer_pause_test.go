@@ -0,0 +1,90 @@
+package dban_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerPause(t *testing.T) {
+	newStreamer := func(key string, kvQ dban.KeyValueQ, clock dban.Clock) *dbantest.RecordingStreamer[int] {
+		batchSize := uint64(2)
+		return dbantest.NewRecordingStreamer(dban.StreamerInitParams[int]{
+			Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3}),
+			KeyValueQ:   kvQ,
+			KeyValueKey: key,
+			BatchSize:   &batchSize,
+			Clock:       clock,
+		})
+	}
+	noop := func(context.Context, int) error { return nil }
+
+	t.Run("paused", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := newStreamer("pause-paused", kvQ, nil)
+		require.NoError(t, streamer.FormListAndProcess(noop))
+
+		require.NoError(t, streamer.Pause())
+		paused, err := streamer.IsPaused()
+		require.NoError(t, err)
+		assert.True(t, paused)
+		assert.NotNil(t, kvQ.MustGet("pause-paused:paused"))
+
+		assert.True(t, dban.Is(streamer.FormListAndProcess(noop), dban.ErrStreamerPaused))
+		_, err = streamer.ProcessAll(noop)
+		assert.True(t, dban.Is(err, dban.ErrStreamerPaused))
+		err = streamer.FormListAndProcessBatch(func(context.Context, []int) error { return nil })
+		assert.True(t, dban.Is(err, dban.ErrStreamerPaused))
+		assert.Equal(t, []uint64{0}, streamer.Pages(), "a paused streamer must not select entities")
+		assert.Zero(t, streamer.GetStats().Errors, "pausing is not a failure")
+
+		require.NoError(t, streamer.Resume())
+		paused, err = streamer.IsPaused()
+		require.NoError(t, err)
+		assert.False(t, paused)
+		require.NoError(t, streamer.FormListAndProcess(noop))
+		assert.Equal(t, []uint64{0, 1}, streamer.Pages())
+	})
+
+	t.Run("run", func(t *testing.T) {
+		var processed int32
+		clock := &fakeClock{now: time.Now()}
+		streamer := newStreamer("pause-run", dbantest.NewMemoryKeyValueQ(), clock)
+		require.NoError(t, streamer.Pause())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- streamer.Run(ctx, func(context.Context, int) error {
+				atomic.AddInt32(&processed, 1)
+				return nil
+			}, dban.RunConfig{EmptyPollInterval: time.Second, FailFast: true})
+		}()
+
+		// Run checks the flag again every time the poll interval passes
+		clock.advanceToNext(t)
+		clock.advanceToNext(t)
+		require.Eventually(t, func() bool {
+			clock.mu.Lock()
+			defer clock.mu.Unlock()
+			return len(clock.waiters) != 0
+		}, time.Second, time.Millisecond)
+		assert.Zero(t, atomic.LoadInt32(&processed))
+
+		require.NoError(t, streamer.Resume())
+		clock.advanceToNext(t)
+		require.Eventually(t, func() bool {
+			return atomic.LoadInt32(&processed) == 3
+		}, time.Second, time.Millisecond)
+
+		cancel()
+		require.NoError(t, <-done)
+		assert.Equal(t, []uint64{0, 1}, streamer.Pages()[:2], "a paused streamer must not select entities")
+	})
+}
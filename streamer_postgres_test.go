@@ -0,0 +1,141 @@
+package dban
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+func TestStreamerLastRunInfoPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db)
+	var valueType string
+	require.NoError(t, db.RawDB().QueryRow(
+		"SELECT data_type FROM information_schema.columns WHERE table_name = 'key_value' AND column_name = 'value'",
+	).Scan(&valueType))
+	assert.Equal(t, "text", valueType)
+
+	kvQ := NewKeyValueQ(db)
+	batchSize := uint64(2)
+	streamer := NewStreamer[uint64](StreamerInitParams[uint64]{
+		Stream:                 rangeStreamable{size: 5},
+		KeyValueQ:              kvQ,
+		KeyValueKey:            "numbers",
+		BatchSize:              &batchSize,
+		AdvanceAfterProcessing: true,
+	})
+	for i := 0; i < 2; i++ {
+		require.NoError(t, streamer.FormListAndProcess(func(context.Context, uint64) error { return nil }))
+	}
+
+	assert.Equal(t, "2", kvQ.MustGet("numbers").Value, "the cursor must be advanced past the lists")
+	info, err := streamer.GetLastRunInfo()
+	require.NoError(t, err)
+	require.NotNil(t, info)
+	assert.Equal(t, LastRunInfo{At: info.At, Page: 1, Entities: 2, Processed: 4}, *info)
+	assert.Greater(t, len(kvQ.MustGet("numbers"+lastRunKeySuffix).Value), 64)
+}
+
+// updatedAtSlice selects the indexes of the update times within the range
+type updatedAtSlice []time.Time
+
+func (s updatedAtSlice) SelectUpdatedWithin(from, to time.Time, pageParams pgdb.OffsetPageParams) ([]int, error) {
+	var selected []int
+	for i, updatedAt := range s {
+		if !updatedAt.Before(from) && !updatedAt.After(to) {
+			selected = append(selected, i)
+		}
+	}
+	offset := pageParams.PageNumber * pageParams.Limit
+	if offset >= uint64(len(selected)) {
+		return nil, nil
+	}
+	if end := offset + pageParams.Limit; end < uint64(len(selected)) {
+		return selected[offset:end], nil
+	}
+	return selected[offset:], nil
+}
+
+func TestWatermarkStreamerPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db)
+	kvQ := NewKeyValueQ(db)
+
+	start := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	batchSize := uint64(2)
+	streamer := NewWatermarkStreamer(WatermarkStreamerInitParams[int]{
+		Stream:      updatedAtSlice{start.Add(time.Minute), start.Add(2 * time.Minute), start.Add(3 * time.Minute)},
+		KeyValueQ:   kvQ,
+		KeyValueKey: "watermark",
+		BatchSize:   &batchSize,
+		StartAt:     start,
+	})
+
+	list, err := streamer.FormList()
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1}, list)
+	assert.Greater(t, len(kvQ.MustGet("watermark").Value), 64, "the cursor must fit into the value column")
+	cursor, err := streamer.GetCursor()
+	require.NoError(t, err)
+	assert.Equal(t, start, cursor.Watermark)
+	assert.Equal(t, uint64(1), cursor.Page)
+
+	list, err = streamer.FormList()
+	require.NoError(t, err)
+	assert.Equal(t, []int{2}, list)
+	cursor, err = streamer.GetCursor()
+	require.NoError(t, err)
+	assert.True(t, cursor.Watermark.After(start), "the window must be closed")
+	assert.Zero(t, cursor.Page)
+}
+
+type postgresOrder struct {
+	ID uint64
+}
+
+// postgresOrders selects the orders of the IDs of rangeStreamable
+type postgresOrders struct {
+	rangeStreamable
+}
+
+func (s postgresOrders) SelectWithPageParams(params pgdb.OffsetPageParams) ([]postgresOrder, error) {
+	ids, err := s.rangeStreamable.SelectWithPageParams(params)
+	orders := make([]postgresOrder, len(ids))
+	for i, id := range ids {
+		orders[i] = postgresOrder{ID: id}
+	}
+	return orders, err
+}
+
+func TestStreamerFingerprintPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	migrateTestPostgres(t, db)
+	kvQ := NewKeyValueQ(db)
+	batchSize := uint64(2)
+
+	streamer, err := NewStreamerChecked(StreamerInitParams[postgresOrder]{
+		Stream:      postgresOrders{rangeStreamable{size: 5}},
+		KeyValueQ:   kvQ,
+		KeyValueKey: "orders",
+		BatchSize:   &batchSize,
+		Fingerprint: true,
+	})
+	require.NoError(t, err)
+	list, err := streamer.FormList()
+	require.NoError(t, err)
+	assert.Equal(t, []postgresOrder{{0}, {1}}, list)
+	assert.Greater(t, len(kvQ.MustGet("orders"+metaKeySuffix).Value), 64, "the fingerprint must fit into the value column")
+
+	_, err = NewStreamerChecked(StreamerInitParams[uint64]{
+		Stream:      rangeStreamable{size: 5},
+		KeyValueQ:   kvQ,
+		KeyValueKey: "orders",
+		BatchSize:   &batchSize,
+		Fingerprint: true,
+	})
+	assert.True(t, Is(err, ErrKeyCollision), "%v", err)
+}
@@ -0,0 +1,53 @@
+package dban
+
+import (
+	"context"
+	"strings"
+
+	"github.com/lib/pq"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// Preparation is called by a streamer before the first page of every pass over the stream
+// (see StreamerInitParams.Preparation)
+type Preparation func(ctx context.Context) error
+
+// MaterializedViewRefresh returns a Preparation refreshing the materialized view, so that
+// every pass streams through the fresh one. A concurrent refresh requires a unique index on
+// the view
+func MaterializedViewRefresh(db *pgdb.DB, viewName string, concurrently bool) Preparation {
+	query := "REFRESH MATERIALIZED VIEW "
+	if concurrently {
+		query += "CONCURRENTLY "
+	}
+	query += quoteQualifiedIdentifier(viewName)
+
+	return func(ctx context.Context) error {
+		if err := db.ExecRawContext(ctx, query); err != nil {
+			return errors.Wrap(err, "failed to refresh materialized view", logan.F{"view": viewName})
+		}
+		return nil
+	}
+}
+
+// quoteQualifiedIdentifier quotes every part of a possibly schema-qualified name
+func quoteQualifiedIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = pq.QuoteIdentifier(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// prepare calls the Preparation if page is the first one of a pass
+func (s *streamer[T]) prepare(page uint64) error {
+	if s.Preparation == nil || page != 0 {
+		return nil
+	}
+	if err := s.Preparation(s.Ctx); err != nil {
+		return s.fail(StagePrepare, page, errors.Wrap(err, "failed to prepare the stream"))
+	}
+	return nil
+}
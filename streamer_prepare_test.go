@@ -0,0 +1,50 @@
+package dban_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+func TestStreamerPreparation(t *testing.T) {
+	var prepared int
+	var failure error
+	batchSize := uint64(2)
+	streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5}),
+		KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+		KeyValueKey: cursorKey,
+		BatchSize:   &batchSize,
+		Preparation: func(context.Context) error {
+			prepared++
+			return failure
+		},
+	})
+	noop := func(context.Context, int) error { return nil }
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, streamer.FormListAndProcess(noop))
+	}
+	assert.Equal(t, 1, prepared, "preparation must be made once per pass")
+
+	require.NoError(t, streamer.FormListAndProcess(noop))
+	assert.Equal(t, 2, prepared, "preparation must be made again after wraparound")
+
+	require.NoError(t, streamer.FormListAndProcess(noop))
+	require.NoError(t, streamer.FormListAndProcess(noop))
+	failure = errors.New("view is broken")
+	err := streamer.FormListAndProcess(noop)
+	var streamErr *dban.StreamError
+	require.ErrorAs(t, err, &streamErr)
+	assert.Equal(t, dban.StagePrepare, streamErr.Stage)
+	assert.True(t, dban.Is(err, failure))
+
+	page, err := streamer.GetCurrentPage()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), page, "the failed pass must not begin")
+}
@@ -0,0 +1,89 @@
+package dban
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/distributed_lab/logan/v3"
+)
+
+// defaultCountInterval is the interval the total number of entities is counted again with
+const defaultCountInterval = time.Minute
+
+// Countable is an interface a Streamable could implement as well to tell the total number of
+// its entities, so that ProcessAll and Run report how far they have got
+type Countable interface {
+	Count() (uint64, error)
+}
+
+// progress counts the entities of a Countable stream once in a while
+type progress struct {
+	countable Countable
+	interval  time.Duration
+
+	mu        sync.Mutex
+	countedAt time.Time
+}
+
+func newProgress(source interface{}, interval *time.Duration) *progress {
+	countable, ok := source.(Countable)
+	if !ok {
+		return nil
+	}
+
+	p := &progress{countable: countable, interval: defaultCountInterval}
+	if interval != nil && *interval > 0 {
+		p.interval = *interval
+	}
+	return p
+}
+
+// countTotal counts the entities of the stream unless it was done less than CountInterval
+// ago or force is set. A failure to count them makes the total unknown until the next count
+func (s *streamer[T]) countTotal(force bool) {
+	if s.progress == nil {
+		return
+	}
+
+	s.progress.mu.Lock()
+	defer s.progress.mu.Unlock()
+
+	now := s.Clock.Now()
+	if !force && now.Sub(s.progress.countedAt) < s.progress.interval {
+		return
+	}
+	s.progress.countedAt = now
+
+	total, err := s.progress.countable.Count()
+	if err != nil {
+		total = 0
+		if s.Log != nil {
+			s.Log.WithError(err).WithField("key", s.KeyValueKey).Warn("Failed to count entities, total is unknown")
+		}
+	}
+	atomic.StoreInt64(&s.stats.total, int64(total))
+}
+
+// reportProgress records the position of the stream at the end of the list of formed
+// entities taken from page and logs it along with the total, if it is known
+func (s *streamer[T]) reportProgress(page uint64, formed int) {
+	if s.progress == nil {
+		return
+	}
+
+	s.countTotal(false)
+	position := page*s.BatchSize + uint64(formed)
+	atomic.StoreInt64(&s.stats.position, int64(position))
+	if s.Log == nil {
+		return
+	}
+
+	fields := logan.F{"key": s.KeyValueKey, "processed": position}
+	total := atomic.LoadInt64(&s.stats.total)
+	if percent, known := (StreamerStats{Total: total, Position: int64(position)}).Percent(); known {
+		fields["total"] = total
+		fields["percent"] = percent
+	}
+	s.Log.WithFields(fields).Info("Processed batch")
+}
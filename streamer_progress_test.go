@@ -0,0 +1,110 @@
+package dban_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/logan/v3"
+)
+
+// countableStreamable counts its entities with count
+type countableStreamable struct {
+	dban.Streamable[int]
+	count func() (uint64, error)
+}
+
+func (s countableStreamable) Count() (uint64, error) {
+	return s.count()
+}
+
+func TestStreamerProgress(t *testing.T) {
+	newStreamer := func(key string, count func() (uint64, error), logs *bytes.Buffer) dban.Streamer[int] {
+		batchSize := uint64(2)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream: countableStreamable{
+				Streamable: dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5, 6, 7, 8}),
+				count:      count,
+			},
+			KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey: key,
+			BatchSize:   &batchSize,
+			Log:         logan.New().Out(logs),
+		})
+	}
+	noop := func(context.Context, int) error { return nil }
+
+	t.Run("counted", func(t *testing.T) {
+		var logs bytes.Buffer
+		counts := 0
+		streamer := newStreamer("progress-counted", func() (uint64, error) {
+			counts++
+			return 8, nil
+		}, &logs)
+
+		_, err := streamer.ProcessAll(noop)
+		require.NoError(t, err)
+		assert.Equal(t, 1, counts, "the total must be counted once per interval")
+
+		stats := streamer.GetStats()
+		assert.Equal(t, int64(8), stats.Total)
+		assert.Equal(t, int64(8), stats.Position)
+		percent, known := stats.Percent()
+		assert.True(t, known)
+		assert.Equal(t, float64(100), percent)
+		assert.Contains(t, logs.String(), "processed=2")
+		assert.Contains(t, logs.String(), "percent=25")
+	})
+
+	t.Run("count failed", func(t *testing.T) {
+		var logs bytes.Buffer
+		streamer := newStreamer("progress-failed", func() (uint64, error) {
+			return 0, errors.New("boom")
+		}, &logs)
+
+		_, err := streamer.ProcessAll(noop)
+		require.NoError(t, err, "a failure to count entities must not fail the stream")
+		_, known := streamer.GetStats().Percent()
+		assert.False(t, known)
+		assert.Contains(t, logs.String(), "total is unknown")
+		assert.NotContains(t, logs.String(), "percent=")
+	})
+
+	t.Run("shrunk", func(t *testing.T) {
+		percent, known := dban.StreamerStats{Total: 4, Position: 6}.Percent()
+		assert.True(t, known)
+		assert.Equal(t, float64(100), percent)
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		counts := 0
+		interval := time.Minute
+		batchSize := uint64(2)
+		streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream: countableStreamable{
+				Streamable: dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5}),
+				count: func() (uint64, error) {
+					counts++
+					clock.now = clock.now.Add(interval)
+					return 5, nil
+				},
+			},
+			KeyValueQ:     dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey:   "progress-interval",
+			BatchSize:     &batchSize,
+			Clock:         clock,
+			CountInterval: &interval,
+		})
+
+		_, err := streamer.ProcessAll(noop)
+		require.NoError(t, err)
+		assert.Equal(t, 4, counts, "the total must be counted at the start and after every interval")
+	})
+}
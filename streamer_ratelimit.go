@@ -0,0 +1,70 @@
+package dban
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// throttle waits for the entity limiter, if any, to let the next entity be processed.
+// It fails once ctx is done or the wait would outlast its deadline
+func (s *streamer[T]) throttle(ctx context.Context) error {
+	if s.EntityLimiter == nil {
+		return nil
+	}
+	return s.EntityLimiter.Wait(ctx)
+}
+
+// newEntityLimiter makes the limiter of the entities of a streamer: the one given if
+// any, or one allowing limit entities per second one at a time. No limiter is needed for
+// an infinite limit
+func newEntityLimiter(limiter *rate.Limiter, limit *rate.Limit) *rate.Limiter {
+	if limiter != nil || limit == nil || *limit == rate.Inf {
+		return limiter
+	}
+	return rate.NewLimiter(*limit, 1)
+}
+
+// batchPacer spaces the pages taken by the copies of a streamer by the interval at least
+type batchPacer struct {
+	mu       sync.Mutex
+	interval time.Duration
+	// next is the earliest time the next page could be taken at
+	next time.Time
+}
+
+func newBatchPacer(interval *time.Duration) *batchPacer {
+	if interval == nil || *interval <= 0 {
+		return nil
+	}
+	return &batchPacer{interval: *interval}
+}
+
+// pace waits until the next page could be taken, reserving the time slot for it, so that
+// concurrent callers are spaced as well. It fails once Ctx is done
+func (s *streamer[T]) pace() error {
+	if s.pacer == nil {
+		return nil
+	}
+
+	s.pacer.mu.Lock()
+	now := s.Clock.Now()
+	at := s.pacer.next
+	if at.Before(now) {
+		at = now
+	}
+	s.pacer.next = at.Add(s.pacer.interval)
+	s.pacer.mu.Unlock()
+
+	if at.Equal(now) {
+		return nil
+	}
+	select {
+	case <-s.Ctx.Done():
+		return s.fail(StageCursorRead, 0, s.Ctx.Err())
+	case <-s.Clock.After(at.Sub(now)):
+		return nil
+	}
+}
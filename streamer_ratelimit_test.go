@@ -0,0 +1,117 @@
+package dban_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"golang.org/x/time/rate"
+)
+
+func TestStreamerBatchInterval(t *testing.T) {
+	batchSize, interval := uint64(1), time.Minute
+
+	t.Run("spaced", func(t *testing.T) {
+		start := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)
+		clock := &fakeClock{now: start}
+		streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:        dbantest.NewSliceStreamable([]int{1, 2, 3}),
+			KeyValueQ:     dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey:   cursorKey,
+			BatchSize:     &batchSize,
+			BatchInterval: &interval,
+			Clock:         clock,
+		})
+
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{1}, list)
+
+		lists := make(chan []int)
+		go func() {
+			list, err := streamer.FormList()
+			assert.NoError(t, err)
+			lists <- list
+		}()
+		assert.Equal(t, start.Add(interval), clock.advanceToNext(t))
+		assert.Equal(t, []int{2}, <-lists)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		clock := &fakeClock{now: time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)}
+		streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:        dbantest.NewSliceStreamable([]int{1, 2, 3}),
+			KeyValueQ:     dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey:   cursorKey,
+			BatchSize:     &batchSize,
+			BatchInterval: &interval,
+			Clock:         clock,
+			Ctx:           &ctx,
+		})
+		require.NoError(t, streamer.FormListAndProcess(func(context.Context, int) error { return nil }))
+
+		time.AfterFunc(10*time.Millisecond, cancel)
+		err := streamer.FormListAndProcess(func(context.Context, int) error {
+			t.Fatal("no list must be formed before the interval passes")
+			return nil
+		})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestStreamerEntityRateLimit(t *testing.T) {
+	batchSize := uint64(4)
+
+	t.Run("spaced", func(t *testing.T) {
+		limit := rate.Every(20 * time.Millisecond)
+		streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:          dbantest.NewSliceStreamable([]int{1, 2, 3}),
+			KeyValueQ:       dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey:     cursorKey,
+			BatchSize:       &batchSize,
+			EntityRateLimit: &limit,
+		})
+
+		var calls []time.Time
+		require.NoError(t, streamer.FormListAndProcess(func(context.Context, int) error {
+			calls = append(calls, time.Now())
+			return nil
+		}))
+		require.Len(t, calls, 3)
+		for i := 1; i < len(calls); i++ {
+			assert.GreaterOrEqual(t, calls[i].Sub(calls[i-1]), 15*time.Millisecond)
+		}
+	})
+
+	for _, concurrency := range []uint64{1, 2} {
+		concurrency := concurrency
+		t.Run("canceled", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+				Stream:            dbantest.NewSliceStreamable([]int{1, 2, 3}),
+				KeyValueQ:         dbantest.NewMemoryKeyValueQ(),
+				KeyValueKey:       cursorKey,
+				BatchSize:         &batchSize,
+				EntityRateLimiter: rate.NewLimiter(rate.Every(time.Hour), 1),
+				MaxConcurrency:    &concurrency,
+				Ctx:               &ctx,
+			})
+
+			time.AfterFunc(10*time.Millisecond, cancel)
+			started := time.Now()
+			err := streamer.FormListAndProcess(func(context.Context, int) error { return nil })
+			require.ErrorIs(t, err, context.Canceled)
+			assert.Less(t, time.Since(started), time.Second)
+
+			var streamErr *dban.StreamError
+			require.ErrorAs(t, err, &streamErr)
+			assert.Equal(t, 1, streamErr.EntityIndex)
+		})
+	}
+}
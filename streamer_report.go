@@ -0,0 +1,84 @@
+package dban
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// EntityResult is the outcome of processing an entity of a list
+type EntityResult[T any] struct {
+	Entity T
+	// Index is the position of the entity in the list
+	Index int
+	// Err is the error the entity failed with, nil if it was processed. An error stopping
+	// the list is a StreamError wrapping the one of the processing function
+	Err error
+	// Took is the time the entity took to be processed, including the retries made with
+	// EntityMaxAttempts
+	Took time.Duration
+	// DeadLettered tells the entity failed with Err was handed to OnDeadLetter
+	DeadLettered bool
+}
+
+// BatchReport tells how every entity of a list processed by FormListAndProcessReport did
+type BatchReport[T any] struct {
+	// Page is the page the list was taken from
+	Page uint64
+	// Results are the outcomes of the entities in the order they were processed in
+	Results []EntityResult[T]
+}
+
+// entityResults collects the results of a list, possibly processed concurrently
+type entityResults[T any] struct {
+	mu   sync.Mutex
+	list []EntityResult[T]
+}
+
+func (r *entityResults[T]) add(result EntityResult[T]) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.list = append(r.list, result)
+}
+
+func (s *streamer[T]) FormListAndProcessReport(fn func(ctx context.Context, t T) error) (BatchReport[T], error) {
+	reporting := *s
+	reporting.results = &entityResults[T]{}
+	page, _, _, err := reporting.formListAndProcess(eachEntity(fn))
+	report := BatchReport[T]{Page: page, Results: reporting.results.list}
+	if stopped(err) {
+		return report, nil
+	}
+	return report, err
+}
+
+// reportEntity records the outcome of processing the i-th entity (see processEntity), if the
+// results are collected
+func (s *streamer[T]) reportEntity(entity T, i int, started time.Time, deadLettered bool, failure, err, stop error) {
+	if s.results == nil {
+		return
+	}
+
+	result := EntityResult[T]{Entity: entity, Index: i, Err: err, Took: time.Since(started), DeadLettered: deadLettered}
+	switch {
+	case stop != nil:
+		result.Err = stop
+	case deadLettered:
+		result.Err = failure
+	}
+	s.results.add(result)
+}
+
+type entityIndexKey struct{}
+
+// withEntityIndex makes the context the i-th entity is processed with when the results are
+// collected, so that wrappers could tell the result of the entity they were called with
+func withEntityIndex(ctx context.Context, i int) context.Context {
+	return context.WithValue(ctx, entityIndexKey{}, i)
+}
+
+// entityIndex returns the index withEntityIndex put into ctx
+func entityIndex(ctx context.Context) (int, bool) {
+	i, ok := ctx.Value(entityIndexKey{}).(int)
+	return i, ok
+}
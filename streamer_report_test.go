@@ -0,0 +1,99 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerFormListAndProcessReport(t *testing.T) {
+	failure := errors.New("boom")
+	newStreamer := func(key string, params dban.StreamerInitParams[int]) dban.Streamer[int] {
+		batchSize := uint64(4)
+		params.Stream = dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5, 6})
+		params.KeyValueQ = dbantest.NewMemoryKeyValueQ()
+		params.KeyValueKey = key
+		params.BatchSize = &batchSize
+		return dban.NewStreamer(params)
+	}
+	failing := func(_ context.Context, i int) error {
+		if i == 2 {
+			return failure
+		}
+		return nil
+	}
+	// outcomes returns the entities reported and whether they failed
+	outcomes := func(report dban.BatchReport[int]) map[int]bool {
+		failed := make(map[int]bool, len(report.Results))
+		for _, result := range report.Results {
+			failed[result.Entity] = result.Err != nil
+		}
+		return failed
+	}
+
+	t.Run("succeeded", func(t *testing.T) {
+		streamer := newStreamer("report-succeeded", dban.StreamerInitParams[int]{})
+		_, err := streamer.FormListAndProcessReport(func(context.Context, int) error { return nil })
+		require.NoError(t, err)
+
+		report, err := streamer.FormListAndProcessReport(func(context.Context, int) error { return nil })
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), report.Page)
+		assert.Equal(t, map[int]bool{5: false, 6: false}, outcomes(report))
+		assert.Equal(t, 5, report.Results[0].Entity, "the results must be in the processing order")
+	})
+
+	t.Run("fail fast", func(t *testing.T) {
+		report, err := newStreamer("report-fail-fast", dban.StreamerInitParams[int]{}).FormListAndProcessReport(failing)
+		assert.True(t, errors.Is(err, failure))
+		assert.Equal(t, map[int]bool{1: false, 2: true}, outcomes(report), "the list must be reported up to the failure")
+		assert.True(t, errors.Is(report.Results[1].Err, failure))
+	})
+
+	t.Run("collect", func(t *testing.T) {
+		policy := dban.EntityErrorCollect
+		streamer := newStreamer("report-collect", dban.StreamerInitParams[int]{EntityErrorPolicy: &policy})
+		report, err := streamer.FormListAndProcessReport(failing)
+		var batchErrs *dban.BatchErrors
+		assert.True(t, errors.As(err, &batchErrs))
+		assert.Equal(t, map[int]bool{1: false, 2: true, 3: false, 4: false}, outcomes(report))
+		assert.Equal(t, failure, report.Results[1].Err)
+	})
+
+	t.Run("dead-lettered", func(t *testing.T) {
+		var deadLetters []int
+		streamer := newStreamer("report-dead-lettered", dban.StreamerInitParams[int]{
+			EntityMaxAttempts: 2,
+			OnDeadLetter: func(_ context.Context, i int, _ error) error {
+				deadLetters = append(deadLetters, i)
+				return nil
+			},
+		})
+		report, err := streamer.FormListAndProcessReport(failing)
+		require.NoError(t, err)
+		require.Len(t, report.Results, 4)
+		assert.True(t, report.Results[1].DeadLettered)
+		assert.Equal(t, failure, report.Results[1].Err)
+		assert.Equal(t, []int{2}, deadLetters)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		concurrency := uint64(3)
+		policy := dban.EntityErrorSkipAndLog
+		streamer := newStreamer("report-concurrent", dban.StreamerInitParams[int]{
+			MaxConcurrency:    &concurrency,
+			EntityErrorPolicy: &policy,
+		})
+		report, err := streamer.FormListAndProcessReport(failing)
+		require.NoError(t, err)
+		assert.Equal(t, map[int]bool{1: false, 2: true, 3: false, 4: false}, outcomes(report))
+		for _, result := range report.Results {
+			assert.Equal(t, result.Entity-1, result.Index, "the results must tell the index of the entities")
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package dban
+
+import (
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// selectRetrying selects the page, retrying it with SelectRetry while it fails with
+// transient errors. Each retry is logged, and the error left once the attempts are
+// exhausted or Ctx is done is wrapped with the number of attempts made
+func (s *streamer[T]) selectRetrying(pageNumber uint64) ([]T, error) {
+	params, skip := s.pageParams(pageNumber)
+	entities, err := s.selectPage(params)
+	if s.SelectRetry == nil {
+		return skipped(entities, skip), err
+	}
+
+	attempt := 1
+	for ; attempt < s.SelectRetry.MaxAttempts && err != nil && s.SelectRetry.IsTransient(err); attempt++ {
+		delay := s.SelectRetry.delay(attempt)
+		if s.Log != nil {
+			s.Log.WithError(err).WithFields(logan.F{
+				"page":    pageNumber,
+				"attempt": attempt,
+				"delay":   delay.String(),
+			}).Warn("Failed to select entities, retrying")
+		}
+		select {
+		case <-s.Ctx.Done():
+			return nil, errors.Wrap(err, "failed to select entities before the context was done", logan.F{"attempts": attempt})
+		case <-s.Clock.After(delay):
+		}
+		entities, err = s.selectPage(params)
+	}
+	if err != nil && attempt > 1 {
+		return nil, errors.Wrap(err, "failed to select entities", logan.F{"attempts": attempt})
+	}
+	return skipped(entities, skip), err
+}
+
+// selectRetry fills in the defaults of the retries of Select, if any
+func selectRetry(cfg *RetryConfig) *RetryConfig {
+	if cfg == nil {
+		return nil
+	}
+	retry := cfg.withDefaults()
+	return &retry
+}
@@ -0,0 +1,83 @@
+package dban_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// flakyStreamable fails selects with the errors in order, then selects from the stream
+type flakyStreamable struct {
+	dban.Streamable[int]
+	errs    []error
+	selects int
+}
+
+func (s *flakyStreamable) SelectWithPageParams(params pgdb.OffsetPageParams) ([]int, error) {
+	s.selects++
+	if len(s.errs) == 0 {
+		return s.Streamable.SelectWithPageParams(params)
+	}
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return nil, err
+}
+
+func TestStreamerSelectRetry(t *testing.T) {
+	newStreamer := func(stream dban.Streamable[int], ctx context.Context) dban.Streamer[int] {
+		batchSize := uint64(2)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:      stream,
+			KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey: cursorKey,
+			BatchSize:   &batchSize,
+			Ctx:         &ctx,
+			SelectRetry: &dban.RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		})
+	}
+	items := dbantest.NewSliceStreamable([]int{1, 2, 3})
+
+	t.Run("recovered", func(t *testing.T) {
+		stream := &flakyStreamable{Streamable: items, errs: []error{driver.ErrBadConn, driver.ErrBadConn}}
+		list, err := newStreamer(stream, context.Background()).FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, list)
+		assert.Equal(t, 3, stream.selects)
+	})
+
+	t.Run("attempts exhausted", func(t *testing.T) {
+		stream := &flakyStreamable{Streamable: items, errs: []error{driver.ErrBadConn, driver.ErrBadConn, driver.ErrBadConn}}
+		_, err := newStreamer(stream, context.Background()).FormList()
+		assert.True(t, dban.Is(err, driver.ErrBadConn))
+		assert.Equal(t, 3, errors.GetFields(err)["attempts"])
+		assert.Equal(t, 3, stream.selects)
+	})
+
+	t.Run("not transient", func(t *testing.T) {
+		failure := errors.New("syntax error")
+		stream := &flakyStreamable{Streamable: items, errs: []error{failure}}
+		_, err := newStreamer(stream, context.Background()).FormList()
+		assert.True(t, dban.Is(err, failure))
+		assert.Nil(t, errors.GetFields(err)["attempts"])
+		assert.Equal(t, 1, stream.selects)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		stream := &flakyStreamable{Streamable: items, errs: []error{driver.ErrBadConn}}
+		streamer := newStreamer(stream, ctx)
+		_, err := streamer.Select(0)
+		assert.True(t, dban.Is(err, driver.ErrBadConn))
+		assert.Equal(t, 1, errors.GetFields(err)["attempts"])
+		assert.Equal(t, 1, stream.selects)
+	})
+}
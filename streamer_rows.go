@@ -0,0 +1,197 @@
+package dban
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// RowIterator iterates over entities of a page decoding one of them at a time
+type RowIterator[T any] interface {
+	// Next advances to the next entity, returning false when there are no more or it failed
+	Next() bool
+	// Scan decodes the current entity
+	Scan() (T, error)
+	// Err returns an error Next stopped on, if any
+	Err() error
+	// Close releases the iterator
+	Close() error
+}
+
+// RowStreamable is a source of entities returning pages as iterators instead of slices, so
+// that a whole page is never held in memory
+type RowStreamable[T any] interface {
+	SelectRowsWithPageParams(pageParams pgdb.OffsetPageParams) (RowIterator[T], error)
+}
+
+// Rows is a set of rows of a query, such as *sql.Rows or *sqlx.Rows
+type Rows interface {
+	Next() bool
+	Err() error
+	Close() error
+}
+
+type rowIterator[R Rows, T any] struct {
+	rows R
+	scan func(rows R) (T, error)
+}
+
+// NewRowIterator creates a RowIterator over rows of a query decoding entities with scan,
+// e.g. func(rows *sqlx.Rows) (Foo, error) { var foo Foo; return foo, rows.StructScan(&foo) }
+func NewRowIterator[R Rows, T any](rows R, scan func(rows R) (T, error)) RowIterator[T] {
+	return &rowIterator[R, T]{rows: rows, scan: scan}
+}
+
+func (i *rowIterator[R, T]) Next() bool {
+	return i.rows.Next()
+}
+
+func (i *rowIterator[R, T]) Scan() (T, error) {
+	return i.scan(i.rows)
+}
+
+func (i *rowIterator[R, T]) Err() error {
+	return i.rows.Err()
+}
+
+func (i *rowIterator[R, T]) Close() error {
+	return i.rows.Close()
+}
+
+func (s *streamer[T]) FormAndProcessRows(fn func(ctx context.Context, t T) error) error {
+	err := s.formAndProcessRows(fn)
+	if stopped(err) {
+		if err = s.stopStreaming(err, false); stopped(err) {
+			return nil
+		}
+	} else if s.canceled(err) {
+		err = s.interruptStreaming(err, false)
+	}
+	return s.failed(err)
+}
+
+func (s *streamer[T]) formAndProcessRows(fn func(ctx context.Context, t T) error) (err error) {
+	if s.RowStream == nil {
+		return errors.New("streamer has no RowStream to select rows from")
+	}
+	if s.adaptive != nil {
+		return errors.New("FormAndProcessRows is not supported with AdaptiveBatch")
+	}
+	if err = s.checkPaused(); err != nil {
+		return err
+	}
+	failures, err := s.checkBreaker()
+	if err != nil {
+		return err
+	}
+	defer func() { s.recordBreaker(failures, err) }()
+	if err = s.checkFingerprint(); err != nil {
+		return err
+	}
+	if err = s.pace(); err != nil {
+		return err
+	}
+
+	var (
+		rows  RowIterator[T]
+		page  uint64
+		found bool
+	)
+	defer func() {
+		if rows != nil {
+			_ = rows.Close()
+		}
+	}()
+
+	err = s.inTx(func(s *streamer[T]) (err error) {
+		page, found, err = s.takePage(func(page uint64) (int, error) {
+			if rows != nil {
+				_ = rows.Close()
+			}
+
+			var err error
+			rows, err = s.RowStream.SelectRowsWithPageParams(pgdb.OffsetPageParams{
+				Limit:      s.BatchSize,
+				PageNumber: page,
+			})
+			if err != nil {
+				rows = nil
+				return 0, err
+			}
+			if !rows.Next() {
+				return 0, rows.Err()
+			}
+			// the rows are counted as they are scanned
+			return 1, nil
+		})
+		return err
+	})
+	if err != nil {
+		return s.streamError(err)
+	}
+	s.progressed()
+	if !found {
+		return nil
+	}
+
+	s.emit(BatchStarted{Key: s.KeyValueKey, Page: page})
+	started := time.Now()
+	completed := BatchCompleted{Key: s.KeyValueKey, Page: page}
+	_, completed.CursorPersistDeferred = s.cursorBuffer.current()
+	defer func() {
+		completed.Duration = time.Since(started)
+		s.emit(completed)
+	}()
+
+	// the first row was already fetched to find out whether the page is empty
+	var collected BatchErrors
+	for i, next := 0, true; next; i, next = i+1, rows.Next() {
+		if ctxErr := s.Ctx.Err(); ctxErr != nil {
+			return s.failEntity(page, i, ctxErr)
+		}
+		entity, err := rows.Scan()
+		if err != nil {
+			completed.Failed++
+			return s.fail(StageSelect, page, errors.Wrap(err, "failed to scan an entity"))
+		}
+		if s.redelivered(entity) {
+			continue
+		}
+		if s.filteredOut(entity) {
+			completed.Filtered++
+			continue
+		}
+		if err = s.throttle(s.Ctx); err != nil {
+			return s.failEntity(page, i, err)
+		}
+		deadLettered, err, stopErr := s.processEntity(func() error {
+			return fn(s.Ctx, entity)
+		}, page, i, entity)
+		if stopErr != nil {
+			if !stopped(stopErr) {
+				completed.Failed++
+			}
+			return stopErr
+		}
+		if err != nil {
+			completed.Failed++
+			if err = s.entityFailed(page, i, err, &collected); err != nil {
+				return err
+			}
+			continue
+		}
+		s.delivered(entity)
+		if deadLettered {
+			completed.DeadLettered++
+			continue
+		}
+		completed.Processed++
+	}
+
+	if err = rows.Err(); err != nil {
+		return s.fail(StageSelect, page, errors.Wrap(err, "failed to iterate over rows"))
+	}
+	return collected.orNil()
+}
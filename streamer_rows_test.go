@@ -0,0 +1,174 @@
+package dban_test
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+type fatEntity struct {
+	ID      int
+	Payload []byte
+}
+
+// syntheticSource generates size fat entities on demand, either as slices or as rows
+type syntheticSource struct {
+	size    int
+	payload int
+}
+
+func (s syntheticSource) bounds(params pgdb.OffsetPageParams) (int, int) {
+	from := int(params.PageNumber * params.Limit)
+	to := from + int(params.Limit)
+	if to > s.size {
+		to = s.size
+	}
+	return from, to
+}
+
+func (s syntheticSource) entity(id int) fatEntity {
+	return fatEntity{ID: id, Payload: make([]byte, s.payload)}
+}
+
+func (s syntheticSource) SelectWithPageParams(params pgdb.OffsetPageParams) ([]fatEntity, error) {
+	var entities []fatEntity
+	from, to := s.bounds(params)
+	for id := from; id < to; id++ {
+		entities = append(entities, s.entity(id))
+	}
+	return entities, nil
+}
+
+func (s syntheticSource) SelectRowsWithPageParams(params pgdb.OffsetPageParams) (dban.RowIterator[fatEntity], error) {
+	from, to := s.bounds(params)
+	return &syntheticRows{source: s, next: from, to: to}, nil
+}
+
+type syntheticRows struct {
+	source   syntheticSource
+	next, to int
+	current  int
+}
+
+func (r *syntheticRows) Next() bool {
+	if r.next >= r.to {
+		return false
+	}
+	r.current, r.next = r.next, r.next+1
+	return true
+}
+
+func (r *syntheticRows) Scan() (fatEntity, error) { return r.source.entity(r.current), nil }
+func (r *syntheticRows) Err() error               { return nil }
+func (r *syntheticRows) Close() error             { return nil }
+
+func newRowStreamer(source syntheticSource, batchSize uint64) dban.Streamer[fatEntity] {
+	return dban.NewStreamer(dban.StreamerInitParams[fatEntity]{
+		Stream:      source,
+		RowStream:   source,
+		KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+		KeyValueKey: cursorKey,
+		BatchSize:   &batchSize,
+	})
+}
+
+func TestStreamerFormAndProcessRows(t *testing.T) {
+	streamer := newRowStreamer(syntheticSource{size: 10_000, payload: 16}, 4_000)
+
+	for _, expected := range [][2]int{{0, 4_000}, {4_000, 8_000}, {8_000, 10_000}, {0, 4_000}} {
+		var ids []int
+		require.NoError(t, streamer.FormAndProcessRows(func(_ context.Context, entity fatEntity) error {
+			ids = append(ids, entity.ID)
+			return nil
+		}))
+
+		require.Len(t, ids, expected[1]-expected[0])
+		for i, id := range ids {
+			require.Equal(t, expected[0]+i, id)
+		}
+	}
+
+	empty := newRowStreamer(syntheticSource{}, 10)
+	assert.NoError(t, empty.FormAndProcessRows(func(context.Context, fatEntity) error {
+		t.Fatal("there must be nothing to process")
+		return nil
+	}))
+
+	withoutRows := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      dbantest.NewSliceStreamable([]int{1}),
+		KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+		KeyValueKey: cursorKey,
+	})
+	assert.Error(t, withoutRows.FormAndProcessRows(func(context.Context, int) error { return nil }))
+}
+
+func TestNewRowIterator(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	rows, err := db.Query("SELECT id FROM foo")
+	require.NoError(t, err)
+
+	iterator := dban.NewRowIterator(rows, func(rows *sql.Rows) (int, error) {
+		var id int
+		return id, rows.Scan(&id)
+	})
+	var ids []int
+	for iterator.Next() {
+		id, err := iterator.Scan()
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	require.NoError(t, iterator.Err())
+	require.NoError(t, iterator.Close())
+	assert.Equal(t, []int{1, 2}, ids)
+}
+
+func BenchmarkStreamerRows(b *testing.B) {
+	source := syntheticSource{size: 2_000, payload: 64 << 10}
+
+	// the heap is sampled while entities are processed
+	measure := func(b *testing.B, run func(dban.Streamer[fatEntity], func(context.Context, fatEntity) error) error) {
+		var peak uint64
+		process := func(_ context.Context, entity fatEntity) error {
+			if entity.ID%100 == 0 {
+				var stats runtime.MemStats
+				runtime.ReadMemStats(&stats)
+				if stats.HeapAlloc > peak {
+					peak = stats.HeapAlloc
+				}
+			}
+			return nil
+		}
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			runtime.GC()
+			if err := run(newRowStreamer(source, uint64(source.size)), process); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MiB")
+	}
+
+	b.Run("slice", func(b *testing.B) {
+		measure(b, func(s dban.Streamer[fatEntity], fn func(context.Context, fatEntity) error) error {
+			return s.FormListAndProcess(fn)
+		})
+	})
+	b.Run("rows", func(b *testing.B) {
+		measure(b, func(s dban.Streamer[fatEntity], fn func(context.Context, fatEntity) error) error {
+			return s.FormAndProcessRows(fn)
+		})
+	})
+}
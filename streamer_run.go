@@ -0,0 +1,113 @@
+package dban
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+const (
+	defaultEmptyPollInterval = time.Second
+	defaultRunErrorInterval  = 5 * time.Second
+)
+
+// RunConfig configures Run
+type RunConfig struct {
+	// EmptyPollInterval is the time Run waits for once the end of the stream is reached,
+	// a second by default
+	EmptyPollInterval time.Duration
+	// MaxEmptyPollInterval enables the exponential backoff: if it exceeds EmptyPollInterval,
+	// the wait doubles every time the end of the stream is reached in a row, up to it
+	MaxEmptyPollInterval time.Duration
+	// ErrorInterval is the time Run waits for after a failure before forming the next list,
+	// 5 seconds by default
+	ErrorInterval time.Duration
+	// FailFast makes Run return the first failure instead of logging it and going on
+	FailFast bool
+}
+
+// Run forms and processes lists until ctx is canceled, following the stream the way
+// ProcessAll drains it. A full list is followed by the next one right away, while a list
+// that is not full means the end of the stream, so Run waits for EmptyPollInterval before
+// polling it again. As with ProcessAll, the last page is processed again at every poll
+// until it is full (see DedupWindow). Failures are logged with Log and the loop goes on
+// after ErrorInterval, unless FailFast is set. Canceling ctx stops the loop between lists
+// and makes Run return nil. The cursor is flushed on return (see Flush)
+func (s *streamer[T]) Run(ctx context.Context, fn func(ctx context.Context, t T) error, cfg RunConfig) (err error) {
+	if s.err != nil {
+		return errors.Wrap(s.err, "invalid streamer")
+	}
+	defer func() { err = s.flushed(err) }()
+	if cfg.EmptyPollInterval <= 0 {
+		cfg.EmptyPollInterval = defaultEmptyPollInterval
+	}
+	if cfg.ErrorInterval <= 0 {
+		cfg.ErrorInterval = defaultRunErrorInterval
+	}
+
+	drain := *s
+	drain.draining = true
+	drain.countTotal(true)
+	poll := cfg.EmptyPollInterval
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		page, formed, _, err := drain.formListAndProcess(eachEntity(fn))
+		if stopped(err) || Is(err, ErrInterrupted) {
+			return nil
+		}
+		if Is(err, ErrStreamerPaused) {
+			// the flag is checked again once the poll interval passes
+			poll, err = cfg.EmptyPollInterval, nil
+		}
+		if open, ok := errors.Cause(err).(*CircuitOpenError); ok {
+			// the probe list is formed once the cool-down passes
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-s.Clock.After(open.Until.Sub(s.Clock.Now())):
+			}
+			continue
+		}
+		if err == nil && formed != 0 {
+			drain.reportProgress(page, formed)
+		}
+		if err == nil && formed != 0 && (uint64(formed)%s.BatchSize == 0 || s.descending) {
+			poll = cfg.EmptyPollInterval
+			continue
+		}
+		if err == nil && formed != 0 {
+			// a list of concurrent streamers spans several pages, and the entities added to
+			// the last of them later would be skipped if the cursor was left past it
+			err = drain.rewind(page + (uint64(formed)+s.BatchSize-1)/s.BatchSize - 1)
+		}
+
+		wait := poll
+		if err != nil {
+			if cfg.FailFast {
+				return err
+			}
+			if s.Log != nil {
+				s.Log.WithError(err).WithFields(logan.F{
+					"key":   s.KeyValueKey,
+					"retry": cfg.ErrorInterval,
+				}).Error("Failed to process list")
+			}
+			wait = cfg.ErrorInterval
+		} else if poll < cfg.MaxEmptyPollInterval {
+			if poll *= 2; poll > cfg.MaxEmptyPollInterval {
+				poll = cfg.MaxEmptyPollInterval
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.Clock.After(wait):
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerRun(t *testing.T) {
+	start := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	stream := &mutableStreamable{items: []int{1, 2, 3}}
+	batchSize := uint64(2)
+	streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      stream,
+		KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+		KeyValueKey: cursorKey,
+		BatchSize:   &batchSize,
+		Clock:       clock,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var processed []int
+	done := make(chan error, 1)
+	go func() {
+		done <- streamer.Run(ctx, func(_ context.Context, i int) error {
+			processed = append(processed, i)
+			if len(processed) == 4 {
+				// the last page is processed for the second time
+				stream.items = append(stream.items, 4, 5, 6)
+			}
+			return nil
+		}, dban.RunConfig{EmptyPollInterval: time.Second, MaxEmptyPollInterval: 3 * time.Second})
+	}()
+
+	// the waits double at the end of the stream, start over once a full list is formed and
+	// are capped at the maximum
+	for _, elapsed := range []time.Duration{1, 3, 4, 6, 9, 12} {
+		assert.Equal(t, start.Add(elapsed*time.Second), clock.advanceToNext(t))
+	}
+	cancel()
+
+	require.NoError(t, <-done)
+	assert.Equal(t, []int{1, 2, 3, 3, 3, 4, 5, 6}, processed)
+}
+
+func TestStreamerRunFailure(t *testing.T) {
+	newStreamer := func(clock dban.Clock) dban.Streamer[int] {
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:      dbantest.NewSliceStreamable([]int{1, 2}),
+			KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey: cursorKey,
+			Clock:       clock,
+		})
+	}
+	failFirst := func(processed *[]int) func(context.Context, int) error {
+		return func(_ context.Context, i int) error {
+			*processed = append(*processed, i)
+			if len(*processed) == 1 {
+				return errors.New("failed to process")
+			}
+			return nil
+		}
+	}
+
+	t.Run("logged", func(t *testing.T) {
+		start := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)
+		clock := &fakeClock{now: start}
+		ctx, cancel := context.WithCancel(context.Background())
+		var processed []int
+		done := make(chan error, 1)
+		go func() {
+			done <- newStreamer(clock).Run(ctx, failFirst(&processed), dban.RunConfig{
+				EmptyPollInterval: time.Second,
+				ErrorInterval:     5 * time.Second,
+			})
+		}()
+
+		// the end of the stream is polled once the error interval is over
+		assert.Equal(t, start.Add(5*time.Second), clock.advanceToNext(t))
+		assert.Equal(t, start.Add(6*time.Second), clock.advanceToNext(t))
+		cancel()
+
+		require.NoError(t, <-done)
+		// the cursor was moved past the failed list before it was processed
+		assert.Equal(t, []int{1}, processed)
+	})
+
+	t.Run("fail fast", func(t *testing.T) {
+		var processed []int
+		err := newStreamer(&fakeClock{}).Run(context.Background(), failFirst(&processed), dban.RunConfig{FailFast: true})
+
+		var streamErr *dban.StreamError
+		require.True(t, errors.As(err, &streamErr), "unexpected error: %v", err)
+		assert.Equal(t, 0, streamErr.EntityIndex)
+		assert.Equal(t, []int{1}, processed)
+	})
+}
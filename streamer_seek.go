@@ -0,0 +1,101 @@
+package dban
+
+import (
+	"math"
+
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+func (s *streamer[T]) Reset() error {
+	if s.descending {
+		if err := s.resetDescending(); err != nil {
+			return err
+		}
+		s.emit(CursorReset{Key: s.KeyValueKey})
+		return nil
+	}
+	if err := s.setPage(0, "Cursor reset", nil); err != nil {
+		return err
+	}
+	s.emit(CursorReset{Key: s.KeyValueKey})
+	return nil
+}
+
+func (s *streamer[T]) SetPage(page uint64) error {
+	return s.setPage(page, "Cursor set", nil)
+}
+
+// SkipToEnd moves the cursor to the page the last entity counted is on. The last page is
+// processed again if it is partially filled, as the entities added later are taken from it.
+// When the count is a multiple of the batch size, the cursor of a draining streamer (see
+// WrapAround) is moved to the empty page past the end, where it waits for new entities,
+// while the cursor of a wrapping one is moved to the last full page, as an empty page would
+// make it start over from the first one
+func (s *streamer[T]) SkipToEnd() error {
+	if s.err != nil {
+		return errors.Wrap(s.err, "invalid streamer")
+	}
+	fields := logan.F{"key": s.KeyValueKey}
+	if s.descending {
+		return errors.From(errors.New("Descending streamer could not skip to the end"), fields)
+	}
+	countable, ok := s.Source.(Countable)
+	if !ok {
+		return errors.From(ErrNotCountable, fields)
+	}
+
+	count, err := countable.Count()
+	if err != nil {
+		return errors.Wrap(err, "failed to count entities", fields)
+	}
+	page := count / s.BatchSize
+	if count%s.BatchSize == 0 && page > 0 && !s.draining {
+		page--
+	}
+
+	current, err := s.GetCurrentPage()
+	if err != nil {
+		return errors.Wrap(err, "failed to get current page", fields)
+	}
+	var skipped uint64
+	if page > current {
+		skipped = page - current
+	}
+	return s.setPage(page, "Cursor skipped to the end", logan.F{"count": count, "skipped": skipped})
+}
+
+// setPage writes the cursor along with the batch size it is counted with, logging message
+// with the extra fields once it is written
+func (s *streamer[T]) setPage(page uint64, message string, extra logan.F) error {
+	if s.err != nil {
+		return errors.Wrap(s.err, "invalid streamer")
+	}
+	fields := logan.F{"key": s.KeyValueKey, "page": page}
+	// cursors are advanced as bigint by the SQL storages
+	if page > math.MaxInt64 {
+		return errors.From(errors.New("page overflows int64"), fields)
+	}
+
+	err := s.inTx(func(s *streamer[T]) error {
+		if err := s.writeCursor(page); err != nil {
+			return errors.Wrap(err, "failed to write cursor")
+		}
+		if s.descending {
+			if err := s.markDirection(descendingMarker); err != nil {
+				return err
+			}
+		}
+		return s.storeBatchSize()
+	})
+	// the cursor is read again with CursorCacheSync
+	s.checkpoint.forget()
+	if err != nil {
+		return errors.Wrap(err, "failed to set page", fields)
+	}
+
+	if s.Log != nil {
+		s.Log.WithFields(fields.Merge(extra)).Info(message)
+	}
+	return nil
+}
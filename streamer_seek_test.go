@@ -0,0 +1,92 @@
+package dban_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerSetPage(t *testing.T) {
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	streamer := newTestStreamer(dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5, 6}), kvQ)
+
+	require.NoError(t, streamer.SetPage(2))
+	list, err := streamer.FormList()
+	require.NoError(t, err)
+	assert.Equal(t, []int{5, 6}, list)
+	assert.Equal(t, "2", kvQ.MustGet(cursorKey+":batch_size").Value)
+
+	require.NoError(t, streamer.Reset())
+	list, err = streamer.FormList()
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, list)
+
+	assert.Error(t, streamer.SetPage(math.MaxInt64+1))
+	assert.Equal(t, "1", kvQ.MustGet(cursorKey).Value)
+}
+
+func TestStreamerSetPageBatchSize(t *testing.T) {
+	// the page is counted with the batch size of the streamer setting it
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey + ":batch_size", Value: "3"}))
+	streamer := newTestStreamer(dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5, 6}), kvQ)
+
+	require.NoError(t, streamer.SetPage(1))
+	list, err := streamer.FormList()
+	require.NoError(t, err)
+	assert.Equal(t, []int{3, 4}, list)
+}
+
+func TestStreamerSkipToEnd(t *testing.T) {
+	newStreamer := func(count int, wrapAround bool, kvQ dban.KeyValueQ) dban.Streamer[int] {
+		entities := make([]int, count)
+		for i := range entities {
+			entities[i] = i + 1
+		}
+		batchSize := uint64(15)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream: countableStreamable{
+				Streamable: dbantest.NewSliceStreamable(entities),
+				count:      func() (uint64, error) { return uint64(count), nil },
+			},
+			KeyValueQ:   kvQ,
+			KeyValueKey: cursorKey,
+			BatchSize:   &batchSize,
+			WrapAround:  &wrapAround,
+		})
+	}
+
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	streamer := newStreamer(47, true, kvQ)
+	require.NoError(t, streamer.SkipToEnd())
+	assert.Equal(t, "3", kvQ.MustGet(cursorKey).Value)
+	list, err := streamer.FormList()
+	require.NoError(t, err)
+	assert.Equal(t, []int{46, 47}, list, "the partially filled last page must be processed")
+
+	// an empty page past the end makes a wrapping streamer start over
+	kvQ = dbantest.NewMemoryKeyValueQ()
+	streamer = newStreamer(45, true, kvQ)
+	require.NoError(t, streamer.SkipToEnd())
+	assert.Equal(t, "2", kvQ.MustGet(cursorKey).Value)
+
+	kvQ = dbantest.NewMemoryKeyValueQ()
+	streamer = newStreamer(45, false, kvQ)
+	require.NoError(t, streamer.SkipToEnd())
+	assert.Equal(t, "3", kvQ.MustGet(cursorKey).Value)
+	list, err = streamer.FormList()
+	require.NoError(t, err)
+	assert.Empty(t, list)
+	assert.Equal(t, "3", kvQ.MustGet(cursorKey).Value, "a draining streamer must wait for new entities at the end")
+
+	kvQ = dbantest.NewMemoryKeyValueQ()
+	streamer = newTestStreamer(dbantest.NewSliceStreamable([]int{1, 2, 3}), kvQ)
+	assert.True(t, dban.Is(streamer.SkipToEnd(), dban.ErrNotCountable))
+	cursor, err := kvQ.Get(cursorKey)
+	require.NoError(t, err)
+	assert.Nil(t, cursor)
+}
@@ -0,0 +1,30 @@
+package dban
+
+import (
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+// SortableStreamable is an interface a Streamable could implement as well to select pages
+// sorted by the Sorts of the streamer, so that the order offset pagination relies on is
+// configured along with the streamer instead of being left to every query
+type SortableStreamable[T any] interface {
+	SelectWithPageParamsSorted(pageParams pgdb.OffsetPageParams, sorts pgdb.Sorts) ([]T, error)
+}
+
+// newSortedSource returns the source to select the pages sorted by sorts from, nil if there
+// are no sorts or the stream does not support them
+func newSortedSource[T any](stream Streamable[T], sorts pgdb.Sorts) SortableStreamable[T] {
+	if len(sorts) == 0 {
+		return nil
+	}
+	sortable, _ := stream.(SortableStreamable[T])
+	return sortable
+}
+
+// selectPage selects the page from Source, sorted by Sorts if they are set
+func (s *streamer[T]) selectPage(params pgdb.OffsetPageParams) ([]T, error) {
+	if s.sorted != nil {
+		return s.sorted.SelectWithPageParamsSorted(params, s.Sorts)
+	}
+	return s.Source.SelectWithPageParams(params)
+}
@@ -0,0 +1,76 @@
+package dban_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+// sortableStreamable sorts its numbers by "number" or "-number" before selecting a page
+type sortableStreamable struct {
+	dban.Streamable[int]
+	numbers []int
+	sorts   []pgdb.Sorts
+}
+
+func (s *sortableStreamable) SelectWithPageParamsSorted(pageParams pgdb.OffsetPageParams, sorts pgdb.Sorts) ([]int, error) {
+	s.sorts = append(s.sorts, sorts)
+	numbers := append([]int(nil), s.numbers...)
+	sort.Slice(numbers, func(i, j int) bool {
+		if sorts[0].Desc() {
+			return numbers[i] > numbers[j]
+		}
+		return numbers[i] < numbers[j]
+	})
+	return dbantest.NewSliceStreamable(numbers).SelectWithPageParams(pageParams)
+}
+
+func TestStreamerSorts(t *testing.T) {
+	numbers := []int{3, 1, 4, 2, 5}
+	newStream := func() *sortableStreamable {
+		return &sortableStreamable{Streamable: dbantest.NewSliceStreamable(numbers), numbers: numbers}
+	}
+	newParams := func(key string, stream dban.Streamable[int], sorts pgdb.Sorts) dban.StreamerInitParams[int] {
+		batchSize := uint64(3)
+		return dban.StreamerInitParams[int]{
+			Stream:      stream,
+			KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey: key,
+			BatchSize:   &batchSize,
+			Sorts:       sorts,
+		}
+	}
+
+	t.Run("sorted", func(t *testing.T) {
+		stream := newStream()
+		streamer := dban.NewStreamer(newParams("sorts-sorted", stream, pgdb.Sorts{"-number"}))
+
+		var processed []int
+		_, err := streamer.ProcessAll(func(_ context.Context, i int) error {
+			processed = append(processed, i)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []int{5, 4, 3, 2, 1}, processed)
+		assert.Equal(t, pgdb.Sorts{"-number"}, stream.sorts[0])
+	})
+
+	t.Run("unsorted", func(t *testing.T) {
+		stream := newStream()
+		list, err := dban.NewStreamer(newParams("sorts-unsorted", stream, nil)).FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{3, 1, 4}, list, "the pages must be selected as they are without sorts")
+		assert.Empty(t, stream.sorts)
+	})
+
+	t.Run("not sortable", func(t *testing.T) {
+		_, err := dban.NewStreamerChecked(newParams("sorts-not-sortable", dbantest.NewSliceStreamable(numbers), pgdb.Sorts{"number"}))
+		assert.ErrorContains(t, err, "Sorts requires a Stream implementing SortableStreamable")
+	})
+}
@@ -0,0 +1,130 @@
+package dban
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatusReporter is a streamer whose state StatusHandler renders. Streamers created with
+// NewStreamer implement it
+type StatusReporter interface {
+	HealthReporter
+	GetCurrentPage() (uint64, error)
+	GetStats() StreamerStats
+	IsPaused() (bool, error)
+}
+
+// StreamerStatus is the state of a streamer rendered by StatusHandler
+type StreamerStatus struct {
+	// Name is the name the streamer is registered under
+	Name string `json:"name"`
+	// Key is the cursor key of the streamer
+	Key string `json:"key"`
+	// Page is the page the cursor is at, null if it failed to be read
+	Page *uint64 `json:"page"`
+	// Paused tells whether the streamer is paused, null if it failed to be read
+	Paused *bool `json:"paused"`
+	// LastProgress is the time a list was last formed, zero if none was
+	LastProgress time.Time     `json:"last_progress"`
+	Stats        StreamerStats `json:"stats"`
+	// Error describes the failures to read the state of the streamer, if any
+	Error string `json:"error,omitempty"`
+}
+
+// StatusReport is the document StatusHandler renders
+type StatusReport struct {
+	// Streamers are ordered by their names
+	Streamers []StreamerStatus `json:"streamers"`
+}
+
+// StatusHandler is an http.Handler rendering the state of the registered streamers as a
+// StatusReport in JSON, e.g. for a /debug/dban endpoint. The cursor and the pause flag of
+// every streamer are read from the key value storage on each request, a failure to read them
+// being reported in the entry of the streamer rather than failing the response
+type StatusHandler struct {
+	mu        sync.RWMutex
+	reporters map[string]StatusReporter
+}
+
+// NewStatusHandler creates a status handler with no streamers registered (see Register)
+func NewStatusHandler() *StatusHandler {
+	return &StatusHandler{reporters: make(map[string]StatusReporter)}
+}
+
+// Register adds the streamer to the report under the name, replacing the one registered
+// under the same name, if any
+func (h *StatusHandler) Register(name string, s StatusReporter) *StatusHandler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reporters[name] = s
+	return h
+}
+
+// RegisterGroup registers the streamers added to the group so far under their names in it
+func (h *StatusHandler) RegisterGroup(g *StreamerGroup) *StatusHandler {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for name, member := range g.members {
+		h.Register(name, member.reporter())
+	}
+	return h
+}
+
+// Report reads the state of the registered streamers
+func (h *StatusHandler) Report() StatusReport {
+	h.mu.RLock()
+	names := make([]string, 0, len(h.reporters))
+	reporters := make(map[string]StatusReporter, len(h.reporters))
+	for name, reporter := range h.reporters {
+		names = append(names, name)
+		reporters[name] = reporter
+	}
+	h.mu.RUnlock()
+
+	sort.Strings(names)
+	report := StatusReport{Streamers: make([]StreamerStatus, 0, len(names))}
+	for _, name := range names {
+		report.Streamers = append(report.Streamers, streamerStatus(name, reporters[name]))
+	}
+	return report
+}
+
+func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	body, err := json.Marshal(h.Report())
+	if err != nil {
+		http.Error(w, "failed to marshal status", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
+
+// streamerStatus reads the state of the streamer, collecting the failures to read it
+func streamerStatus(name string, s StatusReporter) StreamerStatus {
+	status := StreamerStatus{
+		Name:         name,
+		Key:          s.Name(),
+		LastProgress: s.LastProgress(),
+		Stats:        s.GetStats(),
+	}
+
+	var failures []string
+	if page, err := s.GetCurrentPage(); err != nil {
+		failures = append(failures, "failed to get current page: "+err.Error())
+	} else {
+		status.Page = &page
+	}
+	if paused, err := s.IsPaused(); err != nil {
+		failures = append(failures, "failed to check pause: "+err.Error())
+	} else {
+		status.Paused = &paused
+	}
+	status.Error = strings.Join(failures, "; ")
+
+	return status
+}
@@ -0,0 +1,86 @@
+package dban_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+// brokenReporter fails to read the state kept in the key value storage
+type brokenReporter struct {
+	dban.Streamer[int]
+}
+
+func (brokenReporter) GetCurrentPage() (uint64, error) { return 0, errors.New("connection refused") }
+func (brokenReporter) IsPaused() (bool, error)         { return false, errors.New("connection refused") }
+
+func TestStatusHandler(t *testing.T) {
+	newStreamer := func(key string) dban.Streamer[int] {
+		batchSize := uint64(2)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3}),
+			KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey: key,
+			BatchSize:   &batchSize,
+		})
+	}
+	orders, payments := newStreamer("status-orders"), newStreamer("status-payments")
+	require.NoError(t, orders.FormListAndProcess(func(context.Context, int) error { return nil }))
+	require.NoError(t, payments.Pause())
+
+	handler := dban.NewStatusHandler().
+		Register("payments", payments).
+		Register("orders", orders).
+		Register("broken", brokenReporter{Streamer: newStreamer("status-broken")})
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/dban", nil))
+
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	var report struct {
+		Streamers []map[string]json.RawMessage `json:"streamers"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	require.Len(t, report.Streamers, 3)
+	for _, entry := range report.Streamers {
+		for _, field := range []string{"name", "key", "page", "paused", "last_progress", "stats"} {
+			assert.Contains(t, entry, field)
+		}
+	}
+
+	broken, ordersEntry, paymentsEntry := report.Streamers[0], report.Streamers[1], report.Streamers[2]
+	assert.JSONEq(t, `"broken"`, string(broken["name"]), "streamers must be ordered by name")
+	assert.JSONEq(t, `null`, string(broken["page"]))
+	assert.JSONEq(t, `null`, string(broken["paused"]))
+	assert.JSONEq(t, `"failed to get current page: connection refused; failed to check pause: connection refused"`,
+		string(broken["error"]))
+
+	assert.JSONEq(t, `"status-orders"`, string(ordersEntry["key"]))
+	assert.JSONEq(t, `1`, string(ordersEntry["page"]))
+	assert.JSONEq(t, `false`, string(ordersEntry["paused"]))
+	assert.NotContains(t, ordersEntry, "error")
+	var stats dban.StreamerStats
+	require.NoError(t, json.Unmarshal(ordersEntry["stats"], &stats))
+	assert.GreaterOrEqual(t, stats.Processed, int64(2))
+
+	assert.JSONEq(t, `true`, string(paymentsEntry["paused"]))
+	assert.JSONEq(t, `"0001-01-01T00:00:00Z"`, string(paymentsEntry["last_progress"]))
+
+	t.Run("group", func(t *testing.T) {
+		group := dban.NewStreamerGroup().
+			Add("orders", dban.StreamerRun(orders, func(context.Context, int) error { return nil }, dban.RunConfig{}))
+		report := dban.NewStatusHandler().RegisterGroup(group).Report()
+		require.Len(t, report.Streamers, 1)
+		assert.Equal(t, "orders", report.Streamers[0].Name)
+		assert.Equal(t, "status-orders", report.Streamers[0].Key)
+	})
+}
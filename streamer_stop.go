@@ -0,0 +1,54 @@
+package dban
+
+// stopped reports whether err is ErrStopStreaming returned by a processing function
+func stopped(err error) bool {
+	return err != nil && Is(err, ErrStopStreaming)
+}
+
+// stopStreaming handles ErrStopStreaming the list was stopped with: the cursor is moved back
+// to the page of the entity that returned it, unless the cursor was held and so has not been
+// moved past the page, or it is shared by cooperative instances. It returns err, as the
+// callers stop on it, or a failure to move the cursor back
+func (s *streamer[T]) stopStreaming(err error, held bool) error {
+	streamErr := s.streamError(err)
+	if !held && !s.cooperative {
+		if rewindErr := s.rewind(streamErr.Page); rewindErr != nil {
+			return rewindErr
+		}
+	}
+	if s.Log != nil {
+		s.Log.WithFields(streamErr.Fields()).Info("Streaming stopped by the processing function")
+	}
+	return err
+}
+
+// canceled reports whether err is caused by Ctx being done
+func (s *streamer[T]) canceled(err error) bool {
+	ctxErr := s.Ctx.Err()
+	return err != nil && ctxErr != nil && Is(err, ctxErr)
+}
+
+// interruptStreaming handles Ctx done in the middle of a list the way stopStreaming handles
+// ErrStopStreaming, persisting the progress regardless of Ctx: the cursor is moved back to
+// the page of the entity interrupted at, or, if it was held, the number of the entities of
+// the page done with is stored (see CheckpointEveryNEntities). It returns ErrInterrupted
+// wrapping err, or a failure to persist the progress
+func (s *streamer[T]) interruptStreaming(err error, held bool) error {
+	streamErr := s.streamError(err)
+	if streamErr.Stage == StageProcess {
+		persist := *s
+		persist.KeyValueQ = s.shutdownQ
+		switch {
+		case held:
+			persist.offsetReached(streamErr.Page, streamErr.EntityIndex)
+		case !s.cooperative:
+			if rewindErr := persist.rewind(streamErr.Page); rewindErr != nil {
+				return rewindErr
+			}
+		}
+	}
+	if s.Log != nil {
+		s.Log.WithFields(streamErr.Fields()).Info("Streaming interrupted")
+	}
+	return &kindError{kind: ErrInterrupted, err: err}
+}
@@ -0,0 +1,162 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerStop(t *testing.T) {
+	newStreamer := func(key string, kvQ dban.KeyValueQ, advanceAfterProcessing bool) dban.Streamer[int] {
+		batchSize, every := uint64(2), uint64(1)
+		params := dban.StreamerInitParams[int]{
+			Stream:                 dbantest.NewSliceStreamable([]int{1, 2, 3, 4}),
+			KeyValueQ:              kvQ,
+			KeyValueKey:            key,
+			BatchSize:              &batchSize,
+			AdvanceAfterProcessing: advanceAfterProcessing,
+		}
+		if advanceAfterProcessing {
+			params.CheckpointEveryNEntities = &every
+		}
+		return dban.NewStreamer(params)
+	}
+	stopAt := func(stop int, err error) func(context.Context, int) error {
+		return func(_ context.Context, i int) error {
+			if i == stop {
+				return err
+			}
+			return nil
+		}
+	}
+
+	t.Run("stopped", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := newStreamer("stop-stopped", kvQ, false)
+		require.NoError(t, streamer.FormListAndProcess(stopAt(3, nil)))
+
+		err := streamer.FormListAndProcess(stopAt(3, fmt.Errorf("maintenance: %w", dban.ErrStopStreaming)))
+		require.NoError(t, err)
+		assert.Equal(t, "1", kvQ.MustGet("stop-stopped").Value, "the page stopped at must be formed again")
+		assert.Zero(t, streamer.GetStats().Errors)
+		assert.Zero(t, streamer.GetStats().Failed)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := newStreamer("stop-failed", kvQ, false)
+		require.NoError(t, streamer.FormListAndProcess(stopAt(3, nil)))
+
+		failure := errors.New("boom")
+		err := streamer.FormListAndProcess(stopAt(3, failure))
+		assert.True(t, dban.Is(err, failure))
+		assert.Equal(t, "2", kvQ.MustGet("stop-failed").Value, "the page failed is skipped")
+		assert.Equal(t, int64(1), streamer.GetStats().Errors)
+		assert.Equal(t, int64(1), streamer.GetStats().Failed)
+	})
+
+	t.Run("advance after processing", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := newStreamer("stop-held", kvQ, true)
+
+		require.NoError(t, streamer.FormListAndProcess(stopAt(2, dban.ErrStopStreaming)))
+		assert.Equal(t, "0:1", kvQ.MustGet("stop-held:offset").Value, "the entities completed must be checkpointed")
+
+		var processed []int
+		require.NoError(t, streamer.FormListAndProcess(func(_ context.Context, i int) error {
+			processed = append(processed, i)
+			return nil
+		}))
+		assert.Equal(t, []int{2}, processed)
+	})
+
+	t.Run("process all", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := newStreamer("stop-all", kvQ, false)
+
+		report, err := streamer.ProcessAll(stopAt(4, dban.ErrStopStreaming))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(3), report.Entities)
+		assert.Equal(t, "1", kvQ.MustGet("stop-all").Value)
+	})
+}
+
+func TestStreamerInterrupted(t *testing.T) {
+	newStreamer := func(ctx context.Context, key string, kvQ dban.KeyValueQ, advanceAfterProcessing bool) dban.Streamer[int] {
+		batchSize, every := uint64(2), uint64(10)
+		params := dban.StreamerInitParams[int]{
+			Stream:                 dbantest.NewSliceStreamable([]int{1, 2, 3, 4}),
+			KeyValueQ:              kvQ,
+			KeyValueKey:            key,
+			BatchSize:              &batchSize,
+			Ctx:                    &ctx,
+			AdvanceAfterProcessing: advanceAfterProcessing,
+		}
+		if advanceAfterProcessing {
+			params.CheckpointEveryNEntities = &every
+		}
+		return dban.NewStreamer(params)
+	}
+	// cancelAfter cancels the context once the entity is processed
+	cancelAfter := func(last int, cancel context.CancelFunc, processed *[]int) func(context.Context, int) error {
+		return func(_ context.Context, i int) error {
+			*processed = append(*processed, i)
+			if i == last {
+				cancel()
+			}
+			return nil
+		}
+	}
+
+	t.Run("interrupted", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := newStreamer(ctx, "interrupt-page", kvQ, false)
+		require.NoError(t, streamer.FormListAndProcess(func(context.Context, int) error { return nil }))
+
+		var processed []int
+		err := streamer.FormListAndProcess(cancelAfter(3, cancel, &processed))
+		assert.True(t, dban.Is(err, dban.ErrInterrupted))
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.Equal(t, []int{3}, processed, "the entity in flight must be finished")
+		assert.Equal(t, "1", kvQ.MustGet("interrupt-page").Value, "the page interrupted at must be formed again")
+		assert.Zero(t, streamer.GetStats().Errors, "an interruption is not a failure")
+	})
+
+	t.Run("advance after processing", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		kvQ := dbantest.NewMemoryKeyValueQ()
+
+		var processed []int
+		err := newStreamer(ctx, "interrupt-held", kvQ, true).FormListAndProcess(cancelAfter(1, cancel, &processed))
+		assert.True(t, dban.Is(err, dban.ErrInterrupted))
+		assert.Equal(t, "0", kvQ.MustGet("interrupt-held").Value)
+		assert.Equal(t, "0:1", kvQ.MustGet("interrupt-held:offset").Value, "the offset must match the last entity processed")
+
+		processed = nil
+		streamer := newStreamer(context.Background(), "interrupt-held", kvQ, true)
+		require.NoError(t, streamer.FormListAndProcess(cancelAfter(0, func() {}, &processed)))
+		assert.Equal(t, []int{2}, processed)
+	})
+
+	t.Run("process all", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var processed []int
+		_, err := newStreamer(ctx, "interrupt-all", dbantest.NewMemoryKeyValueQ(), false).ProcessAll(cancelAfter(1, cancel, &processed))
+		assert.True(t, dban.Is(err, dban.ErrInterrupted))
+	})
+
+	t.Run("run", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var processed []int
+		streamer := newStreamer(ctx, "interrupt-run", dbantest.NewMemoryKeyValueQ(), false)
+		err := streamer.Run(context.Background(), cancelAfter(1, cancel, &processed), dban.RunConfig{FailFast: true})
+		require.NoError(t, err, "Run must stop on cancellation without a failure")
+	})
+}
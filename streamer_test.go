@@ -1,8 +1,637 @@
-package dban
+package dban_test
 
-import "testing"
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+const cursorKey = "cursor"
+
+func newTestStreamer(stream dban.Streamable[int], kvQ dban.KeyValueQ) dban.Streamer[int] {
+	batchSize := uint64(2)
+	return dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      stream,
+		KeyValueQ:   kvQ,
+		KeyValueKey: cursorKey,
+		BatchSize:   &batchSize,
+	})
+}
 
-// TODO: to be implemented
 func TestStreamer(t *testing.T) {
-	return
+	var recorded []pgdb.OffsetPageParams
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	streamer := newTestStreamer(dbantest.NewSliceStreamable([]int{1, 2, 3}, dbantest.WithPageParamsRecorder(&recorded)), kvQ)
+
+	for _, expected := range [][]int{{1, 2}, {3}, {1, 2}} {
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, expected, list)
+	}
+
+	page, err := streamer.GetCurrentPage()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), page)
+	assert.Equal(t, []uint64{0, 1, 2, 0}, pageNumbers(recorded), "end of the list must wrap to the first page")
+
+	var processed []int
+	require.NoError(t, streamer.FormListAndProcess(func(_ context.Context, item int) error {
+		processed = append(processed, item)
+		return nil
+	}))
+	assert.Equal(t, []int{3}, processed)
+}
+
+func TestStreamerEmpty(t *testing.T) {
+	list, err := newTestStreamer(dbantest.NewSliceStreamable([]int{}), dbantest.NewMemoryKeyValueQ()).FormList()
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestStreamerErrors(t *testing.T) {
+	failure := errors.New("page is broken")
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	streamer := newTestStreamer(dbantest.NewSliceStreamable([]int{1, 2, 3}, dbantest.WithPageError(1, failure)), kvQ)
+
+	_, err := streamer.FormList()
+	require.NoError(t, err)
+	_, err = streamer.FormList()
+	assert.Error(t, err)
+	assert.Equal(t, "1", kvQ.MustGet(cursorKey).Value, "cursor must not move on failures")
+
+	for _, cursor := range []string{"-1", "1O"} {
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: cursor}))
+		_, err = streamer.GetCurrentPage()
+		assert.True(t, dban.Is(err, dban.ErrCorruptCursor), cursor)
+	}
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "0"}))
+	err = streamer.FormListAndProcess(func(context.Context, int) error { return failure })
+	assert.Error(t, err)
+}
+
+func pageNumbers(params []pgdb.OffsetPageParams) []uint64 {
+	numbers := make([]uint64, len(params))
+	for i, p := range params {
+		numbers[i] = p.PageNumber
+	}
+	return numbers
+}
+
+func TestStreamerCursorParsing(t *testing.T) {
+	cases := []struct {
+		cursor string
+		page   uint64
+		valid  bool
+	}{
+		{cursor: "0", page: 0, valid: true},
+		{cursor: "42", page: 42, valid: true},
+		{cursor: strconv.FormatInt(math.MaxInt64, 10), page: math.MaxInt64, valid: true},
+		{cursor: strconv.FormatUint(math.MaxInt64+1, 10), page: math.MaxInt64 + 1, valid: true},
+		{cursor: strconv.FormatUint(math.MaxUint64-1, 10), page: math.MaxUint64 - 1, valid: true},
+		{cursor: strconv.FormatUint(math.MaxUint64, 10)},
+		{cursor: "18446744073709551616"},
+		{cursor: "-1"},
+		{cursor: "+1"},
+		{cursor: "1O"},
+		{cursor: ""},
+		{cursor: " 1"},
+		{cursor: "1\n"},
+	}
+
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	streamer := newTestStreamer(dbantest.NewSliceStreamable([]int{}), kvQ)
+	for _, tc := range cases {
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: tc.cursor}))
+
+		page, err := streamer.GetCurrentPage()
+		if tc.valid {
+			assert.NoError(t, err, "%q", tc.cursor)
+			assert.Equal(t, tc.page, page, "%q", tc.cursor)
+			continue
+		}
+
+		assert.True(t, dban.Is(err, dban.ErrCorruptCursor), "%q", tc.cursor)
+		corrupt, ok := errors.Cause(err).(*dban.CorruptCursorError)
+		if assert.True(t, ok, "%q", tc.cursor) {
+			assert.Equal(t, tc.cursor, corrupt.Value)
+		}
+	}
+}
+
+// racingKeyValueQ fixes the cursor on behalf of another instance right before the recovery reads it
+type racingKeyValueQ struct {
+	dban.KeyValueQ
+	reads int
+}
+
+func (q *racingKeyValueQ) LockingGet(key string) (*dban.KeyValue, error) {
+	if q.reads++; q.reads == 2 {
+		if err := q.KeyValueQ.Upsert(dban.KeyValue{Key: key, Value: "1"}); err != nil {
+			return nil, err
+		}
+	}
+	return q.KeyValueQ.LockingGet(key)
+}
+
+func TestStreamerCorruptCursorPolicy(t *testing.T) {
+	newStreamer := func(kvQ dban.KeyValueQ, policy dban.CorruptCursorPolicy, handler dban.CorruptCursorHandler) dban.Streamer[int] {
+		batchSize := uint64(2)
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "1O"}))
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:              dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5}),
+			KeyValueQ:           kvQ,
+			KeyValueKey:         cursorKey,
+			BatchSize:           &batchSize,
+			CorruptCursorPolicy: &policy,
+			OnCorruptCursor:     handler,
+		})
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		_, err := newStreamer(kvQ, dban.CorruptCursorFail, nil).FormList()
+		assert.True(t, dban.Is(err, dban.ErrCorruptCursor))
+		assert.Equal(t, "1O", kvQ.MustGet(cursorKey).Value)
+	})
+
+	t.Run("reset to zero", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		list, err := newStreamer(kvQ, dban.CorruptCursorResetToZero, nil).FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, list)
+		assert.Equal(t, "1", kvQ.MustGet(cursorKey).Value)
+	})
+
+	t.Run("callback", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		var raw string
+		list, err := newStreamer(kvQ, dban.CorruptCursorCallback, func(cursor string) (uint64, error) {
+			raw = cursor
+			return 2, nil
+		}).FormList()
+		require.NoError(t, err)
+		assert.Equal(t, "1O", raw)
+		assert.Equal(t, []int{5}, list)
+		assert.Equal(t, "3", kvQ.MustGet(cursorKey).Value)
+	})
+
+	t.Run("callback failure", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		_, err := newStreamer(kvQ, dban.CorruptCursorCallback, func(string) (uint64, error) {
+			return 0, errors.New("refusing to guess")
+		}).FormList()
+		assert.Error(t, err)
+		assert.Equal(t, "1O", kvQ.MustGet(cursorKey).Value)
+
+		_, err = newStreamer(kvQ, dban.CorruptCursorCallback, nil).FormList()
+		assert.Error(t, err)
+	})
+
+	t.Run("recovered concurrently", func(t *testing.T) {
+		kvQ := &racingKeyValueQ{KeyValueQ: dbantest.NewMemoryKeyValueQ()}
+		list, err := newStreamer(kvQ, dban.CorruptCursorResetToZero, nil).FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{3, 4}, list, "the cursor recovered by another instance must win")
+	})
+}
+
+func TestStreamerBatchSizeChange(t *testing.T) {
+	items := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	newStreamer := func(kvQ dban.KeyValueQ, batchSize uint64, policy dban.BatchSizePolicy) dban.Streamer[int] {
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:          dbantest.NewSliceStreamable(items),
+			KeyValueQ:       kvQ,
+			KeyValueKey:     cursorKey,
+			BatchSize:       &batchSize,
+			BatchSizePolicy: &policy,
+		})
+	}
+
+	for _, tc := range []struct {
+		name          string
+		from, to      uint64
+		page          uint64
+		expectedPage  uint64
+		expectedFirst int
+	}{
+		{name: "unchanged", from: 2, to: 2, page: 3, expectedPage: 3, expectedFirst: 6},
+		{name: "shrink", from: 4, to: 2, page: 1, expectedPage: 2, expectedFirst: 4},
+		{name: "grow", from: 2, to: 3, page: 3, expectedPage: 2, expectedFirst: 6},
+		{name: "grow with overlap", from: 2, to: 4, page: 3, expectedPage: 1, expectedFirst: 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			kvQ := dbantest.NewMemoryKeyValueQ()
+			old := newStreamer(kvQ, tc.from, dban.BatchSizeTranslate)
+			for i := uint64(0); i < tc.page; i++ {
+				_, err := old.FormList()
+				require.NoError(t, err)
+			}
+
+			streamer := newStreamer(kvQ, tc.to, dban.BatchSizeTranslate)
+			page, err := streamer.GetCurrentPage()
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedPage, page)
+
+			list, err := streamer.FormList()
+			require.NoError(t, err)
+			require.NotEmpty(t, list)
+			assert.Equal(t, tc.expectedFirst, list[0])
+			assert.LessOrEqual(t, list[0], int(tc.page*tc.from), "items must not be skipped")
+			assert.Equal(t, strconv.FormatUint(tc.to, 10), kvQ.MustGet(cursorKey+":batch_size").Value)
+		})
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		_, err := newStreamer(kvQ, 2, dban.BatchSizeFail).FormList()
+		require.NoError(t, err)
+
+		_, err = newStreamer(kvQ, 3, dban.BatchSizeFail).GetCurrentPage()
+		assert.True(t, dban.Is(err, dban.ErrBatchSizeChanged))
+		assert.Equal(t, "1", kvQ.MustGet(cursorKey).Value, "cursor must be left intact")
+	})
+}
+
+func TestStreamerInvalidKey(t *testing.T) {
+	for _, key := range []string{"", " ", "\t\n", strings.Repeat("k", dban.DefaultMaxKeyLength+1)} {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3}),
+			KeyValueQ:   kvQ,
+			KeyValueKey: key,
+		})
+
+		_, err := streamer.FormList()
+		assert.True(t, dban.Is(err, dban.ErrInvalidKey), "%q", key)
+		_, err = streamer.Select(0)
+		assert.True(t, dban.Is(err, dban.ErrInvalidKey), "%q", key)
+		_, err = dban.GetStrict(kvQ, key)
+		assert.True(t, dban.Is(err, dban.ErrNoSuchKey), "nothing must be written by %q", key)
+	}
+}
+
+// scriptedStreamable returns pages from the script in order, regardless of the page asked for
+type scriptedStreamable struct {
+	script [][]int
+	pages  []uint64
+}
+
+func (s *scriptedStreamable) SelectWithPageParams(params pgdb.OffsetPageParams) ([]int, error) {
+	s.pages = append(s.pages, params.PageNumber)
+	if len(s.script) == 0 {
+		return nil, nil
+	}
+	page := s.script[0]
+	s.script = s.script[1:]
+	return page, nil
+}
+
+// stuckKeyValueQ keeps the cursor at the same page as if another instance moved it back every time
+type stuckKeyValueQ struct {
+	dban.KeyValueQ
+	page string
+}
+
+func (q *stuckKeyValueQ) LockingGet(key string) (*dban.KeyValue, error) {
+	return &dban.KeyValue{Key: key, Value: q.page}, nil
+}
+
+func TestStreamerReset(t *testing.T) {
+	t.Run("wraps around once", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "5"}))
+		stream := &scriptedStreamable{script: [][]int{nil, {1, 2}}}
+
+		list, err := newTestStreamer(stream, kvQ).FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, list)
+		assert.Equal(t, []uint64{5, 0}, stream.pages)
+		assert.Equal(t, "1", kvQ.MustGet(cursorKey).Value)
+	})
+
+	t.Run("empty after reset", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "5"}))
+		stream := &scriptedStreamable{}
+
+		list, err := newTestStreamer(stream, kvQ).FormList()
+		require.NoError(t, err)
+		assert.Empty(t, list)
+		assert.Equal(t, []uint64{5, 0}, stream.pages)
+	})
+
+	t.Run("cursor keeps moving", func(t *testing.T) {
+		kvQ := &stuckKeyValueQ{KeyValueQ: dbantest.NewMemoryKeyValueQ(), page: "5"}
+		stream := &scriptedStreamable{script: [][]int{nil, nil, {1, 2}}}
+
+		_, err := newTestStreamer(stream, kvQ).FormList()
+		assert.True(t, dban.Is(err, dban.ErrInconsistentStream))
+		assert.Equal(t, []uint64{5, 5}, stream.pages, "the cursor must be reset only once")
+	})
+}
+
+func TestStreamerAdvanceFirst(t *testing.T) {
+	mode := dban.CursorAdvanceFirst
+	batchSize := uint64(2)
+	newStreamer := func(stream dban.Streamable[int], kvQ dban.KeyValueQ) dban.Streamer[int] {
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:      stream,
+			KeyValueQ:   kvQ,
+			KeyValueKey: cursorKey,
+			BatchSize:   &batchSize,
+			CursorMode:  &mode,
+		})
+	}
+
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	var recorded []pgdb.OffsetPageParams
+	streamer := newStreamer(dbantest.NewSliceStreamable([]int{1, 2, 3}, dbantest.WithPageParamsRecorder(&recorded)), kvQ)
+	for _, expected := range [][]int{{1, 2}, {3}, {1, 2}} {
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, expected, list)
+	}
+	assert.Equal(t, []uint64{0, 1, 2, 0}, pageNumbers(recorded))
+	assert.Equal(t, "1", kvQ.MustGet(cursorKey).Value)
+
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "1O"}))
+	_, err := streamer.FormList()
+	assert.True(t, dban.Is(err, dban.ErrCorruptCursor))
+
+	// the querier has to be able to advance the cursor
+	_, err = newStreamer(dbantest.NewSliceStreamable([]int{1}), struct{ dban.KeyValueQ }{kvQ}).FormList()
+	assert.Error(t, err)
+}
+
+func TestStreamerAdvanceAfterProcessing(t *testing.T) {
+	batchSize := uint64(5)
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:                 dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5, 6}),
+		KeyValueQ:              kvQ,
+		KeyValueKey:            cursorKey,
+		BatchSize:              &batchSize,
+		AdvanceAfterProcessing: true,
+	})
+	require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "0"}))
+
+	var processed []int
+	failure := errors.New("boom")
+	err := streamer.FormListAndProcess(func(_ context.Context, i int) error {
+		if i == 3 {
+			return failure
+		}
+		processed = append(processed, i)
+		return nil
+	})
+	var streamErr *dban.StreamError
+	require.ErrorAs(t, err, &streamErr)
+	assert.Equal(t, dban.StageProcess, streamErr.Stage)
+	assert.Equal(t, 2, streamErr.EntityIndex)
+	assert.True(t, dban.Is(err, failure))
+	assert.Equal(t, "0", kvQ.MustGet(cursorKey).Value, "the cursor is not expected to move past a failed list")
+
+	// the failed list is formed again
+	require.NoError(t, streamer.FormListAndProcess(func(_ context.Context, i int) error {
+		processed = append(processed, i)
+		return nil
+	}))
+	assert.Equal(t, []int{1, 2, 1, 2, 3, 4, 5}, processed)
+	assert.Equal(t, "1", kvQ.MustGet(cursorKey).Value)
+
+	// the cursor has to be advanced by the streamer itself
+	mode := dban.CursorAdvanceFirst
+	_, err = dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:                 dbantest.NewSliceStreamable([]int{1}),
+		KeyValueQ:              kvQ,
+		KeyValueKey:            cursorKey,
+		CursorMode:             &mode,
+		AdvanceAfterProcessing: true,
+	}).FormList()
+	assert.Error(t, err)
+}
+
+// brokenKeyValueQ fails locking reads and writes with the respective errors
+type brokenKeyValueQ struct {
+	dban.KeyValueQ
+	lockingGetErr, upsertErr error
+}
+
+func (q *brokenKeyValueQ) LockingGet(key string) (*dban.KeyValue, error) {
+	if q.lockingGetErr != nil {
+		return nil, q.lockingGetErr
+	}
+	return q.KeyValueQ.LockingGet(key)
+}
+
+func (q *brokenKeyValueQ) Upsert(kv dban.KeyValue) error {
+	if q.upsertErr != nil {
+		return q.upsertErr
+	}
+	return q.KeyValueQ.Upsert(kv)
+}
+
+func TestStreamerStreamError(t *testing.T) {
+	failure := errors.New("boom")
+	items := []int{1, 2, 3, 4}
+	assertStreamError := func(t *testing.T, err error, expected dban.StreamError) {
+		var streamErr *dban.StreamError
+		require.ErrorAs(t, err, &streamErr)
+		expected.KeyValueKey = cursorKey
+		expected.Err = streamErr.Err
+		assert.Equal(t, expected, *streamErr)
+		assert.True(t, dban.Is(err, failure))
+		assert.Equal(t, streamErr.Fields()["stage"], errors.GetFields(err)["stage"])
+	}
+
+	t.Run("select", func(t *testing.T) {
+		_, err := newTestStreamer(dbantest.NewSliceStreamable(items, dbantest.WithPageError(0, failure)),
+			dbantest.NewMemoryKeyValueQ()).FormList()
+		assertStreamError(t, err, dban.StreamError{Stage: dban.StageSelect, EntityIndex: -1})
+	})
+
+	t.Run("cursor read", func(t *testing.T) {
+		kvQ := &brokenKeyValueQ{KeyValueQ: dbantest.NewMemoryKeyValueQ(), lockingGetErr: failure}
+		_, err := newTestStreamer(dbantest.NewSliceStreamable(items), kvQ).FormList()
+		assertStreamError(t, err, dban.StreamError{Stage: dban.StageCursorRead, EntityIndex: -1})
+	})
+
+	t.Run("cursor write", func(t *testing.T) {
+		kvQ := &brokenKeyValueQ{KeyValueQ: dbantest.NewMemoryKeyValueQ()}
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "1"}))
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey + ":batch_size", Value: "2"}))
+		kvQ.upsertErr = failure
+		_, err := newTestStreamer(dbantest.NewSliceStreamable(items), kvQ).FormList()
+		assertStreamError(t, err, dban.StreamError{Stage: dban.StageCursorWrite, Page: 1, EntityIndex: -1})
+	})
+
+	t.Run("process", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "1"}))
+		err := newTestStreamer(dbantest.NewSliceStreamable(items), kvQ).FormListAndProcess(
+			func(_ context.Context, i int) error {
+				if i == 4 {
+					return failure
+				}
+				return nil
+			})
+		assertStreamError(t, err, dban.StreamError{Stage: dban.StageProcess, Page: 1, EntityIndex: 1})
+		assert.Equal(t, 1, errors.GetFields(err)["entity_index"])
+	})
+}
+
+func TestStreamerCanceled(t *testing.T) {
+	newStreamer := func(ctx context.Context, kvQ dban.KeyValueQ) dban.Streamer[int] {
+		batchSize := uint64(4)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5}),
+			KeyValueQ:   kvQ,
+			KeyValueKey: cursorKey,
+			BatchSize:   &batchSize,
+			Ctx:         &ctx,
+		})
+	}
+
+	t.Run("between entities", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var processed []int
+		err := newStreamer(ctx, dbantest.NewMemoryKeyValueQ()).FormListAndProcess(func(_ context.Context, i int) error {
+			processed = append(processed, i)
+			if i == 2 {
+				cancel()
+			}
+			return nil
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, []int{1, 2}, processed)
+
+		var streamErr *dban.StreamError
+		require.ErrorAs(t, err, &streamErr)
+		assert.Equal(t, dban.StageProcess, streamErr.Stage)
+		assert.Equal(t, 2, streamErr.EntityIndex)
+	})
+
+	t.Run("before queries", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := newStreamer(ctx, kvQ)
+		_, err := streamer.FormList()
+		require.ErrorIs(t, err, context.Canceled)
+
+		err = streamer.FormListAndProcess(func(context.Context, int) error {
+			t.Fatal("no entity is expected to be processed")
+			return nil
+		})
+		require.ErrorIs(t, err, context.Canceled)
+
+		cursor, err := kvQ.Get(cursorKey)
+		require.NoError(t, err)
+		assert.Nil(t, cursor, "the cursor is not expected to be written")
+	})
+}
+
+func TestStreamerCursorDeleted(t *testing.T) {
+	kvQ := dbantest.NewMemoryKeyValueQ()
+	streamer := newTestStreamer(dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5}), kvQ)
+
+	for _, expected := range [][]int{{1, 2}, {3, 4}} {
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, expected, list)
+	}
+
+	require.NoError(t, kvQ.Delete(cursorKey))
+	page, err := streamer.GetCurrentPage()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), page, "a deleted cursor must start again from the first page")
+
+	list, err := streamer.FormList()
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, list)
+}
+
+func TestStreamerStats(t *testing.T) {
+	batchSize := uint64(2)
+	started := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	failure := errors.New("page is broken")
+	streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3, 4}, dbantest.WithPageError(1, failure)),
+		KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+		KeyValueKey: "stats-cursor",
+		BatchSize:   &batchSize,
+		Clock:       &fakeClock{now: started},
+	})
+
+	require.NoError(t, streamer.FormListAndProcess(func(context.Context, int) error { return nil }))
+	stats := streamer.GetStats()
+	assert.Equal(t, int64(1), stats.Batches)
+	assert.Equal(t, int64(2), stats.Processed)
+	assert.Equal(t, int64(0), stats.Errors)
+	assert.True(t, started.Equal(stats.LastBatchAt))
+
+	err := streamer.FormListAndProcess(func(context.Context, int) error { return nil })
+	assert.True(t, dban.Is(err, failure))
+	assert.Equal(t, int64(1), streamer.GetStats().Errors)
+	assert.Equal(t, int64(1), streamer.GetStats().Batches, "failed page must not count as a batch")
+
+	other := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      dbantest.NewSliceStreamable([]int{1, 2}),
+		KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+		KeyValueKey: "stats-cursor",
+		BatchSize:   &batchSize,
+	})
+	assert.Equal(t, dban.StreamerStats{BatchSize: 2}, other.GetStats(), "streamers must not share counters")
+}
+
+func TestNewStreamerChecked(t *testing.T) {
+	zero := uint64(0)
+	valid := dban.StreamerInitParams[int]{
+		Stream:      dbantest.NewSliceStreamable([]int{1}),
+		KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+		KeyValueKey: cursorKey,
+	}
+	streamer, err := dban.NewStreamerChecked(valid)
+	require.NoError(t, err)
+	list, err := streamer.FormList()
+	require.NoError(t, err)
+	assert.Equal(t, []int{1}, list)
+
+	cases := []struct {
+		name   string
+		modify func(p *dban.StreamerInitParams[int])
+		field  string
+	}{
+		{name: "no stream", modify: func(p *dban.StreamerInitParams[int]) { p.Stream = nil }, field: "Stream"},
+		{name: "no querier", modify: func(p *dban.StreamerInitParams[int]) { p.KeyValueQ = nil }, field: "KeyValueQ"},
+		{name: "empty key", modify: func(p *dban.StreamerInitParams[int]) { p.KeyValueKey = "" }, field: "KeyValueKey"},
+		{name: "zero batch size", modify: func(p *dban.StreamerInitParams[int]) { p.BatchSize = &zero }, field: "BatchSize"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			params := valid
+			tc.modify(&params)
+			streamer, err := dban.NewStreamerChecked(params)
+			require.Error(t, err)
+			assert.Nil(t, streamer)
+			assert.Contains(t, err.Error(), tc.field)
+
+			_, err = dban.NewStreamer(params).FormList()
+			assert.Error(t, err, "the streamer must fail instead of streaming")
+		})
+	}
 }
@@ -0,0 +1,34 @@
+package dban
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startBatchSpan opens a span of a batch and returns a copy of the streamer whose Ctx
+// carries it, along with a querier bound to that Ctx, so that queries are made within the span
+func (s *streamer[T]) startBatchSpan() (*streamer[T], trace.Span) {
+	ctx, span := s.Tracer.Start(s.Ctx, "dban.streamer.batch", trace.WithAttributes(spanKey(s.KeyValueKey)))
+	traced := *s
+	traced.Ctx = ctx
+	traced.KeyValueQ = withContext(s.KeyValueQ, ctx)
+	return &traced, span
+}
+
+// process calls fn for the i-th entity of a batch, within a span of its own if the
+// streamer is traced
+func (s *streamer[T]) process(fn func(ctx context.Context, t T) error, i int, entity T) error {
+	ctx := s.Ctx
+	if s.results != nil {
+		ctx = withEntityIndex(ctx, i)
+	}
+	if s.Tracer == nil {
+		return fn(ctx, entity)
+	}
+
+	ctx, span := s.Tracer.Start(ctx, "dban.streamer.entity", trace.WithAttributes(attrIndex.Int(i)))
+	err := fn(ctx, entity)
+	endSpan(span, err)
+	return err
+}
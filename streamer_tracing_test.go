@@ -0,0 +1,116 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestTracer(t *testing.T) (trace.Tracer, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+	return provider.Tracer("dban"), recorder
+}
+
+// spansByName groups the ended spans by their names
+func spansByName(recorder *tracetest.SpanRecorder) map[string][]sdktrace.ReadOnlySpan {
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	return spans
+}
+
+func TestStreamerTracing(t *testing.T) {
+	tracer, recorder := newTestTracer(t)
+	batchSize := uint64(2)
+	streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3}),
+		KeyValueQ:   dban.NewTracedKeyValueQ(dbantest.NewMemoryKeyValueQ(), tracer),
+		KeyValueKey: cursorKey,
+		BatchSize:   &batchSize,
+		Tracer:      tracer,
+	})
+
+	var entitySpans []trace.SpanContext
+	require.NoError(t, streamer.FormListAndProcess(func(ctx context.Context, _ int) error {
+		entitySpans = append(entitySpans, trace.SpanContextFromContext(ctx))
+		return nil
+	}))
+
+	spans := spansByName(recorder)
+	require.Len(t, spans["dban.streamer.batch"], 1)
+	batch := spans["dban.streamer.batch"][0]
+	assert.Equal(t, codes.Unset, batch.Status().Code)
+
+	require.Len(t, spans["dban.streamer.entity"], 2)
+	for i, span := range spans["dban.streamer.entity"] {
+		assert.Equal(t, batch.SpanContext().SpanID(), span.Parent().SpanID())
+		assert.Equal(t, span.SpanContext(), entitySpans[i], "the processing function gets the context of the entity span")
+	}
+
+	kvSpans := append(spans["dban.kv.locking_get"], spans["dban.kv.upsert"]...)
+	require.NotEmpty(t, kvSpans)
+	for _, span := range kvSpans {
+		assert.Equal(t, batch.SpanContext().TraceID(), span.SpanContext().TraceID(), "%s is not in the trace of the batch", span.Name())
+	}
+
+	t.Run("error", func(t *testing.T) {
+		recorder := tracetest.NewSpanRecorder()
+		tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("dban")
+		streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:      dbantest.NewSliceStreamable([]int{1}),
+			KeyValueQ:   dbantest.NewMemoryKeyValueQ(),
+			KeyValueKey: cursorKey,
+			Tracer:      tracer,
+		})
+
+		assert.Error(t, streamer.FormListAndProcess(func(context.Context, int) error {
+			return errors.New("failed to process")
+		}))
+
+		spans := spansByName(recorder)
+		require.Len(t, spans["dban.streamer.entity"], 1)
+		entity := spans["dban.streamer.entity"][0]
+		assert.Equal(t, codes.Error, entity.Status().Code)
+		assert.Equal(t, "failed to process", entity.Status().Description)
+		require.Len(t, entity.Events(), 1, "the error is recorded")
+		require.Len(t, spans["dban.streamer.batch"], 1)
+		assert.Equal(t, codes.Error, spans["dban.streamer.batch"][0].Status().Code)
+	})
+}
+
+func TestTracedKeyValueQ(t *testing.T) {
+	tracer, recorder := newTestTracer(t)
+	kvQ := dban.NewTracedKeyValueQ(dbantest.NewMemoryKeyValueQ(), tracer)
+
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	bound := kvQ.(dban.KeyValueQCtx).WithContext(ctx)
+	long := strings.Repeat("k", 200)
+	require.NoError(t, bound.Upsert(dban.KeyValue{Key: long, Value: "1"}))
+	_, err := bound.GetMany([]string{"a", "b"})
+	require.NoError(t, err)
+	parent.End()
+
+	spans := spansByName(recorder)
+	require.Len(t, spans["dban.kv.upsert"], 1)
+	upsert := spans["dban.kv.upsert"][0]
+	assert.Equal(t, parent.SpanContext().SpanID(), upsert.Parent().SpanID())
+	require.Len(t, upsert.Attributes(), 1)
+	assert.Equal(t, "dban.key", string(upsert.Attributes()[0].Key))
+	assert.Len(t, upsert.Attributes()[0].Value.AsString(), 128, "the key is truncated")
+
+	require.Len(t, spans["dban.kv.get_many"], 1)
+	assert.Equal(t, int64(2), spans["dban.kv.get_many"][0].Attributes()[0].Value.AsInt64())
+}
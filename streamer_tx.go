@@ -0,0 +1,72 @@
+package dban
+
+import (
+	"context"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type txContextKey struct{}
+
+// KeyValueQFromContext returns the querier bound to the transaction of the list processed by
+// FormListAndProcessTx, whose context is passed to the processing function
+func KeyValueQFromContext(ctx context.Context) (KeyValueQ, bool) {
+	q, ok := ctx.Value(txContextKey{}).(KeyValueQ)
+	return q, ok
+}
+
+// DBFromContext returns the database handle bound to the transaction of the list processed by
+// FormListAndProcessTx, so that other queriers of the processing function could join it. It
+// is found for the Postgres querier only (see NewKeyValueQ), not for the decorated ones
+func DBFromContext(ctx context.Context) (*pgdb.DB, bool) {
+	q, ok := ctx.Value(txContextKey{}).(*keyValueQ)
+	if !ok {
+		return nil, false
+	}
+	return q.db, true
+}
+
+// FormListAndProcessTx processes the list while the cursor is locked with LockingGet, so the
+// transaction, and the lock, are held for as long as the whole list is processed: other
+// streamers with the same cursor key wait for it (see WithLockTimeout), and a slow
+// processing function keeps a connection busy in an open transaction. Keep the batch size
+// small enough for the list to be processed well within the timeouts of the database
+func (s *streamer[T]) FormListAndProcessTx(fn func(ctx context.Context, q KeyValueQ, t T) error) (err error) {
+	if s.err != nil {
+		return errors.Wrap(s.err, "invalid streamer")
+	}
+	if s.CursorMode != CursorLockAndUpdate || s.PageConcurrency > 1 {
+		return errors.New("FormListAndProcessTx requires CursorLockAndUpdate")
+	}
+	if s.descending {
+		return errors.New("FormListAndProcessTx is not supported by descending streamers")
+	}
+	if _, ok := s.KeyValueQ.(TransactionalKeyValueQ); !ok {
+		return errors.New("FormListAndProcessTx requires a TransactionalKeyValueQ")
+	}
+	if err = s.checkPaused(); err != nil {
+		return err
+	}
+	failures, err := s.checkBreaker()
+	if err != nil {
+		return err
+	}
+	defer func() { s.recordBreaker(failures, err) }()
+	if s.Tracer != nil {
+		var span trace.Span
+		s, span = s.startBatchSpan()
+		defer func() { endSpan(span, err) }()
+	}
+
+	_, _, _, err = s.formAndProcessHeld(func(tx *streamer[T]) listProcessor[T] {
+		return eachEntity(func(ctx context.Context, t T) error {
+			return fn(context.WithValue(ctx, txContextKey{}, tx.KeyValueQ), tx.KeyValueQ, t)
+		})
+	})
+	if s.canceled(err) {
+		err = s.interruptStreaming(err, true)
+	}
+	return s.failed(err)
+}
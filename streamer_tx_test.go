@@ -0,0 +1,112 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+// txKeyValueQ applies the writes made within a transaction to the underlying querier
+// once the transaction is committed
+type txKeyValueQ struct {
+	dban.KeyValueQ
+	// pending are the writes of the transaction, nil outside of one
+	pending map[string]string
+}
+
+func (q *txKeyValueQ) Transaction(fn func(q dban.KeyValueQ) error) error {
+	if q.pending != nil {
+		return fn(q)
+	}
+
+	tx := &txKeyValueQ{KeyValueQ: q.KeyValueQ, pending: map[string]string{}}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for key, value := range tx.pending {
+		if err := q.KeyValueQ.Upsert(dban.KeyValue{Key: key, Value: value}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *txKeyValueQ) WithinTx() bool {
+	return q.pending != nil
+}
+
+func (q *txKeyValueQ) Get(key string) (*dban.KeyValue, error) {
+	if value, ok := q.pending[key]; ok {
+		return &dban.KeyValue{Key: key, Value: value}, nil
+	}
+	return q.KeyValueQ.Get(key)
+}
+
+func (q *txKeyValueQ) LockingGet(key string) (*dban.KeyValue, error) {
+	return q.Get(key)
+}
+
+func (q *txKeyValueQ) Upsert(kv dban.KeyValue) error {
+	if q.pending != nil {
+		q.pending[kv.Key] = kv.Value
+		return nil
+	}
+	return q.KeyValueQ.Upsert(kv)
+}
+
+func TestStreamerFormListAndProcessTx(t *testing.T) {
+	memory := dbantest.NewMemoryKeyValueQ()
+	batchSize := uint64(5)
+	streamer := dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5, 6}),
+		KeyValueQ:   &txKeyValueQ{KeyValueQ: memory},
+		KeyValueKey: cursorKey,
+		BatchSize:   &batchSize,
+	})
+	require.NoError(t, memory.Upsert(dban.KeyValue{Key: cursorKey, Value: "0"}))
+
+	process := func(failOn int) func(context.Context, dban.KeyValueQ, int) error {
+		return func(ctx context.Context, q dban.KeyValueQ, i int) error {
+			if i == failOn {
+				return errors.New("boom")
+			}
+			fromCtx, ok := dban.KeyValueQFromContext(ctx)
+			require.True(t, ok)
+			require.Same(t, q, fromCtx)
+			require.True(t, q.(dban.TransactionalKeyValueQ).WithinTx())
+			return q.Upsert(dban.KeyValue{Key: "done:" + strconv.Itoa(i), Value: "true"})
+		}
+	}
+
+	err := streamer.FormListAndProcessTx(process(3))
+	var streamErr *dban.StreamError
+	require.ErrorAs(t, err, &streamErr)
+	assert.Equal(t, dban.StageProcess, streamErr.Stage)
+	assert.Equal(t, 2, streamErr.EntityIndex)
+
+	// neither the writes of the processed entities nor the cursor update are committed
+	value, err := memory.Get("done:1")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+	assert.Equal(t, "0", memory.MustGet(cursorKey).Value)
+
+	require.NoError(t, streamer.FormListAndProcessTx(process(0)))
+	for i := 1; i <= 5; i++ {
+		assert.Equal(t, "true", memory.MustGet("done:"+strconv.Itoa(i)).Value)
+	}
+	assert.Equal(t, "1", memory.MustGet(cursorKey).Value)
+
+	// the querier has to support transactions
+	err = dban.NewStreamer(dban.StreamerInitParams[int]{
+		Stream:      dbantest.NewSliceStreamable([]int{1}),
+		KeyValueQ:   memory,
+		KeyValueKey: cursorKey,
+	}).FormListAndProcessTx(process(0))
+	assert.Error(t, err)
+}
@@ -0,0 +1,128 @@
+package dban
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// processListConcurrently does the same thing as processList, but processes up to
+// MaxConcurrency entities at once, so they are processed in no particular order. The first
+// error stopping the list cancels the context of the entities in flight, prevents the rest
+// of them from being processed and is returned once all the workers are done. Errors of
+// the skipped entities are collected in the order of the list
+func (s *streamer[T]) processListConcurrently(
+	fn func(ctx context.Context, t T) error, page uint64, entities []T, completed *BatchCompleted, collected *BatchErrors,
+) error {
+	ctx, cancel := context.WithCancel(s.Ctx)
+	defer cancel()
+	worker := *s
+	worker.Ctx = ctx
+
+	var (
+		mu      sync.Mutex
+		stopErr error
+		wg      sync.WaitGroup
+		// undone is the first of the entities not processed as ctx is done
+		undone = len(entities)
+	)
+	indices := make(chan int)
+	workers := s.MaxConcurrency
+	if uint64(len(entities)) < workers {
+		workers = uint64(len(entities))
+	}
+	for w := uint64(0); w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				if ctx.Err() != nil {
+					mu.Lock()
+					if i < undone {
+						undone = i
+					}
+					mu.Unlock()
+					continue
+				}
+				deadLettered, err, stop := worker.processEntity(func() error {
+					return worker.process(fn, i, entities[i])
+				}, page, i, entities[i])
+
+				mu.Lock()
+				switch {
+				case stop != nil:
+					if !stopped(stop) {
+						completed.Failed++
+					}
+					if stopErr == nil {
+						stopErr = stop
+						cancel()
+					}
+				case err != nil:
+					completed.Failed++
+					if err = s.entityFailed(page, i, err, collected); err != nil && stopErr == nil {
+						stopErr = err
+						cancel()
+					}
+				case deadLettered:
+					s.delivered(entities[i])
+					completed.DeadLettered++
+				default:
+					s.delivered(entities[i])
+					completed.Processed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+dispatch:
+	for i, entity := range entities {
+		if s.redelivered(entity) {
+			continue
+		}
+		if s.filteredOut(entity) {
+			mu.Lock()
+			completed.Filtered++
+			mu.Unlock()
+			continue
+		}
+		if err := s.throttle(ctx); err != nil {
+			mu.Lock()
+			if i < undone {
+				undone = i
+			}
+			if stopErr == nil && ctx.Err() == nil {
+				// the wait would outlast the deadline of Ctx
+				stopErr = s.failEntity(page, i, err)
+			}
+			mu.Unlock()
+			break dispatch
+		}
+		select {
+		case indices <- i:
+		case <-ctx.Done():
+			mu.Lock()
+			if i < undone {
+				undone = i
+			}
+			mu.Unlock()
+			break dispatch
+		}
+	}
+	close(indices)
+	wg.Wait()
+
+	sort.Slice(collected.Errors, func(i, j int) bool {
+		return collected.Errors[i].Page < collected.Errors[j].Page ||
+			(collected.Errors[i].Page == collected.Errors[j].Page && collected.Errors[i].EntityIndex < collected.Errors[j].EntityIndex)
+	})
+	if stopErr != nil {
+		return stopErr
+	}
+	if undone < len(entities) {
+		// Ctx is done, as the list was not stopped by an entity
+		return s.failEntity(page, undone, s.Ctx.Err())
+	}
+	return nil
+}
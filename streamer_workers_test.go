@@ -0,0 +1,118 @@
+package dban_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+)
+
+func TestStreamerMaxConcurrency(t *testing.T) {
+	newStreamer := func(kvQ dban.KeyValueQ, policy dban.EntityErrorPolicy) dban.Streamer[int] {
+		batchSize, concurrency := uint64(6), uint64(3)
+		return dban.NewStreamer(dban.StreamerInitParams[int]{
+			Stream:                 dbantest.NewSliceStreamable([]int{1, 2, 3, 4, 5, 6}),
+			KeyValueQ:              kvQ,
+			KeyValueKey:            cursorKey,
+			BatchSize:              &batchSize,
+			MaxConcurrency:         &concurrency,
+			AdvanceAfterProcessing: true,
+			EntityErrorPolicy:      &policy,
+		})
+	}
+
+	t.Run("bounded", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		var (
+			mu                  sync.Mutex
+			processed           []int
+			inFlight, maxFlight int32
+			// the first entities wait for each other, so that they are known to be in flight at once
+			first = make(chan struct{})
+			calls int32
+		)
+		err := newStreamer(kvQ, dban.EntityErrorFailFast).FormListAndProcess(func(_ context.Context, i int) error {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for max := atomic.LoadInt32(&maxFlight); n > max && !atomic.CompareAndSwapInt32(&maxFlight, max, n); {
+				max = atomic.LoadInt32(&maxFlight)
+			}
+
+			switch atomic.AddInt32(&calls, 1) {
+			case 3:
+				close(first)
+			case 1, 2:
+				select {
+				case <-first:
+				case <-time.After(time.Second):
+					return errors.New("entities are not processed concurrently")
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			processed = append(processed, i)
+			return nil
+		})
+		require.NoError(t, err)
+
+		sort.Ints(processed)
+		assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, processed)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&maxFlight))
+		assert.Equal(t, "1", kvQ.MustGet(cursorKey).Value)
+	})
+
+	t.Run("first error cancels", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: cursorKey, Value: "0"}))
+		failure := errors.New("boom")
+		var calls int32
+		err := newStreamer(kvQ, dban.EntityErrorFailFast).FormListAndProcess(func(ctx context.Context, i int) error {
+			atomic.AddInt32(&calls, 1)
+			if i == 1 {
+				return failure
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+				return nil
+			}
+		})
+
+		var streamErr *dban.StreamError
+		require.ErrorAs(t, err, &streamErr)
+		assert.Equal(t, 0, streamErr.EntityIndex)
+		assert.True(t, dban.Is(err, failure))
+		assert.LessOrEqual(t, atomic.LoadInt32(&calls), int32(3), "no entity is expected to be dispatched after the failure")
+		assert.Equal(t, "0", kvQ.MustGet(cursorKey).Value)
+	})
+
+	t.Run("collect", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		failure := errors.New("boom")
+		err := newStreamer(kvQ, dban.EntityErrorCollect).FormListAndProcess(func(_ context.Context, i int) error {
+			if i%2 == 0 {
+				return failure
+			}
+			return nil
+		})
+
+		var batchErr *dban.BatchErrors
+		require.ErrorAs(t, err, &batchErr)
+		indices := make([]int, len(batchErr.Errors))
+		for i, err := range batchErr.Errors {
+			indices[i] = err.EntityIndex
+		}
+		assert.Equal(t, []int{1, 3, 5}, indices)
+		assert.Equal(t, "1", kvQ.MustGet(cursorKey).Value)
+	})
+}
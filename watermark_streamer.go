@@ -0,0 +1,286 @@
+package dban
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/distributed_lab/kit/pgdb"
+	"gitlab.com/distributed_lab/logan/v3"
+	"gitlab.com/distributed_lab/logan/v3/errors"
+)
+
+// TimeRangeStreamable is an interface that an object must implement in order to be streamed
+// through by the time its entities were updated at. Entities updated within from and to, both
+// inclusive, must be selected in a stable order, e.g. ORDER BY updated_at, id
+type TimeRangeStreamable[T any] interface {
+	SelectUpdatedWithin(from, to time.Time, pageParams pgdb.OffsetPageParams) ([]T, error)
+}
+
+// WatermarkCursor is a cursor of a WatermarkStreamer: the window of the update time being
+// paged through and the page to take next. Until is zero while no window is open
+type WatermarkCursor struct {
+	Watermark time.Time `json:"watermark"`
+	Until     time.Time `json:"until"`
+	Page      uint64    `json:"page"`
+}
+
+// WatermarkStreamer streams through the entities of a TimeRangeStreamable updated since the
+// watermark stored in the key value storage, so that only the entities modified since the
+// last pass are processed instead of the whole stream
+type WatermarkStreamer[T any] interface {
+	// FormList returns the next page of the entities updated within the current window,
+	// opening one from the watermark up to now if none is open. Once the window is
+	// exhausted, the watermark is moved to its upper bound. With a TransactionalKeyValueQ the
+	// cursor is read and updated in one transaction, so concurrent calls get distinct pages
+	FormList() ([]T, error)
+	// FormListAndProcess forms a list according to FormList and applies fn to its entities
+	// one by one, stopping at the first failure
+	FormListAndProcess(fn func(ctx context.Context, t T) error) error
+	// GetCursor returns the stored cursor, the one of StartAt with no window open at the
+	// start of the stream
+	GetCursor() (WatermarkCursor, error)
+	// HealthReporter reports the cursor key as a name and the time a list was last formed
+	HealthReporter
+}
+
+// WatermarkStreamerInitParams are parameters specified when initializing a new watermark
+// streamer
+type WatermarkStreamerInitParams[T any] struct {
+	Stream      TimeRangeStreamable[T]
+	KeyValueQ   KeyValueQ
+	KeyValueKey string
+	BatchSize   *uint64
+	// StartAt is the watermark of the first window, the zero time by default, so that the
+	// first pass processes every entity
+	StartAt time.Time
+	// Lag is subtracted from the current time to get the upper bound of a window, leaving
+	// the entities updated within the last Lag to the next one (see NewWatermarkStreamer)
+	Lag       time.Duration
+	Clock     Clock
+	Log       *logan.Entry
+	Ctx       *context.Context
+	EventSink EventSink
+}
+
+// NewWatermarkStreamer creates a new instance of WatermarkStreamer using
+// WatermarkStreamerInitParams. The cursor is stored as JSON of WatermarkCursor, e.g.
+// {"watermark":"2023-05-01T10:00:00Z","until":"2023-05-01T11:00:00Z","page":3}, so that it
+// could be inspected and fixed by hand. It does not fit into 64 characters, so the value
+// column must be widened the way migration 002_value_text.sql does. The upper bound of a window is captured once the
+// window is opened and stored with the cursor, so that the pages do not shift as entities
+// are updated concurrently, and resuming after a restart pages through the same window.
+// Both bounds are inclusive: the entities updated exactly at the watermark are selected by
+// both windows sharing it, so the stream is processed at least once, and processing must be
+// idempotent. Entities updated while a window is paged through move past its upper bound and
+// are left to the next window, shifting the following ones to the earlier pages, which are
+// not selected again: with frequent updates the window should fit a page or two. Lag covers
+// the clock skew between the instances updating the entities and the streamer, as well as
+// transactions committed after the update time they set: entities updated within the last
+// Lag are left to the next window, so the ones committed late are not missed. Log,
+// BatchSize, Ctx and EventSink could be omitted the same way as with NewStreamer, and Clock
+// is the system one by default
+func NewWatermarkStreamer[T any](initParams WatermarkStreamerInitParams[T]) WatermarkStreamer[T] {
+	var (
+		batchSize       = defaultBatchSize
+		ctx             = context.Background()
+		clock     Clock = systemClock{}
+	)
+
+	if initParams.BatchSize != nil {
+		batchSize = *initParams.BatchSize
+	}
+	if initParams.Ctx != nil {
+		ctx = *initParams.Ctx
+	}
+	if initParams.Clock != nil {
+		clock = initParams.Clock
+	}
+
+	err := ValidateKey(initParams.KeyValueKey)
+	switch {
+	case err != nil:
+	case initParams.Stream == nil:
+		err = errors.New("watermark streamer requires Stream")
+	case initParams.KeyValueQ == nil:
+		err = errors.New("watermark streamer requires KeyValueQ")
+	case batchSize == 0:
+		err = errors.New("BatchSize must be at least 1")
+	case initParams.Lag < 0:
+		err = errors.New("Lag must not be negative")
+	}
+
+	return &watermarkStreamer[T]{
+		Stream:       initParams.Stream,
+		KeyValueQ:    withContext(initParams.KeyValueQ, ctx),
+		KeyValueKey:  initParams.KeyValueKey,
+		BatchSize:    batchSize,
+		StartAt:      initParams.StartAt,
+		Lag:          initParams.Lag,
+		Clock:        clock,
+		Log:          initParams.Log,
+		Ctx:          ctx,
+		EventSink:    asyncEventSink(initParams.EventSink),
+		codec:        JSONCodec[WatermarkCursor](),
+		lastProgress: new(int64),
+		err:          err,
+	}
+}
+
+type watermarkStreamer[T any] struct {
+	Stream      TimeRangeStreamable[T]
+	KeyValueQ   KeyValueQ
+	KeyValueKey string
+	BatchSize   uint64
+	StartAt     time.Time
+	Lag         time.Duration
+	Clock       Clock
+	Log         *logan.Entry
+	Ctx         context.Context
+	EventSink   EventSink
+
+	codec Codec[WatermarkCursor]
+	// err is an error of the construction returned by every method
+	err          error
+	lastProgress *int64
+}
+
+func (s *watermarkStreamer[T]) FormList() ([]T, error) {
+	if s.err != nil {
+		return nil, errors.Wrap(s.err, "invalid streamer")
+	}
+
+	var entities []T
+	err := transaction(s.KeyValueQ, func(q KeyValueQ) error {
+		tx := *s
+		tx.KeyValueQ = q
+		var err error
+		entities, err = tx.formList()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	atomic.StoreInt64(s.lastProgress, time.Now().UnixNano())
+	return entities, nil
+}
+
+func (s *watermarkStreamer[T]) FormListAndProcess(fn func(ctx context.Context, t T) error) error {
+	entities, err := s.FormList()
+	if err != nil {
+		return errors.Wrap(err, "failed to form list")
+	}
+
+	for i, entity := range entities {
+		if err = s.Ctx.Err(); err != nil {
+			return err
+		}
+		if err = fn(s.Ctx, entity); err != nil {
+			return errors.Wrap(err, "failed to process entity", logan.F{"key": s.KeyValueKey, "entity_index": i})
+		}
+	}
+	return nil
+}
+
+func (s *watermarkStreamer[T]) GetCursor() (WatermarkCursor, error) {
+	if s.err != nil {
+		return WatermarkCursor{}, errors.Wrap(s.err, "invalid streamer")
+	}
+	return s.readCursor(s.KeyValueQ.Get)
+}
+
+func (s *watermarkStreamer[T]) Name() string {
+	return s.KeyValueKey
+}
+
+func (s *watermarkStreamer[T]) LastProgress() time.Time {
+	if nanos := atomic.LoadInt64(s.lastProgress); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
+// formList selects the next page of the window, opening one if none is open, and moves the
+// cursor past it. A page that is not full exhausts the window, so the watermark is moved to
+// its upper bound right away instead of selecting an empty page next time
+func (s *watermarkStreamer[T]) formList() ([]T, error) {
+	if err := s.Ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	cursor, err := s.readCursor(s.KeyValueQ.LockingGet)
+	if err != nil {
+		return nil, err
+	}
+	if cursor.Until.IsZero() {
+		cursor.Until = s.Clock.Now().Add(-s.Lag).UTC()
+		cursor.Page = 0
+		if !cursor.Until.After(cursor.Watermark) {
+			// nothing could be updated since the watermark yet
+			s.emit(EndOfStream{Key: s.KeyValueKey})
+			return nil, nil
+		}
+	}
+
+	entities, err := s.Stream.SelectUpdatedWithin(cursor.Watermark, cursor.Until, pgdb.OffsetPageParams{
+		Limit:      s.BatchSize,
+		PageNumber: cursor.Page,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select entities", logan.F{
+			"key":       s.KeyValueKey,
+			"watermark": cursor.Watermark,
+			"until":     cursor.Until,
+			"page":      cursor.Page,
+		})
+	}
+
+	if uint64(len(entities)) < s.BatchSize {
+		s.emit(EndOfStream{Key: s.KeyValueKey})
+		cursor = WatermarkCursor{Watermark: cursor.Until}
+	} else {
+		cursor.Page++
+	}
+	if err = s.writeCursor(cursor); err != nil {
+		return nil, err
+	}
+	return entities, nil
+}
+
+// readCursor reads the cursor with get, returning the one of StartAt at the start of the
+// stream
+func (s *watermarkStreamer[T]) readCursor(get func(key string) (*KeyValue, error)) (WatermarkCursor, error) {
+	stored, err := get(s.KeyValueKey)
+	if err != nil {
+		return WatermarkCursor{}, errors.Wrap(err, "failed to get current cursor value", logan.F{"key": s.KeyValueKey})
+	}
+	if stored == nil {
+		return WatermarkCursor{Watermark: s.StartAt.UTC()}, nil
+	}
+
+	cursor, err := s.codec.Decode(stored.Value)
+	if err != nil {
+		return WatermarkCursor{}, errors.Wrap(&CorruptCursorError{Value: stored.Value, Err: err}, "failed to parse cursor", logan.F{
+			"key":       s.KeyValueKey,
+			"kv_cursor": stored.Value,
+		})
+	}
+	return cursor, nil
+}
+
+func (s *watermarkStreamer[T]) writeCursor(cursor WatermarkCursor) error {
+	value, err := s.codec.Encode(cursor)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode cursor", logan.F{"key": s.KeyValueKey})
+	}
+	if err = s.KeyValueQ.Upsert(KeyValue{Key: s.KeyValueKey, Value: value}); err != nil {
+		return errors.Wrap(err, "failed to update cursor", logan.F{"key": s.KeyValueKey, "cursor": value})
+	}
+	return nil
+}
+
+func (s *watermarkStreamer[T]) emit(event Event) {
+	if s.EventSink != nil {
+		s.EventSink.Emit(event)
+	}
+}
@@ -0,0 +1,140 @@
+package dban_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zspkg/dban"
+	"github.com/zspkg/dban/dbantest"
+	"gitlab.com/distributed_lab/kit/pgdb"
+)
+
+type updatedEntity struct {
+	ID        int
+	UpdatedAt time.Time
+}
+
+// updatedSlice selects the entities updated within the range in the order of the update time
+// and the ID, as they are appended
+type updatedSlice struct {
+	mu       sync.Mutex
+	entities []updatedEntity
+}
+
+func (s *updatedSlice) add(id int, updatedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entities = append(s.entities, updatedEntity{ID: id, UpdatedAt: updatedAt})
+}
+
+func (s *updatedSlice) SelectUpdatedWithin(from, to time.Time, pageParams pgdb.OffsetPageParams) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []int
+	for _, entity := range s.entities {
+		if !entity.UpdatedAt.Before(from) && !entity.UpdatedAt.After(to) {
+			ids = append(ids, entity.ID)
+		}
+	}
+	return dbantest.NewSliceStreamable(ids).SelectWithPageParams(pageParams)
+}
+
+func TestWatermarkStreamer(t *testing.T) {
+	start := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	newStreamer := func(key string, stream dban.TimeRangeStreamable[int], kvQ dban.KeyValueQ, clock dban.Clock) dban.WatermarkStreamer[int] {
+		batchSize := uint64(2)
+		return dban.NewWatermarkStreamer(dban.WatermarkStreamerInitParams[int]{
+			Stream:      stream,
+			KeyValueQ:   kvQ,
+			KeyValueKey: key,
+			BatchSize:   &batchSize,
+			StartAt:     start,
+			Lag:         time.Second,
+			Clock:       clock,
+		})
+	}
+
+	t.Run("windows", func(t *testing.T) {
+		stream := &updatedSlice{}
+		for i := 1; i <= 3; i++ {
+			stream.add(i, start.Add(time.Duration(i)*time.Minute))
+		}
+		clock := &fakeClock{now: start.Add(time.Hour)}
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		streamer := newStreamer("watermark", stream, kvQ, clock)
+
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, list)
+		cursor, err := streamer.GetCursor()
+		require.NoError(t, err)
+		assert.Equal(t, dban.WatermarkCursor{Watermark: start, Until: clock.now.Add(-time.Second), Page: 1}, cursor)
+
+		// updated after the window is opened, so it is left to the next one
+		stream.add(4, start.Add(time.Hour))
+		list, err = streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{3}, list)
+		cursor, err = streamer.GetCursor()
+		require.NoError(t, err)
+		assert.Equal(t, dban.WatermarkCursor{Watermark: start.Add(time.Hour - time.Second)}, cursor)
+
+		list, err = streamer.FormList()
+		require.NoError(t, err)
+		assert.Empty(t, list, "the upper bound of the next window must not pass the lag")
+
+		clock.now = start.Add(2 * time.Hour)
+		list, err = streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{4}, list)
+		assert.Contains(t, kvQ.MustGet("watermark").Value, `"watermark":"2023-05-01T11:59:59Z"`,
+			"the cursor must be readable by an operator")
+	})
+
+	t.Run("inclusive boundary", func(t *testing.T) {
+		stream := &updatedSlice{}
+		clock := &fakeClock{now: start.Add(time.Minute)}
+		boundary := clock.now.Add(-time.Second)
+		stream.add(1, boundary)
+		streamer := newStreamer("watermark-boundary", stream, dbantest.NewMemoryKeyValueQ(), clock)
+
+		list, err := streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{1}, list)
+
+		// updated at the same time, but committed once the window is closed
+		stream.add(2, boundary)
+		clock.now = start.Add(time.Hour)
+		list, err = streamer.FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, list, "entities at the watermark must be selected again")
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		stream := &updatedSlice{}
+		for i := 1; i <= 5; i++ {
+			stream.add(i, start.Add(time.Duration(i)*time.Minute))
+		}
+		clock := &fakeClock{now: start.Add(time.Hour)}
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		_, err := newStreamer("watermark-resume", stream, kvQ, clock).FormList()
+		require.NoError(t, err)
+
+		// a restarted instance must page through the same window despite the time passed
+		clock.now = start.Add(3 * time.Hour)
+		stream.add(6, start.Add(2*time.Hour))
+		list, err := newStreamer("watermark-resume", stream, kvQ, clock).FormList()
+		require.NoError(t, err)
+		assert.Equal(t, []int{3, 4}, list)
+	})
+
+	t.Run("corrupt cursor", func(t *testing.T) {
+		kvQ := dbantest.NewMemoryKeyValueQ()
+		require.NoError(t, kvQ.Upsert(dban.KeyValue{Key: "watermark-corrupt", Value: "3"}))
+		_, err := newStreamer("watermark-corrupt", &updatedSlice{}, kvQ, &fakeClock{now: start}).FormList()
+		assert.True(t, dban.Is(err, dban.ErrCorruptCursor))
+	})
+}